@@ -21,12 +21,14 @@ import (
 	"os"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 	flag "github.com/spf13/pflag"
 	"github.com/tikv/tikv-operator/pkg/client/clientset/versioned"
 	informers "github.com/tikv/tikv-operator/pkg/client/informers/externalversions"
 	"github.com/tikv/tikv-operator/pkg/controller"
 	"github.com/tikv/tikv-operator/pkg/controller/tikvcluster"
+	"github.com/tikv/tikv-operator/pkg/pdapi"
 	"github.com/tikv/tikv-operator/pkg/scheme"
 	"github.com/tikv/tikv-operator/pkg/verflag"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -49,9 +51,12 @@ import (
 
 var (
 	workers            int
+	auditWorkers       int
 	autoFailover       bool
 	pdFailoverPeriod   time.Duration
 	tikvFailoverPeriod time.Duration
+	cacheWarmupTimeout time.Duration
+	strictCacheSync    bool
 	leaseDuration      = 15 * time.Second
 	renewDuration      = 5 * time.Second
 	retryPeriod        = 3 * time.Second
@@ -62,11 +67,26 @@ var (
 // TODO organize via component config/option
 func initFlags(fs *flag.FlagSet) {
 	fs.IntVar(&workers, "workers", 5, "The number of workers that are allowed to sync concurrently. Larger number = more responsive management, but more CPU (and network) load")
+	fs.IntVar(&auditWorkers, "audit-workers", 2, "The number of workers that are allowed to sync clusters without spec changes concurrently, spec changes are always synced ahead of them")
 	fs.BoolVar(&autoFailover, "auto-failover", true, "Auto failover")
 	fs.DurationVar(&pdFailoverPeriod, "pd-failover-period", time.Duration(5*time.Minute), "PD failover period default(5m)")
 	fs.DurationVar(&tikvFailoverPeriod, "tikv-failover-period", time.Duration(5*time.Minute), "TiKV failover period default(5m)")
 	fs.DurationVar(&controller.ResyncDuration, "resync-duration", time.Duration(30*time.Second), "Resync time of informer")
+	fs.DurationVar(&cacheWarmupTimeout, "cache-warmup-timeout", time.Duration(1*time.Minute), "How long to wait for the caches of pods and pvcs before reconciling clusters, decisions relying on them are deferred until they are synced")
+	fs.BoolVar(&strictCacheSync, "strict-cache-sync", false, "Wait for the caches of all informers to be synced before reconciling clusters")
+	fs.BoolVar(&controller.LegacyPromAnnotations, "legacy-prometheus-annotations", false, "Keep the old style <name>.prometheus.io/port annotations of the additional metrics endpoints for scrape configs relying on them")
+	fs.DurationVar(&pdapi.ResponseCacheTTL, "pd-response-cache-ttl", pdapi.ResponseCacheTTL, "How long the stores, members and config read from PD are cached for each cluster, 0 disables the cache, any write to PD invalidates it")
+	fs.DurationVar(&controller.RelistSpreadWindow, "relist-spread-window", controller.RelistSpreadWindow, "How long the clusters re-delivered by a full relist of the informers are spread over before being synced, 0 syncs them at once")
+	fs.DurationVar(&controller.StatusUpdateInterval, "status-update-interval", controller.StatusUpdateInterval, "The minimum interval between the status writes of a cluster that only report the progress of an upgrade or scaling, 0 writes every change at once")
+	fs.DurationVar(&controller.StatusWriteInterval, "status-write-interval", controller.StatusWriteInterval, "The interval the token bucket limiting the status writes of a cluster is refilled at, the Ready condition flipping and a failover starting are written at once, 0 disables the limit")
+	fs.IntVar(&controller.StatusWriteBurst, "status-write-burst", controller.StatusWriteBurst, "The number of status writes of a cluster allowed in a burst")
+	fs.DurationVar(&controller.EventInterval, "event-interval", controller.EventInterval, "The interval the token bucket limiting the events of an object is refilled at, the failover events are recorded at once, 0 disables the limit")
+	fs.IntVar(&controller.EventBurst, "event-burst", controller.EventBurst, "The number of events of an object allowed in a burst")
+	fs.Var(&controller.SharedNodesPortRange, "shared-nodes-port-range", "The range of host ports allocated for the clusters in sharedNodes network mode, e.g. 21000-21999, each cluster takes 4 of them")
+	fs.DurationVar(&controller.PVCTerminatingTimeout, "pvc-terminating-timeout", controller.PVCTerminatingTimeout, "How long a new pod waits for the terminating PVC of the same ordinal to be deleted before the PVC is reported stuck")
+	fs.BoolVar(&controller.RemoveStuckPVCProtection, "remove-stuck-pvc-protection", false, "Remove the pvc-protection finalizer of the PVCs stuck terminating once no pod uses them")
 	fs.StringVar(&controller.PDDiscoveryImage, "pd-discovery-image", "tikv/tikv-operator:latest", "The image of the PD discovery service")
+	fs.StringVar(&controller.DataDirInitImage, "data-dir-init-image", "busybox:1.26.2", "The image of the init container chowning the data directory of the pods running as non-root for the volumes not honoring fsGroup, empty disables the init container")
 }
 
 // Run runs the controller-manager. This should never exit.
@@ -122,28 +142,43 @@ func Run(stopCh <-chan struct{}) error {
 	controllerCtx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	syncStatus := controller.NewInformerSyncStatus()
+
 	onStarted := func(ctx context.Context) {
 		_ = genericCli
-		tcController := tikvcluster.NewController(kubeCli, cli, genericCli, informerFactory, kubeInformerFactory, autoFailover, pdFailoverPeriod, tikvFailoverPeriod)
+		tcController := tikvcluster.NewController(kubeCli, cli, genericCli, pdapi.NewDefaultPDControlWithSecretLister(kubeCli, kubeInformerFactory.Core().V1().Secrets().Lister()), informerFactory, kubeInformerFactory, autoFailover, pdFailoverPeriod, tikvFailoverPeriod, syncStatus)
 
 		// Start informer factories after all controller are initialized.
 		informerFactory.Start(ctx.Done())
 		kubeInformerFactory.Start(ctx.Done())
 
-		// Wait for all started informers' cache were synced.
-		for v, synced := range informerFactory.WaitForCacheSync(wait.NeverStop) {
-			if !synced {
-				klog.Fatalf("error syncing informer for %v", v)
+		if strictCacheSync {
+			// Wait for all started informers' cache were synced.
+			for v, synced := range informerFactory.WaitForCacheSync(wait.NeverStop) {
+				if !synced {
+					klog.Fatalf("error syncing informer for %v", v)
+				}
 			}
-		}
-		for v, synced := range kubeInformerFactory.WaitForCacheSync(wait.NeverStop) {
-			if !synced {
-				klog.Fatalf("error syncing informer for %v", v)
+			for v, synced := range kubeInformerFactory.WaitForCacheSync(wait.NeverStop) {
+				if !synced {
+					klog.Fatalf("error syncing informer for %v", v)
+				}
+			}
+			klog.Infof("cache of informer factories sync successfully")
+		} else {
+			// Only the caches required by all clusters are waited for, decisions relying
+			// on the other caches are deferred until they are synced.
+			if !syncStatus.WaitForCacheSync(wait.NeverStop, 0, controller.RequiredInformers...) {
+				klog.Fatalf("error syncing informers %v", syncStatus.NotSynced())
+			}
+			if syncStatus.WaitForCacheSync(wait.NeverStop, cacheWarmupTimeout) {
+				klog.Infof("cache of informer factories sync successfully")
+			} else {
+				klog.Warningf("caches of informers %v are not synced in %v, start reconciling with them deferred", syncStatus.NotSynced(), cacheWarmupTimeout)
 			}
 		}
-		klog.Infof("cache of informer factories sync successfully")
 
-		wait.Forever(func() { tcController.Run(workers, ctx.Done()) }, waitDuration)
+		wait.Forever(func() { tcController.Run(workers, auditWorkers, ctx.Done()) }, waitDuration)
 	}
 
 	onStopped := func() {
@@ -165,6 +200,18 @@ func Run(stopCh <-chan struct{}) error {
 	}, waitDuration)
 
 	healthz.InstallHandler(http.DefaultServeMux)
+	healthz.InstallPathHandler(http.DefaultServeMux, "/readyz", syncStatus.Checks(
+		controller.TikvClusterInformer,
+		controller.StatefulSetInformer,
+		controller.ServiceInformer,
+		controller.ConfigMapInformer,
+		controller.EndpointsInformer,
+		controller.NodeInformer,
+		controller.PodInformer,
+		controller.PVCInformer,
+		controller.PVInformer,
+	)...)
+	http.Handle("/metrics", promhttp.Handler())
 	klog.Fatal(http.ListenAndServe(":6060", nil))
 	return nil
 }
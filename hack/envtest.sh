@@ -0,0 +1,37 @@
+#!/usr/bin/env bash
+
+# Copyright 2020 TiKV Project Authors.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+#
+# Runs the envtest suites against a local kube-apiserver and etcd with the CRDs
+# in manifests installed. Extra arguments are passed to go test, e.g.
+#
+#   hack/envtest.sh -run TestCreate -v
+#
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+ROOT=$(unset CDPATH && cd $(dirname "${BASH_SOURCE[0]}")/.. && pwd)
+cd $ROOT
+
+source "${ROOT}/hack/lib.sh"
+
+if [ -z "${KUBEBUILDER_ASSETS:-}" ]; then
+    hack::ensure_kubebuilder_assets
+    export KUBEBUILDER_ASSETS=$KUBEBUILDER_ASSETS_DIR
+fi
+
+go test -tags envtest ./test/envtest/... "$@"
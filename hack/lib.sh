@@ -32,6 +32,8 @@ KUBETEST2_VERSION=v0.1.0
 KUBETSTS2_BIN=$OUTPUT_BIN/kubetest2
 AWS_K8S_TESTER_VERSION=v1.1.5
 AWS_K8S_TESTER_BIN=$OUTPUT_BIN/aws-k8s-tester
+KUBEBUILDER_VERSION=${KUBEBUILDER_VERSION:-2.3.1}
+KUBEBUILDER_ASSETS_DIR=$OUTPUT/kubebuilder/bin
 
 test -d "$OUTPUT_BIN" || mkdir -p "$OUTPUT_BIN"
 
@@ -92,6 +94,27 @@ function hack::ensure_kind() {
     chmod +x $KIND_BIN
 }
 
+function hack::verify_kubebuilder_assets() {
+    if test -x "$KUBEBUILDER_ASSETS_DIR/kube-apiserver" && test -x "$KUBEBUILDER_ASSETS_DIR/etcd"; then
+        [[ "$(cat $KUBEBUILDER_ASSETS_DIR/.version 2>/dev/null)" == "$KUBEBUILDER_VERSION" ]]
+        return
+    fi
+    return 1
+}
+
+# hack::ensure_kubebuilder_assets installs the kube-apiserver and etcd binaries
+# the envtest suites run against
+function hack::ensure_kubebuilder_assets() {
+    if hack::verify_kubebuilder_assets; then
+        return 0
+    fi
+    echo "Installing kubebuilder assets v$KUBEBUILDER_VERSION..."
+    local KUBEBUILDER_URL=https://github.com/kubernetes-sigs/kubebuilder/releases/download/v${KUBEBUILDER_VERSION}/kubebuilder_${KUBEBUILDER_VERSION}_${OS}_${ARCH}.tar.gz
+    test -d "$KUBEBUILDER_ASSETS_DIR" || mkdir -p "$KUBEBUILDER_ASSETS_DIR"
+    curl --retry 10 -L -s "$KUBEBUILDER_URL" | tar --strip-components 2 -C $KUBEBUILDER_ASSETS_DIR -zxf - kubebuilder_${KUBEBUILDER_VERSION}_${OS}_${ARCH}/bin
+    echo "$KUBEBUILDER_VERSION" > $KUBEBUILDER_ASSETS_DIR/.version
+}
+
 # hack::version_ge "$v1" "$v2" checks whether "v1" is greater or equal to "v2"
 function hack::version_ge() {
     [ "$(printf '%s\n' "$1" "$2" | sort -V | head -n1)" = "$2" ]
@@ -14,6 +14,7 @@
 package v1alpha1
 
 import (
+	apps "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 )
 
@@ -31,12 +32,16 @@ type ComponentAccessor interface {
 	NodeSelector() map[string]string
 	Annotations() map[string]string
 	Tolerations() []corev1.Toleration
+	TopologySpreadConstraints() []corev1.TopologySpreadConstraint
 	PodSecurityContext() *corev1.PodSecurityContext
+	ContainerSecurityContext() *corev1.SecurityContext
 	SchedulerName() string
 	DnsPolicy() corev1.DNSPolicy
 	ConfigUpdateStrategy() ConfigUpdateStrategy
+	StatefulSetUpdateStrategy() apps.StatefulSetUpdateStrategyType
 	BuildPodSpec() corev1.PodSpec
 	Env() []corev1.EnvVar
+	AdditionalContainers() []corev1.Container
 }
 
 type componentAccessorImpl struct {
@@ -48,7 +53,15 @@ type componentAccessorImpl struct {
 }
 
 func (a *componentAccessorImpl) PodSecurityContext() *corev1.PodSecurityContext {
-	return a.ComponentSpec.PodSecurityContext
+	psc := a.ComponentSpec.PodSecurityContext
+	if psc == nil {
+		psc = a.ClusterSpec.PodSecurityContext
+	}
+	return psc
+}
+
+func (a *componentAccessorImpl) ContainerSecurityContext() *corev1.SecurityContext {
+	return a.ComponentSpec.SecurityContext
 }
 
 func (a *componentAccessorImpl) ImagePullPolicy() corev1.PullPolicy {
@@ -116,14 +129,40 @@ func (a *componentAccessorImpl) Annotations() map[string]string {
 	return anno
 }
 
+// Tolerations returns the cluster-level tolerations followed by the ones of the component, a
+// toleration of the component matching a cluster-level one replaces it, e.g. to change its
+// tolerationSeconds
 func (a *componentAccessorImpl) Tolerations() []corev1.Toleration {
-	tols := a.ComponentSpec.Tolerations
-	if len(tols) == 0 {
-		tols = a.ClusterSpec.Tolerations
+	var tols []corev1.Toleration
+	for _, tol := range a.ClusterSpec.Tolerations {
+		tols = appendToleration(tols, tol)
+	}
+	for _, tol := range a.ComponentSpec.Tolerations {
+		tols = appendToleration(tols, tol)
 	}
 	return tols
 }
 
+func appendToleration(tols []corev1.Toleration, tol corev1.Toleration) []corev1.Toleration {
+	for i := range tols {
+		if tols[i].MatchToleration(&tol) {
+			tols[i] = tol
+			return tols
+		}
+	}
+	return append(tols, tol)
+}
+
+// TopologySpreadConstraints returns the topology spread constraints of the component, or the
+// cluster-level ones if the component has none
+func (a *componentAccessorImpl) TopologySpreadConstraints() []corev1.TopologySpreadConstraint {
+	constraints := a.ComponentSpec.TopologySpreadConstraints
+	if len(constraints) == 0 {
+		constraints = a.ClusterSpec.TopologySpreadConstraints
+	}
+	return constraints
+}
+
 func (a *componentAccessorImpl) DnsPolicy() corev1.DNSPolicy {
 	dnsPolicy := corev1.DNSClusterFirst // same as kubernetes default
 	if a.HostNetwork() {
@@ -145,15 +184,28 @@ func (a *componentAccessorImpl) ConfigUpdateStrategy() ConfigUpdateStrategy {
 	return *strategy
 }
 
+func (a *componentAccessorImpl) StatefulSetUpdateStrategy() apps.StatefulSetUpdateStrategyType {
+	if a.ComponentSpec.StatefulSetUpdateStrategy == "" {
+		return apps.RollingUpdateStatefulSetStrategyType
+	}
+	return a.ComponentSpec.StatefulSetUpdateStrategy
+}
+
+// BuildPodSpec returns the pod spec of the scheduling properties of the component, it shares no
+// memory with the TikvCluster so that the pod spec can be modified
 func (a *componentAccessorImpl) BuildPodSpec() corev1.PodSpec {
+	var tols []corev1.Toleration
+	for _, tol := range a.Tolerations() {
+		tols = append(tols, *tol.DeepCopy())
+	}
 	spec := corev1.PodSpec{
 		SchedulerName:   a.SchedulerName(),
-		Affinity:        a.Affinity(),
+		Affinity:        a.Affinity().DeepCopy(),
 		NodeSelector:    a.NodeSelector(),
 		HostNetwork:     a.HostNetwork(),
 		RestartPolicy:   corev1.RestartPolicyAlways,
-		Tolerations:     a.Tolerations(),
-		SecurityContext: a.PodSecurityContext(),
+		Tolerations:     tols,
+		SecurityContext: a.PodSecurityContext().DeepCopy(),
 	}
 	if a.PriorityClassName() != nil {
 		spec.PriorityClassName = *a.PriorityClassName()
@@ -161,8 +213,22 @@ func (a *componentAccessorImpl) BuildPodSpec() corev1.PodSpec {
 	return spec
 }
 
+// Env returns a copy of the environment variables of the component
 func (a *componentAccessorImpl) Env() []corev1.EnvVar {
-	return a.ComponentSpec.Env
+	var env []corev1.EnvVar
+	for _, e := range a.ComponentSpec.Env {
+		env = append(env, *e.DeepCopy())
+	}
+	return env
+}
+
+// AdditionalContainers returns a copy of the sidecar containers of the component
+func (a *componentAccessorImpl) AdditionalContainers() []corev1.Container {
+	var containers []corev1.Container
+	for _, c := range a.ComponentSpec.AdditionalContainers {
+		containers = append(containers, *c.DeepCopy())
+	}
+	return containers
 }
 
 // BaseTiKVSpec returns the base spec of TiKV servers
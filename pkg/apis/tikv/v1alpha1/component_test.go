@@ -0,0 +1,121 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/pointer"
+)
+
+func TestComponentAccessorAffinity(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	clusterAffinity := &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{}}
+	pdAffinity := &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{}}
+	tc := &TikvCluster{}
+	tc.Spec.Affinity = clusterAffinity
+	tc.Spec.PD.Affinity = pdAffinity
+
+	// the affinity of a component replaces the cluster-level one as a whole
+	g.Expect(tc.BasePDSpec().Affinity()).To(Equal(pdAffinity))
+	g.Expect(tc.BaseTiKVSpec().Affinity()).To(Equal(clusterAffinity))
+}
+
+func TestComponentAccessorNodeSelector(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := &TikvCluster{}
+	tc.Spec.NodeSelector = map[string]string{"zone": "a", "disk": "hdd"}
+	tc.Spec.TiKV.NodeSelector = map[string]string{"disk": "ssd"}
+
+	g.Expect(tc.BaseTiKVSpec().NodeSelector()).To(Equal(map[string]string{"zone": "a", "disk": "ssd"}))
+	g.Expect(tc.BasePDSpec().NodeSelector()).To(Equal(map[string]string{"zone": "a", "disk": "hdd"}))
+}
+
+func TestComponentAccessorTolerations(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dedicated := corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "tikv", Effect: corev1.TaintEffectNoSchedule}
+	unreachable := corev1.Toleration{Key: "node.kubernetes.io/unreachable", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute}
+	storage := corev1.Toleration{Key: "storage", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}
+	unreachableLonger := unreachable
+	unreachableLonger.TolerationSeconds = pointer.Int64Ptr(600)
+
+	tests := []struct {
+		name      string
+		cluster   []corev1.Toleration
+		component []corev1.Toleration
+		expected  []corev1.Toleration
+	}{
+		{
+			name: "not set",
+		},
+		{
+			name:     "cluster-level only",
+			cluster:  []corev1.Toleration{dedicated},
+			expected: []corev1.Toleration{dedicated},
+		},
+		{
+			name:      "component only",
+			component: []corev1.Toleration{storage},
+			expected:  []corev1.Toleration{storage},
+		},
+		{
+			name:      "appended",
+			cluster:   []corev1.Toleration{dedicated, unreachable},
+			component: []corev1.Toleration{storage},
+			expected:  []corev1.Toleration{dedicated, unreachable, storage},
+		},
+		{
+			name:      "deduplicated",
+			cluster:   []corev1.Toleration{dedicated, unreachable},
+			component: []corev1.Toleration{storage, dedicated, storage},
+			expected:  []corev1.Toleration{dedicated, unreachable, storage},
+		},
+		{
+			name:      "replaced by the component",
+			cluster:   []corev1.Toleration{unreachable, dedicated},
+			component: []corev1.Toleration{unreachableLonger},
+			expected:  []corev1.Toleration{unreachableLonger, dedicated},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &TikvCluster{}
+			tc.Spec.Tolerations = tt.cluster
+			tc.Spec.TiKV.Tolerations = tt.component
+			g.Expect(tc.BaseTiKVSpec().Tolerations()).To(Equal(tt.expected))
+			// the specs are not mutated
+			g.Expect(tc.Spec.Tolerations).To(Equal(tt.cluster))
+		})
+	}
+}
+
+func TestComponentAccessorTopologySpreadConstraints(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	zoneSpread := corev1.TopologySpreadConstraint{MaxSkew: 1, TopologyKey: "topology.kubernetes.io/zone", WhenUnsatisfiable: corev1.DoNotSchedule}
+	hostSpread := corev1.TopologySpreadConstraint{MaxSkew: 1, TopologyKey: "kubernetes.io/hostname", WhenUnsatisfiable: corev1.ScheduleAnyway}
+	tc := &TikvCluster{}
+	g.Expect(tc.BaseTiKVSpec().TopologySpreadConstraints()).To(BeEmpty())
+
+	// the constraints of a component replace the cluster-level ones as a whole
+	tc.Spec.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{zoneSpread}
+	tc.Spec.TiKV.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{hostSpread}
+	g.Expect(tc.BasePDSpec().TopologySpreadConstraints()).To(Equal([]corev1.TopologySpreadConstraint{zoneSpread}))
+	g.Expect(tc.BaseTiKVSpec().TopologySpreadConstraints()).To(Equal([]corev1.TopologySpreadConstraint{hostSpread}))
+}
@@ -14,20 +14,28 @@
 package defaulting
 
 import (
+	"fmt"
+
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/utils/pointer"
 )
 
 const (
 	defaultTiKVImage = "pingcap/tikv"
 	defaultPDImage   = "pingcap/pd"
+
+	mebibyte = 1 << 20
 )
 
-func SetTikvClusterDefault(tc *v1alpha1.TikvCluster) {
+// SetTikvClusterDefault sets the defaults of the TikvCluster, it returns the warnings of the
+// values normalized that are probably not what the user intended
+func SetTikvClusterDefault(tc *v1alpha1.TikvCluster) []string {
 	setTikvClusterSpecDefault(tc)
 	setPdSpecDefault(tc)
 	setTikvSpecDefault(tc)
+	return setStorageDefault(tc)
 }
 
 // setTikvClusterSpecDefault is only managed the property under Spec
@@ -58,3 +66,45 @@ func setPdSpecDefault(tc *v1alpha1.TikvCluster) {
 		tc.Spec.PD.MaxFailoverCount = pointer.Int32Ptr(3)
 	}
 }
+
+// setStorageDefault normalizes the storage requests and limits of PD and TiKV to binary units,
+// it returns a warning for each storage written in decimal units
+func setStorageDefault(tc *v1alpha1.TikvCluster) []string {
+	var warnings []string
+	for _, rl := range []struct {
+		path string
+		rl   corev1.ResourceList
+	}{
+		{"spec.pd.requests", tc.Spec.PD.Requests},
+		{"spec.pd.limits", tc.Spec.PD.Limits},
+		{"spec.tikv.requests", tc.Spec.TiKV.Requests},
+		{"spec.tikv.limits", tc.Spec.TiKV.Limits},
+	} {
+		if warning := normalizeStorage(rl.path, rl.rl); warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+	return warnings
+}
+
+func normalizeStorage(path string, rl corev1.ResourceList) string {
+	q, ok := rl[corev1.ResourceStorage]
+	if !ok {
+		return ""
+	}
+	normalized, decimal := NormalizeStorageQuantity(q)
+	rl[corev1.ResourceStorage] = normalized
+	if !decimal {
+		return ""
+	}
+	return fmt.Sprintf("%s.storage %s uses decimal units, which is only %s in binary units", path, q.String(), normalized.String())
+}
+
+// NormalizeStorageQuantity converts the storage quantity to binary units without changing its value,
+// it also reports whether the quantity was written in decimal units, e.g. 100G, which is smaller
+// than the binary units (100Gi) users usually intend.
+func NormalizeStorageQuantity(q resource.Quantity) (resource.Quantity, bool) {
+	v := q.Value()
+	decimal := q.Format != resource.BinarySI && v%mebibyte != 0
+	return *resource.NewQuantity(v, resource.BinarySI), decimal
+}
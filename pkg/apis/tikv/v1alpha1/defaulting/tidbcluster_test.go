@@ -0,0 +1,124 @@
+// Copyright 2019 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package defaulting
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1/fuzzer"
+	listers "github.com/tikv/tikv-operator/pkg/client/listers/tikv/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestNormalizeStorageQuantity(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		quantity        string
+		expected        string
+		expectedDecimal bool
+	}{
+		{quantity: "100Gi", expected: "100Gi", expectedDecimal: false},
+		{quantity: "0.5Ti", expected: "512Gi", expectedDecimal: false},
+		{quantity: "1.5Gi", expected: "1536Mi", expectedDecimal: false},
+		{quantity: "1Ei", expected: "1Ei", expectedDecimal: false},
+		{quantity: "100G", expected: "97656250Ki", expectedDecimal: true},
+		{quantity: "1T", expected: "976562500Ki", expectedDecimal: true},
+		{quantity: "1.5G", expected: "1500000000", expectedDecimal: true},
+		{quantity: "1e9", expected: "1000000000", expectedDecimal: true},
+		{quantity: "1073741824", expected: "1Gi", expectedDecimal: false},
+		{quantity: "0", expected: "0", expectedDecimal: false},
+		{quantity: "1024M", expected: "1000000Ki", expectedDecimal: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.quantity, func(t *testing.T) {
+			q, decimal := NormalizeStorageQuantity(resource.MustParse(tt.quantity))
+			g.Expect(q.String()).To(Equal(tt.expected))
+			g.Expect(q.Format).To(Equal(resource.BinarySI))
+			g.Expect(decimal).To(Equal(tt.expectedDecimal))
+			// normalization must never change the value
+			g.Expect(q.Cmp(resource.MustParse(tt.quantity))).To(Equal(0))
+		})
+	}
+}
+
+func TestSetStorageDefault(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := &v1alpha1.TikvCluster{}
+	tc.Spec.PD.Requests = corev1.ResourceList{
+		corev1.ResourceStorage: resource.MustParse("1G"),
+		corev1.ResourceCPU:     resource.MustParse("1"),
+	}
+	tc.Spec.TiKV.Requests = corev1.ResourceList{
+		corev1.ResourceStorage: resource.MustParse("0.5Ti"),
+	}
+	tc.Spec.TiKV.Limits = corev1.ResourceList{
+		corev1.ResourceStorage: resource.MustParse("100G"),
+	}
+
+	warnings := setStorageDefault(tc)
+	g.Expect(warnings).To(Equal([]string{
+		"spec.pd.requests.storage 1G uses decimal units, which is only 1000000000 in binary units",
+		"spec.tikv.limits.storage 100G uses decimal units, which is only 97656250Ki in binary units",
+	}))
+
+	pdStorage := tc.Spec.PD.Requests[corev1.ResourceStorage]
+	g.Expect(pdStorage.String()).To(Equal("1000000000"))
+	pdCPU := tc.Spec.PD.Requests[corev1.ResourceCPU]
+	g.Expect(pdCPU.String()).To(Equal("1"))
+	tikvStorage := tc.Spec.TiKV.Requests[corev1.ResourceStorage]
+	g.Expect(tikvStorage.String()).To(Equal("512Gi"))
+	tikvLimit := tc.Spec.TiKV.Limits[corev1.ResourceStorage]
+	g.Expect(tikvLimit.String()).To(Equal("97656250Ki"))
+	g.Expect(tc.Spec.PD.Limits).To(BeNil())
+}
+
+func TestSetTikvClusterDefaultFuzz(t *testing.T) {
+	g := NewGomegaWithT(t)
+	seed := time.Now().UnixNano()
+	t.Logf("seed: %d", seed)
+	f := fuzzer.New(seed)
+	for i := 0; i < 50; i++ {
+		cached := &v1alpha1.TikvCluster{}
+		f.Fuzz(cached)
+		cached.Namespace, cached.Name = "default", "test"
+		indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		g.Expect(indexer.Add(cached)).To(Succeed())
+		before, err := json.Marshal(cached)
+		g.Expect(err).NotTo(HaveOccurred())
+
+		tc, err := listers.NewTikvClusterLister(indexer).TikvClusters("default").Get("test")
+		g.Expect(err).NotTo(HaveOccurred())
+		tc = tc.DeepCopyForMutation()
+		SetTikvClusterDefault(tc)
+
+		// defaulting is idempotent
+		defaulted := tc.DeepCopy()
+		SetTikvClusterDefault(defaulted)
+		g.Expect(apiequality.Semantic.DeepEqual(defaulted, tc)).To(BeTrue())
+
+		// neither defaulting nor any other change of the copy touches the object of the informer cache
+		fuzzer.Mutate(tc)
+		after, err := json.Marshal(cached)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(string(after)).To(Equal(string(before)))
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fuzzer generates random TikvCluster objects and mutates them in place, to test that the
+// API types survive deep copies and JSON round trips.
+package fuzzer
+
+import (
+	"math/rand"
+	"reflect"
+
+	fuzz "github.com/google/gofuzz"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// New returns a fuzzer of the TikvCluster API types seeded with seed, the values of the types
+// whose JSON form is lossy, e.g. the second precision of metav1.Time, are generated in their
+// canonical form
+func New(seed int64) *fuzz.Fuzzer {
+	return fuzz.New().NilChance(.5).NumElements(0, 2).RandSource(rand.NewSource(seed)).Funcs(
+		func(j *metav1.ObjectMeta, c fuzz.Continue) {
+			c.FuzzNoCustom(j)
+			// the managed fields are only written by the apiserver
+			j.ManagedFields = nil
+		},
+		func(t *metav1.Time, c fuzz.Continue) {
+			*t = metav1.Unix(c.Int63n(1<<32), 0)
+		},
+		func(q *resource.Quantity, c fuzz.Continue) {
+			*q = *resource.NewQuantity(c.Int63n(1<<40), resource.DecimalSI)
+		},
+		func(i *intstr.IntOrString, c fuzz.Continue) {
+			if c.RandBool() {
+				*i = intstr.FromInt(int(c.Int31()))
+			} else {
+				*i = intstr.FromString(c.RandString())
+			}
+		},
+	)
+}
+
+// Mutate changes every value reachable from obj in place, writing through its pointers, slices
+// and maps rather than replacing them, so that the memory obj shares with another object shows up
+// as a change of the other object. The structs with unexported fields, e.g. metav1.Time and
+// resource.Quantity, are left untouched.
+func Mutate(obj interface{}) {
+	mutate(reflect.ValueOf(obj))
+}
+
+func mutate(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			mutate(v.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath != "" {
+				return
+			}
+		}
+		for i := 0; i < v.NumField(); i++ {
+			mutate(v.Field(i))
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			mutate(v.Index(i))
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(v.MapIndex(k))
+			mutate(e)
+			v.SetMapIndex(k, e)
+		}
+	}
+	if !v.CanSet() {
+		return
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(v.String() + "-mutated")
+	case reflect.Bool:
+		v.SetBool(!v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(v.Int() + 1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(v.Uint() + 1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(v.Float() + 1)
+	}
+}
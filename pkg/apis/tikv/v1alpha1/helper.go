@@ -16,6 +16,7 @@ package v1alpha1
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/pingcap/advanced-statefulset/client/apis/apps/v1/helper"
@@ -29,6 +30,13 @@ const (
 	defaultTimeZone    = "UTC"
 )
 
+// DeepCopyForMutation returns a copy of the TikvCluster to be modified. The objects taken from
+// listers are shared with the informer cache and all the other readers of it, they must never be
+// modified in place, e.g. by defaulting or by setting the status.
+func (tc *TikvCluster) DeepCopyForMutation() *TikvCluster {
+	return tc.DeepCopy()
+}
+
 func (tc *TikvCluster) PDImage() string {
 	image := tc.Spec.PD.Image
 	baseImage := tc.Spec.PD.BaseImage
@@ -67,12 +75,43 @@ func (tc *TikvCluster) TiKVImage() string {
 	return image
 }
 
+func (tc *TikvCluster) TiKVVersion() string {
+	image := tc.TiKVImage()
+	colonIdx := strings.LastIndexByte(image, ':')
+	if colonIdx >= 0 {
+		return image[colonIdx+1:]
+	}
+
+	return "latest"
+}
+
 func (tc *TikvCluster) GetInstanceName() string {
 	return tc.Name
 }
 
 func (tc *TikvCluster) IsTLSClusterEnabled() bool {
-	return false
+	return tc.Spec.TLSCluster != nil && tc.Spec.TLSCluster.Enabled
+}
+
+// IsCertManagerEnabled returns whether the certificates of the cluster TLS are issued by cert-manager
+func (tc *TikvCluster) IsCertManagerEnabled() bool {
+	return tc.IsTLSClusterEnabled() && tc.Spec.TLSCluster.CertManager != nil
+}
+
+// PDDashboardExposed returns whether the pd dashboard is exposed through its own service
+func (tc *TikvCluster) PDDashboardExposed() bool {
+	return tc.Spec.PD.Dashboard != nil && tc.Spec.PD.Dashboard.Expose
+}
+
+// PDDashboardBackendProtocol returns how the ingress of the pd dashboard connects to pd
+func (tc *TikvCluster) PDDashboardBackendProtocol() PDDashboardBackendProtocol {
+	if dashboard := tc.Spec.PD.Dashboard; dashboard != nil && dashboard.Ingress != nil && dashboard.Ingress.BackendProtocol != "" {
+		return dashboard.Ingress.BackendProtocol
+	}
+	if tc.IsTLSClusterEnabled() {
+		return PDDashboardBackendHTTPS
+	}
+	return PDDashboardBackendHTTP
 }
 
 func (tc *TikvCluster) Timezone() string {
@@ -171,8 +210,34 @@ func (tc *TikvCluster) TiKVStsDesiredOrdinals(excludeFailover bool) sets.Int32 {
 	return helper.GetPodOrdinalsFromReplicasAndDeleteSlots(replicas, tc.getDeleteSlots(label.TiKVLabelVal))
 }
 
+// TiKVStsDeleteSlots returns the delete slots of the tikv statefulset
+func (tc *TikvCluster) TiKVStsDeleteSlots() sets.Int32 {
+	return tc.getDeleteSlots(label.TiKVLabelVal)
+}
+
+// TiKVOfflineStoreOrdinals returns the ordinals of the pods of the stores decommissioned
+// by spec.tikv.offlineStores, they are deleted from the statefulset like delete slots
+func (tc *TikvCluster) TiKVOfflineStoreOrdinals() sets.Int32 {
+	ordinals := sets.NewInt32()
+	for _, store := range tc.Status.TiKV.OfflineStores {
+		i := strings.LastIndex(store.PodName, "-")
+		if i < 0 {
+			continue
+		}
+		ordinal, err := strconv.ParseInt(store.PodName[i+1:], 10, 32)
+		if err != nil {
+			continue
+		}
+		ordinals.Insert(int32(ordinal))
+	}
+	return ordinals
+}
+
 func (tc *TikvCluster) getDeleteSlots(component string) (deleteSlots sets.Int32) {
 	deleteSlots = sets.NewInt32()
+	if component == label.TiKVLabelVal {
+		deleteSlots.Insert(tc.TiKVOfflineStoreOrdinals().List()...)
+	}
 	annotations := tc.GetAnnotations()
 	if annotations == nil {
 		return deleteSlots
@@ -205,6 +270,36 @@ func (tc *TikvCluster) Scheme() string {
 	return "http"
 }
 
+// DefaultClusterPorts are the ports the pods listen on out of sharedNodes network mode
+var DefaultClusterPorts = ClusterPorts{PDClient: 2379, PDPeer: 2380, TiKV: 20160, TiKVStatus: 20180}
+
+// Ports returns the ports the pods of the cluster listen on, the ports allocated for the cluster
+// in sharedNodes network mode or the default ones
+func (tc *TikvCluster) Ports() ClusterPorts {
+	if tc.Spec.NetworkMode == NetworkModeSharedNodes && tc.Status.Ports != nil {
+		return *tc.Status.Ports
+	}
+	return DefaultClusterPorts
+}
+
+// PDServicePort returns the port of the pd service the clients connect to, it can be overridden
+// by .spec.pd.service.port
+func (tc *TikvCluster) PDServicePort() int32 {
+	if svc := tc.Spec.PD.Service; svc != nil && svc.Port != nil {
+		return *svc.Port
+	}
+	return DefaultClusterPorts.PDClient
+}
+
+// DriftPolicy returns what to do when the statefulsets of the cluster are modified bypassing
+// the operator, defaults to Repair
+func (tc *TikvCluster) DriftPolicy() DriftPolicy {
+	if tc.Spec.DriftPolicy == "" {
+		return DriftPolicyRepair
+	}
+	return tc.Spec.DriftPolicy
+}
+
 func (tc *TikvCluster) PDUpgrading() bool {
 	return tc.Status.PD.Phase == UpgradePhase
 }
@@ -213,6 +308,12 @@ func (tc *TikvCluster) TiKVUpgrading() bool {
 	return tc.Status.TiKV.Phase == UpgradePhase
 }
 
+// IsDeleting returns whether the TikvCluster is being deleted, its members are
+// garbage collected then
+func (tc *TikvCluster) IsDeleting() bool {
+	return tc.DeletionTimestamp != nil
+}
+
 func (tc *TikvCluster) PDIsAvailable() bool {
 	lowerLimit := tc.Spec.PD.Replicas/2 + 1
 	if int32(len(tc.Status.PD.Members)) < lowerLimit {
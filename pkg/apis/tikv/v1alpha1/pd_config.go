@@ -133,6 +133,10 @@ type DashboardConfig struct {
 	TiDBKeyPath *string `toml:"tidb-key-path,omitempty" json:"tidb-key-path,omitempty"`
 	// +optional
 	PublicPathPrefix *string `toml:"public-path-prefix,omitempty" json:"public-path-prefix,omitempty"`
+	// InternalProxy makes the PD members not serving the dashboard proxy its requests to the
+	// member serving it
+	// +optional
+	InternalProxy *bool `toml:"internal-proxy,omitempty" json:"internal-proxy,omitempty"`
 }
 
 // PDLogConfig serializes log related config in toml/json.
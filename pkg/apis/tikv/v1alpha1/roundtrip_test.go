@@ -0,0 +1,135 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1/fuzzer"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const fuzzIters = 50
+
+// apiTypes returns the types of the TikvCluster API registered in the scheme, all the other
+// types of the package are reachable from them
+func apiTypes(g *GomegaWithT) map[string]reflect.Type {
+	scheme := runtime.NewScheme()
+	g.Expect(AddToScheme(scheme)).To(Succeed())
+	types := map[string]reflect.Type{}
+	for kind, typ := range scheme.KnownTypes(SchemeGroupVersion) {
+		// the types of metav1 registered along with them, e.g. the options
+		if typ.PkgPath() != reflect.TypeOf(TikvCluster{}).PkgPath() {
+			continue
+		}
+		types[kind] = typ
+	}
+	return types
+}
+
+func TestRoundTripDeepCopy(t *testing.T) {
+	g := NewGomegaWithT(t)
+	seed := time.Now().UnixNano()
+	t.Logf("seed: %d", seed)
+	f := fuzzer.New(seed)
+	for kind, typ := range apiTypes(g) {
+		t.Run(kind, func(t *testing.T) {
+			for i := 0; i < fuzzIters; i++ {
+				obj := reflect.New(typ).Interface().(runtime.Object)
+				f.Fuzz(obj)
+				before, err := json.Marshal(obj)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				copied := obj.DeepCopyObject()
+				g.Expect(apiequality.Semantic.DeepEqual(obj, copied)).To(BeTrue(), "%s is not equal to its deep copy", before)
+
+				// the copy shares no memory with the original
+				fuzzer.Mutate(copied)
+				after, err := json.Marshal(obj)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(string(after)).To(Equal(string(before)), "mutating a deep copy mutated the original")
+			}
+		})
+	}
+}
+
+func TestRoundTripJSON(t *testing.T) {
+	g := NewGomegaWithT(t)
+	seed := time.Now().UnixNano()
+	t.Logf("seed: %d", seed)
+	f := fuzzer.New(seed)
+	for kind, typ := range apiTypes(g) {
+		t.Run(kind, func(t *testing.T) {
+			for i := 0; i < fuzzIters; i++ {
+				obj := reflect.New(typ).Interface().(runtime.Object)
+				f.Fuzz(obj)
+				data, err := json.Marshal(obj)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				decoded := reflect.New(typ).Interface().(runtime.Object)
+				g.Expect(json.Unmarshal(data, decoded)).To(Succeed())
+				g.Expect(apiequality.Semantic.DeepEqual(obj, decoded)).To(BeTrue(), "%s does not survive a JSON round trip", data)
+			}
+		})
+	}
+}
+
+func TestBuildPodSpecSharesNoMemory(t *testing.T) {
+	g := NewGomegaWithT(t)
+	seed := time.Now().UnixNano()
+	t.Logf("seed: %d", seed)
+	f := fuzzer.New(seed)
+	for i := 0; i < fuzzIters; i++ {
+		tc := &TikvCluster{}
+		f.Fuzz(tc)
+		before, err := json.Marshal(tc)
+		g.Expect(err).NotTo(HaveOccurred())
+
+		for _, accessor := range []ComponentAccessor{tc.BasePDSpec(), tc.BaseTiKVSpec()} {
+			podSpec := accessor.BuildPodSpec()
+			fuzzer.Mutate(&podSpec)
+			env := accessor.Env()
+			fuzzer.Mutate(env)
+			containers := accessor.AdditionalContainers()
+			fuzzer.Mutate(containers)
+			annotations := accessor.Annotations()
+			fuzzer.Mutate(annotations)
+			nodeSelector := accessor.NodeSelector()
+			fuzzer.Mutate(nodeSelector)
+		}
+		after, err := json.Marshal(tc)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(string(after)).To(Equal(string(before)), "mutating the pod spec mutated the tikv cluster")
+	}
+}
+
+func TestDeepCopyForMutation(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := &TikvCluster{}
+	tc.Spec.Tolerations = []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpExists}}
+	tc.Spec.TiKV.NodeSelector = map[string]string{"disk": "ssd"}
+
+	copied := tc.DeepCopyForMutation()
+	copied.Spec.Tolerations[0].Key = "storage"
+	copied.Spec.TiKV.NodeSelector["disk"] = "hdd"
+	g.Expect(tc.Spec.Tolerations[0].Key).To(Equal("dedicated"))
+	g.Expect(tc.Spec.TiKV.NodeSelector).To(Equal(map[string]string{"disk": "ssd"}))
+}
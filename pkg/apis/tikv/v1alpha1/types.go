@@ -66,6 +66,30 @@ const (
 	ConfigUpdateStrategyRollingUpdate ConfigUpdateStrategy = "RollingUpdate"
 )
 
+// NetworkMode represents how the ports of the pods of a cluster are chosen
+type NetworkMode string
+
+const (
+	// NetworkModeDefault listens on the default ports of the components
+	NetworkModeDefault NetworkMode = ""
+	// NetworkModeSharedNodes listens on ports allocated for each cluster by the operator, so that
+	// the pods of several clusters sharing the nodes with hostNetwork do not collide
+	NetworkModeSharedNodes NetworkMode = "sharedNodes"
+)
+
+// DriftPolicy represents what the operator does when the live statefulset of a component has
+// been modified bypassing the operator
+type DriftPolicy string
+
+const (
+	// DriftPolicyRepair reverts the modified statefulset to the rendering of the operator once,
+	// the drift is reported if it persists after that
+	DriftPolicyRepair DriftPolicy = "Repair"
+	// DriftPolicyReport reports the drift and leaves the modified statefulset untouched for
+	// inspection, the statefulset is not updated until the drift is resolved
+	DriftPolicyReport DriftPolicy = "Report"
+)
+
 // +genclient
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
@@ -109,7 +133,7 @@ type TikvClusterSpec struct {
 	TiKV TiKVSpec `json:"tikv"`
 
 	// Indicates that the tikv cluster is paused and will not be processed by
-	// the controller.
+	// the controller, its status is still synced.
 	// +optional
 	Paused bool `json:"paused,omitempty"`
 
@@ -138,11 +162,47 @@ type TikvClusterSpec struct {
 	// +optional
 	HostNetwork *bool `json:"hostNetwork,omitempty"`
 
+	// NetworkMode determines the ports the pods listen on, sharedNodes allocates them for the
+	// cluster from the range of the operator, see status.ports. It can not be changed once set.
+	// +kubebuilder:validation:Enum=sharedNodes
+	// +optional
+	NetworkMode NetworkMode `json:"networkMode,omitempty"`
+
+	// Ports pins the ports of the cluster in sharedNodes network mode instead of allocating them,
+	// they must be distinct, in the range of the ports of the operator for the sharedNodes
+	// network mode, and not conflict with the ports of the other clusters in that mode
+	// +optional
+	Ports *ClusterPorts `json:"ports,omitempty"`
+
+	// DriftPolicy determines what happens when the statefulset of a component is modified
+	// bypassing the operator, see status.<component>.renderedSpecHash
+	// Optional: Defaults to Repair
+	// +kubebuilder:validation:Enum=Repair,Report
+	// +optional
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+
+	// CloneFrom copies the spec of another TikvCluster in the same namespace when the cluster is
+	// created, the fields set to non-zero values in the spec of this cluster override the ones
+	// copied. The instance-specific fields of the source, e.g. the ports and the stores offline,
+	// are not copied. It can not be changed once set, see status.clone.
+	// +optional
+	CloneFrom *TikvClusterRef `json:"cloneFrom,omitempty"`
+
+	// Whether enable the TLS connection between the components of the cluster
+	// Optional: Defaults to nil
+	// +optional
+	TLSCluster *TLSCluster `json:"tlsCluster,omitempty"`
+
+	// Monitoring of the cluster by Prometheus
+	// +optional
+	Monitoring *MonitoringSpec `json:"monitoring,omitempty"`
+
 	// Affinity of TiDB cluster Pods
 	// +optional
 	Affinity *corev1.Affinity `json:"affinity,omitempty"`
 
-	// PriorityClassName of TiDB cluster Pods
+	// PriorityClassName of TiDB cluster Pods, including the discovery, components may override
+	// it respectively. Changing it rolls the pods like any other change of the pod template.
 	// Optional: Defaults to omitted
 	// +optional
 	PriorityClassName *string `json:"priorityClassName,omitempty"`
@@ -159,22 +219,173 @@ type TikvClusterSpec struct {
 	// +optional
 	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
 
+	// Base topology spread constraints of TiDB cluster Pods, components may override them respectively.
+	// An empty labelSelector is filled with the labels selecting the pods of each component
+	// +optional
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+
+	// Base pod security context of TiDB cluster Pods, components may override it respectively.
+	// When runAsNonRoot or fsGroup is set, the data directory is chowned by an init container
+	// running as root, as the volumes of some CSI drivers do not honor fsGroup. Changing it
+	// rolls the pods like any other change of the pod template.
+	// +optional
+	PodSecurityContext *corev1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+
 	// Time zone of TiDB cluster Pods
 	// Optional: Defaults to UTC
 	// +optional
 	Timezone string `json:"timezone,omitempty"`
 }
 
+// TLSCluster can enable TLS connection between the components of the cluster
+type TLSCluster struct {
+	// Enable mutual TLS authentication among the components of the cluster, the certificates of
+	// each component are read from the secret named "<clusterName>-<componentName>-cluster-secret",
+	// and the certificate the operator connects to pd with from "<clusterName>-cluster-client-secret".
+	// It can only be enabled when the cluster is created.
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// CertManager has the certificates of the secrets above issued by cert-manager instead of
+	// created manually, the statefulsets are created once the secrets are issued. The secrets
+	// are renewed in place by cert-manager and the pods see the renewed certificates without
+	// being restarted.
+	// +optional
+	CertManager *CertManagerTLS `json:"certManager,omitempty"`
+}
+
+// MonitoringSpec describes the monitoring of the cluster by Prometheus
+type MonitoringSpec struct {
+	// ExportScrapeTLS copies the client certificate of the cluster TLS to the namespace of
+	// Prometheus, so that it can scrape the status ports of the members
+	// +optional
+	ExportScrapeTLS *ExportScrapeTLS `json:"exportScrapeTLS,omitempty"`
+}
+
+// ExportScrapeTLS copies the secret "<clusterName>-cluster-client-secret" to the secret
+// "<clusterNamespace>-<clusterName>-scrape-tls" of the namespace, it is refreshed when the
+// certificate is rotated and deleted along with the cluster. The keys ca.crt, tls.crt and
+// tls.key of the copy are meant for the tlsConfig of a ServiceMonitor or a PodMonitor. It has
+// no effect unless the cluster TLS is enabled.
+type ExportScrapeTLS struct {
+	// Namespace the secret is copied to, e.g. the namespace of Prometheus
+	Namespace string `json:"namespace"`
+}
+
+// CertManagerTLS has the certificates of the cluster issued by cert-manager
+type CertManagerTLS struct {
+	// IssuerRef is the cert-manager Issuer or ClusterIssuer issuing the certificates
+	IssuerRef CertManagerIssuerRef `json:"issuerRef"`
+}
+
+// CertManagerIssuerRef references a cert-manager Issuer or ClusterIssuer
+type CertManagerIssuerRef struct {
+	// Name of the issuer
+	Name string `json:"name"`
+	// Kind of the issuer, Issuer or ClusterIssuer
+	// Optional: Defaults to Issuer
+	// +kubebuilder:validation:Enum=Issuer,ClusterIssuer
+	// +optional
+	Kind string `json:"kind,omitempty"`
+	// Group of the issuer
+	// Optional: Defaults to cert-manager.io
+	// +optional
+	Group string `json:"group,omitempty"`
+}
+
 // TikvClusterStatus represents the current status of a tikv cluster.
 type TikvClusterStatus struct {
 	ClusterID string     `json:"clusterID,omitempty"`
 	PD        PDStatus   `json:"pd,omitempty"`
 	TiKV      TiKVStatus `json:"tikv,omitempty"`
+	// Ports are the ports the pods listen on in sharedNodes network mode
+	// +optional
+	Ports *ClusterPorts `json:"ports,omitempty"`
+	// Progress is the progress of the ongoing upgrade or scaling, e.g. "upgrading tikv 14/30",
+	// empty if there is none
+	// +optional
+	Progress string `json:"progress,omitempty"`
+	// ForceSync is the value of the tikv.org/force-sync annotation handled last
+	// +optional
+	ForceSync string `json:"forceSync,omitempty"`
+	// UpgradeStatus is the durations measured during the latest upgrades of the components
+	// +optional
+	UpgradeStatus *UpgradeStatus `json:"upgradeStatus,omitempty"`
+	// Clone records the cluster the spec is cloned from, see spec.cloneFrom
+	// +optional
+	Clone *CloneStatus `json:"clone,omitempty"`
 	// Represents the latest available observations of a tikv cluster's state.
 	// +optional
 	Conditions []TikvClusterCondition `json:"conditions,omitempty"`
 }
 
+// TikvClusterRef references a TikvCluster in the namespace of the referrer
+type TikvClusterRef struct {
+	Name string `json:"name"`
+}
+
+// CloneStatus records the cluster the spec is cloned from for traceability
+type CloneStatus struct {
+	// Source is the name of the cluster cloned
+	Source string `json:"source"`
+	// SpecHash is the hash of the spec of the source cluster when it was cloned, the
+	// instance-specific fields excluded
+	SpecHash string `json:"specHash"`
+	// CloneTime is the time the spec was cloned
+	CloneTime metav1.Time `json:"cloneTime"`
+}
+
+// ClusterPorts are the ports the pods of a cluster listen on
+type ClusterPorts struct {
+	PDClient   int32 `json:"pdClient"`
+	PDPeer     int32 `json:"pdPeer"`
+	TiKV       int32 `json:"tikv"`
+	TiKVStatus int32 `json:"tikvStatus"`
+}
+
+// UpgradeStatus is the durations measured during the latest upgrades of the components,
+// which tell where the time of a rollout goes for planning maintenance windows
+type UpgradeStatus struct {
+	PD   *ComponentUpgradeStatus `json:"pd,omitempty"`
+	TiKV *ComponentUpgradeStatus `json:"tikv,omitempty"`
+}
+
+// ComponentUpgradeStatus is the durations measured during the latest upgrade of a component
+type ComponentUpgradeStatus struct {
+	// Revision is the statefulset revision the component is upgraded to
+	Revision string `json:"revision"`
+	// Pods are the durations measured for the upgraded pods
+	// +optional
+	Pods map[string]PodUpgradeDurations `json:"pods,omitempty"`
+	// +optional
+	ImagePull *UpgradeDurationSummary `json:"imagePull,omitempty"`
+	// +optional
+	Startup *UpgradeDurationSummary `json:"startup,omitempty"`
+	// +optional
+	LeaderEviction *UpgradeDurationSummary `json:"leaderEviction,omitempty"`
+}
+
+// PodUpgradeDurations are the durations measured for a pod during an upgrade
+type PodUpgradeDurations struct {
+	// ImagePull is from the pod being scheduled to its container being started, which is
+	// mostly spent pulling the image
+	// +optional
+	ImagePull *metav1.Duration `json:"imagePull,omitempty"`
+	// Startup is from the container being started to the pd member being healthy or the
+	// tikv store being Up
+	// +optional
+	Startup *metav1.Duration `json:"startup,omitempty"`
+	// LeaderEviction is how long the leaders of the tikv store took to be evicted
+	// +optional
+	LeaderEviction *metav1.Duration `json:"leaderEviction,omitempty"`
+}
+
+// UpgradeDurationSummary is the percentiles of a duration measured for the pods of an upgrade
+type UpgradeDurationSummary struct {
+	P50 metav1.Duration `json:"p50"`
+	P95 metav1.Duration `json:"p95"`
+}
+
 // TikvClusterCondition describes the state of a tikv cluster at a certain point.
 type TikvClusterCondition struct {
 	// Type of the condition.
@@ -194,7 +405,9 @@ type TikvClusterCondition struct {
 	Message string `json:"message,omitempty"`
 }
 
-// TikvClusterConditionType represents a tikv cluster condition value.
+// TikvClusterConditionType represents a tikv cluster condition value. Besides the types below, the
+// failures of the managers syncing the cluster are reported by the conditions <Manager>Synced, e.g.
+// PDSynced, which are only added once the manager failed.
 type TikvClusterConditionType string
 
 const (
@@ -206,12 +419,42 @@ const (
 	// - All TiKV stores are up.
 	// - All TiFlash stores are up.
 	TikvClusterReady TikvClusterConditionType = "Ready"
+	// TiKVScaleInBlocked indicates that scaling in tikv is refused because the
+	// remaining stores would be fewer than the max-replicas of pd.
+	TiKVScaleInBlocked TikvClusterConditionType = "TiKVScaleInBlocked"
+	// ConfigRefNotFound indicates that the ConfigMap key referenced by
+	// spec.tikv.configRef does not exist.
+	ConfigRefNotFound TikvClusterConditionType = "ConfigRefNotFound"
+	// FailoverLimitReached indicates that automatic failover refused to handle a
+	// failure because the maxFailoverCount of pd or tikv has been reached.
+	FailoverLimitReached TikvClusterConditionType = "FailoverLimitReached"
+	// ZoneOutageSuspected indicates that too many tikv stores of a zone are down at the same
+	// time, their failover is suspended until spec.tikv.failover.zoneFailoverDeadline.
+	ZoneOutageSuspected TikvClusterConditionType = "ZoneOutageSuspected"
+	// UpgradeStalled indicates that the tikv pod upgraded last has not become
+	// ready within spec.tikv.upgradeStallTimeout.
+	UpgradeStalled TikvClusterConditionType = "UpgradeStalled"
+	// PVCStuckTerminating indicates that the PVC of a pod to be created has been
+	// terminating for longer than the operator waits for it.
+	PVCStuckTerminating TikvClusterConditionType = "PVCStuckTerminating"
+	// DriftDetected indicates that the statefulset of a component has been modified
+	// bypassing the operator, see spec.driftPolicy.
+	DriftDetected TikvClusterConditionType = "DriftDetected"
+	// TLSSecretInvalid indicates that the certificate of the cluster TLS in the secret of
+	// pd or tikv can not be used by the members, they are not rolled out until it is fixed.
+	TLSSecretInvalid TikvClusterConditionType = "TLSSecretInvalid"
 )
 
 // +k8s:openapi-gen=true
 // DiscoverySpec contains details of Discovery members
 type DiscoverySpec struct {
 	corev1.ResourceRequirements `json:",inline"`
+
+	// ServiceAccountName is the service account the discovery runs as. By default a service
+	// account bound to a role that can only read the cluster is created along with the
+	// discovery, set it to run the discovery as a service account managed by users instead
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
 }
 
 // +k8s:openapi-gen=true
@@ -254,6 +497,72 @@ type PDSpec struct {
 	// which used by Dashboard.
 	// +optional
 	TLSClientSecretName *string `json:"tlsClientSecretName,omitempty"`
+
+	// Dashboard configures exposing the dashboard built in PD
+	// +optional
+	Dashboard *PDDashboardSpec `json:"dashboard,omitempty"`
+}
+
+// +k8s:openapi-gen=true
+// PDDashboardSpec configures exposing the dashboard built in PD
+type PDDashboardSpec struct {
+	// Expose creates a Service dedicated to the dashboard, which exposes the client port of PD
+	// the dashboard is served on only, and sets dashboard.internal-proxy in the PD config so
+	// that any PD member behind the Service serves the dashboard. The dashboard keeps requiring
+	// its users to sign in. Disabling it deletes the Service and the Ingress.
+	// Requires .spec.pd.config to be set.
+	// +optional
+	Expose bool `json:"expose,omitempty"`
+
+	// Service defines the Service of the dashboard.
+	// Optional: Defaults to a ClusterIP Service
+	// +optional
+	Service *ServiceSpec `json:"service,omitempty"`
+
+	// Ingress renders an Ingress routing the /dashboard path to the dashboard Service.
+	// Removing it deletes the Ingress.
+	// +optional
+	Ingress *PDDashboardIngressSpec `json:"ingress,omitempty"`
+}
+
+// PDDashboardBackendProtocol is how an Ingress connects to the PD dashboard
+type PDDashboardBackendProtocol string
+
+const (
+	// PDDashboardBackendHTTP connects to PD in plain HTTP, for clusters without TLS
+	PDDashboardBackendHTTP PDDashboardBackendProtocol = "HTTP"
+	// PDDashboardBackendHTTPS re-encrypts the traffic to PD
+	PDDashboardBackendHTTPS PDDashboardBackendProtocol = "HTTPS"
+	// PDDashboardBackendPassthrough hands the TLS connections over to PD as is
+	PDDashboardBackendPassthrough PDDashboardBackendProtocol = "Passthrough"
+)
+
+// +k8s:openapi-gen=true
+// PDDashboardIngressSpec is the Ingress of the PD dashboard, the backend protocol is rendered
+// with the annotations of ingress-nginx
+type PDDashboardIngressSpec struct {
+	// ClassName is the class of the Ingress, set as the kubernetes.io/ingress.class annotation
+	// +optional
+	ClassName *string `json:"className,omitempty"`
+
+	// Host is the host the dashboard is served on, required by Passthrough
+	// +optional
+	Host string `json:"host,omitempty"`
+
+	// TLSSecretName is the name of the secret of the certificate the Ingress terminates TLS
+	// with, it is not allowed with Passthrough
+	// +optional
+	TLSSecretName *string `json:"tlsSecretName,omitempty"`
+
+	// BackendProtocol is how the Ingress connects to PD, HTTP, HTTPS or Passthrough. With the
+	// cluster TLS enabled it must be HTTPS or Passthrough, otherwise it must be HTTP.
+	// Optional: Defaults to HTTPS if the cluster TLS is enabled, HTTP otherwise
+	// +optional
+	BackendProtocol PDDashboardBackendProtocol `json:"backendProtocol,omitempty"`
+
+	// Additional annotations of the Ingress
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
 // +k8s:openapi-gen=true
@@ -292,9 +601,302 @@ type TiKVSpec struct {
 	// +optional
 	StorageClassName *string `json:"storageClassName,omitempty"`
 
+	// PVCReclaimPolicy marks the PVCs of TiKV with the tikv.org/pvc-reclaim annotation, retain
+	// or delete, telling the local volume provisioner how to handle the disk backing a PVC once
+	// it is deleted. The PVCs are marked when they are created and the existing ones are marked
+	// on the next sync. Unsetting it leaves the annotations of the PVCs as is.
+	// Optional: Defaults to not marking the PVCs
+	// +kubebuilder:validation:Enum=Retain;Delete
+	// +optional
+	PVCReclaimPolicy corev1.PersistentVolumeReclaimPolicy `json:"pvcReclaimPolicy,omitempty"`
+
 	// Config is the Configuration of tikv-servers
 	// +optional
 	Config *TiKVConfig `json:"config,omitempty"`
+
+	// ConfigRef references the TOML configuration of tikv-servers stored in a ConfigMap
+	// in the namespace of the TikvCluster, it is mutually exclusive with Config.
+	// +optional
+	ConfigRef *ConfigMapKeyRef `json:"configRef,omitempty"`
+
+	// ConfigLayers are TOML fragments merged in order on top of Config or ConfigRef, the later
+	// layers win. Tables are merged key by key recursively, the other values, including arrays
+	// and arrays of tables, are replaced as a whole. The layer each key of the merged config
+	// comes from is recorded in the tikv.org/config-provenance annotation of the ConfigMap.
+	// +optional
+	ConfigLayers []TiKVConfigLayer `json:"configLayers,omitempty"`
+
+	// EvictLeaderTimeout is the timeout to wait for the leaders of a TiKV store to be evicted
+	// before upgrading it, in the format of Go Duration.
+	// Optional: Defaults to 3m
+	// +optional
+	EvictLeaderTimeout *string `json:"evictLeaderTimeout,omitempty"`
+
+	// UpgradeStallTimeout is how long an upgraded TiKV pod may be not ready before the
+	// upgrade is reported as stalled, in the format of Go Duration.
+	// Optional: Defaults to 10m
+	// +optional
+	UpgradeStallTimeout *string `json:"upgradeStallTimeout,omitempty"`
+
+	// ScaleInPVCRetentionPeriod is how long the PVC of a scaled in TiKV member is retained
+	// before being deleted, in the format of Go Duration. Scaling out the member again
+	// within the period recreates the PVC instead of reusing the stale data.
+	// Optional: Defaults to retain the PVC until the member is scaled out again
+	// +optional
+	ScaleInPVCRetentionPeriod *string `json:"scaleInPVCRetentionPeriod,omitempty"`
+
+	// OfflineStores lists the pod names or store IDs of the TiKV stores to decommission,
+	// the stores are set offline in PD and their ordinals are removed from the statefulset
+	// as delete slots once they become tombstone. Removing an entry before the store becomes
+	// tombstone cancels the decommission. Like delete slots, the ordinals are skipped rather
+	// than subtracted from replicas, so decrease replicas as well to shrink the cluster instead
+	// of replacing the stores. Requires the AdvancedStatefulSet feature.
+	// +optional
+	OfflineStores []string `json:"offlineStores,omitempty"`
+
+	// ScaleOut enables the staged scale out of TiKV, stores are added a step at a time
+	// and the next step waits for the stores added to be Up and to receive regions.
+	// Optional: Defaults to add all the stores without waiting
+	// +optional
+	ScaleOut *TiKVScaleOutStrategy `json:"scaleOut,omitempty"`
+
+	// ScaleIn is the strategy of the scale in of TiKV, the pod of a store is only removed once
+	// the store is drained and tombstone.
+	// Optional: Defaults to decommission and remove one store at a time
+	// +optional
+	ScaleIn *TiKVScaleInStrategy `json:"scaleIn,omitempty"`
+
+	// ScalePolicy sets how the pods of the stores scaled in are removed once the stores are
+	// tombstone.
+	// +optional
+	ScalePolicy *TiKVScalePolicy `json:"scalePolicy,omitempty"`
+
+	// StorageMigration moves the TiKV stores to another storage class online, one store at a
+	// time a store is added on the target storage class, its regions are balanced, and a store
+	// on another storage class is decommissioned like the ones listed in offlineStores. Once
+	// all the stores are on the target storage class, storageClassName is set to it and
+	// storageMigration is cleared. Removing it aborts the migration, the store being
+	// decommissioned is kept unless it has become tombstone. Requires the AdvancedStatefulSet
+	// feature.
+	// +optional
+	StorageMigration *TiKVStorageMigration `json:"storageMigration,omitempty"`
+
+	// UpgradePartition limits the rolling upgrade of TiKV to the pods of ordinals greater than or
+	// equal to it, like the partition of StatefulSet. Lowering it resumes the upgrade, raising it
+	// halts the upgrade without reverting the pods already upgraded.
+	// Optional: Defaults to upgrade all the pods
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	UpgradePartition *int32 `json:"upgradePartition,omitempty"`
+
+	// WaitDurationBetweenUpgrades is how long the upgrade waits after an upgraded TiKV pod is
+	// ready and its store is Up before upgrading the next pod, giving PD time to rebalance the
+	// leaders. The force upgrade annotation skips the wait.
+	// Optional: Defaults to upgrade the next pod at once
+	// +optional
+	WaitDurationBetweenUpgrades *metav1.Duration `json:"waitDurationBetweenUpgrades,omitempty"`
+
+	// UpgradeSafetyCheck sets the thresholds of the check made before upgrading each TiKV pod,
+	// the upgrade waits while any store is not Up or too many regions have down or pending
+	// peers. The force upgrade annotation skips the check.
+	// Optional: Defaults to wait for no region to have down or pending peers
+	// +optional
+	UpgradeSafetyCheck *TiKVUpgradeSafetyCheck `json:"upgradeSafetyCheck,omitempty"`
+
+	// Failover sets how the failover of the stores down is dampened when a whole zone is down,
+	// the replacements of the stores of a zone out could not be scheduled and would churn once
+	// the zone is back.
+	// Optional: Defaults to suspend the failover of a zone with more than 30% of its stores down
+	// for an hour
+	// +optional
+	Failover *TiKVFailover `json:"failover,omitempty"`
+
+	// MaxUpgradingPods is the number of TiKV pods the rolling upgrade evicts the leaders from
+	// and restarts together. The pods upgraded together are consecutive ordinals whose stores
+	// are in the same value of the first location label of PD, e.g. the same zone, which holds
+	// at most one replica of each region as long as there are at least max-replicas values of
+	// the label. It is clamped so that at least max-replicas stores are not being upgraded.
+	// Optional: Defaults to 1
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxUpgradingPods *int32 `json:"maxUpgradingPods,omitempty"`
+
+	// Encryption enables the encryption at rest of TiKV with the master key stored in a secret
+	// or in a KMS, the security.encryption config is rendered to point to it. A secret is
+	// mounted read-only into the TiKV pods, updating the keys in it rotates the master key by a
+	// rolling restart when the config update strategy is RollingUpdate. Once enabled, removing
+	// it is refused unless the tikv.org/disable-encryption annotation is set, as the data
+	// encrypted cannot be read without the master key.
+	// +optional
+	Encryption *TiKVEncryption `json:"encryption,omitempty"`
+
+	// AdditionalConfigFiles are extra files stored in the ConfigMap of tikv along with the config
+	// file, e.g. the files read by a plugin, keyed by file name. They are mounted into
+	// /etc/tikv and updating them rolls tikv like the config file. The other keys added to the
+	// ConfigMap by users are kept as is and are not mounted.
+	// +optional
+	AdditionalConfigFiles map[string]string `json:"additionalConfigFiles,omitempty"`
+}
+
+// +k8s:openapi-gen=true
+// TiKVEncryption is the encryption at rest of TiKV
+type TiKVEncryption struct {
+	// Method is the method to encrypt the data files.
+	// Possible values are "aes128-ctr", "aes192-ctr" and "aes256-ctr".
+	// Optional: Defaults to aes256-ctr
+	// +optional
+	Method string `json:"method,omitempty"`
+
+	// SecretRef references the secret in the namespace of the TikvCluster holding the master key
+	// in hex form in the key master-key. To rotate the master key, move the current key to the
+	// key previous-master-key and put the new key in master-key. It is mutually exclusive with KMS.
+	// +optional
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+
+	// KMS keeps the master key in AWS KMS or a KMS compatible service, it is mutually exclusive
+	// with SecretRef.
+	// +optional
+	KMS *TiKVEncryptionKMS `json:"kms,omitempty"`
+}
+
+// +k8s:openapi-gen=true
+// TiKVEncryptionKMS is the KMS key the master key of the encryption at rest of TiKV is kept in
+type TiKVEncryptionKMS struct {
+	// KeyID is the id of the KMS key
+	KeyID string `json:"keyID"`
+
+	// Region is the region of the KMS key
+	Region string `json:"region"`
+
+	// Endpoint is the endpoint of a KMS compatible service, leave it empty for AWS KMS
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// CredentialsSecretRef references the secret in the namespace of the TikvCluster holding the
+	// credentials to access the KMS in the keys access-key-id and secret-access-key, which are
+	// injected as the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY env vars of TiKV.
+	// Optional: Defaults to the credentials found in the environment of TiKV, e.g. IAM roles for
+	// service accounts with .tikv.serviceAccount set to the service account of the role
+	// +optional
+	CredentialsSecretRef *corev1.LocalObjectReference `json:"credentialsSecretRef,omitempty"`
+}
+
+// +k8s:openapi-gen=true
+// TiKVScaleOutStrategy is the strategy of the staged scale out of TiKV
+type TiKVScaleOutStrategy struct {
+	// StepSize is the number of stores added at a time.
+	// Optional: Defaults to 1
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	StepSize *int32 `json:"stepSize,omitempty"`
+
+	// MinRegionCount is the number of regions each store added by a step has to receive
+	// before the next step. A step is also complete once pd has no pending operators,
+	// i.e. regions are balanced.
+	// Optional: Defaults to wait for pd to have no pending operators only
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinRegionCount *int32 `json:"minRegionCount,omitempty"`
+}
+
+// +k8s:openapi-gen=true
+// TiKVScalePolicy sets how the pods of the TiKV stores scaled in are removed
+type TiKVScalePolicy struct {
+	// ConnectionDrainSeconds is how long the pod of a store scaled in is kept running but not
+	// ready once the store is tombstone, before the pod is removed, so that the clients holding
+	// connections to the store re-resolve it. The TiKV pods get a readiness probe failing while
+	// the pod is draining, which replaces the TCP probe of the cluster TLS, so setting it or
+	// unsetting it rolls the TiKV pods. The tikv.org/skip-connection-drain annotation of the
+	// TikvCluster skips the wait.
+	// Optional: Defaults to 0, the pod is removed as soon as the store is tombstone
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	ConnectionDrainSeconds int32 `json:"connectionDrainSeconds,omitempty"`
+}
+
+// TiKVScaleInStrategy is the strategy of the scale in of TiKV
+type TiKVScaleInStrategy struct {
+	// Bulk decommissions all the stores removed by a scale in at once rather than one at a
+	// time, the pods are removed as soon as their stores are tombstone. It does not apply when
+	// the scale in removes delete slots of the AdvancedStatefulSet feature.
+	// Optional: Defaults to false
+	// +optional
+	Bulk bool `json:"bulk,omitempty"`
+}
+
+// +k8s:openapi-gen=true
+// TiKVStorageMigration is the target of the online migration of the TiKV stores between
+// storage classes
+type TiKVStorageMigration struct {
+	// StorageClassName is the storage class the stores are moved to
+	StorageClassName string `json:"storageClassName"`
+}
+
+// +k8s:openapi-gen=true
+// TiKVUpgradeSafetyCheck is the thresholds of the check made before upgrading each TiKV pod
+type TiKVUpgradeSafetyCheck struct {
+	// MaxDownPeerRegions is the number of regions with down peers the upgrade tolerates.
+	// Optional: Defaults to 0
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxDownPeerRegions *int32 `json:"maxDownPeerRegions,omitempty"`
+
+	// MaxPendingPeerRegions is the number of regions with pending peers the upgrade tolerates.
+	// Optional: Defaults to 0
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxPendingPeerRegions *int32 `json:"maxPendingPeerRegions,omitempty"`
+}
+
+// +k8s:openapi-gen=true
+// TiKVFailover is the zone outage awareness of the failover of tikv
+type TiKVFailover struct {
+	// ZoneLabel is the store label holding the zone of a store, the stores without it are
+	// failed over one by one.
+	// Optional: Defaults to zone
+	// +optional
+	ZoneLabel string `json:"zoneLabel,omitempty"`
+
+	// ZoneOutageThreshold is the percentage of the stores of a zone which, once exceeded by the
+	// stores of the zone down at the same time, suspends the failover of the zone. A single store
+	// down is always failed over.
+	// Optional: Defaults to 30
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	ZoneOutageThreshold *int32 `json:"zoneOutageThreshold,omitempty"`
+
+	// ZoneFailoverDeadline is how long the failover of a zone suspected to be out is suspended,
+	// from the time the stores down exceeded the threshold.
+	// Optional: Defaults to 1h
+	// +optional
+	ZoneFailoverDeadline *metav1.Duration `json:"zoneFailoverDeadline,omitempty"`
+}
+
+// +k8s:openapi-gen=true
+// TiKVConfigLayer is a TOML fragment of the configuration of tikv-servers, either inline
+// or stored in a ConfigMap
+type TiKVConfigLayer struct {
+	// Name of the layer, which must be unique
+	Name string `json:"name"`
+
+	// Config is the inline TOML fragment, it is mutually exclusive with ConfigRef
+	// +optional
+	Config string `json:"config,omitempty"`
+
+	// ConfigRef references the TOML fragment stored in a ConfigMap in the namespace of the TikvCluster
+	// +optional
+	ConfigRef *ConfigMapKeyRef `json:"configRef,omitempty"`
+}
+
+// +k8s:openapi-gen=true
+// ConfigMapKeyRef references a key of a ConfigMap in the namespace of the TikvCluster
+type ConfigMapKeyRef struct {
+	// Name of the ConfigMap
+	ConfigMapName string `json:"configMapName"`
+
+	// Key of the ConfigMap data
+	Key string `json:"key"`
 }
 
 // +k8s:openapi-gen=true
@@ -316,6 +918,8 @@ type ComponentSpec struct {
 	ImagePullPolicy *corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
 
 	// Whether Hostnetwork of the component is enabled. Override the cluster-level setting if present
+	// The pods of a component in the host network are never scheduled to the same node, a
+	// required pod anti-affinity on the hostname is added to its affinity.
 	// Optional: Defaults to cluster-level setting
 	// +optional
 	HostNetwork *bool `json:"hostNetwork,omitempty"`
@@ -345,23 +949,58 @@ type ComponentSpec struct {
 	// +optional
 	Annotations map[string]string `json:"annotations,omitempty"`
 
-	// Tolerations of the component. Override the cluster-level tolerations if non-empty
+	// Tolerations of the component. Appended to the cluster-level tolerations, replacing the
+	// cluster-level ones of the same key, operator, value and effect
 	// Optional: Defaults to cluster-level setting
 	// +optional
 	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
 
-	// PodSecurityContext of the component
+	// TopologySpreadConstraints of the pods of the component, e.g. to spread them evenly across
+	// the zones. An empty labelSelector is filled with the labels selecting the pods of the
+	// component. Override the cluster-level topologySpreadConstraints if non-empty.
+	// It requires the EvenPodsSpread feature gate of Kubernetes.
+	// Optional: Defaults to cluster-level setting
+	// +optional
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+
+	// PodSecurityContext of the component. Override the cluster-level podSecurityContext if present
+	// Optional: Defaults to cluster-level setting
 	// +optional
 	PodSecurityContext *corev1.PodSecurityContext `json:"podSecurityContext,omitempty"`
 
+	// SecurityContext of the main container of the component, e.g. to drop all capabilities and
+	// run with a read-only root filesystem in restricted namespaces. The init containers run with
+	// its read-only root filesystem, privilege escalation and capabilities dropped, adding back
+	// the capabilities they need. With a read-only root filesystem an emptyDir is mounted at /tmp.
+	// The seccomp profile is set by the seccomp annotations of the pod in this version of the API.
+	// Changing it restarts the pods of the component.
+	// Optional: Defaults to none, privileged of tikv defaults to spec.tikv.privileged
+	// +optional
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+
 	// ConfigUpdateStrategy of the component. Override the cluster-level updateStrategy if present
 	// Optional: Defaults to cluster-level setting
 	// +optional
 	ConfigUpdateStrategy *ConfigUpdateStrategy `json:"configUpdateStrategy,omitempty"`
 
+	// StatefulSetUpdateStrategy of the component, RollingUpdate or OnDelete. With OnDelete the
+	// operator never deletes the pods to upgrade them, they are upgraded once deleted manually.
+	// Switching the strategy does not restart the pods.
+	// Optional: Defaults to RollingUpdate
+	// +optional
+	StatefulSetUpdateStrategy apps.StatefulSetUpdateStrategyType `json:"statefulSetUpdateStrategy,omitempty"`
+
 	// List of environment variables to set in the container, like
 	// v1.Container.Env.
 	Env []corev1.EnvVar `json:"env,omitempty"`
+
+	// AdditionalContainers are sidecar containers appended to the pods of the component after
+	// the containers managed by the operator, e.g. log shippers or debug agents. They may mount
+	// the volumes of the pods, e.g. the data and config volumes named after the component.
+	// The names of the containers managed by the operator, pd, tikv, init and init-data-dir,
+	// are reserved. Changing them restarts the pods of the component.
+	// +optional
+	AdditionalContainers []corev1.Container `json:"additionalContainers,omitempty"`
 }
 
 // +k8s:openapi-gen=true
@@ -385,6 +1024,12 @@ type ServiceSpec struct {
 	// PortName is the name of service port
 	// +optional
 	PortName *string `json:"portName,omitempty"`
+
+	// Port of the service, the pods keep listening on their own port. Changing it of the pd
+	// service rolls tikv, which connects to pd through the service.
+	// Optional: Defaults to 2379
+	// +optional
+	Port *int32 `json:"port,omitempty"`
 }
 
 // PDStatus is PD status
@@ -397,6 +1042,71 @@ type PDStatus struct {
 	FailureMembers  map[string]PDFailureMember `json:"failureMembers,omitempty"`
 	UnjoinedMembers map[string]UnjoinedMember  `json:"unjoinedMembers,omitempty"`
 	Image           string                     `json:"image,omitempty"`
+	// ScaleIn is the progress of the ongoing scale in of PD
+	ScaleIn *PDScaleInStatus `json:"scaleIn,omitempty"`
+	// CurrentRevision is statefulSet.currentRevision, the revision of the pods not upgraded yet
+	CurrentRevision string `json:"currentRevision,omitempty"`
+	// UpdateRevision is statefulSet.updateRevision, the revision the pods are upgraded to
+	UpdateRevision string `json:"updateRevision,omitempty"`
+	// UpgradedReplicas is the number of pods running the update revision
+	UpgradedReplicas int32 `json:"upgradedReplicas,omitempty"`
+	// UpgradingOrdinal is the ordinal of the pod being upgraded, it is only set while phase is Upgrade
+	UpgradingOrdinal *int32 `json:"upgradingOrdinal,omitempty"`
+	// RenderedSpecHash is the hash of the fields of the statefulset owned by the operator as
+	// rendered from the spec, LiveSpecHash is the hash of the same fields of the live statefulset
+	RenderedSpecHash string `json:"renderedSpecHash,omitempty"`
+	LiveSpecHash     string `json:"liveSpecHash,omitempty"`
+	// Drift summarizes how the live statefulset differs from the one last applied by the
+	// operator, it is only set while the drift is reported, see spec.driftPolicy
+	Drift string `json:"drift,omitempty"`
+	// TLSCertHash is the hash of the cluster TLS certificate last observed in the secret
+	TLSCertHash string `json:"tlsCertHash,omitempty"`
+	// TLSCertRolloutHash is the hash of the rotated certificate the pods are restarted to load,
+	// it is only set for the versions not reloading the certificates online
+	TLSCertRolloutHash string `json:"tlsCertRolloutHash,omitempty"`
+}
+
+// PDScaleInPhase is the progress of removing a member in a PD scale in
+type PDScaleInPhase string
+
+const (
+	// PDScaleInPhaseTransferLeader means the pd leader is being transferred away from the member
+	PDScaleInPhaseTransferLeader PDScaleInPhase = "TransferringLeader"
+	// PDScaleInPhaseDeleteMember means the member is deleted and the scale in waits for it to leave the membership
+	PDScaleInPhaseDeleteMember PDScaleInPhase = "DeletingMember"
+	// PDScaleInPhaseMemberRemoved means the member has left the membership and the statefulset is shrunk by one
+	PDScaleInPhaseMemberRemoved PDScaleInPhase = "MemberRemoved"
+)
+
+// PDScaleInStatus is the progress of a PD scale in, the members are removed one at a time
+// from the highest ordinal, each before the statefulset is shrunk
+type PDScaleInStatus struct {
+	// TargetReplicas is the replicas the scale in ends with
+	TargetReplicas int32 `json:"targetReplicas"`
+	// Member is the member being removed
+	Member string         `json:"member"`
+	Phase  PDScaleInPhase `json:"phase"`
+}
+
+// TiKVScaleInPhase is the step of removing the pods of a TiKV scale in
+type TiKVScaleInPhase string
+
+const (
+	// TiKVScaleInPhaseDecommissionStore means the stores of the pods are being drained by pd
+	TiKVScaleInPhaseDecommissionStore TiKVScaleInPhase = "DecommissioningStore"
+	// TiKVScaleInPhaseDrainConnections means the stores are tombstone and the pods are kept not
+	// ready until the deadline for the clients to close their connections
+	TiKVScaleInPhaseDrainConnections TiKVScaleInPhase = "DrainingConnections"
+)
+
+// TiKVScaleInStatus is the step of a TiKV scale in, it is cleared once the pods are removed
+type TiKVScaleInStatus struct {
+	// Pods are the pods being removed
+	Pods  []string         `json:"pods,omitempty"`
+	Phase TiKVScaleInPhase `json:"phase"`
+	// ConnectionDrainDeadline is when the pods are removed, it is only set while the
+	// connections are drained, see spec.tikv.scalePolicy.connectionDrainSeconds
+	ConnectionDrainDeadline *metav1.Time `json:"connectionDrainDeadline,omitempty"`
 }
 
 // PDMember is PD member
@@ -435,7 +1145,91 @@ type TiKVStatus struct {
 	Stores          map[string]TiKVStore        `json:"stores,omitempty"`
 	TombstoneStores map[string]TiKVStore        `json:"tombstoneStores,omitempty"`
 	FailureStores   map[string]TiKVFailureStore `json:"failureStores,omitempty"`
+	OfflineStores   map[string]TiKVOfflineStore `json:"offlineStores,omitempty"`
 	Image           string                      `json:"image,omitempty"`
+	// CurrentScaleOutOrdinals are the ordinals of the pods added by the ongoing step of a
+	// staged scale out, see spec.tikv.scaleOut
+	CurrentScaleOutOrdinals []int32 `json:"currentScaleOutOrdinals,omitempty"`
+	// ScaleIn is the step of the ongoing scale in of TiKV
+	ScaleIn *TiKVScaleInStatus `json:"scaleIn,omitempty"`
+	// UpdatedOrdinals are the ordinals of the pods running statefulSet.updateRevision during an
+	// upgrade, the other pods run statefulSet.currentRevision
+	UpdatedOrdinals []int32 `json:"updatedOrdinals,omitempty"`
+	// RollingBackFrom is the revision the pods are rolled back from, set when the spec is
+	// reverted to statefulSet.currentRevision in the middle of an upgrade
+	RollingBackFrom string `json:"rollingBackFrom,omitempty"`
+	// StorageMigration is the progress of spec.tikv.storageMigration
+	StorageMigration *TiKVStorageMigrationStatus `json:"storageMigration,omitempty"`
+	// LastUpgradedPod is the pod upgraded last during an upgrade and when it became ready with
+	// its store Up, see spec.tikv.waitDurationBetweenUpgrades
+	LastUpgradedPod *UpgradedPod `json:"lastUpgradedPod,omitempty"`
+	// UpgradingOrdinals are the ordinals of the pods being upgraded together during an upgrade,
+	// see spec.tikv.maxUpgradingPods
+	UpgradingOrdinals []int32 `json:"upgradingOrdinals,omitempty"`
+	// CurrentRevision is statefulSet.currentRevision, the revision of the pods not upgraded yet
+	CurrentRevision string `json:"currentRevision,omitempty"`
+	// UpdateRevision is statefulSet.updateRevision, the revision the pods are upgraded to
+	UpdateRevision string `json:"updateRevision,omitempty"`
+	// UpgradedReplicas is the number of pods running the update revision
+	UpgradedReplicas int32 `json:"upgradedReplicas,omitempty"`
+	// UpgradingOrdinal is the ordinal of the pod being upgraded, it is only set while phase is
+	// Upgrade, the pods upgraded together with it are in upgradingOrdinals
+	UpgradingOrdinal *int32 `json:"upgradingOrdinal,omitempty"`
+	// RenderedSpecHash is the hash of the fields of the statefulset owned by the operator as
+	// rendered from the spec, LiveSpecHash is the hash of the same fields of the live statefulset
+	RenderedSpecHash string `json:"renderedSpecHash,omitempty"`
+	LiveSpecHash     string `json:"liveSpecHash,omitempty"`
+	// Drift summarizes how the live statefulset differs from the one last applied by the
+	// operator, it is only set while the drift is reported, see spec.driftPolicy
+	Drift string `json:"drift,omitempty"`
+	// TLSCertHash is the hash of the cluster TLS certificate last observed in the secret
+	TLSCertHash string `json:"tlsCertHash,omitempty"`
+	// TLSCertRolloutHash is the hash of the rotated certificate the pods are restarted to load,
+	// it is only set for the versions not reloading the certificates online
+	TLSCertRolloutHash string `json:"tlsCertRolloutHash,omitempty"`
+}
+
+// TiKVStorageMigrationStatus is the progress of the migration of the TiKV stores to another
+// storage class
+type TiKVStorageMigrationStatus struct {
+	// StorageClassName is the storage class the stores are moved to
+	StorageClassName string `json:"storageClassName"`
+	// CurrentStore is the pod of the store being replaced, it is decommissioned like the stores
+	// in spec.tikv.offlineStores
+	CurrentStore string `json:"currentStore,omitempty"`
+	// NewStore is the pod of the store added on the target storage class for CurrentStore
+	NewStore string `json:"newStore,omitempty"`
+	// MigratedStores is the number of stores replaced so far
+	MigratedStores int32 `json:"migratedStores"`
+	// RemainingStores is the estimated number of stores left to replace, including CurrentStore
+	RemainingStores int32 `json:"remainingStores"`
+	// StartTime is when the migration started
+	StartTime metav1.Time `json:"startTime"`
+}
+
+// UpgradedPod is a pod upgraded and when it became ready
+type UpgradedPod struct {
+	Name      string      `json:"name"`
+	ReadyTime metav1.Time `json:"readyTime"`
+}
+
+// OfflineStorePhase is the decommission progress of a store listed in spec.tikv.offlineStores
+type OfflineStorePhase string
+
+const (
+	// OfflineStorePhaseOffline means the store is set offline and its data is being migrated
+	OfflineStorePhaseOffline OfflineStorePhase = "Offline"
+	// OfflineStorePhaseTombstone means the store has become tombstone
+	OfflineStorePhaseTombstone OfflineStorePhase = "Tombstone"
+	// OfflineStorePhaseRemoved means the pod of the store has been removed from the statefulset
+	OfflineStorePhaseRemoved OfflineStorePhase = "Removed"
+)
+
+// TiKVOfflineStore is the decommission progress of a store listed in spec.tikv.offlineStores
+type TiKVOfflineStore struct {
+	ID      string            `json:"id"`
+	PodName string            `json:"podName"`
+	Phase   OfflineStorePhase `json:"phase"`
 }
 
 // TiKVStores is either Up/Down/Offline/Tombstone
@@ -449,6 +1243,8 @@ type TiKVStore struct {
 	LastHeartbeatTime metav1.Time `json:"lastHeartbeatTime"`
 	// Last time the health transitioned from one to another.
 	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Labels are the labels of the store in PD, e.g. its zone
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 // TiKVFailureStore is the tikv failure store information
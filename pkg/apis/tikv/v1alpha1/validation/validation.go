@@ -14,12 +14,17 @@
 package validation
 
 import (
+	"fmt"
 	"reflect"
+	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	apps "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 
 	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 )
@@ -34,6 +39,64 @@ func ValidateTikvCluster(tc *v1alpha1.TikvCluster) field.ErrorList {
 	allErrs = append(allErrs, validateAnnotations(tc.ObjectMeta.Annotations, fldPath.Child("annotations"))...)
 	// validate spec
 	allErrs = append(allErrs, validateTiKVClusterSpec(&tc.Spec, field.NewPath("spec"))...)
+	allErrs = append(allErrs, validatePDDashboard(tc, field.NewPath("spec", "pd", "dashboard"))...)
+	allErrs = append(allErrs, validateCloneFrom(tc, field.NewPath("spec", "cloneFrom"))...)
+	allErrs = append(allErrs, validateAdvertiseAddresses(tc, field.NewPath("spec", "ports"))...)
+	return allErrs
+}
+
+// validateAdvertiseAddresses validates that no two endpoints the members advertise share an
+// address. The members in the host network advertise the addresses of the nodes they run on, so
+// the ports of the components in the host network must not collide.
+func validateAdvertiseAddresses(tc *v1alpha1.TikvCluster, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	// the ports pinned are validated along with the network mode
+	if tc.Spec.Ports != nil {
+		return allErrs
+	}
+	hosts := map[v1alpha1.MemberType]string{
+		v1alpha1.PDMemberType:   fmt.Sprintf("%s-pd-peer", tc.GetName()),
+		v1alpha1.TiKVMemberType: fmt.Sprintf("%s-tikv-peer", tc.GetName()),
+	}
+	if tc.Spec.NetworkMode == v1alpha1.NetworkModeSharedNodes || tc.BasePDSpec().HostNetwork() {
+		hosts[v1alpha1.PDMemberType] = "node"
+	}
+	if tc.Spec.NetworkMode == v1alpha1.NetworkModeSharedNodes || tc.BaseTiKVSpec().HostNetwork() {
+		hosts[v1alpha1.TiKVMemberType] = "node"
+	}
+	ports := tc.Ports()
+	seen := map[string]bool{}
+	for _, endpoint := range []struct {
+		name       string
+		memberType v1alpha1.MemberType
+		port       int32
+	}{
+		{"pdClient", v1alpha1.PDMemberType, ports.PDClient},
+		{"pdPeer", v1alpha1.PDMemberType, ports.PDPeer},
+		{"tikv", v1alpha1.TiKVMemberType, ports.TiKV},
+		{"tikvStatus", v1alpha1.TiKVMemberType, ports.TiKVStatus},
+	} {
+		address := fmt.Sprintf("%s:%d", hosts[endpoint.memberType], endpoint.port)
+		if seen[address] {
+			allErrs = append(allErrs, field.Duplicate(fldPath.Child(endpoint.name), address))
+		}
+		seen[address] = true
+	}
+	return allErrs
+}
+
+// validateCloneFrom validates the reference to the cluster cloned
+func validateCloneFrom(tc *v1alpha1.TikvCluster, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if tc.Spec.CloneFrom == nil {
+		return allErrs
+	}
+	name := tc.Spec.CloneFrom.Name
+	if name == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("name"), "the name of the cluster to clone must be set"))
+	} else if name == tc.GetName() {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("name"), name, "a cluster can not be cloned from itself"))
+	}
 	return allErrs
 }
 
@@ -47,6 +110,109 @@ func validateTiKVClusterSpec(spec *v1alpha1.TikvClusterSpec, fldPath *field.Path
 	allErrs := field.ErrorList{}
 	allErrs = append(allErrs, validatePDSpec(&spec.PD, fldPath.Child("pd"))...)
 	allErrs = append(allErrs, validateTiKVSpec(&spec.TiKV, fldPath.Child("tikv"))...)
+	allErrs = append(allErrs, validateNetworkMode(spec, fldPath)...)
+	allErrs = append(allErrs, validateTLSCluster(spec.TLSCluster, fldPath.Child("tlsCluster"))...)
+	allErrs = append(allErrs, validateMonitoring(spec, fldPath.Child("monitoring"))...)
+	allErrs = append(allErrs, validateDiscovery(&spec.Discovery, fldPath.Child("discovery"))...)
+	allErrs = append(allErrs, validateTopologySpreadConstraints(spec.TopologySpreadConstraints, fldPath.Child("topologySpreadConstraints"))...)
+	allErrs = append(allErrs, validatePriorityClassName(spec.PriorityClassName, fldPath.Child("priorityClassName"))...)
+	switch spec.DriftPolicy {
+	case "", v1alpha1.DriftPolicyRepair, v1alpha1.DriftPolicyReport:
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("driftPolicy"), spec.DriftPolicy,
+			[]string{string(v1alpha1.DriftPolicyRepair), string(v1alpha1.DriftPolicyReport)}))
+	}
+	return allErrs
+}
+
+// validateDiscovery validates the service account the discovery runs as
+func validateDiscovery(spec *v1alpha1.DiscoverySpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if spec.ServiceAccountName != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(spec.ServiceAccountName) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("serviceAccountName"), spec.ServiceAccountName, msg))
+		}
+	}
+	return allErrs
+}
+
+// validateTLSCluster validates the issuer of the certificates issued by cert-manager
+func validateTLSCluster(tls *v1alpha1.TLSCluster, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if tls == nil || tls.CertManager == nil {
+		return allErrs
+	}
+	certManagerPath := fldPath.Child("certManager")
+	if !tls.Enabled {
+		allErrs = append(allErrs, field.Forbidden(certManagerPath, "requires spec.tlsCluster.enabled"))
+	}
+	issuerPath := certManagerPath.Child("issuerRef")
+	if tls.CertManager.IssuerRef.Name == "" {
+		allErrs = append(allErrs, field.Required(issuerPath.Child("name"), ""))
+	}
+	switch tls.CertManager.IssuerRef.Kind {
+	case "", "Issuer", "ClusterIssuer":
+	default:
+		allErrs = append(allErrs, field.NotSupported(issuerPath.Child("kind"), tls.CertManager.IssuerRef.Kind, []string{"Issuer", "ClusterIssuer"}))
+	}
+	return allErrs
+}
+
+// validateMonitoring validates the namespace the scrape certificate is exported to
+func validateMonitoring(spec *v1alpha1.TikvClusterSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if spec.Monitoring == nil || spec.Monitoring.ExportScrapeTLS == nil {
+		return allErrs
+	}
+	exportPath := fldPath.Child("exportScrapeTLS")
+	if spec.TLSCluster == nil || !spec.TLSCluster.Enabled {
+		allErrs = append(allErrs, field.Forbidden(exportPath, "requires spec.tlsCluster.enabled"))
+	}
+	namespacePath := exportPath.Child("namespace")
+	namespace := spec.Monitoring.ExportScrapeTLS.Namespace
+	if namespace == "" {
+		allErrs = append(allErrs, field.Required(namespacePath, "the namespace the secret is copied to is required"))
+	} else {
+		for _, msg := range validation.IsDNS1123Label(namespace) {
+			allErrs = append(allErrs, field.Invalid(namespacePath, namespace, msg))
+		}
+	}
+	return allErrs
+}
+
+// validateNetworkMode validates the network mode and the ports pinned for the cluster
+func validateNetworkMode(spec *v1alpha1.TikvClusterSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	switch spec.NetworkMode {
+	case v1alpha1.NetworkModeDefault, v1alpha1.NetworkModeSharedNodes:
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("networkMode"), spec.NetworkMode, []string{string(v1alpha1.NetworkModeSharedNodes)}))
+	}
+	if spec.Ports == nil {
+		return allErrs
+	}
+	portsPath := fldPath.Child("ports")
+	if spec.NetworkMode != v1alpha1.NetworkModeSharedNodes {
+		allErrs = append(allErrs, field.Forbidden(portsPath, "ports can only be pinned in sharedNodes network mode"))
+	}
+	seen := map[int32]bool{}
+	for _, port := range []struct {
+		name  string
+		value int32
+	}{
+		{"pdClient", spec.Ports.PDClient},
+		{"pdPeer", spec.Ports.PDPeer},
+		{"tikv", spec.Ports.TiKV},
+		{"tikvStatus", spec.Ports.TiKVStatus},
+	} {
+		for _, msg := range validation.IsValidPortNum(int(port.value)) {
+			allErrs = append(allErrs, field.Invalid(portsPath.Child(port.name), port.value, msg))
+		}
+		if seen[port.value] {
+			allErrs = append(allErrs, field.Duplicate(portsPath.Child(port.name), port.value))
+		}
+		seen[port.value] = true
+	}
 	return allErrs
 }
 
@@ -54,6 +220,61 @@ func validatePDSpec(spec *v1alpha1.PDSpec, fldPath *field.Path) field.ErrorList
 	allErrs := field.ErrorList{}
 	allErrs = append(allErrs, validateComponentSpec(&spec.ComponentSpec, fldPath)...)
 	allErrs = append(allErrs, validateRequestsStorage(spec.ResourceRequirements.Requests, fldPath)...)
+	allErrs = append(allErrs, validateServiceSpec(spec.Service, fldPath.Child("service"))...)
+	return allErrs
+}
+
+// validateServiceSpec validates the port overridden of a service
+func validateServiceSpec(svc *v1alpha1.ServiceSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if svc == nil || svc.Port == nil {
+		return allErrs
+	}
+	for _, msg := range validation.IsValidPortNum(int(*svc.Port)) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("port"), *svc.Port, msg))
+	}
+	return allErrs
+}
+
+// validatePDDashboard validates the exposure of the pd dashboard, the protocol the ingress
+// connects to pd with must match whether the cluster TLS is enabled
+func validatePDDashboard(tc *v1alpha1.TikvCluster, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	dashboard := tc.Spec.PD.Dashboard
+	if dashboard == nil {
+		return allErrs
+	}
+	if dashboard.Expose && tc.Spec.PD.Config == nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("expose"), dashboard.Expose, "requires spec.pd.config to be set"))
+	}
+	allErrs = append(allErrs, validateServiceSpec(dashboard.Service, fldPath.Child("service"))...)
+	ingress := dashboard.Ingress
+	if ingress == nil {
+		return allErrs
+	}
+	ingressPath := fldPath.Child("ingress")
+	protocol := tc.PDDashboardBackendProtocol()
+	switch protocol {
+	case v1alpha1.PDDashboardBackendHTTP:
+		if tc.IsTLSClusterEnabled() {
+			allErrs = append(allErrs, field.Invalid(ingressPath.Child("backendProtocol"), protocol, "must be HTTPS or Passthrough with the cluster TLS enabled"))
+		}
+	case v1alpha1.PDDashboardBackendHTTPS, v1alpha1.PDDashboardBackendPassthrough:
+		if !tc.IsTLSClusterEnabled() {
+			allErrs = append(allErrs, field.Invalid(ingressPath.Child("backendProtocol"), protocol, "must be HTTP with the cluster TLS disabled"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(ingressPath.Child("backendProtocol"), protocol,
+			[]string{string(v1alpha1.PDDashboardBackendHTTP), string(v1alpha1.PDDashboardBackendHTTPS), string(v1alpha1.PDDashboardBackendPassthrough)}))
+	}
+	if protocol == v1alpha1.PDDashboardBackendPassthrough {
+		if ingress.Host == "" {
+			allErrs = append(allErrs, field.Required(ingressPath.Child("host"), "required by Passthrough"))
+		}
+		if ingress.TLSSecretName != nil {
+			allErrs = append(allErrs, field.Forbidden(ingressPath.Child("tlsSecretName"), "not allowed with Passthrough"))
+		}
+	}
 	return allErrs
 }
 
@@ -61,6 +282,60 @@ func validateTiKVSpec(spec *v1alpha1.TiKVSpec, fldPath *field.Path) field.ErrorL
 	allErrs := field.ErrorList{}
 	allErrs = append(allErrs, validateComponentSpec(&spec.ComponentSpec, fldPath)...)
 	allErrs = append(allErrs, validateRequestsStorage(spec.ResourceRequirements.Requests, fldPath)...)
+	allErrs = append(allErrs, validateDuration(spec.EvictLeaderTimeout, fldPath.Child("evictLeaderTimeout"))...)
+	allErrs = append(allErrs, validateDuration(spec.UpgradeStallTimeout, fldPath.Child("upgradeStallTimeout"))...)
+	allErrs = append(allErrs, validateDuration(spec.ScaleInPVCRetentionPeriod, fldPath.Child("scaleInPVCRetentionPeriod"))...)
+	allErrs = append(allErrs, validateTiKVConfigRef(spec, fldPath.Child("configRef"))...)
+	allErrs = append(allErrs, validateTiKVConfigLayers(spec.ConfigLayers, fldPath.Child("configLayers"))...)
+	allErrs = append(allErrs, validateOfflineStores(spec.OfflineStores, fldPath.Child("offlineStores"))...)
+	allErrs = append(allErrs, validateScaleOutStrategy(spec.ScaleOut, fldPath.Child("scaleOut"))...)
+	allErrs = append(allErrs, validateStorageMigration(spec.StorageMigration, fldPath.Child("storageMigration"))...)
+	allErrs = append(allErrs, validateUpgradeSafetyCheck(spec.UpgradeSafetyCheck, fldPath.Child("upgradeSafetyCheck"))...)
+	allErrs = append(allErrs, validateTiKVFailover(spec.Failover, fldPath.Child("failover"))...)
+	allErrs = append(allErrs, validateTiKVEncryption(spec.Encryption, fldPath.Child("encryption"))...)
+	allErrs = append(allErrs, validateTiKVAdditionalConfigFiles(spec.AdditionalConfigFiles, fldPath.Child("additionalConfigFiles"))...)
+	if spec.UpgradePartition != nil && *spec.UpgradePartition < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("upgradePartition"), *spec.UpgradePartition, "must be greater than or equal to 0"))
+	}
+	if spec.WaitDurationBetweenUpgrades != nil && spec.WaitDurationBetweenUpgrades.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("waitDurationBetweenUpgrades"), spec.WaitDurationBetweenUpgrades.Duration.String(), "must be greater than or equal to 0"))
+	}
+	switch spec.PVCReclaimPolicy {
+	case "", corev1.PersistentVolumeReclaimRetain, corev1.PersistentVolumeReclaimDelete:
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("pvcReclaimPolicy"), spec.PVCReclaimPolicy,
+			[]string{string(corev1.PersistentVolumeReclaimRetain), string(corev1.PersistentVolumeReclaimDelete)}))
+	}
+	if spec.ScalePolicy != nil && spec.ScalePolicy.ConnectionDrainSeconds < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("scalePolicy", "connectionDrainSeconds"), spec.ScalePolicy.ConnectionDrainSeconds, "must be greater than or equal to 0"))
+	}
+	if spec.MaxUpgradingPods != nil && *spec.MaxUpgradingPods < 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("maxUpgradingPods"), *spec.MaxUpgradingPods, "must be greater than 0"))
+	}
+	if sc := spec.SecurityContext; sc != nil && sc.Privileged != nil && spec.Privileged != nil && *sc.Privileged != *spec.Privileged {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("securityContext", "privileged"), *sc.Privileged, "conflicts with spec.tikv.privileged"))
+	}
+	return allErrs
+}
+
+// tikvReservedConfigNames are the keys of the configmap of tikv and the files of /etc/tikv owned
+// by the operator
+var tikvReservedConfigNames = []string{"config-file", "startup-script", "encryption-key-hash", "tikv.toml"}
+
+// validateTiKVAdditionalConfigFiles validates the names of the additional files, they are both the
+// keys of the configmap and the names of the files mounted
+func validateTiKVAdditionalConfigFiles(files map[string]string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for name := range files {
+		for _, msg := range validation.IsConfigMapKey(name) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(name), name, msg))
+		}
+		for _, reserved := range tikvReservedConfigNames {
+			if name == reserved {
+				allErrs = append(allErrs, field.Invalid(fldPath.Key(name), name, "is reserved by tikv-operator"))
+			}
+		}
+	}
 	return allErrs
 }
 
@@ -68,6 +343,90 @@ func validateComponentSpec(spec *v1alpha1.ComponentSpec, fldPath *field.Path) fi
 	allErrs := field.ErrorList{}
 	// TODO validate other fields
 	allErrs = append(allErrs, validateEnv(spec.Env, fldPath.Child("env"))...)
+	allErrs = append(allErrs, validatePriorityClassName(spec.PriorityClassName, fldPath.Child("priorityClassName"))...)
+	allErrs = append(allErrs, validateTopologySpreadConstraints(spec.TopologySpreadConstraints, fldPath.Child("topologySpreadConstraints"))...)
+	allErrs = append(allErrs, validateAdditionalContainers(spec.AdditionalContainers, fldPath.Child("additionalContainers"))...)
+	switch spec.StatefulSetUpdateStrategy {
+	case "", apps.RollingUpdateStatefulSetStrategyType, apps.OnDeleteStatefulSetStrategyType:
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("statefulSetUpdateStrategy"), spec.StatefulSetUpdateStrategy,
+			[]string{string(apps.RollingUpdateStatefulSetStrategyType), string(apps.OnDeleteStatefulSetStrategyType)}))
+	}
+	return allErrs
+}
+
+// validatePriorityClassName validates the name of the priority class of the pods, which the
+// apiserver would otherwise only reject once the statefulset is created
+func validatePriorityClassName(name *string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if name == nil || *name == "" {
+		return allErrs
+	}
+	for _, msg := range validation.IsDNS1123Subdomain(*name) {
+		allErrs = append(allErrs, field.Invalid(fldPath, *name, msg))
+	}
+	return allErrs
+}
+
+// reservedContainerNames are the names of the containers and init containers the operator adds to
+// the pods of the components
+var reservedContainerNames = sets.NewString(
+	v1alpha1.PDMemberType.String(),
+	v1alpha1.TiKVMemberType.String(),
+	"init",
+	"init-data-dir",
+)
+
+// validateAdditionalContainers validates the names and images of the sidecar containers, the
+// other fields are validated by the apiserver once the statefulset is applied
+func validateAdditionalContainers(containers []corev1.Container, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	names := sets.NewString()
+	for i, c := range containers {
+		idxPath := fldPath.Index(i)
+		switch {
+		case c.Name == "":
+			allErrs = append(allErrs, field.Required(idxPath.Child("name"), ""))
+		case reservedContainerNames.Has(c.Name):
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("name"), c.Name, "is reserved for the containers managed by the operator"))
+		case names.Has(c.Name):
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("name"), c.Name))
+		default:
+			for _, msg := range validation.IsDNS1123Label(c.Name) {
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("name"), c.Name, msg))
+			}
+		}
+		names.Insert(c.Name)
+		if c.Image == "" {
+			allErrs = append(allErrs, field.Required(idxPath.Child("image"), ""))
+		}
+	}
+	return allErrs
+}
+
+// validateTopologySpreadConstraints validates the skew, the topology key and the action of the
+// topology spread constraints, the labelSelector may be left empty
+func validateTopologySpreadConstraints(constraints []corev1.TopologySpreadConstraint, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for i, c := range constraints {
+		idxPath := fldPath.Index(i)
+		if c.MaxSkew <= 0 {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("maxSkew"), c.MaxSkew, "must be greater than 0"))
+		}
+		if c.TopologyKey == "" {
+			allErrs = append(allErrs, field.Required(idxPath.Child("topologyKey"), ""))
+		} else {
+			for _, msg := range validation.IsQualifiedName(c.TopologyKey) {
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("topologyKey"), c.TopologyKey, msg))
+			}
+		}
+		switch c.WhenUnsatisfiable {
+		case corev1.DoNotSchedule, corev1.ScheduleAnyway:
+		default:
+			allErrs = append(allErrs, field.NotSupported(idxPath.Child("whenUnsatisfiable"), c.WhenUnsatisfiable,
+				[]string{string(corev1.DoNotSchedule), string(corev1.ScheduleAnyway)}))
+		}
+	}
 	return allErrs
 }
 
@@ -80,6 +439,220 @@ func validateRequestsStorage(requests corev1.ResourceList, fldPath *field.Path)
 	return allErrs
 }
 
+// validateDuration validates the optional duration string
+func validateDuration(d *string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if d == nil {
+		return allErrs
+	}
+	if _, err := time.ParseDuration(*d); err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath, *d, err.Error()))
+	}
+	return allErrs
+}
+
+// validateTiKVConfigRef validates the config reference of tikv, the ConfigMap is always
+// looked up in the namespace of the TikvCluster so the name must not carry a namespace
+func validateTiKVConfigRef(spec *v1alpha1.TiKVSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	ref := spec.ConfigRef
+	if ref == nil {
+		return allErrs
+	}
+	if spec.Config != nil {
+		allErrs = append(allErrs, field.Forbidden(fldPath, "may not be specified when `config` is not empty"))
+	}
+	allErrs = append(allErrs, validateConfigMapKeyRef(ref, fldPath)...)
+	return allErrs
+}
+
+// validateTiKVConfigLayers validates the config layers of tikv, each of them is either an inline
+// TOML fragment or a ConfigMap reference, the fragments in ConfigMaps are validated when merged
+func validateTiKVConfigLayers(layers []v1alpha1.TiKVConfigLayer, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	names := map[string]bool{}
+	for i, layer := range layers {
+		idxPath := fldPath.Index(i)
+		if len(layer.Name) == 0 {
+			allErrs = append(allErrs, field.Required(idxPath.Child("name"), ""))
+		} else if names[layer.Name] {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("name"), layer.Name))
+		}
+		names[layer.Name] = true
+		if layer.ConfigRef != nil {
+			if len(layer.Config) > 0 {
+				allErrs = append(allErrs, field.Forbidden(idxPath.Child("configRef"), "may not be specified when `config` is not empty"))
+			}
+			allErrs = append(allErrs, validateConfigMapKeyRef(layer.ConfigRef, idxPath.Child("configRef"))...)
+			continue
+		}
+		if len(layer.Config) == 0 {
+			allErrs = append(allErrs, field.Required(idxPath, "one of `config` or `configRef` must be specified"))
+			continue
+		}
+		var table map[string]interface{}
+		if _, err := toml.Decode(layer.Config, &table); err != nil {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("config"), layer.Config, err.Error()))
+		}
+	}
+	return allErrs
+}
+
+// validateConfigMapKeyRef validates a reference to a key of a ConfigMap in the namespace of the TikvCluster
+func validateConfigMapKeyRef(ref *v1alpha1.ConfigMapKeyRef, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for _, msg := range apivalidation.NameIsDNSSubdomain(ref.ConfigMapName, false) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("configMapName"), ref.ConfigMapName, msg))
+	}
+	if len(ref.Key) == 0 {
+		allErrs = append(allErrs, field.Required(fldPath.Child("key"), ""))
+	} else {
+		for _, msg := range validation.IsConfigMapKey(ref.Key) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("key"), ref.Key, msg))
+		}
+	}
+	return allErrs
+}
+
+// validateOfflineStores validates the stores to decommission, each of them is a store ID or a pod name
+func validateOfflineStores(stores []string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	seen := map[string]bool{}
+	for i, store := range stores {
+		idxPath := fldPath.Index(i)
+		if len(store) == 0 {
+			allErrs = append(allErrs, field.Required(idxPath, ""))
+			continue
+		}
+		if seen[store] {
+			allErrs = append(allErrs, field.Duplicate(idxPath, store))
+		}
+		seen[store] = true
+	}
+	return allErrs
+}
+
+// validateTiKVFailover validates the zone outage threshold and the zone failover deadline of tikv
+func validateTiKVFailover(failover *v1alpha1.TiKVFailover, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if failover == nil {
+		return allErrs
+	}
+	if t := failover.ZoneOutageThreshold; t != nil && (*t < 0 || *t > 100) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("zoneOutageThreshold"), *t, "must be between 0 and 100"))
+	}
+	if d := failover.ZoneFailoverDeadline; d != nil && d.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("zoneFailoverDeadline"), d.Duration.String(), "must be greater than or equal to 0"))
+	}
+	if failover.ZoneLabel != "" {
+		for _, msg := range validation.IsQualifiedName(failover.ZoneLabel) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("zoneLabel"), failover.ZoneLabel, msg))
+		}
+	}
+	return allErrs
+}
+
+// validateUpgradeSafetyCheck validates the thresholds of the upgrade safety check of tikv
+func validateUpgradeSafetyCheck(check *v1alpha1.TiKVUpgradeSafetyCheck, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if check == nil {
+		return allErrs
+	}
+	if check.MaxDownPeerRegions != nil && *check.MaxDownPeerRegions < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("maxDownPeerRegions"), *check.MaxDownPeerRegions, "must be greater than or equal to 0"))
+	}
+	if check.MaxPendingPeerRegions != nil && *check.MaxPendingPeerRegions < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("maxPendingPeerRegions"), *check.MaxPendingPeerRegions, "must be greater than or equal to 0"))
+	}
+	return allErrs
+}
+
+// validateScaleOutStrategy validates the staged scale out strategy of tikv
+func validateScaleOutStrategy(strategy *v1alpha1.TiKVScaleOutStrategy, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if strategy == nil {
+		return allErrs
+	}
+	if strategy.StepSize != nil && *strategy.StepSize < 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("stepSize"), *strategy.StepSize, "must be greater than 0"))
+	}
+	if strategy.MinRegionCount != nil && *strategy.MinRegionCount < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("minRegionCount"), *strategy.MinRegionCount, "must be greater than or equal to 0"))
+	}
+	return allErrs
+}
+
+func validateStorageMigration(migration *v1alpha1.TiKVStorageMigration, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if migration == nil {
+		return allErrs
+	}
+	if migration.StorageClassName == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("storageClassName"), "the storage class to migrate the stores to is required"))
+	} else {
+		for _, msg := range validation.IsDNS1123Subdomain(migration.StorageClassName) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("storageClassName"), migration.StorageClassName, msg))
+		}
+	}
+	return allErrs
+}
+
+// tikvEncryptionMethods are the methods tikv encrypts the data files with
+var tikvEncryptionMethods = []string{"aes128-ctr", "aes192-ctr", "aes256-ctr"}
+
+func validateTiKVEncryption(encryption *v1alpha1.TiKVEncryption, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if encryption == nil {
+		return allErrs
+	}
+	if encryption.Method != "" {
+		supported := false
+		for _, method := range tikvEncryptionMethods {
+			if encryption.Method == method {
+				supported = true
+			}
+		}
+		if !supported {
+			allErrs = append(allErrs, field.NotSupported(fldPath.Child("method"), encryption.Method, tikvEncryptionMethods))
+		}
+	}
+	switch {
+	case encryption.SecretRef == nil && encryption.KMS == nil:
+		allErrs = append(allErrs, field.Required(fldPath, "exactly one of secretRef and kms is required"))
+	case encryption.SecretRef != nil && encryption.KMS != nil:
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("kms"), "secretRef and kms are mutually exclusive"))
+	case encryption.SecretRef != nil:
+		allErrs = append(allErrs, validateSecretRef(encryption.SecretRef, fldPath.Child("secretRef"))...)
+	default:
+		// the key id is not echoed in the errors, which end up in the events of the webhook
+		kms := encryption.KMS
+		kmsPath := fldPath.Child("kms")
+		if kms.KeyID == "" {
+			allErrs = append(allErrs, field.Required(kmsPath.Child("keyID"), "the id of the kms key is required"))
+		}
+		if kms.Region == "" {
+			allErrs = append(allErrs, field.Required(kmsPath.Child("region"), "the region of the kms key is required"))
+		}
+		if kms.CredentialsSecretRef != nil {
+			allErrs = append(allErrs, validateSecretRef(kms.CredentialsSecretRef, kmsPath.Child("credentialsSecretRef"))...)
+		}
+	}
+	return allErrs
+}
+
+func validateSecretRef(ref *corev1.LocalObjectReference, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	namePath := fldPath.Child("name")
+	if ref.Name == "" {
+		allErrs = append(allErrs, field.Required(namePath, "the name of the secret is required"))
+	} else {
+		for _, msg := range validation.IsDNS1123Subdomain(ref.Name) {
+			allErrs = append(allErrs, field.Invalid(namePath, ref.Name, msg))
+		}
+	}
+	return allErrs
+}
+
 // validateEnv validates env vars
 func validateEnv(vars []corev1.EnvVar, fldPath *field.Path) field.ErrorList {
 	allErrs := field.ErrorList{}
@@ -188,7 +761,36 @@ func ValidateUpdateTikvCluster(old, tc *v1alpha1.TikvCluster) field.ErrorList {
 	allErrs = append(allErrs, ValidateTikvCluster(tc)...)
 	allErrs = append(allErrs, validateUpdatePDConfig(old.Spec.PD.Config, tc.Spec.PD.Config, field.NewPath("spec.pd.config"))...)
 	allErrs = append(allErrs, disallowUsingLegacyAPIInNewCluster(old, tc)...)
+	allErrs = append(allErrs, validateUpdateNetworkMode(old, tc)...)
+	allErrs = append(allErrs, validateUpdateTLSCluster(old, tc)...)
+	if !reflect.DeepEqual(old.Spec.CloneFrom, tc.Spec.CloneFrom) {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "cloneFrom"), tc.Spec.CloneFrom, "cloneFrom is immutable"))
+	}
+
+	return allErrs
+}
+
+// validateUpdateNetworkMode disallows changing the network mode, which changes the ports the pd
+// members advertise to each other
+func validateUpdateNetworkMode(old, tc *v1alpha1.TikvCluster) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if old.Spec.NetworkMode != tc.Spec.NetworkMode {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "networkMode"), tc.Spec.NetworkMode, "networkMode is immutable"))
+	}
+	if !reflect.DeepEqual(old.Spec.Ports, tc.Spec.Ports) {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "ports"), tc.Spec.Ports, "ports are immutable"))
+	}
+	return allErrs
+}
 
+// validateUpdateTLSCluster disallows switching the cluster TLS of an existing cluster, the members
+// can not talk to each other during the rolling update in between
+func validateUpdateTLSCluster(old, tc *v1alpha1.TikvCluster) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if old.IsTLSClusterEnabled() != tc.IsTLSClusterEnabled() {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "tlsCluster", "enabled"), tc.IsTLSClusterEnabled(),
+			"the cluster TLS can not be enabled or disabled for an existing cluster"))
+	}
 	return allErrs
 }
 
@@ -227,7 +829,8 @@ func disallowUsingLegacyAPIInNewCluster(old, tc *v1alpha1.TikvCluster) field.Err
 	if old.Spec.TiKV.BaseImage != "" && tc.Spec.TiKV.BaseImage == "" {
 		allErrs = append(allErrs, field.Invalid(path.Child("tikv.baseImage"), tc.Spec.TiKV.BaseImage, "baseImage of TiKV must not be empty"))
 	}
-	if old.Spec.TiKV.Config != nil && tc.Spec.TiKV.Config == nil {
+	if (old.Spec.TiKV.Config != nil || old.Spec.TiKV.ConfigRef != nil || len(old.Spec.TiKV.ConfigLayers) > 0) &&
+		tc.Spec.TiKV.Config == nil && tc.Spec.TiKV.ConfigRef == nil && len(tc.Spec.TiKV.ConfigLayers) == 0 {
 		allErrs = append(allErrs, field.Invalid(path.Child("tikv.config"), tc.Spec.TiKV.Config, "TiKV.config must not be nil"))
 	}
 	if old.Spec.PD.Config != nil && tc.Spec.PD.Config == nil {
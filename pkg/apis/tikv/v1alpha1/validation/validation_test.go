@@ -15,11 +15,15 @@ package validation
 
 import (
 	"testing"
+	"time"
 
 	. "github.com/onsi/gomega"
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	apps "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
 )
 
 func TestValidateRequestsStorage(t *testing.T) {
@@ -63,9 +67,1154 @@ func TestValidateRequestsStorage(t *testing.T) {
 	}
 }
 
+func TestValidateEvictLeaderTimeout(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name           string
+		timeout        *string
+		expectedErrors int
+	}{
+		{
+			name:           "not set",
+			timeout:        nil,
+			expectedErrors: 0,
+		},
+		{
+			name:           "valid duration",
+			timeout:        pointer.StringPtr("10m"),
+			expectedErrors: 0,
+		},
+		{
+			name:           "invalid duration",
+			timeout:        pointer.StringPtr("10"),
+			expectedErrors: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.EvictLeaderTimeout = tt.timeout
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
+
+func TestValidateTiKVSecurityContext(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name            string
+		privileged      *bool
+		securityContext *corev1.SecurityContext
+		expectedErrors  int
+	}{
+		{
+			name:           "not set",
+			expectedErrors: 0,
+		},
+		{
+			name:            "privileged of the security context only",
+			securityContext: &corev1.SecurityContext{Privileged: pointer.BoolPtr(true)},
+			expectedErrors:  0,
+		},
+		{
+			name:            "privileged agreed",
+			privileged:      pointer.BoolPtr(false),
+			securityContext: &corev1.SecurityContext{Privileged: pointer.BoolPtr(false)},
+			expectedErrors:  0,
+		},
+		{
+			name:            "privileged in conflict",
+			privileged:      pointer.BoolPtr(true),
+			securityContext: &corev1.SecurityContext{Privileged: pointer.BoolPtr(false)},
+			expectedErrors:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Privileged = tt.privileged
+			tc.Spec.TiKV.SecurityContext = tt.securityContext
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
+
+func TestValidateDiscoveryServiceAccountName(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name               string
+		serviceAccountName string
+		expectedErrors     int
+	}{
+		{
+			name:           "not set",
+			expectedErrors: 0,
+		},
+		{
+			name:               "valid",
+			serviceAccountName: "discovery",
+			expectedErrors:     0,
+		},
+		{
+			name:               "invalid",
+			serviceAccountName: "Discovery_SA",
+			expectedErrors:     1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.Discovery.ServiceAccountName = tt.serviceAccountName
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
+
+func TestValidateTiKVConfigRef(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name           string
+		config         *v1alpha1.TiKVConfig
+		configRef      *v1alpha1.ConfigMapKeyRef
+		expectedErrors int
+	}{
+		{
+			name:           "not set",
+			expectedErrors: 0,
+		},
+		{
+			name:           "valid ref",
+			configRef:      &v1alpha1.ConfigMapKeyRef{ConfigMapName: "tikv-config", Key: "config.toml"},
+			expectedErrors: 0,
+		},
+		{
+			name:           "both inline config and ref",
+			config:         &v1alpha1.TiKVConfig{},
+			configRef:      &v1alpha1.ConfigMapKeyRef{ConfigMapName: "tikv-config", Key: "config.toml"},
+			expectedErrors: 1,
+		},
+		{
+			name:           "cross namespace ref",
+			configRef:      &v1alpha1.ConfigMapKeyRef{ConfigMapName: "other/tikv-config", Key: "config.toml"},
+			expectedErrors: 1,
+		},
+		{
+			name:           "empty key",
+			configRef:      &v1alpha1.ConfigMapKeyRef{ConfigMapName: "tikv-config"},
+			expectedErrors: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Config = tt.config
+			tc.Spec.TiKV.ConfigRef = tt.configRef
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
+
+func TestValidateTiKVConfigLayers(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ref := &v1alpha1.ConfigMapKeyRef{ConfigMapName: "tikv-config", Key: "config.toml"}
+	tests := []struct {
+		name           string
+		layers         []v1alpha1.TiKVConfigLayer
+		expectedErrors int
+	}{
+		{
+			name: "valid layers",
+			layers: []v1alpha1.TiKVConfigLayer{
+				{Name: "team", ConfigRef: ref},
+				{Name: "group", Config: "[raftstore]\nsync-log = false\n"},
+			},
+			expectedErrors: 0,
+		},
+		{
+			name:           "no name",
+			layers:         []v1alpha1.TiKVConfigLayer{{ConfigRef: ref}},
+			expectedErrors: 1,
+		},
+		{
+			name:           "duplicate names",
+			layers:         []v1alpha1.TiKVConfigLayer{{Name: "team", ConfigRef: ref}, {Name: "team", ConfigRef: ref}},
+			expectedErrors: 1,
+		},
+		{
+			name:           "neither inline config nor ref",
+			layers:         []v1alpha1.TiKVConfigLayer{{Name: "team"}},
+			expectedErrors: 1,
+		},
+		{
+			name:           "both inline config and ref",
+			layers:         []v1alpha1.TiKVConfigLayer{{Name: "team", Config: "a = 1", ConfigRef: ref}},
+			expectedErrors: 1,
+		},
+		{
+			name:           "invalid inline config",
+			layers:         []v1alpha1.TiKVConfigLayer{{Name: "team", Config: "[raftstore"}},
+			expectedErrors: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.ConfigLayers = tt.layers
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
+
+func TestValidateStatefulSetUpdateStrategy(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name           string
+		strategy       apps.StatefulSetUpdateStrategyType
+		expectedErrors int
+	}{
+		{name: "default", strategy: "", expectedErrors: 0},
+		{name: "RollingUpdate", strategy: apps.RollingUpdateStatefulSetStrategyType, expectedErrors: 0},
+		{name: "OnDelete", strategy: apps.OnDeleteStatefulSetStrategyType, expectedErrors: 0},
+		{name: "unknown strategy", strategy: "Recreate", expectedErrors: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.PD.StatefulSetUpdateStrategy = tt.strategy
+			tc.Spec.TiKV.StatefulSetUpdateStrategy = tt.strategy
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
+
+func TestValidatePDDashboard(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name           string
+		dashboard      *v1alpha1.PDDashboardSpec
+		config         *v1alpha1.PDConfig
+		expectedErrors int
+	}{
+		{
+			name:           "not set",
+			expectedErrors: 0,
+		},
+		{
+			name:           "exposed",
+			dashboard:      &v1alpha1.PDDashboardSpec{Expose: true},
+			config:         &v1alpha1.PDConfig{},
+			expectedErrors: 0,
+		},
+		{
+			name:           "exposed without pd config",
+			dashboard:      &v1alpha1.PDDashboardSpec{Expose: true},
+			expectedErrors: 1,
+		},
+		{
+			name: "ingress with the default protocol",
+			dashboard: &v1alpha1.PDDashboardSpec{
+				Expose:  true,
+				Ingress: &v1alpha1.PDDashboardIngressSpec{Host: "pd.example.com", TLSSecretName: pointer.StringPtr("pd-dashboard-tls")},
+			},
+			config:         &v1alpha1.PDConfig{},
+			expectedErrors: 0,
+		},
+		{
+			name: "HTTPS without the cluster TLS",
+			dashboard: &v1alpha1.PDDashboardSpec{
+				Expose:  true,
+				Ingress: &v1alpha1.PDDashboardIngressSpec{BackendProtocol: v1alpha1.PDDashboardBackendHTTPS},
+			},
+			config:         &v1alpha1.PDConfig{},
+			expectedErrors: 1,
+		},
+		{
+			name: "Passthrough without host and with a tls secret",
+			dashboard: &v1alpha1.PDDashboardSpec{
+				Expose: true,
+				Ingress: &v1alpha1.PDDashboardIngressSpec{
+					BackendProtocol: v1alpha1.PDDashboardBackendPassthrough,
+					TLSSecretName:   pointer.StringPtr("pd-dashboard-tls"),
+				},
+			},
+			config:         &v1alpha1.PDConfig{},
+			expectedErrors: 3,
+		},
+		{
+			name: "unknown protocol",
+			dashboard: &v1alpha1.PDDashboardSpec{
+				Expose:  true,
+				Ingress: &v1alpha1.PDDashboardIngressSpec{BackendProtocol: "GRPC"},
+			},
+			config:         &v1alpha1.PDConfig{},
+			expectedErrors: 1,
+		},
+		{
+			name: "invalid service port",
+			dashboard: &v1alpha1.PDDashboardSpec{
+				Expose:  true,
+				Service: &v1alpha1.ServiceSpec{Port: pointer.Int32Ptr(0)},
+			},
+			config:         &v1alpha1.PDConfig{},
+			expectedErrors: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.PD.Dashboard = tt.dashboard
+			tc.Spec.PD.Config = tt.config
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
+
+func TestValidatePDServicePort(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name           string
+		service        *v1alpha1.ServiceSpec
+		expectedErrors int
+	}{
+		{
+			name:           "not set",
+			expectedErrors: 0,
+		},
+		{
+			name:           "port not set",
+			service:        &v1alpha1.ServiceSpec{},
+			expectedErrors: 0,
+		},
+		{
+			name:           "valid port",
+			service:        &v1alpha1.ServiceSpec{Port: pointer.Int32Ptr(12379)},
+			expectedErrors: 0,
+		},
+		{
+			name:           "invalid port",
+			service:        &v1alpha1.ServiceSpec{Port: pointer.Int32Ptr(65536)},
+			expectedErrors: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.PD.Service = tt.service
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
+
+func TestValidateOfflineStores(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name           string
+		stores         []string
+		expectedErrors int
+	}{
+		{
+			name:           "not set",
+			expectedErrors: 0,
+		},
+		{
+			name:           "store ids and pod names",
+			stores:         []string{"1", "demo-tikv-2"},
+			expectedErrors: 0,
+		},
+		{
+			name:           "empty entry",
+			stores:         []string{"1", ""},
+			expectedErrors: 1,
+		},
+		{
+			name:           "duplicated entries",
+			stores:         []string{"1", "2", "1"},
+			expectedErrors: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.OfflineStores = tt.stores
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
+
+func TestValidateScaleOutStrategy(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name           string
+		strategy       *v1alpha1.TiKVScaleOutStrategy
+		expectedErrors int
+	}{
+		{
+			name:           "not set",
+			expectedErrors: 0,
+		},
+		{
+			name:           "defaults",
+			strategy:       &v1alpha1.TiKVScaleOutStrategy{},
+			expectedErrors: 0,
+		},
+		{
+			name:           "valid",
+			strategy:       &v1alpha1.TiKVScaleOutStrategy{StepSize: pointer.Int32Ptr(2), MinRegionCount: pointer.Int32Ptr(0)},
+			expectedErrors: 0,
+		},
+		{
+			name:           "invalid",
+			strategy:       &v1alpha1.TiKVScaleOutStrategy{StepSize: pointer.Int32Ptr(0), MinRegionCount: pointer.Int32Ptr(-1)},
+			expectedErrors: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.ScaleOut = tt.strategy
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
+
+func TestValidateStorageMigration(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name           string
+		migration      *v1alpha1.TiKVStorageMigration
+		expectedErrors int
+	}{
+		{
+			name:           "not set",
+			expectedErrors: 0,
+		},
+		{
+			name:           "valid",
+			migration:      &v1alpha1.TiKVStorageMigration{StorageClassName: "gp3"},
+			expectedErrors: 0,
+		},
+		{
+			name:           "no storage class",
+			migration:      &v1alpha1.TiKVStorageMigration{},
+			expectedErrors: 1,
+		},
+		{
+			name:           "invalid storage class",
+			migration:      &v1alpha1.TiKVStorageMigration{StorageClassName: "GP3"},
+			expectedErrors: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.StorageMigration = tt.migration
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
+
+func TestValidateTiKVEncryption(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name           string
+		encryption     *v1alpha1.TiKVEncryption
+		expectedErrors int
+	}{
+		{
+			name:           "not set",
+			expectedErrors: 0,
+		},
+		{
+			name:           "valid",
+			encryption:     &v1alpha1.TiKVEncryption{Method: "aes128-ctr", SecretRef: &corev1.LocalObjectReference{Name: "master-key"}},
+			expectedErrors: 0,
+		},
+		{
+			name:           "default method",
+			encryption:     &v1alpha1.TiKVEncryption{SecretRef: &corev1.LocalObjectReference{Name: "master-key"}},
+			expectedErrors: 0,
+		},
+		{
+			name:           "unsupported method",
+			encryption:     &v1alpha1.TiKVEncryption{Method: "plaintext", SecretRef: &corev1.LocalObjectReference{Name: "master-key"}},
+			expectedErrors: 1,
+		},
+		{
+			name:           "neither secret nor kms",
+			encryption:     &v1alpha1.TiKVEncryption{},
+			expectedErrors: 1,
+		},
+		{
+			name:           "no secret name",
+			encryption:     &v1alpha1.TiKVEncryption{SecretRef: &corev1.LocalObjectReference{}},
+			expectedErrors: 1,
+		},
+		{
+			name:           "invalid secret name",
+			encryption:     &v1alpha1.TiKVEncryption{SecretRef: &corev1.LocalObjectReference{Name: "Master_Key"}},
+			expectedErrors: 1,
+		},
+		{
+			name: "kms",
+			encryption: &v1alpha1.TiKVEncryption{KMS: &v1alpha1.TiKVEncryptionKMS{
+				KeyID:                "0987dcba-09fe-87dc-65ba-ab0987654321",
+				Region:               "us-west-2",
+				CredentialsSecretRef: &corev1.LocalObjectReference{Name: "kms-credentials"},
+			}},
+			expectedErrors: 0,
+		},
+		{
+			name:           "kms without key id and region",
+			encryption:     &v1alpha1.TiKVEncryption{KMS: &v1alpha1.TiKVEncryptionKMS{}},
+			expectedErrors: 2,
+		},
+		{
+			name: "both secret and kms",
+			encryption: &v1alpha1.TiKVEncryption{
+				SecretRef: &corev1.LocalObjectReference{Name: "master-key"},
+				KMS:       &v1alpha1.TiKVEncryptionKMS{KeyID: "0987dcba-09fe-87dc-65ba-ab0987654321", Region: "us-west-2"},
+			},
+			expectedErrors: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Encryption = tt.encryption
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
+
+func TestValidateUpgradeSafetyCheck(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name           string
+		check          *v1alpha1.TiKVUpgradeSafetyCheck
+		expectedErrors int
+	}{
+		{
+			name:           "not set",
+			expectedErrors: 0,
+		},
+		{
+			name:           "valid",
+			check:          &v1alpha1.TiKVUpgradeSafetyCheck{MaxDownPeerRegions: pointer.Int32Ptr(0), MaxPendingPeerRegions: pointer.Int32Ptr(100)},
+			expectedErrors: 0,
+		},
+		{
+			name:           "invalid",
+			check:          &v1alpha1.TiKVUpgradeSafetyCheck{MaxDownPeerRegions: pointer.Int32Ptr(-1), MaxPendingPeerRegions: pointer.Int32Ptr(-1)},
+			expectedErrors: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.UpgradeSafetyCheck = tt.check
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
+
+func TestValidateMaxUpgradingPods(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name             string
+		maxUpgradingPods *int32
+		expectedErrors   int
+	}{
+		{
+			name:           "not set",
+			expectedErrors: 0,
+		},
+		{
+			name:             "valid",
+			maxUpgradingPods: pointer.Int32Ptr(3),
+			expectedErrors:   0,
+		},
+		{
+			name:             "zero",
+			maxUpgradingPods: pointer.Int32Ptr(0),
+			expectedErrors:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.MaxUpgradingPods = tt.maxUpgradingPods
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
+
+func TestValidateTiKVScalePolicy(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tc := newTikvCluster()
+	tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+	tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+	tc.Spec.TiKV.ScalePolicy = &v1alpha1.TiKVScalePolicy{ConnectionDrainSeconds: 30}
+	g.Expect(ValidateTikvCluster(tc)).To(BeEmpty())
+
+	tc.Spec.TiKV.ScalePolicy.ConnectionDrainSeconds = -1
+	g.Expect(ValidateTikvCluster(tc)).To(HaveLen(1))
+}
+
+func TestValidateAdditionalContainers(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name           string
+		containers     []corev1.Container
+		expectedErrors int
+	}{
+		{
+			name: "valid",
+			containers: []corev1.Container{
+				{Name: "fluent-bit", Image: "fluent/fluent-bit", VolumeMounts: []corev1.VolumeMount{{Name: "tikv", MountPath: "/var/lib/tikv"}}},
+				{Name: "debug", Image: "busybox"},
+			},
+		},
+		{
+			name:           "reserved name",
+			containers:     []corev1.Container{{Name: "tikv", Image: "busybox"}},
+			expectedErrors: 1,
+		},
+		{
+			name:           "duplicated name",
+			containers:     []corev1.Container{{Name: "debug", Image: "busybox"}, {Name: "debug", Image: "busybox"}},
+			expectedErrors: 1,
+		},
+		{
+			name:           "invalid name",
+			containers:     []corev1.Container{{Name: "Debug", Image: "busybox"}},
+			expectedErrors: 1,
+		},
+		{
+			name:           "no name and image",
+			containers:     []corev1.Container{{}},
+			expectedErrors: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.AdditionalContainers = tt.containers
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
+
+func TestValidateTiKVPVCReclaimPolicy(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tc := newTikvCluster()
+	tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+	tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+	tc.Spec.TiKV.PVCReclaimPolicy = corev1.PersistentVolumeReclaimRetain
+	g.Expect(ValidateTikvCluster(tc)).To(BeEmpty())
+
+	tc.Spec.TiKV.PVCReclaimPolicy = corev1.PersistentVolumeReclaimRecycle
+	g.Expect(ValidateTikvCluster(tc)).To(HaveLen(1))
+}
+
+func TestValidateNetworkMode(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ports := v1alpha1.ClusterPorts{PDClient: 21000, PDPeer: 21001, TiKV: 21002, TiKVStatus: 21003}
+	tests := []struct {
+		name           string
+		mode           v1alpha1.NetworkMode
+		ports          func() *v1alpha1.ClusterPorts
+		expectedErrors int
+	}{
+		{
+			name:           "default",
+			expectedErrors: 0,
+		},
+		{
+			name:           "shared nodes",
+			mode:           v1alpha1.NetworkModeSharedNodes,
+			expectedErrors: 0,
+		},
+		{
+			name:           "unknown mode",
+			mode:           "host",
+			expectedErrors: 1,
+		},
+		{
+			name: "pinned ports",
+			mode: v1alpha1.NetworkModeSharedNodes,
+			ports: func() *v1alpha1.ClusterPorts {
+				p := ports
+				return &p
+			},
+			expectedErrors: 0,
+		},
+		{
+			name: "pinned ports out of shared nodes mode",
+			ports: func() *v1alpha1.ClusterPorts {
+				p := ports
+				return &p
+			},
+			expectedErrors: 1,
+		},
+		{
+			name: "invalid port",
+			mode: v1alpha1.NetworkModeSharedNodes,
+			ports: func() *v1alpha1.ClusterPorts {
+				p := ports
+				p.TiKVStatus = 0
+				return &p
+			},
+			expectedErrors: 1,
+		},
+		{
+			name: "duplicated ports",
+			mode: v1alpha1.NetworkModeSharedNodes,
+			ports: func() *v1alpha1.ClusterPorts {
+				p := ports
+				p.TiKV = p.PDClient
+				return &p
+			},
+			expectedErrors: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.NetworkMode = tt.mode
+			if tt.ports != nil {
+				tc.Spec.Ports = tt.ports()
+			}
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
+
+func TestValidateDriftPolicy(t *testing.T) {
+	g := NewGomegaWithT(t)
+	for _, tt := range []struct {
+		policy         v1alpha1.DriftPolicy
+		expectedErrors int
+	}{
+		{"", 0},
+		{v1alpha1.DriftPolicyRepair, 0},
+		{v1alpha1.DriftPolicyReport, 0},
+		{"Ignore", 1},
+	} {
+		tc := newTikvCluster()
+		tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+		tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+		tc.Spec.DriftPolicy = tt.policy
+		g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors), string(tt.policy))
+	}
+}
+
+func TestValidateCloneFrom(t *testing.T) {
+	g := NewGomegaWithT(t)
+	for _, tt := range []struct {
+		cloneFrom      *v1alpha1.TikvClusterRef
+		expectedErrors int
+	}{
+		{nil, 0},
+		{&v1alpha1.TikvClusterRef{Name: "blue"}, 0},
+		{&v1alpha1.TikvClusterRef{}, 1},
+		{&v1alpha1.TikvClusterRef{Name: "test-validate-requests-storage"}, 1},
+	} {
+		tc := newTikvCluster()
+		tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+		tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+		tc.Spec.CloneFrom = tt.cloneFrom
+		g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors), "%v", tt.cloneFrom)
+
+		old := tc.DeepCopy()
+		old.Spec.CloneFrom = nil
+		if tt.cloneFrom != nil {
+			g.Expect(ValidateUpdateTikvCluster(old, tc)).To(HaveLen(tt.expectedErrors+1), "%v", tt.cloneFrom)
+		}
+	}
+}
+
+func TestValidateAdvertiseAddresses(t *testing.T) {
+	g := NewGomegaWithT(t)
+	hostNetwork := true
+	for _, tt := range []struct {
+		name           string
+		update         func(tc *v1alpha1.TikvCluster)
+		expectedErrors int
+	}{
+		{"default ports", func(tc *v1alpha1.TikvCluster) {}, 0},
+		{"default ports in the host network", func(tc *v1alpha1.TikvCluster) {
+			tc.Spec.HostNetwork = &hostNetwork
+		}, 0},
+		{"ports allocated", func(tc *v1alpha1.TikvCluster) {
+			tc.Spec.NetworkMode = v1alpha1.NetworkModeSharedNodes
+			tc.Status.Ports = &v1alpha1.ClusterPorts{PDClient: 21000, PDPeer: 21001, TiKV: 21002, TiKVStatus: 21003}
+		}, 0},
+		{"ports allocated in conflict", func(tc *v1alpha1.TikvCluster) {
+			tc.Spec.NetworkMode = v1alpha1.NetworkModeSharedNodes
+			tc.Status.Ports = &v1alpha1.ClusterPorts{PDClient: 21000, PDPeer: 21001, TiKV: 21000, TiKVStatus: 21003}
+		}, 1},
+	} {
+		tc := newTikvCluster()
+		tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+		tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+		tt.update(tc)
+		g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors), tt.name)
+	}
+}
+
+func TestValidateUpdateNetworkMode(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	old := newTikvCluster()
+	old.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+	old.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+	old.Spec.NetworkMode = v1alpha1.NetworkModeSharedNodes
+
+	tc := old.DeepCopy()
+	g.Expect(ValidateUpdateTikvCluster(old, tc)).To(BeEmpty())
+
+	tc.Spec.NetworkMode = v1alpha1.NetworkModeDefault
+	g.Expect(ValidateUpdateTikvCluster(old, tc)).To(HaveLen(1))
+
+	tc = old.DeepCopy()
+	tc.Spec.Ports = &v1alpha1.ClusterPorts{PDClient: 21000, PDPeer: 21001, TiKV: 21002, TiKVStatus: 21003}
+	g.Expect(ValidateUpdateTikvCluster(old, tc)).To(HaveLen(1))
+}
+
+func TestValidateUpdateTLSCluster(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	old := newTikvCluster()
+	old.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+	old.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+
+	tc := old.DeepCopy()
+	tc.Spec.TLSCluster = &v1alpha1.TLSCluster{}
+	g.Expect(ValidateUpdateTikvCluster(old, tc)).To(BeEmpty())
+
+	tc.Spec.TLSCluster.Enabled = true
+	errs := ValidateUpdateTikvCluster(old, tc)
+	g.Expect(errs).To(HaveLen(1))
+	g.Expect(errs[0].Field).To(Equal("spec.tlsCluster.enabled"))
+
+	old = tc.DeepCopy()
+	g.Expect(ValidateUpdateTikvCluster(old, tc)).To(BeEmpty())
+	tc.Spec.TLSCluster = nil
+	g.Expect(ValidateUpdateTikvCluster(old, tc)).To(HaveLen(1))
+}
+
+func TestValidateTLSClusterCertManager(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name           string
+		tls            *v1alpha1.TLSCluster
+		expectedErrors int
+	}{
+		{
+			name:           "issuer",
+			tls:            &v1alpha1.TLSCluster{Enabled: true, CertManager: &v1alpha1.CertManagerTLS{IssuerRef: v1alpha1.CertManagerIssuerRef{Name: "ca"}}},
+			expectedErrors: 0,
+		},
+		{
+			name:           "cluster issuer",
+			tls:            &v1alpha1.TLSCluster{Enabled: true, CertManager: &v1alpha1.CertManagerTLS{IssuerRef: v1alpha1.CertManagerIssuerRef{Name: "ca", Kind: "ClusterIssuer"}}},
+			expectedErrors: 0,
+		},
+		{
+			name:           "tls disabled",
+			tls:            &v1alpha1.TLSCluster{CertManager: &v1alpha1.CertManagerTLS{IssuerRef: v1alpha1.CertManagerIssuerRef{Name: "ca"}}},
+			expectedErrors: 1,
+		},
+		{
+			name:           "no issuer name and unknown kind",
+			tls:            &v1alpha1.TLSCluster{Enabled: true, CertManager: &v1alpha1.CertManagerTLS{IssuerRef: v1alpha1.CertManagerIssuerRef{Kind: "Vault"}}},
+			expectedErrors: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TLSCluster = tt.tls
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
+
+func TestValidateMonitoringExportScrapeTLS(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name           string
+		tls            bool
+		export         *v1alpha1.ExportScrapeTLS
+		expectedErrors int
+	}{
+		{
+			name:           "exported",
+			tls:            true,
+			export:         &v1alpha1.ExportScrapeTLS{Namespace: "monitoring"},
+			expectedErrors: 0,
+		},
+		{
+			name:           "tls disabled",
+			export:         &v1alpha1.ExportScrapeTLS{Namespace: "monitoring"},
+			expectedErrors: 1,
+		},
+		{
+			name:           "no namespace",
+			tls:            true,
+			export:         &v1alpha1.ExportScrapeTLS{},
+			expectedErrors: 1,
+		},
+		{
+			name:           "invalid namespace",
+			tls:            true,
+			export:         &v1alpha1.ExportScrapeTLS{Namespace: "Monitoring.NS"},
+			expectedErrors: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TLSCluster = &v1alpha1.TLSCluster{Enabled: tt.tls}
+			tc.Spec.Monitoring = &v1alpha1.MonitoringSpec{ExportScrapeTLS: tt.export}
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
+
+func TestValidateUpdateTiKVConfigToRef(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	old := newTikvCluster()
+	old.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+	old.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+	old.Spec.TiKV.Config = &v1alpha1.TiKVConfig{}
+
+	tc := old.DeepCopy()
+	tc.Spec.TiKV.Config = nil
+	g.Expect(ValidateUpdateTikvCluster(old, tc)).To(HaveLen(1))
+
+	tc.Spec.TiKV.ConfigRef = &v1alpha1.ConfigMapKeyRef{ConfigMapName: "tikv-config", Key: "config.toml"}
+	g.Expect(ValidateUpdateTikvCluster(old, tc)).To(BeEmpty())
+}
+
 func newTikvCluster() *v1alpha1.TikvCluster {
 	tc := &v1alpha1.TikvCluster{}
 	tc.Name = "test-validate-requests-storage"
 	tc.Namespace = "default"
 	return tc
 }
+
+func TestValidateTiKVAdditionalConfigFiles(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name           string
+		files          map[string]string
+		expectedErrors int
+	}{
+		{
+			name:           "not set",
+			expectedErrors: 0,
+		},
+		{
+			name:           "valid",
+			files:          map[string]string{"blacklist.txt": "", "plugin-config.toml": ""},
+			expectedErrors: 0,
+		},
+		{
+			name:           "reserved",
+			files:          map[string]string{"config-file": "", "tikv.toml": ""},
+			expectedErrors: 2,
+		},
+		{
+			name:           "invalid key",
+			files:          map[string]string{"plugin/blacklist.txt": ""},
+			expectedErrors: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.AdditionalConfigFiles = tt.files
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
+
+func TestValidateTiKVFailover(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name           string
+		failover       *v1alpha1.TiKVFailover
+		expectedErrors int
+	}{
+		{
+			name:           "not set",
+			expectedErrors: 0,
+		},
+		{
+			name: "valid",
+			failover: &v1alpha1.TiKVFailover{
+				ZoneLabel:            "topology.kubernetes.io/zone",
+				ZoneOutageThreshold:  pointer.Int32Ptr(50),
+				ZoneFailoverDeadline: &metav1.Duration{Duration: 30 * time.Minute},
+			},
+			expectedErrors: 0,
+		},
+		{
+			name: "invalid threshold",
+			failover: &v1alpha1.TiKVFailover{
+				ZoneOutageThreshold: pointer.Int32Ptr(101),
+			},
+			expectedErrors: 1,
+		},
+		{
+			name: "negative deadline",
+			failover: &v1alpha1.TiKVFailover{
+				ZoneFailoverDeadline: &metav1.Duration{Duration: -time.Minute},
+			},
+			expectedErrors: 1,
+		},
+		{
+			name: "invalid zone label",
+			failover: &v1alpha1.TiKVFailover{
+				ZoneLabel: "zone label",
+			},
+			expectedErrors: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Failover = tt.failover
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
+
+func TestValidateTopologySpreadConstraints(t *testing.T) {
+	g := NewGomegaWithT(t)
+	valid := corev1.TopologySpreadConstraint{MaxSkew: 1, TopologyKey: "topology.kubernetes.io/zone", WhenUnsatisfiable: corev1.DoNotSchedule}
+	tests := []struct {
+		name           string
+		cluster        []corev1.TopologySpreadConstraint
+		tikv           []corev1.TopologySpreadConstraint
+		expectedErrors int
+	}{
+		{
+			name:           "not set",
+			expectedErrors: 0,
+		},
+		{
+			name:           "valid",
+			cluster:        []corev1.TopologySpreadConstraint{valid},
+			tikv:           []corev1.TopologySpreadConstraint{valid},
+			expectedErrors: 0,
+		},
+		{
+			name:           "invalid cluster-level",
+			cluster:        []corev1.TopologySpreadConstraint{{TopologyKey: "zone", WhenUnsatisfiable: corev1.ScheduleAnyway}},
+			expectedErrors: 1,
+		},
+		{
+			name:           "invalid tikv",
+			tikv:           []corev1.TopologySpreadConstraint{{MaxSkew: 1, WhenUnsatisfiable: "Never"}},
+			expectedErrors: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TopologySpreadConstraints = tt.cluster
+			tc.Spec.TiKV.TopologySpreadConstraints = tt.tikv
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
+
+func TestValidatePriorityClassName(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name           string
+		cluster        *string
+		pd             *string
+		tikv           *string
+		expectedErrors int
+	}{
+		{
+			name:           "not set",
+			expectedErrors: 0,
+		},
+		{
+			name:           "valid",
+			cluster:        pointer.StringPtr("system-cluster-critical"),
+			tikv:           pointer.StringPtr("tikv.high-priority"),
+			expectedErrors: 0,
+		},
+		{
+			name:           "empty override",
+			pd:             pointer.StringPtr(""),
+			expectedErrors: 0,
+		},
+		{
+			name:           "invalid",
+			cluster:        pointer.StringPtr("High_Priority"),
+			pd:             pointer.StringPtr("pd priority"),
+			tikv:           pointer.StringPtr("tikv/high"),
+			expectedErrors: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvCluster()
+			tc.Spec.PD.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+			tc.Spec.PriorityClassName = tt.cluster
+			tc.Spec.PD.PriorityClassName = tt.pd
+			tc.Spec.TiKV.PriorityClassName = tt.tikv
+			g.Expect(ValidateTikvCluster(tc)).To(HaveLen(tt.expectedErrors))
+		})
+	}
+}
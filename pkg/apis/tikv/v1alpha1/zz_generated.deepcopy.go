@@ -20,9 +20,76 @@ package v1alpha1
 import (
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertManagerIssuerRef) DeepCopyInto(out *CertManagerIssuerRef) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertManagerIssuerRef.
+func (in *CertManagerIssuerRef) DeepCopy() *CertManagerIssuerRef {
+	if in == nil {
+		return nil
+	}
+	out := new(CertManagerIssuerRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertManagerTLS) DeepCopyInto(out *CertManagerTLS) {
+	*out = *in
+	out.IssuerRef = in.IssuerRef
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertManagerTLS.
+func (in *CertManagerTLS) DeepCopy() *CertManagerTLS {
+	if in == nil {
+		return nil
+	}
+	out := new(CertManagerTLS)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CloneStatus) DeepCopyInto(out *CloneStatus) {
+	*out = *in
+	in.CloneTime.DeepCopyInto(&out.CloneTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CloneStatus.
+func (in *CloneStatus) DeepCopy() *CloneStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CloneStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterPorts) DeepCopyInto(out *ClusterPorts) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterPorts.
+func (in *ClusterPorts) DeepCopy() *ClusterPorts {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterPorts)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ComponentSpec) DeepCopyInto(out *ComponentSpec) {
 	*out = *in
@@ -77,11 +144,23 @@ func (in *ComponentSpec) DeepCopyInto(out *ComponentSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.TopologySpreadConstraints != nil {
+		in, out := &in.TopologySpreadConstraints, &out.TopologySpreadConstraints
+		*out = make([]v1.TopologySpreadConstraint, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.PodSecurityContext != nil {
 		in, out := &in.PodSecurityContext, &out.PodSecurityContext
 		*out = new(v1.PodSecurityContext)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.SecurityContext != nil {
+		in, out := &in.SecurityContext, &out.SecurityContext
+		*out = new(v1.SecurityContext)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.ConfigUpdateStrategy != nil {
 		in, out := &in.ConfigUpdateStrategy, &out.ConfigUpdateStrategy
 		*out = new(ConfigUpdateStrategy)
@@ -94,6 +173,13 @@ func (in *ComponentSpec) DeepCopyInto(out *ComponentSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.AdditionalContainers != nil {
+		in, out := &in.AdditionalContainers, &out.AdditionalContainers
+		*out = make([]v1.Container, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -107,6 +193,60 @@ func (in *ComponentSpec) DeepCopy() *ComponentSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ComponentUpgradeStatus) DeepCopyInto(out *ComponentUpgradeStatus) {
+	*out = *in
+	if in.Pods != nil {
+		in, out := &in.Pods, &out.Pods
+		*out = make(map[string]PodUpgradeDurations, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.ImagePull != nil {
+		in, out := &in.ImagePull, &out.ImagePull
+		*out = new(UpgradeDurationSummary)
+		**out = **in
+	}
+	if in.Startup != nil {
+		in, out := &in.Startup, &out.Startup
+		*out = new(UpgradeDurationSummary)
+		**out = **in
+	}
+	if in.LeaderEviction != nil {
+		in, out := &in.LeaderEviction, &out.LeaderEviction
+		*out = new(UpgradeDurationSummary)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ComponentUpgradeStatus.
+func (in *ComponentUpgradeStatus) DeepCopy() *ComponentUpgradeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ComponentUpgradeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConfigMapKeyRef) DeepCopyInto(out *ConfigMapKeyRef) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConfigMapKeyRef.
+func (in *ConfigMapKeyRef) DeepCopy() *ConfigMapKeyRef {
+	if in == nil {
+		return nil
+	}
+	out := new(ConfigMapKeyRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DashboardConfig) DeepCopyInto(out *DashboardConfig) {
 	*out = *in
@@ -130,6 +270,11 @@ func (in *DashboardConfig) DeepCopyInto(out *DashboardConfig) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.InternalProxy != nil {
+		in, out := &in.InternalProxy, &out.InternalProxy
+		*out = new(bool)
+		**out = **in
+	}
 	return
 }
 
@@ -160,6 +305,22 @@ func (in *DiscoverySpec) DeepCopy() *DiscoverySpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExportScrapeTLS) DeepCopyInto(out *ExportScrapeTLS) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExportScrapeTLS.
+func (in *ExportScrapeTLS) DeepCopy() *ExportScrapeTLS {
+	if in == nil {
+		return nil
+	}
+	out := new(ExportScrapeTLS)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FileLogConfig) DeepCopyInto(out *FileLogConfig) {
 	*out = *in
@@ -268,6 +429,27 @@ func (in *MasterKeyKMSConfig) DeepCopy() *MasterKeyKMSConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MonitoringSpec) DeepCopyInto(out *MonitoringSpec) {
+	*out = *in
+	if in.ExportScrapeTLS != nil {
+		in, out := &in.ExportScrapeTLS, &out.ExportScrapeTLS
+		*out = new(ExportScrapeTLS)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MonitoringSpec.
+func (in *MonitoringSpec) DeepCopy() *MonitoringSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MonitoringSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PDConfig) DeepCopyInto(out *PDConfig) {
 	*out = *in
@@ -417,6 +599,65 @@ func (in *PDConfig) DeepCopy() *PDConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PDDashboardIngressSpec) DeepCopyInto(out *PDDashboardIngressSpec) {
+	*out = *in
+	if in.ClassName != nil {
+		in, out := &in.ClassName, &out.ClassName
+		*out = new(string)
+		**out = **in
+	}
+	if in.TLSSecretName != nil {
+		in, out := &in.TLSSecretName, &out.TLSSecretName
+		*out = new(string)
+		**out = **in
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PDDashboardIngressSpec.
+func (in *PDDashboardIngressSpec) DeepCopy() *PDDashboardIngressSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PDDashboardIngressSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PDDashboardSpec) DeepCopyInto(out *PDDashboardSpec) {
+	*out = *in
+	if in.Service != nil {
+		in, out := &in.Service, &out.Service
+		*out = new(ServiceSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Ingress != nil {
+		in, out := &in.Ingress, &out.Ingress
+		*out = new(PDDashboardIngressSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PDDashboardSpec.
+func (in *PDDashboardSpec) DeepCopy() *PDDashboardSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PDDashboardSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PDFailureMember) DeepCopyInto(out *PDFailureMember) {
 	*out = *in
@@ -652,6 +893,22 @@ func (in *PDReplicationConfig) DeepCopy() *PDReplicationConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PDScaleInStatus) DeepCopyInto(out *PDScaleInStatus) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PDScaleInStatus.
+func (in *PDScaleInStatus) DeepCopy() *PDScaleInStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PDScaleInStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PDScheduleConfig) DeepCopyInto(out *PDScheduleConfig) {
 	*out = *in
@@ -956,6 +1213,11 @@ func (in *PDSpec) DeepCopyInto(out *PDSpec) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.Dashboard != nil {
+		in, out := &in.Dashboard, &out.Dashboard
+		*out = new(PDDashboardSpec)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -999,6 +1261,16 @@ func (in *PDStatus) DeepCopyInto(out *PDStatus) {
 			(*out)[key] = *val.DeepCopy()
 		}
 	}
+	if in.ScaleIn != nil {
+		in, out := &in.ScaleIn, &out.ScaleIn
+		*out = new(PDScaleInStatus)
+		**out = **in
+	}
+	if in.UpgradingOrdinal != nil {
+		in, out := &in.UpgradingOrdinal, &out.UpgradingOrdinal
+		*out = new(int32)
+		**out = **in
+	}
 	return
 }
 
@@ -1060,6 +1332,37 @@ func (in PDStoreLabels) DeepCopy() PDStoreLabels {
 	return *out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodUpgradeDurations) DeepCopyInto(out *PodUpgradeDurations) {
+	*out = *in
+	if in.ImagePull != nil {
+		in, out := &in.ImagePull, &out.ImagePull
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Startup != nil {
+		in, out := &in.Startup, &out.Startup
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.LeaderEviction != nil {
+		in, out := &in.LeaderEviction, &out.LeaderEviction
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodUpgradeDurations.
+func (in *PodUpgradeDurations) DeepCopy() *PodUpgradeDurations {
+	if in == nil {
+		return nil
+	}
+	out := new(PodUpgradeDurations)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ServiceSpec) DeepCopyInto(out *ServiceSpec) {
 	*out = *in
@@ -1085,6 +1388,11 @@ func (in *ServiceSpec) DeepCopyInto(out *ServiceSpec) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.Port != nil {
+		in, out := &in.Port, &out.Port
+		*out = new(int32)
+		**out = **in
+	}
 	return
 }
 
@@ -1098,6 +1406,27 @@ func (in *ServiceSpec) DeepCopy() *ServiceSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TLSCluster) DeepCopyInto(out *TLSCluster) {
+	*out = *in
+	if in.CertManager != nil {
+		in, out := &in.CertManager, &out.CertManager
+		*out = new(CertManagerTLS)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TLSCluster.
+func (in *TLSCluster) DeepCopy() *TLSCluster {
+	if in == nil {
+		return nil
+	}
+	out := new(TLSCluster)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TiKVBlockCacheConfig) DeepCopyInto(out *TiKVBlockCacheConfig) {
 	*out = *in
@@ -1446,6 +1775,27 @@ func (in *TiKVConfig) DeepCopy() *TiKVConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TiKVConfigLayer) DeepCopyInto(out *TiKVConfigLayer) {
+	*out = *in
+	if in.ConfigRef != nil {
+		in, out := &in.ConfigRef, &out.ConfigRef
+		*out = new(ConfigMapKeyRef)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TiKVConfigLayer.
+func (in *TiKVConfigLayer) DeepCopy() *TiKVConfigLayer {
+	if in == nil {
+		return nil
+	}
+	out := new(TiKVConfigLayer)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TiKVCoprocessorConfig) DeepCopyInto(out *TiKVCoprocessorConfig) {
 	*out = *in
@@ -1705,43 +2055,116 @@ func (in *TiKVDbConfig) DeepCopy() *TiKVDbConfig {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *TiKVEncryptionConfig) DeepCopyInto(out *TiKVEncryptionConfig) {
+func (in *TiKVEncryption) DeepCopyInto(out *TiKVEncryption) {
 	*out = *in
-	if in.Method != nil {
-		in, out := &in.Method, &out.Method
-		*out = new(string)
-		**out = **in
-	}
-	if in.DataKeyRotationPeriod != nil {
-		in, out := &in.DataKeyRotationPeriod, &out.DataKeyRotationPeriod
-		*out = new(string)
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(v1.LocalObjectReference)
 		**out = **in
 	}
-	if in.MasterKey != nil {
-		in, out := &in.MasterKey, &out.MasterKey
-		*out = new(TiKVMasterKeyConfig)
-		(*in).DeepCopyInto(*out)
-	}
-	if in.PreviousMasterKey != nil {
-		in, out := &in.PreviousMasterKey, &out.PreviousMasterKey
-		*out = new(TiKVMasterKeyConfig)
+	if in.KMS != nil {
+		in, out := &in.KMS, &out.KMS
+		*out = new(TiKVEncryptionKMS)
 		(*in).DeepCopyInto(*out)
 	}
 	return
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TiKVEncryptionConfig.
-func (in *TiKVEncryptionConfig) DeepCopy() *TiKVEncryptionConfig {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TiKVEncryption.
+func (in *TiKVEncryption) DeepCopy() *TiKVEncryption {
 	if in == nil {
 		return nil
 	}
-	out := new(TiKVEncryptionConfig)
+	out := new(TiKVEncryption)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *TiKVFailureStore) DeepCopyInto(out *TiKVFailureStore) {
+func (in *TiKVEncryptionKMS) DeepCopyInto(out *TiKVEncryptionKMS) {
+	*out = *in
+	if in.CredentialsSecretRef != nil {
+		in, out := &in.CredentialsSecretRef, &out.CredentialsSecretRef
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TiKVEncryptionKMS.
+func (in *TiKVEncryptionKMS) DeepCopy() *TiKVEncryptionKMS {
+	if in == nil {
+		return nil
+	}
+	out := new(TiKVEncryptionKMS)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TiKVEncryptionConfig) DeepCopyInto(out *TiKVEncryptionConfig) {
+	*out = *in
+	if in.Method != nil {
+		in, out := &in.Method, &out.Method
+		*out = new(string)
+		**out = **in
+	}
+	if in.DataKeyRotationPeriod != nil {
+		in, out := &in.DataKeyRotationPeriod, &out.DataKeyRotationPeriod
+		*out = new(string)
+		**out = **in
+	}
+	if in.MasterKey != nil {
+		in, out := &in.MasterKey, &out.MasterKey
+		*out = new(TiKVMasterKeyConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PreviousMasterKey != nil {
+		in, out := &in.PreviousMasterKey, &out.PreviousMasterKey
+		*out = new(TiKVMasterKeyConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TiKVEncryptionConfig.
+func (in *TiKVEncryptionConfig) DeepCopy() *TiKVEncryptionConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(TiKVEncryptionConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TiKVFailover) DeepCopyInto(out *TiKVFailover) {
+	*out = *in
+	if in.ZoneOutageThreshold != nil {
+		in, out := &in.ZoneOutageThreshold, &out.ZoneOutageThreshold
+		*out = new(int32)
+		**out = **in
+	}
+	if in.ZoneFailoverDeadline != nil {
+		in, out := &in.ZoneFailoverDeadline, &out.ZoneFailoverDeadline
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TiKVFailover.
+func (in *TiKVFailover) DeepCopy() *TiKVFailover {
+	if in == nil {
+		return nil
+	}
+	out := new(TiKVFailover)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TiKVFailureStore) DeepCopyInto(out *TiKVFailureStore) {
 	*out = *in
 	in.CreatedAt.DeepCopyInto(&out.CreatedAt)
 	return
@@ -1867,6 +2290,22 @@ func (in *TiKVMasterKeyConfig) DeepCopy() *TiKVMasterKeyConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TiKVOfflineStore) DeepCopyInto(out *TiKVOfflineStore) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TiKVOfflineStore.
+func (in *TiKVOfflineStore) DeepCopy() *TiKVOfflineStore {
+	if in == nil {
+		return nil
+	}
+	out := new(TiKVOfflineStore)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TiKVPDConfig) DeepCopyInto(out *TiKVPDConfig) {
 	*out = *in
@@ -2357,6 +2796,89 @@ func (in *TiKVReadPoolConfig) DeepCopy() *TiKVReadPoolConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TiKVScaleInStatus) DeepCopyInto(out *TiKVScaleInStatus) {
+	*out = *in
+	if in.Pods != nil {
+		in, out := &in.Pods, &out.Pods
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ConnectionDrainDeadline != nil {
+		in, out := &in.ConnectionDrainDeadline, &out.ConnectionDrainDeadline
+		*out = (*in).DeepCopy()
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TiKVScaleInStatus.
+func (in *TiKVScaleInStatus) DeepCopy() *TiKVScaleInStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TiKVScaleInStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TiKVScaleInStrategy) DeepCopyInto(out *TiKVScaleInStrategy) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TiKVScaleInStrategy.
+func (in *TiKVScaleInStrategy) DeepCopy() *TiKVScaleInStrategy {
+	if in == nil {
+		return nil
+	}
+	out := new(TiKVScaleInStrategy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TiKVScaleOutStrategy) DeepCopyInto(out *TiKVScaleOutStrategy) {
+	*out = *in
+	if in.StepSize != nil {
+		in, out := &in.StepSize, &out.StepSize
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MinRegionCount != nil {
+		in, out := &in.MinRegionCount, &out.MinRegionCount
+		*out = new(int32)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TiKVScaleOutStrategy.
+func (in *TiKVScaleOutStrategy) DeepCopy() *TiKVScaleOutStrategy {
+	if in == nil {
+		return nil
+	}
+	out := new(TiKVScaleOutStrategy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TiKVScalePolicy) DeepCopyInto(out *TiKVScalePolicy) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TiKVScalePolicy.
+func (in *TiKVScalePolicy) DeepCopy() *TiKVScalePolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(TiKVScalePolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TiKVSecurityConfig) DeepCopyInto(out *TiKVSecurityConfig) {
 	*out = *in
@@ -2663,6 +3185,95 @@ func (in *TiKVSpec) DeepCopyInto(out *TiKVSpec) {
 		*out = new(TiKVConfig)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.ConfigRef != nil {
+		in, out := &in.ConfigRef, &out.ConfigRef
+		*out = new(ConfigMapKeyRef)
+		**out = **in
+	}
+	if in.ConfigLayers != nil {
+		in, out := &in.ConfigLayers, &out.ConfigLayers
+		*out = make([]TiKVConfigLayer, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.EvictLeaderTimeout != nil {
+		in, out := &in.EvictLeaderTimeout, &out.EvictLeaderTimeout
+		*out = new(string)
+		**out = **in
+	}
+	if in.UpgradeStallTimeout != nil {
+		in, out := &in.UpgradeStallTimeout, &out.UpgradeStallTimeout
+		*out = new(string)
+		**out = **in
+	}
+	if in.ScaleInPVCRetentionPeriod != nil {
+		in, out := &in.ScaleInPVCRetentionPeriod, &out.ScaleInPVCRetentionPeriod
+		*out = new(string)
+		**out = **in
+	}
+	if in.OfflineStores != nil {
+		in, out := &in.OfflineStores, &out.OfflineStores
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ScaleOut != nil {
+		in, out := &in.ScaleOut, &out.ScaleOut
+		*out = new(TiKVScaleOutStrategy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ScaleIn != nil {
+		in, out := &in.ScaleIn, &out.ScaleIn
+		*out = new(TiKVScaleInStrategy)
+		**out = **in
+	}
+	if in.ScalePolicy != nil {
+		in, out := &in.ScalePolicy, &out.ScalePolicy
+		*out = new(TiKVScalePolicy)
+		**out = **in
+	}
+	if in.StorageMigration != nil {
+		in, out := &in.StorageMigration, &out.StorageMigration
+		*out = new(TiKVStorageMigration)
+		**out = **in
+	}
+	if in.UpgradePartition != nil {
+		in, out := &in.UpgradePartition, &out.UpgradePartition
+		*out = new(int32)
+		**out = **in
+	}
+	if in.WaitDurationBetweenUpgrades != nil {
+		in, out := &in.WaitDurationBetweenUpgrades, &out.WaitDurationBetweenUpgrades
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.UpgradeSafetyCheck != nil {
+		in, out := &in.UpgradeSafetyCheck, &out.UpgradeSafetyCheck
+		*out = new(TiKVUpgradeSafetyCheck)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Failover != nil {
+		in, out := &in.Failover, &out.Failover
+		*out = new(TiKVFailover)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MaxUpgradingPods != nil {
+		in, out := &in.MaxUpgradingPods, &out.MaxUpgradingPods
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Encryption != nil {
+		in, out := &in.Encryption, &out.Encryption
+		*out = new(TiKVEncryption)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AdditionalConfigFiles != nil {
+		in, out := &in.AdditionalConfigFiles, &out.AdditionalConfigFiles
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 	return
 }
 
@@ -2705,6 +3316,48 @@ func (in *TiKVStatus) DeepCopyInto(out *TiKVStatus) {
 			(*out)[key] = *val.DeepCopy()
 		}
 	}
+	if in.OfflineStores != nil {
+		in, out := &in.OfflineStores, &out.OfflineStores
+		*out = make(map[string]TiKVOfflineStore, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.CurrentScaleOutOrdinals != nil {
+		in, out := &in.CurrentScaleOutOrdinals, &out.CurrentScaleOutOrdinals
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+	if in.ScaleIn != nil {
+		in, out := &in.ScaleIn, &out.ScaleIn
+		*out = new(TiKVScaleInStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.UpdatedOrdinals != nil {
+		in, out := &in.UpdatedOrdinals, &out.UpdatedOrdinals
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+	if in.StorageMigration != nil {
+		in, out := &in.StorageMigration, &out.StorageMigration
+		*out = new(TiKVStorageMigrationStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LastUpgradedPod != nil {
+		in, out := &in.LastUpgradedPod, &out.LastUpgradedPod
+		*out = new(UpgradedPod)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.UpgradingOrdinals != nil {
+		in, out := &in.UpgradingOrdinals, &out.UpgradingOrdinals
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+	if in.UpgradingOrdinal != nil {
+		in, out := &in.UpgradingOrdinal, &out.UpgradingOrdinal
+		*out = new(int32)
+		**out = **in
+	}
 	return
 }
 
@@ -2820,11 +3473,51 @@ func (in *TiKVStorageReadPoolConfig) DeepCopy() *TiKVStorageReadPoolConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TiKVStorageMigration) DeepCopyInto(out *TiKVStorageMigration) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TiKVStorageMigration.
+func (in *TiKVStorageMigration) DeepCopy() *TiKVStorageMigration {
+	if in == nil {
+		return nil
+	}
+	out := new(TiKVStorageMigration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TiKVStorageMigrationStatus) DeepCopyInto(out *TiKVStorageMigrationStatus) {
+	*out = *in
+	in.StartTime.DeepCopyInto(&out.StartTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TiKVStorageMigrationStatus.
+func (in *TiKVStorageMigrationStatus) DeepCopy() *TiKVStorageMigrationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TiKVStorageMigrationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TiKVStore) DeepCopyInto(out *TiKVStore) {
 	*out = *in
 	in.LastHeartbeatTime.DeepCopyInto(&out.LastHeartbeatTime)
 	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 	return
 }
 
@@ -2986,6 +3679,32 @@ func (in *TiKVUnifiedReadPoolConfig) DeepCopy() *TiKVUnifiedReadPoolConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TiKVUpgradeSafetyCheck) DeepCopyInto(out *TiKVUpgradeSafetyCheck) {
+	*out = *in
+	if in.MaxDownPeerRegions != nil {
+		in, out := &in.MaxDownPeerRegions, &out.MaxDownPeerRegions
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxPendingPeerRegions != nil {
+		in, out := &in.MaxPendingPeerRegions, &out.MaxPendingPeerRegions
+		*out = new(int32)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TiKVUpgradeSafetyCheck.
+func (in *TiKVUpgradeSafetyCheck) DeepCopy() *TiKVUpgradeSafetyCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(TiKVUpgradeSafetyCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TikvCluster) DeepCopyInto(out *TikvCluster) {
 	*out = *in
@@ -3065,6 +3784,22 @@ func (in *TikvClusterList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TikvClusterRef) DeepCopyInto(out *TikvClusterRef) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TikvClusterRef.
+func (in *TikvClusterRef) DeepCopy() *TikvClusterRef {
+	if in == nil {
+		return nil
+	}
+	out := new(TikvClusterRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TikvClusterSpec) DeepCopyInto(out *TikvClusterSpec) {
 	*out = *in
@@ -3076,6 +3811,26 @@ func (in *TikvClusterSpec) DeepCopyInto(out *TikvClusterSpec) {
 		*out = new(bool)
 		**out = **in
 	}
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = new(ClusterPorts)
+		**out = **in
+	}
+	if in.CloneFrom != nil {
+		in, out := &in.CloneFrom, &out.CloneFrom
+		*out = new(TikvClusterRef)
+		**out = **in
+	}
+	if in.TLSCluster != nil {
+		in, out := &in.TLSCluster, &out.TLSCluster
+		*out = new(TLSCluster)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Monitoring != nil {
+		in, out := &in.Monitoring, &out.Monitoring
+		*out = new(MonitoringSpec)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Affinity != nil {
 		in, out := &in.Affinity, &out.Affinity
 		*out = new(v1.Affinity)
@@ -3107,6 +3862,18 @@ func (in *TikvClusterSpec) DeepCopyInto(out *TikvClusterSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.TopologySpreadConstraints != nil {
+		in, out := &in.TopologySpreadConstraints, &out.TopologySpreadConstraints
+		*out = make([]v1.TopologySpreadConstraint, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.PodSecurityContext != nil {
+		in, out := &in.PodSecurityContext, &out.PodSecurityContext
+		*out = new(v1.PodSecurityContext)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -3125,6 +3892,21 @@ func (in *TikvClusterStatus) DeepCopyInto(out *TikvClusterStatus) {
 	*out = *in
 	in.PD.DeepCopyInto(&out.PD)
 	in.TiKV.DeepCopyInto(&out.TiKV)
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = new(ClusterPorts)
+		**out = **in
+	}
+	if in.UpgradeStatus != nil {
+		in, out := &in.UpgradeStatus, &out.UpgradeStatus
+		*out = new(UpgradeStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Clone != nil {
+		in, out := &in.Clone, &out.Clone
+		*out = new(CloneStatus)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
 		*out = make([]TikvClusterCondition, len(*in))
@@ -3161,3 +3943,64 @@ func (in *UnjoinedMember) DeepCopy() *UnjoinedMember {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpgradeDurationSummary) DeepCopyInto(out *UpgradeDurationSummary) {
+	*out = *in
+	out.P50 = in.P50
+	out.P95 = in.P95
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpgradeDurationSummary.
+func (in *UpgradeDurationSummary) DeepCopy() *UpgradeDurationSummary {
+	if in == nil {
+		return nil
+	}
+	out := new(UpgradeDurationSummary)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpgradeStatus) DeepCopyInto(out *UpgradeStatus) {
+	*out = *in
+	if in.PD != nil {
+		in, out := &in.PD, &out.PD
+		*out = new(ComponentUpgradeStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TiKV != nil {
+		in, out := &in.TiKV, &out.TiKV
+		*out = new(ComponentUpgradeStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpgradeStatus.
+func (in *UpgradeStatus) DeepCopy() *UpgradeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(UpgradeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpgradedPod) DeepCopyInto(out *UpgradedPod) {
+	*out = *in
+	in.ReadyTime.DeepCopyInto(&out.ReadyTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpgradedPod.
+func (in *UpgradedPod) DeepCopy() *UpgradedPod {
+	if in == nil {
+		return nil
+	}
+	out := new(UpgradedPod)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -27,6 +27,7 @@ import (
 	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
@@ -55,6 +56,8 @@ type TypedControlInterface interface {
 	CreateOrUpdatePVC(controller runtime.Object, pvc *corev1.PersistentVolumeClaim, setOwnerFlag bool) (*corev1.PersistentVolumeClaim, error)
 	// CreateOrUpdateIngress create the desired ingress or update the current one to desired state if already existed
 	CreateOrUpdateIngress(controller runtime.Object, ingress *extensionsv1beta1.Ingress) (*extensionsv1beta1.Ingress, error)
+	// CreateOrUpdateCertificate create the desired cert-manager certificate or update the current one to desired state if already existed
+	CreateOrUpdateCertificate(controller runtime.Object, cert *unstructured.Unstructured) (*unstructured.Unstructured, error)
 	// UpdateStatus update the /status subresource of the object
 	UpdateStatus(newStatus runtime.Object) error
 	// Delete delete the given object from the cluster
@@ -136,6 +139,21 @@ func (w *typedWrapper) CreateOrUpdateSecret(controller runtime.Object, secret *c
 	return result.(*corev1.Secret), nil
 }
 
+func (w *typedWrapper) CreateOrUpdateCertificate(controller runtime.Object, cert *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	result, err := w.GenericControlInterface.CreateOrUpdate(controller, cert, func(existing, desired runtime.Object) error {
+		existingCert := existing.(*unstructured.Unstructured)
+		desiredCert := desired.(*unstructured.Unstructured)
+
+		existingCert.SetLabels(desiredCert.GetLabels())
+		existingCert.Object["spec"] = desiredCert.Object["spec"]
+		return nil
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+	return result.(*unstructured.Unstructured), nil
+}
+
 func (w *typedWrapper) Delete(controller, obj runtime.Object) error {
 	return w.GenericControlInterface.Delete(controller, obj)
 }
@@ -233,8 +251,11 @@ func (w *typedWrapper) CreateOrUpdateConfigMap(controller runtime.Object, cm *co
 		existingCm := existing.(*corev1.ConfigMap)
 		desiredCm := desired.(*corev1.ConfigMap)
 
-		existingCm.Data = desiredCm.Data
+		existingCm.Data = MergeConfigMapData(existingCm, desiredCm)
 		existingCm.Labels = desiredCm.Labels
+		if existingCm.Annotations == nil {
+			existingCm.Annotations = map[string]string{}
+		}
 		for k, v := range desiredCm.Annotations {
 			existingCm.Annotations[k] = v
 		}
@@ -0,0 +1,139 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apiserver/pkg/server/healthz"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// TikvClusterInformer is the name of the tikvcluster informer
+	TikvClusterInformer = "tikvcluster"
+	// StatefulSetInformer is the name of the statefulset informer
+	StatefulSetInformer = "statefulset"
+	// ServiceInformer is the name of the service informer
+	ServiceInformer = "service"
+	// ConfigMapInformer is the name of the configmap informer
+	ConfigMapInformer = "configmap"
+	// EndpointsInformer is the name of the endpoints informer
+	EndpointsInformer = "endpoints"
+	// NodeInformer is the name of the node informer
+	NodeInformer = "node"
+	// PodInformer is the name of the pod informer
+	PodInformer = "pod"
+	// PVCInformer is the name of the persistentvolumeclaim informer
+	PVCInformer = "pvc"
+	// PVInformer is the name of the persistentvolume informer
+	PVInformer = "pv"
+)
+
+// RequiredInformers are the informers whose caches must be synced before reconciling any cluster,
+// the caches of the other informers, e.g. pods and pvcs, may take minutes to sync in large clusters
+var RequiredInformers = []string{TikvClusterInformer, StatefulSetInformer, ServiceInformer, ConfigMapInformer, EndpointsInformer}
+
+// InformerSyncStatus tracks whether the caches of informers are synced, so that clusters can
+// be reconciled before all caches are warmed up, deferring only the decisions which rely on
+// the caches not synced yet. Informers not tracked are considered synced.
+type InformerSyncStatus struct {
+	lock   sync.RWMutex
+	synced map[string]cache.InformerSynced
+}
+
+// NewInformerSyncStatus returns an InformerSyncStatus tracking no informer
+func NewInformerSyncStatus() *InformerSyncStatus {
+	return &InformerSyncStatus{synced: map[string]cache.InformerSynced{}}
+}
+
+// Add tracks the informer by name
+func (s *InformerSyncStatus) Add(name string, hasSynced cache.InformerSynced) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.synced[name] = hasSynced
+}
+
+// HasSynced returns true if the caches of all the named informers are synced
+func (s *InformerSyncStatus) HasSynced(names ...string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, name := range names {
+		if hasSynced, ok := s.synced[name]; ok && !hasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// NotSynced returns the sorted names of the informers whose caches are not synced yet
+func (s *InformerSyncStatus) NotSynced() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var names []string
+	for name, hasSynced := range s.synced {
+		if !hasSynced() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WaitForCacheSync waits for the caches of the named informers, or all the informers if no
+// name is given, to be synced, it returns false if they are not synced before the timeout or
+// the stop channel is closed. A zero timeout waits until the stop channel is closed.
+func (s *InformerSyncStatus) WaitForCacheSync(stopCh <-chan struct{}, timeout time.Duration, names ...string) bool {
+	if timeout > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		mergedCh := make(chan struct{})
+		go func() {
+			defer close(mergedCh)
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			select {
+			case <-stopCh:
+			case <-timer.C:
+			case <-done:
+			}
+		}()
+		stopCh = mergedCh
+	}
+	return cache.WaitForCacheSync(stopCh, func() bool {
+		if len(names) == 0 {
+			return len(s.NotSynced()) == 0
+		}
+		return s.HasSynced(names...)
+	})
+}
+
+// Checks returns a readiness check for each of the named informers
+func (s *InformerSyncStatus) Checks(names ...string) []healthz.HealthChecker {
+	var checks []healthz.HealthChecker
+	for _, name := range names {
+		name := name
+		checks = append(checks, healthz.NamedCheck("informer-"+name, func(_ *http.Request) error {
+			if !s.HasSynced(name) {
+				return fmt.Errorf("cache of the %s informer is not synced", name)
+			}
+			return nil
+		}))
+	}
+	return checks
+}
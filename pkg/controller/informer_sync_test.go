@@ -0,0 +1,53 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestInformerSyncStatus(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	var podSynced int32
+	s := NewInformerSyncStatus()
+	s.Add(TikvClusterInformer, func() bool { return true })
+	s.Add(StatefulSetInformer, func() bool { return true })
+	s.Add(PodInformer, func() bool { return atomic.LoadInt32(&podSynced) == 1 })
+
+	g.Expect(s.HasSynced(TikvClusterInformer, StatefulSetInformer)).To(BeTrue())
+	g.Expect(s.HasSynced(TikvClusterInformer, PodInformer)).To(BeFalse())
+	// informers not tracked are considered synced
+	g.Expect(s.HasSynced(PVCInformer)).To(BeTrue())
+	g.Expect(s.NotSynced()).To(Equal([]string{PodInformer}))
+
+	checks := s.Checks(TikvClusterInformer, PodInformer)
+	g.Expect(checks).To(HaveLen(2))
+	g.Expect(checks[0].Name()).To(Equal("informer-tikvcluster"))
+	g.Expect(checks[0].Check(nil)).To(Succeed())
+	g.Expect(checks[1].Check(nil)).NotTo(Succeed())
+
+	// the slow pod informer does not block waiting for the other caches
+	g.Expect(s.WaitForCacheSync(nil, 0, TikvClusterInformer, StatefulSetInformer)).To(BeTrue())
+	g.Expect(s.WaitForCacheSync(nil, 200*time.Millisecond)).To(BeFalse())
+
+	atomic.StoreInt32(&podSynced, 1)
+	g.Expect(s.WaitForCacheSync(nil, time.Second)).To(BeTrue())
+	g.Expect(s.NotSynced()).To(BeEmpty())
+	g.Expect(checks[1].Check(nil)).To(Succeed())
+}
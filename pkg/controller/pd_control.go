@@ -20,7 +20,7 @@ import (
 
 // GetPDClient gets the pd client from the TikvCluster
 func GetPDClient(pdControl pdapi.PDControlInterface, tc *v1alpha1.TikvCluster) pdapi.PDClient {
-	return pdControl.GetPDClient(pdapi.Namespace(tc.GetNamespace()), tc.GetName(), tc.IsTLSClusterEnabled())
+	return pdControl.GetPDClient(pdapi.Namespace(tc.GetNamespace()), tc.GetName(), PDClientURL(tc), tc.IsTLSClusterEnabled())
 }
 
 // NewFakePDClient creates a fake pdclient that is set as the pd client
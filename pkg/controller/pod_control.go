@@ -108,7 +108,7 @@ func (rpc *realPodControl) UpdateMetaInfo(tc *v1alpha1.TikvCluster, pod *corev1.
 	memberID := labels[label.MemberIDLabelKey]
 	storeID := labels[label.StoreIDLabelKey]
 
-	pdClient := rpc.pdControl.GetPDClient(pdapi.Namespace(tc.GetNamespace()), tcName, tc.IsTLSClusterEnabled())
+	pdClient := GetPDClient(rpc.pdControl, tc)
 	if labels[label.ClusterIDLabelKey] == "" {
 		cluster, err := pdClient.GetCluster()
 		if err != nil {
@@ -122,7 +122,7 @@ func (rpc *realPodControl) UpdateMetaInfo(tc *v1alpha1.TikvCluster, pod *corev1.
 	case label.PDLabelVal:
 		if labels[label.MemberIDLabelKey] == "" {
 			// get member id
-			members, err := pdClient.GetMembers()
+			members, err := pdClient.GetMembers(pdapi.NoCache)
 			if err != nil {
 				return pod, fmt.Errorf("failed to get pd members info from pd, TikvCluster: %s/%s, err: %v", ns, tcName, err)
 			}
@@ -136,7 +136,7 @@ func (rpc *realPodControl) UpdateMetaInfo(tc *v1alpha1.TikvCluster, pod *corev1.
 	case label.TiKVLabelVal:
 		if labels[label.StoreIDLabelKey] == "" {
 			// get store id
-			stores, err := pdClient.GetStores()
+			stores, err := pdClient.GetStores(pdapi.NoCache)
 			if err != nil {
 				return pod, fmt.Errorf("failed to get tikv stores info from pd, TikvCluster: %s/%s, err: %v", ns, tcName, err)
 			}
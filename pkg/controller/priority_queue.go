@@ -0,0 +1,251 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// QueueKind is one of the two queues of a PriorityQueue
+type QueueKind string
+
+const (
+	// SpecQueue holds keys of objects whose spec changed, they are synced first
+	SpecQueue QueueKind = "spec"
+	// AuditQueue holds keys enqueued by periodic resyncs and status changes
+	AuditQueue QueueKind = "audit"
+
+	// inFlightRetryDelay is how long a spec key waits when the same key is being synced by an audit worker
+	inFlightRetryDelay = 100 * time.Millisecond
+)
+
+var (
+	queueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "tikv_operator",
+			Subsystem: "workqueue",
+			Name:      "depth",
+			Help:      "Number of keys waiting in the queue.",
+		}, []string{"name"})
+	queueOldestAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "tikv_operator",
+			Subsystem: "workqueue",
+			Name:      "oldest_key_age_seconds",
+			Help:      "How long the oldest key has been waiting in the queue.",
+		}, []string{"name"})
+)
+
+func init() {
+	prometheus.MustRegister(queueDepth, queueOldestAge)
+}
+
+// PriorityQueue is a rate limited work queue made of a spec queue and an audit queue,
+// so that a wave of audits can not delay syncing user visible spec changes.
+// A key pending in the spec queue supersedes its twin in the audit queue, and a key is
+// never processed by both queues at the same time.
+type PriorityQueue struct {
+	queues map[QueueKind]workqueue.RateLimitingInterface
+	names  map[QueueKind]string
+
+	lock sync.Mutex
+	// pending records when the keys waiting in each queue were added
+	pending map[QueueKind]map[interface{}]time.Time
+	// inFlight records which queue a key being processed comes from
+	inFlight map[interface{}]QueueKind
+}
+
+// NewPriorityQueue returns a PriorityQueue, name is used for the spec queue
+// and name-audit for the audit queue.
+func NewPriorityQueue(name string) *PriorityQueue {
+	names := map[QueueKind]string{
+		SpecQueue:  name,
+		AuditQueue: name + "-audit",
+	}
+	q := &PriorityQueue{
+		queues:   map[QueueKind]workqueue.RateLimitingInterface{},
+		names:    names,
+		pending:  map[QueueKind]map[interface{}]time.Time{},
+		inFlight: map[interface{}]QueueKind{},
+	}
+	for kind, n := range names {
+		q.queues[kind] = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), n)
+		q.pending[kind] = map[interface{}]time.Time{}
+	}
+	return q
+}
+
+// Add adds the key to the spec queue, dropping its twin pending in the audit queue
+func (q *PriorityQueue) Add(key interface{}) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delete(q.pending[AuditQueue], key)
+	q.markPending(SpecQueue, key)
+	q.queues[SpecQueue].Add(key)
+}
+
+// AddAudit adds the key to the audit queue, unless it is already pending in the spec queue
+func (q *PriorityQueue) AddAudit(key interface{}) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if _, ok := q.pending[SpecQueue][key]; ok {
+		return
+	}
+	q.markPending(AuditQueue, key)
+	q.queues[AuditQueue].Add(key)
+}
+
+// AddAuditAfter adds the key to the audit queue after the delay, unless it is pending in the
+// spec queue by then
+func (q *PriorityQueue) AddAuditAfter(key interface{}, delay time.Duration) {
+	if delay <= 0 {
+		q.AddAudit(key)
+		return
+	}
+	time.AfterFunc(delay, func() {
+		q.AddAudit(key)
+	})
+}
+
+// AddRateLimited adds the key back to the queue it came from after the rate limiter says it's ok
+func (q *PriorityQueue) AddRateLimited(kind QueueKind, key interface{}) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if kind == AuditQueue {
+		if _, ok := q.pending[SpecQueue][key]; ok {
+			return
+		}
+	}
+	q.markPending(kind, key)
+	q.queues[kind].AddRateLimited(key)
+}
+
+// AddAfter adds the key back to the queue it came from after the delay
+func (q *PriorityQueue) AddAfter(kind QueueKind, key interface{}, delay time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if kind == AuditQueue {
+		if _, ok := q.pending[SpecQueue][key]; ok {
+			return
+		}
+	}
+	q.markPending(kind, key)
+	q.queues[kind].AddAfter(key, delay)
+}
+
+// Get blocks until a key can be processed from the given queue, audit keys
+// superseded by the spec queue are skipped. The caller must call Done with the
+// same kind when it finishes processing the key.
+func (q *PriorityQueue) Get(kind QueueKind) (interface{}, bool) {
+	for {
+		key, quit := q.queues[kind].Get()
+		if quit {
+			return nil, true
+		}
+		if q.startProcessing(kind, key) {
+			return key, false
+		}
+		q.queues[kind].Done(key)
+	}
+}
+
+// Done marks the key as done processing in the given queue
+func (q *PriorityQueue) Done(kind QueueKind, key interface{}) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.inFlight[key] == kind {
+		delete(q.inFlight, key)
+	}
+	q.queues[kind].Done(key)
+}
+
+// Forget indicates that the key is finished being retried in the given queue
+func (q *PriorityQueue) Forget(kind QueueKind, key interface{}) {
+	q.queues[kind].Forget(key)
+}
+
+// Len returns the number of keys waiting in the given queue
+func (q *PriorityQueue) Len(kind QueueKind) int {
+	return q.queues[kind].Len()
+}
+
+// ShutDown shuts down both queues
+func (q *PriorityQueue) ShutDown() {
+	for _, queue := range q.queues {
+		queue.ShutDown()
+	}
+}
+
+// startProcessing tells whether a key got from the given queue should be processed
+func (q *PriorityQueue) startProcessing(kind QueueKind, key interface{}) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	defer q.recordMetrics()
+
+	_, pending := q.pending[kind][key]
+	delete(q.pending[kind], key)
+	other, inFlight := q.inFlight[key]
+	switch {
+	case kind == AuditQueue && !pending:
+		// superseded by the spec queue
+		q.queues[kind].Forget(key)
+		return false
+	case kind == AuditQueue && inFlight:
+		// being synced by a spec worker which covers the audit
+		q.queues[kind].Forget(key)
+		return false
+	case kind == SpecQueue && inFlight && other == AuditQueue:
+		// wait for the audit worker instead of syncing the same key concurrently
+		q.markPending(kind, key)
+		q.queues[kind].AddAfter(key, inFlightRetryDelay)
+		return false
+	}
+	q.inFlight[key] = kind
+	return true
+}
+
+// markPending records the time the key is added to the queue, the lock must be held
+func (q *PriorityQueue) markPending(kind QueueKind, key interface{}) {
+	if _, ok := q.pending[kind][key]; !ok {
+		q.pending[kind][key] = time.Now()
+	}
+	q.recordMetrics()
+}
+
+// UpdateMetrics refreshes the depth and age metrics of both queues, the age
+// only grows while nothing is added or got so it has to be refreshed periodically.
+func (q *PriorityQueue) UpdateMetrics() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.recordMetrics()
+}
+
+// recordMetrics exposes the depth and age of both queues, the lock must be held
+func (q *PriorityQueue) recordMetrics() {
+	now := time.Now()
+	for kind, pending := range q.pending {
+		var age time.Duration
+		for _, added := range pending {
+			if d := now.Sub(added); d > age {
+				age = d
+			}
+		}
+		queueDepth.WithLabelValues(q.names[kind]).Set(float64(len(pending)))
+		queueOldestAge.WithLabelValues(q.names[kind]).Set(age.Seconds())
+	}
+}
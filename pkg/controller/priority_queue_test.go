@@ -0,0 +1,128 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestPriorityQueueSpecSupersedesAudit(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	q := NewPriorityQueue("test-supersede")
+	defer q.ShutDown()
+
+	q.AddAudit("a")
+	q.Add("a")
+	q.AddAudit("b")
+
+	key, quit := q.Get(SpecQueue)
+	g.Expect(quit).To(BeFalse())
+	g.Expect(key).To(Equal("a"))
+	q.Done(SpecQueue, key)
+
+	// the audit twin of a is skipped
+	key, quit = q.Get(AuditQueue)
+	g.Expect(quit).To(BeFalse())
+	g.Expect(key).To(Equal("b"))
+	q.Done(AuditQueue, key)
+	g.Expect(q.Len(AuditQueue)).To(Equal(0))
+}
+
+func TestPriorityQueueAuditIgnoredWhenSpecPending(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	q := NewPriorityQueue("test-ignored")
+	defer q.ShutDown()
+
+	q.Add("a")
+	q.AddAudit("a")
+	g.Expect(q.Len(SpecQueue)).To(Equal(1))
+	g.Expect(q.Len(AuditQueue)).To(Equal(0))
+
+	key, _ := q.Get(SpecQueue)
+	q.AddRateLimited(SpecQueue, key)
+	q.Done(SpecQueue, key)
+	q.AddRateLimited(AuditQueue, key)
+	g.Expect(q.Len(AuditQueue)).To(Equal(0))
+}
+
+func TestPriorityQueueNoConcurrentProcessing(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	q := NewPriorityQueue("test-concurrent")
+	defer q.ShutDown()
+
+	// an audit key being synced by a spec worker is dropped
+	q.Add("a")
+	key, _ := q.Get(SpecQueue)
+	g.Expect(key).To(Equal("a"))
+	q.AddAudit("a")
+	q.AddAudit("b")
+	key, _ = q.Get(AuditQueue)
+	g.Expect(key).To(Equal("b"))
+	q.Done(SpecQueue, "a")
+	q.Done(AuditQueue, "b")
+
+	// a spec key being synced by an audit worker waits for it
+	q.AddAudit("c")
+	key, _ = q.Get(AuditQueue)
+	g.Expect(key).To(Equal("c"))
+	q.Add("c")
+	q.Add("d")
+	key, _ = q.Get(SpecQueue)
+	g.Expect(key).To(Equal("d"))
+	q.Done(SpecQueue, key)
+	q.Done(AuditQueue, "c")
+	key, _ = q.Get(SpecQueue)
+	g.Expect(key).To(Equal("c"))
+	q.Done(SpecQueue, key)
+}
+
+func TestPriorityQueueMetrics(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	q := NewPriorityQueue("test-metrics")
+	defer q.ShutDown()
+
+	gauge := func(name string) float64 {
+		m := &dto.Metric{}
+		g.Expect(queueDepth.WithLabelValues(name).Write(m)).To(Succeed())
+		return m.GetGauge().GetValue()
+	}
+	age := func(name string) float64 {
+		m := &dto.Metric{}
+		g.Expect(queueOldestAge.WithLabelValues(name).Write(m)).To(Succeed())
+		return m.GetGauge().GetValue()
+	}
+
+	q.Add("a")
+	q.AddAudit("b")
+	q.AddAudit("c")
+	g.Expect(gauge("test-metrics")).To(Equal(float64(1)))
+	g.Expect(gauge("test-metrics-audit")).To(Equal(float64(2)))
+
+	time.Sleep(10 * time.Millisecond)
+	q.UpdateMetrics()
+	g.Expect(age("test-metrics-audit")).To(BeNumerically(">=", 0.01))
+
+	key, _ := q.Get(SpecQueue)
+	q.Done(SpecQueue, key)
+	g.Expect(gauge("test-metrics")).To(Equal(float64(0)))
+	g.Expect(age("test-metrics")).To(Equal(float64(0)))
+}
@@ -37,6 +37,7 @@ type PVCControlInterface interface {
 	UpdateMetaInfo(*v1alpha1.TikvCluster, *corev1.PersistentVolumeClaim, *corev1.Pod) (*corev1.PersistentVolumeClaim, error)
 	UpdatePVC(*v1alpha1.TikvCluster, *corev1.PersistentVolumeClaim) (*corev1.PersistentVolumeClaim, error)
 	DeletePVC(*v1alpha1.TikvCluster, *corev1.PersistentVolumeClaim) error
+	RemovePVCFinalizer(*v1alpha1.TikvCluster, *corev1.PersistentVolumeClaim, string) error
 	GetPVC(name, namespace string) (*corev1.PersistentVolumeClaim, error)
 }
 
@@ -106,6 +107,50 @@ func (rpc *realPVCControl) UpdatePVC(tc *v1alpha1.TikvCluster, pvc *corev1.Persi
 	return updatePVC, err
 }
 
+// RemovePVCFinalizer removes the finalizer from the pvc, e.g. to release a pvc stuck terminating
+func (rpc *realPVCControl) RemovePVCFinalizer(tc *v1alpha1.TikvCluster, pvc *corev1.PersistentVolumeClaim, finalizer string) error {
+	ns := tc.GetNamespace()
+	tcName := tc.GetName()
+	pvcName := pvc.GetName()
+
+	// make a copy so we don't mutate the shared cache
+	pvc = pvc.DeepCopy()
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		finalizers := removeString(pvc.Finalizers, finalizer)
+		if len(finalizers) == len(pvc.Finalizers) {
+			return nil
+		}
+		pvc.Finalizers = finalizers
+		_, updateErr := rpc.kubeCli.CoreV1().PersistentVolumeClaims(ns).Update(pvc)
+		if updateErr == nil {
+			klog.Infof("remove finalizer %s of PVC: [%s/%s] successfully, TikvCluster: %s", finalizer, ns, pvcName, tcName)
+			return nil
+		}
+		klog.Errorf("failed to remove finalizer %s of PVC: [%s/%s], TikvCluster: %s, error: %v", finalizer, ns, pvcName, tcName, updateErr)
+
+		if updated, err := rpc.pvcLister.PersistentVolumeClaims(ns).Get(pvcName); err == nil {
+			pvc = updated.DeepCopy()
+		} else {
+			utilruntime.HandleError(fmt.Errorf("error getting updated PVC %s/%s from lister: %v", ns, pvcName, err))
+		}
+
+		return updateErr
+	})
+	rpc.recordPVCEvent("remove finalizer", tc, pvcName, err)
+	return err
+}
+
+// removeString returns the strings without the given one
+func removeString(strs []string, str string) []string {
+	var result []string
+	for _, s := range strs {
+		if s != str {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
 func (rpc *realPVCControl) UpdateMetaInfo(tc *v1alpha1.TikvCluster, pvc *corev1.PersistentVolumeClaim, pod *corev1.Pod) (*corev1.PersistentVolumeClaim, error) {
 	ns := tc.GetNamespace()
 	tcName := tc.GetName()
@@ -230,6 +275,19 @@ func (fpc *FakePVCControl) UpdatePVC(_ *v1alpha1.TikvCluster, pvc *corev1.Persis
 	return pvc, fpc.PVCIndexer.Update(pvc)
 }
 
+// RemovePVCFinalizer removes the finalizer from the pvc
+func (fpc *FakePVCControl) RemovePVCFinalizer(_ *v1alpha1.TikvCluster, pvc *corev1.PersistentVolumeClaim, finalizer string) error {
+	defer fpc.updatePVCTracker.Inc()
+	if fpc.updatePVCTracker.ErrorReady() {
+		defer fpc.updatePVCTracker.Reset()
+		return fpc.updatePVCTracker.GetError()
+	}
+
+	pvc = pvc.DeepCopy()
+	pvc.Finalizers = removeString(pvc.Finalizers, finalizer)
+	return fpc.PVCIndexer.Update(pvc)
+}
+
 // UpdateMetaInfo updates the meta info of pvc
 func (fpc *FakePVCControl) UpdateMetaInfo(_ *v1alpha1.TikvCluster, pvc *corev1.PersistentVolumeClaim, pod *corev1.Pod) (*corev1.PersistentVolumeClaim, error) {
 	defer fpc.updatePVCTracker.Inc()
@@ -0,0 +1,107 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// RelistSpreadWindow is how long the objects re-delivered by a relist of an informer are spread
+// over before being enqueued, so that a relist of all the informers, e.g. during an upgrade of
+// kube-apiserver, does not make all the clusters hit PD at once. 0 disables spreading.
+var RelistSpreadWindow = time.Minute
+
+var informerRelists = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "tikv_operator",
+		Subsystem: "informer",
+		Name:      "relists_total",
+		Help:      "Number of full relists of the informers detected, by resource.",
+	}, []string{"resource"})
+
+func init() {
+	prometheus.MustRegister(informerRelists)
+}
+
+// RelistDetector detects the full relists of an informer from the updates it delivers.
+// Periodic resyncs deliver the object held by the informer's store as both old and cur, and
+// watch events always bump the resource version, while a relist replaces the store with freshly
+// decoded objects, so an update carrying a new object of an unchanged resource version is an
+// object re-delivered by a relist.
+type RelistDetector struct {
+	resource string
+	window   time.Duration
+	// onRelist is called once when a relist begins
+	onRelist func()
+
+	lock sync.Mutex
+	// until is when the relist being spread is considered over
+	until time.Time
+	now   func() time.Time
+}
+
+// NewRelistDetector returns a RelistDetector of the informer of the resource, the re-delivered
+// objects are spread over window
+func NewRelistDetector(resource string, window time.Duration, onRelist func()) *RelistDetector {
+	return &RelistDetector{
+		resource: resource,
+		window:   window,
+		onRelist: onRelist,
+		now:      time.Now,
+	}
+}
+
+// Observe is called with each update delivered by the informer, it returns whether the update
+// is an object re-delivered by a relist and the random delay to enqueue it with
+func (d *RelistDetector) Observe(old, cur interface{}) (time.Duration, bool) {
+	if d.window <= 0 || !isRelisted(old, cur) {
+		return 0, false
+	}
+
+	d.lock.Lock()
+	now := d.now()
+	begins := !now.Before(d.until)
+	if begins {
+		d.until = now.Add(d.window)
+	}
+	d.lock.Unlock()
+
+	if begins {
+		informerRelists.WithLabelValues(d.resource).Inc()
+		klog.Infof("relist of %s detected, spreading the re-delivered objects over %s", d.resource, d.window)
+		if d.onRelist != nil {
+			d.onRelist()
+		}
+	}
+	return time.Duration(rand.Int63n(int64(d.window))), true
+}
+
+// isRelisted returns whether cur is a new object of the same resource version as old
+func isRelisted(old, cur interface{}) bool {
+	if old == cur {
+		return false
+	}
+	oldMeta, oldOk := old.(metav1.Object)
+	curMeta, curOk := cur.(metav1.Object)
+	if !oldOk || !curOk {
+		return false
+	}
+	return oldMeta.GetResourceVersion() == curMeta.GetResourceVersion()
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+func relistCount(g *GomegaWithT, resource string) float64 {
+	m := &dto.Metric{}
+	g.Expect(informerRelists.WithLabelValues(resource).Write(m)).To(Succeed())
+	return m.GetCounter().GetValue()
+}
+
+func TestRelistDetector(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	now := time.Now()
+	onRelist := 0
+	d := NewRelistDetector("relist-detector-test", time.Minute, func() { onRelist++ })
+	d.now = func() time.Time { return now }
+	relists := relistCount(g, "relist-detector-test")
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "pod", ResourceVersion: "1"}}
+	// periodic resyncs deliver the same object
+	_, relisted := d.Observe(pod, pod)
+	g.Expect(relisted).To(BeFalse())
+	// watch events bump the resource version
+	updated := pod.DeepCopy()
+	updated.ResourceVersion = "2"
+	_, relisted = d.Observe(pod, updated)
+	g.Expect(relisted).To(BeFalse())
+	g.Expect(onRelist).To(Equal(0))
+
+	for i := 0; i < 10; i++ {
+		delay, relisted := d.Observe(pod, pod.DeepCopy())
+		g.Expect(relisted).To(BeTrue())
+		g.Expect(delay).To(BeNumerically("<", time.Minute))
+	}
+	g.Expect(onRelist).To(Equal(1))
+	g.Expect(relistCount(g, "relist-detector-test") - relists).To(Equal(float64(1)))
+
+	// another relist begins once the spread window is over
+	now = now.Add(time.Minute)
+	_, relisted = d.Observe(pod, pod.DeepCopy())
+	g.Expect(relisted).To(BeTrue())
+	g.Expect(onRelist).To(Equal(2))
+	g.Expect(relistCount(g, "relist-detector-test") - relists).To(Equal(float64(2)))
+
+	// a zero window disables spreading
+	d = NewRelistDetector("relist-detector-test", 0, nil)
+	_, relisted = d.Observe(pod, pod.DeepCopy())
+	g.Expect(relisted).To(BeFalse())
+}
+
+func TestRelistDetectorSpreadsInformerReplace(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	const count = 500
+	window := 2 * time.Second
+
+	watchers := make(chan *watch.FakeWatcher, 10)
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			list := &corev1.PodList{ListMeta: metav1.ListMeta{ResourceVersion: "1"}}
+			for i := 0; i < count; i++ {
+				list.Items = append(list.Items, corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: fmt.Sprintf("pod-%d", i), ResourceVersion: "1"},
+				})
+			}
+			return list, nil
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			w := watch.NewFake()
+			watchers <- w
+			return w, nil
+		},
+	}
+	informer := cache.NewSharedIndexInformer(lw, &corev1.Pod{}, 0, cache.Indexers{})
+
+	q := NewPriorityQueue("relist-test")
+	defer q.ShutDown()
+	d := NewRelistDetector("relist-informer-test", window, nil)
+	var lock sync.Mutex
+	var delays []time.Duration
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, cur interface{}) {
+			delay, relisted := d.Observe(old, cur)
+			if !relisted {
+				return
+			}
+			key, _ := cache.MetaNamespaceKeyFunc(cur)
+			q.AddAuditAfter(key, delay)
+			lock.Lock()
+			defer lock.Unlock()
+			delays = append(delays, delay)
+		},
+	})
+	relists := relistCount(g, "relist-informer-test")
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go informer.Run(stopCh)
+	g.Expect(cache.WaitForCacheSync(stopCh, informer.HasSynced)).To(BeTrue())
+
+	// the watch is closed, the reflector relists and replaces the store with the same objects
+	(<-watchers).Stop()
+	g.Eventually(func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(delays)
+	}, 10*time.Second).Should(Equal(count))
+	g.Expect(relistCount(g, "relist-informer-test") - relists).To(Equal(float64(1)))
+
+	// the enqueues are staggered over the window instead of all at once
+	g.Expect(q.Len(AuditQueue)).To(BeNumerically("<", count/2))
+	g.Eventually(func() int { return q.Len(AuditQueue) }, 2*window).Should(Equal(count))
+
+	lock.Lock()
+	defer lock.Unlock()
+	early := 0
+	for _, delay := range delays {
+		g.Expect(delay).To(BeNumerically("<", window))
+		if delay < window/2 {
+			early++
+		}
+	}
+	g.Expect(early).To(BeNumerically(">", count/4))
+	g.Expect(early).To(BeNumerically("<", count*3/4))
+}
@@ -0,0 +1,51 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+// RequeueScheduler computes the exponential backoff of the keys failing to sync, for the
+// managers waiting on a component, e.g. PD being unavailable, which requeue with
+// RequeueErrorAfter rather than the rate limiter of the queue shared by all failures. The delay
+// of a key doubles from base on every call of Next up to max, until the key is forgotten.
+// It is safe for concurrent use.
+type RequeueScheduler struct {
+	limiter workqueue.RateLimiter
+}
+
+// NewRequeueScheduler returns a RequeueScheduler whose delays start at base and are capped at max
+func NewRequeueScheduler(base, max time.Duration) *RequeueScheduler {
+	return &RequeueScheduler{
+		limiter: workqueue.NewItemExponentialFailureRateLimiter(base, max),
+	}
+}
+
+// Next records a failure of the key and returns how long to wait before syncing it again
+func (s *RequeueScheduler) Next(key string) time.Duration {
+	return s.limiter.When(key)
+}
+
+// Forget resets the backoff of the key, it is called once the key is synced
+func (s *RequeueScheduler) Forget(key string) {
+	s.limiter.Forget(key)
+}
+
+// Retries returns the number of failures of the key recorded since it was last forgotten
+func (s *RequeueScheduler) Retries(key string) int {
+	return s.limiter.NumRequeues(key)
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestRequeueSchedulerNext(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	s := NewRequeueScheduler(100*time.Millisecond, time.Second)
+	var delays []time.Duration
+	for i := 0; i < 6; i++ {
+		delays = append(delays, s.Next("a"))
+	}
+	g.Expect(delays).To(Equal([]time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}))
+	g.Expect(s.Retries("a")).To(Equal(6))
+
+	// the keys back off independently
+	g.Expect(s.Next("b")).To(Equal(100 * time.Millisecond))
+
+	// the backoff starts over once the key is forgotten
+	s.Forget("a")
+	g.Expect(s.Retries("a")).To(Equal(0))
+	g.Expect(s.Next("a")).To(Equal(100 * time.Millisecond))
+	g.Expect(s.Next("b")).To(Equal(200 * time.Millisecond))
+}
+
+func TestRequeueSchedulerConcurrent(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	s := NewRequeueScheduler(time.Millisecond, time.Hour)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				s.Next("a")
+			}
+		}()
+	}
+	wg.Wait()
+	g.Expect(s.Retries("a")).To(Equal(100))
+}
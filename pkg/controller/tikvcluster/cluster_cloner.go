@@ -0,0 +1,223 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikvcluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Masterminds/semver"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	listers "github.com/tikv/tikv-operator/pkg/client/listers/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/manager/member"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/klog"
+)
+
+// ClusterCloner clones the spec of the cluster referenced by spec.cloneFrom into a new cluster,
+// e.g. to stand up a parallel cluster on other storage classes or instance types and migrate
+// the data to it
+type ClusterCloner struct {
+	tcLister listers.TikvClusterLister
+}
+
+// NewClusterCloner returns a ClusterCloner of the clusters in the lister
+func NewClusterCloner(tcLister listers.TikvClusterLister) *ClusterCloner {
+	return &ClusterCloner{tcLister: tcLister}
+}
+
+// Clone sets the spec of a cluster not created yet to the spec of the cluster referenced by
+// spec.cloneFrom, overridden by the fields set in the spec of the cluster, and records the
+// source in status.clone. A cluster is cloned once, the clusters cloned already and the clusters
+// created are left untouched. The versions of the clone incompatible with the source are
+// returned as validation errors, the spec of the cluster is not changed then.
+func (cc *ClusterCloner) Clone(tc *v1alpha1.TikvCluster) (field.ErrorList, error) {
+	if tc.Spec.CloneFrom == nil || tc.Status.Clone != nil {
+		return nil, nil
+	}
+	ns := tc.GetNamespace()
+	tcName := tc.GetName()
+	if tc.Status.ClusterID != "" || tc.Status.PD.StatefulSet != nil {
+		klog.Warningf("tikv cluster %s/%s: ignore spec.cloneFrom of a cluster created already", ns, tcName)
+		return nil, nil
+	}
+
+	sourceName := tc.Spec.CloneFrom.Name
+	source, err := cc.tcLister.TikvClusters(ns).Get(sourceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the tikv cluster %s/%s to clone: %v", ns, sourceName, err)
+	}
+	// a source cloned from another cluster has to be cloned first to be complete
+	if source.Spec.CloneFrom != nil && source.Status.Clone == nil {
+		return nil, fmt.Errorf("the tikv cluster %s/%s to clone is not cloned from %s yet", ns, sourceName, source.Spec.CloneFrom.Name)
+	}
+
+	sourceSpec := cloneableSpec(&source.Spec)
+	specHash, err := member.Sha256Sum(sourceSpec)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := overrideSpec(sourceSpec, &tc.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to override the spec of the tikv cluster %s/%s cloned: %v", ns, sourceName, err)
+	}
+	spec.CloneFrom = tc.Spec.CloneFrom
+
+	clone := tc.DeepCopy()
+	clone.Spec = *spec
+	fldPath := field.NewPath("spec", "cloneFrom")
+	errs := field.ErrorList{}
+	if !compatibleVersions(source.PDVersion(), clone.PDVersion()) {
+		errs = append(errs, field.Invalid(fldPath, sourceName, fmt.Sprintf("pd version %s is incompatible with the version %s of the cluster to clone", clone.PDVersion(), source.PDVersion())))
+	}
+	if !compatibleVersions(source.TiKVVersion(), clone.TiKVVersion()) {
+		errs = append(errs, field.Invalid(fldPath, sourceName, fmt.Sprintf("tikv version %s is incompatible with the version %s of the cluster to clone", clone.TiKVVersion(), source.TiKVVersion())))
+	}
+	if len(errs) > 0 {
+		return errs, nil
+	}
+
+	tc.Spec = *spec
+	tc.Status.Clone = &v1alpha1.CloneStatus{
+		Source:    sourceName,
+		SpecHash:  specHash,
+		CloneTime: metav1.Now(),
+	}
+	klog.Infof("tikv cluster %s/%s: spec cloned from %s, spec hash %s", ns, tcName, sourceName, specHash)
+	return nil, nil
+}
+
+// cloneableSpec returns a copy of the spec without the fields specific to the cluster instance,
+// i.e. the ports pinned, the stores offline, the upgrade partition, the storage migration, the
+// pause and the cluster the spec is cloned from
+func cloneableSpec(spec *v1alpha1.TikvClusterSpec) *v1alpha1.TikvClusterSpec {
+	spec = spec.DeepCopy()
+	spec.Ports = nil
+	spec.TiKV.OfflineStores = nil
+	spec.TiKV.UpgradePartition = nil
+	spec.TiKV.StorageMigration = nil
+	spec.Paused = false
+	spec.CloneFrom = nil
+	return spec
+}
+
+// overrideSpec returns the spec with the fields set to non-zero values in overrides merged into
+// it, the objects are merged recursively and the other values are replaced
+func overrideSpec(spec, overrides *v1alpha1.TikvClusterSpec) (*v1alpha1.TikvClusterSpec, error) {
+	base, err := toJSONObject(spec)
+	if err != nil {
+		return nil, err
+	}
+	override, err := toJSONObject(overrides)
+	if err != nil {
+		return nil, err
+	}
+	// an override with no field set prunes to nil
+	pruned, _ := pruneZeroValues(override).(map[string]interface{})
+	data, err := json.Marshal(mergeJSONObjects(base, pruned))
+	if err != nil {
+		return nil, err
+	}
+	merged := &v1alpha1.TikvClusterSpec{}
+	if err := json.Unmarshal(data, merged); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// toJSONObject returns the JSON object of v, the numbers are kept as json.Number so that the
+// large integers in the configurations do not lose precision
+func toJSONObject(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	obj := map[string]interface{}{}
+	if err := decoder.Decode(&obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// pruneZeroValues removes the zero values and the objects left empty from a JSON value, the
+// fields without omitempty are marshaled even if they are not set
+func pruneZeroValues(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, child := range value {
+			if pruned := pruneZeroValues(child); pruned != nil {
+				value[k] = pruned
+			} else {
+				delete(value, k)
+			}
+		}
+		if len(value) == 0 {
+			return nil
+		}
+		return value
+	case []interface{}:
+		if len(value) == 0 {
+			return nil
+		}
+		return value
+	case string:
+		if value == "" {
+			return nil
+		}
+	case json.Number:
+		if f, err := value.Float64(); err == nil && f == 0 {
+			return nil
+		}
+	case bool:
+		if !value {
+			return nil
+		}
+	}
+	return v
+}
+
+func mergeJSONObjects(base, override map[string]interface{}) map[string]interface{} {
+	for k, v := range override {
+		baseObj, baseIsObj := base[k].(map[string]interface{})
+		overrideObj, overrideIsObj := v.(map[string]interface{})
+		if baseIsObj && overrideIsObj {
+			base[k] = mergeJSONObjects(baseObj, overrideObj)
+		} else {
+			base[k] = v
+		}
+	}
+	return base
+}
+
+// compatibleVersions returns whether a cluster of the version clone can take over the data of a
+// cluster of the version source, i.e. the same major version and not older. The versions which
+// are not semantic versions, e.g. nightly, are compatible with the same versions only.
+func compatibleVersions(source, clone string) bool {
+	if source == clone {
+		return true
+	}
+	sourceVersion, err := semver.NewVersion(source)
+	if err != nil {
+		return false
+	}
+	cloneVersion, err := semver.NewVersion(clone)
+	if err != nil {
+		return false
+	}
+	return sourceVersion.Major() == cloneVersion.Major() && !cloneVersion.LessThan(sourceVersion)
+}
@@ -0,0 +1,151 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikvcluster
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	listers "github.com/tikv/tikv-operator/pkg/client/listers/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/pointer"
+)
+
+func TestClusterClonerClone(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	cc := NewClusterCloner(listers.NewTikvClusterLister(indexer))
+
+	source := newTikvClusterForTikvClusterControl()
+	source.Name = "blue"
+	source.Spec.TiKV.StorageClassName = pointer.StringPtr("standard")
+	source.Spec.TiKV.OfflineStores = []string{"blue-tikv-2"}
+	source.Spec.Ports = &v1alpha1.ClusterPorts{PDClient: 21000, PDPeer: 21001, TiKV: 21002, TiKVStatus: 21003}
+	source.Status.ClusterID = "6868053448423950349"
+
+	tc := &v1alpha1.TikvCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "green", Namespace: source.Namespace},
+		Spec: v1alpha1.TikvClusterSpec{
+			CloneFrom: &v1alpha1.TikvClusterRef{Name: "blue"},
+			TiKV: v1alpha1.TiKVSpec{
+				StorageClassName: pointer.StringPtr("fast"),
+				ResourceRequirements: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("100G")},
+				},
+			},
+		},
+	}
+
+	// the cluster to clone is not found
+	errs, err := cc.Clone(tc)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(errs).To(BeEmpty())
+	g.Expect(tc.Status.Clone).To(BeNil())
+
+	g.Expect(indexer.Add(source)).To(Succeed())
+	errs, err = cc.Clone(tc)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(errs).To(BeEmpty())
+	g.Expect(tc.Spec.Version).To(Equal("v3.0.8"))
+	g.Expect(apiequality.Semantic.DeepEqual(tc.Spec.PD, source.Spec.PD)).To(BeTrue())
+	g.Expect(tc.Spec.TiKV.Replicas).To(Equal(int32(3)))
+	g.Expect(*tc.Spec.TiKV.StorageClassName).To(Equal("fast"))
+	g.Expect(tc.Spec.TiKV.Requests.Storage().String()).To(Equal("100G"))
+	g.Expect(tc.Spec.TiKV.OfflineStores).To(BeEmpty())
+	g.Expect(tc.Spec.Ports).To(BeNil())
+	g.Expect(tc.Spec.CloneFrom).To(Equal(&v1alpha1.TikvClusterRef{Name: "blue"}))
+	g.Expect(tc.Status.ClusterID).To(BeEmpty())
+	g.Expect(tc.Status.Clone).NotTo(BeNil())
+	g.Expect(tc.Status.Clone.Source).To(Equal("blue"))
+	g.Expect(tc.Status.Clone.SpecHash).NotTo(BeEmpty())
+	// the source is not changed
+	g.Expect(*source.Spec.TiKV.StorageClassName).To(Equal("standard"))
+
+	// a cluster is cloned once
+	cloned := tc.DeepCopy()
+	source.Spec.Version = "v3.0.9"
+	g.Expect(indexer.Update(source)).To(Succeed())
+	errs, err = cc.Clone(tc)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(errs).To(BeEmpty())
+	g.Expect(tc).To(Equal(cloned))
+}
+
+func TestClusterClonerIncompatibleVersion(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	cc := NewClusterCloner(listers.NewTikvClusterLister(indexer))
+	source := newTikvClusterForTikvClusterControl()
+	source.Name = "blue"
+	g.Expect(indexer.Add(source)).To(Succeed())
+
+	for _, tt := range []struct {
+		version        string
+		expectedErrors int
+	}{
+		{"", 0},
+		{"v3.1.0", 0},
+		{"v3.0.7", 2},
+		{"v4.0.0", 2},
+		{"nightly", 2},
+	} {
+		tc := &v1alpha1.TikvCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "green", Namespace: source.Namespace},
+			Spec: v1alpha1.TikvClusterSpec{
+				CloneFrom: &v1alpha1.TikvClusterRef{Name: "blue"},
+				Version:   tt.version,
+			},
+		}
+		errs, err := cc.Clone(tc)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(errs).To(HaveLen(tt.expectedErrors), tt.version)
+		if tt.expectedErrors > 0 {
+			g.Expect(tc.Status.Clone).To(BeNil())
+			g.Expect(tc.Spec.Version).To(Equal(tt.version))
+		} else {
+			g.Expect(tc.Status.Clone).NotTo(BeNil())
+		}
+	}
+}
+
+func TestOverrideSpec(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spec := &v1alpha1.TikvClusterSpec{
+		Version:      "v3.0.8",
+		NodeSelector: map[string]string{"zone": "a", "disk": "hdd"},
+		TiKV:         v1alpha1.TiKVSpec{Replicas: 3, MaxFailoverCount: controller.Int32Ptr(3)},
+	}
+	merged, err := overrideSpec(spec, &v1alpha1.TikvClusterSpec{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(apiequality.Semantic.DeepEqual(merged, spec)).To(BeTrue())
+
+	merged, err = overrideSpec(spec, &v1alpha1.TikvClusterSpec{
+		NodeSelector: map[string]string{"disk": "ssd"},
+		TiKV:         v1alpha1.TiKVSpec{Replicas: 5},
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(merged.Version).To(Equal("v3.0.8"))
+	g.Expect(merged.NodeSelector).To(Equal(map[string]string{"zone": "a", "disk": "ssd"}))
+	g.Expect(merged.TiKV.Replicas).To(Equal(int32(5)))
+	g.Expect(*merged.TiKV.MaxFailoverCount).To(Equal(int32(3)))
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikvcluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	listers "github.com/tikv/tikv-operator/pkg/client/listers/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/label"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/klog"
+)
+
+// clusterPortCount is the number of ports allocated for a cluster, see v1alpha1.ClusterPorts
+const clusterPortCount = 4
+
+// PortAllocator allocates the ports of the clusters in sharedNodes network mode from
+// controller.SharedNodesPortRange. The ports of a cluster are kept in its annotation so that
+// they survive the restarts of the operator, and are free again once the cluster is deleted.
+type PortAllocator struct {
+	tcLister listers.TikvClusterLister
+
+	mu sync.Mutex
+	// allocated are the ports allocated for the clusters, which may not be seen in the lister yet
+	allocated map[types.UID]v1alpha1.ClusterPorts
+}
+
+// NewPortAllocator returns a PortAllocator of the clusters in the lister
+func NewPortAllocator(tcLister listers.TikvClusterLister) *PortAllocator {
+	return &PortAllocator{
+		tcLister:  tcLister,
+		allocated: map[types.UID]v1alpha1.ClusterPorts{},
+	}
+}
+
+// Allocate sets the ports of the cluster in sharedNodes network mode to status.ports and the
+// annotation. The ports are pinned by spec.ports, kept from a previous allocation or allocated
+// anew, the ports pinned out of controller.SharedNodesPortRange and the conflicts of the ports
+// pinned or kept with the ports of the other clusters are returned as validation errors.
+func (pa *PortAllocator) Allocate(tc *v1alpha1.TikvCluster) (field.ErrorList, error) {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+
+	if tc.Spec.NetworkMode != v1alpha1.NetworkModeSharedNodes {
+		delete(pa.allocated, tc.GetUID())
+		tc.Status.Ports = nil
+		return nil, nil
+	}
+
+	tcs, err := pa.tcLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	used := map[int32]*v1alpha1.TikvCluster{}
+	present := map[types.UID]bool{}
+	for _, other := range tcs {
+		present[other.GetUID()] = true
+		if other.GetUID() == tc.GetUID() || other.Spec.NetworkMode != v1alpha1.NetworkModeSharedNodes {
+			continue
+		}
+		ports, ok := pa.allocated[other.GetUID()]
+		if !ok {
+			ports, _, ok = recordedPorts(other)
+		}
+		if !ok {
+			continue
+		}
+		for _, port := range namedPorts(ports) {
+			used[port.value] = other
+		}
+	}
+	// the ports of the clusters deleted are returned to the pool
+	for uid := range pa.allocated {
+		if !present[uid] && uid != tc.GetUID() {
+			delete(pa.allocated, uid)
+		}
+	}
+
+	ports, fldPath, ok := recordedPorts(tc)
+	if ok {
+		// the cluster created earlier keeps the ports in conflict, e.g. with a copy of it
+		// carrying the annotation
+		allErrs := field.ErrorList{}
+		for _, port := range namedPorts(ports) {
+			// the ports kept from a previous allocation stay valid if the range is changed
+			if tc.Spec.Ports != nil && !controller.SharedNodesPortRange.Contains(int(port.value)) {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child(port.name), port.value,
+					fmt.Sprintf("must be in the range %s of the ports for the clusters in sharedNodes network mode", controller.SharedNodesPortRange.String())))
+				continue
+			}
+			if owner, conflicted := used[port.value]; conflicted && !createdAfter(owner, tc) {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child(port.name), port.value,
+					fmt.Sprintf("conflicts with the ports of tikv cluster %s/%s", owner.GetNamespace(), owner.GetName())))
+			}
+		}
+		if len(allErrs) > 0 {
+			return allErrs, nil
+		}
+	} else if ports, ok = pa.allocated[tc.GetUID()]; !ok {
+		if ports, err = allocatePorts(used); err != nil {
+			return nil, err
+		}
+		klog.Infof("tikv cluster %s/%s: allocated ports %+v", tc.GetNamespace(), tc.GetName(), ports)
+	}
+
+	value, err := json.Marshal(ports)
+	if err != nil {
+		return nil, err
+	}
+	pa.allocated[tc.GetUID()] = ports
+	if tc.Annotations == nil {
+		tc.Annotations = map[string]string{}
+	}
+	tc.Annotations[label.AnnAllocatedPortsKey] = string(value)
+	tc.Status.Ports = &ports
+	return nil, nil
+}
+
+// recordedPorts returns the ports pinned by spec.ports, kept in the annotation or in status.ports
+// of the cluster, in that order, and where they are from
+func recordedPorts(tc *v1alpha1.TikvCluster) (v1alpha1.ClusterPorts, *field.Path, bool) {
+	if tc.Spec.Ports != nil {
+		return *tc.Spec.Ports, field.NewPath("spec", "ports"), true
+	}
+	if value, ok := tc.Annotations[label.AnnAllocatedPortsKey]; ok {
+		var ports v1alpha1.ClusterPorts
+		if err := json.Unmarshal([]byte(value), &ports); err == nil {
+			return ports, field.NewPath("metadata", "annotations").Key(label.AnnAllocatedPortsKey), true
+		}
+		klog.Warningf("tikv cluster %s/%s: ignore the invalid annotation %s: %q", tc.GetNamespace(), tc.GetName(), label.AnnAllocatedPortsKey, value)
+	}
+	if tc.Status.Ports != nil {
+		return *tc.Status.Ports, field.NewPath("status", "ports"), true
+	}
+	return v1alpha1.ClusterPorts{}, nil, false
+}
+
+// createdAfter returns whether the cluster a is created after the cluster b
+func createdAfter(a, b *v1alpha1.TikvCluster) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return b.CreationTimestamp.Before(&a.CreationTimestamp)
+	}
+	return fmt.Sprintf("%s/%s", a.GetNamespace(), a.GetName()) > fmt.Sprintf("%s/%s", b.GetNamespace(), b.GetName())
+}
+
+// allocatePorts returns the lowest block of ports in the range which are all unused
+func allocatePorts(used map[int32]*v1alpha1.TikvCluster) (v1alpha1.ClusterPorts, error) {
+	r := controller.SharedNodesPortRange
+	for base := r.Base; base+clusterPortCount <= r.Base+r.Size; base += clusterPortCount {
+		free := true
+		for port := base; port < base+clusterPortCount; port++ {
+			if _, ok := used[int32(port)]; ok {
+				free = false
+				break
+			}
+		}
+		if free {
+			return v1alpha1.ClusterPorts{
+				PDClient:   int32(base),
+				PDPeer:     int32(base + 1),
+				TiKV:       int32(base + 2),
+				TiKVStatus: int32(base + 3),
+			}, nil
+		}
+	}
+	return v1alpha1.ClusterPorts{}, fmt.Errorf("no ports left in the range %s for the clusters in sharedNodes network mode", r.String())
+}
+
+type namedPort struct {
+	name  string
+	value int32
+}
+
+// namedPorts returns the ports with their names in spec.ports
+func namedPorts(ports v1alpha1.ClusterPorts) []namedPort {
+	return []namedPort{
+		{"pdClient", ports.PDClient},
+		{"pdPeer", ports.PDPeer},
+		{"tikv", ports.TiKV},
+		{"tikvStatus", ports.TiKVStatus},
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikvcluster
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	listers "github.com/tikv/tikv-operator/pkg/client/listers/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/label"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestPortAllocatorAllocate(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	indexer, pa := newFakePortAllocator()
+	first := newSharedNodesTikvCluster("first", time.Unix(1, 0))
+	g.Expect(indexer.Add(first.DeepCopy())).To(Succeed())
+	errs, err := pa.Allocate(first)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(errs).To(BeEmpty())
+	g.Expect(*first.Status.Ports).To(Equal(v1alpha1.ClusterPorts{PDClient: 21000, PDPeer: 21001, TiKV: 21002, TiKVStatus: 21003}))
+	g.Expect(first.Annotations).To(HaveKeyWithValue(label.AnnAllocatedPortsKey, `{"pdClient":21000,"pdPeer":21001,"tikv":21002,"tikvStatus":21003}`))
+	g.Expect(first.Ports()).To(Equal(*first.Status.Ports))
+
+	// the allocation is kept before the annotation is seen in the lister
+	second := newSharedNodesTikvCluster("second", time.Unix(2, 0))
+	g.Expect(indexer.Add(second.DeepCopy())).To(Succeed())
+	errs, err = pa.Allocate(second)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(errs).To(BeEmpty())
+	g.Expect(second.Status.Ports.PDClient).To(Equal(int32(21004)))
+	g.Expect(indexer.Update(first)).To(Succeed())
+	g.Expect(indexer.Update(second)).To(Succeed())
+
+	// the allocation is read from the annotation after a restart of the operator
+	_, pa = newFakePortAllocatorWithIndexer(indexer)
+	again := first.DeepCopy()
+	again.Status.Ports = nil
+	errs, err = pa.Allocate(again)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(errs).To(BeEmpty())
+	g.Expect(again.Status.Ports).To(Equal(first.Status.Ports))
+
+	// the ports of a deleted cluster are allocated again
+	g.Expect(indexer.Delete(first)).To(Succeed())
+	third := newSharedNodesTikvCluster("third", time.Unix(3, 0))
+	g.Expect(indexer.Add(third.DeepCopy())).To(Succeed())
+	errs, err = pa.Allocate(third)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(errs).To(BeEmpty())
+	g.Expect(third.Status.Ports.PDClient).To(Equal(int32(21000)))
+}
+
+func TestPortAllocatorConflicts(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	indexer, pa := newFakePortAllocator()
+	first := newSharedNodesTikvCluster("first", time.Unix(1, 0))
+	_, err := pa.Allocate(first)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(indexer.Add(first)).To(Succeed())
+
+	// pinned ports in conflict with the ports of a cluster created earlier
+	pinned := newSharedNodesTikvCluster("pinned", time.Unix(2, 0))
+	pinned.Spec.Ports = &v1alpha1.ClusterPorts{PDClient: 21003, PDPeer: 21100, TiKV: 21101, TiKVStatus: 21102}
+	g.Expect(indexer.Add(pinned)).To(Succeed())
+	errs, err := pa.Allocate(pinned)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(errs).To(HaveLen(1))
+	g.Expect(errs[0].Field).To(Equal("spec.ports.pdClient"))
+	g.Expect(pinned.Status.Ports).To(BeNil())
+
+	pinned.Spec.Ports.PDClient = 21103
+	errs, err = pa.Allocate(pinned)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(errs).To(BeEmpty())
+	g.Expect(*pinned.Status.Ports).To(Equal(*pinned.Spec.Ports))
+
+	// the cluster created earlier keeps its ports
+	errs, err = pa.Allocate(first)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(errs).To(BeEmpty())
+}
+
+func TestPortAllocatorPinnedOutOfRange(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	indexer, pa := newFakePortAllocator()
+	pinned := newSharedNodesTikvCluster("pinned", time.Unix(1, 0))
+	pinned.Spec.Ports = &v1alpha1.ClusterPorts{PDClient: 2379, PDPeer: 21001, TiKV: 21002, TiKVStatus: 21003}
+	g.Expect(indexer.Add(pinned)).To(Succeed())
+	errs, err := pa.Allocate(pinned)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(errs).To(HaveLen(1))
+	g.Expect(errs[0].Field).To(Equal("spec.ports.pdClient"))
+	g.Expect(pinned.Status.Ports).To(BeNil())
+
+	// the ports kept in the annotation are not checked against the range
+	kept := newSharedNodesTikvCluster("kept", time.Unix(2, 0))
+	kept.Annotations = map[string]string{label.AnnAllocatedPortsKey: `{"pdClient":30000,"pdPeer":30001,"tikv":30002,"tikvStatus":30003}`}
+	g.Expect(indexer.Add(kept)).To(Succeed())
+	errs, err = pa.Allocate(kept)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(errs).To(BeEmpty())
+	g.Expect(kept.Status.Ports.PDClient).To(Equal(int32(30000)))
+}
+
+func TestPortAllocatorDefaultNetworkMode(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	_, pa := newFakePortAllocator()
+	tc := newSharedNodesTikvCluster("default", time.Unix(1, 0))
+	tc.Spec.NetworkMode = v1alpha1.NetworkModeDefault
+	tc.Status.Ports = &v1alpha1.ClusterPorts{PDClient: 21000, PDPeer: 21001, TiKV: 21002, TiKVStatus: 21003}
+	errs, err := pa.Allocate(tc)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(errs).To(BeEmpty())
+	g.Expect(tc.Status.Ports).To(BeNil())
+	g.Expect(tc.Ports()).To(Equal(v1alpha1.DefaultClusterPorts))
+}
+
+func newFakePortAllocator() (cache.Indexer, *PortAllocator) {
+	return newFakePortAllocatorWithIndexer(nil)
+}
+
+func newFakePortAllocatorWithIndexer(indexer cache.Indexer) (cache.Indexer, *PortAllocator) {
+	if indexer == nil {
+		indexer = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	}
+	return indexer, NewPortAllocator(listers.NewTikvClusterLister(indexer))
+}
+
+func newSharedNodesTikvCluster(name string, created time.Time) *v1alpha1.TikvCluster {
+	return &v1alpha1.TikvCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         metav1.NamespaceDefault,
+			UID:               types.UID(name),
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Spec: v1alpha1.TikvClusterSpec{
+			NetworkMode: v1alpha1.NetworkModeSharedNodes,
+		},
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikvcluster
+
+import (
+	"fmt"
+
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	utiltikvcluster "github.com/tikv/tikv-operator/pkg/util/tikvcluster"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// The names of the steps syncing a tikv cluster, the failures of a step are reported by the
+// condition <name>Synced
+const (
+	stepOrphanPodsCleaner  = "OrphanPodsCleaner"
+	stepPVCCleaner         = "PVCCleaner"
+	stepTLSCertManager     = "TLSCertManager"
+	stepTLSSecretValidator = "TLSSecretValidator"
+	stepTLSCertReloader    = "TLSCertReloader"
+	stepScrapeTLSExporter  = "ScrapeTLSExporter"
+	stepDiscovery          = "Discovery"
+	stepPD                 = "PD"
+	stepPDStatus           = "PDStatus"
+	stepStorageMigrator    = "StorageMigrator"
+	stepTiKV               = "TiKV"
+	stepTiKVStatus         = "TiKVStatus"
+	stepMetaManager        = "MetaManager"
+	stepPVCChecker         = "PVCChecker"
+)
+
+// syncStep is a manager run by a syncPipeline
+type syncStep struct {
+	name string
+	// dependsOn are the steps run ahead of the step, it is skipped unless all of them succeed.
+	// The steps not in the pipeline, e.g. the ones disabled for a paused cluster, are ignored
+	dependsOn []string
+	// fallbackOf is the step the step stands in for, it is only run if that step failed or was
+	// skipped, e.g. the status of tikv is refreshed on its own if the tikv member manager could
+	// not sync the members
+	fallbackOf string
+	run        func(*v1alpha1.TikvCluster) error
+}
+
+// stepOutcome is the outcome of a step, a step skipped takes the outcome of the dependency it is
+// skipped for
+type stepOutcome int
+
+const (
+	stepSucceeded stepOutcome = iota
+	// stepRequeued is the outcome of a RequeueError, the step waits for something, e.g. pd to be
+	// available
+	stepRequeued
+	// stepIgnored is the outcome of an IgnoreError, e.g. the cluster is being deleted, neither
+	// the dependents nor the fallbacks of the step are run
+	stepIgnored
+	stepFailed
+)
+
+// syncPipeline runs the steps syncing a tikv cluster in the order they are declared. A step only
+// blocks the steps depending on it, so that e.g. the failure of the discovery does not keep the
+// status of tikv from being refreshed. Every step is idempotent, it is run again on the next sync
+// whatever the outcome of the other steps.
+type syncPipeline struct {
+	steps []syncStep
+}
+
+func newSyncPipeline(steps ...syncStep) *syncPipeline {
+	declared := map[string]bool{}
+	for _, step := range steps {
+		for _, dep := range append([]string{step.fallbackOf}, step.dependsOn...) {
+			if dep != "" && !declared[dep] && containsStep(steps, dep) {
+				panic(fmt.Sprintf("sync step %s is declared ahead of %s it depends on", step.name, dep))
+			}
+		}
+		declared[step.name] = true
+	}
+	return &syncPipeline{steps: steps}
+}
+
+func containsStep(steps []syncStep, name string) bool {
+	for _, step := range steps {
+		if step.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// run runs the steps and returns the errors of all the steps failed, the conditions of the steps
+// are updated in the status of tc
+func (p *syncPipeline) run(tc *v1alpha1.TikvCluster) []error {
+	var errs []error
+	outcomes := map[string]stepOutcome{}
+	for _, step := range p.steps {
+		if step.fallbackOf != "" {
+			if outcome, ok := outcomes[step.fallbackOf]; !ok || outcome == stepSucceeded || outcome == stepIgnored {
+				continue
+			}
+		}
+		if dep, outcome, blocked := blockedBy(step, outcomes); blocked {
+			klog.V(4).Infof("tikv cluster %s/%s: %s is skipped, %s did not succeed", tc.GetNamespace(), tc.GetName(), step.name, dep)
+			outcomes[step.name] = outcome
+			if outcome == stepFailed {
+				setStepCondition(tc, step.name, v1.ConditionFalse, utiltikvcluster.ManagerDependencyFailed, fmt.Sprintf("%s failed", dep))
+			}
+			continue
+		}
+
+		err := step.run(tc)
+		switch {
+		case err == nil:
+			outcomes[step.name] = stepSucceeded
+			if cond := utiltikvcluster.GetTikvClusterCondition(tc.Status, utiltikvcluster.ManagerSyncedConditionType(step.name)); cond != nil {
+				setStepCondition(tc, step.name, v1.ConditionTrue, utiltikvcluster.ManagerSynced, "")
+			}
+		case controller.IsIgnoreError(err):
+			outcomes[step.name] = stepIgnored
+		case controller.IsRequeueError(err):
+			outcomes[step.name] = stepRequeued
+		default:
+			outcomes[step.name] = stepFailed
+			setStepCondition(tc, step.name, v1.ConditionFalse, utiltikvcluster.ManagerSyncFailed, err.Error())
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// blockedBy returns the first dependency of the step not succeeded and its outcome
+func blockedBy(step syncStep, outcomes map[string]stepOutcome) (string, stepOutcome, bool) {
+	for _, dep := range step.dependsOn {
+		if outcome, ok := outcomes[dep]; ok && outcome != stepSucceeded {
+			return dep, outcome, true
+		}
+	}
+	return "", stepSucceeded, false
+}
+
+// setStepCondition sets the condition of the step, the condition is only added once the step
+// failed, so that the status of a healthy cluster is not cluttered with a condition per step
+func setStepCondition(tc *v1alpha1.TikvCluster, name string, status v1.ConditionStatus, reason, message string) {
+	cond := utiltikvcluster.NewTikvClusterCondition(utiltikvcluster.ManagerSyncedConditionType(name), status, reason, message)
+	utiltikvcluster.SetTikvClusterCondition(&tc.Status, *cond)
+}
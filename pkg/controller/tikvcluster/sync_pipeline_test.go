@@ -0,0 +1,105 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikvcluster
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
+)
+
+func TestSyncPipelineRun(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	type testcase struct {
+		name        string
+		errs        map[string]error
+		expectedRun []string
+		expectedErr int
+	}
+	tests := []testcase{
+		{
+			name:        "all succeeded",
+			expectedRun: []string{"a", "b", "c"},
+		},
+		{
+			name:        "failed",
+			errs:        map[string]error{"a": fmt.Errorf("a failed")},
+			expectedRun: []string{"a", "c", "b-status"},
+			expectedErr: 1,
+		},
+		{
+			name:        "requeued",
+			errs:        map[string]error{"a": controller.RequeueErrorf("a is waiting")},
+			expectedRun: []string{"a", "c", "b-status"},
+			expectedErr: 1,
+		},
+		{
+			name:        "ignored",
+			errs:        map[string]error{"a": controller.IgnoreErrorf("a is deleting")},
+			expectedRun: []string{"a", "c"},
+			expectedErr: 1,
+		},
+		{
+			name:        "independent steps failed",
+			errs:        map[string]error{"b": fmt.Errorf("b failed"), "c": fmt.Errorf("c failed")},
+			expectedRun: []string{"a", "b", "c", "b-status"},
+			expectedErr: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Log(test.name)
+		var run []string
+		step := func(name string) func(*v1alpha1.TikvCluster) error {
+			return func(*v1alpha1.TikvCluster) error {
+				run = append(run, name)
+				return test.errs[name]
+			}
+		}
+		pipeline := newSyncPipeline(
+			syncStep{name: "a", run: step("a")},
+			syncStep{name: "b", dependsOn: []string{"a", "disabled"}, run: step("b")},
+			syncStep{name: "c", run: step("c")},
+			syncStep{name: "b-status", fallbackOf: "b", run: step("b-status")},
+		)
+		errs := pipeline.run(newTikvClusterForTikvClusterControl())
+		g.Expect(run).To(Equal(test.expectedRun))
+		g.Expect(errs).To(HaveLen(test.expectedErr))
+	}
+}
+
+func TestSyncPipelineOrder(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(func() {
+		newSyncPipeline(
+			syncStep{name: "b", dependsOn: []string{"a"}},
+			syncStep{name: "a"},
+		)
+	}).To(Panic())
+
+	// the steps of every combination are declared after their dependencies
+	control, _, _, _, _, _, _ := newFakeTikvClusterControl()
+	for _, podsSynced := range []bool{true, false} {
+		for _, paused := range []bool{true, false} {
+			g.Expect(func() {
+				newSyncPipeline(control.(*defaultTikvClusterControl).syncSteps(podsSynced, paused)...)
+			}).NotTo(Panic())
+		}
+	}
+}
@@ -14,14 +14,24 @@
 package tikvcluster
 
 import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1/defaulting"
 	v1alpha1validation "github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1/validation"
 	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/label"
 	"github.com/tikv/tikv-operator/pkg/manager"
 	"github.com/tikv/tikv-operator/pkg/manager/member"
+	"github.com/tikv/tikv-operator/pkg/pdapi"
+	utiltikvcluster "github.com/tikv/tikv-operator/pkg/util/tikvcluster"
 	v1 "k8s.io/api/core/v1"
 	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	errorutils "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
@@ -39,65 +49,280 @@ type ControlInterface interface {
 // implements the documented semantics for TikvClusters.
 func NewDefaultTikvClusterControl(
 	tcControl controller.TikvClusterControlInterface,
-	pdMemberManager manager.Manager,
-	tikvMemberManager manager.Manager,
+	pdControl pdapi.PDControlInterface,
+	pdMemberManager manager.MemberManager,
+	tikvMemberManager manager.MemberManager,
 	metaManager manager.Manager,
 	orphanPodsCleaner member.OrphanPodsCleaner,
+	pvcCleaner member.PVCCleaner,
+	pvcChecker member.TerminatingPVCChecker,
 	discoveryManager member.PDDiscoveryManager,
+	tlsCertManager member.TLSCertManager,
+	tlsSecretValidator member.TLSSecretValidator,
+	tlsCertReloader member.TLSCertReloader,
+	scrapeTLSExporter member.ScrapeTLSExporter,
+	storageMigrator member.TiKVStorageMigrator,
 	conditionUpdater TikvClusterConditionUpdater,
+	portAllocator *PortAllocator,
+	clusterCloner *ClusterCloner,
+	syncStatus *controller.InformerSyncStatus,
 	recorder record.EventRecorder) ControlInterface {
 	return &defaultTikvClusterControl{
 		tcControl,
+		pdControl,
 		pdMemberManager,
 		tikvMemberManager,
 		metaManager,
 		orphanPodsCleaner,
+		pvcCleaner,
+		pvcChecker,
 		discoveryManager,
+		tlsCertManager,
+		tlsSecretValidator,
+		tlsCertReloader,
+		scrapeTLSExporter,
+		storageMigrator,
 		conditionUpdater,
+		portAllocator,
+		clusterCloner,
+		syncStatus,
 		recorder,
+		newStatusUpdateThrottle(),
+		controller.NewWriteLimiter(controller.WriteKindStatus,
+			func() time.Duration { return controller.StatusWriteInterval },
+			func() int { return controller.StatusWriteBurst }),
+		newDefaultingWarner(),
 	}
 }
 
 type defaultTikvClusterControl struct {
-	tcControl         controller.TikvClusterControlInterface
-	pdMemberManager   manager.Manager
-	tikvMemberManager manager.Manager
-	metaManager       manager.Manager
-	orphanPodsCleaner member.OrphanPodsCleaner
-	discoveryManager  member.PDDiscoveryManager
-	conditionUpdater  TikvClusterConditionUpdater
-	recorder          record.EventRecorder
+	tcControl          controller.TikvClusterControlInterface
+	pdControl          pdapi.PDControlInterface
+	pdMemberManager    manager.MemberManager
+	tikvMemberManager  manager.MemberManager
+	metaManager        manager.Manager
+	orphanPodsCleaner  member.OrphanPodsCleaner
+	pvcCleaner         member.PVCCleaner
+	pvcChecker         member.TerminatingPVCChecker
+	discoveryManager   member.PDDiscoveryManager
+	tlsCertManager     member.TLSCertManager
+	tlsSecretValidator member.TLSSecretValidator
+	tlsCertReloader    member.TLSCertReloader
+	scrapeTLSExporter  member.ScrapeTLSExporter
+	storageMigrator    member.TiKVStorageMigrator
+	conditionUpdater   TikvClusterConditionUpdater
+	portAllocator      *PortAllocator
+	clusterCloner      *ClusterCloner
+	syncStatus         *controller.InformerSyncStatus
+	recorder           record.EventRecorder
+	statusThrottle     *statusUpdateThrottle
+	statusLimiter      *controller.WriteLimiter
+	defaultingWarner   *defaultingWarner
 }
 
 // UpdateStatefulSet executes the core logic loop for a tikvcluster.
 func (tcc *defaultTikvClusterControl) UpdateTikvCluster(tc *v1alpha1.TikvCluster) error {
+	oldStatus := tc.Status.DeepCopy()
+	// the spec cloned is written along with the status, it is cloned ahead of the defaulting so
+	// that the fields defaulted do not override the ones cloned
+	if cloned, err := tcc.clone(tc); !cloned {
+		return err // no need to retry on the versions incompatible until they are fixed
+	}
 	tcc.defaulting(tc)
 	if !tcc.validate(tc) {
 		return nil // fatal error, no need to retry on invalid object
 	}
 
 	var errs []error
-	oldStatus := tc.Status.DeepCopy()
+	// only the status of a paused cluster is synced, neither its ports nor its failover change
+	paused := controller.IsClusterPaused(tc)
 
-	if err := tcc.updateTikvCluster(tc); err != nil {
-		errs = append(errs, err)
+	// the ports are allocated ahead of syncing the members listening on them, the annotation
+	// keeping them is written along with the status
+	portsAnnotated := false
+	if !paused {
+		oldPorts := tc.Annotations[label.AnnAllocatedPortsKey]
+		if allocated, err := tcc.allocatePorts(tc); !allocated {
+			return err // no need to retry on the ports in conflict until they are fixed
+		}
+		portsAnnotated = tc.Annotations[label.AnnAllocatedPortsKey] != oldPorts
+	}
+
+	// the annotation is cleared once handled, so tc has to be updated even if the status is not changed
+	recoverRequested := false
+	if !paused {
+		var recovered []string
+		recovered, recoverRequested = member.RecoverFailover(tc)
+		if recoverRequested {
+			tcc.recorder.Event(tc, v1.EventTypeNormal, "RecoverFailover", fmt.Sprintf("recover failover of pods %v", recovered))
+		}
+	}
+
+	// a new value of the force-sync annotation requests a full sync with the status refreshed from pd
+	// bypassing the cached responses, the value is recorded in status once handled
+	forceSync := tc.Annotations[label.AnnForceSyncKey]
+	forced := forceSync != "" && forceSync != tc.Status.ForceSync
+	if forced {
+		klog.Infof("tikv cluster %s/%s: full sync requested by %s: %q", tc.GetNamespace(), tc.GetName(), label.AnnForceSyncKey, forceSync)
+		tcc.recorder.Event(tc, v1.EventTypeNormal, "ForceSync", fmt.Sprintf("full sync %q started", forceSync))
+		tcc.pdControl.InvalidateResponseCache(pdapi.Namespace(tc.GetNamespace()), tc.GetName())
 	}
 
+	errs = append(errs, tcc.updateTikvCluster(tc)...)
+
 	if err := tcc.conditionUpdater.Update(tc); err != nil {
 		errs = append(errs, err)
 	}
+	tc.Status.Progress = getProgress(tc)
+
+	if forced {
+		// recorded even if the sync failed, it is retried as usual rather than forced again
+		tc.Status.ForceSync = forceSync
+		if err := errorutils.NewAggregate(errs); err != nil {
+			tcc.recorder.Event(tc, v1.EventTypeWarning, "ForceSyncFailed", fmt.Sprintf("full sync %q failed: %v", forceSync, err))
+		} else {
+			tcc.recorder.Event(tc, v1.EventTypeNormal, "ForceSyncCompleted", fmt.Sprintf("full sync %q completed", forceSync))
+		}
+	}
 
-	if apiequality.Semantic.DeepEqual(&tc.Status, oldStatus) {
+	if !recoverRequested && !portsAnnotated && apiequality.Semantic.DeepEqual(&tc.Status, oldStatus) {
 		return errorutils.NewAggregate(errs)
 	}
+	// the progress is written at most once per interval, the other changes are limited by the
+	// token bucket of the cluster but for the critical transitions. A write deferred is not kept,
+	// the status of the sync it is requeued for is written, i.e. the freshest one wins
+	if !recoverRequested && !portsAnnotated && !forced && !criticalTransition(&tc.Status, oldStatus) {
+		if progressChangedOnly(&tc.Status, oldStatus) {
+			if remaining := tcc.statusThrottle.remaining(tc.GetUID()); remaining > 0 {
+				if len(errs) > 0 {
+					return errorutils.NewAggregate(errs)
+				}
+				return controller.RequeueErrorAfter(remaining, "tikvcluster: [%s/%s]'s progress %q is written in %v", tc.GetNamespace(), tc.GetName(), tc.Status.Progress, remaining)
+			}
+		} else if allowed, wait := tcc.statusLimiter.Allow(tc.GetUID()); !allowed {
+			errs = append(errs, controller.RequeueErrorAfter(wait, "tikvcluster: [%s/%s]'s status is written in %v, the status writes are rate limited", tc.GetNamespace(), tc.GetName(), wait))
+			return errorutils.NewAggregate(errs)
+		}
+	}
 	if _, err := tcc.tcControl.UpdateTikvCluster(tc.DeepCopy(), &tc.Status, oldStatus); err != nil {
 		errs = append(errs, err)
+	} else {
+		tcc.statusThrottle.updated(tc.GetUID())
+		if tc.Status.Progress != oldStatus.Progress && tc.Status.Progress != "" {
+			tcc.recorder.Event(tc, v1.EventTypeNormal, "Progress", tc.Status.Progress)
+		}
 	}
 
 	return errorutils.NewAggregate(errs)
 }
 
+// getProgress returns the progress of the ongoing upgrade or scaling of the tikv cluster, with
+// the upgrade taking precedence and pd ahead of tikv
+func getProgress(tc *v1alpha1.TikvCluster) string {
+	pdSet, tikvSet := tc.Status.PD.StatefulSet, tc.Status.TiKV.StatefulSet
+	if pdSet != nil && tc.Status.PD.Phase == v1alpha1.UpgradePhase {
+		return fmt.Sprintf("upgrading pd %d/%d", pdSet.UpdatedReplicas, pdSet.Replicas)
+	}
+	if tikvSet != nil && tc.Status.TiKV.Phase == v1alpha1.UpgradePhase {
+		return fmt.Sprintf("upgrading tikv %d/%d", tikvSet.UpdatedReplicas, tikvSet.Replicas)
+	}
+	if pdSet != nil && (pdSet.Replicas != tc.Spec.PD.Replicas || pdSet.ReadyReplicas < tc.Spec.PD.Replicas) {
+		return fmt.Sprintf("scaling pd %d/%d", pdSet.ReadyReplicas, tc.Spec.PD.Replicas)
+	}
+	if tikvSet != nil && (tikvSet.Replicas != tc.Spec.TiKV.Replicas || tikvSet.ReadyReplicas < tc.Spec.TiKV.Replicas) {
+		return fmt.Sprintf("scaling tikv %d/%d", tikvSet.ReadyReplicas, tc.Spec.TiKV.Replicas)
+	}
+	return ""
+}
+
+// progressChangedOnly returns whether the status only differs from the old one in the fields
+// changing along an upgrade or scaling: the progress, the statefulset statuses and the leader
+// counts and heartbeats of the stores
+func progressChangedOnly(status, oldStatus *v1alpha1.TikvClusterStatus) bool {
+	strip := func(status *v1alpha1.TikvClusterStatus) *v1alpha1.TikvClusterStatus {
+		status = status.DeepCopy()
+		status.Progress = ""
+		status.PD.StatefulSet = nil
+		status.TiKV.StatefulSet = nil
+		for _, stores := range []map[string]v1alpha1.TiKVStore{status.TiKV.Stores, status.TiKV.TombstoneStores} {
+			for id, store := range stores {
+				store.LeaderCount = 0
+				store.LastHeartbeatTime = metav1.Time{}
+				stores[id] = store
+			}
+		}
+		return status
+	}
+	return apiequality.Semantic.DeepEqual(strip(status), strip(oldStatus))
+}
+
+// criticalTransition returns whether the status changes in a way written bypassing the rate
+// limit of the status writes: the Ready condition flipping or a failover starting
+func criticalTransition(status, oldStatus *v1alpha1.TikvClusterStatus) bool {
+	conditionStatus := func(status *v1alpha1.TikvClusterStatus) v1.ConditionStatus {
+		if cond := utiltikvcluster.GetTikvClusterReadyCondition(*status); cond != nil {
+			return cond.Status
+		}
+		return v1.ConditionUnknown
+	}
+	if conditionStatus(status) != conditionStatus(oldStatus) {
+		return true
+	}
+	for name := range status.PD.FailureMembers {
+		if _, ok := oldStatus.PD.FailureMembers[name]; !ok {
+			return true
+		}
+	}
+	for id := range status.TiKV.FailureStores {
+		if _, ok := oldStatus.TiKV.FailureStores[id]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// statusUpdateThrottle tracks the last status write of each cluster
+type statusUpdateThrottle struct {
+	mu         sync.Mutex
+	lastUpdate map[types.UID]time.Time
+}
+
+func newStatusUpdateThrottle() *statusUpdateThrottle {
+	return &statusUpdateThrottle{lastUpdate: map[types.UID]time.Time{}}
+}
+
+// remaining returns how long the cluster has to wait before its status is written again
+func (t *statusUpdateThrottle) remaining(uid types.UID) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	last, ok := t.lastUpdate[uid]
+	if !ok {
+		return 0
+	}
+	return controller.StatusUpdateInterval - time.Since(last)
+}
+
+// updated records a status write of the cluster, dropping the writes past the interval so that
+// deleted clusters are not tracked forever
+func (t *statusUpdateThrottle) updated(uid types.UID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for id, last := range t.lastUpdate {
+		if now.Sub(last) >= controller.StatusUpdateInterval {
+			delete(t.lastUpdate, id)
+		}
+	}
+	t.lastUpdate[uid] = now
+}
+
 func (tcc *defaultTikvClusterControl) validate(tc *v1alpha1.TikvCluster) bool {
+	// the names of all member resources are derived from the cluster name
+	if err := controller.ValidateClusterName(tc.GetName()); err != nil {
+		klog.Errorf("tikv cluster %s/%s is not valid and must be recreated with a valid name, error: %v", tc.GetNamespace(), tc.GetName(), err)
+		tcc.recorder.Event(tc, v1.EventTypeWarning, "FailedValidation", err.Error())
+		return false
+	}
 	errs := v1alpha1validation.ValidateTikvCluster(tc)
 	if len(errs) > 0 {
 		aggregatedErr := errs.ToAggregate()
@@ -108,21 +333,167 @@ func (tcc *defaultTikvClusterControl) validate(tc *v1alpha1.TikvCluster) bool {
 	return true
 }
 
+// allocatePorts allocates the ports of the cluster in sharedNodes network mode, it returns false
+// if the ports could not be allocated, e.g. the range is used up, or the ports pinned or kept for
+// the cluster conflict with the ports of the other clusters
+func (tcc *defaultTikvClusterControl) allocatePorts(tc *v1alpha1.TikvCluster) (bool, error) {
+	errs, err := tcc.portAllocator.Allocate(tc)
+	if err != nil {
+		tcc.recorder.Event(tc, v1.EventTypeWarning, "FailedAllocatePorts", err.Error())
+		return false, err
+	}
+	if len(errs) > 0 {
+		aggregatedErr := errs.ToAggregate()
+		klog.Errorf("tikv cluster %s/%s has ports in conflict which must be fixed first, aggregated error: %v", tc.GetNamespace(), tc.GetName(), aggregatedErr)
+		tcc.recorder.Event(tc, v1.EventTypeWarning, "FailedValidation", aggregatedErr.Error())
+		return false, nil
+	}
+	return true, nil
+}
+
+// clone clones the spec of the cluster referenced by spec.cloneFrom, it returns false if the spec
+// could not be cloned, e.g. the cluster to clone is not found or its version is incompatible
+func (tcc *defaultTikvClusterControl) clone(tc *v1alpha1.TikvCluster) (bool, error) {
+	cloning := tc.Spec.CloneFrom != nil && tc.Status.Clone == nil
+	errs, err := tcc.clusterCloner.Clone(tc)
+	if err != nil {
+		tcc.recorder.Event(tc, v1.EventTypeWarning, "FailedClone", err.Error())
+		return false, err
+	}
+	if len(errs) > 0 {
+		aggregatedErr := errs.ToAggregate()
+		klog.Errorf("tikv cluster %s/%s can not be cloned and must be fixed first, aggregated error: %v", tc.GetNamespace(), tc.GetName(), aggregatedErr)
+		tcc.recorder.Event(tc, v1.EventTypeWarning, "FailedValidation", aggregatedErr.Error())
+		return false, nil
+	}
+	if cloning && tc.Status.Clone != nil {
+		tcc.recorder.Event(tc, v1.EventTypeNormal, "Cloned", fmt.Sprintf("spec cloned from %s, spec hash %s", tc.Status.Clone.Source, tc.Status.Clone.SpecHash))
+	}
+	return true, nil
+}
+
 func (tcc *defaultTikvClusterControl) defaulting(tc *v1alpha1.TikvCluster) {
-	defaulting.SetTikvClusterDefault(tc)
+	warnings := defaulting.SetTikvClusterDefault(tc)
+	if tcc.defaultingWarner.shouldWarn(tc, len(warnings) > 0) {
+		tcc.recorder.Event(tc, v1.EventTypeWarning, "DecimalStorageUnits", strings.Join(warnings, "; "))
+	}
 }
 
-func (tcc *defaultTikvClusterControl) updateTikvCluster(tc *v1alpha1.TikvCluster) error {
-	// cleaning all orphan pods managed by operator
-	if _, err := tcc.orphanPodsCleaner.Clean(tc); err != nil {
-		return err
+// defaultingWarner deduplicates the warnings of the defaulting, which runs on every sync, a
+// cluster is warned once per generation of its spec
+type defaultingWarner struct {
+	mu     sync.Mutex
+	warned map[types.UID]int64
+}
+
+func newDefaultingWarner() *defaultingWarner {
+	return &defaultingWarner{warned: map[types.UID]int64{}}
+}
+
+// shouldWarn returns whether the cluster with warnings is to be warned, it forgets the clusters
+// without warnings
+func (w *defaultingWarner) shouldWarn(tc *v1alpha1.TikvCluster, warnings bool) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !warnings {
+		delete(w.warned, tc.GetUID())
+		return false
 	}
+	if generation, ok := w.warned[tc.GetUID()]; ok && generation == tc.GetGeneration() {
+		return false
+	}
+	w.warned[tc.GetUID()] = tc.GetGeneration()
+	return true
+}
 
-	// reconcile PD discovery service
-	if err := tcc.discoveryManager.Reconcile(tc); err != nil {
-		return err
+// updateTikvCluster runs the managers syncing the cluster and returns the errors of all of them,
+// see syncSteps for their order and dependencies
+func (tcc *defaultTikvClusterControl) updateTikvCluster(tc *v1alpha1.TikvCluster) []error {
+	// the cleaners and the meta manager would take pods and pvcs missing from
+	// caches still warming up as deleted, skip them until the caches are synced
+	podsSynced := tcc.syncStatus.HasSynced(controller.PodInformer, controller.PVCInformer, controller.PVInformer)
+	if !podsSynced {
+		klog.Infof("tikv cluster %s/%s: caches %v are not synced, defer cleaning and syncing meta", tc.GetNamespace(), tc.GetName(), tcc.syncStatus.NotSynced())
 	}
 
+	// the member managers sync the status of a paused cluster without changing anything,
+	// the other managers are skipped
+	paused := controller.IsClusterPaused(tc)
+
+	errs := newSyncPipeline(tcc.syncSteps(podsSynced, paused)...).run(tc)
+	if paused {
+		errs = append(errs, controller.IgnoreErrorf("tikv cluster %s/%s is paused, only its status is synced", tc.GetNamespace(), tc.GetName()))
+	}
+	return errs
+}
+
+// syncSteps returns the managers syncing the cluster in the order they are run. The mutating
+// managers of the members are chained, discovery -> pd -> tikv, the others only depend on what
+// they use, so that e.g. the status of tikv is refreshed and the orphan pods are cleaned even if
+// pd can not be synced.
+func (tcc *defaultTikvClusterControl) syncSteps(podsSynced, paused bool) []syncStep {
+	var steps []syncStep
+	add := func(enabled bool, step syncStep) {
+		if enabled {
+			steps = append(steps, step)
+		}
+	}
+
+	// cleaning all orphan pods managed by operator
+	add(podsSynced && !paused, syncStep{
+		name: stepOrphanPodsCleaner,
+		run: func(tc *v1alpha1.TikvCluster) error {
+			_, err := tcc.orphanPodsCleaner.Clean(tc)
+			return err
+		},
+	})
+
+	// deleting the pvcs of scaled in tikv members retained longer than .tikv.scaleInPVCRetentionPeriod
+	add(podsSynced && !paused, syncStep{
+		name: stepPVCCleaner,
+		run: func(tc *v1alpha1.TikvCluster) error {
+			_, err := tcc.pvcCleaner.Clean(tc)
+			return err
+		},
+	})
+
+	// issuing the certificates of the cluster TLS with cert-manager, the members mounting
+	// them are not created until they are issued
+	add(!paused, syncStep{
+		name: stepTLSCertManager,
+		run:  tcc.tlsCertManager.Sync,
+	})
+
+	// validating the certificates of the cluster TLS, the members are not rolled out with a
+	// certificate they would crashloop on
+	add(!paused, syncStep{
+		name:      stepTLSSecretValidator,
+		dependsOn: []string{stepTLSCertManager},
+		run:       tcc.tlsSecretValidator.Validate,
+	})
+
+	// reloading the rotated certificates of the cluster TLS, the members of the versions not
+	// reloading them online are restarted with their leaders evicted
+	add(!paused, syncStep{
+		name:      stepTLSCertReloader,
+		dependsOn: []string{stepTLSSecretValidator},
+		run:       tcc.tlsCertReloader.Sync,
+	})
+
+	// exporting the client certificate of the cluster TLS to the namespace of Prometheus, it is
+	// refreshed once the certificate is rotated
+	add(!paused, syncStep{
+		name:      stepScrapeTLSExporter,
+		dependsOn: []string{stepTLSCertManager},
+		run:       tcc.scrapeTLSExporter.Sync,
+	})
+
+	// reconcile PD discovery service
+	add(!paused, syncStep{
+		name: stepDiscovery,
+		run:  tcc.discoveryManager.Reconcile,
+	})
+
 	// works that should do to making the pd cluster current state match the desired state:
 	//   - create or update the pd service
 	//   - create or update the pd headless service
@@ -134,9 +505,26 @@ func (tcc *defaultTikvClusterControl) updateTikvCluster(tc *v1alpha1.TikvCluster
 	//   - upgrade the pd cluster
 	//   - scale out/in the pd cluster
 	//   - failover the pd cluster
-	if err := tcc.pdMemberManager.Sync(tc); err != nil {
-		return err
-	}
+	add(true, syncStep{
+		name:      stepPD,
+		dependsOn: []string{stepDiscovery, stepTLSCertReloader},
+		run:       tcc.pdMemberManager.Sync,
+	})
+
+	// refreshing the status of pd if the pd member manager did not
+	add(true, syncStep{
+		name:       stepPDStatus,
+		fallbackOf: stepPD,
+		run:        tcc.pdMemberManager.SyncStatus,
+	})
+
+	// migrating the tikv stores to the storage class of spec.tikv.storageMigration a store at a
+	// time, the store being replaced is decommissioned as an offline store by the tikv sync
+	add(podsSynced && !paused, syncStep{
+		name:      stepStorageMigrator,
+		dependsOn: []string{stepPD},
+		run:       tcc.storageMigrator.Migrate,
+	})
 
 	// works that should do to making the tikv cluster current state match the desired state:
 	//   - waiting for the pd cluster available(pd cluster is in quorum)
@@ -147,19 +535,36 @@ func (tcc *defaultTikvClusterControl) updateTikvCluster(tc *v1alpha1.TikvCluster
 	//   - upgrade the tikv cluster
 	//   - scale out/in the tikv cluster
 	//   - failover the tikv cluster
-	if err := tcc.tikvMemberManager.Sync(tc); err != nil {
-		return err
-	}
+	add(true, syncStep{
+		name:      stepTiKV,
+		dependsOn: []string{stepPD},
+		run:       tcc.tikvMemberManager.Sync,
+	})
+
+	// refreshing the status of tikv if the tikv member manager did not, e.g. pd failed to sync
+	// while its members are still serving
+	add(true, syncStep{
+		name:       stepTiKVStatus,
+		fallbackOf: stepTiKV,
+		run:        tcc.tikvMemberManager.SyncStatus,
+	})
 
 	// syncing the labels from Pod to PVC and PV, these labels include:
 	//   - label.StoreIDLabelKey
 	//   - label.MemberIDLabelKey
 	//   - label.NamespaceLabelKey
-	if err := tcc.metaManager.Sync(tc); err != nil {
-		return err
-	}
+	add(podsSynced && !paused, syncStep{
+		name: stepMetaManager,
+		run:  tcc.metaManager.Sync,
+	})
 
-	return nil
+	// waiting for the terminating pvcs of the pods to be created, and reporting the ones stuck
+	add(podsSynced && !paused, syncStep{
+		name: stepPVCChecker,
+		run:  tcc.pvcChecker.Check,
+	})
+
+	return steps
 }
 
 var _ ControlInterface = &defaultTikvClusterControl{}
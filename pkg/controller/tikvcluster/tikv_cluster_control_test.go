@@ -17,20 +17,26 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	. "github.com/onsi/gomega"
+	perrors "github.com/pingcap/errors"
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
 	"github.com/tikv/tikv-operator/pkg/client/clientset/versioned/fake"
 	informers "github.com/tikv/tikv-operator/pkg/client/informers/externalversions"
 	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/label"
 	mm "github.com/tikv/tikv-operator/pkg/manager/member"
 	"github.com/tikv/tikv-operator/pkg/manager/meta"
+	"github.com/tikv/tikv-operator/pkg/pdapi"
+	utiltikvcluster "github.com/tikv/tikv-operator/pkg/util/tikvcluster"
 	apps "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/tools/record"
 )
 
@@ -45,6 +51,7 @@ func TestTikvClusterControlUpdateTikvCluster(t *testing.T) {
 		syncTiKVMemberManagerErr bool
 		syncMetaManagerErr       bool
 		updateTCStatusErr        bool
+		podCacheNotSynced        bool
 		errExpectFn              func(*GomegaWithT, error)
 	}
 	testFn := func(test *testcase, t *testing.T) {
@@ -54,7 +61,7 @@ func TestTikvClusterControlUpdateTikvCluster(t *testing.T) {
 		if test.update != nil {
 			test.update(tc)
 		}
-		control, orphanPodCleaner, pdMemberManager, tikvMemberManager, metaManager, tcUpdater := newFakeTikvClusterControl()
+		control, orphanPodCleaner, pdMemberManager, tikvMemberManager, metaManager, tcUpdater, syncStatus := newFakeTikvClusterControl()
 
 		if test.orphanPodCleanerErr {
 			orphanPodCleaner.SetnOrphanPodCleanerError(fmt.Errorf("clean orphan pod error"))
@@ -71,6 +78,9 @@ func TestTikvClusterControlUpdateTikvCluster(t *testing.T) {
 		if test.updateTCStatusErr {
 			tcUpdater.SetUpdateTikvClusterError(fmt.Errorf("update tikvcluster status error"), 0)
 		}
+		if test.podCacheNotSynced {
+			syncStatus.Add(controller.PodInformer, func() bool { return false })
+		}
 
 		err := control.UpdateTikvCluster(tc)
 		if test.errExpectFn != nil {
@@ -130,6 +140,65 @@ func TestTikvClusterControlUpdateTikvCluster(t *testing.T) {
 				g.Expect(strings.Contains(err.Error(), "meta manager sync error")).To(Equal(true))
 			},
 		},
+		{
+			name:                     "cleaning and meta syncing are deferred when pod cache is not synced",
+			update:                   nil,
+			orphanPodCleanerErr:      true,
+			syncPDMemberManagerErr:   false,
+			syncTiKVMemberManagerErr: false,
+			syncMetaManagerErr:       true,
+			updateTCStatusErr:        false,
+			podCacheNotSynced:        true,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+		},
+		{
+			name: "paused cluster only syncs the status",
+			update: func(cluster *v1alpha1.TikvCluster) {
+				cluster.Spec.Paused = true
+			},
+			orphanPodCleanerErr:      true,
+			syncPDMemberManagerErr:   false,
+			syncTiKVMemberManagerErr: false,
+			syncMetaManagerErr:       true,
+			updateTCStatusErr:        false,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).To(HaveOccurred())
+				// the error is aggregated with the ones of the status update
+				g.Expect(perrors.Find(err, controller.IsIgnoreError)).NotTo(BeNil())
+				g.Expect(err.Error()).To(ContainSubstring("is paused"))
+			},
+		},
+		{
+			name: "paused cluster syncs the status of the members",
+			update: func(cluster *v1alpha1.TikvCluster) {
+				cluster.Spec.Paused = true
+			},
+			orphanPodCleanerErr:      false,
+			syncPDMemberManagerErr:   true,
+			syncTiKVMemberManagerErr: false,
+			syncMetaManagerErr:       false,
+			updateTCStatusErr:        false,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring("pd member manager sync error"))
+			},
+		},
+		{
+			name: "cluster name is too long",
+			update: func(cluster *v1alpha1.TikvCluster) {
+				cluster.Name = strings.Repeat("a", controller.MaxClusterNameLength+1)
+			},
+			orphanPodCleanerErr:      false,
+			syncPDMemberManagerErr:   true,
+			syncTiKVMemberManagerErr: false,
+			syncMetaManagerErr:       false,
+			updateTCStatusErr:        false,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+		},
 		{
 			name:                     "tikvcluster status is not updated",
 			update:                   nil,
@@ -200,6 +269,281 @@ func TestTikvClusterControlUpdateTikvCluster(t *testing.T) {
 	}
 }
 
+func TestTikvClusterControlForceSync(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForTikvClusterControl()
+	tc.Annotations = map[string]string{label.AnnForceSyncKey: "2020-05-01T00:00:00Z"}
+	control, _, pdMemberManager, _, _, _, _ := newFakeTikvClusterControl()
+
+	// the value is recorded even if the sync failed, so it is not forced again
+	pdMemberManager.SetSyncError(fmt.Errorf("pd member manager sync error"))
+	err := control.UpdateTikvCluster(tc)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(tc.Status.ForceSync).To(Equal("2020-05-01T00:00:00Z"))
+
+	pdMemberManager.SetSyncError(nil)
+	tc.Annotations[label.AnnForceSyncKey] = "2020-05-02T00:00:00Z"
+	err = control.UpdateTikvCluster(tc)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(tc.Status.ForceSync).To(Equal("2020-05-02T00:00:00Z"))
+
+	// removing the annotation keeps the value handled last
+	delete(tc.Annotations, label.AnnForceSyncKey)
+	err = control.UpdateTikvCluster(tc)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(tc.Status.ForceSync).To(Equal("2020-05-02T00:00:00Z"))
+}
+
+func TestTikvClusterControlPipeline(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForTikvClusterControl()
+	control, orphanPodCleaner, pdMemberManager, _, _, _, _ := newFakeTikvClusterControl()
+	condition := func(step string) *v1alpha1.TikvClusterCondition {
+		return utiltikvcluster.GetTikvClusterCondition(tc.Status, utiltikvcluster.ManagerSyncedConditionType(step))
+	}
+
+	// the status of tikv is refreshed even if pd failed to sync, the errors are aggregated
+	pdMemberManager.SetSyncError(fmt.Errorf("pd member manager sync error"))
+	orphanPodCleaner.SetnOrphanPodCleanerError(fmt.Errorf("clean orphan pod error"))
+	err := control.UpdateTikvCluster(tc)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("pd member manager sync error"))
+	g.Expect(err.Error()).To(ContainSubstring("clean orphan pod error"))
+	g.Expect(tc.Status.TiKV.Synced).To(BeTrue())
+	g.Expect(tc.Status.PD.Synced).To(BeTrue())
+	g.Expect(condition(stepPD).Status).To(Equal(corev1.ConditionFalse))
+	g.Expect(condition(stepPD).Reason).To(Equal(utiltikvcluster.ManagerSyncFailed))
+	g.Expect(condition(stepTiKV).Reason).To(Equal(utiltikvcluster.ManagerDependencyFailed))
+	g.Expect(condition(stepOrphanPodsCleaner).Status).To(Equal(corev1.ConditionFalse))
+	g.Expect(condition(stepDiscovery)).To(BeNil())
+
+	// the conditions flip once the managers sync again
+	pdMemberManager.SetSyncError(nil)
+	orphanPodCleaner.SetnOrphanPodCleanerError(nil)
+	g.Expect(control.UpdateTikvCluster(tc)).To(Succeed())
+	for _, step := range []string{stepPD, stepTiKV, stepOrphanPodsCleaner} {
+		g.Expect(condition(step).Status).To(Equal(corev1.ConditionTrue))
+		g.Expect(condition(step).Reason).To(Equal(utiltikvcluster.ManagerSynced))
+	}
+}
+
+func TestTikvClusterControlDecimalStorageWarning(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	control, _, _, _, _, _, _ := newFakeTikvClusterControl()
+	recorder := control.(*defaultTikvClusterControl).recorder.(*record.FakeRecorder)
+	events := func() []string {
+		var events []string
+		for {
+			select {
+			case event := <-recorder.Events:
+				if strings.Contains(event, "DecimalStorageUnits") {
+					events = append(events, event)
+				}
+			default:
+				return events
+			}
+		}
+	}
+
+	// the storage of the cluster is written in decimal units, it is warned once per generation
+	tc := newTikvClusterForTikvClusterControl()
+	tc.Generation = 1
+	g.Expect(control.UpdateTikvCluster(tc.DeepCopy())).To(Succeed())
+	g.Expect(events()).To(ConsistOf(ContainSubstring("spec.pd.requests.storage 10G uses decimal units")))
+	g.Expect(control.UpdateTikvCluster(tc.DeepCopy())).To(Succeed())
+	g.Expect(events()).To(BeEmpty())
+
+	tc.Generation = 2
+	g.Expect(control.UpdateTikvCluster(tc.DeepCopy())).To(Succeed())
+	g.Expect(events()).To(HaveLen(1))
+
+	tc.Spec.PD.Requests[corev1.ResourceStorage] = resource.MustParse("10Gi")
+	tc.Spec.TiKV.Requests[corev1.ResourceStorage] = resource.MustParse("10Gi")
+	g.Expect(control.UpdateTikvCluster(tc.DeepCopy())).To(Succeed())
+	g.Expect(events()).To(BeEmpty())
+}
+
+func TestTikvClusterControlProgress(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	defer func(interval time.Duration) {
+		controller.StatusUpdateInterval = interval
+	}(controller.StatusUpdateInterval)
+	controller.StatusUpdateInterval = 0
+
+	tc := newTikvClusterForTikvClusterControl()
+	tc.Spec.TiKV.Replicas = 5
+	control, _, _, _, _, tcUpdater, _ := newFakeTikvClusterControl()
+	written := func() string {
+		obj, exists, err := tcUpdater.TcIndexer.Get(tc)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(exists).To(BeTrue())
+		return obj.(*v1alpha1.TikvCluster).Status.Progress
+	}
+
+	// every pod upgraded is written as a distinct progress
+	tc.Status.PD.StatefulSet = &apps.StatefulSetStatus{Replicas: 3, ReadyReplicas: 3}
+	tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
+	progresses := map[string]bool{}
+	for i := int32(0); i <= 5; i++ {
+		tc.Status.TiKV.StatefulSet = &apps.StatefulSetStatus{Replicas: 5, ReadyReplicas: 5, UpdatedReplicas: i}
+		err := control.UpdateTikvCluster(tc)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(written()).To(Equal(fmt.Sprintf("upgrading tikv %d/5", i)))
+		progresses[written()] = true
+	}
+	g.Expect(progresses).To(HaveLen(6))
+
+	tc.Status.TiKV.Phase = v1alpha1.NormalPhase
+	g.Expect(control.UpdateTikvCluster(tc)).To(Succeed())
+	g.Expect(written()).To(BeEmpty())
+
+	// the progress is not written again within the interval, the other changes are
+	controller.StatusUpdateInterval = time.Hour
+	tc.Status.TiKV.StatefulSet = &apps.StatefulSetStatus{Replicas: 5, ReadyReplicas: 4}
+	err := control.UpdateTikvCluster(tc)
+	g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+	after, ok := controller.RequeueAfter(err)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(after).To(BeNumerically(">", 0))
+	g.Expect(written()).To(BeEmpty())
+
+	tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
+	tc.Status.TiKV.StatefulSet = &apps.StatefulSetStatus{Replicas: 5, ReadyReplicas: 4}
+	g.Expect(control.UpdateTikvCluster(tc)).To(Succeed())
+	g.Expect(written()).To(Equal("upgrading tikv 0/5"))
+}
+
+func TestTikvClusterControlStatusWriteLimit(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	defer func(interval time.Duration, burst int) {
+		controller.StatusWriteInterval = interval
+		controller.StatusWriteBurst = burst
+	}(controller.StatusWriteInterval, controller.StatusWriteBurst)
+	controller.StatusWriteInterval = time.Hour
+	controller.StatusWriteBurst = 3
+
+	tc := newTikvClusterForTikvClusterControl()
+	// the fake tikv member manager changes the status on every sync of a cluster with stores,
+	// like a flapping store
+	tc.Status.TiKV.Stores = map[string]v1alpha1.TiKVStore{}
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("%d", i)
+		tc.Status.TiKV.Stores[id] = v1alpha1.TiKVStore{ID: id, State: v1alpha1.TiKVStateDown}
+	}
+	control, _, _, _, _, tcUpdater, _ := newFakeTikvClusterControl()
+	written := func() *v1alpha1.TikvClusterStatus {
+		obj, exists, err := tcUpdater.TcIndexer.Get(tc)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(exists).To(BeTrue())
+		return &obj.(*v1alpha1.TikvCluster).Status
+	}
+
+	// the first write adds the Ready condition bypassing the limit, the burst follows
+	writes := 0
+	for i := 0; i < 20; i++ {
+		err := control.UpdateTikvCluster(tc)
+		if err != nil {
+			g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+		}
+		if written().ClusterID == tc.Status.ClusterID {
+			writes++
+		}
+	}
+	g.Expect(writes).To(Equal(1 + 3))
+
+	// the Ready condition flipping is written at once
+	tc.Status.PD.StatefulSet = &apps.StatefulSetStatus{Replicas: 3, ReadyReplicas: 3}
+	tc.Status.TiKV.StatefulSet = &apps.StatefulSetStatus{Replicas: 3, ReadyReplicas: 3}
+	tc.Status.PD.Members = map[string]v1alpha1.PDMember{}
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("%d", i)
+		tc.Status.PD.Members[id] = v1alpha1.PDMember{Name: id, Health: true}
+		tc.Status.TiKV.Stores[id] = v1alpha1.TiKVStore{ID: id, State: v1alpha1.TiKVStateUp}
+	}
+	g.Expect(control.UpdateTikvCluster(tc)).To(Succeed())
+	g.Expect(written().ClusterID).To(Equal(tc.Status.ClusterID))
+	g.Expect(utiltikvcluster.GetTikvClusterReadyCondition(*written()).Status).To(Equal(corev1.ConditionTrue))
+
+	// once allowed, the freshest status is written
+	g.Expect(controller.IsRequeueError(control.UpdateTikvCluster(tc))).To(BeTrue())
+	controller.StatusWriteInterval = 0
+	g.Expect(control.UpdateTikvCluster(tc)).To(Succeed())
+	g.Expect(written().ClusterID).To(Equal(tc.Status.ClusterID))
+}
+
+func TestGetProgress(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	type testcase struct {
+		name     string
+		update   func(*v1alpha1.TikvCluster)
+		expected string
+	}
+	tests := []testcase{
+		{
+			name:     "no statefulset",
+			update:   func(tc *v1alpha1.TikvCluster) {},
+			expected: "",
+		},
+		{
+			name: "settled",
+			update: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.StatefulSet = &apps.StatefulSetStatus{Replicas: 3, ReadyReplicas: 3}
+				tc.Status.TiKV.StatefulSet = &apps.StatefulSetStatus{Replicas: 3, ReadyReplicas: 3}
+			},
+			expected: "",
+		},
+		{
+			name: "pd upgrading ahead of tikv",
+			update: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Phase = v1alpha1.UpgradePhase
+				tc.Status.PD.StatefulSet = &apps.StatefulSetStatus{Replicas: 3, ReadyReplicas: 3, UpdatedReplicas: 1}
+				tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
+				tc.Status.TiKV.StatefulSet = &apps.StatefulSetStatus{Replicas: 3, ReadyReplicas: 3}
+			},
+			expected: "upgrading pd 1/3",
+		},
+		{
+			name: "tikv upgrading ahead of pd scaling",
+			update: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.StatefulSet = &apps.StatefulSetStatus{Replicas: 2, ReadyReplicas: 2}
+				tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
+				tc.Status.TiKV.StatefulSet = &apps.StatefulSetStatus{Replicas: 3, ReadyReplicas: 3, UpdatedReplicas: 2}
+			},
+			expected: "upgrading tikv 2/3",
+		},
+		{
+			name: "tikv scaling out",
+			update: func(tc *v1alpha1.TikvCluster) {
+				tc.Spec.TiKV.Replicas = 5
+				tc.Status.PD.StatefulSet = &apps.StatefulSetStatus{Replicas: 3, ReadyReplicas: 3}
+				tc.Status.TiKV.StatefulSet = &apps.StatefulSetStatus{Replicas: 5, ReadyReplicas: 4}
+			},
+			expected: "scaling tikv 4/5",
+		},
+		{
+			name: "tikv scaling in",
+			update: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.StatefulSet = &apps.StatefulSetStatus{Replicas: 3, ReadyReplicas: 3}
+				tc.Status.TiKV.StatefulSet = &apps.StatefulSetStatus{Replicas: 4, ReadyReplicas: 4}
+			},
+			expected: "scaling tikv 4/3",
+		},
+	}
+
+	for _, test := range tests {
+		t.Log(test.name)
+		tc := newTikvClusterForTikvClusterControl()
+		test.update(tc)
+		g.Expect(getProgress(tc)).To(Equal(test.expected))
+	}
+}
+
 func TestTikvClusterStatusEquality(t *testing.T) {
 	g := NewGomegaWithT(t)
 	tcStatus := v1alpha1.TikvClusterStatus{}
@@ -219,7 +563,8 @@ func newFakeTikvClusterControl() (
 	*mm.FakePDMemberManager,
 	*mm.FakeTiKVMemberManager,
 	*meta.FakeMetaManager,
-	*controller.FakeTikvClusterControl) {
+	*controller.FakeTikvClusterControl,
+	*controller.InformerSyncStatus) {
 	cli := fake.NewSimpleClientset()
 	tcInformer := informers.NewSharedInformerFactory(cli, 0).Tikv().V1alpha1().TikvClusters()
 	recorder := record.NewFakeRecorder(10)
@@ -229,19 +574,32 @@ func newFakeTikvClusterControl() (
 	tikvMemberManager := mm.NewFakeTiKVMemberManager()
 	metaManager := meta.NewFakeMetaManager()
 	orphanPodCleaner := mm.NewFakeOrphanPodsCleaner()
+	pvcCleaner := mm.NewFakePVCCleaner()
 	discoveryManager := mm.NewFakeDiscoveryManger()
+	syncStatus := controller.NewInformerSyncStatus()
 	control := NewDefaultTikvClusterControl(
 		tcUpdater,
+		pdapi.NewFakePDControl(kubefake.NewSimpleClientset()),
 		pdMemberManager,
 		tikvMemberManager,
 		metaManager,
 		orphanPodCleaner,
+		pvcCleaner,
+		mm.NewFakeTerminatingPVCChecker(),
 		discoveryManager,
+		mm.NewFakeTLSCertManager(),
+		mm.NewFakeTLSSecretValidator(),
+		mm.NewFakeTLSCertReloader(),
+		mm.NewFakeScrapeTLSExporter(),
+		mm.NewFakeTiKVStorageMigrator(),
 		&tikvClusterConditionUpdater{},
+		NewPortAllocator(tcInformer.Lister()),
+		NewClusterCloner(tcInformer.Lister()),
+		syncStatus,
 		recorder,
 	)
 
-	return control, orphanPodCleaner, pdMemberManager, tikvMemberManager, metaManager, tcUpdater
+	return control, orphanPodCleaner, pdMemberManager, tikvMemberManager, metaManager, tcUpdater, syncStatus
 }
 
 func newTikvClusterForTikvClusterControl() *v1alpha1.TikvCluster {
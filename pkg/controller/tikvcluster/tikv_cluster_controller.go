@@ -23,13 +23,18 @@ import (
 	informers "github.com/tikv/tikv-operator/pkg/client/informers/externalversions"
 	listers "github.com/tikv/tikv-operator/pkg/client/listers/tikv/v1alpha1"
 	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/deps"
+	"github.com/tikv/tikv-operator/pkg/label"
 	mm "github.com/tikv/tikv-operator/pkg/manager/member"
 	"github.com/tikv/tikv-operator/pkg/manager/meta"
 	"github.com/tikv/tikv-operator/pkg/pdapi"
+	"github.com/tikv/tikv-operator/pkg/util"
 	apps "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	kubeinformers "k8s.io/client-go/informers"
@@ -38,7 +43,6 @@ import (
 	appslisters "k8s.io/client-go/listers/apps/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
-	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -60,8 +64,12 @@ type Controller struct {
 	setLister appslisters.StatefulSetLister
 	// setListerSynced returns true if the statefulset shared informer has synced at least once
 	setListerSynced cache.InformerSynced
-	// tikvclusters that need to be synced.
-	queue workqueue.RateLimitingInterface
+	// tikvclusters that need to be synced, spec changes are synced ahead of audits.
+	queue *controller.PriorityQueue
+	// relist spreads the audits of the tikvclusters re-delivered by a relist of the informer
+	relist *controller.RelistDetector
+	// scrapeTLSExporter deletes the client certificates exported by the tikvclusters deleted
+	scrapeTLSExporter mm.ScrapeTLSExporter
 }
 
 // NewController creates a tikvcluster controller.
@@ -69,17 +77,22 @@ func NewController(
 	kubeCli kubernetes.Interface,
 	cli versioned.Interface,
 	genericCli client.Client,
+	pdControl pdapi.PDControlInterface,
 	informerFactory informers.SharedInformerFactory,
 	kubeInformerFactory kubeinformers.SharedInformerFactory,
 	autoFailover bool,
 	pdFailoverPeriod time.Duration,
 	tikvFailoverPeriod time.Duration,
+	syncStatus *controller.InformerSyncStatus,
 ) *Controller {
 	eventBroadcaster := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{QPS: 1})
 	eventBroadcaster.StartLogging(klog.V(2).Infof)
 	eventBroadcaster.StartRecordingToSink(&eventv1.EventSinkImpl{
 		Interface: eventv1.New(kubeCli.CoreV1().RESTClient()).Events("")})
-	recorder := eventBroadcaster.NewRecorder(v1alpha1.Scheme, corev1.EventSource{Component: "tikv-controller-manager"})
+	// the events of a flapping cluster are capped on top of the aggregation of the recorder
+	recorder := controller.NewRateLimitedRecorder(
+		eventBroadcaster.NewRecorder(v1alpha1.Scheme, corev1.EventSource{Component: "tikv-controller-manager"}),
+		mm.FailoverEventReasons...)
 
 	tcInformer := informerFactory.Tikv().V1alpha1().TikvClusters()
 	setInformer := kubeInformerFactory.Apps().V1().StatefulSets()
@@ -89,56 +102,55 @@ func NewController(
 	pvInformer := kubeInformerFactory.Core().V1().PersistentVolumes()
 	podInformer := kubeInformerFactory.Core().V1().Pods()
 	nodeInformer := kubeInformerFactory.Core().V1().Nodes()
-
+	cmInformer := kubeInformerFactory.Core().V1().ConfigMaps()
+	deployInformer := kubeInformerFactory.Apps().V1().Deployments()
+	secretInformer := kubeInformerFactory.Core().V1().Secrets()
+
+	syncStatus.Add(controller.TikvClusterInformer, tcInformer.Informer().HasSynced)
+	syncStatus.Add(controller.StatefulSetInformer, setInformer.Informer().HasSynced)
+	syncStatus.Add(controller.ServiceInformer, svcInformer.Informer().HasSynced)
+	syncStatus.Add(controller.ConfigMapInformer, cmInformer.Informer().HasSynced)
+	syncStatus.Add(controller.EndpointsInformer, epsInformer.Informer().HasSynced)
+	syncStatus.Add(controller.NodeInformer, nodeInformer.Informer().HasSynced)
+	syncStatus.Add(controller.PodInformer, podInformer.Informer().HasSynced)
+	syncStatus.Add(controller.PVCInformer, pvcInformer.Informer().HasSynced)
+	syncStatus.Add(controller.PVInformer, pvInformer.Informer().HasSynced)
+
+	dependencies := deps.NewDependencies(kubeCli, cli, genericCli, pdControl, informerFactory, kubeInformerFactory, recorder)
 	tcControl := controller.NewRealTikvClusterControl(cli, tcInformer.Lister(), recorder)
-	pdControl := pdapi.NewDefaultPDControl(kubeCli)
-	setControl := controller.NewRealStatefuSetControl(kubeCli, setInformer.Lister(), recorder)
-	svcControl := controller.NewRealServiceControl(kubeCli, svcInformer.Lister(), recorder)
 	pvControl := controller.NewRealPVControl(kubeCli, pvcInformer.Lister(), pvInformer.Lister(), recorder)
-	pvcControl := controller.NewRealPVCControl(kubeCli, recorder, pvcInformer.Lister())
-	podControl := controller.NewRealPodControl(kubeCli, pdControl, podInformer.Lister(), recorder)
-	typedControl := controller.NewTypedControl(controller.NewRealGenericControl(genericCli, recorder))
-	pdScaler := mm.NewPDScaler(pdControl, pvcInformer.Lister(), pvcControl)
-	tikvScaler := mm.NewTiKVScaler(pdControl, pvcInformer.Lister(), pvcControl, podInformer.Lister())
+	pvcControl := dependencies.PVCControl
+	podControl := dependencies.PodControl
+	typedControl := dependencies.TypedControl
+	pdScaler := mm.NewPDScaler(dependencies)
+	tikvScaler := mm.NewTiKVScaler(dependencies)
 	pdFailover := mm.NewPDFailover(cli, pdControl, pdFailoverPeriod, podInformer.Lister(), podControl, pvcInformer.Lister(), pvcControl, pvInformer.Lister(), recorder)
 	tikvFailover := mm.NewTiKVFailover(tikvFailoverPeriod, recorder)
-	pdUpgrader := mm.NewPDUpgrader(pdControl, podControl, podInformer.Lister())
-	tikvUpgrader := mm.NewTiKVUpgrader(pdControl, podControl, podInformer.Lister())
+	pdUpgrader := mm.NewPDUpgrader(dependencies)
+	tikvUpgrader := mm.NewTiKVUpgrader(dependencies)
+	scrapeTLSExporter := mm.NewScrapeTLSExporter(kubeCli, secretInformer.Lister(), recorder)
 
 	tcc := &Controller{
 		kubeClient: kubeCli,
 		cli:        cli,
 		control: NewDefaultTikvClusterControl(
 			tcControl,
+			pdControl,
 			mm.NewPDMemberManager(
-				pdControl,
-				setControl,
-				svcControl,
-				podControl,
-				typedControl,
-				setInformer.Lister(),
-				svcInformer.Lister(),
-				podInformer.Lister(),
-				epsInformer.Lister(),
-				pvcInformer.Lister(),
+				dependencies,
 				pdScaler,
 				pdUpgrader,
 				autoFailover,
 				pdFailover,
+				syncStatus,
 			),
 			mm.NewTiKVMemberManager(
-				pdControl,
-				setControl,
-				svcControl,
-				typedControl,
-				setInformer.Lister(),
-				svcInformer.Lister(),
-				podInformer.Lister(),
-				nodeInformer.Lister(),
+				dependencies,
 				autoFailover,
 				tikvFailover,
 				tikvScaler,
 				tikvUpgrader,
+				syncStatus,
 			),
 			meta.NewMetaManager(
 				pvcInformer.Lister(),
@@ -154,19 +166,60 @@ func NewController(
 				pvcInformer.Lister(),
 				kubeCli,
 			),
+			mm.NewPVCCleaner(
+				podInformer.Lister(),
+				pvcControl,
+				pvcInformer.Lister(),
+			),
+			mm.NewTerminatingPVCChecker(
+				podInformer.Lister(),
+				pvcControl,
+				pvcInformer.Lister(),
+				recorder,
+			),
 			mm.NewPDDiscoveryManager(typedControl),
+			mm.NewTLSCertManager(typedControl, recorder),
+			mm.NewTLSSecretValidator(secretInformer.Lister(), recorder),
+			mm.NewTLSCertReloader(secretInformer.Lister(), recorder),
+			scrapeTLSExporter,
+			mm.NewTiKVStorageMigrator(
+				pdControl,
+				setInformer.Lister(),
+				pvcInformer.Lister(),
+				controller.NewRealGeneralPVCControl(kubeCli, recorder),
+				recorder,
+			),
 			&tikvClusterConditionUpdater{},
+			NewPortAllocator(tcInformer.Lister()),
+			NewClusterCloner(tcInformer.Lister()),
+			syncStatus,
 			recorder,
 		),
-		queue: workqueue.NewNamedRateLimitingQueue(
-			workqueue.DefaultControllerRateLimiter(),
-			"tikvcluster",
-		),
+		queue: controller.NewPriorityQueue("tikvcluster"),
+		relist: controller.NewRelistDetector("tikvclusters", controller.RelistSpreadWindow, func() {
+			// the spread audits of all the clusters read from PD again, serve their status
+			// reads from the cache for the whole spread window, the reads of the clusters
+			// being upgraded or scaled are not StatusOnly and keep the normal TTL
+			window := controller.RelistSpreadWindow
+			pdapi.ExtendResponseCacheTTL(window, time.Now().Add(2*window))
+		}),
+		scrapeTLSExporter: scrapeTLSExporter,
 	}
 
 	tcInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: tcc.enqueueTikvCluster,
 		UpdateFunc: func(old, cur interface{}) {
+			// periodic resyncs and status changes leave the generation untouched,
+			// forced syncs are requested by users so they are not queued behind audits
+			oldTC, curTC := old.(*v1alpha1.TikvCluster), cur.(*v1alpha1.TikvCluster)
+			if delay, relisted := tcc.relist.Observe(old, cur); relisted {
+				tcc.enqueueTikvClusterForAuditAfter(cur, delay)
+				return
+			}
+			if oldTC.Generation == curTC.Generation && oldTC.Annotations[label.AnnForceSyncKey] == curTC.Annotations[label.AnnForceSyncKey] {
+				tcc.enqueueTikvClusterForAudit(cur)
+				return
+			}
 			tcc.enqueueTikvCluster(cur)
 		},
 		DeleteFunc: tcc.enqueueTikvCluster,
@@ -184,11 +237,48 @@ func NewController(
 	tcc.setLister = setInformer.Lister()
 	tcc.setListerSynced = setInformer.Informer().HasSynced
 
+	// Dependent objects owned by a TikvCluster, deleting any of them must
+	// trigger a sync right away rather than at the next resync.
+	for _, informer := range []cache.SharedIndexInformer{
+		svcInformer.Informer(),
+		cmInformer.Informer(),
+		deployInformer.Informer(),
+	} {
+		controller.WatchForController(informer, tcc.queue, tcc.getTikvCluster, label.New().Labels())
+	}
+
+	// ConfigMaps referenced by spec.tikv.configRef are not owned by the TikvCluster,
+	// editing them must roll out the new config as well.
+	cmInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: tcc.enqueueTikvClustersForConfigMap,
+		UpdateFunc: func(old, cur interface{}) {
+			if old.(*corev1.ConfigMap).ResourceVersion == cur.(*corev1.ConfigMap).ResourceVersion {
+				return
+			}
+			tcc.enqueueTikvClustersForConfigMap(cur)
+		},
+		DeleteFunc: tcc.enqueueTikvClustersForConfigMap,
+	})
+
+	// The cluster TLS secrets are rotated by cert-manager, the members must load the new
+	// certificates, see TLSCertReloader. Updating the master key of the encryption at rest
+	// rolls tikv. The client certificate exported for Prometheus is copied again, and so is
+	// an exported copy edited or deleted.
+	secretInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, cur interface{}) {
+			if old.(*corev1.Secret).ResourceVersion == cur.(*corev1.Secret).ResourceVersion {
+				return
+			}
+			tcc.enqueueTikvClustersForSecret(cur.(*corev1.Secret))
+		},
+		DeleteFunc: tcc.enqueueTikvClusterForScrapeTLSSecret,
+	})
+
 	return tcc
 }
 
-// Run runs the tikvcluster controller.
-func (tcc *Controller) Run(workers int, stopCh <-chan struct{}) {
+// Run runs the tikvcluster controller, auditWorkers caps the number of workers syncing audit keys.
+func (tcc *Controller) Run(workers, auditWorkers int, stopCh <-chan struct{}) {
 	defer utilruntime.HandleCrash()
 	defer tcc.queue.ShutDown()
 
@@ -196,35 +286,48 @@ func (tcc *Controller) Run(workers int, stopCh <-chan struct{}) {
 	defer klog.Info("Shutting down tikvcluster controller")
 
 	for i := 0; i < workers; i++ {
-		go wait.Until(tcc.worker, time.Second, stopCh)
+		go wait.Until(func() { tcc.worker(controller.SpecQueue) }, time.Second, stopCh)
+	}
+	for i := 0; i < auditWorkers; i++ {
+		go wait.Until(func() { tcc.worker(controller.AuditQueue) }, time.Second, stopCh)
 	}
+	go wait.Until(tcc.queue.UpdateMetrics, 10*time.Second, stopCh)
 
 	<-stopCh
 }
 
 // worker runs a worker goroutine that invokes processNextWorkItem until the the controller's queue is closed
-func (tcc *Controller) worker() {
-	for tcc.processNextWorkItem() {
+func (tcc *Controller) worker(kind controller.QueueKind) {
+	for tcc.processNextWorkItem(kind) {
 	}
 }
 
 // processNextWorkItem dequeues items, processes them, and marks them done. It enforces that the syncHandler is never
 // invoked concurrently with the same key.
-func (tcc *Controller) processNextWorkItem() bool {
-	key, quit := tcc.queue.Get()
+func (tcc *Controller) processNextWorkItem(kind controller.QueueKind) bool {
+	key, quit := tcc.queue.Get(kind)
 	if quit {
 		return false
 	}
-	defer tcc.queue.Done(key)
+	defer tcc.queue.Done(kind, key)
 	if err := tcc.sync(key.(string)); err != nil {
-		if perrors.Find(err, controller.IsRequeueError) != nil {
+		if requeueErr := perrors.Find(err, controller.IsRequeueError); requeueErr != nil {
 			klog.Infof("TikvCluster: %v, still need sync: %v, requeuing", key.(string), err)
+			if after, ok := controller.RequeueAfter(requeueErr); ok {
+				tcc.queue.AddAfter(kind, key, after)
+			} else {
+				tcc.queue.AddRateLimited(kind, key)
+			}
+		} else if perrors.Find(err, controller.IsIgnoreError) != nil {
+			// e.g. the cluster is being deleted, it is synced again if it is updated
+			klog.V(4).Infof("TikvCluster: %v, ignore err: %v", key.(string), err)
+			tcc.queue.Forget(kind, key)
 		} else {
 			utilruntime.HandleError(fmt.Errorf("TikvCluster: %v, sync failed %v, requeuing", key.(string), err))
+			tcc.queue.AddRateLimited(kind, key)
 		}
-		tcc.queue.AddRateLimited(key)
 	} else {
-		tcc.queue.Forget(key)
+		tcc.queue.Forget(kind, key)
 	}
 	return true
 }
@@ -243,13 +346,14 @@ func (tcc *Controller) sync(key string) error {
 	tc, err := tcc.tcLister.TikvClusters(ns).Get(name)
 	if errors.IsNotFound(err) {
 		klog.Infof("TikvCluster has been deleted %v", key)
-		return nil
+		// the exported copies are in other namespaces, they are not garbage collected
+		return tcc.scrapeTLSExporter.Cleanup(ns, name)
 	}
 	if err != nil {
 		return err
 	}
 
-	return tcc.syncTikvCluster(tc.DeepCopy())
+	return tcc.syncTikvCluster(tc.DeepCopyForMutation())
 }
 
 func (tcc *Controller) syncTikvCluster(tc *v1alpha1.TikvCluster) error {
@@ -266,6 +370,26 @@ func (tcc *Controller) enqueueTikvCluster(obj interface{}) {
 	tcc.queue.Add(key)
 }
 
+// enqueueTikvClusterForAudit enqueues the given tikvcluster in the audit queue.
+func (tcc *Controller) enqueueTikvClusterForAudit(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("Cound't get key for object %+v: %v", obj, err))
+		return
+	}
+	tcc.queue.AddAudit(key)
+}
+
+// enqueueTikvClusterForAuditAfter enqueues the given tikvcluster in the audit queue after the delay.
+func (tcc *Controller) enqueueTikvClusterForAuditAfter(obj interface{}, delay time.Duration) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("Cound't get key for object %+v: %v", obj, err))
+		return
+	}
+	tcc.queue.AddAuditAfter(key, delay)
+}
+
 // addStatefulSet adds the tikvcluster for the statefulset to the sync queue
 func (tcc *Controller) addStatefulSet(obj interface{}) {
 	set := obj.(*apps.StatefulSet)
@@ -340,6 +464,128 @@ func (tcc *Controller) deleteStatefulSet(obj interface{}) {
 	tcc.enqueueTikvCluster(tc)
 }
 
+// enqueueTikvClustersForConfigMap enqueues the tikvclusters referencing the configmap in spec.tikv.configRef.
+func (tcc *Controller) enqueueTikvClustersForConfigMap(obj interface{}) {
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("couldn't get object from tombstone %+v", obj))
+			return
+		}
+		cm, ok = tombstone.Obj.(*corev1.ConfigMap)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("tombstone contained object that is not a configmap %+v", obj))
+			return
+		}
+	}
+
+	tcs, err := tcc.tcLister.TikvClusters(cm.GetNamespace()).List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to list TikvClusters in namespace %s: %v", cm.GetNamespace(), err))
+		return
+	}
+	for _, tc := range tcs {
+		if !referencesConfigMap(tc, cm.GetName()) {
+			continue
+		}
+		klog.V(4).Infof("ConfigMap %s/%s referenced by TikvCluster %s changed", cm.GetNamespace(), cm.GetName(), tc.GetName())
+		tcc.enqueueTikvCluster(tc)
+	}
+}
+
+// enqueueTikvClustersForSecret enqueues the tikvclusters of the cluster TLS secret of pd or tikv
+// or of the master key or the kms credentials of the tikv encryption.
+func (tcc *Controller) enqueueTikvClustersForSecret(secret *corev1.Secret) {
+	if tcc.enqueueTikvClusterForScrapeTLSSecret(secret) {
+		return
+	}
+	tcs, err := tcc.tcLister.TikvClusters(secret.GetNamespace()).List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to list TikvClusters in namespace %s: %v", secret.GetNamespace(), err))
+		return
+	}
+	for _, tc := range tcs {
+		if !referencesSecret(tc, secret.GetName()) {
+			continue
+		}
+		klog.V(4).Infof("Secret %s/%s of TikvCluster %s changed", secret.GetNamespace(), secret.GetName(), tc.GetName())
+		tcc.enqueueTikvCluster(tc)
+	}
+}
+
+// enqueueTikvClusterForScrapeTLSSecret enqueues the tikvcluster that exported the client
+// certificate, it returns false if the secret is not an exported copy
+func (tcc *Controller) enqueueTikvClusterForScrapeTLSSecret(obj interface{}) bool {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			return false
+		}
+		if secret, ok = tombstone.Obj.(*corev1.Secret); !ok {
+			return false
+		}
+	}
+	l := label.Label(secret.Labels)
+	if !l.IsManagedByTiKVOperator() || l[label.ComponentLabelKey] != label.ScrapeTLSLabelVal {
+		return false
+	}
+	ns, tcName := l[label.NamespaceLabelKey], l[label.InstanceLabelKey]
+	if ns == "" || tcName == "" {
+		return false
+	}
+	klog.V(4).Infof("Scrape tls secret %s/%s of TikvCluster %s/%s changed", secret.GetNamespace(), secret.GetName(), ns, tcName)
+	tcc.queue.Add(fmt.Sprintf("%s/%s", ns, tcName))
+	return true
+}
+
+// referencesSecret returns whether the secret is a cluster TLS secret, the client certificate
+// exported for Prometheus or an encryption secret of the tikvcluster
+func referencesSecret(tc *v1alpha1.TikvCluster, name string) bool {
+	if encryption := tc.Spec.TiKV.Encryption; encryption != nil {
+		if encryption.SecretRef != nil && encryption.SecretRef.Name == name {
+			return true
+		}
+		if encryption.KMS != nil && encryption.KMS.CredentialsSecretRef != nil && encryption.KMS.CredentialsSecretRef.Name == name {
+			return true
+		}
+	}
+	if !tc.IsTLSClusterEnabled() {
+		return false
+	}
+	if monitoring := tc.Spec.Monitoring; monitoring != nil && monitoring.ExportScrapeTLS != nil && name == util.ClusterClientTLSSecretName(tc.GetName()) {
+		return true
+	}
+	return name == util.ClusterTLSSecretName(tc.GetName(), label.PDLabelVal) || name == util.ClusterTLSSecretName(tc.GetName(), label.TiKVLabelVal)
+}
+
+// referencesConfigMap returns whether the tikv config or any of its layers is read from the ConfigMap
+func referencesConfigMap(tc *v1alpha1.TikvCluster, name string) bool {
+	if ref := tc.Spec.TiKV.ConfigRef; ref != nil && ref.ConfigMapName == name {
+		return true
+	}
+	for _, layer := range tc.Spec.TiKV.ConfigLayers {
+		if layer.ConfigRef != nil && layer.ConfigRef.ConfigMapName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// getTikvCluster returns the TikvCluster with its TypeMeta populated, objects
+// from the lister have an empty TypeMeta which WatchForController relies on
+// to verify the controller ref.
+func (tcc *Controller) getTikvCluster(ns, name string) (runtime.Object, error) {
+	tc, err := tcc.tcLister.TikvClusters(ns).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	tc = tc.DeepCopyForMutation()
+	tc.SetGroupVersionKind(controller.ControllerKind)
+	return tc, nil
+}
+
 // resolveTikvClusterFromSet returns the TikvCluster by a StatefulSet,
 // or nil if the StatefulSet could not be resolved to a matching TikvCluster
 // of the correct Kind.
@@ -21,6 +21,7 @@ import (
 	"github.com/tikv/tikv-operator/pkg/client/clientset/versioned"
 	tcinformers "github.com/tikv/tikv-operator/pkg/client/informers/externalversions/tikv/v1alpha1"
 	listers "github.com/tikv/tikv-operator/pkg/client/listers/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/label"
 	corev1 "k8s.io/api/core/v1"
 	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -58,6 +59,20 @@ func (rtc *realTikvClusterControl) UpdateTikvCluster(tc *v1alpha1.TikvCluster, n
 	tcName := tc.GetName()
 
 	status := tc.Status.DeepCopy()
+	// the ports allocated are written along with the status, they must survive the retries as well
+	ports, portsAnnotated := tc.Annotations[label.AnnAllocatedPortsKey]
+	// the recover failover annotation removed once the failover is recovered must not come back
+	_, recoverAnnotated := tc.Annotations[label.AnnRecoverFailoverKey]
+	// so is the spec cloned, which is not cloned again once status.clone is recorded
+	var clonedSpec *v1alpha1.TikvClusterSpec
+	if newStatus.Clone != nil && oldStatus.Clone == nil {
+		clonedSpec = tc.Spec.DeepCopy()
+	}
+	// and so are the fields of the tikv spec the storage migration drives
+	var migratedTiKVSpec *v1alpha1.TiKVSpec
+	if !apiequality.Semantic.DeepEqual(newStatus.TiKV.StorageMigration, oldStatus.TiKV.StorageMigration) {
+		migratedTiKVSpec = tc.Spec.TiKV.DeepCopy()
+	}
 	var updateTC *v1alpha1.TikvCluster
 
 	// don't wait due to limited number of clients, but backoff after the default number of steps
@@ -72,8 +87,25 @@ func (rtc *realTikvClusterControl) UpdateTikvCluster(tc *v1alpha1.TikvCluster, n
 
 		if updated, err := rtc.tcLister.TikvClusters(ns).Get(tcName); err == nil {
 			// make a copy so we don't mutate the shared cache
-			tc = updated.DeepCopy()
+			tc = updated.DeepCopyForMutation()
 			tc.Status = *status
+			if portsAnnotated {
+				if tc.Annotations == nil {
+					tc.Annotations = map[string]string{}
+				}
+				tc.Annotations[label.AnnAllocatedPortsKey] = ports
+			}
+			if !recoverAnnotated {
+				delete(tc.Annotations, label.AnnRecoverFailoverKey)
+			}
+			if clonedSpec != nil {
+				tc.Spec = *clonedSpec
+			}
+			if migratedTiKVSpec != nil {
+				tc.Spec.TiKV.OfflineStores = migratedTiKVSpec.OfflineStores
+				tc.Spec.TiKV.StorageClassName = migratedTiKVSpec.StorageClassName
+				tc.Spec.TiKV.StorageMigration = migratedTiKVSpec.StorageMigration
+			}
 		} else {
 			utilruntime.HandleError(fmt.Errorf("error getting updated TikvCluster %s/%s from lister: %v", ns, tcName, err))
 		}
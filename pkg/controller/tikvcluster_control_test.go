@@ -23,6 +23,7 @@ import (
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
 	"github.com/tikv/tikv-operator/pkg/client/clientset/versioned/fake"
 	listers "github.com/tikv/tikv-operator/pkg/client/listers/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/label"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -68,6 +69,65 @@ func TestTikvClusterControlUpdateTikvClusterConflictSuccess(t *testing.T) {
 	g.Expect(err).To(Succeed())
 }
 
+func TestTikvClusterControlUpdateTikvClusterConflictKeepsAllocatedPorts(t *testing.T) {
+	g := NewGomegaWithT(t)
+	recorder := record.NewFakeRecorder(10)
+	tc := newTikvCluster()
+	fakeClient := &fake.Clientset{}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	// the cluster in the lister is not annotated yet
+	g.Expect(indexer.Add(tc.DeepCopy())).To(Succeed())
+	tcLister := listers.NewTikvClusterLister(indexer)
+	control := NewRealTikvClusterControl(fakeClient, tcLister, recorder)
+	conflict := false
+	var updated *v1alpha1.TikvCluster
+	fakeClient.AddReactor("update", "tikvclusters", func(action core.Action) (bool, runtime.Object, error) {
+		update := action.(core.UpdateAction)
+		if !conflict {
+			conflict = true
+			return true, update.GetObject(), apierrors.NewConflict(action.GetResource().GroupResource(), tc.Name, errors.New("conflict"))
+		}
+		updated = update.GetObject().(*v1alpha1.TikvCluster)
+		return true, update.GetObject(), nil
+	})
+
+	tc.Annotations = map[string]string{label.AnnAllocatedPortsKey: `{"pdClient":21000,"pdPeer":21001,"tikv":21002,"tikvStatus":21003}`}
+	_, err := control.UpdateTikvCluster(tc, &v1alpha1.TikvClusterStatus{}, &v1alpha1.TikvClusterStatus{})
+	g.Expect(err).To(Succeed())
+	g.Expect(updated.Annotations).To(HaveKeyWithValue(label.AnnAllocatedPortsKey, tc.Annotations[label.AnnAllocatedPortsKey]))
+}
+
+func TestTikvClusterControlUpdateTikvClusterConflictKeepsRecoverFailoverRemoved(t *testing.T) {
+	g := NewGomegaWithT(t)
+	recorder := record.NewFakeRecorder(10)
+	tc := newTikvCluster()
+	fakeClient := &fake.Clientset{}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	// the cluster in the lister is still annotated to recover the failover
+	cached := tc.DeepCopy()
+	cached.Annotations = map[string]string{label.AnnRecoverFailoverKey: "true"}
+	g.Expect(indexer.Add(cached)).To(Succeed())
+	tcLister := listers.NewTikvClusterLister(indexer)
+	control := NewRealTikvClusterControl(fakeClient, tcLister, recorder)
+	conflict := false
+	var updated *v1alpha1.TikvCluster
+	fakeClient.AddReactor("update", "tikvclusters", func(action core.Action) (bool, runtime.Object, error) {
+		update := action.(core.UpdateAction)
+		if !conflict {
+			conflict = true
+			return true, update.GetObject(), apierrors.NewConflict(action.GetResource().GroupResource(), tc.Name, errors.New("conflict"))
+		}
+		updated = update.GetObject().(*v1alpha1.TikvCluster)
+		return true, update.GetObject(), nil
+	})
+
+	// the failover is recovered and the annotation removed by the sync
+	tc.Annotations = map[string]string{}
+	_, err := control.UpdateTikvCluster(tc, &v1alpha1.TikvClusterStatus{}, &v1alpha1.TikvClusterStatus{})
+	g.Expect(err).To(Succeed())
+	g.Expect(updated.Annotations).NotTo(HaveKey(label.AnnRecoverFailoverKey))
+}
+
 func TestDeepEqualExceptHeartbeatTime(t *testing.T) {
 	g := NewGomegaWithT(t)
 
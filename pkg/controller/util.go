@@ -15,20 +15,34 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
+	stderrors "errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/dustin/go-humanize"
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/label"
 	"github.com/tikv/tikv-operator/pkg/scheme"
 	"github.com/tikv/tikv-operator/pkg/util"
+	apps "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	errorutils "k8s.io/apimachinery/pkg/util/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/retry"
 	"k8s.io/client-go/util/workqueue"
@@ -51,31 +65,90 @@ var (
 
 	// PDDiscoveryImage is the image of pd discovery service
 	PDDiscoveryImage string
+
+	// DataDirInitImage is the image of the init container chowning the data directory of the
+	// pods running as non-root, empty disables the init container
+	DataDirInitImage string
+
+	// LegacyPromAnnotations controls whether the old style <name>.prometheus.io/port annotations
+	// are kept for scrape configs relying on them, some scrape configs take them as additional
+	// targets with the default metrics path which scrapes duplicated series
+	LegacyPromAnnotations bool
+
+	// StatusUpdateInterval is the minimum interval between the status writes of a cluster that
+	// only report progress, e.g. one more pod upgraded, to keep a large rollout from writing
+	// etcd on every sync. 0 writes every change at once
+	StatusUpdateInterval = 5 * time.Second
+
+	// SharedNodesPortRange is the range the ports of the clusters in sharedNodes network mode are
+	// allocated from, a block of 4 ports for each cluster
+	SharedNodesPortRange = utilnet.PortRange{Base: 21000, Size: 1000}
+
+	// PVCTerminatingTimeout is how long the pod of a member waits for its old PVC to be deleted
+	// before the PVC is reported stuck terminating
+	PVCTerminatingTimeout = 5 * time.Minute
+
+	// RemoveStuckPVCProtection controls whether the pvc-protection finalizer of a PVC stuck
+	// terminating is removed once no pod uses the PVC any more
+	RemoveStuckPVCProtection bool
 )
 
 const (
+	// legacyPromPortSuffix is the suffix of the old style <name>.prometheus.io/port annotations
+	legacyPromPortSuffix = ".prometheus.io/port"
+
 	// defaultTiDBSlowLogImage is default image of tikv log tailer
 	defaultTiDBLogTailerImage = "busybox:1.26.2"
 )
 
+var (
+	// ErrRequeue matches any RequeueError with errors.Is, even if it is wrapped
+	ErrRequeue = stderrors.New("requeue")
+	// ErrIgnore matches any IgnoreError with errors.Is, even if it is wrapped
+	ErrIgnore = stderrors.New("ignore")
+)
+
 // RequeueError is used to requeue the item, this error type should't be considered as a real error
 type RequeueError struct {
 	s string
+	// after is how long to wait before requeueing, 0 requeues rate limited
+	after time.Duration
 }
 
 func (re *RequeueError) Error() string {
 	return re.s
 }
 
+// Is makes errors.Is(err, ErrRequeue) report whether err is or wraps a RequeueError
+func (re *RequeueError) Is(target error) bool {
+	return target == ErrRequeue
+}
+
 // RequeueErrorf returns a RequeueError
 func RequeueErrorf(format string, a ...interface{}) error {
-	return &RequeueError{fmt.Sprintf(format, a...)}
+	return &RequeueError{s: fmt.Sprintf(format, a...)}
+}
+
+// RequeueErrorAfter returns a RequeueError requeueing the item after the given duration
+// rather than rate limited, for the waits of known lengths
+func RequeueErrorAfter(after time.Duration, format string, a ...interface{}) error {
+	return &RequeueError{s: fmt.Sprintf(format, a...), after: after}
+}
+
+// RequeueAfter returns how long to wait before requeueing the item of err, which is or wraps
+// a RequeueError, false if it is not a RequeueError or it should be requeued rate limited
+func RequeueAfter(err error) (time.Duration, bool) {
+	var requeueErr *RequeueError
+	if !stderrors.As(err, &requeueErr) || requeueErr.after <= 0 {
+		return 0, false
+	}
+	return requeueErr.after, true
 }
 
-// IsRequeueError returns whether err is a RequeueError
+// IsRequeueError returns whether err is or wraps a RequeueError
 func IsRequeueError(err error) bool {
-	_, ok := err.(*RequeueError)
-	return ok
+	var requeueErr *RequeueError
+	return stderrors.As(err, &requeueErr)
 }
 
 // IgnoreError is used to ignore this item, this error type should't be considered as a real error, no need to requeue
@@ -87,15 +160,45 @@ func (re *IgnoreError) Error() string {
 	return re.s
 }
 
+// Is makes errors.Is(err, ErrIgnore) report whether err is or wraps an IgnoreError
+func (re *IgnoreError) Is(target error) bool {
+	return target == ErrIgnore
+}
+
 // IgnoreErrorf returns a IgnoreError
 func IgnoreErrorf(format string, a ...interface{}) error {
 	return &IgnoreError{fmt.Sprintf(format, a...)}
 }
 
-// IsIgnoreError returns whether err is a IgnoreError
+// IsIgnoreError returns whether err is or wraps a IgnoreError
 func IsIgnoreError(err error) bool {
-	_, ok := err.(*IgnoreError)
-	return ok
+	var ignoreErr *IgnoreError
+	return stderrors.As(err, &ignoreErr)
+}
+
+// IsHarmlessError returns whether err, or any error it wraps, is a NotFound error or an
+// IgnoreError, which the controllers swallow. RequeueErrors are harmless as well if requeue is
+// true, for the callers that retry them later anyway.
+func IsHarmlessError(err error, requeue bool) bool {
+	if err == nil {
+		return false
+	}
+	var statusErr errors.APIStatus
+	if stderrors.As(err, &statusErr) && statusErr.Status().Reason == metav1.StatusReasonNotFound {
+		return true
+	}
+	var ignoreErr *IgnoreError
+	if stderrors.As(err, &ignoreErr) {
+		return true
+	}
+	var requeueErr *RequeueError
+	return requeue && stderrors.As(err, &requeueErr)
+}
+
+// IsClusterPaused returns whether the reconciliation of the TikvCluster is paused by spec.paused,
+// the status of a paused cluster is still synced but nothing is changed for it
+func IsClusterPaused(tc *v1alpha1.TikvCluster) bool {
+	return tc.Spec.Paused
 }
 
 // GetOwnerRef returns TikvCluster's OwnerReference
@@ -112,6 +215,26 @@ func GetOwnerRef(tc *v1alpha1.TikvCluster) metav1.OwnerReference {
 	}
 }
 
+// GetNonControllerOwnerRef returns a TikvCluster's non-controlling OwnerReference, the resources
+// shared with others (e.g. TLS secrets) are garbage collected with the cluster without blocking
+// its deletion
+func GetNonControllerOwnerRef(tc *v1alpha1.TikvCluster) metav1.OwnerReference {
+	controller := false
+	blockOwnerDeletion := false
+	ref := GetOwnerRef(tc)
+	ref.Controller = &controller
+	ref.BlockOwnerDeletion = &blockOwnerDeletion
+	return ref
+}
+
+// OwnerRefs returns the OwnerReferences of a resource owned by a TikvCluster, controlling or not
+func OwnerRefs(tc *v1alpha1.TikvCluster, controller bool) []metav1.OwnerReference {
+	if controller {
+		return []metav1.OwnerReference{GetOwnerRef(tc)}
+	}
+	return []metav1.OwnerReference{GetNonControllerOwnerRef(tc)}
+}
+
 // TiKVCapacity returns string resource requirement. In tikv-server, KB/MB/GB
 // equal to MiB/GiB/TiB, so we cannot use resource.String() directly.
 // Minimum unit we use is MiB, capacity less than 1MiB is ignored.
@@ -127,104 +250,307 @@ func TiKVCapacity(limits corev1.ResourceList) string {
 	if !ok {
 		return defaultArgs
 	}
-	i, b := q.AsInt64()
-	if !b {
-		klog.Errorf("quantity %s can't be converted to int64", q.String())
-		return defaultArgs
+	// Value rounds up the fractional quantities, e.g. 0.5Ti, which can't be converted by AsInt64
+	return formatCapacity(q.Value())
+}
+
+// TiFlashCapacity returns the combined capacity of storageClaims TiFlash storage claims
+// in the same units as TiKVCapacity, each claim is limited by the storage limit of limits,
+// a missing storage limit counts as zero. Like TiKVCapacity, the capacity less than 1MiB
+// left over by the sum is ignored.
+func TiFlashCapacity(limits corev1.ResourceList, storageClaims int) string {
+	claims := make([]corev1.ResourceRequirements, 0, storageClaims)
+	for i := 0; i < storageClaims; i++ {
+		claims = append(claims, corev1.ResourceRequirements{Limits: limits})
+	}
+	return sumTiFlashCapacity(claims)
+}
+
+// sumTiFlashCapacity returns the combined capacity of the TiFlash storage claims, given by
+// the resources of each claim, the claims without a storage limit are counted as zero. The
+// sum is truncated to MiB, e.g. 1.5Gi + 100Ki is 1536MB.
+func sumTiFlashCapacity(claims []corev1.ResourceRequirements) string {
+	var total int64
+	for _, claim := range claims {
+		q, ok := claim.Limits[corev1.ResourceStorage]
+		if !ok {
+			continue
+		}
+		total += q.Value()
 	}
+	if total == 0 {
+		return "0"
+	}
+	return formatCapacity(total)
+}
+
+// formatCapacity formats the bytes in units understood by tikv-server, the remainder
+// less than 1MiB is truncated
+func formatCapacity(i int64) string {
 	if i%humanize.GiByte == 0 {
 		return fmt.Sprintf("%dGB", i/humanize.GiByte)
 	}
 	return fmt.Sprintf("%dMB", i/humanize.MiByte)
 }
 
-// PDMemberName returns pd member name
+// MaxClusterNameLength is the max length of the name of a TikvCluster. The pods of the
+// member statefulsets are labeled with the controller-revision-hash <statefulset>-<hash>,
+// where the hash is up to 10 characters, and label values are limited to 63 characters,
+// so the longest statefulset name <cluster>-tikv leaves 47 characters to the cluster name.
+const MaxClusterNameLength = 47
+
+// ValidateClusterName validates that the names derived from the cluster name by the
+// member-name helpers are valid names of services, statefulsets and pods
+func ValidateClusterName(name string) error {
+	if len(name) > MaxClusterNameLength {
+		return fmt.Errorf("cluster name %q must be no more than %d characters", name, MaxClusterNameLength)
+	}
+	if errs := validation.IsDNS1035Label(name); len(errs) > 0 {
+		return fmt.Errorf("cluster name %q is invalid: %s", name, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// MemberName returns the name of the statefulset of the member type, clusterName is at most MaxClusterNameLength characters
+func MemberName(clusterName string, t v1alpha1.MemberType) string {
+	return fmt.Sprintf("%s-%s", clusterName, t)
+}
+
+// PeerMemberName returns the name of the peer service of the member type, clusterName is at most MaxClusterNameLength characters
+func PeerMemberName(clusterName string, t v1alpha1.MemberType) string {
+	return fmt.Sprintf("%s-%s-peer", clusterName, t)
+}
+
+// MemberLabels returns the canonical labels of the objects of the member type of the cluster,
+// i.e. the name, managed-by, instance and component labels the statefulset selects its pods by
+func MemberLabels(tc *v1alpha1.TikvCluster, t v1alpha1.MemberType) map[string]string {
+	return label.New().Instance(tc.GetInstanceName()).Component(t.String()).Labels()
+}
+
+// MemberSelector returns the selector of the objects of the member type of the cluster, see MemberLabels
+func MemberSelector(tc *v1alpha1.TikvCluster, t v1alpha1.MemberType) (labels.Selector, error) {
+	return label.Label(MemberLabels(tc, t)).Selector()
+}
+
+// PDMemberName returns pd member name, clusterName is at most MaxClusterNameLength characters
 func PDMemberName(clusterName string) string {
-	return fmt.Sprintf("%s-pd", clusterName)
+	return MemberName(clusterName, v1alpha1.PDMemberType)
+}
+
+// PDClientURL returns the url the clients in the kubernetes cluster reach pd at through the pd
+// service, e.g. https://demo-pd.default.svc:2379. The scheme follows the cluster TLS and the
+// port .spec.pd.service.port
+func PDClientURL(tc *v1alpha1.TikvCluster) string {
+	return fmt.Sprintf("%s://%s.%s.svc:%d", tc.Scheme(), PDMemberName(tc.GetName()), tc.GetNamespace(), tc.PDServicePort())
+}
+
+// PDPeerURL returns the url the pd member of the ordinal advertises to its peers, e.g.
+// https://demo-pd-0.demo-pd-peer.default.svc:2380, the pod is resolved by its stable name in the
+// pd peer service. The scheme follows the cluster TLS
+func PDPeerURL(tc *v1alpha1.TikvCluster, ordinal int32) string {
+	podName := util.GetPodName(tc, v1alpha1.PDMemberType, ordinal)
+	return fmt.Sprintf("%s://%s.%s.%s.svc:%d", tc.Scheme(), podName, PDPeerMemberName(tc.GetName()), tc.GetNamespace(), tc.Ports().PDPeer)
 }
 
-// PDPeerMemberName returns pd peer service name
+// PDPeerMemberName returns pd peer service name, clusterName is at most MaxClusterNameLength characters
 func PDPeerMemberName(clusterName string) string {
-	return fmt.Sprintf("%s-pd-peer", clusterName)
+	return PeerMemberName(clusterName, v1alpha1.PDMemberType)
 }
 
-// TiKVMemberName returns tikv member name
+// PDDashboardMemberName returns the name of the service and the ingress of the pd dashboard,
+// clusterName is at most MaxClusterNameLength characters
+func PDDashboardMemberName(clusterName string) string {
+	return fmt.Sprintf("%s-dashboard", PDMemberName(clusterName))
+}
+
+// TiKVMemberName returns tikv member name, clusterName is at most MaxClusterNameLength characters
 func TiKVMemberName(clusterName string) string {
-	return fmt.Sprintf("%s-tikv", clusterName)
+	return MemberName(clusterName, v1alpha1.TiKVMemberType)
 }
 
-// TiKVPeerMemberName returns tikv peer service name
+// TiKVPeerMemberName returns tikv peer service name, clusterName is at most MaxClusterNameLength characters
 func TiKVPeerMemberName(clusterName string) string {
-	return fmt.Sprintf("%s-tikv-peer", clusterName)
+	return PeerMemberName(clusterName, v1alpha1.TiKVMemberType)
 }
 
-// TiFlashMemberName returns tiflash member name
+// TiFlashMemberName returns tiflash member name, clusterName is at most MaxClusterNameLength characters
 func TiFlashMemberName(clusterName string) string {
-	return fmt.Sprintf("%s-tiflash", clusterName)
+	return MemberName(clusterName, "tiflash")
 }
 
-// TiCDCMemberName returns ticdc member name
+// TiCDCMemberName returns ticdc member name, clusterName is at most MaxClusterNameLength characters
 func TiCDCMemberName(clusterName string) string {
-	return fmt.Sprintf("%s-ticdc", clusterName)
+	return MemberName(clusterName, "ticdc")
 }
 
-// TiFlashPeerMemberName returns tiflash peer service name
+// TiFlashPeerMemberName returns tiflash peer service name, clusterName is at most MaxClusterNameLength characters
 func TiFlashPeerMemberName(clusterName string) string {
-	return fmt.Sprintf("%s-tiflash-peer", clusterName)
+	return PeerMemberName(clusterName, "tiflash")
 }
 
-// TiCDCPeerMemberName returns ticdc peer service name
+// TiCDCPeerMemberName returns ticdc peer service name, clusterName is at most MaxClusterNameLength characters
 func TiCDCPeerMemberName(clusterName string) string {
-	return fmt.Sprintf("%s-ticdc-peer", clusterName)
+	return PeerMemberName(clusterName, "ticdc")
 }
 
-// TiDBMemberName returns tikv member name
+// TiDBMemberName returns tidb member name, clusterName is at most MaxClusterNameLength characters
 func TiDBMemberName(clusterName string) string {
-	return fmt.Sprintf("%s-tikv", clusterName)
+	return MemberName(clusterName, "tidb")
 }
 
-// TiDBPeerMemberName returns tikv peer service name
+// TiDBPeerMemberName returns tidb peer service name, clusterName is at most MaxClusterNameLength characters
 func TiDBPeerMemberName(clusterName string) string {
-	return fmt.Sprintf("%s-tikv-peer", clusterName)
+	return PeerMemberName(clusterName, "tidb")
+}
+
+// LegacyTiDBMemberName returns the tidb member name TiDBMemberName returned before it was fixed,
+// which collides with the tikv member name. The operator never created resources with it, it
+// is kept for the callers looking up the resources they named after it, which should be
+// renamed to TiDBMemberName.
+//
+// Deprecated: use TiDBMemberName
+func LegacyTiDBMemberName(clusterName string) string {
+	return MemberName(clusterName, v1alpha1.TiKVMemberType)
+}
+
+// LegacyTiDBPeerMemberName returns the tidb peer service name TiDBPeerMemberName returned before
+// it was fixed, see LegacyTiDBMemberName.
+//
+// Deprecated: use TiDBPeerMemberName
+func LegacyTiDBPeerMemberName(clusterName string) string {
+	return PeerMemberName(clusterName, v1alpha1.TiKVMemberType)
 }
 
-// PumpMemberName returns pump member name
+// PumpMemberName returns pump member name, clusterName is at most MaxClusterNameLength characters
 func PumpMemberName(clusterName string) string {
-	return fmt.Sprintf("%s-pump", clusterName)
+	return MemberName(clusterName, "pump")
 }
 
-// TiDBInitializerMemberName returns TiDBInitializer member name
+// TiDBInitializerMemberName returns TiDBInitializer member name, clusterName is at most MaxClusterNameLength characters
 func TiDBInitializerMemberName(clusterName string) string {
 	return fmt.Sprintf("%s-tikv-initializer", clusterName)
 }
 
 // For backward compatibility, pump peer member name do not has -peer suffix
-// PumpPeerMemberName returns pump peer service name
+// PumpPeerMemberName returns pump peer service name, clusterName is at most MaxClusterNameLength characters
 func PumpPeerMemberName(clusterName string) string {
-	return fmt.Sprintf("%s-pump", clusterName)
+	return MemberName(clusterName, "pump")
 }
 
-// DiscoveryMemberName returns the name of tikv discovery
+// DiscoveryMemberName returns the name of tikv discovery, clusterName is at most MaxClusterNameLength characters
 func DiscoveryMemberName(clusterName string) string {
 	return fmt.Sprintf("%s-discovery", clusterName)
 }
 
+// DiscoveryServiceName returns the name of the service of tikv discovery, which is the member name
+// so far. The start script of pd reaches the discovery service by this name.
+func DiscoveryServiceName(clusterName string) string {
+	return DiscoveryMemberName(clusterName)
+}
+
+// DiscoveryDeploymentName returns the name of the deployment of tikv discovery, which is the
+// member name so far
+func DiscoveryDeploymentName(clusterName string) string {
+	return DiscoveryMemberName(clusterName)
+}
+
 // AnnProm adds annotations for prometheus scraping metrics
 func AnnProm(port int32) map[string]string {
-	return map[string]string{
+	return AnnPromWithPath(port, "/metrics")
+}
+
+// AnnPromWithPath adds annotations for prometheus scraping metrics under the given path,
+// an empty path defaults to /metrics
+func AnnPromWithPath(port int32, path string) map[string]string {
+	return annProm(port, path, "http")
+}
+
+// AnnPromTLS adds annotations for prometheus scraping metrics with the given scheme, the
+// prometheus.io/scheme annotation is only added for schemes other than http, which is the
+// default scheme of prometheus
+func AnnPromTLS(port int32, scheme string) map[string]string {
+	return annProm(port, "/metrics", scheme)
+}
+
+func annProm(port int32, path string, scheme string) map[string]string {
+	if path == "" {
+		path = "/metrics"
+	}
+	anns := map[string]string{
 		"prometheus.io/scrape": "true",
-		"prometheus.io/path":   "/metrics",
+		"prometheus.io/path":   path,
 		"prometheus.io/port":   fmt.Sprintf("%d", port),
 	}
+	if scheme != "" && scheme != "http" {
+		anns["prometheus.io/scheme"] = scheme
+	}
+	return anns
 }
 
 // AnnAdditionalProm adds additional prometheus scarping configuration annotation for the pod
-// which has multiple metrics endpoint
-// we assumes that the metrics path is as same as the previous metrics path
-func AnnAdditionalProm(name string, port int32) map[string]string {
-	return map[string]string{
-		fmt.Sprintf("%s.prometheus.io/port", name): fmt.Sprintf("%d", port),
+// which has multiple metrics endpoints, the endpoints are mapped from their names to their
+// ports in a single annotation, we assumes that the metrics path is as same as the previous
+// metrics path
+func AnnAdditionalProm(endpoints map[string]int32) map[string]string {
+	anns := map[string]string{}
+	ports := map[string]string{}
+	for name, port := range endpoints {
+		ports[name] = fmt.Sprintf("%d", port)
+		if LegacyPromAnnotations {
+			anns[name+legacyPromPortSuffix] = ports[name]
+		}
+	}
+	data, err := json.Marshal(ports)
+	if err != nil {
+		klog.Errorf("failed to marshal prometheus endpoints %v: %v", ports, err)
+		return anns
 	}
+	anns[label.AnnPromAdditionalEndpoints] = string(data)
+	return anns
+}
+
+// IsLegacyPromAnnotation returns true if the key is an old style <name>.prometheus.io/port annotation
+func IsLegacyPromAnnotation(key string) bool {
+	return strings.HasSuffix(key, legacyPromPortSuffix)
+}
+
+// MigrateLegacyPromAnnotations returns the annotations with the old style <name>.prometheus.io/port
+// annotations moved into the additional endpoints annotation, the given annotations are returned
+// as is if there is nothing to migrate or LegacyPromAnnotations is set
+func MigrateLegacyPromAnnotations(anns map[string]string) map[string]string {
+	if LegacyPromAnnotations {
+		return anns
+	}
+	migrated := map[string]string{}
+	ports := map[string]string{}
+	for key, val := range anns {
+		if IsLegacyPromAnnotation(key) {
+			ports[strings.TrimSuffix(key, legacyPromPortSuffix)] = val
+			continue
+		}
+		migrated[key] = val
+	}
+	if len(ports) == 0 {
+		return anns
+	}
+	if val, ok := anns[label.AnnPromAdditionalEndpoints]; ok {
+		// the endpoints already in the new annotation take precedence over the legacy ones
+		existing := map[string]string{}
+		if err := json.Unmarshal([]byte(val), &existing); err != nil {
+			klog.Errorf("failed to unmarshal annotation %s: %v", label.AnnPromAdditionalEndpoints, err)
+			return anns
+		}
+		for name, port := range existing {
+			ports[name] = port
+		}
+	}
+	data, err := json.Marshal(ports)
+	if err != nil {
+		klog.Errorf("failed to marshal prometheus endpoints %v: %v", ports, err)
+		return anns
+	}
+	migrated[label.AnnPromAdditionalEndpoints] = string(data)
+	return migrated
 }
 
 func ParseStorageRequest(req corev1.ResourceList) (corev1.ResourceRequirements, error) {
@@ -242,35 +568,144 @@ func ParseStorageRequest(req corev1.ResourceList) (corev1.ResourceRequirements,
 	}, nil
 }
 
+// ValidateStorageChange returns an error if the storage request of any component of the cluster
+// is decreased, which can not be applied as a PVC can not be shrunk. There is no tiflash in the
+// spec yet, so pd and tikv are compared.
+func ValidateStorageChange(old, tc *v1alpha1.TikvCluster) error {
+	var errs []error
+	for _, component := range []struct {
+		memberType v1alpha1.MemberType
+		old, new   corev1.ResourceList
+	}{
+		{v1alpha1.PDMemberType, old.Spec.PD.Requests, tc.Spec.PD.Requests},
+		{v1alpha1.TiKVMemberType, old.Spec.TiKV.Requests, tc.Spec.TiKV.Requests},
+	} {
+		oldQuantity, ok := component.old[corev1.ResourceStorage]
+		if !ok {
+			continue
+		}
+		quantity, ok := component.new[corev1.ResourceStorage]
+		if !ok {
+			continue
+		}
+		if quantity.Cmp(oldQuantity) < 0 {
+			errs = append(errs, fmt.Errorf("%s storage request can not be decreased from %s to %s", component.memberType, oldQuantity.String(), quantity.String()))
+		}
+	}
+	return errorutils.NewAggregate(errs)
+}
+
+// ContainerResource returns the requirements of the container, which are the requirements
+// without the storage of the PVC
 func ContainerResource(req corev1.ResourceRequirements) corev1.ResourceRequirements {
+	return ContainerResourceExcluding(req, corev1.ResourceStorage)
+}
+
+// ContainerResourceExcluding returns a copy of the requirements without the given resources in
+// both the limits and the requests
+func ContainerResourceExcluding(req corev1.ResourceRequirements, exclude ...corev1.ResourceName) corev1.ResourceRequirements {
 	trimmed := req.DeepCopy()
-	if trimmed.Limits != nil {
-		delete(trimmed.Limits, corev1.ResourceStorage)
-	}
-	if trimmed.Requests != nil {
-		delete(trimmed.Requests, corev1.ResourceStorage)
+	for _, name := range exclude {
+		delete(trimmed.Limits, name)
+		delete(trimmed.Requests, name)
 	}
 	return *trimmed
 }
 
+// SplitResourceRequirements splits the requirements into the compute requirements of the container
+// and the storage requirements of its PVC. Like ParseStorageRequest, the storage request must be
+// set once any request is set.
+func SplitResourceRequirements(req corev1.ResourceRequirements) (container corev1.ResourceRequirements, storage corev1.ResourceRequirements, err error) {
+	if req.Requests != nil {
+		q, ok := req.Requests[corev1.ResourceStorage]
+		if !ok {
+			return corev1.ResourceRequirements{}, corev1.ResourceRequirements{}, fmt.Errorf("storage request is not set")
+		}
+		storage.Requests = corev1.ResourceList{corev1.ResourceStorage: q}
+	}
+	if q, ok := req.Limits[corev1.ResourceStorage]; ok {
+		storage.Limits = corev1.ResourceList{corev1.ResourceStorage: q}
+	}
+	return ContainerResource(req), storage, nil
+}
+
 // MemberConfigMapName returns the default ConfigMap name of the specified member type
 // Deprecated
 // TODO: remove after helm get totally abandoned
 func MemberConfigMapName(tc *v1alpha1.TikvCluster, member v1alpha1.MemberType) string {
 	nameKey := fmt.Sprintf("%s-%s", tc.Name, member)
-	return nameKey + getConfigMapSuffix(tc, member.String(), nameKey)
+	suffix, _ := ConfigMapSuffix(tc, member.String(), nameKey)
+	return nameKey + suffix
 }
 
-// getConfigMapSuffix return the ConfigMap name suffix
-func getConfigMapSuffix(tc *v1alpha1.TikvCluster, component string, name string) string {
+// ConfigMapSuffix returns the ConfigMap name suffix recorded in the TikvCluster annotation
+// "tikv.org/<component>.<name>.sha", and whether a non-empty sha is found
+func ConfigMapSuffix(tc *v1alpha1.TikvCluster, component string, name string) (string, bool) {
 	if tc.Annotations == nil {
-		return ""
+		return "", false
 	}
 	sha := tc.Annotations[fmt.Sprintf("tikv.org/%s.%s.sha", component, name)]
 	if len(sha) == 0 {
-		return ""
+		return "", false
+	}
+	return "-" + sha, true
+}
+
+// SetConfigMapManagedKeys records the data keys of the configmap as owned by the operator in the
+// tikv.org/config-managed-keys annotation, the keys added by users are kept on updates
+func SetConfigMapManagedKeys(cm *corev1.ConfigMap) {
+	keys := make([]string, 0, len(cm.Data))
+	for k := range cm.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if cm.Annotations == nil {
+		cm.Annotations = map[string]string{}
 	}
-	return "-" + sha
+	// marshaling a slice of strings never fails
+	b, _ := json.Marshal(keys)
+	cm.Annotations[label.AnnConfigManagedKeys] = string(b)
+}
+
+// ConfigMapUserData returns the data added to the configmap by users, i.e. the keys not recorded
+// in its tikv.org/config-managed-keys annotation. Nil is returned for a configmap without the
+// annotation, all its keys were overwritten by the operator anyway.
+func ConfigMapUserData(cm *corev1.ConfigMap) map[string]string {
+	ann, ok := cm.Annotations[label.AnnConfigManagedKeys]
+	if !ok {
+		return nil
+	}
+	var keys []string
+	if err := json.Unmarshal([]byte(ann), &keys); err != nil {
+		klog.Warningf("configmap %s/%s: failed to parse annotation %s: %v", cm.Namespace, cm.Name, label.AnnConfigManagedKeys, err)
+		return nil
+	}
+	managed := sets.NewString(keys...)
+	data := map[string]string{}
+	for k, v := range cm.Data {
+		if !managed.Has(k) {
+			data[k] = v
+		}
+	}
+	return data
+}
+
+// MergeConfigMapData returns the data the existing configmap is updated to. The data of desired
+// replaces the data of existing as a whole unless desired records the keys owned by the operator,
+// in which case the keys added to existing by users are kept. The keys the operator no longer
+// owns are deleted, the keys of users never are.
+func MergeConfigMapData(existing, desired *corev1.ConfigMap) map[string]string {
+	if _, ok := desired.Annotations[label.AnnConfigManagedKeys]; !ok {
+		return desired.Data
+	}
+	data := map[string]string{}
+	for k, v := range ConfigMapUserData(existing) {
+		data[k] = v
+	}
+	for k, v := range desired.Data {
+		data[k] = v
+	}
+	return data
 }
 
 // setIfNotEmpty set the value into map when value in not empty
@@ -280,6 +715,53 @@ func setIfNotEmpty(container map[string]string, key, value string) {
 	}
 }
 
+// UpgradeProgress returns the partition of the rolling update of the statefulset, the partition
+// it moves to next and whether the rolling update is done. The partition moves down by one once
+// the pods from the partition on have been updated, a nil partition is treated as zero.
+func UpgradeProgress(sts *apps.StatefulSet) (current, desired int32, done bool) {
+	if ru := sts.Spec.UpdateStrategy.RollingUpdate; ru != nil && ru.Partition != nil {
+		current = *ru.Partition
+	}
+	replicas := sts.Status.Replicas
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	status := sts.Status
+	done = status.ObservedGeneration >= sts.Generation &&
+		status.CurrentRevision == status.UpdateRevision &&
+		status.UpdatedReplicas >= replicas
+	desired = current
+	if done || current <= 0 {
+		return current, desired, done
+	}
+	// the partition beyond the last ordinal updates no pod
+	partition := current
+	if partition > replicas {
+		partition = replicas
+	}
+	if status.UpdatedReplicas >= replicas-partition {
+		desired = partition - 1
+	}
+	return current, desired, done
+}
+
+// IsManagedUpgrade returns whether the rolling update of the statefulset is driven by tikv-operator
+// through its partition, i.e. the update strategy is RollingUpdate with the rolling update
+// parameters set, as tikv-operator creates the statefulsets of the components.
+//
+// It returns false for OnDelete and for a strategy without the rolling update parameters, which
+// are set by the users managing the upgrade by hand by modifying the statefulset directly. The
+// upgraders then leave the update strategy as it is and do not move the partition, the native
+// statefulset controller or the users deleting the pods upgrade them, which skips the eviction
+// of the leaders and the health checks between the pods.
+func IsManagedUpgrade(sts *apps.StatefulSet) bool {
+	strategy := sts.Spec.UpdateStrategy
+	if strategy.Type != "" && strategy.Type != apps.RollingUpdateStatefulSetStrategyType {
+		return false
+	}
+	return strategy.RollingUpdate != nil
+}
+
 // Int32Ptr returns a pointer to an int32
 func Int32Ptr(i int32) *int32 {
 	return &i
@@ -347,11 +829,66 @@ func WatchForObject(informer cache.SharedIndexInformer, q workqueue.Interface) {
 	})
 }
 
+// WatchForObjectRateLimited is like WatchForObject, but the updates are added to the workqueue
+// rate limited so that controllers back off on objects changing frequently, the adds and
+// deletes are still added at once
+func WatchForObjectRateLimited(informer cache.SharedIndexInformer, q workqueue.RateLimitingInterface) {
+	keyFn := func(obj interface{}) (string, bool) {
+		key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			utilruntime.HandleError(fmt.Errorf("Cound't get key for object %+v: %v", obj, err))
+			return "", false
+		}
+		return key, true
+	}
+	enqueueFn := func(obj interface{}) {
+		if key, ok := keyFn(obj); ok {
+			q.Add(key)
+		}
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueueFn,
+		UpdateFunc: func(_, cur interface{}) {
+			if key, ok := keyFn(cur); ok {
+				q.AddRateLimited(key)
+			}
+		},
+		DeleteFunc: enqueueFn,
+	})
+}
+
 type GetControllerFn func(ns, name string) (runtime.Object, error)
 
+// Enqueuer is the part of a work queue WatchForController needs, it is
+// satisfied by both workqueue.Interface and PriorityQueue
+type Enqueuer interface {
+	Add(item interface{})
+}
+
+// UpdateFilter returns whether the update of an object from old to cur should be enqueued
+type UpdateFilter func(old, cur interface{}) bool
+
+// ResourceVersionChanged is an UpdateFilter skipping the updates of periodic resyncs, which
+// carry the same object as old and cur
+func ResourceVersionChanged(old, cur interface{}) bool {
+	oldMeta, oldOk := old.(metav1.Object)
+	curMeta, curOk := cur.(metav1.Object)
+	return !oldOk || !curOk || oldMeta.GetResourceVersion() != curMeta.GetResourceVersion()
+}
+
 // WatchForController watch the object change from informer and add it's controller to workqueue
-func WatchForController(informer cache.SharedIndexInformer, q workqueue.Interface, fn GetControllerFn, m map[string]string) {
+func WatchForController(informer cache.SharedIndexInformer, q Enqueuer, fn GetControllerFn, m map[string]string) {
+	WatchForControllerWithFilter(informer, q, fn, m, nil)
+}
+
+// WatchForControllerWithFilter is like WatchForController, but an update is only enqueued if
+// filter returns true for it, all updates are enqueued if filter is nil
+func WatchForControllerWithFilter(informer cache.SharedIndexInformer, q Enqueuer, fn GetControllerFn, m map[string]string, filter UpdateFilter) {
 	enqueueFn := func(obj interface{}) {
+		// a dropped delete event leaves a tombstone which carries the last known object
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
 		meta, ok := obj.(metav1.Object)
 		if !ok {
 			utilruntime.HandleError(fmt.Errorf("%+v is not a runtime.Object, cannot get controller from it", obj))
@@ -397,7 +934,10 @@ func WatchForController(informer cache.SharedIndexInformer, q workqueue.Interfac
 	}
 	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: enqueueFn,
-		UpdateFunc: func(_, cur interface{}) {
+		UpdateFunc: func(old, cur interface{}) {
+			if filter != nil && !filter(old, cur) {
+				return
+			}
 			enqueueFn(cur)
 		},
 		DeleteFunc: enqueueFn,
@@ -410,6 +950,14 @@ func EmptyClone(obj runtime.Object) (runtime.Object, error) {
 	if !ok {
 		return nil, fmt.Errorf("Obj %v is not a metav1.Object, cannot call EmptyClone", obj)
 	}
+	// the kinds not registered in the scheme, e.g. the cert-manager certificates, are unstructured
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		inst := &unstructured.Unstructured{}
+		inst.SetGroupVersionKind(u.GroupVersionKind())
+		inst.SetName(meta.GetName())
+		inst.SetNamespace(meta.GetNamespace())
+		return inst, nil
+	}
 	gvk, err := InferObjectKind(obj)
 	if err != nil {
 		return nil, err
@@ -427,6 +975,18 @@ func EmptyClone(obj runtime.Object) (runtime.Object, error) {
 	return inst, nil
 }
 
+// EmptyCloneList creates a new empty instance of the list kind of obj, the items and list metadata are not copied
+func EmptyCloneList(obj runtime.Object) (runtime.Object, error) {
+	if !apimeta.IsListType(obj) {
+		return nil, fmt.Errorf("Obj %v is not a list, cannot call EmptyCloneList", obj)
+	}
+	gvk, err := InferObjectKind(obj)
+	if err != nil {
+		return nil, err
+	}
+	return scheme.Scheme.New(gvk)
+}
+
 // InferObjectKind infers the object kind
 func InferObjectKind(obj runtime.Object) (schema.GroupVersionKind, error) {
 	gvks, _, err := scheme.Scheme.ObjectKinds(obj)
@@ -442,12 +1002,56 @@ func InferObjectKind(obj runtime.Object) (schema.GroupVersionKind, error) {
 // GuaranteedUpdate will retry the updateFunc to mutate the object until success, updateFunc is expected to
 // capture the object reference from the caller context to avoid unnecessary type casting.
 func GuaranteedUpdate(cli client.Client, obj runtime.Object, updateFunc func() error) error {
+	return GuaranteedUpdateWithContext(context.Background(), cli, obj, updateFunc)
+}
+
+// GuaranteedUpdateWithContext is like GuaranteedUpdate, but the client calls use ctx and retrying
+// stops with ctx.Err() as soon as ctx is cancelled.
+func GuaranteedUpdateWithContext(ctx context.Context, cli client.Client, obj runtime.Object, updateFunc func() error) error {
+	return guaranteedUpdate(ctx, cli, obj, retry.DefaultRetry, updateFunc)
+}
+
+// GuaranteedUpdateWithBackoff is like GuaranteedUpdate, but retries on conflict with the given backoff,
+// the number of attempts is bounded by backoff.Steps and the wait between attempts by backoff.Cap.
+func GuaranteedUpdateWithBackoff(cli client.Client, obj runtime.Object, backoff wait.Backoff, updateFunc func() error) error {
+	return guaranteedUpdate(context.Background(), cli, obj, backoff, updateFunc)
+}
+
+// GuaranteedCreateOrUpdate is like GuaranteedUpdate, but creates the object if it does not exist.
+// mutateFunc sets the desired state of the object before it is created as well as before each
+// update attempt, the object created concurrently by others is updated instead.
+func GuaranteedCreateOrUpdate(cli client.Client, obj runtime.Object, mutateFunc func() error) error {
+	ctx := context.Background()
 	key, err := client.ObjectKeyFromObject(obj)
 	if err != nil {
 		return err
 	}
-	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		if err := cli.Get(context.TODO(), key, obj); err != nil {
+	err = cli.Get(ctx, key, obj)
+	if err == nil {
+		return guaranteedUpdate(ctx, cli, obj, retry.DefaultRetry, mutateFunc)
+	}
+	if !errors.IsNotFound(err) {
+		return err
+	}
+	if err := mutateFunc(); err != nil {
+		return err
+	}
+	err = cli.Create(ctx, obj)
+	if errors.IsAlreadyExists(err) {
+		return guaranteedUpdate(ctx, cli, obj, retry.DefaultRetry, mutateFunc)
+	}
+	return err
+}
+
+// guaranteedUpdate retries on conflict the same way as retry.RetryOnConflict, except that it
+// gives up when ctx is done instead of sleeping through the backoff.
+func guaranteedUpdate(ctx context.Context, cli client.Client, obj runtime.Object, backoff wait.Backoff, updateFunc func() error) error {
+	key, err := client.ObjectKeyFromObject(obj)
+	if err != nil {
+		return err
+	}
+	tryUpdate := func() error {
+		if err := cli.Get(ctx, key, obj); err != nil {
 			return err
 		}
 		beforeMutation := obj.DeepCopyObject()
@@ -457,6 +1061,31 @@ func GuaranteedUpdate(cli client.Client, obj runtime.Object, updateFunc func() e
 		if apiequality.Semantic.DeepEqual(obj, beforeMutation) {
 			return nil
 		}
-		return cli.Update(context.TODO(), obj)
-	})
+		return cli.Update(ctx, obj)
+	}
+
+	// like wait.ExponentialBackoff, the update is attempted at least once
+	if backoff.Steps < 1 {
+		backoff.Steps = 1
+	}
+	var lastConflictErr error
+	for backoff.Steps > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := tryUpdate()
+		if !errors.IsConflict(err) {
+			return err
+		}
+		lastConflictErr = err
+		if backoff.Steps == 1 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff.Step()):
+		}
+	}
+	return lastConflictErr
 }
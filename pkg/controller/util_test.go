@@ -14,16 +14,30 @@
 package controller
 
 import (
+	"context"
+	stderrors "errors"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	. "github.com/onsi/gomega"
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/label"
+	"github.com/tikv/tikv-operator/pkg/scheme"
 	apps "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 func TestRequeueError(t *testing.T) {
@@ -35,6 +49,76 @@ func TestRequeueError(t *testing.T) {
 	g.Expect(ok).To(BeTrue())
 	g.Expect(err.Error()).To(Equal("i am a requeue error"))
 	g.Expect(IsRequeueError(fmt.Errorf("i am not a requeue error"))).To(BeFalse())
+	_, ok = RequeueAfter(err)
+	g.Expect(ok).To(BeFalse())
+
+	err = RequeueErrorAfter(time.Minute, "i am a requeue %s", "error")
+	g.Expect(IsRequeueError(err)).To(BeTrue())
+	g.Expect(err.Error()).To(Equal("i am a requeue error"))
+	after, ok := RequeueAfter(err)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(after).To(Equal(time.Minute))
+	after, ok = RequeueAfter(fmt.Errorf("wrapped: %w", err))
+	g.Expect(ok).To(BeTrue())
+	g.Expect(after).To(Equal(time.Minute))
+	_, ok = RequeueAfter(fmt.Errorf("i am not a requeue error"))
+	g.Expect(ok).To(BeFalse())
+
+	// a zero duration is requeued rate limited
+	err = RequeueErrorAfter(0, "i am a requeue %s", "error")
+	g.Expect(IsRequeueError(err)).To(BeTrue())
+	after, ok = RequeueAfter(err)
+	g.Expect(ok).To(BeFalse())
+	g.Expect(after).To(Equal(time.Duration(0)))
+
+	wrapped := fmt.Errorf("sync tikv: %w", fmt.Errorf("upgrade: %w", RequeueErrorf("i am a requeue %s", "error")))
+	g.Expect(IsRequeueError(wrapped)).To(BeTrue())
+	g.Expect(stderrors.Is(wrapped, ErrRequeue)).To(BeTrue())
+	g.Expect(stderrors.Is(wrapped, ErrIgnore)).To(BeFalse())
+	g.Expect(IsIgnoreError(wrapped)).To(BeFalse())
+	g.Expect(stderrors.Is(fmt.Errorf("i am not a requeue error"), ErrRequeue)).To(BeFalse())
+}
+
+func TestIgnoreError(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	err := IgnoreErrorf("i am an ignore %s", "error")
+	g.Expect(IsIgnoreError(err)).To(BeTrue())
+	g.Expect(err.Error()).To(Equal("i am an ignore error"))
+	g.Expect(IsIgnoreError(fmt.Errorf("i am not an ignore error"))).To(BeFalse())
+
+	wrapped := fmt.Errorf("sync tikv: %w", fmt.Errorf("delete: %w", err))
+	g.Expect(IsIgnoreError(wrapped)).To(BeTrue())
+	g.Expect(stderrors.Is(wrapped, ErrIgnore)).To(BeTrue())
+	g.Expect(stderrors.Is(wrapped, ErrRequeue)).To(BeFalse())
+	g.Expect(IsRequeueError(wrapped)).To(BeFalse())
+}
+
+func TestIsHarmlessError(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	notFound := errors.NewNotFound(corev1.Resource("pods"), "demo-pd-0")
+	tests := []struct {
+		name    string
+		err     error
+		requeue bool
+		expect  bool
+	}{
+		{name: "nil", err: nil, expect: false},
+		{name: "not found", err: notFound, expect: true},
+		{name: "other api error", err: errors.NewConflict(corev1.Resource("pods"), "demo-pd-0", fmt.Errorf("conflict")), expect: false},
+		{name: "ignore error", err: IgnoreErrorf("ignored"), expect: true},
+		{name: "requeue error", err: RequeueErrorf("requeued"), expect: false},
+		{name: "requeue error with requeue", err: RequeueErrorf("requeued"), requeue: true, expect: true},
+		{name: "wrapped not found", err: fmt.Errorf("get pod: %w", notFound), expect: true},
+		{name: "wrapped ignore error", err: fmt.Errorf("sync: %w", IgnoreErrorf("ignored")), expect: true},
+		{name: "wrapped requeue error with requeue", err: fmt.Errorf("sync: %w", RequeueErrorf("requeued")), requeue: true, expect: true},
+		{name: "other error", err: fmt.Errorf("failed"), requeue: true, expect: false},
+	}
+	for _, tt := range tests {
+		t.Log(tt.name)
+		g.Expect(IsHarmlessError(tt.err, tt.requeue)).To(Equal(tt.expect))
+	}
 }
 
 func TestGetOwnerRef(t *testing.T) {
@@ -51,6 +135,26 @@ func TestGetOwnerRef(t *testing.T) {
 	g.Expect(*ref.BlockOwnerDeletion).To(BeTrue())
 }
 
+func TestOwnerRefs(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvCluster()
+	tc.UID = types.UID("demo-uid")
+	for _, controller := range []bool{true, false} {
+		t.Logf("controller: %v", controller)
+		refs := OwnerRefs(tc, controller)
+		g.Expect(refs).To(HaveLen(1))
+		ref := refs[0]
+		g.Expect(ref.APIVersion).To(Equal(ControllerKind.GroupVersion().String()))
+		g.Expect(ref.Kind).To(Equal(ControllerKind.Kind))
+		g.Expect(ref.Name).To(Equal(tc.GetName()))
+		g.Expect(ref.UID).To(Equal(types.UID("demo-uid")))
+		g.Expect(*ref.Controller).To(Equal(controller))
+		g.Expect(*ref.BlockOwnerDeletion).To(Equal(controller))
+	}
+	g.Expect(OwnerRefs(tc, false)[0]).To(Equal(GetNonControllerOwnerRef(tc)))
+}
+
 func TestTiKVCapacity(t *testing.T) {
 	g := NewGomegaWithT(t)
 
@@ -105,6 +209,105 @@ func TestTiKVCapacity(t *testing.T) {
 				g.Expect(s).To(Equal("1430MB"))
 			},
 		},
+		{
+			name: "0.5Ti",
+			limit: corev1.ResourceList{
+				corev1.ResourceStorage: resource.MustParse("0.5Ti"),
+			},
+			expectFn: func(g *GomegaWithT, s string) {
+				g.Expect(s).To(Equal("512GB"))
+			},
+		},
+		{
+			name: "1.5Gi",
+			limit: corev1.ResourceList{
+				corev1.ResourceStorage: resource.MustParse("1.5Gi"),
+			},
+			expectFn: func(g *GomegaWithT, s string) {
+				g.Expect(s).To(Equal("1536MB"))
+			},
+		},
+		{
+			name: "0.5Gi",
+			limit: corev1.ResourceList{
+				corev1.ResourceStorage: resource.MustParse("0.5Gi"),
+			},
+			expectFn: func(g *GomegaWithT, s string) {
+				g.Expect(s).To(Equal("512MB"))
+			},
+		},
+		{
+			name: "2.5Ti",
+			limit: corev1.ResourceList{
+				corev1.ResourceStorage: resource.MustParse("2.5Ti"),
+			},
+			expectFn: func(g *GomegaWithT, s string) {
+				g.Expect(s).To(Equal("2560GB"))
+			},
+		},
+		{
+			name: "1Ei",
+			limit: corev1.ResourceList{
+				corev1.ResourceStorage: resource.MustParse("1Ei"),
+			},
+			expectFn: func(g *GomegaWithT, s string) {
+				g.Expect(s).To(Equal("1073741824GB"))
+			},
+		},
+		{
+			name: "512Mi",
+			limit: corev1.ResourceList{
+				corev1.ResourceStorage: resource.MustParse("512Mi"),
+			},
+			expectFn: func(g *GomegaWithT, s string) {
+				g.Expect(s).To(Equal("512MB"))
+			},
+		},
+		{
+			name: "100G",
+			limit: corev1.ResourceList{
+				corev1.ResourceStorage: resource.MustParse("100G"),
+			},
+			expectFn: func(g *GomegaWithT, s string) {
+				g.Expect(s).To(Equal("95367MB"))
+			},
+		},
+		{
+			name: "100M",
+			limit: corev1.ResourceList{
+				corev1.ResourceStorage: resource.MustParse("100M"),
+			},
+			expectFn: func(g *GomegaWithT, s string) {
+				g.Expect(s).To(Equal("95MB"))
+			},
+		},
+		{
+			name: "1e9",
+			limit: corev1.ResourceList{
+				corev1.ResourceStorage: resource.MustParse("1e9"),
+			},
+			expectFn: func(g *GomegaWithT, s string) {
+				g.Expect(s).To(Equal("953MB"))
+			},
+		},
+		{
+			name: "1073741824",
+			limit: corev1.ResourceList{
+				corev1.ResourceStorage: resource.MustParse("1073741824"),
+			},
+			expectFn: func(g *GomegaWithT, s string) {
+				g.Expect(s).To(Equal("1GB"))
+			},
+		},
+		{
+			name: "1.5Mi",
+			limit: corev1.ResourceList{
+				corev1.ResourceStorage: resource.MustParse("1.5Mi"),
+			},
+			expectFn: func(g *GomegaWithT, s string) {
+				g.Expect(s).To(Equal("1MB"))
+			},
+		},
 	}
 
 	for i := range tests {
@@ -112,16 +315,225 @@ func TestTiKVCapacity(t *testing.T) {
 	}
 }
 
+func TestTiFlashCapacity(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tests := []struct {
+		name          string
+		limits        corev1.ResourceList
+		storageClaims int
+		expected      string
+	}{
+		{
+			name:          "no limits",
+			storageClaims: 2,
+			expected:      "0",
+		},
+		{
+			name:          "no storage limit",
+			limits:        corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+			storageClaims: 2,
+			expected:      "0",
+		},
+		{
+			name:          "no claims",
+			limits:        corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("100Gi")},
+			storageClaims: 0,
+			expected:      "0",
+		},
+		{
+			name:          "one claim",
+			limits:        corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("100Gi")},
+			storageClaims: 1,
+			expected:      "100GB",
+		},
+		{
+			name:          "two claims",
+			limits:        corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1.5Gi")},
+			storageClaims: 2,
+			expected:      "3GB",
+		},
+		{
+			name:          "three claims",
+			limits:        corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1G")},
+			storageClaims: 3,
+			expected:      "2861MB",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g.Expect(TiFlashCapacity(tt.limits, tt.storageClaims)).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestSumTiFlashCapacity(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	storageLimit := func(q string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(q)},
+		}
+	}
+	tests := []struct {
+		name     string
+		claims   []corev1.ResourceRequirements
+		expected string
+	}{
+		{
+			name:     "no claims",
+			expected: "0",
+		},
+		{
+			name:     "one claim",
+			claims:   []corev1.ResourceRequirements{storageLimit("100Gi")},
+			expected: "100GB",
+		},
+		{
+			name:     "two claims with mixed units",
+			claims:   []corev1.ResourceRequirements{storageLimit("1Gi"), storageLimit("512Mi")},
+			expected: "1536MB",
+		},
+		{
+			name:     "three claims with mixed units",
+			claims:   []corev1.ResourceRequirements{storageLimit("1Gi"), storageLimit("1G"), storageLimit("1024Mi")},
+			expected: "3001MB",
+		},
+		{
+			name: "three claims with one limit missing",
+			claims: []corev1.ResourceRequirements{
+				storageLimit("1Gi"),
+				{Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("5Gi")}},
+				storageLimit("2Gi"),
+			},
+			expected: "3GB",
+		},
+		{
+			name:     "the remainder less than 1MiB is truncated",
+			claims:   []corev1.ResourceRequirements{storageLimit("1.5Gi"), storageLimit("100Ki")},
+			expected: "1536MB",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g.Expect(sumTiFlashCapacity(tt.claims)).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestValidateClusterName(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	atBoundary := strings.Repeat("a", MaxClusterNameLength)
+	g.Expect(ValidateClusterName(atBoundary)).To(Succeed())
+	// the controller-revision-hash label of the pods is at most 63 characters
+	g.Expect(len(TiKVMemberName(atBoundary) + "-4294967295")).To(BeNumerically("<=", 63))
+	g.Expect(len(DiscoveryMemberName(atBoundary))).To(BeNumerically("<=", 63))
+
+	g.Expect(ValidateClusterName(atBoundary + "a")).NotTo(Succeed())
+	g.Expect(ValidateClusterName("1demo")).NotTo(Succeed())
+	g.Expect(ValidateClusterName("demo.cluster")).NotTo(Succeed())
+	g.Expect(ValidateClusterName("demo")).To(Succeed())
+}
+
+func TestMemberName(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		memberType v1alpha1.MemberType
+		name       string
+		peerName   string
+	}{
+		{v1alpha1.PDMemberType, "demo-pd", "demo-pd-peer"},
+		{v1alpha1.TiKVMemberType, "demo-tikv", "demo-tikv-peer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.memberType.String(), func(t *testing.T) {
+			g.Expect(MemberName("demo", tt.memberType)).To(Equal(tt.name))
+			g.Expect(PeerMemberName("demo", tt.memberType)).To(Equal(tt.peerName))
+		})
+	}
+	g.Expect(PDMemberName("demo")).To(Equal(MemberName("demo", v1alpha1.PDMemberType)))
+	g.Expect(PDPeerMemberName("demo")).To(Equal(PeerMemberName("demo", v1alpha1.PDMemberType)))
+	g.Expect(TiKVMemberName("demo")).To(Equal(MemberName("demo", v1alpha1.TiKVMemberType)))
+	g.Expect(TiKVPeerMemberName("demo")).To(Equal(PeerMemberName("demo", v1alpha1.TiKVMemberType)))
+}
+
+func TestMemberLabels(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tc := newTikvCluster()
+	for _, memberType := range []v1alpha1.MemberType{v1alpha1.PDMemberType, v1alpha1.TiKVMemberType} {
+		t.Run(memberType.String(), func(t *testing.T) {
+			memberLabels := MemberLabels(tc, memberType)
+			g.Expect(memberLabels).To(Equal(map[string]string{
+				label.NameLabelKey:      "tikv-cluster",
+				label.ManagedByLabelKey: label.TiKVOperator,
+				label.InstanceLabelKey:  "demo",
+				label.ComponentLabelKey: memberType.String(),
+			}))
+			// the component is the suffix of the statefulset name
+			g.Expect(MemberName(tc.Name, memberType)).To(Equal(tc.Name + "-" + memberLabels[label.ComponentLabelKey]))
+
+			selector, err := MemberSelector(tc, memberType)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(selector.Matches(labels.Set(memberLabels))).To(BeTrue())
+			parsed, err := labels.Parse(selector.String())
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(parsed.Matches(labels.Set(memberLabels))).To(BeTrue())
+		})
+	}
+	g.Expect(MemberLabels(tc, v1alpha1.PDMemberType)).To(Equal(label.New().Instance("demo").PD().Labels()))
+	g.Expect(MemberLabels(tc, v1alpha1.TiKVMemberType)).To(Equal(label.New().Instance("demo").TiKV().Labels()))
+
+	// the selectors of the members do not select each other nor the members of other clusters
+	pdSelector, err := MemberSelector(tc, v1alpha1.PDMemberType)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(pdSelector.Matches(labels.Set(MemberLabels(tc, v1alpha1.TiKVMemberType)))).To(BeFalse())
+	other := newTikvCluster()
+	other.Name = "other"
+	g.Expect(pdSelector.Matches(labels.Set(MemberLabels(other, v1alpha1.PDMemberType)))).To(BeFalse())
+}
+
 func TestPDMemberName(t *testing.T) {
 	g := NewGomegaWithT(t)
 	g.Expect(PDMemberName("demo")).To(Equal("demo-pd"))
 }
 
+func TestPDClientURL(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tc := newTikvCluster()
+	g.Expect(PDClientURL(tc)).To(Equal("http://demo-pd.default.svc:2379"))
+
+	tc.Spec.TLSCluster = &v1alpha1.TLSCluster{Enabled: true}
+	g.Expect(PDClientURL(tc)).To(Equal("https://demo-pd.default.svc:2379"))
+
+	port := int32(12379)
+	tc.Spec.PD.Service = &v1alpha1.ServiceSpec{Port: &port}
+	g.Expect(PDClientURL(tc)).To(Equal("https://demo-pd.default.svc:12379"))
+}
+
+func TestPDPeerURL(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tc := newTikvCluster()
+	g.Expect(PDPeerURL(tc, 0)).To(Equal("http://demo-pd-0.demo-pd-peer.default.svc:2380"))
+	g.Expect(PDPeerURL(tc, 12)).To(Equal("http://demo-pd-12.demo-pd-peer.default.svc:2380"))
+
+	tc.Spec.TLSCluster = &v1alpha1.TLSCluster{Enabled: true}
+	g.Expect(PDPeerURL(tc, 0)).To(Equal("https://demo-pd-0.demo-pd-peer.default.svc:2380"))
+	g.Expect(PDPeerURL(tc, 12)).To(Equal("https://demo-pd-12.demo-pd-peer.default.svc:2380"))
+}
+
 func TestPDPeerMemberName(t *testing.T) {
 	g := NewGomegaWithT(t)
 	g.Expect(PDPeerMemberName("demo")).To(Equal("demo-pd-peer"))
 }
 
+func TestPDDashboardMemberName(t *testing.T) {
+	g := NewGomegaWithT(t)
+	g.Expect(PDDashboardMemberName("demo")).To(Equal("demo-pd-dashboard"))
+}
+
 func TestTiKVMemberName(t *testing.T) {
 	g := NewGomegaWithT(t)
 	g.Expect(TiKVMemberName("demo")).To(Equal("demo-tikv"))
@@ -134,12 +546,34 @@ func TestTiKVPeerMemberName(t *testing.T) {
 
 func TestTiDBMemberName(t *testing.T) {
 	g := NewGomegaWithT(t)
-	g.Expect(TiDBMemberName("demo")).To(Equal("demo-tikv"))
+	g.Expect(TiDBMemberName("demo")).To(Equal("demo-tidb"))
+	g.Expect(LegacyTiDBMemberName("demo")).To(Equal("demo-tikv"))
 }
 
 func TestTiDBPeerMemberName(t *testing.T) {
 	g := NewGomegaWithT(t)
-	g.Expect(TiDBPeerMemberName("demo")).To(Equal("demo-tikv-peer"))
+	g.Expect(TiDBPeerMemberName("demo")).To(Equal("demo-tidb-peer"))
+	g.Expect(LegacyTiDBPeerMemberName("demo")).To(Equal("demo-tikv-peer"))
+}
+
+func TestMemberNamesAreDistinct(t *testing.T) {
+	g := NewGomegaWithT(t)
+	names := []string{
+		PDMemberName("demo"),
+		PDPeerMemberName("demo"),
+		PDDashboardMemberName("demo"),
+		TiKVMemberName("demo"),
+		TiKVPeerMemberName("demo"),
+		TiDBMemberName("demo"),
+		TiDBPeerMemberName("demo"),
+		PumpMemberName("demo"),
+		PumpPeerMemberName("demo"),
+	}
+	seen := map[string]bool{}
+	for _, name := range names {
+		g.Expect(seen).NotTo(HaveKey(name))
+		seen[name] = true
+	}
 }
 
 func TestPumpMemberName(t *testing.T) {
@@ -157,6 +591,16 @@ func TestDiscoveryMemberName(t *testing.T) {
 	g.Expect(DiscoveryMemberName("demo")).To(Equal("demo-discovery"))
 }
 
+func TestDiscoveryServiceName(t *testing.T) {
+	g := NewGomegaWithT(t)
+	g.Expect(DiscoveryServiceName("demo")).To(Equal("demo-discovery"))
+}
+
+func TestDiscoveryDeploymentName(t *testing.T) {
+	g := NewGomegaWithT(t)
+	g.Expect(DiscoveryDeploymentName("demo")).To(Equal("demo-discovery"))
+}
+
 func TestAnnProm(t *testing.T) {
 	g := NewGomegaWithT(t)
 
@@ -164,6 +608,311 @@ func TestAnnProm(t *testing.T) {
 	g.Expect(ann["prometheus.io/scrape"]).To(Equal("true"))
 	g.Expect(ann["prometheus.io/path"]).To(Equal("/metrics"))
 	g.Expect(ann["prometheus.io/port"]).To(Equal("9090"))
+	g.Expect(ann).NotTo(HaveKey("prometheus.io/scheme"))
+}
+
+func TestAnnPromTLS(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(AnnPromTLS(9090, "http")).To(Equal(AnnProm(9090)))
+	ann := AnnPromTLS(9090, "https")
+	g.Expect(ann["prometheus.io/scrape"]).To(Equal("true"))
+	g.Expect(ann["prometheus.io/path"]).To(Equal("/metrics"))
+	g.Expect(ann["prometheus.io/port"]).To(Equal("9090"))
+	g.Expect(ann["prometheus.io/scheme"]).To(Equal("https"))
+}
+
+func TestAnnPromWithPath(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(AnnPromWithPath(9090, "")).To(Equal(AnnProm(9090)))
+	ann := AnnPromWithPath(9090, "/debug/metrics")
+	g.Expect(ann["prometheus.io/scrape"]).To(Equal("true"))
+	g.Expect(ann["prometheus.io/path"]).To(Equal("/debug/metrics"))
+	g.Expect(ann["prometheus.io/port"]).To(Equal("9090"))
+
+	// the additional endpoints share the custom path
+	for k, v := range AnnAdditionalProm(map[string]int32{"proxy": 20292}) {
+		ann[k] = v
+	}
+	g.Expect(ann).To(Equal(map[string]string{
+		"prometheus.io/scrape":                     "true",
+		"prometheus.io/path":                       "/debug/metrics",
+		"prometheus.io/port":                       "9090",
+		"prometheus.tikv.org/additional-endpoints": `{"proxy":"20292"}`,
+	}))
+}
+
+func TestAnnAdditionalProm(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(AnnAdditionalProm(map[string]int32{"proxy": 20292, "engine": 8234})).To(Equal(map[string]string{
+		"prometheus.tikv.org/additional-endpoints": `{"engine":"8234","proxy":"20292"}`,
+	}))
+
+	LegacyPromAnnotations = true
+	defer func() { LegacyPromAnnotations = false }()
+	g.Expect(AnnAdditionalProm(map[string]int32{"proxy": 20292})).To(Equal(map[string]string{
+		"prometheus.tikv.org/additional-endpoints": `{"proxy":"20292"}`,
+		"proxy.prometheus.io/port":                 "20292",
+	}))
+}
+
+func TestMigrateLegacyPromAnnotations(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name     string
+		legacy   bool
+		anns     map[string]string
+		expected map[string]string
+	}{
+		{
+			name:     "nothing to migrate",
+			anns:     AnnProm(20180),
+			expected: AnnProm(20180),
+		},
+		{
+			name: "legacy annotations",
+			anns: map[string]string{
+				"prometheus.io/scrape":     "true",
+				"prometheus.io/port":       "20180",
+				"proxy.prometheus.io/port": "20292",
+			},
+			expected: map[string]string{
+				"prometheus.io/scrape":                     "true",
+				"prometheus.io/port":                       "20180",
+				"prometheus.tikv.org/additional-endpoints": `{"proxy":"20292"}`,
+			},
+		},
+		{
+			name: "merged with new style annotation",
+			anns: map[string]string{
+				"proxy.prometheus.io/port":                 "20292",
+				"engine.prometheus.io/port":                "8234",
+				"prometheus.tikv.org/additional-endpoints": `{"proxy":"20293"}`,
+			},
+			expected: map[string]string{
+				"prometheus.tikv.org/additional-endpoints": `{"engine":"8234","proxy":"20293"}`,
+			},
+		},
+		{
+			name:   "legacy annotations are kept",
+			legacy: true,
+			anns: map[string]string{
+				"proxy.prometheus.io/port": "20292",
+			},
+			expected: map[string]string{
+				"proxy.prometheus.io/port": "20292",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			LegacyPromAnnotations = tt.legacy
+			defer func() { LegacyPromAnnotations = false }()
+			g.Expect(MigrateLegacyPromAnnotations(tt.anns)).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestSplitResourceRequirements(t *testing.T) {
+	g := NewGomegaWithT(t)
+	cpu := resource.MustParse("1")
+	memory := resource.MustParse("2Gi")
+	disk := resource.MustParse("100Gi")
+	tests := []struct {
+		name            string
+		req             corev1.ResourceRequirements
+		expectContainer corev1.ResourceRequirements
+		expectStorage   corev1.ResourceRequirements
+		expectErr       bool
+	}{
+		{
+			name: "requests only",
+			req: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: cpu, corev1.ResourceStorage: disk},
+			},
+			expectContainer: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: cpu},
+			},
+			expectStorage: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: disk},
+			},
+		},
+		{
+			name: "limits only",
+			req: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceMemory: memory, corev1.ResourceStorage: disk},
+			},
+			expectContainer: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceMemory: memory},
+			},
+			expectStorage: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceStorage: disk},
+			},
+		},
+		{
+			name: "requests and limits",
+			req: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: cpu, corev1.ResourceStorage: disk},
+				Limits:   corev1.ResourceList{corev1.ResourceCPU: cpu, corev1.ResourceMemory: memory},
+			},
+			expectContainer: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: cpu},
+				Limits:   corev1.ResourceList{corev1.ResourceCPU: cpu, corev1.ResourceMemory: memory},
+			},
+			expectStorage: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: disk},
+			},
+		},
+		{
+			name: "storage request not set",
+			req: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: cpu},
+			},
+			expectErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			container, storage, err := SplitResourceRequirements(tt.req)
+			if tt.expectErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(container).To(Equal(tt.expectContainer))
+			g.Expect(storage).To(Equal(tt.expectStorage))
+		})
+	}
+}
+
+func TestValidateStorageChange(t *testing.T) {
+	g := NewGomegaWithT(t)
+	storage := func(quantity string) corev1.ResourceList {
+		return corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(quantity)}
+	}
+	tests := []struct {
+		name      string
+		pd        corev1.ResourceList
+		tikv      corev1.ResourceList
+		expectErr string
+	}{
+		{
+			name: "equal",
+			pd:   storage("10Gi"),
+			tikv: storage("100Gi"),
+		},
+		{
+			name: "equal in other units",
+			pd:   storage("10240Mi"),
+			tikv: storage("100Gi"),
+		},
+		{
+			name: "increased",
+			pd:   storage("20Gi"),
+			tikv: storage("200Gi"),
+		},
+		{
+			name:      "pd decreased",
+			pd:        storage("5Gi"),
+			tikv:      storage("100Gi"),
+			expectErr: "pd storage request can not be decreased from 10Gi to 5Gi",
+		},
+		{
+			name:      "tikv decreased",
+			pd:        storage("10Gi"),
+			tikv:      storage("50Gi"),
+			expectErr: "tikv storage request can not be decreased from 100Gi to 50Gi",
+		},
+		{
+			name: "storage request removed",
+			pd:   nil,
+			tikv: storage("100Gi"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := &v1alpha1.TikvCluster{}
+			old.Spec.PD.Requests = storage("10Gi")
+			old.Spec.TiKV.Requests = storage("100Gi")
+			tc := old.DeepCopy()
+			tc.Spec.PD.Requests = tt.pd
+			tc.Spec.TiKV.Requests = tt.tikv
+			err := ValidateStorageChange(old, tc)
+			if tt.expectErr == "" {
+				g.Expect(err).NotTo(HaveOccurred())
+				return
+			}
+			g.Expect(err).To(MatchError(tt.expectErr))
+		})
+	}
+}
+
+func TestContainerResourceExcluding(t *testing.T) {
+	g := NewGomegaWithT(t)
+	cpu := resource.MustParse("1")
+	disk := resource.MustParse("100Gi")
+	ephemeral := resource.MustParse("10Gi")
+	gpu := resource.MustParse("2")
+	const gpuResource corev1.ResourceName = "nvidia.com/gpu"
+	req := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: cpu, corev1.ResourceStorage: disk, corev1.ResourceEphemeralStorage: ephemeral},
+		Limits:   corev1.ResourceList{corev1.ResourceCPU: cpu, corev1.ResourceEphemeralStorage: ephemeral, gpuResource: gpu},
+	}
+	tests := []struct {
+		name    string
+		req     corev1.ResourceRequirements
+		exclude []corev1.ResourceName
+		expect  corev1.ResourceRequirements
+	}{
+		{
+			name:    "storage",
+			req:     req,
+			exclude: []corev1.ResourceName{corev1.ResourceStorage},
+			expect: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: cpu, corev1.ResourceEphemeralStorage: ephemeral},
+				Limits:   corev1.ResourceList{corev1.ResourceCPU: cpu, corev1.ResourceEphemeralStorage: ephemeral, gpuResource: gpu},
+			},
+		},
+		{
+			name:    "storage and ephemeral storage",
+			req:     req,
+			exclude: []corev1.ResourceName{corev1.ResourceStorage, corev1.ResourceEphemeralStorage},
+			expect: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: cpu},
+				Limits:   corev1.ResourceList{corev1.ResourceCPU: cpu, gpuResource: gpu},
+			},
+		},
+		{
+			name:    "extended resource",
+			req:     req,
+			exclude: []corev1.ResourceName{gpuResource},
+			expect: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: cpu, corev1.ResourceStorage: disk, corev1.ResourceEphemeralStorage: ephemeral},
+				Limits:   corev1.ResourceList{corev1.ResourceCPU: cpu, corev1.ResourceEphemeralStorage: ephemeral},
+			},
+		},
+		{
+			name:   "nothing",
+			req:    req,
+			expect: req,
+		},
+		{
+			name:    "no requirements",
+			req:     corev1.ResourceRequirements{},
+			exclude: []corev1.ResourceName{corev1.ResourceStorage},
+			expect:  corev1.ResourceRequirements{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g.Expect(ContainerResourceExcluding(tt.req, tt.exclude...)).To(Equal(tt.expect))
+		})
+	}
+	// the requirements passed in are left untouched
+	g.Expect(req.Requests).To(HaveKey(corev1.ResourceStorage))
+	g.Expect(req.Limits).To(HaveKey(gpuResource))
 }
 
 func TestMemberConfigMapName(t *testing.T) {
@@ -242,6 +991,86 @@ func TestMemberConfigMapName(t *testing.T) {
 	}
 }
 
+func TestConfigMapSuffix(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	type testcase struct {
+		name         string
+		annotations  map[string]string
+		expectSuffix string
+		expectFound  bool
+	}
+	tests := []testcase{
+		{
+			name:         "nil annotations",
+			annotations:  nil,
+			expectSuffix: "",
+			expectFound:  false,
+		},
+		{
+			name: "empty sha",
+			annotations: map[string]string{
+				"tikv.org/sidecar.cluster-name-sidecar.sha": "",
+			},
+			expectSuffix: "",
+			expectFound:  false,
+		},
+		{
+			name: "sha of another component",
+			annotations: map[string]string{
+				"tikv.org/tikv.cluster-name-sidecar.sha": "uuuuuuuu",
+			},
+			expectSuffix: "",
+			expectFound:  false,
+		},
+		{
+			name: "sha presented",
+			annotations: map[string]string{
+				"tikv.org/sidecar.cluster-name-sidecar.sha": "uuuuuuuu",
+			},
+			expectSuffix: "-uuuuuuuu",
+			expectFound:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tc := &v1alpha1.TikvCluster{}
+			tc.Name = "cluster-name"
+			tc.Annotations = test.annotations
+			suffix, found := ConfigMapSuffix(tc, "sidecar", "cluster-name-sidecar")
+			g.Expect(suffix).To(Equal(test.expectSuffix))
+			g.Expect(found).To(Equal(test.expectFound))
+		})
+	}
+}
+
+func TestEmptyCloneList(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tcList := &v1alpha1.TikvClusterList{
+		ListMeta: metav1.ListMeta{ResourceVersion: "1"},
+		Items:    []v1alpha1.TikvCluster{{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "ns"}}},
+	}
+	obj, err := EmptyCloneList(tcList)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(obj).To(Equal(&v1alpha1.TikvClusterList{}))
+
+	podList := &corev1.PodList{
+		ListMeta: metav1.ListMeta{ResourceVersion: "1"},
+		Items:    []corev1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "ns"}}},
+	}
+	obj, err = EmptyCloneList(podList)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(obj).To(Equal(&corev1.PodList{}))
+	// the items of the source list are untouched
+	g.Expect(podList.Items).To(HaveLen(1))
+
+	_, err = EmptyCloneList(&corev1.Pod{})
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("is not a list"))
+}
+
 func TestSetIfNotEmpty(t *testing.T) {
 	g := NewGomegaWithT(t)
 
@@ -299,6 +1128,374 @@ func TestSetIfNotEmpty(t *testing.T) {
 	}
 }
 
+func TestUpgradeProgress(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	type testcase struct {
+		name          string
+		generation    int64
+		partition     *int32
+		status        apps.StatefulSetStatus
+		expectCurrent int32
+		expectDesired int32
+		expectDone    bool
+	}
+	testFn := func(test *testcase, t *testing.T) {
+		t.Log(test.name)
+
+		sts := &apps.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Generation: test.generation},
+			Spec: apps.StatefulSetSpec{
+				Replicas: Int32Ptr(3),
+				UpdateStrategy: apps.StatefulSetUpdateStrategy{
+					Type: apps.RollingUpdateStatefulSetStrategyType,
+				},
+			},
+			Status: test.status,
+		}
+		if test.partition != nil {
+			sts.Spec.UpdateStrategy.RollingUpdate = &apps.RollingUpdateStatefulSetStrategy{Partition: test.partition}
+		}
+		sts.Status.Replicas = 3
+
+		current, desired, done := UpgradeProgress(sts)
+		g.Expect(current).To(Equal(test.expectCurrent))
+		g.Expect(desired).To(Equal(test.expectDesired))
+		g.Expect(done).To(Equal(test.expectDone))
+	}
+	tests := []testcase{
+		{
+			name:          "never started",
+			partition:     Int32Ptr(3),
+			status:        apps.StatefulSetStatus{CurrentRevision: "1", UpdateRevision: "2"},
+			expectCurrent: 3,
+			expectDesired: 2,
+		},
+		{
+			name:          "never started without partition",
+			status:        apps.StatefulSetStatus{CurrentRevision: "1", UpdateRevision: "2"},
+			expectCurrent: 0,
+			expectDesired: 0,
+		},
+		{
+			name:          "mid-upgrade, the pod of the partition updated",
+			partition:     Int32Ptr(2),
+			status:        apps.StatefulSetStatus{CurrentRevision: "1", UpdateRevision: "2", UpdatedReplicas: 1},
+			expectCurrent: 2,
+			expectDesired: 1,
+		},
+		{
+			name:          "mid-upgrade, the pod of the partition updating",
+			partition:     Int32Ptr(1),
+			status:        apps.StatefulSetStatus{CurrentRevision: "1", UpdateRevision: "2", UpdatedReplicas: 1},
+			expectCurrent: 1,
+			expectDesired: 1,
+		},
+		{
+			name:          "the last pod updating",
+			partition:     Int32Ptr(0),
+			status:        apps.StatefulSetStatus{CurrentRevision: "1", UpdateRevision: "2", UpdatedReplicas: 2},
+			expectCurrent: 0,
+			expectDesired: 0,
+		},
+		{
+			name:          "completed",
+			partition:     Int32Ptr(0),
+			status:        apps.StatefulSetStatus{CurrentRevision: "2", UpdateRevision: "2", UpdatedReplicas: 3},
+			expectCurrent: 0,
+			expectDesired: 0,
+			expectDone:    true,
+		},
+		{
+			name:          "completed without partition",
+			status:        apps.StatefulSetStatus{CurrentRevision: "2", UpdateRevision: "2", UpdatedReplicas: 3},
+			expectCurrent: 0,
+			expectDesired: 0,
+			expectDone:    true,
+		},
+		{
+			name:          "not observed yet",
+			generation:    2,
+			partition:     Int32Ptr(0),
+			status:        apps.StatefulSetStatus{ObservedGeneration: 1, CurrentRevision: "2", UpdateRevision: "2", UpdatedReplicas: 3},
+			expectCurrent: 0,
+			expectDesired: 0,
+		},
+	}
+
+	for i := range tests {
+		testFn(&tests[i], t)
+	}
+}
+
+func TestIsManagedUpgrade(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tests := []struct {
+		name     string
+		strategy apps.StatefulSetUpdateStrategy
+		expected bool
+	}{
+		{
+			name: "RollingUpdate",
+			strategy: apps.StatefulSetUpdateStrategy{
+				Type:          apps.RollingUpdateStatefulSetStrategyType,
+				RollingUpdate: &apps.RollingUpdateStatefulSetStrategy{Partition: Int32Ptr(3)},
+			},
+			expected: true,
+		},
+		{
+			name:     "RollingUpdate without the rolling update parameters",
+			strategy: apps.StatefulSetUpdateStrategy{Type: apps.RollingUpdateStatefulSetStrategyType},
+			expected: false,
+		},
+		{
+			name:     "OnDelete",
+			strategy: apps.StatefulSetUpdateStrategy{Type: apps.OnDeleteStatefulSetStrategyType},
+			expected: false,
+		},
+		{
+			name:     "nil strategy",
+			strategy: apps.StatefulSetUpdateStrategy{},
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Log(tt.name)
+		sts := &apps.StatefulSet{Spec: apps.StatefulSetSpec{UpdateStrategy: tt.strategy}}
+		g.Expect(IsManagedUpgrade(sts)).To(Equal(tt.expected))
+	}
+}
+
+// conflictClient fails the first conflicts updates with a conflict error
+type conflictClient struct {
+	client.Client
+	conflicts     int
+	getTracker    RequestTracker
+	createTracker RequestTracker
+	updateTracker RequestTracker
+}
+
+func (c *conflictClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	defer c.createTracker.Inc()
+	if c.createTracker.ErrorReady() {
+		defer c.createTracker.Reset()
+		return c.createTracker.GetError()
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *conflictClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	defer c.getTracker.Inc()
+	if c.getTracker.ErrorReady() {
+		defer c.getTracker.Reset()
+		return c.getTracker.GetError()
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func (c *conflictClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	defer c.updateTracker.Inc()
+	if c.updateTracker.GetRequests() < c.conflicts {
+		return errors.NewConflict(corev1.Resource("configmaps"), "demo", fmt.Errorf("the object has been modified"))
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func TestGuaranteedUpdateWithBackoff(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	type testcase struct {
+		name           string
+		conflicts      int
+		backoff        wait.Backoff
+		expectAttempts int
+		expectConflict bool
+	}
+	testFn := func(test *testcase, t *testing.T) {
+		t.Log(test.name)
+
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "demo",
+				Namespace: metav1.NamespaceDefault,
+			},
+		}
+		cli := &conflictClient{
+			Client:    fake.NewFakeClientWithScheme(scheme.Scheme, cm.DeepCopy()),
+			conflicts: test.conflicts,
+		}
+		err := GuaranteedUpdateWithBackoff(cli, cm, test.backoff, func() error {
+			cm.Data = map[string]string{"key": "value"}
+			return nil
+		})
+		if test.expectConflict {
+			g.Expect(errors.IsConflict(err)).To(BeTrue())
+		} else {
+			g.Expect(err).NotTo(HaveOccurred())
+		}
+		g.Expect(cli.getTracker.GetRequests()).To(Equal(test.expectAttempts))
+		g.Expect(cli.updateTracker.GetRequests()).To(Equal(test.expectAttempts))
+	}
+	tests := []testcase{
+		{
+			name:           "no conflict",
+			conflicts:      0,
+			backoff:        wait.Backoff{Steps: 5, Duration: time.Millisecond, Factor: 1.0},
+			expectAttempts: 1,
+			expectConflict: false,
+		},
+		{
+			name:           "succeed after conflicts",
+			conflicts:      3,
+			backoff:        wait.Backoff{Steps: 5, Duration: time.Millisecond, Factor: 1.0},
+			expectAttempts: 4,
+			expectConflict: false,
+		},
+		{
+			name:           "conflicts exceed steps",
+			conflicts:      10,
+			backoff:        wait.Backoff{Steps: 5, Duration: time.Millisecond, Factor: 1.0},
+			expectAttempts: 5,
+			expectConflict: true,
+		},
+		{
+			name:           "zero steps still attempt once",
+			conflicts:      0,
+			backoff:        wait.Backoff{},
+			expectAttempts: 1,
+			expectConflict: false,
+		},
+		{
+			name:           "cap stops retrying",
+			conflicts:      10,
+			backoff:        wait.Backoff{Steps: 5, Duration: time.Millisecond, Factor: 2.0, Cap: 2 * time.Millisecond},
+			expectAttempts: 2,
+			expectConflict: true,
+		},
+	}
+
+	for i := range tests {
+		testFn(&tests[i], t)
+	}
+}
+
+func TestGuaranteedCreateOrUpdate(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	type testcase struct {
+		name         string
+		existing     bool
+		conflicts    int
+		createErr    error
+		expectCreate int
+		expectUpdate int
+	}
+	testFn := func(test *testcase, t *testing.T) {
+		t.Log(test.name)
+
+		key := client.ObjectKey{Namespace: metav1.NamespaceDefault, Name: "demo"}
+		var initObjs []runtime.Object
+		if test.existing || test.createErr != nil {
+			initObjs = append(initObjs, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+				Data:       map[string]string{"key": "old", "other": "kept"},
+			})
+		}
+		cli := &conflictClient{
+			Client:    fake.NewFakeClientWithScheme(scheme.Scheme, initObjs...),
+			conflicts: test.conflicts,
+		}
+		if test.createErr != nil {
+			// created by others after the get
+			cli.createTracker.SetError(test.createErr)
+			cli.getTracker.SetError(errors.NewNotFound(corev1.Resource("configmaps"), key.Name))
+		}
+		cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+		err := GuaranteedCreateOrUpdate(cli, cm, func() error {
+			if cm.Data == nil {
+				cm.Data = map[string]string{}
+			}
+			cm.Data["key"] = "value"
+			return nil
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(cli.createTracker.GetRequests()).To(Equal(test.expectCreate))
+		g.Expect(cli.updateTracker.GetRequests()).To(Equal(test.expectUpdate))
+
+		actual := &corev1.ConfigMap{}
+		g.Expect(cli.Client.Get(context.TODO(), key, actual)).To(Succeed())
+		g.Expect(actual.Data["key"]).To(Equal("value"))
+		if test.existing || test.createErr != nil {
+			g.Expect(actual.Data["other"]).To(Equal("kept"))
+		}
+	}
+	tests := []testcase{
+		{
+			name:         "create",
+			expectCreate: 1,
+			expectUpdate: 0,
+		},
+		{
+			name:         "update",
+			existing:     true,
+			expectCreate: 0,
+			expectUpdate: 1,
+		},
+		{
+			name:         "update after conflicts",
+			existing:     true,
+			conflicts:    2,
+			expectCreate: 0,
+			expectUpdate: 3,
+		},
+		{
+			name:         "update the object created by others",
+			createErr:    errors.NewAlreadyExists(corev1.Resource("configmaps"), "demo"),
+			expectCreate: 1,
+			expectUpdate: 1,
+		},
+	}
+
+	for i := range tests {
+		testFn(&tests[i], t)
+	}
+}
+
+func TestGuaranteedUpdateWithContext(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "demo",
+			Namespace: metav1.NamespaceDefault,
+		},
+	}
+	cli := &conflictClient{
+		Client:    fake.NewFakeClientWithScheme(scheme.Scheme, cm.DeepCopy()),
+		conflicts: 10,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := GuaranteedUpdateWithContext(ctx, cli, cm, func() error {
+		// cancel in the middle of retrying
+		if cli.getTracker.GetRequests() == 2 {
+			cancel()
+		}
+		cm.Data = map[string]string{"key": "value"}
+		return nil
+	})
+	g.Expect(err).To(Equal(context.Canceled))
+	g.Expect(cli.getTracker.GetRequests()).To(Equal(2))
+	g.Expect(cli.updateTracker.GetRequests()).To(Equal(2))
+
+	// a cancelled context does not reach the api server at all
+	err = GuaranteedUpdateWithContext(ctx, cli, cm, func() error { return nil })
+	g.Expect(err).To(Equal(context.Canceled))
+	g.Expect(cli.getTracker.GetRequests()).To(Equal(2))
+}
+
 func collectEvents(source <-chan string) []string {
 	done := false
 	events := make([]string, 0)
@@ -347,3 +1544,164 @@ func newStatefulSet(tc *v1alpha1.TikvCluster, _ string) *apps.StatefulSet {
 func GetName(tcName string, name string) string {
 	return fmt.Sprintf("%s-%s", tcName, name)
 }
+
+// fakeEventInformer records the event handler so that the tests fire events at it directly
+type fakeEventInformer struct {
+	cache.SharedIndexInformer
+	handler cache.ResourceEventHandler
+}
+
+func (i *fakeEventInformer) AddEventHandler(handler cache.ResourceEventHandler) {
+	i.handler = handler
+}
+
+func TestWatchForObjectRateLimited(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	informer := &fakeEventInformer{}
+	q := workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Minute, time.Hour))
+	defer q.ShutDown()
+	WatchForObjectRateLimited(informer, q)
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "pod"}}
+	informer.handler.OnAdd(pod)
+	g.Expect(q.Len()).To(Equal(1))
+	item, _ := q.Get()
+	q.Done(item)
+
+	for i := 0; i < 10; i++ {
+		informer.handler.OnUpdate(pod, pod)
+	}
+	// the updates wait for the backoff of the key
+	g.Expect(q.Len()).To(Equal(0))
+	g.Expect(q.NumRequeues("ns/pod")).To(Equal(10))
+
+	informer.handler.OnDelete(cache.DeletedFinalStateUnknown{Key: "ns/pod", Obj: pod})
+	g.Expect(q.Len()).To(Equal(1))
+}
+
+func TestIsClusterPaused(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tc := newTikvCluster()
+	g.Expect(IsClusterPaused(tc)).To(BeFalse())
+	tc.Spec.Paused = true
+	g.Expect(IsClusterPaused(tc)).To(BeTrue())
+}
+
+func TestWatchForControllerWithFilter(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	type testcase struct {
+		name          string
+		filter        UpdateFilter
+		oldVersion    string
+		curVersion    string
+		expectEnqueue bool
+	}
+
+	tc := &v1alpha1.TikvCluster{
+		TypeMeta:   metav1.TypeMeta{Kind: ControllerKind.Kind, APIVersion: ControllerKind.GroupVersion().String()},
+		ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: "demo"},
+	}
+	getController := func(ns, name string) (runtime.Object, error) {
+		return tc, nil
+	}
+
+	testFn := func(test *testcase) {
+		t.Log(test.name)
+		informer := &fakeEventInformer{}
+		q := workqueue.New()
+		defer q.ShutDown()
+		if test.filter == nil {
+			// WatchForController enqueues all the updates as it did before the filter was added
+			WatchForController(informer, q, getController, nil)
+		} else {
+			WatchForControllerWithFilter(informer, q, getController, nil, test.filter)
+		}
+
+		old := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:       metav1.NamespaceDefault,
+				Name:            "demo-tikv-0",
+				ResourceVersion: test.oldVersion,
+				OwnerReferences: []metav1.OwnerReference{GetOwnerRef(tc)},
+			},
+		}
+		cur := old.DeepCopy()
+		cur.ResourceVersion = test.curVersion
+		informer.handler.OnUpdate(old, cur)
+		if test.expectEnqueue {
+			g.Expect(q.Len()).To(Equal(1))
+			item, _ := q.Get()
+			g.Expect(item).To(Equal("default/demo"))
+		} else {
+			g.Expect(q.Len()).To(Equal(0))
+		}
+	}
+
+	tests := []testcase{
+		{
+			name:          "resync is skipped",
+			filter:        ResourceVersionChanged,
+			oldVersion:    "1",
+			curVersion:    "1",
+			expectEnqueue: false,
+		},
+		{
+			name:          "update is enqueued",
+			filter:        ResourceVersionChanged,
+			oldVersion:    "1",
+			curVersion:    "2",
+			expectEnqueue: true,
+		},
+		{
+			name:          "resync is enqueued by WatchForController",
+			filter:        nil,
+			oldVersion:    "1",
+			curVersion:    "1",
+			expectEnqueue: true,
+		},
+		{
+			name: "update is skipped by the filter",
+			filter: func(old, cur interface{}) bool {
+				return false
+			},
+			oldVersion:    "1",
+			curVersion:    "2",
+			expectEnqueue: false,
+		},
+	}
+	for i := range tests {
+		testFn(&tests[i])
+	}
+}
+
+func TestMergeConfigMapData(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	existing := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{label.AnnConfigManagedKeys: `["config-file","removed"]`},
+		},
+		Data: map[string]string{"config-file": "a", "removed": "b", "user": "c", "collision": "d"},
+	}
+	desired := &corev1.ConfigMap{
+		Data: map[string]string{"config-file": "e", "collision": "f"},
+	}
+
+	// the data is replaced as a whole unless desired records the keys it owns
+	g.Expect(MergeConfigMapData(existing, desired)).To(Equal(desired.Data))
+
+	SetConfigMapManagedKeys(desired)
+	g.Expect(desired.Annotations[label.AnnConfigManagedKeys]).To(Equal(`["collision","config-file"]`))
+	g.Expect(MergeConfigMapData(existing, desired)).To(Equal(map[string]string{
+		"config-file": "e",
+		"collision":   "f",
+		"user":        "c",
+	}))
+
+	// all the keys of a configmap not recording the keys it owns were owned by the operator
+	delete(existing.Annotations, label.AnnConfigManagedKeys)
+	g.Expect(ConfigMapUserData(existing)).To(BeNil())
+	g.Expect(MergeConfigMapData(existing, desired)).To(Equal(desired.Data))
+}
@@ -0,0 +1,182 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+var (
+	// StatusWriteInterval and StatusWriteBurst bound the status writes of a cluster to a token
+	// bucket refilled by one token per interval, so that a flapping store does not write etcd
+	// on every sync. The critical transitions, e.g. the Ready condition flipping, are written
+	// at once. 0 disables the limit
+	StatusWriteInterval = 5 * time.Second
+	StatusWriteBurst    = 3
+
+	// EventInterval and EventBurst bound the events of an object the same way, on top of the
+	// aggregation of the event recorder. 0 disables the limit
+	EventInterval = 5 * time.Second
+	EventBurst    = 10
+)
+
+var writesDropped = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "tikv_operator",
+		Subsystem: "write_limiter",
+		Name:      "dropped_total",
+		Help:      "Number of status writes deferred and events dropped by the per-cluster write limiter, by kind.",
+	}, []string{"kind"})
+
+func init() {
+	prometheus.MustRegister(writesDropped)
+}
+
+const (
+	// WriteKindStatus is the kind of the status writes of a cluster
+	WriteKindStatus = "status"
+	// WriteKindEvent is the kind of the events
+	WriteKindEvent = "event"
+)
+
+// WriteLimiter is a token bucket for each object, keyed by its UID
+type WriteLimiter struct {
+	kind     string
+	interval func() time.Duration
+	burst    func() int
+
+	mu      sync.Mutex
+	buckets map[types.UID]*tokenBucket
+	now     func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewWriteLimiter returns a WriteLimiter of the kind, the interval and the burst are read on
+// each write so that they can be changed by flags after the limiter is created
+func NewWriteLimiter(kind string, interval func() time.Duration, burst func() int) *WriteLimiter {
+	return &WriteLimiter{
+		kind:     kind,
+		interval: interval,
+		burst:    burst,
+		buckets:  map[types.UID]*tokenBucket{},
+		now:      time.Now,
+	}
+}
+
+// Allow takes a token of the object, if there is none, the write is counted as dropped and
+// Allow returns how long the object has to wait for the next token
+func (l *WriteLimiter) Allow(uid types.UID) (bool, time.Duration) {
+	interval, burst := l.interval(), l.burst()
+	if interval <= 0 || burst <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	// the buckets refilled are forgotten, so that deleted objects are not tracked forever
+	for id, b := range l.buckets {
+		if id != uid && b.refill(now, interval, burst) >= float64(burst) {
+			delete(l.buckets, id)
+		}
+	}
+	b, ok := l.buckets[uid]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		l.buckets[uid] = b
+	}
+	if b.refill(now, interval, burst) >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	writesDropped.WithLabelValues(l.kind).Inc()
+	return false, time.Duration((1 - b.tokens) * float64(interval))
+}
+
+// refill adds the tokens accumulated since the last refill and returns the tokens
+func (b *tokenBucket) refill(now time.Time, interval time.Duration, burst int) float64 {
+	b.tokens += float64(now.Sub(b.last)) / float64(interval)
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+	return b.tokens
+}
+
+// rateLimitedRecorder drops the events of an object past its token bucket, the events of the
+// critical reasons are always recorded
+type rateLimitedRecorder struct {
+	record.EventRecorder
+	limiter  *WriteLimiter
+	critical map[string]bool
+}
+
+// NewRateLimitedRecorder returns an EventRecorder recording the events of an object at most at
+// EventInterval with a burst of EventBurst, except the events of the critical reasons
+func NewRateLimitedRecorder(recorder record.EventRecorder, criticalReasons ...string) record.EventRecorder {
+	critical := map[string]bool{}
+	for _, reason := range criticalReasons {
+		critical[reason] = true
+	}
+	return &rateLimitedRecorder{
+		EventRecorder: recorder,
+		limiter:       NewWriteLimiter(WriteKindEvent, func() time.Duration { return EventInterval }, func() int { return EventBurst }),
+		critical:      critical,
+	}
+}
+
+func (r *rateLimitedRecorder) allow(object runtime.Object, reason string) bool {
+	if r.critical[reason] {
+		return true
+	}
+	accessor, err := apimeta.Accessor(object)
+	if err != nil {
+		return true
+	}
+	if allowed, _ := r.limiter.Allow(accessor.GetUID()); !allowed {
+		klog.V(4).Infof("event %s of %s/%s dropped by the rate limit", reason, accessor.GetNamespace(), accessor.GetName())
+		return false
+	}
+	return true
+}
+
+func (r *rateLimitedRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	if r.allow(object, reason) {
+		r.EventRecorder.Event(object, eventtype, reason, message)
+	}
+}
+
+func (r *rateLimitedRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	if r.allow(object, reason) {
+		r.EventRecorder.Eventf(object, eventtype, reason, messageFmt, args...)
+	}
+}
+
+func (r *rateLimitedRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	if r.allow(object, reason) {
+		r.EventRecorder.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestWriteLimiterAllow(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	now := time.Now()
+	limiter := NewWriteLimiter(WriteKindStatus, func() time.Duration { return 5 * time.Second }, func() int { return 3 })
+	limiter.now = func() time.Time { return now }
+
+	// a status oscillating 10 times per second for a minute
+	allowed := 0
+	for i := 0; i < 600; i++ {
+		if ok, _ := limiter.Allow("a"); ok {
+			allowed++
+		}
+		now = now.Add(100 * time.Millisecond)
+	}
+	g.Expect(allowed).To(BeNumerically("<=", 3+60/5))
+	g.Expect(allowed).To(BeNumerically(">=", 3+60/5-1))
+
+	// the next token is waited for
+	limiter.Allow("a")
+	ok, wait := limiter.Allow("a")
+	g.Expect(ok).To(BeFalse())
+	g.Expect(wait).To(BeNumerically(">", 0))
+	g.Expect(wait).To(BeNumerically("<=", 5*time.Second))
+
+	// the buckets are per object
+	ok, _ = limiter.Allow("b")
+	g.Expect(ok).To(BeTrue())
+
+	// the bucket of an object refilled is forgotten
+	now = now.Add(time.Minute)
+	limiter.Allow("b")
+	g.Expect(limiter.buckets).NotTo(HaveKey(types.UID("a")))
+	ok, _ = limiter.Allow("a")
+	g.Expect(ok).To(BeTrue())
+
+	// 0 disables the limit
+	disabled := NewWriteLimiter(WriteKindStatus, func() time.Duration { return 0 }, func() int { return 3 })
+	for i := 0; i < 10; i++ {
+		ok, _ := disabled.Allow("a")
+		g.Expect(ok).To(BeTrue())
+	}
+}
+
+func TestRateLimitedRecorder(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	defer func(interval time.Duration, burst int) {
+		EventInterval = interval
+		EventBurst = burst
+	}(EventInterval, EventBurst)
+	EventInterval = time.Hour
+	EventBurst = 2
+
+	fakeRecorder := record.NewFakeRecorder(100)
+	recorder := NewRateLimitedRecorder(fakeRecorder, "Unhealthy")
+	tc := newTikvCluster()
+	tc.UID = "demo"
+	other := newTikvCluster()
+	other.UID = "other"
+
+	for i := 0; i < 10; i++ {
+		recorder.Event(tc, corev1.EventTypeWarning, "StoreDown", "store 1 is down")
+		recorder.Eventf(tc, corev1.EventTypeNormal, "StoreUp", "store %d is up", 1)
+	}
+	g.Expect(fakeRecorder.Events).To(HaveLen(2))
+
+	// the critical reasons and the other objects are not limited
+	recorder.Event(tc, corev1.EventTypeWarning, "Unhealthy", "tikv pod[demo-tikv-0] is unhealthy")
+	recorder.Event(other, corev1.EventTypeWarning, "StoreDown", "store 1 is down")
+	g.Expect(fakeRecorder.Events).To(HaveLen(4))
+}
@@ -0,0 +1,132 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deps
+
+import (
+	"time"
+
+	"github.com/tikv/tikv-operator/pkg/client/clientset/versioned"
+	"github.com/tikv/tikv-operator/pkg/client/clientset/versioned/fake"
+	informers "github.com/tikv/tikv-operator/pkg/client/informers/externalversions"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/pdapi"
+	"k8s.io/apimachinery/pkg/util/clock"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Dependencies holds the kubernetes control-plane dependencies of the member managers,
+// the member managers read through the listers and write through the controls only.
+type Dependencies struct {
+	KubeClientset       kubernetes.Interface
+	Clientset           versioned.Interface
+	KubeInformerFactory kubeinformers.SharedInformerFactory
+	InformerFactory     informers.SharedInformerFactory
+	PDControl           pdapi.PDControlInterface
+	Recorder            record.EventRecorder
+	// Clock is the source of the time of the deadlines and the soak periods,
+	// it is a fake clock in unit tests.
+	Clock clock.Clock
+
+	StatefulSetControl controller.StatefulSetControlInterface
+	ServiceControl     controller.ServiceControlInterface
+	PodControl         controller.PodControlInterface
+	PVCControl         controller.PVCControlInterface
+	TypedControl       controller.TypedControlInterface
+
+	StatefulSetLister appslisters.StatefulSetLister
+	ServiceLister     corelisters.ServiceLister
+	EndpointLister    corelisters.EndpointsLister
+	PodLister         corelisters.PodLister
+	PVCLister         corelisters.PersistentVolumeClaimLister
+	NodeLister        corelisters.NodeLister
+	ConfigMapLister   corelisters.ConfigMapLister
+	SecretLister      corelisters.SecretLister
+}
+
+// NewDependencies returns the Dependencies backed by the apiserver
+func NewDependencies(
+	kubeCli kubernetes.Interface,
+	cli versioned.Interface,
+	genericCli client.Client,
+	pdControl pdapi.PDControlInterface,
+	informerFactory informers.SharedInformerFactory,
+	kubeInformerFactory kubeinformers.SharedInformerFactory,
+	recorder record.EventRecorder,
+) *Dependencies {
+	d := newDependencies(kubeCli, cli, informerFactory, kubeInformerFactory)
+	d.PDControl = pdControl
+	d.Recorder = recorder
+	d.Clock = clock.RealClock{}
+	d.StatefulSetControl = controller.NewRealStatefuSetControl(kubeCli, d.StatefulSetLister, recorder)
+	d.ServiceControl = controller.NewRealServiceControl(kubeCli, d.ServiceLister, recorder)
+	d.PodControl = controller.NewRealPodControl(kubeCli, pdControl, d.PodLister, recorder)
+	d.PVCControl = controller.NewRealPVCControl(kubeCli, recorder, d.PVCLister)
+	d.TypedControl = controller.NewTypedControl(controller.NewRealGenericControl(genericCli, recorder))
+	return d
+}
+
+// NewFakeDependencies returns the Dependencies for unit tests, it wires the fake clientsets,
+// the fake controls, the fake PD control and a fake clock. The listers share the informers
+// of the informer factories, so the objects added to their indexers are visible to the listers.
+func NewFakeDependencies() *Dependencies {
+	kubeCli := kubefake.NewSimpleClientset()
+	cli := fake.NewSimpleClientset()
+	d := newDependencies(kubeCli, cli,
+		informers.NewSharedInformerFactory(cli, 0), kubeinformers.NewSharedInformerFactory(kubeCli, 0))
+
+	tcInformer := d.InformerFactory.Tikv().V1alpha1().TikvClusters()
+	setInformer := d.KubeInformerFactory.Apps().V1().StatefulSets()
+	svcInformer := d.KubeInformerFactory.Core().V1().Services()
+	epsInformer := d.KubeInformerFactory.Core().V1().Endpoints()
+	podInformer := d.KubeInformerFactory.Core().V1().Pods()
+	pvcInformer := d.KubeInformerFactory.Core().V1().PersistentVolumeClaims()
+
+	d.PDControl = pdapi.NewFakePDControl(kubeCli)
+	d.Recorder = record.NewFakeRecorder(100)
+	d.Clock = clock.NewFakeClock(time.Now())
+	d.StatefulSetControl = controller.NewFakeStatefulSetControl(setInformer, tcInformer)
+	d.ServiceControl = controller.NewFakeServiceControl(svcInformer, epsInformer, tcInformer)
+	d.PodControl = controller.NewFakePodControl(podInformer)
+	d.PVCControl = controller.NewFakePVCControl(pvcInformer)
+	d.TypedControl = controller.NewTypedControl(controller.NewFakeGenericControl())
+	return d
+}
+
+func newDependencies(
+	kubeCli kubernetes.Interface,
+	cli versioned.Interface,
+	informerFactory informers.SharedInformerFactory,
+	kubeInformerFactory kubeinformers.SharedInformerFactory,
+) *Dependencies {
+	return &Dependencies{
+		KubeClientset:       kubeCli,
+		Clientset:           cli,
+		KubeInformerFactory: kubeInformerFactory,
+		InformerFactory:     informerFactory,
+		StatefulSetLister:   kubeInformerFactory.Apps().V1().StatefulSets().Lister(),
+		ServiceLister:       kubeInformerFactory.Core().V1().Services().Lister(),
+		EndpointLister:      kubeInformerFactory.Core().V1().Endpoints().Lister(),
+		PodLister:           kubeInformerFactory.Core().V1().Pods().Lister(),
+		PVCLister:           kubeInformerFactory.Core().V1().PersistentVolumeClaims().Lister(),
+		NodeLister:          kubeInformerFactory.Core().V1().Nodes().Lister(),
+		ConfigMapLister:     kubeInformerFactory.Core().V1().ConfigMaps().Lister(),
+		SecretLister:        kubeInformerFactory.Core().V1().Secrets().Lister(),
+	}
+}
@@ -21,6 +21,7 @@ import (
 
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
 	"github.com/tikv/tikv-operator/pkg/client/clientset/versioned"
+	"github.com/tikv/tikv-operator/pkg/controller"
 	"github.com/tikv/tikv-operator/pkg/pdapi"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -98,15 +99,16 @@ func (td *pdDiscovery) Discover(advertisePeerUrl string) (string, error) {
 		return fmt.Sprintf("--initial-cluster=%s=%s://%s", podName, tc.Scheme(), advertisePeerUrl), nil
 	}
 
-	pdClient := td.pdControl.GetPDClient(pdapi.Namespace(tc.GetNamespace()), tc.GetName(), tc.IsTLSClusterEnabled())
-	membersInfo, err := pdClient.GetMembers()
+	pdClient := controller.GetPDClient(td.pdControl, tc)
+	membersInfo, err := pdClient.GetMembers(pdapi.NoCache)
 	if err != nil {
 		return "", err
 	}
 
+	ports := tc.Ports()
 	membersArr := make([]string, 0)
 	for _, member := range membersInfo.Members {
-		memberURL := strings.ReplaceAll(member.PeerUrls[0], ":2380", ":2379")
+		memberURL := strings.ReplaceAll(member.PeerUrls[0], fmt.Sprintf(":%d", ports.PDPeer), fmt.Sprintf(":%d", ports.PDClient))
 		membersArr = append(membersArr, memberURL)
 	}
 	delete(currentCluster.peers, podName)
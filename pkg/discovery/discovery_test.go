@@ -222,6 +222,29 @@ func TestDiscoveryDiscovery(t *testing.T) {
 				g.Expect(s).To(Equal("--initial-cluster=demo-pd-2=http://demo-pd-2.demo-pd-peer.default.svc:2380"))
 			},
 		},
+		{
+			name: "1 cluster with tls, third ordinal, return the initial-cluster args",
+			ns:   "default",
+			url:  "demo-pd-2.demo-pd-peer.default.svc:2380",
+			tcFn: func() (*v1alpha1.TikvCluster, error) {
+				tc, _ := newTC()
+				tc.Spec.TLSCluster = &v1alpha1.TLSCluster{Enabled: true}
+				return tc, nil
+			},
+			clusters: map[string]*clusterInfo{
+				"default/demo": {
+					resourceVersion: "1",
+					peers: map[string]struct{}{
+						"demo-pd-0": {},
+						"demo-pd-1": {},
+					},
+				},
+			},
+			expectFn: func(g *GomegaWithT, td *pdDiscovery, s string, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(s).To(Equal("--initial-cluster=demo-pd-2=https://demo-pd-2.demo-pd-peer.default.svc:2380"))
+			},
+		},
 		{
 			name: "1 cluster, the first ordinal second request, get members failed",
 			ns:   "default",
@@ -51,12 +51,54 @@ const (
 	// AnnForceUpgradeKey is tc annotation key to indicate whether force upgrade should be done
 	AnnForceUpgradeKey = "tikv.org/force-upgrade"
 
+	// AnnForceScaleInKey is tc annotation key to allow scaling in tikv below the max-replicas of pd
+	AnnForceScaleInKey = "tikv.org/force-scale-in"
+
+	// AnnSkipConnectionDrainKey is tc annotation key to remove the tikv pods scaled in without draining the connections
+	AnnSkipConnectionDrainKey = "tikv.org/skip-connection-drain"
+
+	// AnnDisableEncryptionKey is tc annotation key to allow removing .tikv.encryption once the encryption at rest is enabled
+	AnnDisableEncryptionKey = "tikv.org/disable-encryption"
+
+	// AnnRecoverFailoverKey is tc annotation key to recover the failover of pd and tikv, the value is
+	// either AnnRecoverFailoverVal to recover all failure members and stores or a comma separated pod list
+	AnnRecoverFailoverKey = "tikv.org/recover-failover"
+	// AnnForceSyncKey is tc annotation key to request a full sync of the cluster, e.g. with a timestamp
+	// as the value, a new value triggers a new sync
+	AnnForceSyncKey = "tikv.org/force-sync"
+	// AnnAllocatedPortsKey is tc annotation key of the ports allocated for the cluster in sharedNodes
+	// network mode, which keeps the allocation across restarts of the operator
+	AnnAllocatedPortsKey = "tikv.org/allocated-ports"
+
+	// AnnPromAdditionalEndpoints is pod annotation key of the additional metrics endpoints of the
+	// pod which has multiple metrics endpoints, the value maps the endpoint names to their ports
+	AnnPromAdditionalEndpoints = "prometheus.tikv.org/additional-endpoints"
+
+	// AnnConfigProvenance is configmap annotation key of the layer each key of the merged config
+	// comes from, the value maps the dotted key paths to the layers
+	AnnConfigProvenance = "tikv.org/config-provenance"
+
+	// AnnConfigManagedKeys is configmap annotation key of the data keys owned by the operator,
+	// the other keys are added by users and are kept when the configmap is updated
+	AnnConfigManagedKeys = "tikv.org/config-managed-keys"
+
 	// AnnPDDeferDeleting is pd pod annotation key  in pod for defer for deleting pod
 	AnnPDDeferDeleting = "tikv.org/pd-defer-deleting"
 
 	// AnnEvictLeaderBeginTime is pod annotation key to indicate the begin time for evicting region leader
 	AnnEvictLeaderBeginTime = "tikv.org/evictLeaderBeginTime"
 
+	// AnnConnectionDrainBeginTime is pod annotation key of the begin time for draining the connections of a tikv pod
+	// scaled in, the readiness probe fails while it is set
+	AnnConnectionDrainBeginTime = "tikv.org/connection-drain-begin-time"
+
+	// AnnTLSCertHash is pod annotation key of the hash of the rotated cluster TLS certificate the
+	// pod is restarted to load, changing it rolls the members not reloading certificates online
+	AnnTLSCertHash = "tikv.org/tls-cert-hash"
+
+	// AnnStoreState is tikv pod annotation key of the state of its store, see member.GetStoreState
+	AnnStoreState = "tikv.org/store-state"
+
 	// AnnPodDeferDeleting is pod annotation key to indicate the pod which need to be restarted
 	AnnPodDeferDeleting = "tikv.org/pod-defer-deleting"
 
@@ -69,6 +111,10 @@ const (
 	// AnnPVCPodScheduling is pod scheduling annotation key, it represents whether the pod is scheduling
 	AnnPVCPodScheduling = "tikv.org/pod-scheduling"
 
+	// AnnPVCReclaimKey is pvc annotation key telling the local volume provisioner whether to retain or delete the
+	// disk backing the pvc once it is deleted
+	AnnPVCReclaimKey = "tikv.org/pvc-reclaim"
+
 	// AnnPDDeleteSlots is annotation key of pd delete slots.
 	AnnPDDeleteSlots = "pd.tikv.org/delete-slots"
 
@@ -84,6 +130,24 @@ const (
 	// AnnForceUpgradeVal is tc annotation value to indicate whether force upgrade should be done
 	AnnForceUpgradeVal = "true"
 
+	// AnnForceScaleInVal is tc annotation value to allow scaling in tikv below the max-replicas of pd
+	AnnForceScaleInVal = "true"
+
+	// AnnSkipConnectionDrainVal is tc annotation value to remove the tikv pods scaled in without draining the connections
+	AnnSkipConnectionDrainVal = "true"
+
+	// AnnDisableEncryptionVal is tc annotation value to allow removing .tikv.encryption once the encryption at rest is enabled
+	AnnDisableEncryptionVal = "true"
+
+	// AnnRecoverFailoverVal is tc annotation value to recover all failure members and stores
+	AnnRecoverFailoverVal = "true"
+
+	// AnnPVCReclaimRetainVal is pvc annotation value to retain the disk backing the pvc once it is deleted
+	AnnPVCReclaimRetainVal = "retain"
+
+	// AnnPVCReclaimDeleteVal is pvc annotation value to delete the disk backing the pvc once it is deleted
+	AnnPVCReclaimDeleteVal = "delete"
+
 	// PDLabelVal is PD label value
 	PDLabelVal string = "pd"
 
@@ -93,6 +157,9 @@ const (
 	// DiscoveryLabelVal is Discovery label value
 	DiscoveryLabelVal string = "discovery"
 
+	// ScrapeTLSLabelVal is the label value of the client certificates copied for Prometheus
+	ScrapeTLSLabelVal string = "scrape-tls"
+
 	// TiKVOperator is ManagedByLabelKey label value
 	TiKVOperator string = "tikv-operator"
 )
@@ -20,3 +20,11 @@ type Manager interface {
 	// Sync	implements the logic for syncing tikvcluster.
 	Sync(*v1alpha1.TikvCluster) error
 }
+
+// MemberManager is a Manager of the members of a component whose status can be refreshed on its
+// own, e.g. when syncing the members is blocked by the failure of another component.
+type MemberManager interface {
+	Manager
+	// SyncStatus refreshes the status of the members in tikvcluster without changing them.
+	SyncStatus(*v1alpha1.TikvCluster) error
+}
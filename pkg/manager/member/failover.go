@@ -13,15 +13,100 @@
 
 package member
 
-import "github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+import (
+	"strings"
+
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/label"
+	utiltikvcluster "github.com/tikv/tikv-operator/pkg/util/tikvcluster"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog"
+)
 
 const (
 	unHealthEventReason     = "Unhealthy"
 	unHealthEventMsgPattern = "%s pod[%s] is unhealthy, msg:%s"
+
+	failoverLimitReachedReason = "FailoverLimitReached"
 )
 
+// FailoverEventReasons are the reasons of the events reporting a failover starting or refused,
+// they are recorded bypassing the rate limit of the events
+var FailoverEventReasons = []string{unHealthEventReason, failoverLimitReachedReason}
+
 // Failover implements the logic for pd/tikv/tidb's failover and recovery.
 type Failover interface {
 	Failover(*v1alpha1.TikvCluster) error
 	Recover(*v1alpha1.TikvCluster)
 }
+
+// RecoverFailover removes the failure members of pd and failure stores of tikv requested by the
+// recover-failover annotation and clears the annotation, it returns the pods recovered.
+// The extra replicas created by failover are then scaled in gracefully by the scalers, which
+// delete the stores or members and defer deleting their PVCs.
+func RecoverFailover(tc *v1alpha1.TikvCluster) ([]string, bool) {
+	val, ok := tc.Annotations[label.AnnRecoverFailoverKey]
+	if !ok {
+		return nil, false
+	}
+	all := val == label.AnnRecoverFailoverVal
+	pods := sets.NewString()
+	for _, podName := range strings.Split(val, ",") {
+		if podName = strings.TrimSpace(podName); podName != "" {
+			pods.Insert(podName)
+		}
+	}
+
+	var recovered []string
+	for podName := range tc.Status.PD.FailureMembers {
+		if all || pods.Has(podName) {
+			delete(tc.Status.PD.FailureMembers, podName)
+			recovered = append(recovered, podName)
+		}
+	}
+	for key, failureStore := range tc.Status.TiKV.FailureStores {
+		if all || pods.Has(failureStore.PodName) {
+			delete(tc.Status.TiKV.FailureStores, key)
+			recovered = append(recovered, failureStore.PodName)
+		}
+	}
+	delete(tc.Annotations, label.AnnRecoverFailoverKey)
+	resetFailoverLimitReachedCondition(tc)
+	klog.Infof("tikv cluster %s/%s: recover failover of %q, recovered pods: %v", tc.GetNamespace(), tc.GetName(), val, recovered)
+	return recovered, true
+}
+
+// failoverDisabled returns true if automatic failover is disabled by an unset or zero maxFailoverCount
+func failoverDisabled(maxFailoverCount *int32) bool {
+	return maxFailoverCount == nil || *maxFailoverCount == 0
+}
+
+// pdFailoverLimitReached returns true if failover has added as many pd replicas as spec.pd.maxFailoverCount allows
+func pdFailoverLimitReached(tc *v1alpha1.TikvCluster) bool {
+	return !failoverDisabled(tc.Spec.PD.MaxFailoverCount) && getFailureReplicas(tc) >= int(*tc.Spec.PD.MaxFailoverCount)
+}
+
+// tikvFailoverLimitReached returns true if failover has recorded as many tikv stores as spec.tikv.maxFailoverCount allows
+func tikvFailoverLimitReached(tc *v1alpha1.TikvCluster) bool {
+	return !failoverDisabled(tc.Spec.TiKV.MaxFailoverCount) && len(tc.Status.TiKV.FailureStores) >= int(*tc.Spec.TiKV.MaxFailoverCount)
+}
+
+func setFailoverLimitReachedCondition(tc *v1alpha1.TikvCluster, reason, message string) {
+	cond := utiltikvcluster.NewTikvClusterCondition(v1alpha1.FailoverLimitReached, corev1.ConditionTrue, reason, message)
+	utiltikvcluster.SetTikvClusterCondition(&tc.Status, *cond)
+}
+
+// resetFailoverLimitReachedCondition resets the FailoverLimitReached condition once the failures
+// of both pd and tikv are back under their maxFailoverCount, e.g. after being recovered manually
+func resetFailoverLimitReachedCondition(tc *v1alpha1.TikvCluster) {
+	cond := utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.FailoverLimitReached)
+	if cond == nil || cond.Status != corev1.ConditionTrue {
+		return
+	}
+	if pdFailoverLimitReached(tc) || tikvFailoverLimitReached(tc) {
+		return
+	}
+	cond = utiltikvcluster.NewTikvClusterCondition(v1alpha1.FailoverLimitReached, corev1.ConditionFalse, utiltikvcluster.FailoverLimitNotReached, "")
+	utiltikvcluster.SetTikvClusterCondition(&tc.Status, *cond)
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/label"
+)
+
+func TestRecoverFailover(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name                 string
+		annotations          map[string]string
+		expectRequested      bool
+		expectRecovered      []string
+		expectFailureMembers []string
+		expectFailureStores  []string
+	}{
+		{
+			name:                 "no annotation",
+			expectFailureMembers: []string{"test-pd-1"},
+			expectFailureStores:  []string{"1", "2"},
+		},
+		{
+			name:                 "recover all",
+			annotations:          map[string]string{label.AnnRecoverFailoverKey: label.AnnRecoverFailoverVal},
+			expectRequested:      true,
+			expectRecovered:      []string{"test-pd-1", "test-tikv-1", "test-tikv-2"},
+			expectFailureMembers: []string{},
+			expectFailureStores:  []string{},
+		},
+		{
+			name:                 "recover listed pods",
+			annotations:          map[string]string{label.AnnRecoverFailoverKey: "test-tikv-2, test-tikv-3"},
+			expectRequested:      true,
+			expectRecovered:      []string{"test-tikv-2"},
+			expectFailureMembers: []string{"test-pd-1"},
+			expectFailureStores:  []string{"1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvClusterForPD()
+			tc.Annotations = tt.annotations
+			tc.Status.PD.FailureMembers = map[string]v1alpha1.PDFailureMember{
+				"test-pd-1": {PodName: "test-pd-1"},
+			}
+			tc.Status.TiKV.FailureStores = map[string]v1alpha1.TiKVFailureStore{
+				"1": {PodName: "test-tikv-1", StoreID: "1"},
+				"2": {PodName: "test-tikv-2", StoreID: "2"},
+			}
+
+			recovered, requested := RecoverFailover(tc)
+			g.Expect(requested).To(Equal(tt.expectRequested))
+			g.Expect(recovered).To(ConsistOf(tt.expectRecovered))
+			g.Expect(tc.Annotations).NotTo(HaveKey(label.AnnRecoverFailoverKey))
+			var members, stores []string
+			for podName := range tc.Status.PD.FailureMembers {
+				members = append(members, podName)
+			}
+			for key := range tc.Status.TiKV.FailureStores {
+				stores = append(stores, key)
+			}
+			g.Expect(members).To(ConsistOf(tt.expectFailureMembers))
+			g.Expect(stores).To(ConsistOf(tt.expectFailureStores))
+		})
+	}
+}
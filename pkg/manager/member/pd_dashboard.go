@@ -0,0 +1,206 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/label"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog"
+)
+
+const (
+	// pdDashboardPath is the path the dashboard is served on by pd
+	pdDashboardPath = "/dashboard"
+
+	ingressClassAnnotation         = "kubernetes.io/ingress.class"
+	nginxBackendProtocolAnnotation = "nginx.ingress.kubernetes.io/backend-protocol"
+	nginxSSLPassthroughAnnotation  = "nginx.ingress.kubernetes.io/ssl-passthrough"
+)
+
+// syncPDDashboard syncs the service and the ingress exposing the pd dashboard, they are
+// deleted once the dashboard is no longer exposed
+func (pmm *pdMemberManager) syncPDDashboard(tc *v1alpha1.TikvCluster) error {
+	if controller.IsClusterPaused(tc) {
+		klog.V(4).Infof("tidb cluster %s/%s is paused, skip syncing for pd dashboard", tc.GetNamespace(), tc.GetName())
+		return nil
+	}
+
+	if !tc.PDDashboardExposed() {
+		return pmm.deletePDDashboard(tc)
+	}
+
+	if _, err := pmm.typedControl.CreateOrUpdateService(tc, getNewPDDashboardService(tc)); err != nil {
+		return err
+	}
+	if tc.Spec.PD.Dashboard.Ingress == nil {
+		return pmm.deletePDDashboardIngress(tc)
+	}
+	_, err := pmm.typedControl.CreateOrUpdateIngress(tc, getNewPDDashboardIngress(tc))
+	return err
+}
+
+// deletePDDashboard deletes the ingress and the service of the pd dashboard, the ingress is only
+// created along with the service so nothing is left once the service is gone
+func (pmm *pdMemberManager) deletePDDashboard(tc *v1alpha1.TikvCluster) error {
+	svc, err := pmm.svcLister.Services(tc.GetNamespace()).Get(controller.PDDashboardMemberName(tc.GetName()))
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(svc, tc) {
+		return nil
+	}
+	if err := pmm.deletePDDashboardIngress(tc); err != nil {
+		return err
+	}
+	err = pmm.typedControl.Delete(tc, svc.DeepCopy())
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (pmm *pdMemberManager) deletePDDashboardIngress(tc *v1alpha1.TikvCluster) error {
+	ingress := &extensionsv1beta1.Ingress{}
+	key := types.NamespacedName{Namespace: tc.GetNamespace(), Name: controller.PDDashboardMemberName(tc.GetName())}
+	exist, err := pmm.typedControl.Exist(key, ingress)
+	if err != nil {
+		return err
+	}
+	if !exist || !metav1.IsControlledBy(ingress, tc) {
+		return nil
+	}
+	err = pmm.typedControl.Delete(tc, ingress)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func getNewPDDashboardService(tc *v1alpha1.TikvCluster) *corev1.Service {
+	pdLabel := label.New().Instance(tc.GetInstanceName()).PD().Labels()
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            controller.PDDashboardMemberName(tc.Name),
+			Namespace:       tc.Namespace,
+			Labels:          pdLabel,
+			OwnerReferences: []metav1.OwnerReference{controller.GetOwnerRef(tc)},
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeClusterIP,
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "dashboard",
+					Port:       pdDashboardServicePort(tc),
+					TargetPort: intstr.FromInt(int(tc.Ports().PDClient)),
+					Protocol:   corev1.ProtocolTCP,
+				},
+			},
+			Selector: pdLabel,
+		},
+	}
+	svcSpec := tc.Spec.PD.Dashboard.Service
+	if svcSpec != nil {
+		if svcSpec.Type != "" {
+			svc.Spec.Type = svcSpec.Type
+		}
+		svc.ObjectMeta.Annotations = copyAnnotations(svcSpec.Annotations)
+		if svcSpec.LoadBalancerIP != nil {
+			svc.Spec.LoadBalancerIP = *svcSpec.LoadBalancerIP
+		}
+		if svcSpec.ClusterIP != nil {
+			svc.Spec.ClusterIP = *svcSpec.ClusterIP
+		}
+		if svcSpec.PortName != nil {
+			svc.Spec.Ports[0].Name = *svcSpec.PortName
+		}
+	}
+	return svc
+}
+
+// pdDashboardServicePort returns the port of the dashboard service, it can be overridden by
+// .spec.pd.dashboard.service.port
+func pdDashboardServicePort(tc *v1alpha1.TikvCluster) int32 {
+	if dashboard := tc.Spec.PD.Dashboard; dashboard != nil && dashboard.Service != nil && dashboard.Service.Port != nil {
+		return *dashboard.Service.Port
+	}
+	return v1alpha1.DefaultClusterPorts.PDClient
+}
+
+func getNewPDDashboardIngress(tc *v1alpha1.TikvCluster) *extensionsv1beta1.Ingress {
+	spec := tc.Spec.PD.Dashboard.Ingress
+	svcName := controller.PDDashboardMemberName(tc.Name)
+
+	annotations := copyAnnotations(spec.Annotations)
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	if spec.ClassName != nil {
+		annotations[ingressClassAnnotation] = *spec.ClassName
+	}
+	switch tc.PDDashboardBackendProtocol() {
+	case v1alpha1.PDDashboardBackendHTTPS:
+		annotations[nginxBackendProtocolAnnotation] = "HTTPS"
+	case v1alpha1.PDDashboardBackendPassthrough:
+		annotations[nginxSSLPassthroughAnnotation] = "true"
+	}
+
+	ingress := &extensionsv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            svcName,
+			Namespace:       tc.Namespace,
+			Labels:          label.New().Instance(tc.GetInstanceName()).PD().Labels(),
+			Annotations:     annotations,
+			OwnerReferences: []metav1.OwnerReference{controller.GetOwnerRef(tc)},
+		},
+		Spec: extensionsv1beta1.IngressSpec{
+			Rules: []extensionsv1beta1.IngressRule{
+				{
+					Host: spec.Host,
+					IngressRuleValue: extensionsv1beta1.IngressRuleValue{
+						HTTP: &extensionsv1beta1.HTTPIngressRuleValue{
+							Paths: []extensionsv1beta1.HTTPIngressPath{
+								{
+									Path: pdDashboardPath,
+									Backend: extensionsv1beta1.IngressBackend{
+										ServiceName: svcName,
+										ServicePort: intstr.FromInt(int(pdDashboardServicePort(tc))),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if spec.TLSSecretName != nil {
+		tls := extensionsv1beta1.IngressTLS{SecretName: *spec.TLSSecretName}
+		if spec.Host != "" {
+			tls.Hosts = []string{spec.Host}
+		}
+		ingress.Spec.TLS = []extensionsv1beta1.IngressTLS{tls}
+	}
+	return ingress
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	kubeinformers "k8s.io/client-go/informers"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/pointer"
+)
+
+func TestPDMemberManagerSyncPDDashboard(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pmm, _, _, _, _, _, _ := newFakePDMemberManager()
+	genericControl := controller.NewFakeGenericControl()
+	pmm.typedControl = controller.NewTypedControl(genericControl)
+	svcInformer := kubeinformers.NewSharedInformerFactory(kubefake.NewSimpleClientset(), 0).Core().V1().Services()
+	svcIndexer := svcInformer.Informer().GetIndexer()
+	pmm.svcLister = svcInformer.Lister()
+
+	tc := newTikvClusterForPD()
+	key := types.NamespacedName{Namespace: tc.Namespace, Name: controller.PDDashboardMemberName(tc.Name)}
+	getService := func() (*corev1.Service, bool) {
+		svc := &corev1.Service{}
+		exist, err := genericControl.Exist(key, svc)
+		g.Expect(err).NotTo(HaveOccurred())
+		return svc, exist
+	}
+	getIngress := func() (*extensionsv1beta1.Ingress, bool) {
+		ingress := &extensionsv1beta1.Ingress{}
+		exist, err := genericControl.Exist(key, ingress)
+		g.Expect(err).NotTo(HaveOccurred())
+		return ingress, exist
+	}
+
+	// not exposed
+	g.Expect(pmm.syncPDDashboard(tc)).To(Succeed())
+	_, exist := getService()
+	g.Expect(exist).To(BeFalse())
+
+	// exposed through an ingress
+	tc.Spec.PD.Dashboard = &v1alpha1.PDDashboardSpec{
+		Expose:  true,
+		Ingress: &v1alpha1.PDDashboardIngressSpec{Host: "pd.example.com"},
+	}
+	g.Expect(pmm.syncPDDashboard(tc)).To(Succeed())
+	svc, exist := getService()
+	g.Expect(exist).To(BeTrue())
+	g.Expect(svc.Spec.Ports).To(HaveLen(1))
+	g.Expect(svc.Spec.Ports[0].Port).To(Equal(int32(2379)))
+	_, exist = getIngress()
+	g.Expect(exist).To(BeTrue())
+
+	// the ingress is removed
+	tc.Spec.PD.Dashboard.Ingress = nil
+	g.Expect(pmm.syncPDDashboard(tc)).To(Succeed())
+	_, exist = getService()
+	g.Expect(exist).To(BeTrue())
+	_, exist = getIngress()
+	g.Expect(exist).To(BeFalse())
+
+	// no longer exposed, the service is looked up in the informer cache
+	tc.Spec.PD.Dashboard.Ingress = &v1alpha1.PDDashboardIngressSpec{}
+	g.Expect(pmm.syncPDDashboard(tc)).To(Succeed())
+	svc, _ = getService()
+	g.Expect(svcIndexer.Add(svc)).To(Succeed())
+	tc.Spec.PD.Dashboard.Expose = false
+	g.Expect(pmm.syncPDDashboard(tc)).To(Succeed())
+	_, exist = getService()
+	g.Expect(exist).To(BeFalse())
+	_, exist = getIngress()
+	g.Expect(exist).To(BeFalse())
+
+	// a service of the same name not controlled by the cluster is left alone
+	svc.ResourceVersion = ""
+	svc.OwnerReferences = nil
+	g.Expect(genericControl.FakeCli.Create(context.TODO(), svc)).To(Succeed())
+	g.Expect(svcIndexer.Update(svc)).To(Succeed())
+	g.Expect(pmm.syncPDDashboard(tc)).To(Succeed())
+	_, exist = getService()
+	g.Expect(exist).To(BeTrue())
+}
+
+func TestGetNewPDDashboardIngress(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name                string
+		ingress             v1alpha1.PDDashboardIngressSpec
+		expectedAnnotations map[string]string
+		expectedTLS         []extensionsv1beta1.IngressTLS
+	}{
+		{
+			name:                "default",
+			ingress:             v1alpha1.PDDashboardIngressSpec{},
+			expectedAnnotations: map[string]string{},
+		},
+		{
+			name: "class and tls",
+			ingress: v1alpha1.PDDashboardIngressSpec{
+				ClassName:     pointer.StringPtr("nginx"),
+				Host:          "pd.example.com",
+				TLSSecretName: pointer.StringPtr("pd-dashboard-tls"),
+				Annotations:   map[string]string{"foo": "bar"},
+			},
+			expectedAnnotations: map[string]string{
+				"foo":                         "bar",
+				"kubernetes.io/ingress.class": "nginx",
+			},
+			expectedTLS: []extensionsv1beta1.IngressTLS{
+				{Hosts: []string{"pd.example.com"}, SecretName: "pd-dashboard-tls"},
+			},
+		},
+		{
+			name:    "HTTPS",
+			ingress: v1alpha1.PDDashboardIngressSpec{BackendProtocol: v1alpha1.PDDashboardBackendHTTPS},
+			expectedAnnotations: map[string]string{
+				"nginx.ingress.kubernetes.io/backend-protocol": "HTTPS",
+			},
+		},
+		{
+			name:    "Passthrough",
+			ingress: v1alpha1.PDDashboardIngressSpec{Host: "pd.example.com", BackendProtocol: v1alpha1.PDDashboardBackendPassthrough},
+			expectedAnnotations: map[string]string{
+				"nginx.ingress.kubernetes.io/ssl-passthrough": "true",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvClusterForPD()
+			tc.Spec.PD.Dashboard = &v1alpha1.PDDashboardSpec{Expose: true, Ingress: &tt.ingress}
+			ingress := getNewPDDashboardIngress(tc)
+			g.Expect(ingress.Name).To(Equal("test-pd-dashboard"))
+			g.Expect(ingress.Annotations).To(Equal(tt.expectedAnnotations))
+			g.Expect(ingress.Spec.TLS).To(Equal(tt.expectedTLS))
+			g.Expect(ingress.Spec.Rules).To(HaveLen(1))
+			g.Expect(ingress.Spec.Rules[0].Host).To(Equal(tt.ingress.Host))
+			g.Expect(ingress.Spec.Rules[0].HTTP.Paths).To(Equal([]extensionsv1beta1.HTTPIngressPath{
+				{
+					Path: "/dashboard",
+					Backend: extensionsv1beta1.IngressBackend{
+						ServiceName: "test-pd-dashboard",
+						ServicePort: intstr.FromInt(2379),
+					},
+				},
+			}))
+		})
+	}
+}
@@ -22,7 +22,10 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
@@ -39,18 +42,44 @@ func NewPDDiscoveryManager(typedControl controller.TypedControlInterface) PDDisc
 }
 
 func (m *realPDDiscoveryManager) Reconcile(tc *v1alpha1.TikvCluster) error {
+	if tc.Spec.Discovery.ServiceAccountName == "" {
+		if err := m.syncRBAC(tc); err != nil {
+			return err
+		}
+	} else if err := m.deleteRBAC(tc); err != nil {
+		return controller.RequeueErrorf("error deleting discovery rbac: %v", err)
+	}
+
+	d, err := getTidbDiscoveryDeployment(tc)
+	if err != nil {
+		return controller.RequeueErrorf("error generating discovery deployment: %v", err)
+	}
+	deploy, err := m.ctrl.CreateOrUpdateDeployment(tc, d)
+	if err != nil {
+		return controller.RequeueErrorf("error creating or updating discovery service: %v", err)
+	}
+	// RBAC ensured, reconcile
+	_, err = m.ctrl.CreateOrUpdateService(tc, getTidbDiscoveryService(tc, deploy))
+	if err != nil {
+		return controller.RequeueErrorf("error creating or updating discovery service: %v", err)
+	}
+	return nil
+}
 
+// syncRBAC ensures the service account the discovery runs as by default, it is bound to a role that
+// can only read the cluster and the secrets of its namespace. The objects are owned by the cluster
+// and garbage collected along with it.
+func (m *realPDDiscoveryManager) syncRBAC(tc *v1alpha1.TikvCluster) error {
 	meta, _ := getDiscoveryMeta(tc, controller.DiscoveryMemberName)
 
-	// Ensure RBAC
 	_, err := m.ctrl.CreateOrUpdateRole(tc, &rbacv1.Role{
 		ObjectMeta: meta,
 		Rules: []rbacv1.PolicyRule{
 			{
 				APIGroups:     []string{v1alpha1.GroupName},
-				Resources:     []string{"tikvclusters"},
+				Resources:     []string{"tikvclusters", "tikvclusters/status"},
 				ResourceNames: []string{tc.Name},
-				Verbs:         []string{"get"},
+				Verbs:         []string{"get", "list"},
 			},
 			{
 				APIGroups: []string{corev1.GroupName},
@@ -83,24 +112,30 @@ func (m *realPDDiscoveryManager) Reconcile(tc *v1alpha1.TikvCluster) error {
 	if err != nil {
 		return controller.RequeueErrorf("error creating or updating discovery rolebinding: %v", err)
 	}
-	d, err := getTidbDiscoveryDeployment(tc)
-	if err != nil {
-		return controller.RequeueErrorf("error generating discovery deployment: %v", err)
-	}
-	deploy, err := m.ctrl.CreateOrUpdateDeployment(tc, d)
-	if err != nil {
-		return controller.RequeueErrorf("error creating or updating discovery service: %v", err)
-	}
-	// RBAC ensured, reconcile
-	_, err = m.ctrl.CreateOrUpdateService(tc, getTidbDiscoveryService(tc, deploy))
-	if err != nil {
-		return controller.RequeueErrorf("error creating or updating discovery service: %v", err)
+	return nil
+}
+
+// deleteRBAC deletes the rbac objects created by syncRBAC once the discovery runs as a service
+// account of users, the objects of the same names not controlled by the cluster are left alone
+func (m *realPDDiscoveryManager) deleteRBAC(tc *v1alpha1.TikvCluster) error {
+	key := types.NamespacedName{Namespace: tc.GetNamespace(), Name: controller.DiscoveryMemberName(tc.GetName())}
+	for _, obj := range []runtime.Object{&rbacv1.RoleBinding{}, &corev1.ServiceAccount{}, &rbacv1.Role{}} {
+		exist, err := m.ctrl.Exist(key, obj)
+		if err != nil {
+			return err
+		}
+		if !exist || !metav1.IsControlledBy(obj.(metav1.Object), tc) {
+			continue
+		}
+		if err := m.ctrl.Delete(tc, obj); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
 	}
 	return nil
 }
 
 func getTidbDiscoveryService(tc *v1alpha1.TikvCluster, deploy *appsv1.Deployment) *corev1.Service {
-	meta, _ := getDiscoveryMeta(tc, controller.DiscoveryMemberName)
+	meta, _ := getDiscoveryMeta(tc, controller.DiscoveryServiceName)
 	return &corev1.Service{
 		ObjectMeta: meta,
 		Spec: corev1.ServiceSpec{
@@ -117,7 +152,16 @@ func getTidbDiscoveryService(tc *v1alpha1.TikvCluster, deploy *appsv1.Deployment
 }
 
 func getTidbDiscoveryDeployment(tc *v1alpha1.TikvCluster) (*appsv1.Deployment, error) {
-	meta, l := getDiscoveryMeta(tc, controller.DiscoveryMemberName)
+	meta, l := getDiscoveryMeta(tc, controller.DiscoveryDeploymentName)
+	// the rbac objects are named after the member
+	saName := controller.DiscoveryMemberName(tc.Name)
+	if tc.Spec.Discovery.ServiceAccountName != "" {
+		saName = tc.Spec.Discovery.ServiceAccountName
+	}
+	var priorityClassName string
+	if tc.Spec.PriorityClassName != nil {
+		priorityClassName = *tc.Spec.PriorityClassName
+	}
 	d := &appsv1.Deployment{
 		ObjectMeta: meta,
 		Spec: appsv1.DeploymentSpec{
@@ -128,7 +172,8 @@ func getTidbDiscoveryDeployment(tc *v1alpha1.TikvCluster) (*appsv1.Deployment, e
 					Labels: l.Labels(),
 				},
 				Spec: corev1.PodSpec{
-					ServiceAccountName: meta.Name,
+					ServiceAccountName: saName,
+					PriorityClassName:  priorityClassName,
 					Containers: []corev1.Container{{
 						Name:      "discovery",
 						Resources: controller.ContainerResource(tc.Spec.Discovery.ResourceRequirements),
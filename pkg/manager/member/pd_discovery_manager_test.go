@@ -23,9 +23,13 @@ import (
 	"github.com/tikv/tikv-operator/pkg/controller"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
 )
 
 func newTikvClusterForPDDiscovery() *v1alpha1.TikvCluster {
@@ -126,6 +130,43 @@ func TestPDDiscoveryManager_Reconcile(t *testing.T) {
 			},
 			errOnCreateOrUpdate: false,
 		},
+		{
+			name: "Recreate deleted deployment",
+			prepare: func(tc *v1alpha1.TikvCluster, ctrl *controller.FakeGenericControl) {
+				dm := &realPDDiscoveryManager{ctrl: controller.NewTypedControl(ctrl)}
+				g.Expect(dm.Reconcile(tc)).To(Succeed())
+				deploy := &appsv1.Deployment{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      controller.DiscoveryDeploymentName(tc.Name),
+						Namespace: tc.Namespace,
+					},
+				}
+				g.Expect(ctrl.FakeCli.Delete(context.TODO(), deploy)).To(Succeed())
+			},
+			expect: func(deploys []appsv1.Deployment, tc *v1alpha1.TikvCluster, err error) {
+				g.Expect(err).To(Succeed())
+				g.Expect(deploys).To(HaveLen(1))
+				g.Expect(deploys[0].Name).To((Equal("test-discovery")))
+			},
+			errOnCreateOrUpdate: false,
+		},
+		{
+			name: "Service account of users",
+			prepare: func(tc *v1alpha1.TikvCluster, ctrl *controller.FakeGenericControl) {
+				dm := &realPDDiscoveryManager{ctrl: controller.NewTypedControl(ctrl)}
+				g.Expect(dm.Reconcile(tc)).To(Succeed())
+				sa := &corev1.ServiceAccount{}
+				key := types.NamespacedName{Namespace: tc.Namespace, Name: controller.DiscoveryMemberName(tc.Name)}
+				g.Expect(ctrl.FakeCli.Get(context.TODO(), key, sa)).To(Succeed())
+				tc.Spec.Discovery.ServiceAccountName = "discovery"
+			},
+			expect: func(deploys []appsv1.Deployment, tc *v1alpha1.TikvCluster, err error) {
+				g.Expect(err).To(Succeed())
+				g.Expect(deploys).To(HaveLen(1))
+				g.Expect(deploys[0].Spec.Template.Spec.ServiceAccountName).To(Equal("discovery"))
+			},
+			errOnCreateOrUpdate: false,
+		},
 		{
 			name: "Create or update resource error",
 			expect: func(deploys []appsv1.Deployment, tc *v1alpha1.TikvCluster, err error) {
@@ -140,6 +181,52 @@ func TestPDDiscoveryManager_Reconcile(t *testing.T) {
 	}
 }
 
+func TestPDDiscoveryManagerServiceAccountOfUsers(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForPDDiscovery()
+	dm, ctrl := newFakePDDiscoveryManager()
+	key := types.NamespacedName{Namespace: tc.Namespace, Name: controller.DiscoveryMemberName(tc.Name)}
+	g.Expect(dm.Reconcile(tc)).To(Succeed())
+	role := &rbacv1.Role{}
+	g.Expect(ctrl.FakeCli.Get(context.TODO(), key, role)).To(Succeed())
+	g.Expect(role.Rules[0].Resources).To(ConsistOf("tikvclusters", "tikvclusters/status"))
+	g.Expect(role.Rules[0].Verbs).To(ConsistOf("get", "list"))
+
+	// the rbac objects created by default are deleted once users bring their own service account
+	tc.Spec.Discovery.ServiceAccountName = "discovery"
+	g.Expect(dm.Reconcile(tc)).To(Succeed())
+	for _, obj := range []runtime.Object{&rbacv1.RoleBinding{}, &corev1.ServiceAccount{}, &rbacv1.Role{}} {
+		err := ctrl.FakeCli.Get(context.TODO(), key, obj)
+		g.Expect(errors.IsNotFound(err)).To(BeTrue())
+	}
+
+	// a service account of the same name not controlled by the cluster is kept
+	g.Expect(ctrl.AddObject(&corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+	})).To(Succeed())
+	g.Expect(dm.Reconcile(tc)).To(Succeed())
+	g.Expect(ctrl.FakeCli.Get(context.TODO(), key, &corev1.ServiceAccount{})).To(Succeed())
+}
+
+func TestGetTidbDiscoveryDeploymentPriorityClassName(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForPDDiscovery()
+	d, err := getTidbDiscoveryDeployment(tc)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(d.Spec.Template.Spec.PriorityClassName).To(BeEmpty())
+	oldTemplate := d.Annotations[controller.LastAppliedPodTemplate]
+
+	// the discovery runs at the priority of the cluster, the pod is replaced once it changes
+	tc.Spec.PriorityClassName = pointer.StringPtr("tikv-critical")
+	tc.Spec.TiKV.PriorityClassName = pointer.StringPtr("tikv-store")
+	d, err = getTidbDiscoveryDeployment(tc)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(d.Spec.Template.Spec.PriorityClassName).To(Equal("tikv-critical"))
+	g.Expect(d.Annotations[controller.LastAppliedPodTemplate]).NotTo(Equal(oldTemplate))
+}
+
 func newFakePDDiscoveryManager() (*realPDDiscoveryManager, *controller.FakeGenericControl) {
 	ctrl := controller.NewFakeGenericControl()
 	return &realPDDiscoveryManager{
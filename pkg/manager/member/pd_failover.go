@@ -23,12 +23,14 @@ import (
 	"github.com/tikv/tikv-operator/pkg/controller"
 	"github.com/tikv/tikv-operator/pkg/pdapi"
 	"github.com/tikv/tikv-operator/pkg/util"
+	utiltikvcluster "github.com/tikv/tikv-operator/pkg/util/tikvcluster"
 	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
+	podutil "k8s.io/kubernetes/pkg/api/v1/pod"
 )
 
 type pdFailover struct {
@@ -69,6 +71,11 @@ func (pf *pdFailover) Failover(tc *v1alpha1.TikvCluster) error {
 	ns := tc.GetNamespace()
 	tcName := tc.GetName()
 
+	if failoverDisabled(tc.Spec.PD.MaxFailoverCount) {
+		return nil
+	}
+	resetFailoverLimitReachedCondition(tc)
+
 	if !tc.Status.PD.Synced {
 		return fmt.Errorf("TikvCluster: %s/%s's pd status sync failed, can't failover", ns, tcName)
 	}
@@ -78,24 +85,20 @@ func (pf *pdFailover) Failover(tc *v1alpha1.TikvCluster) error {
 
 	healthCount := 0
 	for podName, pdMember := range tc.Status.PD.Members {
-		if pdMember.Health {
+		if _, unhealthy := pf.unhealthySince(tc, podName, pdMember); !unhealthy {
 			healthCount++
 		} else {
 			pf.recorder.Eventf(tc, apiv1.EventTypeWarning, "PDMemberUnhealthy",
 				"%s(%s) is unhealthy", podName, pdMember.ID)
 		}
 	}
+	// failover never deletes a healthy member, so the pd cluster keeps its quorum
+	// after the failure member is removed as long as it has one now
 	inQuorum := healthCount > len(tc.Status.PD.Members)/2
 	if !inQuorum {
 		return fmt.Errorf("TikvCluster: %s/%s's pd cluster is not health: %d/%d, "+
 			"replicas: %d, failureCount: %d, can't failover",
-			ns, tcName, healthCount, tc.PDStsDesiredReplicas(), tc.Spec.PD.Replicas, len(tc.Status.PD.FailureMembers))
-	}
-
-	failureReplicas := getFailureReplicas(tc)
-	if failureReplicas >= int(*tc.Spec.PD.MaxFailoverCount) {
-		klog.Errorf("PD failover replicas (%d) reaches the limit (%d), skip failover", failureReplicas, *tc.Spec.PD.MaxFailoverCount)
-		return nil
+			ns, tcName, healthCount, len(tc.Status.PD.Members), tc.Spec.PD.Replicas, len(tc.Status.PD.FailureMembers))
 	}
 
 	notDeletedCount := 0
@@ -114,6 +117,7 @@ func (pf *pdFailover) Failover(tc *v1alpha1.TikvCluster) error {
 
 func (pf *pdFailover) Recover(tc *v1alpha1.TikvCluster) {
 	tc.Status.PD.FailureMembers = nil
+	resetFailoverLimitReachedCondition(tc)
 	klog.Infof("pd failover: clearing pd failoverMembers, %s/%s", tc.GetNamespace(), tc.GetName())
 }
 
@@ -132,12 +136,21 @@ func (pf *pdFailover) tryToMarkAPeerAsFailure(tc *v1alpha1.TikvCluster) error {
 		if tc.Status.PD.FailureMembers == nil {
 			tc.Status.PD.FailureMembers = map[string]v1alpha1.PDFailureMember{}
 		}
-		deadline := pdMember.LastTransitionTime.Add(pf.pdFailoverPeriod)
+		since, unhealthy := pf.unhealthySince(tc, podName, pdMember)
+		deadline := since.Add(pf.pdFailoverPeriod)
 		_, exist := tc.Status.PD.FailureMembers[podName]
-		if pdMember.Health || time.Now().Before(deadline) || exist {
+		if !unhealthy || time.Now().Before(deadline) || exist {
 			continue
 		}
 
+		if pdFailoverLimitReached(tc) {
+			msg := fmt.Sprintf("pd member[%s] of pod %s is unhealthy, but failover replicas reached the maxFailoverCount %d, skip failover",
+				pdMember.ID, podName, *tc.Spec.PD.MaxFailoverCount)
+			pf.recorder.Event(tc, apiv1.EventTypeWarning, failoverLimitReachedReason, msg)
+			setFailoverLimitReachedCondition(tc, utiltikvcluster.PDFailoverLimitReached, msg)
+			return nil
+		}
+
 		ordinal, err := util.GetOrdinalFromPodName(podName)
 		if err != nil {
 			return err
@@ -239,6 +252,24 @@ func (pf *pdFailover) tryToDeleteAFailureMember(tc *v1alpha1.TikvCluster) error
 	return nil
 }
 
+// unhealthySince returns whether the pd member is unhealthy and since when. A member
+// whose pod is crash looping is considered unhealthy even if pd has not noticed it yet,
+// in that case the time the pod became not ready is used.
+func (pf *pdFailover) unhealthySince(tc *v1alpha1.TikvCluster, podName string, pdMember v1alpha1.PDMember) (time.Time, bool) {
+	if !pdMember.Health {
+		return pdMember.LastTransitionTime.Time, true
+	}
+	pod, err := pf.podLister.Pods(tc.GetNamespace()).Get(podName)
+	if err != nil || !podCrashLooping(pod) {
+		return time.Time{}, false
+	}
+	_, condition := podutil.GetPodCondition(&pod.Status, apiv1.PodReady)
+	if condition == nil || condition.LastTransitionTime.IsZero() {
+		return pdMember.LastTransitionTime.Time, true
+	}
+	return condition.LastTransitionTime.Time, true
+}
+
 func setMemberDeleted(tc *v1alpha1.TikvCluster, podName string) {
 	failureMember := tc.Status.PD.FailureMembers[podName]
 	failureMember.MemberDeleted = true
@@ -25,6 +25,7 @@ import (
 	"github.com/tikv/tikv-operator/pkg/client/clientset/versioned/fake"
 	"github.com/tikv/tikv-operator/pkg/controller"
 	"github.com/tikv/tikv-operator/pkg/pdapi"
+	utiltikvcluster "github.com/tikv/tikv-operator/pkg/util/tikvcluster"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -48,6 +49,7 @@ func TestPDFailoverFailover(t *testing.T) {
 		hasPod                   bool
 		podWithDeletionTimestamp bool
 		pvcWithDeletionTimestamp bool
+		podCrashLoopingSince     time.Duration
 		delMemberFailed          bool
 		delPodFailed             bool
 		delPVCFailed             bool
@@ -246,6 +248,56 @@ func TestPDFailoverFailover(t *testing.T) {
 				g.Expect(events[1]).To(ContainSubstring("Unhealthy pd pod[test-pd-1] is unhealthy, msg:pd member[12891273174085095651] is unhealthy"))
 			},
 		},
+		{
+			name:                     "has one crash looping member, and exceed deadline",
+			update:                   oneCrashLoopingMember,
+			maxFailoverCount:         3,
+			hasPVC:                   true,
+			hasPod:                   true,
+			podWithDeletionTimestamp: false,
+			podCrashLoopingSince:     10 * time.Minute,
+			delMemberFailed:          false,
+			delPodFailed:             false,
+			delPVCFailed:             false,
+			statusSyncFailed:         false,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(strings.Contains(err.Error(), "marking Pod: default/test-pd-1 pd member: test-pd-1 as failure")).To(Equal(true))
+			},
+			expectFn: func(tc *v1alpha1.TikvCluster, _ *pdFailover) {
+				g.Expect(int(tc.Spec.PD.Replicas)).To(Equal(3))
+				g.Expect(len(tc.Status.PD.FailureMembers)).To(Equal(1))
+				failureMembers := tc.Status.PD.FailureMembers["test-pd-1"]
+				g.Expect(failureMembers.MemberID).To(Equal("12891273174085095651"))
+				g.Expect(string(failureMembers.PVCUID)).To(Equal("pvc-1-uid"))
+				g.Expect(failureMembers.MemberDeleted).To(BeFalse())
+				events := collectEvents(recorder.Events)
+				g.Expect(events).To(HaveLen(2))
+				g.Expect(events[0]).To(ContainSubstring("test-pd-1(12891273174085095651) is unhealthy"))
+				g.Expect(events[1]).To(ContainSubstring("Unhealthy pd pod[test-pd-1] is unhealthy"))
+			},
+		},
+		{
+			name:                     "has one crash looping member, but not exceed deadline",
+			update:                   oneCrashLoopingMember,
+			maxFailoverCount:         3,
+			hasPVC:                   true,
+			hasPod:                   true,
+			podWithDeletionTimestamp: false,
+			podCrashLoopingSince:     2 * time.Minute,
+			delMemberFailed:          false,
+			delPodFailed:             false,
+			delPVCFailed:             false,
+			statusSyncFailed:         false,
+			errExpectFn:              errExpectNil,
+			expectFn: func(tc *v1alpha1.TikvCluster, _ *pdFailover) {
+				g.Expect(int(tc.Spec.PD.Replicas)).To(Equal(3))
+				g.Expect(len(tc.Status.PD.FailureMembers)).To(Equal(0))
+				events := collectEvents(recorder.Events)
+				g.Expect(events).To(HaveLen(1))
+				g.Expect(events[0]).To(ContainSubstring("test-pd-1(12891273174085095651) is unhealthy"))
+			},
+		},
 		{
 			name:                     "has one not ready member but maxFailoverCount is 0",
 			update:                   oneNotReadyMember,
@@ -262,8 +314,38 @@ func TestPDFailoverFailover(t *testing.T) {
 				g.Expect(int(tc.Spec.PD.Replicas)).To(Equal(3))
 				g.Expect(len(tc.Status.PD.FailureMembers)).To(Equal(0))
 				events := collectEvents(recorder.Events)
-				g.Expect(events).To(HaveLen(1))
+				g.Expect(events).To(HaveLen(0))
+			},
+		},
+		{
+			name: "has one not ready member but failover replicas reached maxFailoverCount",
+			update: func(tc *v1alpha1.TikvCluster) {
+				oneNotReadyMember(tc)
+				tc.Status.PD.FailureMembers = map[string]v1alpha1.PDFailureMember{
+					"test-pd-3": {PodName: "test-pd-3", MemberID: "3", MemberDeleted: true},
+				}
+			},
+			maxFailoverCount:         1,
+			hasPVC:                   true,
+			hasPod:                   true,
+			podWithDeletionTimestamp: false,
+			delMemberFailed:          false,
+			delPodFailed:             false,
+			delPVCFailed:             false,
+			statusSyncFailed:         false,
+			errExpectFn:              errExpectNil,
+			expectFn: func(tc *v1alpha1.TikvCluster, _ *pdFailover) {
+				g.Expect(int(tc.Spec.PD.Replicas)).To(Equal(3))
+				g.Expect(tc.Status.PD.FailureMembers).To(HaveLen(1))
+				g.Expect(tc.Status.PD.FailureMembers).NotTo(HaveKey("test-pd-1"))
+				cond := utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.FailoverLimitReached)
+				g.Expect(cond).NotTo(BeNil())
+				g.Expect(cond.Status).To(Equal(corev1.ConditionTrue))
+				g.Expect(cond.Reason).To(Equal(utiltikvcluster.PDFailoverLimitReached))
+				events := collectEvents(recorder.Events)
+				g.Expect(events).To(HaveLen(2))
 				g.Expect(events[0]).To(ContainSubstring("test-pd-1(12891273174085095651) is unhealthy"))
+				g.Expect(events[1]).To(ContainSubstring("FailoverLimitReached pd member[12891273174085095651] of pod test-pd-1 is unhealthy"))
 			},
 		},
 		{
@@ -494,6 +576,9 @@ func TestPDFailoverFailover(t *testing.T) {
 				if test.podWithDeletionTimestamp {
 					pod.DeletionTimestamp = &metav1.Time{Time: time.Now()}
 				}
+				if test.podCrashLoopingSince > 0 {
+					setPodCrashLooping(pod, time.Now().Add(-test.podCrashLoopingSince))
+				}
 				podIndexer.Add(pod)
 			}
 			if test.delPodFailed {
@@ -691,6 +776,17 @@ func oneNotReadyMemberAndAFailureMember(tc *v1alpha1.TikvCluster) {
 	}
 }
 
+func oneCrashLoopingMember(tc *v1alpha1.TikvCluster) {
+	pd0 := ordinalPodName(v1alpha1.PDMemberType, tc.GetName(), 0)
+	pd1 := ordinalPodName(v1alpha1.PDMemberType, tc.GetName(), 1)
+	pd2 := ordinalPodName(v1alpha1.PDMemberType, tc.GetName(), 2)
+	tc.Status.PD.Members = map[string]v1alpha1.PDMember{
+		pd0: {Name: pd0, ID: "0", Health: true},
+		pd1: {Name: pd1, ID: "12891273174085095651", Health: true, LastTransitionTime: metav1.Time{Time: time.Now().Add(-time.Hour)}},
+		pd2: {Name: pd2, ID: "2", Health: true},
+	}
+}
+
 func allMembersReady(tc *v1alpha1.TikvCluster) {
 	pd0 := ordinalPodName(v1alpha1.PDMemberType, tc.GetName(), 0)
 	pd1 := ordinalPodName(v1alpha1.PDMemberType, tc.GetName(), 1)
@@ -743,6 +839,24 @@ func newPodForPDFailover(tc *v1alpha1.TikvCluster, memberType v1alpha1.MemberTyp
 	}
 }
 
+func setPodCrashLooping(pod *corev1.Pod, notReadySince time.Time) {
+	pod.Status.Conditions = []corev1.PodCondition{
+		{
+			Type:               corev1.PodReady,
+			Status:             corev1.ConditionFalse,
+			LastTransitionTime: metav1.Time{Time: notReadySince},
+		},
+	}
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{
+		{
+			Name: v1alpha1.PDMemberType.String(),
+			State: corev1.ContainerState{
+				Waiting: &corev1.ContainerStateWaiting{Reason: CrashLoopBackOff},
+			},
+		},
+	}
+}
+
 func collectEvents(source <-chan string) []string {
 	done := false
 	events := make([]string, 0)
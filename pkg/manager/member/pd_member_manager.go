@@ -15,12 +15,14 @@ package member
 
 import (
 	"fmt"
+	"path"
 	"strconv"
 	"strings"
 
 	"github.com/Masterminds/semver"
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
 	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/deps"
 	"github.com/tikv/tikv-operator/pkg/label"
 	"github.com/tikv/tikv-operator/pkg/manager"
 	"github.com/tikv/tikv-operator/pkg/pdapi"
@@ -29,12 +31,15 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/uuid"
 	v1 "k8s.io/client-go/listers/apps/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
 	podutil "k8s.io/kubernetes/pkg/api/v1/pod"
+	"k8s.io/utils/pointer"
 )
 
 const (
@@ -58,41 +63,45 @@ type pdMemberManager struct {
 	pdUpgrader   Upgrader
 	autoFailover bool
 	pdFailover   Failover
+	syncStatus   *controller.InformerSyncStatus
+	recorder     record.EventRecorder
+	clock        clock.Clock
 }
 
 // NewPDMemberManager returns a *pdMemberManager
-func NewPDMemberManager(pdControl pdapi.PDControlInterface,
-	setControl controller.StatefulSetControlInterface,
-	svcControl controller.ServiceControlInterface,
-	podControl controller.PodControlInterface,
-	typedControl controller.TypedControlInterface,
-	setLister v1.StatefulSetLister,
-	svcLister corelisters.ServiceLister,
-	podLister corelisters.PodLister,
-	epsLister corelisters.EndpointsLister,
-	pvcLister corelisters.PersistentVolumeClaimLister,
+func NewPDMemberManager(deps *deps.Dependencies,
 	pdScaler Scaler,
 	pdUpgrader Upgrader,
 	autoFailover bool,
-	pdFailover Failover) manager.Manager {
+	pdFailover Failover,
+	syncStatus *controller.InformerSyncStatus) manager.MemberManager {
 	return &pdMemberManager{
-		pdControl,
-		setControl,
-		svcControl,
-		podControl,
-		typedControl,
-		setLister,
-		svcLister,
-		podLister,
-		epsLister,
-		pvcLister,
-		pdScaler,
-		pdUpgrader,
-		autoFailover,
-		pdFailover}
+		pdControl:    deps.PDControl,
+		setControl:   deps.StatefulSetControl,
+		svcControl:   deps.ServiceControl,
+		podControl:   deps.PodControl,
+		typedControl: deps.TypedControl,
+		setLister:    deps.StatefulSetLister,
+		svcLister:    deps.ServiceLister,
+		podLister:    deps.PodLister,
+		epsLister:    deps.EndpointLister,
+		pvcLister:    deps.PVCLister,
+		pdScaler:     pdScaler,
+		pdUpgrader:   pdUpgrader,
+		autoFailover: autoFailover,
+		pdFailover:   pdFailover,
+		syncStatus:   syncStatus,
+		recorder:     deps.Recorder,
+		clock:        deps.Clock,
+	}
 }
 
 func (pmm *pdMemberManager) Sync(tc *v1alpha1.TikvCluster) error {
+	// the services and the statefulset deleted by the garbage collector must not be recreated
+	if err := checkClusterDeleting(tc, "syncing pd"); err != nil {
+		return err
+	}
+
 	// Sync PD Service
 	if err := pmm.syncPDServiceForTikvCluster(tc); err != nil {
 		return err
@@ -103,12 +112,29 @@ func (pmm *pdMemberManager) Sync(tc *v1alpha1.TikvCluster) error {
 		return err
 	}
 
+	// Sync PD Dashboard Service and Ingress
+	if err := pmm.syncPDDashboard(tc); err != nil {
+		return err
+	}
+
 	// Sync PD StatefulSet
 	return pmm.syncPDStatefulSetForTikvCluster(tc)
 }
 
+// SyncStatus fulfills the manager.MemberManager interface
+func (pmm *pdMemberManager) SyncStatus(tc *v1alpha1.TikvCluster) error {
+	set, err := pmm.setLister.StatefulSets(tc.GetNamespace()).Get(controller.PDMemberName(tc.GetName()))
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return pmm.syncTikvClusterStatus(tc, set.DeepCopy())
+}
+
 func (pmm *pdMemberManager) syncPDServiceForTikvCluster(tc *v1alpha1.TikvCluster) error {
-	if tc.Spec.Paused {
+	if controller.IsClusterPaused(tc) {
 		klog.V(4).Infof("tidb cluster %s/%s is paused, skip syncing for pd service", tc.GetNamespace(), tc.GetName())
 		return nil
 	}
@@ -152,7 +178,7 @@ func (pmm *pdMemberManager) syncPDServiceForTikvCluster(tc *v1alpha1.TikvCluster
 }
 
 func (pmm *pdMemberManager) syncPDHeadlessServiceForTikvCluster(tc *v1alpha1.TikvCluster) error {
-	if tc.Spec.Paused {
+	if controller.IsClusterPaused(tc) {
 		klog.V(4).Infof("tidb cluster %s/%s is paused, skip syncing for pd headless service", tc.GetNamespace(), tc.GetName())
 		return nil
 	}
@@ -207,7 +233,7 @@ func (pmm *pdMemberManager) syncPDStatefulSetForTikvCluster(tc *v1alpha1.TikvClu
 		klog.Errorf("failed to sync TikvCluster: [%s/%s]'s status, error: %v", ns, tcName, err)
 	}
 
-	if tc.Spec.Paused {
+	if controller.IsClusterPaused(tc) {
 		klog.V(4).Infof("tidb cluster %s/%s is paused, skip syncing for pd statefulset", tc.GetNamespace(), tc.GetName())
 		return nil
 	}
@@ -232,22 +258,24 @@ func (pmm *pdMemberManager) syncPDStatefulSetForTikvCluster(tc *v1alpha1.TikvClu
 		return controller.RequeueErrorf("TikvCluster: [%s/%s], waiting for PD cluster running", ns, tcName)
 	}
 
-	if !tc.Status.PD.Synced {
-		force := NeedForceUpgrade(tc)
-		if force {
-			tc.Status.PD.Phase = v1alpha1.UpgradePhase
-			setUpgradePartition(newPDSet, 0)
-			errSTS := updateStatefulSet(pmm.setControl, tc, newPDSet, oldPDSet)
-			return controller.RequeueErrorf("tidbcluster: [%s/%s]'s pd needs force upgrade, %v", ns, tcName, errSTS)
-		}
+	// upgrading, scaling and failover rely on the pods and pvcs, defer them while the caches are warming up
+	if !pmm.syncStatus.HasSynced(controller.PodInformer, controller.PVCInformer) {
+		return controller.RequeueErrorf("TikvCluster: [%s/%s], waiting for the caches of pods and pvcs to sync pd statefulset", ns, tcName)
 	}
 
-	if !templateEqual(newPDSet, oldPDSet) || tc.Status.PD.Phase == v1alpha1.UpgradePhase {
+	force := forceUpgradeAllowed(tc)
+	if !templateEqual(newPDSet, oldPDSet) || tc.Status.PD.Phase == v1alpha1.UpgradePhase || force {
 		if err := pmm.pdUpgrader.Upgrade(tc, oldPDSet, newPDSet); err != nil {
 			return err
 		}
 	}
 
+	if force && !tc.Status.PD.Synced {
+		// scaling and failover rely on pd, only the upgrade goes on while pd is down
+		errSTS := updateStatefulSet(pmm.setControl, tc, newPDSet, oldPDSet)
+		return controller.RequeueErrorf("tidbcluster: [%s/%s]'s pd needs force upgrade, %v", ns, tcName, errSTS)
+	}
+
 	if err := pmm.pdScaler.Scale(tc, oldPDSet, newPDSet); err != nil {
 		return err
 	}
@@ -262,7 +290,13 @@ func (pmm *pdMemberManager) syncPDStatefulSetForTikvCluster(tc *v1alpha1.TikvClu
 		}
 	}
 
-	return updateStatefulSet(pmm.setControl, tc, newPDSet, oldPDSet)
+	if keepLegacyPromAnnotations(newPDSet, oldPDSet) {
+		if err := migrateLegacyPromAnnotationsOfPods(pmm.podLister, pmm.podControl, tc, label.New().Instance(tc.GetInstanceName()).PD()); err != nil {
+			return err
+		}
+	}
+
+	return updateStatefulSetDetectingDrift(pmm.setControl, pmm.recorder, tc, v1alpha1.PDMemberType, newPDSet, oldPDSet)
 }
 
 // shouldRecover checks whether we should perform recovery operation.
@@ -302,6 +336,9 @@ func (pmm *pdMemberManager) syncTikvClusterStatus(tc *v1alpha1.TikvCluster, set
 	tcName := tc.GetName()
 
 	tc.Status.PD.StatefulSet = &set.Status
+	tc.Status.PD.CurrentRevision = set.Status.CurrentRevision
+	tc.Status.PD.UpdateRevision = set.Status.UpdateRevision
+	tc.Status.PD.UpgradedReplicas = set.Status.UpdatedReplicas
 
 	upgrading, err := pmm.pdStatefulSetIsUpgrading(set, tc)
 	if err != nil {
@@ -311,6 +348,7 @@ func (pmm *pdMemberManager) syncTikvClusterStatus(tc *v1alpha1.TikvCluster, set
 		tc.Status.PD.Phase = v1alpha1.UpgradePhase
 	} else {
 		tc.Status.PD.Phase = v1alpha1.NormalPhase
+		tc.Status.PD.UpgradingOrdinal = nil
 	}
 
 	pdClient := controller.GetPDClient(pmm.pdControl, tc)
@@ -365,7 +403,7 @@ func (pmm *pdMemberManager) syncTikvClusterStatus(tc *v1alpha1.TikvCluster, set
 
 		oldPDMember, exist := tc.Status.PD.Members[name]
 
-		status.LastTransitionTime = metav1.Now()
+		status.LastTransitionTime = metav1.NewTime(pmm.clock.Now())
 		if exist && status.Health == oldPDMember.Health {
 			status.LastTransitionTime = oldPDMember.LastTransitionTime
 		}
@@ -432,8 +470,8 @@ func (pmm *pdMemberManager) getNewPDServiceForTikvCluster(tc *v1alpha1.TikvClust
 			Ports: []corev1.ServicePort{
 				{
 					Name:       "client",
-					Port:       2379,
-					TargetPort: intstr.FromInt(2379),
+					Port:       tc.PDServicePort(),
+					TargetPort: intstr.FromInt(int(tc.Ports().PDClient)),
 					Protocol:   corev1.ProtocolTCP,
 				},
 			},
@@ -479,8 +517,8 @@ func getNewPDHeadlessServiceForTikvCluster(tc *v1alpha1.TikvCluster) *corev1.Ser
 			Ports: []corev1.ServicePort{
 				{
 					Name:       "peer",
-					Port:       2380,
-					TargetPort: intstr.FromInt(2380),
+					Port:       tc.Ports().PDPeer,
+					TargetPort: intstr.FromInt(int(tc.Ports().PDPeer)),
 					Protocol:   corev1.ProtocolTCP,
 				},
 			},
@@ -546,7 +584,7 @@ func getNewPDSetForTikvCluster(tc *v1alpha1.TikvCluster, cm *corev1.ConfigMap) (
 	}
 	if tc.IsTLSClusterEnabled() {
 		volMounts = append(volMounts, corev1.VolumeMount{
-			Name: "pd-tls", ReadOnly: true, MountPath: "/var/lib/pd-tls",
+			Name: "pd-tls", ReadOnly: true, MountPath: pdClusterCertPath,
 		})
 	}
 
@@ -575,13 +613,14 @@ func getNewPDSetForTikvCluster(tc *v1alpha1.TikvCluster, cm *corev1.ConfigMap) (
 	}
 	if tc.IsTLSClusterEnabled() {
 		vols = append(vols, corev1.Volume{
-			Name: "pd-tls", VolumeSource: corev1.VolumeSource{
-				Secret: &corev1.SecretVolumeSource{
-					SecretName: util.ClusterTLSSecretName(tc.Name, label.PDLabelVal),
-				},
-			},
+			Name: "pd-tls", VolumeSource: tlsSecretVolumeSource(util.ClusterTLSSecretName(tc.Name, label.PDLabelVal)),
 		})
 	}
+	if readOnlyRootFilesystem(basePDSpec.ContainerSecurityContext()) {
+		tmpMount, tmpVolume := tmpMountVolume()
+		volMounts = append(volMounts, tmpMount)
+		vols = append(vols, tmpVolume)
+	}
 
 	storageRequest, err := controller.ParseStorageRequest(tc.Spec.PD.Requests)
 	if err != nil {
@@ -590,29 +629,35 @@ func getNewPDSetForTikvCluster(tc *v1alpha1.TikvCluster, cm *corev1.ConfigMap) (
 
 	pdLabel := label.New().Instance(instanceName).PD()
 	setName := controller.PDMemberName(tcName)
-	podAnnotations := CombineAnnotations(controller.AnnProm(2379), basePDSpec.Annotations())
+	podAnnotations := controller.MigrateLegacyPromAnnotations(MergeAnnotations(controller.AnnPromTLS(tc.Ports().PDClient, tc.Scheme()), basePDSpec.Annotations()))
+	if hash := tc.Status.PD.TLSCertRolloutHash; hash != "" {
+		podAnnotations[label.AnnTLSCertHash] = hash
+	}
 	stsAnnotations := getStsAnnotations(tc, label.PDLabelVal)
 	failureReplicas := getFailureReplicas(tc)
+	securityContext := containerSecurityContext(basePDSpec.ContainerSecurityContext(), nil)
 
 	pdContainer := corev1.Container{
 		Name:            v1alpha1.PDMemberType.String(),
 		Image:           tc.PDImage(),
 		ImagePullPolicy: basePDSpec.ImagePullPolicy(),
 		Command:         []string{"/bin/sh", "/usr/local/bin/pd_start_script.sh"},
+		SecurityContext: securityContext,
 		Ports: []corev1.ContainerPort{
 			{
 				Name:          "server",
-				ContainerPort: int32(2380),
+				ContainerPort: tc.Ports().PDPeer,
 				Protocol:      corev1.ProtocolTCP,
 			},
 			{
 				Name:          "client",
-				ContainerPort: int32(2379),
+				ContainerPort: tc.Ports().PDClient,
 				Protocol:      corev1.ProtocolTCP,
 			},
 		},
-		VolumeMounts: volMounts,
-		Resources:    controller.ContainerResource(tc.Spec.PD.ResourceRequirements),
+		VolumeMounts:   volMounts,
+		Resources:      controller.ContainerResource(tc.Spec.PD.ResourceRequirements),
+		ReadinessProbe: tlsClusterReadinessProbe(tc, tc.Ports().PDClient),
 	}
 	env := []corev1.EnvVar{
 		{
@@ -642,8 +687,10 @@ func getNewPDSetForTikvCluster(tc *v1alpha1.TikvCluster, cm *corev1.ConfigMap) (
 	}
 
 	podSpec := basePDSpec.BuildPodSpec()
+	podSpec.TopologySpreadConstraints = topologySpreadConstraints(basePDSpec.TopologySpreadConstraints(), pdLabel)
 	if basePDSpec.HostNetwork() {
 		podSpec.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+		podSpec.Affinity = hostNetworkAntiAffinity(podSpec.Affinity, pdLabel)
 		env = append(env, corev1.EnvVar{
 			Name: "POD_NAME",
 			ValueFrom: &corev1.EnvVarSource{
@@ -655,7 +702,11 @@ func getNewPDSetForTikvCluster(tc *v1alpha1.TikvCluster, cm *corev1.ConfigMap) (
 	}
 	pdContainer.Env = util.AppendEnv(env, basePDSpec.Env())
 	podSpec.Volumes = vols
-	podSpec.Containers = []corev1.Container{pdContainer}
+	podSpec.SecurityContext = basePDSpec.PodSecurityContext().DeepCopy()
+	if init := dataDirInitContainer(podSpec.SecurityContext, securityContext, v1alpha1.PDMemberType.String(), "/var/lib/pd"); init != nil {
+		podSpec.InitContainers = []corev1.Container{*init}
+	}
+	podSpec.Containers = append([]corev1.Container{pdContainer}, basePDSpec.AdditionalContainers()...)
 
 	pdSet := &apps.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
@@ -691,11 +742,7 @@ func getNewPDSetForTikvCluster(tc *v1alpha1.TikvCluster, cm *corev1.ConfigMap) (
 			},
 			ServiceName:         controller.PDPeerMemberName(tcName),
 			PodManagementPolicy: apps.ParallelPodManagement,
-			UpdateStrategy: apps.StatefulSetUpdateStrategy{
-				Type: apps.RollingUpdateStatefulSetStrategyType,
-				RollingUpdate: &apps.RollingUpdateStatefulSetStrategy{
-					Partition: controller.Int32Ptr(tc.Spec.PD.Replicas + int32(failureReplicas)),
-				}},
+			UpdateStrategy:      newStatefulSetUpdateStrategy(basePDSpec.StatefulSetUpdateStrategy(), tc.Spec.PD.Replicas+int32(failureReplicas)),
 		},
 	}
 
@@ -707,14 +754,34 @@ func getPDConfigMap(tc *v1alpha1.TikvCluster) (*corev1.ConfigMap, error) {
 	// For backward compatibility, only sync tidb configmap when .tidb.config is non-nil
 	config := tc.Spec.PD.Config
 	if config == nil {
-		return nil, nil
+		if !tc.IsTLSClusterEnabled() {
+			return nil, nil
+		}
+		config = &v1alpha1.PDConfig{}
+	}
+	if tc.IsTLSClusterEnabled() {
+		config = config.DeepCopy()
+		if config.Security == nil {
+			config.Security = &v1alpha1.PDSecurityConfig{}
+		}
+		config.Security.CAPath = pointer.StringPtr(path.Join(pdClusterCertPath, corev1.ServiceAccountRootCAKey))
+		config.Security.CertPath = pointer.StringPtr(path.Join(pdClusterCertPath, corev1.TLSCertKey))
+		config.Security.KeyPath = pointer.StringPtr(path.Join(pdClusterCertPath, corev1.TLSPrivateKeyKey))
+	}
+	// any pd member behind the dashboard service must serve the dashboard
+	if tc.PDDashboardExposed() && (config.Dashboard == nil || config.Dashboard.InternalProxy == nil) {
+		config = config.DeepCopy()
+		if config.Dashboard == nil {
+			config.Dashboard = &v1alpha1.DashboardConfig{}
+		}
+		config.Dashboard.InternalProxy = pointer.BoolPtr(true)
 	}
 
 	confText, err := MarshalTOML(config)
 	if err != nil {
 		return nil, err
 	}
-	startScript, err := RenderPDStartScript(&PDStartScriptModel{Scheme: tc.Scheme()})
+	startScript, err := RenderPDStartScript(&PDStartScriptModel{Scheme: tc.Scheme(), Ports: tc.Ports()})
 	if err != nil {
 		return nil, err
 	}
@@ -783,7 +850,7 @@ func (pmm *pdMemberManager) collectUnjoinedMembers(tc *v1alpha1.TikvCluster, set
 			tc.Status.PD.UnjoinedMembers[pod.Name] = v1alpha1.UnjoinedMember{
 				PodName:   pod.Name,
 				PVCUID:    pvc.UID,
-				CreatedAt: metav1.Now(),
+				CreatedAt: metav1.NewTime(pmm.clock.Now()),
 			}
 		} else {
 			if tc.Status.PD.UnjoinedMembers != nil {
@@ -798,7 +865,8 @@ func (pmm *pdMemberManager) collectUnjoinedMembers(tc *v1alpha1.TikvCluster, set
 }
 
 type FakePDMemberManager struct {
-	err error
+	err       error
+	statusErr error
 }
 
 func NewFakePDMemberManager() *FakePDMemberManager {
@@ -809,6 +877,18 @@ func (fpmm *FakePDMemberManager) SetSyncError(err error) {
 	fpmm.err = err
 }
 
+func (fpmm *FakePDMemberManager) SetSyncStatusError(err error) {
+	fpmm.statusErr = err
+}
+
+func (fpmm *FakePDMemberManager) SyncStatus(tc *v1alpha1.TikvCluster) error {
+	if fpmm.statusErr != nil {
+		return fpmm.statusErr
+	}
+	tc.Status.PD.Synced = true
+	return nil
+}
+
 func (fpmm *FakePDMemberManager) Sync(tc *v1alpha1.TikvCluster) error {
 	if fpmm.err != nil {
 		return fpmm.err
@@ -18,14 +18,14 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	. "github.com/onsi/gomega"
 	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
-	"github.com/tikv/tikv-operator/pkg/client/clientset/versioned/fake"
-	informers "github.com/tikv/tikv-operator/pkg/client/informers/externalversions"
 	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/deps"
 	"github.com/tikv/tikv-operator/pkg/label"
 	"github.com/tikv/tikv-operator/pkg/pdapi"
 	apps "k8s.io/api/apps/v1"
@@ -169,6 +169,18 @@ func TestPDMemberManagerSyncCreate(t *testing.T) {
 			pdPeerSvcCreated: false,
 			setCreated:       false,
 		},
+		{
+			name: "cluster is being deleted",
+			prepare: func(tc *v1alpha1.TikvCluster) {
+				tc.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+			},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(controller.IsIgnoreError(err)).To(BeTrue())
+			},
+			pdSvcCreated:     false,
+			pdPeerSvcCreated: false,
+			setCreated:       false,
+		},
 	}
 
 	for i := range tests {
@@ -200,6 +212,8 @@ func TestPDMemberManagerSyncUpdate(t *testing.T) {
 			name: "normal",
 			modify: func(tc *v1alpha1.TikvCluster) {
 				tc.Spec.PD.Replicas = 5
+				// left by an upgrade already over
+				tc.Status.PD.UpgradingOrdinal = controller.Int32Ptr(1)
 			},
 			pdHealth: &pdapi.HealthInfo{Healths: []pdapi.MemberHealth{
 				{Name: "pd1", MemberID: uint64(1), ClientUrls: []string{"http://pd1:2379"}, Health: true},
@@ -224,6 +238,9 @@ func TestPDMemberManagerSyncUpdate(t *testing.T) {
 				g.Expect(tc.Status.ClusterID).To(Equal("1"))
 				g.Expect(tc.Status.PD.Phase).To(Equal(v1alpha1.NormalPhase))
 				g.Expect(tc.Status.PD.StatefulSet.ObservedGeneration).To(Equal(int64(1)))
+				g.Expect(tc.Status.PD.CurrentRevision).To(Equal("pd-1"))
+				g.Expect(tc.Status.PD.UpdateRevision).To(Equal("pd-1"))
+				g.Expect(tc.Status.PD.UpgradingOrdinal).To(BeNil())
 				g.Expect(len(tc.Status.PD.Members)).To(Equal(3))
 				g.Expect(tc.Status.PD.Members["pd1"].Health).To(Equal(true))
 				g.Expect(tc.Status.PD.Members["pd2"].Health).To(Equal(true))
@@ -388,6 +405,68 @@ func TestPDMemberManagerSyncUpdate(t *testing.T) {
 	}
 }
 
+func TestPDMemberManagerSyncRecreateDeletedObjects(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name   string
+		delete func(*v1alpha1.TikvCluster, *controller.FakeServiceControl, *controller.FakeGenericControl)
+	}{
+		{
+			name: "pd service",
+			delete: func(tc *v1alpha1.TikvCluster, svcControl *controller.FakeServiceControl, _ *controller.FakeGenericControl) {
+				svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: controller.PDMemberName(tc.Name), Namespace: tc.Namespace}}
+				g.Expect(svcControl.SvcIndexer.Delete(svc)).To(Succeed())
+			},
+		},
+		{
+			name: "pd peer service",
+			delete: func(tc *v1alpha1.TikvCluster, svcControl *controller.FakeServiceControl, _ *controller.FakeGenericControl) {
+				svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: controller.PDPeerMemberName(tc.Name), Namespace: tc.Namespace}}
+				g.Expect(svcControl.SvcIndexer.Delete(svc)).To(Succeed())
+			},
+		},
+		{
+			name: "pd configmap",
+			delete: func(_ *v1alpha1.TikvCluster, _ *controller.FakeServiceControl, genericControl *controller.FakeGenericControl) {
+				cmList := &corev1.ConfigMapList{}
+				g.Expect(genericControl.FakeCli.List(context.TODO(), cmList)).To(Succeed())
+				g.Expect(cmList.Items).To(HaveLen(1))
+				g.Expect(genericControl.FakeCli.Delete(context.TODO(), &cmList.Items[0])).To(Succeed())
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvClusterForPD()
+			tc.Spec.PD.Config = &v1alpha1.PDConfig{}
+			ns := tc.Namespace
+			tcName := tc.Name
+
+			pmm, _, fakeSvcControl, fakePDControl, _, _, _ := newFakePDMemberManager()
+			controller.NewFakePDClient(fakePDControl, tc)
+			genericControl := controller.NewFakeGenericControl()
+			pmm.typedControl = controller.NewTypedControl(genericControl)
+
+			err := pmm.Sync(tc)
+			g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+
+			tt.delete(tc, fakeSvcControl, genericControl)
+
+			// the result does not matter, the deleted object must be recreated by a single sync
+			_ = pmm.Sync(tc)
+
+			_, err = pmm.svcLister.Services(ns).Get(controller.PDMemberName(tcName))
+			g.Expect(err).NotTo(HaveOccurred())
+			_, err = pmm.svcLister.Services(ns).Get(controller.PDPeerMemberName(tcName))
+			g.Expect(err).NotTo(HaveOccurred())
+			cmList := &corev1.ConfigMapList{}
+			g.Expect(genericControl.FakeCli.List(context.TODO(), cmList)).To(Succeed())
+			g.Expect(cmList.Items).To(HaveLen(1))
+		})
+	}
+}
+
 func TestPDMemberManagerPdStatefulSetIsUpgrading(t *testing.T) {
 	g := NewGomegaWithT(t)
 	type testcase struct {
@@ -622,7 +701,8 @@ func TestPDMemberManagerSyncPDSts(t *testing.T) {
 				g.Expect(err).NotTo(HaveOccurred())
 				g.Expect(set.Spec.Template.Spec.Containers[0].Image).To(Equal("pd-test-image:v2"))
 				g.Expect(*set.Spec.Replicas).To(Equal(int32(1)))
-				g.Expect(*set.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(0)))
+				// the pods are rolled in order by the upgrader once the new template is applied
+				g.Expect(*set.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(1)))
 			},
 			expectTikvClusterFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster) {
 				g.Expect(tc.Status.PD.Phase).To(Equal(v1alpha1.UpgradePhase))
@@ -709,40 +789,14 @@ func TestPDMemberManagerSyncPDSts(t *testing.T) {
 }
 
 func newFakePDMemberManager() (*pdMemberManager, *controller.FakeStatefulSetControl, *controller.FakeServiceControl, *pdapi.FakePDControl, cache.Indexer, cache.Indexer, *controller.FakePodControl) {
-	cli := fake.NewSimpleClientset()
-	kubeCli := kubefake.NewSimpleClientset()
-	setInformer := kubeinformers.NewSharedInformerFactory(kubeCli, 0).Apps().V1().StatefulSets()
-	svcInformer := kubeinformers.NewSharedInformerFactory(kubeCli, 0).Core().V1().Services()
-	podInformer := kubeinformers.NewSharedInformerFactory(kubeCli, 0).Core().V1().Pods()
-	epsInformer := kubeinformers.NewSharedInformerFactory(kubeCli, 0).Core().V1().Endpoints()
-	pvcInformer := kubeinformers.NewSharedInformerFactory(kubeCli, 0).Core().V1().PersistentVolumeClaims()
-	tcInformer := informers.NewSharedInformerFactory(cli, 0).Tikv().V1alpha1().TikvClusters()
-	setControl := controller.NewFakeStatefulSetControl(setInformer, tcInformer)
-	svcControl := controller.NewFakeServiceControl(svcInformer, epsInformer, tcInformer)
-	podControl := controller.NewFakePodControl(podInformer)
-	pdControl := pdapi.NewFakePDControl(kubeCli)
-	pdScaler := NewFakePDScaler()
+	d := deps.NewFakeDependencies()
+	podInformer := d.KubeInformerFactory.Core().V1().Pods()
+	pvcInformer := d.KubeInformerFactory.Core().V1().PersistentVolumeClaims()
 	autoFailover := true
-	pdFailover := NewFakePDFailover()
-	pdUpgrader := NewFakePDUpgrader()
-	genericControll := controller.NewFakeGenericControl()
-
-	return &pdMemberManager{
-		pdControl,
-		setControl,
-		svcControl,
-		podControl,
-		controller.NewTypedControl(genericControll),
-		setInformer.Lister(),
-		svcInformer.Lister(),
-		podInformer.Lister(),
-		epsInformer.Lister(),
-		pvcInformer.Lister(),
-		pdScaler,
-		pdUpgrader,
-		autoFailover,
-		pdFailover,
-	}, setControl, svcControl, pdControl, podInformer.Informer().GetIndexer(), pvcInformer.Informer().GetIndexer(), podControl
+	pmm := NewPDMemberManager(d, NewFakePDScaler(), NewFakePDUpgrader(), autoFailover, NewFakePDFailover(), controller.NewInformerSyncStatus())
+
+	return pmm.(*pdMemberManager), d.StatefulSetControl.(*controller.FakeStatefulSetControl), d.ServiceControl.(*controller.FakeServiceControl),
+		d.PDControl.(*pdapi.FakePDControl), podInformer.Informer().GetIndexer(), pvcInformer.Informer().GetIndexer(), d.PodControl.(*controller.FakePodControl)
 }
 
 func newTikvClusterForPD() *v1alpha1.TikvCluster {
@@ -873,6 +927,18 @@ func testHostNetwork(t *testing.T, hostNetwork bool, dnsPolicy v1.DNSPolicy) fun
 		if dnsPolicy != sts.Spec.Template.Spec.DNSPolicy {
 			t.Errorf("unexpected dnsPolicy %v, want %v", sts.Spec.Template.Spec.DNSPolicy, dnsPolicy)
 		}
+		// the pods in the host network are never scheduled to the same node
+		var terms []corev1.PodAffinityTerm
+		if hostNetwork {
+			terms = []corev1.PodAffinityTerm{{LabelSelector: sts.Spec.Selector, TopologyKey: corev1.LabelHostname}}
+		}
+		var got []corev1.PodAffinityTerm
+		if affinity := sts.Spec.Template.Spec.Affinity; affinity != nil && affinity.PodAntiAffinity != nil {
+			got = affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+		}
+		if diff := cmp.Diff(terms, got); diff != "" {
+			t.Errorf("unexpected required pod anti-affinity (-want, +got): %s", diff)
+		}
 	}
 }
 
@@ -1156,6 +1222,56 @@ func TestGetPDConfigMap(t *testing.T) {
 [replication]
   max-replicas = 5
   location-labels = ["node", "rack"]
+`,
+				},
+			},
+		},
+		{
+			name: "dashboard exposed",
+			tc: v1alpha1.TikvCluster{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "foo",
+					Namespace: "ns",
+				},
+				Spec: v1alpha1.TikvClusterSpec{
+					PD: v1alpha1.PDSpec{
+						ComponentSpec: v1alpha1.ComponentSpec{
+							ConfigUpdateStrategy: &updateStrategy,
+						},
+						Config:    &v1alpha1.PDConfig{},
+						Dashboard: &v1alpha1.PDDashboardSpec{Expose: true},
+					},
+				},
+			},
+			expected: &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "foo-pd",
+					Namespace: "ns",
+					Labels: map[string]string{
+						"app.kubernetes.io/name":       "tikv-cluster",
+						"app.kubernetes.io/managed-by": "tikv-operator",
+						"app.kubernetes.io/instance":   "foo",
+						"app.kubernetes.io/component":  "pd",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							APIVersion: "tikv.org/v1alpha1",
+							Kind:       "TikvCluster",
+							Name:       "foo",
+							UID:        "",
+							Controller: func(b bool) *bool {
+								return &b
+							}(true),
+							BlockOwnerDeletion: func(b bool) *bool {
+								return &b
+							}(true),
+						},
+					},
+				},
+				Data: map[string]string{
+					"startup-script": "",
+					"config-file": `[dashboard]
+  internal-proxy = true
 `,
 				},
 			},
@@ -1170,6 +1286,8 @@ func TestGetPDConfigMap(t *testing.T) {
 				g.Expect(cm).To(BeNil())
 				return
 			}
+			// the config of the cluster is left untouched
+			g.Expect(tt.tc.Spec.PD.Config.Dashboard).To(BeNil())
 			// startup-script is better to be tested in e2e
 			cm.Data["startup-script"] = ""
 			if diff := cmp.Diff(*tt.expected, *cm); diff != "" {
@@ -1179,6 +1297,60 @@ func TestGetPDConfigMap(t *testing.T) {
 	}
 }
 
+func TestGetPDConfigMapTLSCluster(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForPD()
+	tc.Spec.PD.Config = nil
+	tc.Spec.TLSCluster = &v1alpha1.TLSCluster{Enabled: true}
+	cm, err := getPDConfigMap(tc)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(cm).NotTo(BeNil())
+
+	config := &v1alpha1.PDConfig{}
+	g.Expect(UnmarshalTOML([]byte(cm.Data["config-file"]), config)).To(Succeed())
+	g.Expect(config.Security).To(Equal(&v1alpha1.PDSecurityConfig{
+		CAPath:   pointer.StringPtr("/var/lib/pd-tls/ca.crt"),
+		CertPath: pointer.StringPtr("/var/lib/pd-tls/tls.crt"),
+		KeyPath:  pointer.StringPtr("/var/lib/pd-tls/tls.key"),
+	}))
+	g.Expect(cm.Data["startup-script"]).To(ContainSubstring("--peer-urls=https://0.0.0.0:2380"))
+	g.Expect(tc.Spec.PD.Config).To(BeNil())
+}
+
+func TestGetNewPDSetForTikvClusterTLS(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForPD()
+	sts, err := getNewPDSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(sts.Spec.Template.Spec.Containers[0].ReadinessProbe).To(BeNil())
+
+	tc.Spec.TLSCluster = &v1alpha1.TLSCluster{Enabled: true}
+	sts, err = getNewPDSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	podSpec := sts.Spec.Template.Spec
+	g.Expect(podSpec.Volumes).To(ContainElement(corev1.Volume{
+		Name: "pd-tls",
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{{
+					Secret: &corev1.SecretProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "test-pd-cluster-secret"}},
+				}},
+			},
+		},
+	}))
+	g.Expect(podSpec.Containers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "pd-tls", ReadOnly: true, MountPath: "/var/lib/pd-tls"}))
+	g.Expect(podSpec.Containers[0].ReadinessProbe.TCPSocket.Port).To(Equal(intstr.FromInt(2379)))
+	g.Expect(sts.Spec.Template.Annotations).NotTo(HaveKey(label.AnnTLSCertHash))
+
+	// the members not reloading the rotated certificates online are restarted to load them
+	tc.Status.PD.TLSCertRolloutHash = "b5a1"
+	sts, err = getNewPDSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(sts.Spec.Template.Annotations).To(HaveKeyWithValue(label.AnnTLSCertHash, "b5a1"))
+}
+
 func TestGetNewPdServiceForTikvCluster(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -20,10 +20,10 @@ import (
 	"github.com/pingcap/advanced-statefulset/client/apis/apps/v1/helper"
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
 	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/deps"
 	"github.com/tikv/tikv-operator/pkg/label"
 	"github.com/tikv/tikv-operator/pkg/pdapi"
 	apps "k8s.io/api/apps/v1"
-	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/klog"
 )
 
@@ -34,14 +34,16 @@ type pdScaler struct {
 }
 
 // NewPDScaler returns a Scaler
-func NewPDScaler(pdControl pdapi.PDControlInterface,
-	pvcLister corelisters.PersistentVolumeClaimLister,
-	pvcControl controller.PVCControlInterface) Scaler {
-	return &pdScaler{generalScaler{pdControl, pvcLister, pvcControl}}
+func NewPDScaler(deps *deps.Dependencies) Scaler {
+	return &pdScaler{generalScaler{deps.PDControl, deps.PVCLister, deps.PVCControl, deps.Clock}}
 }
 
 func (psd *pdScaler) Scale(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulSet, newSet *apps.StatefulSet) error {
 	scaling, _, _, _ := scaleOne(oldSet, newSet)
+	if scaling >= 0 {
+		// the scale in is done or cancelled
+		tc.Status.PD.ScaleIn = nil
+	}
 	if scaling > 0 {
 		return psd.ScaleOut(tc, oldSet, newSet)
 	} else if scaling < 0 {
@@ -93,15 +95,21 @@ func (psd *pdScaler) ScaleOut(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulSet
 }
 
 // We need remove member from cluster before reducing statefulset replicas
-// only remove one member at a time when scale down
+// only remove one member at a time when scale down, the statefulset is shrunk
+// by one once the member has left the membership
 func (psd *pdScaler) ScaleIn(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulSet, newSet *apps.StatefulSet) error {
 	ns := tc.GetNamespace()
 	tcName := tc.GetName()
 	_, ordinal, replicas, deleteSlots := scaleOne(oldSet, newSet)
+	targetReplicas := *newSet.Spec.Replicas
 	resetReplicas(newSet, oldSet)
 	memberName := fmt.Sprintf("%s-pd-%d", tc.GetName(), ordinal)
 	setName := oldSet.GetName()
 
+	if err := checkClusterDeleting(tc, "scaling in pd"); err != nil {
+		return err
+	}
+
 	if tc.PDUpgrading() {
 		return nil
 	}
@@ -110,32 +118,60 @@ func (psd *pdScaler) ScaleIn(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulSet,
 		return fmt.Errorf("TikvCluster: %s/%s's pd status sync failed,can't scale in now", ns, tcName)
 	}
 
+	// the members left must keep the quorum of the target size
+	healthCount := 0
+	for name, member := range tc.Status.PD.Members {
+		if name != memberName && member.Health {
+			healthCount++
+		}
+	}
+	if quorum := int(targetReplicas)/2 + 1; targetReplicas > 0 && healthCount < quorum {
+		return fmt.Errorf("TikvCluster: %s/%s's pd has %d healthy members besides %s, less than the quorum %d of %d replicas, can't scale in now",
+			ns, tcName, healthCount, memberName, quorum, targetReplicas)
+	}
+
 	klog.Infof("scaling in pd statefulset %s/%s, ordinal: %d (replicas: %d, delete slots: %v)", oldSet.Namespace, oldSet.Name, ordinal, replicas, deleteSlots.List())
 
 	pdClient := controller.GetPDClient(psd.pdControl, tc)
-	// If the pd pod was pd leader during scale-in, we would transfer pd leader to pd-0 directly
-	// If the pd statefulSet would be scale-in to zero and the pd-0 was going to be deleted,
-	// we would directly deleted the pd-0 without pd leader transferring
-	if ordinal > 0 {
-		leader, err := pdClient.GetPDLeader()
-		if err != nil {
-			return err
-		}
-		if leader.Name == memberName {
-			err = pdClient.TransferPDLeader(fmt.Sprintf("%s-pd-%d", tc.GetName(), 0))
+	removed, err := psd.memberRemoved(pdClient, memberName)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		// If the pd pod was pd leader during scale-in, we would transfer pd leader to pd-0 directly
+		// If the pd statefulSet would be scale-in to zero and the pd-0 was going to be deleted,
+		// we would directly deleted the pd-0 without pd leader transferring
+		if ordinal > 0 {
+			leader, err := pdClient.GetPDLeader()
 			if err != nil {
 				return err
 			}
-			return controller.RequeueErrorf("tc[%s/%s]'s pd pod[%s/%s] is transferring pd leader,can't scale-in now", ns, tcName, ns, memberName)
+			if leader.Name == memberName {
+				setPDScaleInStatus(tc, targetReplicas, memberName, v1alpha1.PDScaleInPhaseTransferLeader)
+				err = pdClient.TransferPDLeader(fmt.Sprintf("%s-pd-%d", tc.GetName(), 0))
+				if err != nil {
+					return err
+				}
+				return controller.RequeueErrorf("tc[%s/%s]'s pd pod[%s/%s] is transferring pd leader,can't scale-in now", ns, tcName, ns, memberName)
+			}
 		}
-	}
 
-	err := pdClient.DeleteMember(memberName)
-	if err != nil {
-		klog.Errorf("pd scale in: failed to delete member %s, %v", memberName, err)
-		return err
+		setPDScaleInStatus(tc, targetReplicas, memberName, v1alpha1.PDScaleInPhaseDeleteMember)
+		err := pdClient.DeleteMember(memberName)
+		if err != nil {
+			klog.Errorf("pd scale in: failed to delete member %s, %v", memberName, err)
+			return err
+		}
+		klog.Infof("pd scale in: delete member %s successfully", memberName)
+
+		removed, err = psd.memberRemoved(pdClient, memberName)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			return controller.RequeueErrorf("tc[%s/%s]'s pd member %s is deleted, waiting for it to leave the membership", ns, tcName, memberName)
+		}
 	}
-	klog.Infof("pd scale in: delete member %s successfully", memberName)
 
 	pvcName := ordinalPVCName(v1alpha1.PDMemberType, setName, ordinal)
 	pvc, err := psd.pvcLister.PersistentVolumeClaims(ns).Get(pvcName)
@@ -146,7 +182,7 @@ func (psd *pdScaler) ScaleIn(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulSet,
 	if pvc.Annotations == nil {
 		pvc.Annotations = map[string]string{}
 	}
-	now := time.Now().Format(time.RFC3339)
+	now := psd.clock.Now().Format(time.RFC3339)
 	pvc.Annotations[label.AnnPVCDeferDeleting] = now
 
 	_, err = psd.pvcControl.UpdatePVC(tc, pvc)
@@ -158,10 +194,33 @@ func (psd *pdScaler) ScaleIn(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulSet,
 	klog.Infof("pd scale in: set pvc %s/%s annotation: %s to %s",
 		ns, pvcName, label.AnnPVCDeferDeleting, now)
 
+	setPDScaleInStatus(tc, targetReplicas, memberName, v1alpha1.PDScaleInPhaseMemberRemoved)
 	setReplicasAndDeleteSlots(newSet, replicas, deleteSlots)
 	return nil
 }
 
+// memberRemoved asks pd whether the member has left the membership
+func (psd *pdScaler) memberRemoved(pdClient pdapi.PDClient, memberName string) (bool, error) {
+	members, err := pdClient.GetMembers(pdapi.NoCache)
+	if err != nil {
+		return false, err
+	}
+	for _, member := range members.Members {
+		if member.GetName() == memberName {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func setPDScaleInStatus(tc *v1alpha1.TikvCluster, targetReplicas int32, memberName string, phase v1alpha1.PDScaleInPhase) {
+	tc.Status.PD.ScaleIn = &v1alpha1.PDScaleInStatus{
+		TargetReplicas: targetReplicas,
+		Member:         memberName,
+		Phase:          phase,
+	}
+}
+
 func (psd *pdScaler) SyncAutoScalerAnn(tc *v1alpha1.TikvCluster, actual *apps.StatefulSet) error {
 	return nil
 }
@@ -22,14 +22,13 @@ import (
 	"github.com/pingcap/kvproto/pkg/pdpb"
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
 	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/deps"
 	"github.com/tikv/tikv-operator/pkg/label"
 	"github.com/tikv/tikv-operator/pkg/pdapi"
 	apps "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	kubeinformers "k8s.io/client-go/informers"
-	kubefake "k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/tools/cache"
 )
 
@@ -239,18 +238,29 @@ func TestPDScalerScaleIn(t *testing.T) {
 		pvcUpdateErr     bool
 		deleteMemberErr  bool
 		statusSyncFailed bool
+		unhealthyMembers int
+		memberNotRemoved bool
+		deleting         bool
 		err              bool
 		changed          bool
 		isLeader         bool
+		expectPhase      v1alpha1.PDScaleInPhase
 	}
 
 	testFn := func(test *testcase, t *testing.T) {
 		t.Log(test.name)
 		tc := newTikvClusterForPD()
+		normalPDMember(tc)
+		for i := 0; i < test.unhealthyMembers; i++ {
+			tc.Status.PD.Members[ordinalPodName(v1alpha1.PDMemberType, tc.GetName(), int32(i))] = v1alpha1.PDMember{Health: false}
+		}
 
 		if test.pdUpgrading {
 			tc.Status.PD.Phase = v1alpha1.UpgradePhase
 		}
+		if test.deleting {
+			tc.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+		}
 
 		oldSet := newStatefulSetForPDScale()
 		newSet := oldSet.DeepCopy()
@@ -272,11 +282,27 @@ func TestPDScalerScaleIn(t *testing.T) {
 			return &leader, nil
 		})
 
+		deleted := false
 		if test.deleteMemberErr {
 			pdClient.AddReaction(pdapi.DeleteMemberActionType, func(action *pdapi.Action) (interface{}, error) {
 				return nil, fmt.Errorf("error")
 			})
+		} else {
+			pdClient.AddReaction(pdapi.DeleteMemberActionType, func(action *pdapi.Action) (interface{}, error) {
+				deleted = !test.memberNotRemoved
+				return nil, nil
+			})
 		}
+		pdClient.AddReaction(pdapi.GetMembersActionType, func(action *pdapi.Action) (interface{}, error) {
+			members := &pdapi.MembersInfo{}
+			for i := 0; i < 5; i++ {
+				if i == 4 && deleted {
+					continue
+				}
+				members.Members = append(members.Members, &pdpb.Member{Name: fmt.Sprintf("%s-pd-%d", tc.GetName(), i)})
+			}
+			return members, nil
+		})
 		if test.pvcUpdateErr {
 			pvcControl.SetUpdatePVCError(errors.NewInternalError(fmt.Errorf("API server failed")), 0)
 		}
@@ -296,7 +322,10 @@ func TestPDScalerScaleIn(t *testing.T) {
 		tc.Status.PD.Synced = !test.statusSyncFailed
 
 		err := scaler.ScaleIn(tc, oldSet, newSet)
-		if test.err {
+		if test.deleting {
+			g.Expect(controller.IsIgnoreError(err)).To(BeTrue())
+			g.Expect(deleted).To(BeFalse())
+		} else if test.err {
 			g.Expect(err).To(HaveOccurred())
 		} else {
 			g.Expect(err).NotTo(HaveOccurred())
@@ -306,6 +335,11 @@ func TestPDScalerScaleIn(t *testing.T) {
 		} else {
 			g.Expect(int(*newSet.Spec.Replicas)).To(Equal(5))
 		}
+		if test.expectPhase != "" {
+			g.Expect(tc.Status.PD.ScaleIn).NotTo(BeNil())
+			g.Expect(tc.Status.PD.ScaleIn.Phase).To(Equal(test.expectPhase))
+			g.Expect(tc.Status.PD.ScaleIn.TargetReplicas).To(Equal(int32(3)))
+		}
 	}
 
 	tests := []testcase{
@@ -319,6 +353,7 @@ func TestPDScalerScaleIn(t *testing.T) {
 			err:              false,
 			changed:          true,
 			isLeader:         false,
+			expectPhase:      v1alpha1.PDScaleInPhaseMemberRemoved,
 		},
 		{
 			name:             "pd is upgrading",
@@ -364,6 +399,37 @@ func TestPDScalerScaleIn(t *testing.T) {
 			changed:          false,
 			isLeader:         false,
 		},
+		{
+			name:             "waiting for the member to leave the membership",
+			hasPVC:           true,
+			memberNotRemoved: true,
+			err:              true,
+			changed:          false,
+			expectPhase:      v1alpha1.PDScaleInPhaseDeleteMember,
+		},
+		{
+			name:        "transfer leader before deleting the member",
+			hasPVC:      true,
+			isLeader:    true,
+			err:         true,
+			changed:     false,
+			expectPhase: v1alpha1.PDScaleInPhaseTransferLeader,
+		},
+		{
+			name:             "healthy members would be less than the quorum of the target size",
+			hasPVC:           true,
+			unhealthyMembers: 3,
+			err:              true,
+			changed:          false,
+		},
+		{
+			name:             "unhealthy members that keep the quorum of the target size",
+			hasPVC:           true,
+			unhealthyMembers: 2,
+			err:              false,
+			changed:          true,
+			expectPhase:      v1alpha1.PDScaleInPhaseMemberRemoved,
+		},
 		{
 			name:             "pd status sync failed",
 			pdUpgrading:      false,
@@ -375,6 +441,12 @@ func TestPDScalerScaleIn(t *testing.T) {
 			changed:          false,
 			isLeader:         false,
 		},
+		{
+			name:     "cluster is being deleted",
+			hasPVC:   true,
+			deleting: true,
+			changed:  false,
+		},
 	}
 
 	for i := range tests {
@@ -383,15 +455,11 @@ func TestPDScalerScaleIn(t *testing.T) {
 }
 
 func newFakePDScaler() (*pdScaler, *pdapi.FakePDControl, cache.Indexer, *controller.FakePVCControl) {
-	kubeCli := kubefake.NewSimpleClientset()
-
-	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeCli, 0)
-	pvcInformer := kubeInformerFactory.Core().V1().PersistentVolumeClaims()
-	pdControl := pdapi.NewFakePDControl(kubeCli)
-	pvcControl := controller.NewFakePVCControl(pvcInformer)
+	d := deps.NewFakeDependencies()
+	pvcInformer := d.KubeInformerFactory.Core().V1().PersistentVolumeClaims()
 
-	return &pdScaler{generalScaler{pdControl, pvcInformer.Lister(), pvcControl}},
-		pdControl, pvcInformer.Informer().GetIndexer(), pvcControl
+	return NewPDScaler(d).(*pdScaler),
+		d.PDControl.(*pdapi.FakePDControl), pvcInformer.Informer().GetIndexer(), d.PVCControl.(*controller.FakePVCControl)
 }
 
 func newStatefulSetForPDScale() *apps.StatefulSet {
@@ -15,30 +15,36 @@ package member
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/pingcap/advanced-statefulset/client/apis/apps/v1/helper"
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
 	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/deps"
 	"github.com/tikv/tikv-operator/pkg/pdapi"
 	apps "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
 )
 
 type pdUpgrader struct {
-	pdControl  pdapi.PDControlInterface
-	podControl controller.PodControlInterface
-	podLister  corelisters.PodLister
+	pdControl   pdapi.PDControlInterface
+	podControl  controller.PodControlInterface
+	podLister   corelisters.PodLister
+	recorder    record.EventRecorder
+	dnsVerifier *podDNSVerifier
 }
 
 // NewPDUpgrader returns a pdUpgrader
-func NewPDUpgrader(pdControl pdapi.PDControlInterface,
-	podControl controller.PodControlInterface,
-	podLister corelisters.PodLister) Upgrader {
+func NewPDUpgrader(deps *deps.Dependencies) Upgrader {
 	return &pdUpgrader{
-		pdControl:  pdControl,
-		podControl: podControl,
-		podLister:  podLister,
+		pdControl:   deps.PDControl,
+		podControl:  deps.PodControl,
+		podLister:   deps.PodLister,
+		recorder:    deps.Recorder,
+		dnsVerifier: newPodDNSVerifier(deps.Recorder),
 	}
 }
 
@@ -49,7 +55,12 @@ func (pu *pdUpgrader) Upgrade(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulSet
 func (pu *pdUpgrader) gracefulUpgrade(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulSet, newSet *apps.StatefulSet) error {
 	ns := tc.GetNamespace()
 	tcName := tc.GetName()
-	if !tc.Status.PD.Synced {
+	if err := checkClusterDeleting(tc, "upgrading pd"); err != nil {
+		return err
+	}
+	// the forced upgrade goes on even if pd is down and its status can not be synced
+	force := forceUpgradeAllowed(tc)
+	if !tc.Status.PD.Synced && !force {
 		return fmt.Errorf("tidbcluster: [%s/%s]'s pd status sync failed,can not to be upgraded", ns, tcName)
 	}
 
@@ -58,11 +69,20 @@ func (pu *pdUpgrader) gracefulUpgrade(tc *v1alpha1.TikvCluster, oldSet *apps.Sta
 		return nil
 	}
 
+	if newSet.Spec.UpdateStrategy.Type == apps.OnDeleteStatefulSetStrategyType {
+		// the pods are upgraded once they are deleted by the users
+		return nil
+	}
+	if onDeleteStrategyApplied(oldSet) {
+		// switching back to RollingUpdate, the upgrade goes on once the partition is applied
+		return nil
+	}
+
 	if tc.Status.PD.StatefulSet.UpdateRevision == tc.Status.PD.StatefulSet.CurrentRevision {
 		return nil
 	}
 
-	if oldSet.Spec.UpdateStrategy.Type == apps.OnDeleteStatefulSetStrategyType || oldSet.Spec.UpdateStrategy.RollingUpdate == nil {
+	if !controller.IsManagedUpgrade(oldSet) {
 		// Manually bypass tikv-operator to modify statefulset directly, such as modify pd statefulset's RollingUpdate straregy to OnDelete strategy,
 		// or set RollingUpdate to nil, skip tikv-operator's rolling update logic in order to speed up the upgrade in the test environment occasionally.
 		// If we encounter this situation, we will let the native statefulset controller do the upgrade completely, which may be unsafe for upgrading pd.
@@ -74,8 +94,15 @@ func (pu *pdUpgrader) gracefulUpgrade(tc *v1alpha1.TikvCluster, oldSet *apps.Sta
 
 	setUpgradePartition(newSet, *oldSet.Spec.UpdateStrategy.RollingUpdate.Partition)
 	podOrdinals := helper.GetPodOrdinals(*oldSet.Spec.Replicas, oldSet).List()
+	// healthy members already running the new revision, pd leader is transferred to one of them
+	var upgraded []string
+	// the member upgraded last and when it became healthy
+	var lastUpgraded *corev1.Pod
+	var lastUpgradedTime time.Time
 	for _i := len(podOrdinals) - 1; _i >= 0; _i-- {
 		i := podOrdinals[_i]
+		// the pods are processed in descending ordinal, the one returned at is being upgraded
+		tc.Status.PD.UpgradingOrdinal = controller.Int32Ptr(i)
 		podName := PdPodName(tcName, i)
 		pod, err := pu.podLister.Pods(ns).Get(podName)
 		if err != nil {
@@ -88,36 +115,68 @@ func (pu *pdUpgrader) gracefulUpgrade(tc *v1alpha1.TikvCluster, oldSet *apps.Sta
 		}
 
 		if revision == tc.Status.PD.StatefulSet.UpdateRevision {
-			if member, exist := tc.Status.PD.Members[podName]; !exist || !member.Health {
+			if force {
+				// the health of pd is not checked by the forced upgrade, just wait for the pod to run
+				if pod.Status.Phase != corev1.PodRunning {
+					return controller.RequeueErrorf("tidbcluster: [%s/%s]'s pd upgraded pod: [%s] is not running", ns, tcName, podName)
+				}
+				continue
+			}
+			member, exist := tc.Status.PD.Members[podName]
+			if !exist || !member.Health {
 				return controller.RequeueErrorf("tidbcluster: [%s/%s]'s pd upgraded pod: [%s] is not ready", ns, tcName, podName)
 			}
+			recordPodStartup(tc, v1alpha1.PDMemberType, revision, pod, member.LastTransitionTime.Time)
+			upgraded = append(upgraded, podName)
+			lastUpgraded, lastUpgradedTime = pod, member.LastTransitionTime.Time
 			continue
 		}
 
-		return pu.upgradePDPod(tc, i, newSet)
+		if force {
+			pu.recorder.Event(tc, corev1.EventTypeWarning, "ForceUpgrade", fmt.Sprintf("force upgrade pd pod %s without checking the health of pd or transferring the pd leader", podName))
+			setUpgradePartition(newSet, i)
+			return nil
+		}
+		// the next member is not upgraded until the FQDN of the member upgraded last resolves to its new IP
+		if lastUpgraded != nil {
+			if err := pu.dnsVerifier.verify(tc, v1alpha1.PDMemberType, lastUpgraded, lastUpgradedTime); err != nil {
+				return err
+			}
+		}
+		return pu.upgradePDPod(tc, i, newSet, upgraded)
 	}
 
+	tc.Status.PD.UpgradingOrdinal = nil
 	return nil
 }
 
-func (pu *pdUpgrader) upgradePDPod(tc *v1alpha1.TikvCluster, ordinal int32, newSet *apps.StatefulSet) error {
+func (pu *pdUpgrader) upgradePDPod(tc *v1alpha1.TikvCluster, ordinal int32, newSet *apps.StatefulSet, upgraded []string) error {
 	ns := tc.GetNamespace()
 	tcName := tc.GetName()
 	upgradePodName := PdPodName(tcName, ordinal)
-	if tc.Status.PD.Leader.Name == upgradePodName && tc.PDStsActualReplicas() > 1 {
-		lastOrdinal := tc.PDStsActualReplicas() - 1
-		var targetName string
-		if ordinal == lastOrdinal {
-			targetName = PdPodName(tcName, 0)
-		} else {
-			targetName = PdPodName(tcName, lastOrdinal)
-		}
+	if tc.PDStsActualReplicas() <= 1 {
+		klog.Warningf("pd upgrader: tidbcluster: [%s/%s] has only one pd member, upgrade pd member: [%s] without transferring leader", ns, tcName, upgradePodName)
+		setUpgradePartition(newSet, ordinal)
+		return nil
+	}
+
+	// the leader in status may be stale, ask pd for the current one
+	members, err := controller.GetPDClient(pu.pdControl, tc).GetMembers(pdapi.NoCache)
+	if err != nil {
+		return err
+	}
+	if members.Leader == nil {
+		return controller.RequeueErrorf("tidbcluster: [%s/%s]'s pd cluster has no leader", ns, tcName)
+	}
+	if members.Leader.GetName() == upgradePodName {
+		targetName := pu.chooseTransferTarget(tc, ordinal, upgraded)
 		err := pu.transferPDLeaderTo(tc, targetName)
 		if err != nil {
 			klog.Errorf("pd upgrader: failed to transfer pd leader to: %s, %v", targetName, err)
 			return err
 		}
 		klog.Infof("pd upgrader: transfer pd leader to: %s successfully", targetName)
+		// the pod is upgraded by a later sync once pd reports the new leader
 		return controller.RequeueErrorf("tidbcluster: [%s/%s]'s pd member: [%s] is transferring leader to pd member: [%s]", ns, tcName, upgradePodName, targetName)
 	}
 
@@ -125,6 +184,20 @@ func (pu *pdUpgrader) upgradePDPod(tc *v1alpha1.TikvCluster, ordinal int32, newS
 	return nil
 }
 
+// chooseTransferTarget prefers a member already running the new revision so
+// that the leader is not moved again when that member is upgraded.
+func (pu *pdUpgrader) chooseTransferTarget(tc *v1alpha1.TikvCluster, ordinal int32, upgraded []string) string {
+	tcName := tc.GetName()
+	if len(upgraded) > 0 {
+		return upgraded[0]
+	}
+	lastOrdinal := tc.PDStsActualReplicas() - 1
+	if ordinal == lastOrdinal {
+		return PdPodName(tcName, 0)
+	}
+	return PdPodName(tcName, lastOrdinal)
+}
+
 func (pu *pdUpgrader) transferPDLeaderTo(tc *v1alpha1.TikvCluster, targetName string) error {
 	return controller.GetPDClient(pu.pdControl, tc).TransferPDLeader(targetName)
 }
@@ -137,7 +210,7 @@ func NewFakePDUpgrader() Upgrader {
 }
 
 func (fpu *fakePDUpgrader) Upgrade(tc *v1alpha1.TikvCluster, _ *apps.StatefulSet, _ *apps.StatefulSet) error {
-	if !tc.Status.PD.Synced {
+	if !tc.Status.PD.Synced && !forceUpgradeAllowed(tc) {
 		return fmt.Errorf("tidbcluster: pd status sync failed,can not to be upgraded")
 	}
 	tc.Status.PD.Phase = v1alpha1.UpgradePhase
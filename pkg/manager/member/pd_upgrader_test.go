@@ -16,19 +16,20 @@ package member
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	. "github.com/onsi/gomega"
+	"github.com/pingcap/kvproto/pkg/pdpb"
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
 	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/deps"
 	"github.com/tikv/tikv-operator/pkg/label"
 	"github.com/tikv/tikv-operator/pkg/pdapi"
 	apps "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
-	kubeinformers "k8s.io/client-go/informers"
 	podinformers "k8s.io/client-go/informers/core/v1"
-	kubefake "k8s.io/client-go/kubernetes/fake"
 	"k8s.io/utils/pointer"
 )
 
@@ -40,7 +41,12 @@ func TestPDUpgraderUpgrade(t *testing.T) {
 		changeFn          func(*v1alpha1.TikvCluster)
 		changePods        func(pods []*corev1.Pod)
 		changeOldSet      func(set *apps.StatefulSet)
+		modifyOldSet      func(set *apps.StatefulSet)
+		changeNewSet      func(set *apps.StatefulSet)
 		transferLeaderErr bool
+		getMembersErr     bool
+		pdLeader          string
+		transferTo        string
 		errExpectFn       func(*GomegaWithT, error)
 		expectFn          func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet)
 	}
@@ -55,16 +61,32 @@ func TestPDUpgraderUpgrade(t *testing.T) {
 			test.changeFn(tc)
 		}
 
+		var transferTo string
 		if test.transferLeaderErr {
 			pdClient.AddReaction(pdapi.TransferPDLeaderActionType, func(action *pdapi.Action) (interface{}, error) {
 				return nil, fmt.Errorf("failed to transfer leader")
 			})
 		} else {
 			pdClient.AddReaction(pdapi.TransferPDLeaderActionType, func(action *pdapi.Action) (interface{}, error) {
+				transferTo = action.Name
 				return nil, nil
 			})
 		}
 
+		if test.getMembersErr {
+			pdClient.AddReaction(pdapi.GetMembersActionType, func(action *pdapi.Action) (interface{}, error) {
+				return nil, fmt.Errorf("failed to get members")
+			})
+		} else {
+			pdLeader := test.pdLeader
+			if pdLeader == "" {
+				pdLeader = tc.Status.PD.Leader.Name
+			}
+			pdClient.AddReaction(pdapi.GetMembersActionType, func(action *pdapi.Action) (interface{}, error) {
+				return &pdapi.MembersInfo{Leader: &pdpb.Member{Name: pdLeader}}, nil
+			})
+		}
+
 		pods := getPods()
 		if test.changePods != nil {
 			test.changePods(pods)
@@ -79,12 +101,20 @@ func TestPDUpgraderUpgrade(t *testing.T) {
 			test.changeOldSet(oldSet)
 		}
 		SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+		// modified bypassing tikv-operator after the config is applied
+		if test.modifyOldSet != nil {
+			test.modifyOldSet(oldSet)
+		}
 
 		newSet.Spec.UpdateStrategy.RollingUpdate.Partition = controller.Int32Ptr(3)
+		if test.changeNewSet != nil {
+			test.changeNewSet(newSet)
+		}
 
 		err := upgrader.Upgrade(tc, oldSet, newSet)
 		test.errExpectFn(g, err)
 		test.expectFn(g, tc, newSet)
+		g.Expect(transferTo).To(Equal(test.transferTo))
 	}
 
 	tests := []testcase{
@@ -101,16 +131,30 @@ func TestPDUpgraderUpgrade(t *testing.T) {
 			},
 			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet) {
 				g.Expect(tc.Status.PD.Phase).To(Equal(v1alpha1.UpgradePhase))
+				g.Expect(tc.Status.PD.UpgradingOrdinal).To(Equal(controller.Int32Ptr(1)))
 				g.Expect(newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(controller.Int32Ptr(1)))
 			},
 		},
+		{
+			name: "cluster is being deleted",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Synced = true
+				tc.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+			},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(controller.IsIgnoreError(err)).To(BeTrue())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet) {
+				g.Expect(newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(controller.Int32Ptr(3)))
+			},
+		},
 		{
 			name: "modify oldSet update strategy to OnDelete",
 			changeFn: func(tc *v1alpha1.TikvCluster) {
 				tc.Status.PD.Synced = true
 			},
 			changePods: nil,
-			changeOldSet: func(set *apps.StatefulSet) {
+			modifyOldSet: func(set *apps.StatefulSet) {
 				set.Spec.UpdateStrategy = apps.StatefulSetUpdateStrategy{
 					Type: apps.OnDeleteStatefulSetStrategyType,
 				}
@@ -124,13 +168,48 @@ func TestPDUpgraderUpgrade(t *testing.T) {
 				g.Expect(newSet.Spec.UpdateStrategy).To(Equal(apps.StatefulSetUpdateStrategy{Type: apps.OnDeleteStatefulSetStrategyType}))
 			},
 		},
+		{
+			name: "OnDelete strategy leaves the pods to be deleted by the users",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Synced = true
+			},
+			changeOldSet: func(set *apps.StatefulSet) {
+				set.Spec.UpdateStrategy = apps.StatefulSetUpdateStrategy{Type: apps.OnDeleteStatefulSetStrategyType}
+			},
+			changeNewSet: func(set *apps.StatefulSet) {
+				set.Spec.UpdateStrategy = apps.StatefulSetUpdateStrategy{Type: apps.OnDeleteStatefulSetStrategyType}
+			},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet) {
+				g.Expect(tc.Status.PD.Phase).To(Equal(v1alpha1.UpgradePhase))
+				g.Expect(newSet.Spec.UpdateStrategy).To(Equal(apps.StatefulSetUpdateStrategy{Type: apps.OnDeleteStatefulSetStrategyType}))
+			},
+		},
+		{
+			name: "switching from OnDelete to RollingUpdate applies the partition before upgrading the pods",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Synced = true
+			},
+			changeOldSet: func(set *apps.StatefulSet) {
+				set.Spec.UpdateStrategy = apps.StatefulSetUpdateStrategy{Type: apps.OnDeleteStatefulSetStrategyType}
+			},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet) {
+				g.Expect(tc.Status.PD.Phase).To(Equal(v1alpha1.UpgradePhase))
+				g.Expect(newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(controller.Int32Ptr(3)))
+			},
+		},
 		{
 			name: "set oldSet's RollingUpdate strategy to nil",
 			changeFn: func(tc *v1alpha1.TikvCluster) {
 				tc.Status.PD.Synced = true
 			},
 			changePods: nil,
-			changeOldSet: func(set *apps.StatefulSet) {
+			modifyOldSet: func(set *apps.StatefulSet) {
 				set.Spec.UpdateStrategy = apps.StatefulSetUpdateStrategy{
 					Type: apps.RollingUpdateStatefulSetStrategyType,
 				}
@@ -202,14 +281,89 @@ func TestPDUpgraderUpgrade(t *testing.T) {
 			},
 			changePods:        nil,
 			transferLeaderErr: false,
+			transferTo:        PdPodName(upgradeTcName, 2),
 			errExpectFn: func(g *GomegaWithT, err error) {
 				g.Expect(err).To(HaveOccurred())
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
 			},
 			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet) {
 				g.Expect(tc.Status.PD.Phase).To(Equal(v1alpha1.UpgradePhase))
 				g.Expect(newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(controller.Int32Ptr(2)))
 			},
 		},
+		{
+			name: "transfer leader when no member is upgraded",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Synced = true
+			},
+			changePods: func(pods []*corev1.Pod) {
+				pods[2].Labels[apps.ControllerRevisionHashLabelKey] = "1"
+			},
+			transferLeaderErr: false,
+			transferTo:        PdPodName(upgradeTcName, 0),
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet) {
+				g.Expect(tc.Status.PD.Phase).To(Equal(v1alpha1.UpgradePhase))
+				g.Expect(newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(controller.Int32Ptr(2)))
+			},
+		},
+		{
+			name: "leader in status is stale",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Synced = true
+				tc.Status.PD.Leader = v1alpha1.PDMember{Name: PdPodName(upgradeTcName, 1), Health: true}
+			},
+			changePods:        nil,
+			transferLeaderErr: false,
+			pdLeader:          PdPodName(upgradeTcName, 2),
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet) {
+				g.Expect(tc.Status.PD.Phase).To(Equal(v1alpha1.UpgradePhase))
+				g.Expect(newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(controller.Int32Ptr(1)))
+			},
+		},
+		{
+			name: "error when get members",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Synced = true
+			},
+			changePods:        nil,
+			transferLeaderErr: false,
+			getMembersErr:     true,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).To(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet) {
+				g.Expect(tc.Status.PD.Phase).To(Equal(v1alpha1.UpgradePhase))
+				g.Expect(newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(controller.Int32Ptr(2)))
+			},
+		},
+		{
+			name: "skip transferring leader with one pd member",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Synced = true
+				tc.Status.PD.StatefulSet.Replicas = 1
+				tc.Status.PD.Leader = v1alpha1.PDMember{Name: PdPodName(upgradeTcName, 0), Health: true}
+			},
+			changePods: nil,
+			changeOldSet: func(set *apps.StatefulSet) {
+				set.Spec.Replicas = controller.Int32Ptr(1)
+				set.Spec.UpdateStrategy.RollingUpdate.Partition = controller.Int32Ptr(1)
+			},
+			transferLeaderErr: false,
+			getMembersErr:     true,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet) {
+				g.Expect(tc.Status.PD.Phase).To(Equal(v1alpha1.UpgradePhase))
+				g.Expect(newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(controller.Int32Ptr(0)))
+			},
+		},
 		{
 			name: "pd sync failed",
 			changeFn: func(tc *v1alpha1.TikvCluster) {
@@ -225,6 +379,62 @@ func TestPDUpgraderUpgrade(t *testing.T) {
 				g.Expect(newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(controller.Int32Ptr(3)))
 			},
 		},
+		{
+			name: "force upgrade when pd sync failed",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Synced = false
+				tc.Status.PD.Leader = v1alpha1.PDMember{Name: PdPodName(upgradeTcName, 1), Health: true}
+				tc.Annotations = map[string]string{label.AnnForceUpgradeKey: label.AnnForceUpgradeVal}
+			},
+			changePods: func(pods []*corev1.Pod) {
+				pods[2].Status.Phase = corev1.PodRunning
+			},
+			transferLeaderErr: false,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet) {
+				g.Expect(tc.Status.PD.Phase).To(Equal(v1alpha1.UpgradePhase))
+				g.Expect(newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(controller.Int32Ptr(1)))
+			},
+		},
+		{
+			name: "force upgrade waits for the upgraded pod to run",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Synced = false
+				tc.Annotations = map[string]string{label.AnnForceUpgradeKey: label.AnnForceUpgradeVal}
+			},
+			changePods:        nil,
+			transferLeaderErr: false,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet) {
+				g.Expect(tc.Status.PD.Phase).To(Equal(v1alpha1.UpgradePhase))
+				g.Expect(newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(controller.Int32Ptr(3)))
+			},
+		},
+		{
+			name: "force upgrade is ignored for healthy clusters",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Synced = true
+				tc.Status.TiKV.Synced = true
+				tc.Status.PD.Leader = v1alpha1.PDMember{Name: PdPodName(upgradeTcName, 1), Health: true}
+				tc.Annotations = map[string]string{label.AnnForceUpgradeKey: label.AnnForceUpgradeVal}
+			},
+			changePods:        nil,
+			transferLeaderErr: false,
+			transferTo:        PdPodName(upgradeTcName, 2),
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet) {
+				g.Expect(tc.Status.PD.Phase).To(Equal(v1alpha1.UpgradePhase))
+				g.Expect(newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(controller.Int32Ptr(2)))
+			},
+		},
 		{
 			name: "error when transfer leader",
 			changeFn: func(tc *v1alpha1.TikvCluster) {
@@ -250,15 +460,12 @@ func TestPDUpgraderUpgrade(t *testing.T) {
 }
 
 func newPDUpgrader() (Upgrader, *pdapi.FakePDControl, *controller.FakePodControl, podinformers.PodInformer) {
-	kubeCli := kubefake.NewSimpleClientset()
-	podInformer := kubeinformers.NewSharedInformerFactory(kubeCli, 0).Core().V1().Pods()
-	pdControl := pdapi.NewFakePDControl(kubeCli)
-	podControl := controller.NewFakePodControl(podInformer)
-	return &pdUpgrader{
-			pdControl:  pdControl,
-			podControl: podControl,
-			podLister:  podInformer.Lister()},
-		pdControl, podControl, podInformer
+	d := deps.NewFakeDependencies()
+	upgrader := NewPDUpgrader(d).(*pdUpgrader)
+	// the pods of the tests do not resolve
+	upgrader.dnsVerifier = nil
+	return upgrader, d.PDControl.(*pdapi.FakePDControl), d.PodControl.(*controller.FakePodControl),
+		d.KubeInformerFactory.Core().V1().Pods()
 }
 
 func newStatefulSetForPDUpgrader() *apps.StatefulSet {
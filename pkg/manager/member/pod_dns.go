@@ -0,0 +1,69 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// DNSPropagationTimeout is how long the FQDN of a pod restarted by the operator may resolve to
+// another address than the pod IP before the delay is reported
+const DNSPropagationTimeout = time.Minute
+
+// podDNSVerifier verifies that the FQDN a restarted pod advertises resolves to its new IP, so
+// that the next pod is not restarted while pd may still reach the previous one at a stale
+// address, e.g. in the network setups caching negative DNS responses
+type podDNSVerifier struct {
+	lookupHost func(host string) ([]string, error)
+	recorder   record.EventRecorder
+}
+
+func newPodDNSVerifier(recorder record.EventRecorder) *podDNSVerifier {
+	return &podDNSVerifier{lookupHost: net.LookupHost, recorder: recorder}
+}
+
+// verify returns a RequeueError holding the next restart if the FQDN of the pod ready since the
+// given time does not resolve to the pod IP, the delay is reported by a DNSPropagationDelayed
+// event once it lasts longer than DNSPropagationTimeout. A nil verifier verifies nothing.
+func (v *podDNSVerifier) verify(tc *v1alpha1.TikvCluster, memberType v1alpha1.MemberType, pod *corev1.Pod, since time.Time) error {
+	if v == nil || pod.Status.PodIP == "" {
+		return nil
+	}
+	fqdn := fmt.Sprintf("%s.%s.%s.svc", pod.GetName(), controller.PeerMemberName(tc.GetName(), memberType), pod.GetNamespace())
+	addrs, err := v.lookupHost(fqdn)
+	if err == nil {
+		for _, addr := range addrs {
+			if addr == pod.Status.PodIP {
+				return nil
+			}
+		}
+	}
+
+	resolved := fmt.Sprintf("%v", addrs)
+	if err != nil {
+		resolved = err.Error()
+	}
+	msg := fmt.Sprintf("%s pod %s: %s does not resolve to the pod IP %s yet: %s", memberType, pod.GetName(), fqdn, pod.Status.PodIP, resolved)
+	if elapsed := time.Since(since); elapsed > DNSPropagationTimeout {
+		v.recorder.Event(tc, corev1.EventTypeWarning, "DNSPropagationDelayed", fmt.Sprintf("%s after %s, the next pod is not restarted", msg, elapsed.Round(time.Second)))
+	}
+	return controller.RequeueErrorf("tidbcluster: [%s/%s]'s %s", tc.GetNamespace(), tc.GetName(), msg)
+}
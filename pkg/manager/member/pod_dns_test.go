@@ -0,0 +1,73 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestPodDNSVerifierVerify(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForPD()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: TikvPodName(tc.GetName(), 1), Namespace: tc.GetNamespace()},
+		Status:     corev1.PodStatus{PodIP: "10.0.0.2"},
+	}
+	for _, tt := range []struct {
+		name          string
+		addrs         []string
+		err           error
+		since         time.Duration
+		expectHold    bool
+		expectedEvent bool
+	}{
+		{"resolved to the pod IP", []string{"10.0.0.2"}, nil, 0, false, false},
+		{"stale IP cached", []string{"10.0.0.1"}, nil, 10 * time.Second, true, false},
+		{"negative response cached", nil, fmt.Errorf("no such host"), 10 * time.Second, true, false},
+		{"stale IP beyond the timeout", []string{"10.0.0.1"}, nil, 2 * DNSPropagationTimeout, true, true},
+	} {
+		recorder := record.NewFakeRecorder(10)
+		var looked string
+		v := &podDNSVerifier{
+			lookupHost: func(host string) ([]string, error) {
+				looked = host
+				return tt.addrs, tt.err
+			},
+			recorder: recorder,
+		}
+		err := v.verify(tc, v1alpha1.TiKVMemberType, pod, time.Now().Add(-tt.since))
+		g.Expect(looked).To(Equal("test-tikv-1.test-tikv-peer.default.svc"), tt.name)
+		g.Expect(controller.IsRequeueError(err)).To(Equal(tt.expectHold), tt.name)
+		if tt.expectedEvent {
+			g.Expect(recorder.Events).To(HaveLen(1), tt.name)
+			g.Expect(<-recorder.Events).To(ContainSubstring("DNSPropagationDelayed"), tt.name)
+		} else {
+			g.Expect(recorder.Events).To(BeEmpty(), tt.name)
+		}
+	}
+
+	// a nil verifier verifies nothing
+	var v *podDNSVerifier
+	g.Expect(v.verify(tc, v1alpha1.TiKVMemberType, pod, time.Now())).To(Succeed())
+}
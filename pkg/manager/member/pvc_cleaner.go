@@ -0,0 +1,152 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"time"
+
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/label"
+	"k8s.io/apimachinery/pkg/api/errors"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog"
+)
+
+const (
+	skipReasonPVCCleanerRetentionNotSet      = "pvc cleaner: retention period is not set"
+	skipReasonPVCCleanerIsNotDeferDeleting   = "pvc cleaner: pvc is not defer deleting"
+	skipReasonPVCCleanerDeferDeletingInvalid = "pvc cleaner: pvc defer deleting annotation is invalid"
+	skipReasonPVCCleanerRetentionNotExpired  = "pvc cleaner: retention period has not expired"
+	skipReasonPVCCleanerPodExists            = "pvc cleaner: pod of the pvc exists"
+	skipReasonPVCCleanerPVCHasBeenDeleted    = "pvc cleaner: pvc has been deleted"
+	skipReasonPVCCleanerRetentionInvalid     = "pvc cleaner: retention period is invalid"
+)
+
+// PVCCleaner implements the logic for deleting the PVCs of scaled in TiKV members
+//
+// When a TiKV member is scaled in, its PVC is annotated with the defer deleting
+// timestamp instead of being deleted, scaling out the same ordinal again deletes
+// the annotated PVC so that the new store never bootstraps from stale data.
+// The PVCs of members which are not scaled out again are deleted once they have
+// been retained longer than .tikv.scaleInPVCRetentionPeriod.
+type PVCCleaner interface {
+	Clean(*v1alpha1.TikvCluster) (map[string]string, error)
+}
+
+type pvcCleaner struct {
+	podLister  corelisters.PodLister
+	pvcControl controller.PVCControlInterface
+	pvcLister  corelisters.PersistentVolumeClaimLister
+}
+
+// NewPVCCleaner returns a PVCCleaner
+func NewPVCCleaner(podLister corelisters.PodLister,
+	pvcControl controller.PVCControlInterface,
+	pvcLister corelisters.PersistentVolumeClaimLister) PVCCleaner {
+	return &pvcCleaner{podLister, pvcControl, pvcLister}
+}
+
+func (pc *pvcCleaner) Clean(tc *v1alpha1.TikvCluster) (map[string]string, error) {
+	ns := tc.GetNamespace()
+	// for unit test
+	skipReason := map[string]string{}
+
+	retention := tc.Spec.TiKV.ScaleInPVCRetentionPeriod
+	if retention == nil {
+		skipReason[tc.GetName()] = skipReasonPVCCleanerRetentionNotSet
+		return skipReason, nil
+	}
+	period, err := time.ParseDuration(*retention)
+	if err != nil {
+		// guaranteed by validation
+		klog.Errorf("pvc cleaner: cluster %s/%s has invalid scaleInPVCRetentionPeriod %q: %v", ns, tc.GetName(), *retention, err)
+		skipReason[tc.GetName()] = skipReasonPVCCleanerRetentionInvalid
+		return skipReason, nil
+	}
+
+	selector, err := controller.MemberSelector(tc, v1alpha1.TiKVMemberType)
+	if err != nil {
+		return skipReason, err
+	}
+	pvcs, err := pc.pvcLister.PersistentVolumeClaims(ns).List(selector)
+	if err != nil {
+		return skipReason, err
+	}
+
+	for _, pvc := range pvcs {
+		pvcName := pvc.GetName()
+		if pvc.DeletionTimestamp != nil {
+			skipReason[pvcName] = skipReasonPVCCleanerPVCHasBeenDeleted
+			continue
+		}
+		deferDeleting, ok := pvc.Annotations[label.AnnPVCDeferDeleting]
+		if !ok {
+			skipReason[pvcName] = skipReasonPVCCleanerIsNotDeferDeleting
+			continue
+		}
+		deferDeletingTime, err := time.Parse(time.RFC3339, deferDeleting)
+		if err != nil {
+			klog.Warningf("pvc cleaner: pvc %s/%s has invalid annotation %s: %s", ns, pvcName, label.AnnPVCDeferDeleting, deferDeleting)
+			skipReason[pvcName] = skipReasonPVCCleanerDeferDeletingInvalid
+			continue
+		}
+		if time.Now().Before(deferDeletingTime.Add(period)) {
+			skipReason[pvcName] = skipReasonPVCCleanerRetentionNotExpired
+			continue
+		}
+
+		// the member has been scaled out again but the pvc is not deleted yet,
+		// leave it to the scaler
+		if podName := pvc.Labels[label.AnnPodNameKey]; podName != "" {
+			_, err := pc.podLister.Pods(ns).Get(podName)
+			if err == nil {
+				skipReason[pvcName] = skipReasonPVCCleanerPodExists
+				continue
+			}
+			if !errors.IsNotFound(err) {
+				return skipReason, err
+			}
+		}
+
+		if err := pc.pvcControl.DeletePVC(tc, pvc); err != nil {
+			klog.Errorf("pvc cleaner: failed to delete pvc %s/%s, %v", ns, pvcName, err)
+			return skipReason, err
+		}
+		klog.Infof("pvc cleaner: delete pvc %s/%s retained since %s successfully", ns, pvcName, deferDeleting)
+	}
+
+	return skipReason, nil
+}
+
+var _ PVCCleaner = &pvcCleaner{}
+
+type FakePVCCleaner struct {
+	err error
+}
+
+// NewFakePVCCleaner returns a fake pvc cleaner
+func NewFakePVCCleaner() *FakePVCCleaner {
+	return &FakePVCCleaner{}
+}
+
+func (fpc *FakePVCCleaner) SetPVCCleanerError(err error) {
+	fpc.err = err
+}
+
+func (fpc *FakePVCCleaner) Clean(_ *v1alpha1.TikvCluster) (map[string]string, error) {
+	return nil, fpc.err
+}
+
+var _ PVCCleaner = &FakePVCCleaner{}
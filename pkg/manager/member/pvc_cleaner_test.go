@@ -0,0 +1,175 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/label"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/pointer"
+)
+
+func TestPVCCleanerClean(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForPD()
+	newPVC := func(name string, deferDeleting *string) *corev1.PersistentVolumeClaim {
+		l := label.New().Instance(tc.GetInstanceName()).TiKV()
+		l[label.AnnPodNameKey] = name
+		pvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "tikv-" + name,
+				Namespace: metav1.NamespaceDefault,
+				Labels:    l.Labels(),
+			},
+		}
+		if deferDeleting != nil {
+			pvc.Annotations = map[string]string{label.AnnPVCDeferDeleting: *deferDeleting}
+		}
+		return pvc
+	}
+	expired := time.Now().Add(-2 * time.Hour).Format(time.RFC3339)
+	notExpired := time.Now().Add(-30 * time.Minute).Format(time.RFC3339)
+
+	type testcase struct {
+		name            string
+		retention       *string
+		pods            []*corev1.Pod
+		pvcs            []*corev1.PersistentVolumeClaim
+		deletePVCFailed bool
+		expectFn        func(*GomegaWithT, map[string]string, cache.Indexer, error)
+	}
+
+	tests := []testcase{
+		{
+			name:      "retention period is not set",
+			retention: nil,
+			pvcs:      []*corev1.PersistentVolumeClaim{newPVC("pod-1", &expired)},
+			expectFn: func(g *GomegaWithT, skipReason map[string]string, pvcIndexer cache.Indexer, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(skipReason[tc.GetName()]).To(Equal(skipReasonPVCCleanerRetentionNotSet))
+				g.Expect(pvcIndexer.List()).To(HaveLen(1))
+			},
+		},
+		{
+			name:      "pvc is not defer deleting",
+			retention: pointer.StringPtr("1h"),
+			pvcs:      []*corev1.PersistentVolumeClaim{newPVC("pod-1", nil)},
+			expectFn: func(g *GomegaWithT, skipReason map[string]string, pvcIndexer cache.Indexer, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(skipReason["tikv-pod-1"]).To(Equal(skipReasonPVCCleanerIsNotDeferDeleting))
+				g.Expect(pvcIndexer.List()).To(HaveLen(1))
+			},
+		},
+		{
+			name:      "defer deleting annotation is invalid",
+			retention: pointer.StringPtr("1h"),
+			pvcs:      []*corev1.PersistentVolumeClaim{newPVC("pod-1", pointer.StringPtr("yesterday"))},
+			expectFn: func(g *GomegaWithT, skipReason map[string]string, pvcIndexer cache.Indexer, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(skipReason["tikv-pod-1"]).To(Equal(skipReasonPVCCleanerDeferDeletingInvalid))
+				g.Expect(pvcIndexer.List()).To(HaveLen(1))
+			},
+		},
+		{
+			name:      "retention period has not expired",
+			retention: pointer.StringPtr("1h"),
+			pvcs:      []*corev1.PersistentVolumeClaim{newPVC("pod-1", &notExpired)},
+			expectFn: func(g *GomegaWithT, skipReason map[string]string, pvcIndexer cache.Indexer, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(skipReason["tikv-pod-1"]).To(Equal(skipReasonPVCCleanerRetentionNotExpired))
+				g.Expect(pvcIndexer.List()).To(HaveLen(1))
+			},
+		},
+		{
+			name:      "pod of the pvc exists",
+			retention: pointer.StringPtr("1h"),
+			pods: []*corev1.Pod{
+				{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: metav1.NamespaceDefault}},
+			},
+			pvcs: []*corev1.PersistentVolumeClaim{newPVC("pod-1", &expired)},
+			expectFn: func(g *GomegaWithT, skipReason map[string]string, pvcIndexer cache.Indexer, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(skipReason["tikv-pod-1"]).To(Equal(skipReasonPVCCleanerPodExists))
+				g.Expect(pvcIndexer.List()).To(HaveLen(1))
+			},
+		},
+		{
+			name:      "retention period has expired",
+			retention: pointer.StringPtr("1h"),
+			pvcs: []*corev1.PersistentVolumeClaim{
+				newPVC("pod-1", &expired),
+				newPVC("pod-2", &notExpired),
+				newPVC("pod-3", nil),
+			},
+			expectFn: func(g *GomegaWithT, skipReason map[string]string, pvcIndexer cache.Indexer, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(skipReason).To(HaveLen(2))
+				_, exist, _ := pvcIndexer.GetByKey(metav1.NamespaceDefault + "/tikv-pod-1")
+				g.Expect(exist).To(BeFalse())
+				g.Expect(pvcIndexer.List()).To(HaveLen(2))
+			},
+		},
+		{
+			name:            "delete pvc failed",
+			retention:       pointer.StringPtr("1h"),
+			pvcs:            []*corev1.PersistentVolumeClaim{newPVC("pod-1", &expired)},
+			deletePVCFailed: true,
+			expectFn: func(g *GomegaWithT, skipReason map[string]string, pvcIndexer cache.Indexer, err error) {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring("delete pvc failed"))
+				g.Expect(pvcIndexer.List()).To(HaveLen(1))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc.Spec.TiKV.ScaleInPVCRetentionPeriod = tt.retention
+			pc, podIndexer, pvcIndexer, pvcControl := newFakePVCCleaner()
+			for _, pod := range tt.pods {
+				podIndexer.Add(pod)
+			}
+			for _, pvc := range tt.pvcs {
+				pvcIndexer.Add(pvc)
+			}
+			if tt.deletePVCFailed {
+				pvcControl.SetDeletePVCError(fmt.Errorf("delete pvc failed"), 0)
+			}
+
+			skipReason, err := pc.Clean(tc)
+			tt.expectFn(g, skipReason, pvcIndexer, err)
+		})
+	}
+}
+
+func newFakePVCCleaner() (*pvcCleaner, cache.Indexer, cache.Indexer, *controller.FakePVCControl) {
+	kubeCli := kubefake.NewSimpleClientset()
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeCli, 0)
+	podInformer := kubeInformerFactory.Core().V1().Pods()
+	pvcInformer := kubeInformerFactory.Core().V1().PersistentVolumeClaims()
+	pvcControl := controller.NewFakePVCControl(pvcInformer)
+
+	return &pvcCleaner{podInformer.Lister(), pvcControl, pvcInformer.Lister()},
+		podInformer.Informer().GetIndexer(), pvcInformer.Informer().GetIndexer(), pvcControl
+}
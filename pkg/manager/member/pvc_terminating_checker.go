@@ -0,0 +1,167 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	utiltikvcluster "github.com/tikv/tikv-operator/pkg/util/tikvcluster"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+// pvcProtectionFinalizer is the finalizer kubernetes keeps on a PVC as long as a pod uses it
+const pvcProtectionFinalizer = "kubernetes.io/pvc-protection"
+
+// TerminatingPVCChecker implements the logic for the PVCs still terminating when the pods of the
+// same ordinals are to be created
+//
+// When failover or the pvc cleaner deletes a PVC whose volume is slow to detach, the StatefulSet
+// creates the new pod of the same ordinal which finds the old PVC terminating and stays Pending.
+// The checker waits for such PVCs with a requeue, and reports the PVCs terminating longer than
+// controller.PVCTerminatingTimeout in the PVCStuckTerminating condition, naming the finalizers
+// holding them. With controller.RemoveStuckPVCProtection, the pvc-protection finalizer of a stuck
+// PVC is removed once no pod uses it.
+type TerminatingPVCChecker interface {
+	Check(*v1alpha1.TikvCluster) error
+}
+
+type terminatingPVCChecker struct {
+	podLister  corelisters.PodLister
+	pvcControl controller.PVCControlInterface
+	pvcLister  corelisters.PersistentVolumeClaimLister
+	recorder   record.EventRecorder
+}
+
+// NewTerminatingPVCChecker returns a TerminatingPVCChecker
+func NewTerminatingPVCChecker(podLister corelisters.PodLister,
+	pvcControl controller.PVCControlInterface,
+	pvcLister corelisters.PersistentVolumeClaimLister,
+	recorder record.EventRecorder) TerminatingPVCChecker {
+	return &terminatingPVCChecker{podLister, pvcControl, pvcLister, recorder}
+}
+
+func (c *terminatingPVCChecker) Check(tc *v1alpha1.TikvCluster) error {
+	ns := tc.GetNamespace()
+	tcName := tc.GetName()
+
+	var waiting []string
+	var wait time.Duration
+	var stuck []string
+	for _, member := range []struct {
+		memberType v1alpha1.MemberType
+		setName    string
+		ordinals   sets.Int32
+	}{
+		{v1alpha1.PDMemberType, controller.PDMemberName(tcName), tc.PDStsDesiredOrdinals(false)},
+		{v1alpha1.TiKVMemberType, controller.TiKVMemberName(tcName), tc.TiKVStsDesiredOrdinals(false)},
+	} {
+		for _, ordinal := range member.ordinals.List() {
+			pvcName := ordinalPVCName(member.memberType, member.setName, ordinal)
+			pvc, err := c.pvcLister.PersistentVolumeClaims(ns).Get(pvcName)
+			if errors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if pvc.DeletionTimestamp == nil {
+				continue
+			}
+			podName := ordinalPodName(member.memberType, tcName, ordinal)
+			pod, err := c.podLister.Pods(ns).Get(podName)
+			if err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			if err == nil && pod.DeletionTimestamp == nil && pod.Status.Phase == corev1.PodRunning {
+				// the pod is not to be created, it still runs on the pvc
+				continue
+			}
+
+			if remaining := controller.PVCTerminatingTimeout - time.Since(pvc.DeletionTimestamp.Time); remaining > 0 {
+				waiting = append(waiting, pvcName)
+				if wait == 0 || remaining < wait {
+					wait = remaining
+				}
+				continue
+			}
+
+			finalizers := append([]string(nil), pvc.Finalizers...)
+			sort.Strings(finalizers)
+			stuck = append(stuck, fmt.Sprintf("%s (finalizers: %s)", pvcName, strings.Join(finalizers, ", ")))
+			if controller.RemoveStuckPVCProtection && !podUsesPVC(pod) && sets.NewString(finalizers...).Has(pvcProtectionFinalizer) {
+				if err := c.pvcControl.RemovePVCFinalizer(tc, pvc, pvcProtectionFinalizer); err != nil {
+					return err
+				}
+				klog.Infof("tikv cluster %s/%s: removed finalizer %s of pvc %s stuck terminating", ns, tcName, pvcProtectionFinalizer, pvcName)
+			}
+		}
+	}
+
+	if len(stuck) > 0 {
+		msg := fmt.Sprintf("pvcs terminating for more than %s: %s", controller.PVCTerminatingTimeout, strings.Join(stuck, "; "))
+		if cond := utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.PVCStuckTerminating); cond == nil || cond.Status != corev1.ConditionTrue {
+			c.recorder.Event(tc, corev1.EventTypeWarning, "PVCStuckTerminating", msg)
+		}
+		cond := utiltikvcluster.NewTikvClusterCondition(v1alpha1.PVCStuckTerminating, corev1.ConditionTrue, utiltikvcluster.PVCTerminating, msg)
+		utiltikvcluster.SetTikvClusterCondition(&tc.Status, *cond)
+	} else if cond := utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.PVCStuckTerminating); cond != nil && cond.Status == corev1.ConditionTrue {
+		cond = utiltikvcluster.NewTikvClusterCondition(v1alpha1.PVCStuckTerminating, corev1.ConditionFalse, utiltikvcluster.PVCNotTerminating, "")
+		utiltikvcluster.SetTikvClusterCondition(&tc.Status, *cond)
+	}
+
+	if len(waiting) > 0 {
+		return controller.RequeueErrorAfter(wait, "tikvcluster: [%s/%s]'s pods are waiting for the terminating pvcs %v to be deleted", ns, tcName, waiting)
+	}
+	return nil
+}
+
+// podUsesPVC returns whether the pod may still use its volumes, a pod not scheduled yet or
+// terminated does not keep the pvc from being deleted
+func podUsesPVC(pod *corev1.Pod) bool {
+	if pod == nil || pod.Spec.NodeName == "" {
+		return false
+	}
+	return pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed
+}
+
+var _ TerminatingPVCChecker = &terminatingPVCChecker{}
+
+type FakeTerminatingPVCChecker struct {
+	err error
+}
+
+// NewFakeTerminatingPVCChecker returns a fake terminating pvc checker
+func NewFakeTerminatingPVCChecker() *FakeTerminatingPVCChecker {
+	return &FakeTerminatingPVCChecker{}
+}
+
+func (fc *FakeTerminatingPVCChecker) SetCheckError(err error) {
+	fc.err = err
+}
+
+func (fc *FakeTerminatingPVCChecker) Check(_ *v1alpha1.TikvCluster) error {
+	return fc.err
+}
+
+var _ TerminatingPVCChecker = &FakeTerminatingPVCChecker{}
@@ -0,0 +1,174 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	utiltikvcluster "github.com/tikv/tikv-operator/pkg/util/tikvcluster"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestTerminatingPVCCheckerCheck(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	newPVC := func(terminatingFor time.Duration) *corev1.PersistentVolumeClaim {
+		pvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       ordinalPVCName(v1alpha1.TiKVMemberType, controller.TiKVMemberName("test"), 1),
+				Namespace:  metav1.NamespaceDefault,
+				Finalizers: []string{pvcProtectionFinalizer, "example.com/backup"},
+			},
+		}
+		if terminatingFor > 0 {
+			deleted := metav1.NewTime(time.Now().Add(-terminatingFor))
+			pvc.DeletionTimestamp = &deleted
+		}
+		return pvc
+	}
+	newPod := func(nodeName string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ordinalPodName(v1alpha1.TiKVMemberType, "test", 1),
+				Namespace: metav1.NamespaceDefault,
+			},
+			Spec:   corev1.PodSpec{NodeName: nodeName},
+			Status: corev1.PodStatus{Phase: phase},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		pvc          *corev1.PersistentVolumeClaim
+		pod          *corev1.Pod
+		removeStuck  bool
+		expectErr    bool
+		expectStuck  bool
+		expectRemove bool
+	}{
+		{
+			name: "pvc not terminating",
+			pvc:  newPVC(0),
+		},
+		{
+			name: "pod running on the terminating pvc",
+			pvc:  newPVC(time.Hour),
+			pod:  newPod("node-1", corev1.PodRunning),
+		},
+		{
+			name:      "wait for the terminating pvc",
+			pvc:       newPVC(time.Minute),
+			pod:       newPod("", corev1.PodPending),
+			expectErr: true,
+		},
+		{
+			name:        "pvc stuck terminating",
+			pvc:         newPVC(time.Hour),
+			pod:         newPod("", corev1.PodPending),
+			expectStuck: true,
+		},
+		{
+			name:         "remove the finalizer of the pvc stuck terminating",
+			pvc:          newPVC(time.Hour),
+			removeStuck:  true,
+			expectStuck:  true,
+			expectRemove: true,
+		},
+		{
+			name:        "keep the finalizer of the pvc used by a pod",
+			pvc:         newPVC(time.Hour),
+			pod:         newPod("node-1", corev1.PodPending),
+			removeStuck: true,
+			expectStuck: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(removeStuck bool) {
+				controller.RemoveStuckPVCProtection = removeStuck
+			}(controller.RemoveStuckPVCProtection)
+			controller.RemoveStuckPVCProtection = tt.removeStuck
+
+			tc := newTikvClusterForPD()
+			tc.Spec.TiKV.Replicas = 3
+			checker, podIndexer, pvcIndexer := newFakeTerminatingPVCChecker()
+			g.Expect(pvcIndexer.Add(tt.pvc)).To(Succeed())
+			if tt.pod != nil {
+				g.Expect(podIndexer.Add(tt.pod)).To(Succeed())
+			}
+
+			err := checker.Check(tc)
+			if tt.expectErr {
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+				after, ok := controller.RequeueAfter(err)
+				g.Expect(ok).To(BeTrue())
+				g.Expect(after).To(BeNumerically("<=", controller.PVCTerminatingTimeout-time.Minute))
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+			}
+
+			cond := utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.PVCStuckTerminating)
+			if tt.expectStuck {
+				g.Expect(cond).NotTo(BeNil())
+				g.Expect(cond.Status).To(Equal(corev1.ConditionTrue))
+				g.Expect(cond.Message).To(ContainSubstring("example.com/backup"))
+			} else {
+				g.Expect(cond).To(BeNil())
+			}
+
+			obj, _, err := pvcIndexer.Get(tt.pvc)
+			g.Expect(err).NotTo(HaveOccurred())
+			if tt.expectRemove {
+				g.Expect(obj.(*corev1.PersistentVolumeClaim).Finalizers).To(Equal([]string{"example.com/backup"}))
+			} else {
+				g.Expect(obj.(*corev1.PersistentVolumeClaim).Finalizers).To(Equal(tt.pvc.Finalizers))
+			}
+		})
+	}
+}
+
+func TestTerminatingPVCCheckerResetCondition(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForPD()
+	cond := utiltikvcluster.NewTikvClusterCondition(v1alpha1.PVCStuckTerminating, corev1.ConditionTrue, utiltikvcluster.PVCTerminating, "")
+	utiltikvcluster.SetTikvClusterCondition(&tc.Status, *cond)
+
+	checker, _, _ := newFakeTerminatingPVCChecker()
+	g.Expect(checker.Check(tc)).To(Succeed())
+	cond = utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.PVCStuckTerminating)
+	g.Expect(cond.Status).To(Equal(corev1.ConditionFalse))
+	g.Expect(cond.Reason).To(Equal(utiltikvcluster.PVCNotTerminating))
+}
+
+func newFakeTerminatingPVCChecker() (*terminatingPVCChecker, cache.Indexer, cache.Indexer) {
+	kubeCli := kubefake.NewSimpleClientset()
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeCli, 0)
+	podInformer := kubeInformerFactory.Core().V1().Pods()
+	pvcInformer := kubeInformerFactory.Core().V1().PersistentVolumeClaims()
+	pvcControl := controller.NewFakePVCControl(pvcInformer)
+
+	return &terminatingPVCChecker{podInformer.Lister(), pvcControl, pvcInformer.Lister(), record.NewFakeRecorder(10)},
+		podInformer.Informer().GetIndexer(), pvcInformer.Informer().GetIndexer()
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/tikv/tikv-operator/pkg/label"
 	"github.com/tikv/tikv-operator/pkg/pdapi"
 	apps "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/apimachinery/pkg/util/sets"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/klog"
@@ -49,6 +50,7 @@ type generalScaler struct {
 	pdControl  pdapi.PDControlInterface
 	pvcLister  corelisters.PersistentVolumeClaimLister
 	pvcControl controller.PVCControlInterface
+	clock      clock.Clock
 }
 
 func (gs *generalScaler) deleteDeferDeletingPVC(tc *v1alpha1.TikvCluster,
@@ -128,7 +130,7 @@ func (gs *generalScaler) updateDeferDeletingPVC(tc *v1alpha1.TikvCluster,
 		if pvc.Annotations == nil {
 			pvc.Annotations = map[string]string{}
 		}
-		now := time.Now().Format(time.RFC3339)
+		now := gs.clock.Now().Format(time.RFC3339)
 		pvc.Annotations[label.AnnPVCDeferDeleting] = now
 		_, err = gs.pvcControl.UpdatePVC(tc, pvc)
 		if err != nil {
@@ -17,6 +17,7 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	. "github.com/onsi/gomega"
@@ -29,6 +30,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/apimachinery/pkg/util/sets"
 	kubeinformers "k8s.io/client-go/informers"
 	kubefake "k8s.io/client-go/kubernetes/fake"
@@ -170,7 +172,7 @@ func newFakeGeneralScaler() (*generalScaler, cache.Indexer, *controller.FakePVCC
 	pvcInformer := kubeInformerFactory.Core().V1().PersistentVolumeClaims()
 	pvcControl := controller.NewFakePVCControl(pvcInformer)
 
-	return &generalScaler{pvcLister: pvcInformer.Lister(), pvcControl: pvcControl},
+	return &generalScaler{pvcLister: pvcInformer.Lister(), pvcControl: pvcControl, clock: clock.NewFakeClock(time.Now())},
 		pvcInformer.Informer().GetIndexer(), pvcControl
 }
 
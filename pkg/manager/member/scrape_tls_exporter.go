@@ -0,0 +1,183 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/label"
+	"github.com/tikv/tikv-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+// ScrapeTLSExporter copies the client certificate of the cluster TLS to the namespace of
+// Prometheus, see spec.monitoring.exportScrapeTLS. An owner reference can not point to another
+// namespace, so the copies are found by their labels and deleted by the exporter.
+type ScrapeTLSExporter interface {
+	// Sync creates the copy of the client certificate or refreshes it once the certificate is
+	// rotated, and deletes the copies left in the namespaces it is no longer exported to
+	Sync(tc *v1alpha1.TikvCluster) error
+	// Cleanup deletes the copies of the cluster deleted
+	Cleanup(ns, tcName string) error
+}
+
+type realScrapeTLSExporter struct {
+	kubeCli      kubernetes.Interface
+	secretLister corelisters.SecretLister
+	recorder     record.EventRecorder
+}
+
+// NewScrapeTLSExporter returns a ScrapeTLSExporter
+func NewScrapeTLSExporter(kubeCli kubernetes.Interface, secretLister corelisters.SecretLister, recorder record.EventRecorder) ScrapeTLSExporter {
+	return &realScrapeTLSExporter{kubeCli, secretLister, recorder}
+}
+
+func (e *realScrapeTLSExporter) Sync(tc *v1alpha1.TikvCluster) error {
+	ns := tc.GetNamespace()
+	tcName := tc.GetName()
+	var target string
+	if tc.IsTLSClusterEnabled() && tc.Spec.Monitoring != nil && tc.Spec.Monitoring.ExportScrapeTLS != nil {
+		target = tc.Spec.Monitoring.ExportScrapeTLS.Namespace
+	}
+	if err := e.deleteCopies(ns, tcName, target); err != nil {
+		return err
+	}
+	if target == "" {
+		return nil
+	}
+
+	sourceName := util.ClusterClientTLSSecretName(tcName)
+	source, err := e.secretLister.Secrets(ns).Get(sourceName)
+	if errors.IsNotFound(err) {
+		return controller.RequeueErrorf("tikvcluster: [%s/%s]'s client tls secret %s does not exist, it is not exported to namespace %s", ns, tcName, sourceName, target)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get the client tls secret %s/%s: %v", ns, sourceName, err)
+	}
+	data := map[string][]byte{}
+	for _, key := range []string{corev1.ServiceAccountRootCAKey, corev1.TLSCertKey, corev1.TLSPrivateKeyKey} {
+		if v, ok := source.Data[key]; ok {
+			data[key] = v
+		}
+	}
+
+	name := util.ScrapeTLSSecretName(ns, tcName)
+	existing, err := e.secretLister.Secrets(target).Get(name)
+	if errors.IsNotFound(err) {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: target,
+				Labels:    scrapeTLSLabel(ns, tcName).Labels(),
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: data,
+		}
+		if _, err := e.kubeCli.CoreV1().Secrets(target).Create(secret); err != nil {
+			return fmt.Errorf("failed to export the client tls secret %s/%s to %s/%s: %v", ns, sourceName, target, name, err)
+		}
+		klog.Infof("tikv cluster %s/%s: client tls secret %s exported to %s/%s", ns, tcName, sourceName, target, name)
+		e.recorder.Eventf(tc, corev1.EventTypeNormal, "ScrapeTLSExported", "client tls secret %s exported to %s/%s", sourceName, target, name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get the scrape tls secret %s/%s: %v", target, name, err)
+	}
+	if !isScrapeTLSCopy(existing, ns, tcName) {
+		// a secret of the same name created by users is never overwritten
+		return fmt.Errorf("tikvcluster: [%s/%s]'s client tls secret can not be exported, secret %s/%s exists and is not managed by tikv-operator", ns, tcName, target, name)
+	}
+	if reflect.DeepEqual(existing.Data, data) {
+		return nil
+	}
+	updated := existing.DeepCopy()
+	updated.Data = data
+	if _, err := e.kubeCli.CoreV1().Secrets(target).Update(updated); err != nil {
+		return fmt.Errorf("failed to refresh the scrape tls secret %s/%s: %v", target, name, err)
+	}
+	klog.Infof("tikv cluster %s/%s: scrape tls secret %s/%s refreshed", ns, tcName, target, name)
+	return nil
+}
+
+func (e *realScrapeTLSExporter) Cleanup(ns, tcName string) error {
+	return e.deleteCopies(ns, tcName, "")
+}
+
+// deleteCopies deletes the copies of the client certificate of the cluster except the one in
+// the namespace kept
+func (e *realScrapeTLSExporter) deleteCopies(ns, tcName, keep string) error {
+	selector, err := scrapeTLSLabel(ns, tcName).Selector()
+	if err != nil {
+		return err
+	}
+	secrets, err := e.secretLister.List(selector)
+	if err != nil {
+		return fmt.Errorf("failed to list the scrape tls secrets of tikv cluster %s/%s: %v", ns, tcName, err)
+	}
+	for _, secret := range secrets {
+		if secret.GetNamespace() == keep {
+			continue
+		}
+		err := e.kubeCli.CoreV1().Secrets(secret.GetNamespace()).Delete(secret.GetName(), nil)
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete the scrape tls secret %s/%s: %v", secret.GetNamespace(), secret.GetName(), err)
+		}
+		klog.Infof("tikv cluster %s/%s: scrape tls secret %s/%s deleted", ns, tcName, secret.GetNamespace(), secret.GetName())
+	}
+	return nil
+}
+
+// scrapeTLSLabel returns the labels of the copies of the client certificate of the cluster, the
+// namespace label tells the clusters of the same name in different namespaces apart
+func scrapeTLSLabel(ns, tcName string) label.Label {
+	return label.New().Instance(tcName).Namespace(ns).Component(label.ScrapeTLSLabelVal)
+}
+
+func isScrapeTLSCopy(secret *corev1.Secret, ns, tcName string) bool {
+	for k, v := range scrapeTLSLabel(ns, tcName) {
+		if secret.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+type FakeScrapeTLSExporter struct {
+	err error
+}
+
+func NewFakeScrapeTLSExporter() *FakeScrapeTLSExporter {
+	return &FakeScrapeTLSExporter{}
+}
+
+func (e *FakeScrapeTLSExporter) SetSyncError(err error) {
+	e.err = err
+}
+
+func (e *FakeScrapeTLSExporter) Sync(_ *v1alpha1.TikvCluster) error {
+	return e.err
+}
+
+func (e *FakeScrapeTLSExporter) Cleanup(_, _ string) error {
+	return nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/label"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestScrapeTLSExporterSync(t *testing.T) {
+	g := NewGomegaWithT(t)
+	now := time.Now()
+
+	tc := newTikvClusterForPDDiscovery()
+	tc.Spec.TLSCluster = &v1alpha1.TLSCluster{Enabled: true}
+	tc.Spec.Monitoring = &v1alpha1.MonitoringSpec{ExportScrapeTLS: &v1alpha1.ExportScrapeTLS{Namespace: "monitoring"}}
+	kubeCli := kubefake.NewSimpleClientset()
+	secretInformer := kubeinformers.NewSharedInformerFactory(kubeCli, 0).Core().V1().Secrets()
+	indexer := secretInformer.Informer().GetIndexer()
+	exporter := NewScrapeTLSExporter(kubeCli, secretInformer.Lister(), record.NewFakeRecorder(10))
+	// the informer is not started, the secrets written are added to the indexer by hand
+	getCopy := func(ns string) (*corev1.Secret, error) {
+		s, err := kubeCli.CoreV1().Secrets(ns).Get("default-test-scrape-tls", metav1.GetOptions{})
+		if err == nil {
+			g.Expect(indexer.Update(s)).To(Succeed())
+		}
+		return s, err
+	}
+
+	// the client certificate is not issued yet
+	g.Expect(controller.IsRequeueError(exporter.Sync(tc))).To(BeTrue())
+
+	ca := newTestCert(g, nil, nil, now.Add(-time.Hour), now.Add(time.Hour))
+	source := newTestTLSSecret("test-cluster-client-secret", ca, newTestCert(g, ca, nil, now.Add(-time.Hour), now.Add(time.Hour)))
+	g.Expect(indexer.Add(source)).To(Succeed())
+	g.Expect(exporter.Sync(tc)).To(Succeed())
+	copied, err := getCopy("monitoring")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(copied.Data).To(Equal(source.Data))
+	g.Expect(copied.OwnerReferences).To(BeEmpty())
+	g.Expect(copied.Labels[label.NamespaceLabelKey]).To(Equal("default"))
+	g.Expect(copied.Labels[label.InstanceLabelKey]).To(Equal("test"))
+
+	// the rotated certificate is copied again
+	source = newTestTLSSecret("test-cluster-client-secret", ca, newTestCert(g, ca, nil, now.Add(-time.Hour), now.Add(2*time.Hour)))
+	g.Expect(indexer.Update(source)).To(Succeed())
+	g.Expect(exporter.Sync(tc)).To(Succeed())
+	copied, err = getCopy("monitoring")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(copied.Data).To(Equal(source.Data))
+
+	// the copy is moved along with the namespace exported to
+	tc.Spec.Monitoring.ExportScrapeTLS.Namespace = "prometheus"
+	g.Expect(exporter.Sync(tc)).To(Succeed())
+	_, err = kubeCli.CoreV1().Secrets("monitoring").Get("default-test-scrape-tls", metav1.GetOptions{})
+	g.Expect(errors.IsNotFound(err)).To(BeTrue())
+	g.Expect(indexer.Delete(copied)).To(Succeed())
+	_, err = getCopy("prometheus")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// the copies are deleted along with the cluster
+	g.Expect(exporter.Cleanup("default", "test")).To(Succeed())
+	_, err = kubeCli.CoreV1().Secrets("prometheus").Get("default-test-scrape-tls", metav1.GetOptions{})
+	g.Expect(errors.IsNotFound(err)).To(BeTrue())
+}
+
+func TestScrapeTLSExporterSecretNotManaged(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForPDDiscovery()
+	tc.Spec.TLSCluster = &v1alpha1.TLSCluster{Enabled: true}
+	tc.Spec.Monitoring = &v1alpha1.MonitoringSpec{ExportScrapeTLS: &v1alpha1.ExportScrapeTLS{Namespace: "monitoring"}}
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-cluster-client-secret"},
+		Data:       map[string][]byte{corev1.TLSCertKey: []byte("cert")},
+	}
+	users := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "default-test-scrape-tls"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	}
+	kubeCli := kubefake.NewSimpleClientset(source, users)
+	secretInformer := kubeinformers.NewSharedInformerFactory(kubeCli, 0).Core().V1().Secrets()
+	g.Expect(secretInformer.Informer().GetIndexer().Add(source)).To(Succeed())
+	g.Expect(secretInformer.Informer().GetIndexer().Add(users)).To(Succeed())
+	exporter := NewScrapeTLSExporter(kubeCli, secretInformer.Lister(), record.NewFakeRecorder(10))
+
+	// the secret of users is neither overwritten nor deleted
+	g.Expect(exporter.Sync(tc)).NotTo(Succeed())
+	g.Expect(exporter.Cleanup("default", "test")).To(Succeed())
+	s, err := kubeCli.CoreV1().Secrets("monitoring").Get("default-test-scrape-tls", metav1.GetOptions{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(s.Data).To(Equal(users.Data))
+}
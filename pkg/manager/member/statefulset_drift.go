@@ -0,0 +1,236 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	utiltikvcluster "github.com/tikv/tikv-operator/pkg/util/tikvcluster"
+	apps "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+var statefulSetDrift = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "tikv_operator",
+		Subsystem: "statefulset",
+		Name:      "drift",
+		Help:      "Whether the statefulset of a component of a cluster has been modified bypassing the operator (1) or not (0).",
+	}, []string{"namespace", "cluster", "component"})
+
+func init() {
+	prometheus.MustRegister(statefulSetDrift)
+}
+
+// renderedSpec is the part of a statefulset owned by the operator, the renderedSpecHash and
+// liveSpecHash in the status of a component are the hashes of it. The fields defaulted by the
+// apiserver are left out so that the rendering and the live statefulset are comparable.
+type renderedSpec struct {
+	Replicas       int32                          `json:"replicas"`
+	UpdateStrategy apps.StatefulSetUpdateStrategy `json:"updateStrategy"`
+	Labels         map[string]string              `json:"labels,omitempty"`
+	Containers     []renderedContainer            `json:"containers"`
+}
+
+type renderedContainer struct {
+	Name      string                      `json:"name"`
+	Image     string                      `json:"image"`
+	Command   []string                    `json:"command,omitempty"`
+	Args      []string                    `json:"args,omitempty"`
+	Env       []string                    `json:"env,omitempty"`
+	Resources corev1.ResourceRequirements `json:"resources"`
+}
+
+func newRenderedSpec(spec *apps.StatefulSetSpec) renderedSpec {
+	rs := renderedSpec{
+		UpdateStrategy: spec.UpdateStrategy,
+		Labels:         spec.Template.Labels,
+	}
+	if spec.Replicas != nil {
+		rs.Replicas = *spec.Replicas
+	}
+	for _, c := range spec.Template.Spec.Containers {
+		rc := renderedContainer{
+			Name:      c.Name,
+			Image:     c.Image,
+			Command:   c.Command,
+			Args:      c.Args,
+			Resources: c.Resources,
+		}
+		// the env from sources are defaulted by the apiserver, only their names are compared
+		for _, env := range c.Env {
+			rc.Env = append(rc.Env, fmt.Sprintf("%s=%s", env.Name, env.Value))
+		}
+		rs.Containers = append(rs.Containers, rc)
+	}
+	return rs
+}
+
+// renderedSpecHash returns the hash of the fields of the statefulset spec owned by the operator
+func renderedSpecHash(spec *apps.StatefulSetSpec) (string, error) {
+	return Sha256Sum(newRenderedSpec(spec))
+}
+
+// driftSummary summarizes the fields of the live statefulset that differ from the applied one
+func driftSummary(applied, live renderedSpec) string {
+	var diffs []string
+	if applied.Replicas != live.Replicas {
+		diffs = append(diffs, fmt.Sprintf("replicas %d -> %d", applied.Replicas, live.Replicas))
+	}
+	if !apiequality.Semantic.DeepEqual(applied.UpdateStrategy, live.UpdateStrategy) {
+		diffs = append(diffs, "updateStrategy")
+	}
+	if !apiequality.Semantic.DeepEqual(applied.Labels, live.Labels) {
+		diffs = append(diffs, "template labels")
+	}
+	liveContainers := map[string]renderedContainer{}
+	for _, c := range live.Containers {
+		liveContainers[c.Name] = c
+	}
+	for _, a := range applied.Containers {
+		l, ok := liveContainers[a.Name]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("container %s removed", a.Name))
+			continue
+		}
+		delete(liveContainers, a.Name)
+		if a.Image != l.Image {
+			diffs = append(diffs, fmt.Sprintf("container %s image %s -> %s", a.Name, a.Image, l.Image))
+		}
+		if !apiequality.Semantic.DeepEqual(a.Command, l.Command) || !apiequality.Semantic.DeepEqual(a.Args, l.Args) {
+			diffs = append(diffs, fmt.Sprintf("container %s command", a.Name))
+		}
+		if !apiequality.Semantic.DeepEqual(a.Env, l.Env) {
+			diffs = append(diffs, fmt.Sprintf("container %s env", a.Name))
+		}
+		if !apiequality.Semantic.DeepEqual(a.Resources, l.Resources) {
+			diffs = append(diffs, fmt.Sprintf("container %s resources", a.Name))
+		}
+	}
+	var added []string
+	for name := range liveContainers {
+		added = append(added, name)
+	}
+	sort.Strings(added)
+	for _, name := range added {
+		diffs = append(diffs, fmt.Sprintf("container %s added", name))
+	}
+	return strings.Join(diffs, ", ")
+}
+
+// updateStatefulSetDetectingDrift updates the statefulset of a component like updateStatefulSet,
+// checking first whether the live statefulset has been modified bypassing the operator since
+// the operator applied it. Depending on spec.driftPolicy, a modified statefulset is either
+// repaired once and reported if it is still modified at the next sync, or reported and left
+// untouched.
+func updateStatefulSetDetectingDrift(setCtl controller.StatefulSetControlInterface, recorder record.EventRecorder, tc *v1alpha1.TikvCluster,
+	memberType v1alpha1.MemberType, newSet, oldSet *apps.StatefulSet) error {
+	drifted, repaired, err := syncStatefulSetDrift(recorder, tc, memberType, newSet, oldSet)
+	if err != nil {
+		return err
+	}
+	if drifted && tc.DriftPolicy() == v1alpha1.DriftPolicyReport {
+		klog.Warningf("tikv cluster %s/%s: %s statefulset %s is modified bypassing the operator, leave it untouched",
+			tc.GetNamespace(), tc.GetName(), memberType, oldSet.GetName())
+		return nil
+	}
+	if drifted && !repaired {
+		klog.Infof("tikv cluster %s/%s: repair %s statefulset %s modified bypassing the operator",
+			tc.GetNamespace(), tc.GetName(), memberType, oldSet.GetName())
+		return applyStatefulSet(setCtl, tc, newSet, oldSet)
+	}
+	return updateStatefulSet(setCtl, tc, newSet, oldSet)
+}
+
+// syncStatefulSetDrift records the hashes of the rendered and the live statefulset of the
+// component in the status, and reports the drift of the live statefulset from the one applied
+// last. repaired is whether the same drift was seen and repaired at the last sync already.
+func syncStatefulSetDrift(recorder record.EventRecorder, tc *v1alpha1.TikvCluster, memberType v1alpha1.MemberType,
+	newSet, oldSet *apps.StatefulSet) (drifted bool, repaired bool, err error) {
+	renderedHash, err := renderedSpecHash(&newSet.Spec)
+	if err != nil {
+		return false, false, err
+	}
+	liveHash, err := renderedSpecHash(&oldSet.Spec)
+	if err != nil {
+		return false, false, err
+	}
+
+	renderedStatus, liveStatus, driftStatus := &tc.Status.PD.RenderedSpecHash, &tc.Status.PD.LiveSpecHash, &tc.Status.PD.Drift
+	if memberType == v1alpha1.TiKVMemberType {
+		renderedStatus, liveStatus, driftStatus = &tc.Status.TiKV.RenderedSpecHash, &tc.Status.TiKV.LiveSpecHash, &tc.Status.TiKV.Drift
+	}
+	lastLiveHash := *liveStatus
+	*renderedStatus, *liveStatus = renderedHash, liveHash
+
+	var summary string
+	// statefulsets applied before the drift detection have no last applied config to compare with
+	if applied, _, err := GetLastAppliedConfig(oldSet); err == nil {
+		appliedSpec, liveSpec := newRenderedSpec(applied), newRenderedSpec(&oldSet.Spec)
+		appliedHash, err := Sha256Sum(appliedSpec)
+		if err != nil {
+			return false, false, err
+		}
+		if appliedHash != liveHash {
+			summary = driftSummary(appliedSpec, liveSpec)
+		}
+	}
+
+	drifted = summary != ""
+	// the live statefulset unchanged since the last sync was repaired at the last sync
+	repaired = drifted && lastLiveHash == liveHash && tc.DriftPolicy() == v1alpha1.DriftPolicyRepair
+	report := drifted && (repaired || tc.DriftPolicy() == v1alpha1.DriftPolicyReport)
+	if report {
+		if *driftStatus != summary {
+			recorder.Event(tc, corev1.EventTypeWarning, "StatefulSetDrift", fmt.Sprintf("%s statefulset %s is modified bypassing the operator: %s", memberType, oldSet.GetName(), summary))
+		}
+		*driftStatus = summary
+	} else if !drifted {
+		*driftStatus = ""
+	}
+	value := 0.0
+	if *driftStatus != "" {
+		value = 1
+	}
+	statefulSetDrift.WithLabelValues(tc.GetNamespace(), tc.GetName(), memberType.String()).Set(value)
+	syncDriftDetectedCondition(tc)
+	return drifted, repaired, nil
+}
+
+// syncDriftDetectedCondition sets the DriftDetected condition from the drift reported for pd and
+// tikv
+func syncDriftDetectedCondition(tc *v1alpha1.TikvCluster) {
+	var drifts []string
+	if tc.Status.PD.Drift != "" {
+		drifts = append(drifts, fmt.Sprintf("pd: %s", tc.Status.PD.Drift))
+	}
+	if tc.Status.TiKV.Drift != "" {
+		drifts = append(drifts, fmt.Sprintf("tikv: %s", tc.Status.TiKV.Drift))
+	}
+	if len(drifts) > 0 {
+		cond := utiltikvcluster.NewTikvClusterCondition(v1alpha1.DriftDetected, corev1.ConditionTrue, utiltikvcluster.StatefulSetModified, strings.Join(drifts, "; "))
+		utiltikvcluster.SetTikvClusterCondition(&tc.Status, *cond)
+	} else if cond := utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.DriftDetected); cond != nil && cond.Status == corev1.ConditionTrue {
+		cond = utiltikvcluster.NewTikvClusterCondition(v1alpha1.DriftDetected, corev1.ConditionFalse, utiltikvcluster.StatefulSetNotModified, "")
+		utiltikvcluster.SetTikvClusterCondition(&tc.Status, *cond)
+	}
+}
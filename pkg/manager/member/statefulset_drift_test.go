@@ -0,0 +1,163 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/client/clientset/versioned/fake"
+	informers "github.com/tikv/tikv-operator/pkg/client/informers/externalversions"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	utiltikvcluster "github.com/tikv/tikv-operator/pkg/util/tikvcluster"
+	apps "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestDriftSummary(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	set := newStatefulSetForDrift()
+	applied := newRenderedSpec(&set.Spec)
+	g.Expect(driftSummary(applied, newRenderedSpec(&set.Spec))).To(BeEmpty())
+
+	set.Spec.Replicas = controller.Int32Ptr(5)
+	set.Spec.Template.Spec.Containers[0].Image = "tikv:debug"
+	set.Spec.Template.Spec.Containers = append(set.Spec.Template.Spec.Containers, corev1.Container{Name: "debugger"})
+	g.Expect(driftSummary(applied, newRenderedSpec(&set.Spec))).To(Equal("replicas 3 -> 5, container tikv image tikv:v4.0.0 -> tikv:debug, container debugger added"))
+
+	// the env from sources defaulted by the apiserver are not a drift
+	live := newStatefulSetForDrift()
+	live.Spec.Template.Spec.Containers[0].Env[0].ValueFrom.FieldRef.APIVersion = "v1"
+	appliedHash, err := renderedSpecHash(&newStatefulSetForDrift().Spec)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(renderedSpecHash(&live.Spec)).To(Equal(appliedHash))
+}
+
+func TestUpdateStatefulSetDetectingDrift(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy v1alpha1.DriftPolicy
+		// expectedImages are the images of the live statefulset after each sync, the statefulset
+		// is modified to tikv:debug before the first two syncs and resolved before the last one
+		expectedImages []string
+		expectedDrifts []bool
+	}{
+		{
+			name:           "repair once and report the drift persisting",
+			policy:         v1alpha1.DriftPolicyRepair,
+			expectedImages: []string{"tikv:v4.0.0", "tikv:debug", "tikv:v4.0.0"},
+			expectedDrifts: []bool{false, true, false},
+		},
+		{
+			name:           "report the drift and leave the statefulset untouched",
+			policy:         v1alpha1.DriftPolicyReport,
+			expectedImages: []string{"tikv:debug", "tikv:debug", "tikv:v4.0.0"},
+			expectedDrifts: []bool{true, true, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+
+			tc := newTikvClusterForPD()
+			tc.Spec.DriftPolicy = tt.policy
+			kubeCli := kubefake.NewSimpleClientset()
+			setInformer := kubeinformers.NewSharedInformerFactory(kubeCli, 0).Apps().V1().StatefulSets()
+			tcInformer := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0).Tikv().V1alpha1().TikvClusters()
+			setControl := controller.NewFakeStatefulSetControl(setInformer, tcInformer)
+			recorder := record.NewFakeRecorder(10)
+
+			set := newStatefulSetForDrift()
+			set.OwnerReferences = []metav1.OwnerReference{controller.GetOwnerRef(tc)}
+			g.Expect(SetStatefulSetLastAppliedConfigAnnotation(set)).To(Succeed())
+			g.Expect(setInformer.Informer().GetIndexer().Add(set)).To(Succeed())
+
+			for i := range tt.expectedImages {
+				oldSet, err := setInformer.Lister().StatefulSets(set.Namespace).Get(set.Name)
+				g.Expect(err).NotTo(HaveOccurred())
+				oldSet = oldSet.DeepCopy()
+				if i < 2 {
+					// modified bypassing the operator, without the last applied config
+					oldSet.Spec.Template.Spec.Containers[0].Image = "tikv:debug"
+				} else {
+					// resolved manually
+					oldSet.Spec.Template.Spec.Containers[0].Image = "tikv:v4.0.0"
+				}
+				g.Expect(setInformer.Informer().GetIndexer().Update(oldSet)).To(Succeed())
+
+				newSet := newStatefulSetForDrift()
+				newSet.OwnerReferences = oldSet.OwnerReferences
+				g.Expect(updateStatefulSetDetectingDrift(setControl, recorder, tc, v1alpha1.TiKVMemberType, newSet, oldSet.DeepCopy())).To(Succeed())
+
+				live, err := setInformer.Lister().StatefulSets(set.Namespace).Get(set.Name)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(live.Spec.Template.Spec.Containers[0].Image).To(Equal(tt.expectedImages[i]), "sync %d", i)
+				g.Expect(tc.Status.TiKV.RenderedSpecHash).NotTo(BeEmpty())
+				g.Expect(tc.Status.TiKV.LiveSpecHash).NotTo(BeEmpty())
+
+				cond := utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.DriftDetected)
+				if tt.expectedDrifts[i] {
+					g.Expect(tc.Status.TiKV.Drift).To(Equal("container tikv image tikv:v4.0.0 -> tikv:debug"), "sync %d", i)
+					g.Expect(cond).NotTo(BeNil())
+					g.Expect(cond.Status).To(Equal(corev1.ConditionTrue))
+				} else {
+					g.Expect(tc.Status.TiKV.Drift).To(BeEmpty(), "sync %d", i)
+					if cond != nil {
+						g.Expect(cond.Status).To(Equal(corev1.ConditionFalse))
+					}
+				}
+			}
+			// one event for the drift reported
+			g.Expect(recorder.Events).To(HaveLen(1))
+		})
+	}
+}
+
+func newStatefulSetForDrift() *apps.StatefulSet {
+	return &apps.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-tikv",
+			Namespace: corev1.NamespaceDefault,
+		},
+		Spec: apps.StatefulSetSpec{
+			Replicas:       controller.Int32Ptr(3),
+			UpdateStrategy: newStatefulSetUpdateStrategy(apps.RollingUpdateStatefulSetStrategyType, 3),
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "tikv",
+							Image: "tikv:v4.0.0",
+							Env: []corev1.EnvVar{
+								{
+									Name: "NAMESPACE",
+									ValueFrom: &corev1.EnvVarSource{
+										FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -16,6 +16,8 @@ package member
 import (
 	"bytes"
 	"text/template"
+
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
 )
 
 // TODO(aylei): it is hard to maintain script in go literal, we should figure out a better solution
@@ -124,7 +126,7 @@ cluster_name=` + "`" + `echo ${PEER_SERVICE_NAME} | sed 's/-pd-peer//'` + "`" +
 	`
 domain="${POD_NAME}.${PEER_SERVICE_NAME}.${NAMESPACE}.svc"
 discovery_url="${cluster_name}-discovery.${NAMESPACE}.svc:10261"
-encoded_domain_url=` + "`" + `echo ${domain}:2380 | base64 | tr "\n" " " | sed "s/ //g"` + "`" +
+encoded_domain_url=` + "`" + `echo ${domain}:{{ .Ports.PDPeer }} | base64 | tr "\n" " " | sed "s/ //g"` + "`" +
 	`
 elapseTime=0
 period=1
@@ -150,10 +152,10 @@ done
 
 ARGS="--data-dir=/var/lib/pd \
 --name=${POD_NAME} \
---peer-urls={{ .Scheme }}://0.0.0.0:2380 \
---advertise-peer-urls={{ .Scheme }}://${domain}:2380 \
---client-urls={{ .Scheme }}://0.0.0.0:2379 \
---advertise-client-urls={{ .Scheme }}://${domain}:2379 \
+--peer-urls={{ .Scheme }}://0.0.0.0:{{ .Ports.PDPeer }} \
+--advertise-peer-urls={{ .Scheme }}://${domain}:{{ .Ports.PDPeer }} \
+--client-urls={{ .Scheme }}://0.0.0.0:{{ .Ports.PDClient }} \
+--advertise-client-urls={{ .Scheme }}://${domain}:{{ .Ports.PDClient }} \
 --config=/etc/pd/pd.toml \
 "
 
@@ -183,6 +185,8 @@ exec /pd-server ${ARGS}
 
 type PDStartScriptModel struct {
 	Scheme string
+	// Ports are the ports the pods listen on, see v1alpha1.TikvCluster.Ports
+	Ports v1alpha1.ClusterPorts
 }
 
 func RenderPDStartScript(model *PDStartScriptModel) (string, error) {
@@ -219,10 +223,10 @@ fi
 
 # Use HOSTNAME if POD_NAME is unset for backward compatibility.
 POD_NAME=${POD_NAME:-$HOSTNAME}
-ARGS="--pd={{ .Scheme }}://${CLUSTER_NAME}-pd:2379 \
---advertise-addr=${POD_NAME}.${HEADLESS_SERVICE_NAME}.${NAMESPACE}.svc:20160 \
---addr=0.0.0.0:20160 \
---status-addr=0.0.0.0:20180 \
+ARGS="--pd={{ .Scheme }}://${CLUSTER_NAME}-pd:{{ .PDServicePort }} \
+--advertise-addr=${POD_NAME}.${HEADLESS_SERVICE_NAME}.${NAMESPACE}.svc:{{ .Ports.TiKV }} \
+--addr=0.0.0.0:{{ .Ports.TiKV }} \
+--status-addr=0.0.0.0:{{ .Ports.TiKVStatus }} \
 --data-dir=/var/lib/tikv \
 --capacity=${CAPACITY} \
 --config=/etc/tikv/tikv.toml
@@ -240,6 +244,10 @@ exec /tikv-server ${ARGS}
 
 type TiKVStartScriptModel struct {
 	Scheme string
+	// Ports are the ports the pods listen on, see v1alpha1.TikvCluster.Ports
+	Ports v1alpha1.ClusterPorts
+	// PDServicePort is the port of the pd service, see v1alpha1.TikvCluster.PDServicePort
+	PDServicePort int32
 }
 
 func RenderTiKVStartScript(model *TiKVStartScriptModel) (string, error) {
@@ -0,0 +1,150 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// tikvConfigLayer is a TOML config layer and the name it is recorded by in the provenance
+type tikvConfigLayer struct {
+	name   string
+	config string
+}
+
+// renderTiKVConfigLayers merges .tikv.configLayers on top of the base config, which is rendered
+// from .tikv.config or read from .tikv.configRef. refLayers are the configs read from the
+// ConfigMaps referenced by the layers, by layer name. The security config of the cluster TLS
+// and the encryption config of .tikv.encryption are merged last if they are enabled. The merged
+// config is validated as the config of tikv-servers and returned with its provenance.
+func renderTiKVConfigLayers(tc *v1alpha1.TikvCluster, base []byte, refLayers map[string]string, encryptionKeys *tikvEncryptionKeys) ([]byte, map[string]string, error) {
+	baseName := "spec.tikv.config"
+	if tc.Spec.TiKV.ConfigRef != nil {
+		baseName = "spec.tikv.configRef"
+	}
+	layers := []tikvConfigLayer{{name: baseName, config: string(base)}}
+	for _, layer := range tc.Spec.TiKV.ConfigLayers {
+		config := layer.Config
+		if layer.ConfigRef != nil {
+			config = refLayers[layer.Name]
+		}
+		layers = append(layers, tikvConfigLayer{
+			name:   fmt.Sprintf("spec.tikv.configLayers[%s]", layer.Name),
+			config: config,
+		})
+	}
+	if tc.IsTLSClusterEnabled() {
+		layers = append(layers, tikvConfigLayer{name: "spec.tlsCluster", config: tikvSecurityConfig()})
+	}
+	if tc.Spec.TiKV.Encryption != nil {
+		layers = append(layers, tikvConfigLayer{name: "spec.tikv.encryption", config: tikvEncryptionConfig(tc.Spec.TiKV.Encryption, encryptionKeys)})
+	}
+
+	merged, provenance, err := mergeTiKVConfigLayers(layers)
+	if err != nil {
+		return nil, nil, err
+	}
+	confText, err := MarshalTOML(merged)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := UnmarshalTOML(confText, &v1alpha1.TiKVConfig{}); err != nil {
+		return nil, nil, fmt.Errorf("invalid tikv config merged from the config layers: %v", err)
+	}
+	return confText, provenance, nil
+}
+
+// tikvSecurityConfig returns the security config pointing tikv to the certs of the cluster TLS
+func tikvSecurityConfig() string {
+	return fmt.Sprintf("[security]\nca-path = %q\ncert-path = %q\nkey-path = %q\n",
+		path.Join(tikvClusterCertPath, corev1.ServiceAccountRootCAKey),
+		path.Join(tikvClusterCertPath, corev1.TLSCertKey),
+		path.Join(tikvClusterCertPath, corev1.TLSPrivateKeyKey))
+}
+
+// tikvEncryptionConfig returns the encryption config pointing tikv to the kms key or to the
+// master keys mounted from the secret
+func tikvEncryptionConfig(encryption *v1alpha1.TiKVEncryption, keys *tikvEncryptionKeys) string {
+	method := encryption.Method
+	if method == "" {
+		method = defaultTiKVEncryptionMethod
+	}
+	config := fmt.Sprintf("[security.encryption]\ndata-encryption-method = %q\n", method)
+	if kms := encryption.KMS; kms != nil {
+		config += fmt.Sprintf("[security.encryption.master-key]\ntype = \"kms\"\nkey-id = %q\nregion = %q\n", kms.KeyID, kms.Region)
+		if kms.Endpoint != "" {
+			config += fmt.Sprintf("endpoint = %q\n", kms.Endpoint)
+		}
+		return config
+	}
+	config += fmt.Sprintf("[security.encryption.master-key]\ntype = \"file\"\npath = %q\n", path.Join(tikvEncryptionPath, tikvMasterKey))
+	if keys != nil && keys.previous {
+		config += fmt.Sprintf("[security.encryption.previous-master-key]\ntype = \"file\"\npath = %q\n", path.Join(tikvEncryptionPath, tikvPreviousMasterKey))
+	}
+	return config
+}
+
+// mergeTiKVConfigLayers merges the TOML config layers in order, the later layers win:
+//   - tables are merged key by key recursively
+//   - the other values, including arrays and arrays of tables, are replaced as a whole
+//
+// It returns the merged config and the layer each value of it comes from by the dotted key
+// path, an array of tables is recorded as a single value.
+func mergeTiKVConfigLayers(layers []tikvConfigLayer) (map[string]interface{}, map[string]string, error) {
+	merged := map[string]interface{}{}
+	provenance := map[string]string{}
+	for _, layer := range layers {
+		var table map[string]interface{}
+		if _, err := toml.Decode(layer.config, &table); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse config layer %s: %v", layer.name, err)
+		}
+		mergeTOMLTable(merged, table, "", layer.name, provenance)
+	}
+	return merged, provenance, nil
+}
+
+func mergeTOMLTable(dst, src map[string]interface{}, prefix, layer string, provenance map[string]string) {
+	for key, value := range src {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		srcTable, srcIsTable := value.(map[string]interface{})
+		if dstTable, dstIsTable := dst[key].(map[string]interface{}); srcIsTable && dstIsTable {
+			mergeTOMLTable(dstTable, srcTable, path, layer, provenance)
+			continue
+		}
+
+		// whatever the earlier layers set under the key is replaced
+		for p := range provenance {
+			if p == path || strings.HasPrefix(p, path+".") {
+				delete(provenance, p)
+			}
+		}
+		if srcIsTable {
+			table := map[string]interface{}{}
+			dst[key] = table
+			mergeTOMLTable(table, srcTable, path, layer, provenance)
+			continue
+		}
+		dst[key] = value
+		provenance[path] = layer
+	}
+}
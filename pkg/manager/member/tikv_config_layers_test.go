@@ -0,0 +1,86 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestMergeTiKVConfigLayers(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	type testcase struct {
+		name             string
+		layers           []tikvConfigLayer
+		expectMerged     map[string]interface{}
+		expectProvenance map[string]string
+		expectErr        bool
+	}
+	tests := []testcase{
+		{
+			name: "tables are merged recursively",
+			layers: []tikvConfigLayer{
+				{name: "base", config: "[raftstore]\nsync-log = true\nraft-base-tick-interval = \"1s\"\n"},
+				{name: "team", config: "[raftstore]\nsync-log = false\n"},
+			},
+			expectMerged: map[string]interface{}{
+				"raftstore": map[string]interface{}{"sync-log": false, "raft-base-tick-interval": "1s"},
+			},
+			expectProvenance: map[string]string{
+				"raftstore.sync-log":                "team",
+				"raftstore.raft-base-tick-interval": "base",
+			},
+		},
+		{
+			name: "arrays are replaced",
+			layers: []tikvConfigLayer{
+				{name: "base", config: "[server]\nlabels = [\"a\", \"b\"]\n"},
+				{name: "team", config: "[server]\nlabels = [\"c\"]\n"},
+			},
+			expectMerged: map[string]interface{}{
+				"server": map[string]interface{}{"labels": []interface{}{"c"}},
+			},
+			expectProvenance: map[string]string{"server.labels": "team"},
+		},
+		{
+			name: "table replaced by a scalar",
+			layers: []tikvConfigLayer{
+				{name: "base", config: "[storage]\nscheduler-concurrency = 1024\n"},
+				{name: "team", config: "storage = \"none\"\n"},
+			},
+			expectMerged:     map[string]interface{}{"storage": "none"},
+			expectProvenance: map[string]string{"storage": "team"},
+		},
+		{
+			name: "invalid layer",
+			layers: []tikvConfigLayer{
+				{name: "base", config: "[storage"},
+			},
+			expectErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Log(test.name)
+		merged, provenance, err := mergeTiKVConfigLayers(test.layers)
+		if test.expectErr {
+			g.Expect(err).To(HaveOccurred())
+			continue
+		}
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(merged).To(Equal(test.expectMerged))
+		g.Expect(provenance).To(Equal(test.expectProvenance))
+	}
+}
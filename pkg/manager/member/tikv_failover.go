@@ -15,16 +15,45 @@ package member
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
 	"github.com/tikv/tikv-operator/pkg/util"
+	utiltikvcluster "github.com/tikv/tikv-operator/pkg/util/tikvcluster"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
 )
 
+const (
+	// defaultZoneLabel is the store label of the zone if .tikv.failover.zoneLabel is not set
+	defaultZoneLabel = "zone"
+	// defaultZoneOutageThreshold is the percentage of the stores of a zone down above which the
+	// zone is suspected to be out if .tikv.failover.zoneOutageThreshold is not set
+	defaultZoneOutageThreshold = 30
+	// defaultZoneFailoverDeadline is how long the failover of a zone out is suspended if
+	// .tikv.failover.zoneFailoverDeadline is not set
+	defaultZoneFailoverDeadline = time.Hour
+
+	zoneOutageSuspectedReason = "ZoneOutageSuspected"
+)
+
+var tikvFailoverSuppressed = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "tikv_operator",
+		Subsystem: "tikv_failover",
+		Name:      "suppressed_stores",
+		Help:      "Number of tikv stores down whose failover is suspended because their zone is suspected to be out.",
+	}, []string{"namespace", "cluster"})
+
+func init() {
+	prometheus.MustRegister(tikvFailoverSuppressed)
+}
+
 type tikvFailover struct {
 	tikvFailoverPeriod time.Duration
 	recorder           record.EventRecorder
@@ -46,8 +75,18 @@ func (tf *tikvFailover) isPodDesired(tc *v1alpha1.TikvCluster, podName string) b
 }
 
 func (tf *tikvFailover) Failover(tc *v1alpha1.TikvCluster) error {
-	ns := tc.GetNamespace()
-	tcName := tc.GetName()
+	if failoverDisabled(tc.Spec.TiKV.MaxFailoverCount) {
+		return nil
+	}
+	resetFailoverLimitReachedCondition(tc)
+
+	zoneLabel, _, zoneDeadline := tikvZoneFailoverPolicy(tc)
+	outages := tf.zoneOutages(tc)
+	tf.setZoneOutageCondition(tc, outages)
+	suppressed := 0
+	defer func() {
+		tikvFailoverSuppressed.WithLabelValues(tc.GetNamespace(), tc.GetName()).Set(float64(suppressed))
+	}()
 
 	for storeID, store := range tc.Status.TiKV.Stores {
 		podName := store.PodName
@@ -69,23 +108,30 @@ func (tf *tikvFailover) Failover(tc *v1alpha1.TikvCluster) error {
 			}
 		}
 		if store.State == v1alpha1.TiKVStateDown && time.Now().After(deadline) && !exist {
+			if outage, ok := outages[store.Labels[zoneLabel]]; ok && time.Now().Before(outage.since.Add(zoneDeadline)) {
+				// the replacement could not be scheduled in the zone out and would churn once it is back
+				klog.V(4).Infof("tikv cluster %s/%s: store[%s] of pod %s is Down, failover is suspended during the outage of zone %s",
+					tc.GetNamespace(), tc.GetName(), store.ID, podName, outage.zone)
+				suppressed++
+				continue
+			}
 			if tc.Status.TiKV.FailureStores == nil {
 				tc.Status.TiKV.FailureStores = map[string]v1alpha1.TiKVFailureStore{}
 			}
-			if tc.Spec.TiKV.MaxFailoverCount != nil && *tc.Spec.TiKV.MaxFailoverCount > 0 {
-				maxFailoverCount := *tc.Spec.TiKV.MaxFailoverCount
-				if len(tc.Status.TiKV.FailureStores) >= int(maxFailoverCount) {
-					klog.Warningf("%s/%s failure stores count reached the limit: %d", ns, tcName, tc.Spec.TiKV.MaxFailoverCount)
-					return nil
-				}
-				tc.Status.TiKV.FailureStores[storeID] = v1alpha1.TiKVFailureStore{
-					PodName:   podName,
-					StoreID:   store.ID,
-					CreatedAt: metav1.Now(),
-				}
-				msg := fmt.Sprintf("store[%s] is Down", store.ID)
-				tf.recorder.Event(tc, corev1.EventTypeWarning, unHealthEventReason, fmt.Sprintf(unHealthEventMsgPattern, "tikv", podName, msg))
+			if tikvFailoverLimitReached(tc) {
+				msg := fmt.Sprintf("store[%s] of pod %s is Down, but failure stores count reached the maxFailoverCount %d, skip failover",
+					store.ID, podName, *tc.Spec.TiKV.MaxFailoverCount)
+				tf.recorder.Event(tc, corev1.EventTypeWarning, failoverLimitReachedReason, msg)
+				setFailoverLimitReachedCondition(tc, utiltikvcluster.TiKVFailoverLimitReached, msg)
+				return nil
+			}
+			tc.Status.TiKV.FailureStores[storeID] = v1alpha1.TiKVFailureStore{
+				PodName:   podName,
+				StoreID:   store.ID,
+				CreatedAt: metav1.Now(),
 			}
+			msg := fmt.Sprintf("store[%s] is Down", store.ID)
+			tf.recorder.Event(tc, corev1.EventTypeWarning, unHealthEventReason, fmt.Sprintf(unHealthEventMsgPattern, "tikv", podName, msg))
 		}
 	}
 
@@ -101,6 +147,102 @@ func (tf *tikvFailover) Recover(tc *v1alpha1.TikvCluster) {
 			delete(tc.Status.TiKV.FailureStores, key)
 		}
 	}
+	resetFailoverLimitReachedCondition(tc)
+}
+
+// zoneOutage is a zone with too many stores down at the same time
+type zoneOutage struct {
+	zone  string
+	down  int
+	total int
+	// since is when the stores down exceeded the threshold
+	since time.Time
+}
+
+// zoneOutages returns the zones suspected to be out by name, the stores without the zone label are
+// left out so that they are failed over one by one
+func (tf *tikvFailover) zoneOutages(tc *v1alpha1.TikvCluster) map[string]zoneOutage {
+	zoneLabel, threshold, _ := tikvZoneFailoverPolicy(tc)
+	total := map[string]int{}
+	downSince := map[string][]time.Time{}
+	for _, store := range tc.Status.TiKV.Stores {
+		zone := store.Labels[zoneLabel]
+		if zone == "" || !tf.isPodDesired(tc, store.PodName) {
+			continue
+		}
+		total[zone]++
+		if store.State == v1alpha1.TiKVStateDown && !store.LastTransitionTime.IsZero() {
+			downSince[zone] = append(downSince[zone], store.LastTransitionTime.Time)
+		}
+	}
+
+	outages := map[string]zoneOutage{}
+	for zone, times := range downSince {
+		sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+		for i := range times {
+			// a single store down is failed over as usual whatever the size of its zone
+			if down := i + 1; down > 1 && down*100 > int(threshold)*total[zone] {
+				outages[zone] = zoneOutage{zone: zone, down: len(times), total: total[zone], since: times[i]}
+				break
+			}
+		}
+	}
+	return outages
+}
+
+// setZoneOutageCondition reports the zones suspected to be out, an event is recorded when the
+// suspicion starts
+func (tf *tikvFailover) setZoneOutageCondition(tc *v1alpha1.TikvCluster, outages map[string]zoneOutage) {
+	if len(outages) == 0 {
+		resetZoneOutageCondition(tc)
+		return
+	}
+	zones := make([]string, 0, len(outages))
+	for zone := range outages {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	var msgs []string
+	for _, zone := range zones {
+		outage := outages[zone]
+		msgs = append(msgs, fmt.Sprintf("%d of %d stores of zone %s are Down since %s", outage.down, outage.total, zone, outage.since.Format(time.RFC3339)))
+	}
+	msg := strings.Join(msgs, ", ")
+	if cond := utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.ZoneOutageSuspected); cond == nil || cond.Status != corev1.ConditionTrue {
+		tf.recorder.Event(tc, corev1.EventTypeWarning, zoneOutageSuspectedReason, fmt.Sprintf("failover of the stores is suspended, %s", msg))
+	}
+	cond := utiltikvcluster.NewTikvClusterCondition(v1alpha1.ZoneOutageSuspected, corev1.ConditionTrue, utiltikvcluster.ZoneOutage, msg)
+	utiltikvcluster.SetTikvClusterCondition(&tc.Status, *cond)
+}
+
+// resetZoneOutageCondition resets the ZoneOutageSuspected condition once no zone is suspected to
+// be out any more
+func resetZoneOutageCondition(tc *v1alpha1.TikvCluster) {
+	tikvFailoverSuppressed.WithLabelValues(tc.GetNamespace(), tc.GetName()).Set(0)
+	cond := utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.ZoneOutageSuspected)
+	if cond == nil || cond.Status != corev1.ConditionTrue {
+		return
+	}
+	cond = utiltikvcluster.NewTikvClusterCondition(v1alpha1.ZoneOutageSuspected, corev1.ConditionFalse, utiltikvcluster.NoZoneOutage, "")
+	utiltikvcluster.SetTikvClusterCondition(&tc.Status, *cond)
+}
+
+// tikvZoneFailoverPolicy returns the zone label, the zone outage threshold and the zone failover
+// deadline of .tikv.failover with the defaults applied
+func tikvZoneFailoverPolicy(tc *v1alpha1.TikvCluster) (string, int32, time.Duration) {
+	zoneLabel, threshold, deadline := defaultZoneLabel, int32(defaultZoneOutageThreshold), defaultZoneFailoverDeadline
+	if failover := tc.Spec.TiKV.Failover; failover != nil {
+		if failover.ZoneLabel != "" {
+			zoneLabel = failover.ZoneLabel
+		}
+		if failover.ZoneOutageThreshold != nil {
+			threshold = *failover.ZoneOutageThreshold
+		}
+		if failover.ZoneFailoverDeadline != nil {
+			deadline = failover.ZoneFailoverDeadline.Duration
+		}
+	}
+	return zoneLabel, threshold, deadline
 }
 
 type fakeTiKVFailover struct{}
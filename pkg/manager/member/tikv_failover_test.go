@@ -14,11 +14,16 @@
 package member
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
 	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/label"
+	utiltikvcluster "github.com/tikv/tikv-operator/pkg/util/tikvcluster"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/pointer"
@@ -304,3 +309,156 @@ func newFakeTiKVFailover() *tikvFailover {
 	recorder := record.NewFakeRecorder(100)
 	return &tikvFailover{1 * time.Hour, recorder}
 }
+
+func TestTiKVFailoverLimitRecover(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tc := newTikvClusterForPD()
+	tc.Spec.TiKV.Replicas = 6
+	tc.Spec.TiKV.MaxFailoverCount = pointer.Int32Ptr(2)
+	tc.Status.TiKV.Stores = map[string]v1alpha1.TiKVStore{
+		"3": {
+			ID:                 "3",
+			State:              v1alpha1.TiKVStateDown,
+			PodName:            "tikv-3",
+			LastTransitionTime: metav1.Time{Time: time.Now().Add(-70 * time.Minute)},
+		},
+	}
+	tc.Status.TiKV.FailureStores = map[string]v1alpha1.TiKVFailureStore{
+		"1": {PodName: "tikv-1", StoreID: "1"},
+		"2": {PodName: "tikv-2", StoreID: "2"},
+	}
+	recorder := record.NewFakeRecorder(100)
+	tikvFailover := &tikvFailover{1 * time.Hour, recorder}
+
+	// the cap is reached, the down store is only reported
+	g.Expect(tikvFailover.Failover(tc)).To(Succeed())
+	g.Expect(tc.Status.TiKV.FailureStores).To(HaveLen(2))
+	cond := utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.FailoverLimitReached)
+	g.Expect(cond).NotTo(BeNil())
+	g.Expect(cond.Status).To(Equal(corev1.ConditionTrue))
+	g.Expect(cond.Reason).To(Equal(utiltikvcluster.TiKVFailoverLimitReached))
+	events := collectEvents(recorder.Events)
+	g.Expect(events).To(HaveLen(1))
+	g.Expect(events[0]).To(ContainSubstring("FailoverLimitReached store[3] of pod tikv-3 is Down"))
+
+	// recovering a failure store manually brings the count back under the cap
+	tc.Annotations = map[string]string{label.AnnRecoverFailoverKey: "tikv-1"}
+	_, requested := RecoverFailover(tc)
+	g.Expect(requested).To(BeTrue())
+	g.Expect(tc.Status.TiKV.FailureStores).To(HaveLen(1))
+	cond = utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.FailoverLimitReached)
+	g.Expect(cond.Status).To(Equal(corev1.ConditionFalse))
+	g.Expect(cond.Reason).To(Equal(utiltikvcluster.FailoverLimitNotReached))
+
+	// the down store is failed over now
+	g.Expect(tikvFailover.Failover(tc)).To(Succeed())
+	g.Expect(tc.Status.TiKV.FailureStores).To(HaveKey("3"))
+	g.Expect(tc.Status.TiKV.FailureStores).To(HaveLen(2))
+	cond = utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.FailoverLimitReached)
+	g.Expect(cond.Status).To(Equal(corev1.ConditionFalse))
+	events = collectEvents(recorder.Events)
+	g.Expect(events).To(HaveLen(1))
+	g.Expect(events[0]).To(ContainSubstring("Unhealthy tikv pod[tikv-3] is unhealthy"))
+
+	// maxFailoverCount 0 disables failover without reporting anything
+	tc.Spec.TiKV.MaxFailoverCount = pointer.Int32Ptr(0)
+	tc.Status.TiKV.Stores["4"] = v1alpha1.TiKVStore{
+		ID:                 "4",
+		State:              v1alpha1.TiKVStateDown,
+		PodName:            "tikv-4",
+		LastTransitionTime: metav1.Time{Time: time.Now().Add(-70 * time.Minute)},
+	}
+	g.Expect(tikvFailover.Failover(tc)).To(Succeed())
+	g.Expect(tc.Status.TiKV.FailureStores).To(HaveLen(2))
+	g.Expect(collectEvents(recorder.Events)).To(BeEmpty())
+}
+
+func TestTiKVFailoverZoneOutage(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tc := newTikvClusterForPD()
+	tc.Spec.TiKV.Replicas = 6
+	tc.Spec.TiKV.MaxFailoverCount = pointer.Int32Ptr(3)
+	tc.Spec.TiKV.Failover = &v1alpha1.TiKVFailover{ZoneFailoverDeadline: &metav1.Duration{Duration: 2 * time.Hour}}
+	newStore := func(id, podName, zone, state string, downFor time.Duration) v1alpha1.TiKVStore {
+		return v1alpha1.TiKVStore{
+			ID:                 id,
+			PodName:            podName,
+			State:              state,
+			LastTransitionTime: metav1.Time{Time: time.Now().Add(-downFor)},
+			Labels:             map[string]string{"zone": zone},
+		}
+	}
+	tc.Status.TiKV.Stores = map[string]v1alpha1.TiKVStore{
+		"1": newStore("1", "tikv-0", "a", v1alpha1.TiKVStateDown, 70*time.Minute),
+		"2": newStore("2", "tikv-1", "a", v1alpha1.TiKVStateDown, 65*time.Minute),
+		"3": newStore("3", "tikv-2", "a", v1alpha1.TiKVStateUp, 70*time.Minute),
+		"4": newStore("4", "tikv-3", "b", v1alpha1.TiKVStateDown, 70*time.Minute),
+		"5": newStore("5", "tikv-4", "b", v1alpha1.TiKVStateUp, 70*time.Minute),
+		"6": newStore("6", "tikv-5", "b", v1alpha1.TiKVStateUp, 70*time.Minute),
+	}
+	recorder := record.NewFakeRecorder(100)
+	tikvFailover := &tikvFailover{1 * time.Hour, recorder}
+	suppressed := func() float64 {
+		m := &dto.Metric{}
+		g.Expect(tikvFailoverSuppressed.WithLabelValues(tc.GetNamespace(), tc.GetName()).Write(m)).To(Succeed())
+		return m.GetGauge().GetValue()
+	}
+
+	// 2 of the 3 stores of zone a are down, only the single store down of zone b is failed over
+	g.Expect(tikvFailover.Failover(tc)).To(Succeed())
+	g.Expect(tc.Status.TiKV.FailureStores).To(HaveLen(1))
+	g.Expect(tc.Status.TiKV.FailureStores).To(HaveKey("4"))
+	cond := utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.ZoneOutageSuspected)
+	g.Expect(cond).NotTo(BeNil())
+	g.Expect(cond.Status).To(Equal(corev1.ConditionTrue))
+	g.Expect(cond.Reason).To(Equal(utiltikvcluster.ZoneOutage))
+	g.Expect(cond.Message).To(ContainSubstring("2 of 3 stores of zone a are Down"))
+	g.Expect(suppressed()).To(Equal(float64(2)))
+	events := collectEvents(recorder.Events)
+	g.Expect(events).To(HaveLen(2))
+	g.Expect(events).To(ContainElement(ContainSubstring("ZoneOutageSuspected failover of the stores is suspended")))
+
+	// the suspicion is only reported once
+	g.Expect(tikvFailover.Failover(tc)).To(Succeed())
+	g.Expect(collectEvents(recorder.Events)).To(BeEmpty())
+
+	// the stores of zone a are failed over once the zone failover deadline is exceeded
+	tc.Spec.TiKV.Failover.ZoneFailoverDeadline = &metav1.Duration{Duration: time.Hour}
+	g.Expect(tikvFailover.Failover(tc)).To(Succeed())
+	g.Expect(tc.Status.TiKV.FailureStores).To(HaveLen(3))
+	g.Expect(suppressed()).To(Equal(float64(0)))
+
+	// the condition is reset once the zone is back
+	for id, store := range tc.Status.TiKV.Stores {
+		store.State = v1alpha1.TiKVStateUp
+		tc.Status.TiKV.Stores[id] = store
+	}
+	g.Expect(tikvFailover.Failover(tc)).To(Succeed())
+	cond = utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.ZoneOutageSuspected)
+	g.Expect(cond.Status).To(Equal(corev1.ConditionFalse))
+	g.Expect(cond.Reason).To(Equal(utiltikvcluster.NoZoneOutage))
+}
+
+func TestTiKVFailoverZoneOutageWithoutZoneLabel(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tc := newTikvClusterForPD()
+	tc.Spec.TiKV.Replicas = 6
+	tc.Spec.TiKV.MaxFailoverCount = pointer.Int32Ptr(3)
+	tc.Spec.TiKV.Failover = &v1alpha1.TiKVFailover{ZoneLabel: "rack"}
+	tc.Status.TiKV.Stores = map[string]v1alpha1.TiKVStore{}
+	for i, podName := range []string{"tikv-0", "tikv-1", "tikv-2"} {
+		id := fmt.Sprintf("%d", i+1)
+		tc.Status.TiKV.Stores[id] = v1alpha1.TiKVStore{
+			ID:                 id,
+			PodName:            podName,
+			State:              v1alpha1.TiKVStateDown,
+			LastTransitionTime: metav1.Time{Time: time.Now().Add(-70 * time.Minute)},
+			Labels:             map[string]string{"zone": "a"},
+		}
+	}
+
+	// the stores without the zone label are failed over one by one
+	g.Expect(newFakeTiKVFailover().Failover(tc)).To(Succeed())
+	g.Expect(tc.Status.TiKV.FailureStores).To(HaveLen(3))
+	g.Expect(utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.ZoneOutageSuspected)).To(BeNil())
+}
@@ -14,26 +14,35 @@
 package member
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/pingcap/advanced-statefulset/client/apis/apps/v1/helper"
 	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
 	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/deps"
 	"github.com/tikv/tikv-operator/pkg/label"
 	"github.com/tikv/tikv-operator/pkg/manager"
 	"github.com/tikv/tikv-operator/pkg/pdapi"
 	"github.com/tikv/tikv-operator/pkg/util"
+	utiltikvcluster "github.com/tikv/tikv-operator/pkg/util/tikvcluster"
 	apps "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/uuid"
 	v1 "k8s.io/client-go/listers/apps/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
 )
 
@@ -41,54 +50,75 @@ const (
 	// tikvClusterCertPath is where the cert for inter-cluster communication stored (if any)
 	tikvClusterCertPath = "/var/lib/tikv-tls"
 
+	// tikvEncryptionPath is where the secret of the master key for the encryption at rest is stored (if any)
+	tikvEncryptionPath = "/var/lib/tikv-encryption"
+	// tikvEncryptionVolume is the volume of the secret of the master key
+	tikvEncryptionVolume = "tikv-encryption"
+	// tikvMasterKey and tikvPreviousMasterKey are the keys of the master keys in the secret
+	tikvMasterKey         = "master-key"
+	tikvPreviousMasterKey = "previous-master-key"
+	// tikvKMSAccessKeyID and tikvKMSSecretAccessKey are the keys of the kms credentials in the secret
+	tikvKMSAccessKeyID     = "access-key-id"
+	tikvKMSSecretAccessKey = "secret-access-key"
+	// tikvEncryptionHashKey is the key of the hash of the master keys in the configmap
+	tikvEncryptionHashKey = "encryption-key-hash"
+	// defaultTiKVEncryptionMethod is the method to encrypt the data files if .tikv.encryption.method is not set
+	defaultTiKVEncryptionMethod = "aes256-ctr"
+
 	//find a better way to manage store only managed by tikv in Operator
 	tikvStoreLimitPattern = `%s-tikv-\d+\.%s-tikv-peer\.%s\.svc\:\d+`
 )
 
-// tikvMemberManager implements manager.Manager.
+// tikvMemberManager implements manager.MemberManager.
 type tikvMemberManager struct {
 	setControl                   controller.StatefulSetControlInterface
 	svcControl                   controller.ServiceControlInterface
+	podControl                   controller.PodControlInterface
 	pdControl                    pdapi.PDControlInterface
 	typedControl                 controller.TypedControlInterface
 	setLister                    v1.StatefulSetLister
 	svcLister                    corelisters.ServiceLister
 	podLister                    corelisters.PodLister
 	nodeLister                   corelisters.NodeLister
+	cmLister                     corelisters.ConfigMapLister
+	secretLister                 corelisters.SecretLister
 	autoFailover                 bool
 	tikvFailover                 Failover
 	tikvScaler                   Scaler
 	tikvUpgrader                 Upgrader
+	syncStatus                   *controller.InformerSyncStatus
+	recorder                     record.EventRecorder
+	clock                        clock.Clock
 	tikvStatefulSetIsUpgradingFn func(corelisters.PodLister, pdapi.PDControlInterface, *apps.StatefulSet, *v1alpha1.TikvCluster) (bool, error)
 }
 
 // NewTiKVMemberManager returns a *tikvMemberManager
 func NewTiKVMemberManager(
-	pdControl pdapi.PDControlInterface,
-	setControl controller.StatefulSetControlInterface,
-	svcControl controller.ServiceControlInterface,
-	typedControl controller.TypedControlInterface,
-	setLister v1.StatefulSetLister,
-	svcLister corelisters.ServiceLister,
-	podLister corelisters.PodLister,
-	nodeLister corelisters.NodeLister,
+	deps *deps.Dependencies,
 	autoFailover bool,
 	tikvFailover Failover,
 	tikvScaler Scaler,
-	tikvUpgrader Upgrader) manager.Manager {
+	tikvUpgrader Upgrader,
+	syncStatus *controller.InformerSyncStatus) manager.MemberManager {
 	kvmm := tikvMemberManager{
-		pdControl:    pdControl,
-		podLister:    podLister,
-		nodeLister:   nodeLister,
-		setControl:   setControl,
-		svcControl:   svcControl,
-		typedControl: typedControl,
-		setLister:    setLister,
-		svcLister:    svcLister,
+		pdControl:    deps.PDControl,
+		podLister:    deps.PodLister,
+		nodeLister:   deps.NodeLister,
+		cmLister:     deps.ConfigMapLister,
+		secretLister: deps.SecretLister,
+		setControl:   deps.StatefulSetControl,
+		svcControl:   deps.ServiceControl,
+		podControl:   deps.PodControl,
+		typedControl: deps.TypedControl,
+		setLister:    deps.StatefulSetLister,
+		svcLister:    deps.ServiceLister,
 		autoFailover: autoFailover,
 		tikvFailover: tikvFailover,
 		tikvScaler:   tikvScaler,
 		tikvUpgrader: tikvUpgrader,
+		syncStatus:   syncStatus,
+		recorder:     deps.Recorder,
+		clock:        deps.Clock,
 	}
 	kvmm.tikvStatefulSetIsUpgradingFn = tikvStatefulSetIsUpgrading
 	return &kvmm
@@ -108,14 +138,17 @@ func (tkmm *tikvMemberManager) Sync(tc *v1alpha1.TikvCluster) error {
 	ns := tc.GetNamespace()
 	tcName := tc.GetName()
 
-	if !tc.PDIsAvailable() {
-		return controller.RequeueErrorf("TikvCluster: [%s/%s], waiting for PD cluster running", ns, tcName)
+	// pd is deleted along with the cluster, do not wait for it
+	if err := checkClusterDeleting(tc, "syncing tikv"); err != nil {
+		return err
 	}
 
+	// Services do not depend on PD, sync them first so that a deleted
+	// service is recreated even if PD is not available.
 	svcList := []SvcConfig{
 		{
 			Name:       "peer",
-			Port:       20160,
+			Port:       tc.Ports().TiKV,
 			Headless:   true,
 			SvcLabel:   func(l label.Label) label.Label { return l.TiKV() },
 			MemberName: controller.TiKVPeerMemberName,
@@ -126,11 +159,28 @@ func (tkmm *tikvMemberManager) Sync(tc *v1alpha1.TikvCluster) error {
 			return err
 		}
 	}
+
+	if !tc.PDIsAvailable() {
+		return controller.RequeueErrorf("TikvCluster: [%s/%s], waiting for PD cluster running", ns, tcName)
+	}
+
 	return tkmm.syncStatefulSetForTikvCluster(tc)
 }
 
+// SyncStatus fulfills the manager.MemberManager interface
+func (tkmm *tikvMemberManager) SyncStatus(tc *v1alpha1.TikvCluster) error {
+	set, err := tkmm.setLister.StatefulSets(tc.GetNamespace()).Get(controller.TiKVMemberName(tc.GetName()))
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return tkmm.syncTikvClusterStatus(tc, set.DeepCopy())
+}
+
 func (tkmm *tikvMemberManager) syncServiceForTikvCluster(tc *v1alpha1.TikvCluster, svcConfig SvcConfig) error {
-	if tc.Spec.Paused {
+	if controller.IsClusterPaused(tc) {
 		klog.V(4).Infof("tikv cluster %s/%s is paused, skip syncing for tikv service", tc.GetNamespace(), tc.GetName())
 		return nil
 	}
@@ -186,15 +236,39 @@ func (tkmm *tikvMemberManager) syncStatefulSetForTikvCluster(tc *v1alpha1.TikvCl
 	oldSet := oldSetTmp.DeepCopy()
 
 	if err := tkmm.syncTikvClusterStatus(tc, oldSet); err != nil {
-		return err
+		if !forceUpgradeAllowed(tc) {
+			return err
+		}
+		// pd may be down, the forced upgrade goes on without the status of the stores
+		klog.Warningf("failed to sync TikvCluster: [%s/%s]'s tikv status, go on with the forced upgrade: %v", ns, tcName, err)
 	}
 
-	if tc.Spec.Paused {
+	if controller.IsClusterPaused(tc) {
 		klog.V(4).Infof("tikv cluster %s/%s is paused, skip syncing for tikv statefulset", tc.GetNamespace(), tc.GetName())
 		return nil
 	}
 
-	cm, err := tkmm.syncTiKVConfigMap(tc, oldSet)
+	refConfig, refLayers, found, err := tkmm.resolveTiKVConfigRef(tc)
+	if err != nil {
+		return err
+	}
+	if !found {
+		// the ConfigRefNotFound condition tells the user what is missing, and
+		// creating the ConfigMap triggers another sync as it is watched
+		klog.Warningf("tikv cluster %s/%s: config ref not found, skip syncing for tikv statefulset", tc.GetNamespace(), tc.GetName())
+		return nil
+	}
+
+	encryptionKeys, ok, err := tkmm.resolveTiKVEncryption(tc, oldSet)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// the warning event tells the user how to disable the encryption
+		return nil
+	}
+
+	cm, err := tkmm.syncTiKVConfigMap(tc, oldSet, refConfig, refLayers, encryptionKeys)
 	if err != nil {
 		return err
 	}
@@ -204,6 +278,13 @@ func (tkmm *tikvMemberManager) syncStatefulSetForTikvCluster(tc *v1alpha1.TikvCl
 		tkmm.tikvFailover.Recover(tc)
 	}
 
+	// The stores being decommissioned are deleted as delete slots of the desired statefulset
+	if !setNotExist {
+		if err := tkmm.syncOfflineStores(tc, oldSet); err != nil {
+			return err
+		}
+	}
+
 	newSet, err := getNewTiKVSetForTikvCluster(tc, cm)
 	if err != nil {
 		return err
@@ -221,16 +302,32 @@ func (tkmm *tikvMemberManager) syncStatefulSetForTikvCluster(tc *v1alpha1.TikvCl
 		return nil
 	}
 
-	if _, err := tkmm.setStoreLabelsForTiKV(tc); err != nil {
+	// store labels, upgrading, scaling and failover rely on the pods, nodes and pvcs, defer them while the caches are warming up
+	if !tkmm.syncStatus.HasSynced(controller.PodInformer, controller.NodeInformer, controller.PVCInformer) {
+		return controller.RequeueErrorf("TikvCluster: [%s/%s], waiting for the caches of pods, nodes and pvcs to sync tikv statefulset", ns, tcName)
+	}
+
+	force := forceUpgradeAllowed(tc)
+	if _, err := tkmm.setStoreLabelsForTiKV(tc); err != nil && !force {
 		return err
 	}
 
-	if !templateEqual(newSet, oldSet) || tc.Status.TiKV.Phase == v1alpha1.UpgradePhase {
+	if !templateEqual(newSet, oldSet) || tc.Status.TiKV.Phase == v1alpha1.UpgradePhase || force {
 		if err := tkmm.tikvUpgrader.Upgrade(tc, oldSet, newSet); err != nil {
 			return err
 		}
 	}
 
+	if force && !tc.Status.TiKV.Synced {
+		// scaling and failover rely on pd, only the upgrade goes on while pd is down
+		errSTS := updateStatefulSet(tkmm.setControl, tc, newSet, oldSet)
+		return controller.RequeueErrorf("tidbcluster: [%s/%s]'s tikv needs force upgrade, %v", ns, tcName, errSTS)
+	}
+
+	if err := tkmm.cleanupEvictLeaderSchedulers(tc); err != nil {
+		return err
+	}
+
 	if err := tkmm.tikvScaler.Scale(tc, oldSet, newSet); err != nil {
 		return err
 	}
@@ -240,33 +337,240 @@ func (tkmm *tikvMemberManager) syncStatefulSetForTikvCluster(tc *v1alpha1.TikvCl
 			if err := tkmm.tikvFailover.Failover(tc); err != nil {
 				return err
 			}
+		} else if tc.TiKVAllStoresReady() {
+			resetZoneOutageCondition(tc)
+		}
+	}
+
+	if keepLegacyPromAnnotations(newSet, oldSet) {
+		if err := migrateLegacyPromAnnotationsOfPods(tkmm.podLister, tkmm.podControl, tc, label.New().Instance(tc.GetInstanceName()).TiKV()); err != nil {
+			return err
+		}
+	}
+
+	return updateStatefulSetDetectingDrift(tkmm.setControl, tkmm.recorder, tc, v1alpha1.TiKVMemberType, newSet, oldSet)
+}
+
+// resolveTiKVConfigRef returns the config referenced by .tikv.configRef and the configs referenced
+// by .tikv.configLayers by layer name, found is false when a ConfigMap or a key does not exist,
+// which is recorded as a condition.
+func (tkmm *tikvMemberManager) resolveTiKVConfigRef(tc *v1alpha1.TikvCluster) (config string, layers map[string]string, found bool, err error) {
+	if ref := tc.Spec.TiKV.ConfigRef; ref != nil {
+		config, found, err = tkmm.getConfigMapKey(tc, ref)
+		if err != nil || !found {
+			return "", nil, found, err
+		}
+	}
+	for _, layer := range tc.Spec.TiKV.ConfigLayers {
+		if layer.ConfigRef == nil {
+			continue
+		}
+		layerConfig, found, err := tkmm.getConfigMapKey(tc, layer.ConfigRef)
+		if err != nil || !found {
+			return "", nil, found, err
+		}
+		if layers == nil {
+			layers = map[string]string{}
+		}
+		layers[layer.Name] = layerConfig
+	}
+	setConfigRefNotFoundCondition(tc, "", "")
+	return config, layers, true, nil
+}
+
+// getConfigMapKey returns the config stored in the key of the ConfigMap, found is false
+// when the ConfigMap or the key does not exist, which is recorded as a condition.
+func (tkmm *tikvMemberManager) getConfigMapKey(tc *v1alpha1.TikvCluster, ref *v1alpha1.ConfigMapKeyRef) (config string, found bool, err error) {
+	cm, err := tkmm.cmLister.ConfigMaps(tc.GetNamespace()).Get(ref.ConfigMapName)
+	if errors.IsNotFound(err) {
+		setConfigRefNotFoundCondition(tc, utiltikvcluster.ConfigMapNotFound,
+			fmt.Sprintf("configmap %s/%s not found", tc.GetNamespace(), ref.ConfigMapName))
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	config, ok := cm.Data[ref.Key]
+	if !ok {
+		setConfigRefNotFoundCondition(tc, utiltikvcluster.ConfigMapKeyNotFound,
+			fmt.Sprintf("key %s not found in configmap %s/%s", ref.Key, tc.GetNamespace(), ref.ConfigMapName))
+		return "", false, nil
+	}
+	return config, true, nil
+}
+
+// setConfigRefNotFoundCondition sets the ConfigRefNotFound condition when reason is not empty,
+// otherwise it resolves the condition if it has been added.
+func setConfigRefNotFoundCondition(tc *v1alpha1.TikvCluster, reason, message string) {
+	if reason != "" {
+		cond := utiltikvcluster.NewTikvClusterCondition(v1alpha1.ConfigRefNotFound, corev1.ConditionTrue, reason, message)
+		utiltikvcluster.SetTikvClusterCondition(&tc.Status, *cond)
+		return
+	}
+	if utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.ConfigRefNotFound) == nil {
+		return
+	}
+	cond := utiltikvcluster.NewTikvClusterCondition(v1alpha1.ConfigRefNotFound, corev1.ConditionFalse, utiltikvcluster.ConfigRefFound, "")
+	utiltikvcluster.SetTikvClusterCondition(&tc.Status, *cond)
+}
+
+// tikvEncryptionKeys is the master keys of .tikv.encryption
+type tikvEncryptionKeys struct {
+	// hash is the hash of the master keys read from the secret or of the kms config, it is
+	// stored in the configmap so that rotating the master key changes the digest suffix of the
+	// configmap and rolls tikv, and that the encryption enabled is known without echoing the
+	// key id
+	hash string
+	// previous is whether the secret holds the previous master key of a rotation
+	previous bool
+}
+
+// resolveTiKVEncryption reads the master keys of .tikv.encryption from its secret. tikv cannot
+// read the data encrypted without the master key, so once the encryption has been enabled,
+// removing .tikv.encryption is refused unless the disable encryption annotation is set, ok is
+// false when it is refused.
+func (tkmm *tikvMemberManager) resolveTiKVEncryption(tc *v1alpha1.TikvCluster, set *apps.StatefulSet) (keys *tikvEncryptionKeys, ok bool, err error) {
+	ns := tc.GetNamespace()
+	tcName := tc.GetName()
+	encryption := tc.Spec.TiKV.Encryption
+	if encryption == nil {
+		if set == nil || tc.Annotations[label.AnnDisableEncryptionKey] == label.AnnDisableEncryptionVal {
+			return nil, true, nil
+		}
+		enabled, err := tkmm.tikvEncryptionEnabled(set)
+		if err != nil {
+			return nil, false, err
+		}
+		if !enabled {
+			return nil, true, nil
+		}
+		msg := fmt.Sprintf("the encryption at rest of tikv is enabled, removing .tikv.encryption requires the annotation %s: %q as the data encrypted will not be readable",
+			label.AnnDisableEncryptionKey, label.AnnDisableEncryptionVal)
+		klog.Warningf("tikv cluster %s/%s: %s, skip syncing for tikv statefulset", ns, tcName, msg)
+		tkmm.recorder.Event(tc, corev1.EventTypeWarning, "EncryptionRemovalRefused", msg)
+		return nil, false, nil
+	}
+
+	if kms := encryption.KMS; kms != nil {
+		// the events and errors name the secret of the credentials only, never the key id
+		if ref := kms.CredentialsSecretRef; ref != nil {
+			_, err := tkmm.secretLister.Secrets(ns).Get(ref.Name)
+			if errors.IsNotFound(err) {
+				tkmm.recorder.Eventf(tc, corev1.EventTypeWarning, "EncryptionSecretNotFound", "secret %s/%s of the kms credentials not found", ns, ref.Name)
+				return nil, false, controller.RequeueErrorf("TikvCluster: [%s/%s], waiting for the secret %s of the kms credentials", ns, tcName, ref.Name)
+			}
+			if err != nil {
+				return nil, false, err
+			}
+		}
+		hash, err := Sha256Sum(kms)
+		if err != nil {
+			return nil, false, err
 		}
+		return &tikvEncryptionKeys{hash: hash}, true, nil
 	}
 
-	return updateStatefulSet(tkmm.setControl, tc, newSet, oldSet)
+	secretName := encryption.SecretRef.Name
+	secret, err := tkmm.secretLister.Secrets(ns).Get(secretName)
+	if errors.IsNotFound(err) {
+		tkmm.recorder.Eventf(tc, corev1.EventTypeWarning, "EncryptionSecretNotFound", "secret %s/%s of the master key not found", ns, secretName)
+		return nil, false, controller.RequeueErrorf("TikvCluster: [%s/%s], waiting for the secret %s of the master key", ns, tcName, secretName)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	masterKey, ok := secret.Data[tikvMasterKey]
+	if !ok || len(masterKey) == 0 {
+		tkmm.recorder.Eventf(tc, corev1.EventTypeWarning, "EncryptionSecretInvalid", "key %s not found in secret %s/%s", tikvMasterKey, ns, secretName)
+		return nil, false, controller.RequeueErrorf("TikvCluster: [%s/%s], waiting for the key %s in the secret %s", ns, tcName, tikvMasterKey, secretName)
+	}
+	previousMasterKey := secret.Data[tikvPreviousMasterKey]
+	hash, err := Sha256Sum([]string{string(masterKey), string(previousMasterKey)})
+	if err != nil {
+		return nil, false, err
+	}
+	return &tikvEncryptionKeys{hash: hash, previous: len(previousMasterKey) > 0}, true, nil
 }
 
-func (tkmm *tikvMemberManager) syncTiKVConfigMap(tc *v1alpha1.TikvCluster, set *apps.StatefulSet) (*corev1.ConfigMap, error) {
-	// For backward compatibility, only sync tidb configmap when .tikv.config is non-nil
-	if tc.Spec.TiKV.Config == nil {
+// tikvEncryptionEnabled returns whether the tikv statefulset has the encryption at rest enabled,
+// i.e. it mounts the master key secret or its configmap records the hash of the master keys
+func (tkmm *tikvMemberManager) tikvEncryptionEnabled(set *apps.StatefulSet) (bool, error) {
+	if hasVolume(&set.Spec.Template.Spec, tikvEncryptionVolume) {
+		return true, nil
+	}
+	cmName := FindConfigMapVolume(&set.Spec.Template.Spec, func(name string) bool {
+		return strings.HasPrefix(name, set.GetName())
+	})
+	if cmName == "" {
+		return false, nil
+	}
+	cm, err := tkmm.cmLister.ConfigMaps(set.GetNamespace()).Get(cmName)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	_, ok := cm.Data[tikvEncryptionHashKey]
+	return ok, nil
+}
+
+// hasVolume returns whether the pod spec has the volume
+func hasVolume(podSpec *corev1.PodSpec, name string) bool {
+	for _, vol := range podSpec.Volumes {
+		if vol.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (tkmm *tikvMemberManager) syncTiKVConfigMap(tc *v1alpha1.TikvCluster, set *apps.StatefulSet, refConfig string, refLayers map[string]string, encryptionKeys *tikvEncryptionKeys) (*corev1.ConfigMap, error) {
+	// For backward compatibility, only sync tidb configmap when .tikv.config, .tikv.configRef, .tikv.configLayers,
+	// .tikv.encryption or .tikv.additionalConfigFiles is set
+	if tc.Spec.TiKV.Config == nil && tc.Spec.TiKV.ConfigRef == nil && len(tc.Spec.TiKV.ConfigLayers) == 0 && tc.Spec.TiKV.Encryption == nil &&
+		len(tc.Spec.TiKV.AdditionalConfigFiles) == 0 {
 		return nil, nil
 	}
-	newCm, err := getTikVConfigMap(tc)
+	newCm, err := getTikVConfigMap(tc, refConfig, refLayers, encryptionKeys)
 	if err != nil {
 		return nil, err
 	}
-	if set != nil && tc.BaseTiKVSpec().ConfigUpdateStrategy() == v1alpha1.ConfigUpdateStrategyInPlace {
+	if set != nil {
 		inUseName := FindConfigMapVolume(&set.Spec.Template.Spec, func(name string) bool {
 			return strings.HasPrefix(name, controller.TiKVMemberName(tc.Name))
 		})
-		if inUseName != "" {
+		if inUseName != "" && tc.BaseTiKVSpec().ConfigUpdateStrategy() == v1alpha1.ConfigUpdateStrategyInPlace {
 			newCm.Name = inUseName
 		}
+		if inUseName != "" && inUseName != newCm.Name {
+			// the configmap of the new digest carries over the keys users added to the one in use
+			if err := tkmm.copyConfigMapUserData(set.GetNamespace(), inUseName, newCm); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	return tkmm.typedControl.CreateOrUpdateConfigMap(tc, newCm)
 }
 
+// copyConfigMapUserData copies the keys users added to the configmap to cm, the keys of cm win
+func (tkmm *tikvMemberManager) copyConfigMapUserData(ns, name string, cm *corev1.ConfigMap) error {
+	inUse, err := tkmm.cmLister.ConfigMaps(ns).Get(name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for k, v := range controller.ConfigMapUserData(inUse) {
+		if _, ok := cm.Data[k]; !ok {
+			cm.Data[k] = v
+		}
+	}
+	return nil
+}
+
 func getNewServiceForTikvCluster(tc *v1alpha1.TikvCluster, svcConfig SvcConfig) *corev1.Service {
 	ns := tc.Namespace
 	tcName := tc.Name
@@ -321,7 +625,12 @@ func getNewTiKVSetForTikvCluster(tc *v1alpha1.TikvCluster, cm *corev1.ConfigMap)
 	}
 	if tc.IsTLSClusterEnabled() {
 		volMounts = append(volMounts, corev1.VolumeMount{
-			Name: "tikv-tls", ReadOnly: true, MountPath: "/var/lib/tikv-tls",
+			Name: "tikv-tls", ReadOnly: true, MountPath: tikvClusterCertPath,
+		})
+	}
+	if encryption := tc.Spec.TiKV.Encryption; encryption != nil && encryption.SecretRef != nil {
+		volMounts = append(volMounts, corev1.VolumeMount{
+			Name: tikvEncryptionVolume, ReadOnly: true, MountPath: tikvEncryptionPath,
 		})
 	}
 
@@ -332,7 +641,7 @@ func getNewTiKVSetForTikvCluster(tc *v1alpha1.TikvCluster, cm *corev1.ConfigMap)
 				LocalObjectReference: corev1.LocalObjectReference{
 					Name: tikvConfigMap,
 				},
-				Items: []corev1.KeyToPath{{Key: "config-file", Path: "tikv.toml"}},
+				Items: tikvConfigItems(tc),
 			}},
 		},
 		{Name: "startup-script", VolumeSource: corev1.VolumeSource{
@@ -346,14 +655,23 @@ func getNewTiKVSetForTikvCluster(tc *v1alpha1.TikvCluster, cm *corev1.ConfigMap)
 	}
 	if tc.IsTLSClusterEnabled() {
 		vols = append(vols, corev1.Volume{
-			Name: "tikv-tls", VolumeSource: corev1.VolumeSource{
-				Secret: &corev1.SecretVolumeSource{
-					SecretName: util.ClusterTLSSecretName(tc.Name, label.TiKVLabelVal),
-				},
+			Name: "tikv-tls", VolumeSource: tlsSecretVolumeSource(util.ClusterTLSSecretName(tc.Name, label.TiKVLabelVal)),
+		})
+	}
+	if encryption := tc.Spec.TiKV.Encryption; encryption != nil && encryption.SecretRef != nil {
+		vols = append(vols, corev1.Volume{
+			Name: tikvEncryptionVolume, VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: encryption.SecretRef.Name},
 			},
 		})
 	}
 
+	if readOnlyRootFilesystem(baseTiKVSpec.ContainerSecurityContext()) {
+		tmpMount, tmpVolume := tmpMountVolume()
+		volMounts = append(volMounts, tmpMount)
+		vols = append(vols, tmpVolume)
+	}
+
 	sysctls := "sysctl -w"
 	var initContainers []corev1.Container
 	if baseTiKVSpec.Annotations() != nil {
@@ -386,6 +704,10 @@ func getNewTiKVSetForTikvCluster(tc *v1alpha1.TikvCluster, cm *corev1.ConfigMap)
 	if len(initContainers) > 0 {
 		podSecurityContext.Sysctls = []corev1.Sysctl{}
 	}
+	securityContext := containerSecurityContext(baseTiKVSpec.ContainerSecurityContext(), tc.TiKVContainerPrivilege())
+	if init := dataDirInitContainer(podSecurityContext, securityContext, v1alpha1.TiKVMemberType.String(), "/var/lib/tikv"); init != nil {
+		initContainers = append(initContainers, *init)
+	}
 
 	storageRequest, err := controller.ParseStorageRequest(tc.Spec.TiKV.Requests)
 	if err != nil {
@@ -394,7 +716,10 @@ func getNewTiKVSetForTikvCluster(tc *v1alpha1.TikvCluster, cm *corev1.ConfigMap)
 
 	tikvLabel := labelTiKV(tc)
 	setName := controller.TiKVMemberName(tcName)
-	podAnnotations := CombineAnnotations(controller.AnnProm(20180), baseTiKVSpec.Annotations())
+	podAnnotations := controller.MigrateLegacyPromAnnotations(MergeAnnotations(controller.AnnPromTLS(tc.Ports().TiKVStatus, tc.Scheme()), baseTiKVSpec.Annotations()))
+	if hash := tc.Status.TiKV.TLSCertRolloutHash; hash != "" {
+		podAnnotations[label.AnnTLSCertHash] = hash
+	}
 	stsAnnotations := getStsAnnotations(tc, label.TiKVLabelVal)
 	capacity := controller.TiKVCapacity(tc.Spec.TiKV.Limits)
 	headlessSvcName := controller.TiKVPeerMemberName(tcName)
@@ -430,22 +755,23 @@ func getNewTiKVSetForTikvCluster(tc *v1alpha1.TikvCluster, cm *corev1.ConfigMap)
 		Image:           tc.TiKVImage(),
 		ImagePullPolicy: baseTiKVSpec.ImagePullPolicy(),
 		Command:         []string{"/bin/sh", "/usr/local/bin/tikv_start_script.sh"},
-		SecurityContext: &corev1.SecurityContext{
-			Privileged: tc.TiKVContainerPrivilege(),
-		},
+		SecurityContext: securityContext,
 		Ports: []corev1.ContainerPort{
 			{
 				Name:          "server",
-				ContainerPort: int32(20160),
+				ContainerPort: tc.Ports().TiKV,
 				Protocol:      corev1.ProtocolTCP,
 			},
 		},
-		VolumeMounts: volMounts,
-		Resources:    controller.ContainerResource(tc.Spec.TiKV.ResourceRequirements),
+		VolumeMounts:   volMounts,
+		Resources:      controller.ContainerResource(tc.Spec.TiKV.ResourceRequirements),
+		ReadinessProbe: tikvReadinessProbe(tc),
 	}
 	podSpec := baseTiKVSpec.BuildPodSpec()
+	podSpec.TopologySpreadConstraints = topologySpreadConstraints(baseTiKVSpec.TopologySpreadConstraints(), tikvLabel)
 	if baseTiKVSpec.HostNetwork() {
 		podSpec.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+		podSpec.Affinity = hostNetworkAntiAffinity(podSpec.Affinity, tikvLabel)
 		env = append(env, corev1.EnvVar{
 			Name: "POD_NAME",
 			ValueFrom: &corev1.EnvVarSource{
@@ -455,11 +781,25 @@ func getNewTiKVSetForTikvCluster(tc *v1alpha1.TikvCluster, cm *corev1.ConfigMap)
 			},
 		})
 	}
+	if encryption := tc.Spec.TiKV.Encryption; encryption != nil && encryption.KMS != nil && encryption.KMS.CredentialsSecretRef != nil {
+		ref := *encryption.KMS.CredentialsSecretRef
+		env = append(env, corev1.EnvVar{
+			Name: "AWS_ACCESS_KEY_ID",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: ref, Key: tikvKMSAccessKeyID},
+			},
+		}, corev1.EnvVar{
+			Name: "AWS_SECRET_ACCESS_KEY",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: ref, Key: tikvKMSSecretAccessKey},
+			},
+		})
+	}
 	tikvContainer.Env = util.AppendEnv(env, baseTiKVSpec.Env())
 	podSpec.Volumes = vols
 	podSpec.SecurityContext = podSecurityContext
 	podSpec.InitContainers = initContainers
-	podSpec.Containers = []corev1.Container{tikvContainer}
+	podSpec.Containers = append([]corev1.Container{tikvContainer}, baseTiKVSpec.AdditionalContainers()...)
 	podSpec.ServiceAccountName = tc.Spec.TiKV.ServiceAccount
 
 	tikvset := &apps.StatefulSet{
@@ -481,16 +821,11 @@ func getNewTiKVSetForTikvCluster(tc *v1alpha1.TikvCluster, cm *corev1.ConfigMap)
 				Spec: podSpec,
 			},
 			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
-				volumeClaimTemplate(storageRequest, v1alpha1.TiKVMemberType.String(), tc.Spec.TiKV.StorageClassName),
+				tikvVolumeClaimTemplate(tc, storageRequest, v1alpha1.TiKVMemberType.String(), tc.Spec.TiKV.StorageClassName),
 			},
 			ServiceName:         headlessSvcName,
 			PodManagementPolicy: apps.ParallelPodManagement,
-			UpdateStrategy: apps.StatefulSetUpdateStrategy{
-				Type: apps.RollingUpdateStatefulSetStrategyType,
-				RollingUpdate: &apps.RollingUpdateStatefulSetStrategy{
-					Partition: controller.Int32Ptr(tc.TiKVStsDesiredReplicas()),
-				},
-			},
+			UpdateStrategy:      newStatefulSetUpdateStrategy(baseTiKVSpec.StatefulSetUpdateStrategy(), tc.TiKVStsDesiredReplicas()),
 		},
 	}
 	return tikvset, nil
@@ -509,19 +844,63 @@ func volumeClaimTemplate(r corev1.ResourceRequirements, metaName string, storage
 	}
 }
 
-func getTikVConfigMap(tc *v1alpha1.TikvCluster) (*corev1.ConfigMap, error) {
+// tikvVolumeClaimTemplate returns the volume claim template of tikv marked with
+// spec.tikv.pvcReclaimPolicy
+func tikvVolumeClaimTemplate(tc *v1alpha1.TikvCluster, r corev1.ResourceRequirements, metaName string, storageClassName *string) corev1.PersistentVolumeClaim {
+	pvc := volumeClaimTemplate(r, metaName, storageClassName)
+	if policy := tc.Spec.TiKV.PVCReclaimPolicy; policy != "" {
+		util.SetPVCReclaimAnnotation(&pvc, policy == corev1.PersistentVolumeReclaimRetain)
+	}
+	return pvc
+}
 
-	config := tc.Spec.TiKV.Config
-	if config == nil {
-		return nil, nil
+// tikvConfigItems returns the keys of the configmap of tikv mounted into /etc/tikv, the config
+// file and the additional files
+func tikvConfigItems(tc *v1alpha1.TikvCluster) []corev1.KeyToPath {
+	items := []corev1.KeyToPath{{Key: "config-file", Path: "tikv.toml"}}
+	names := make([]string, 0, len(tc.Spec.TiKV.AdditionalConfigFiles))
+	for name := range tc.Spec.TiKV.AdditionalConfigFiles {
+		names = append(names, name)
+	}
+	// sorted so that the pod template is stable
+	sort.Strings(names)
+	for _, name := range names {
+		items = append(items, corev1.KeyToPath{Key: name, Path: name})
 	}
+	return items
+}
 
-	confText, err := MarshalTOML(config)
-	if err != nil {
-		return nil, err
+// getTikVConfigMap renders the configmap of tikv, refConfig is the config read from
+// .tikv.configRef and is used as is when the ref is set and there are no config layers,
+// refLayers are the configs read from the ConfigMaps referenced by .tikv.configLayers,
+// encryptionKeys are the master keys read from the secret of .tikv.encryption
+func getTikVConfigMap(tc *v1alpha1.TikvCluster, refConfig string, refLayers map[string]string, encryptionKeys *tikvEncryptionKeys) (*corev1.ConfigMap, error) {
+
+	var confText []byte
+	if tc.Spec.TiKV.ConfigRef != nil {
+		confText = []byte(refConfig)
+	} else if config := tc.Spec.TiKV.Config; config != nil {
+		var err error
+		confText, err = MarshalTOML(config)
+		if err != nil {
+			return nil, err
+		}
+	} else if len(tc.Spec.TiKV.ConfigLayers) == 0 && !tc.IsTLSClusterEnabled() && tc.Spec.TiKV.Encryption == nil &&
+		len(tc.Spec.TiKV.AdditionalConfigFiles) == 0 {
+		return nil, nil
+	}
+	var provenance map[string]string
+	if len(tc.Spec.TiKV.ConfigLayers) > 0 || tc.IsTLSClusterEnabled() || tc.Spec.TiKV.Encryption != nil {
+		var err error
+		confText, provenance, err = renderTiKVConfigLayers(tc, confText, refLayers, encryptionKeys)
+		if err != nil {
+			return nil, err
+		}
 	}
 	startScript, err := RenderTiKVStartScript(&TiKVStartScriptModel{
-		Scheme: tc.Scheme(),
+		Scheme:        tc.Scheme(),
+		Ports:         tc.Ports(),
+		PDServicePort: tc.PDServicePort(),
 	})
 	if err != nil {
 		return nil, err
@@ -535,11 +914,25 @@ func getTikVConfigMap(tc *v1alpha1.TikvCluster) (*corev1.ConfigMap, error) {
 			Labels:          tikvLabel,
 			OwnerReferences: []metav1.OwnerReference{controller.GetOwnerRef(tc)},
 		},
-		Data: map[string]string{
-			"config-file":    string(confText),
-			"startup-script": startScript,
-		},
+		Data: map[string]string{},
+	}
+	// the names of the additional files are validated not to collide with the keys below
+	for name, content := range tc.Spec.TiKV.AdditionalConfigFiles {
+		cm.Data[name] = content
+	}
+	cm.Data["config-file"] = string(confText)
+	cm.Data["startup-script"] = startScript
+	if provenance != nil {
+		// marshaling a map of strings never fails
+		b, _ := json.Marshal(provenance)
+		cm.Annotations = map[string]string{label.AnnConfigProvenance: string(b)}
+	}
+	if tc.Spec.TiKV.Encryption != nil && encryptionKeys != nil {
+		// not mounted, it only changes the digest of the configmap when the master key is rotated
+		cm.Data[tikvEncryptionHashKey] = encryptionKeys.hash
 	}
+	// the keys users add to the configmap are neither overwritten nor part of the digest
+	controller.SetConfigMapManagedKeys(cm)
 
 	if tc.BaseTiKVSpec().ConfigUpdateStrategy() == v1alpha1.ConfigUpdateStrategyRollingUpdate {
 		if err := AddConfigMapDigestSuffix(cm); err != nil {
@@ -561,6 +954,9 @@ func (tkmm *tikvMemberManager) syncTikvClusterStatus(tc *v1alpha1.TikvCluster, s
 		return nil
 	}
 	tc.Status.TiKV.StatefulSet = &set.Status
+	tc.Status.TiKV.CurrentRevision = set.Status.CurrentRevision
+	tc.Status.TiKV.UpdateRevision = set.Status.UpdateRevision
+	tc.Status.TiKV.UpgradedReplicas = set.Status.UpdatedReplicas
 	upgrading, err := tkmm.tikvStatefulSetIsUpgradingFn(tkmm.podLister, tkmm.pdControl, set, tc)
 	if err != nil {
 		return err
@@ -568,7 +964,16 @@ func (tkmm *tikvMemberManager) syncTikvClusterStatus(tc *v1alpha1.TikvCluster, s
 	if upgrading && tc.Status.PD.Phase != v1alpha1.UpgradePhase {
 		tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
 	} else {
+		// the upgrade of tikv waits for pd, the pod being upgraded is picked again afterwards
 		tc.Status.TiKV.Phase = v1alpha1.NormalPhase
+		tc.Status.TiKV.UpgradingOrdinal = nil
+	}
+	if !upgrading {
+		tc.Status.TiKV.UpdatedOrdinals = nil
+		tc.Status.TiKV.RollingBackFrom = ""
+		tc.Status.TiKV.LastUpgradedPod = nil
+		tc.Status.TiKV.UpgradingOrdinals = nil
+		resetUpgradeStalledCondition(tc)
 	}
 
 	previousStores := tc.Status.TiKV.Stores
@@ -576,8 +981,14 @@ func (tkmm *tikvMemberManager) syncTikvClusterStatus(tc *v1alpha1.TikvCluster, s
 	tombstoneStores := map[string]v1alpha1.TiKVStore{}
 
 	pdCli := controller.GetPDClient(tkmm.pdControl, tc)
+	// The stores feed the decisions to upgrade, scale in and fail over, which must not
+	// be made on a cached state, e.g. an upgraded store cached as Up before it restarted
+	opts := []pdapi.GetOption{pdapi.StatusOnly}
+	if upgrading || tikvStoresChanging(tc, set) {
+		opts = []pdapi.GetOption{pdapi.NoCache}
+	}
 	// This only returns Up/Down/Offline stores
-	storesInfo, err := pdCli.GetStores()
+	storesInfo, err := pdCli.GetStores(opts...)
 	if err != nil {
 		tc.Status.TiKV.Synced = false
 		return err
@@ -607,7 +1018,7 @@ func (tkmm *tikvMemberManager) syncTikvClusterStatus(tc *v1alpha1.TikvCluster, s
 
 		oldStore, exist := previousStores[status.ID]
 
-		status.LastTransitionTime = metav1.Now()
+		status.LastTransitionTime = metav1.NewTime(tkmm.clock.Now())
 		if exist && status.State == oldStore.State {
 			status.LastTransitionTime = oldStore.LastTransitionTime
 		}
@@ -643,6 +1054,15 @@ func (tkmm *tikvMemberManager) syncTikvClusterStatus(tc *v1alpha1.TikvCluster, s
 	return nil
 }
 
+// tikvStoresChanging returns whether the tikv cluster is being scaled or a store is not Up,
+// i.e. the stores may be deleted or failed over
+func tikvStoresChanging(tc *v1alpha1.TikvCluster, set *apps.StatefulSet) bool {
+	if set.Spec.Replicas != nil && *set.Spec.Replicas != tc.TiKVStsDesiredReplicas() {
+		return true
+	}
+	return !tc.TiKVAllStoresReady()
+}
+
 func (tkmm *tikvMemberManager) getTiKVStore(store *pdapi.StoreInfo) *v1alpha1.TiKVStore {
 	if store.Store == nil || store.Status == nil {
 		return nil
@@ -658,7 +1078,136 @@ func (tkmm *tikvMemberManager) getTiKVStore(store *pdapi.StoreInfo) *v1alpha1.Ti
 		LeaderCount:       int32(store.Status.LeaderCount),
 		State:             store.Store.StateName,
 		LastHeartbeatTime: metav1.Time{Time: store.Status.LastHeartbeatTS},
+		Labels:            storeLabels(store.Store.GetLabels()),
+	}
+}
+
+// storeLabels returns the labels of a store in pd as a map, nil if the store has no label
+func storeLabels(labels []*metapb.StoreLabel) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	ls := make(map[string]string, len(labels))
+	for _, l := range labels {
+		ls[l.GetKey()] = l.GetValue()
+	}
+	return ls
+}
+
+// cleanupEvictLeaderSchedulers removes the evict leader schedulers left in pd by an upgrade which
+// was aborted, e.g. the operator restarted or the spec was reverted while the leaders were being
+// evicted, which would keep the stores from getting any leader. Only the schedulers of the stores
+// of the cluster are removed, and only while neither pd nor tikv is being upgraded.
+func (tkmm *tikvMemberManager) cleanupEvictLeaderSchedulers(tc *v1alpha1.TikvCluster) error {
+	if tc.Status.TiKV.Phase == v1alpha1.UpgradePhase || tc.Status.PD.Phase == v1alpha1.UpgradePhase || !tc.Status.TiKV.Synced {
+		return nil
+	}
+	pdCli := controller.GetPDClient(tkmm.pdControl, tc)
+	schedulers, err := pdCli.GetEvictLeaderSchedulers()
+	if err != nil {
+		return err
 	}
+	for _, scheduler := range schedulers {
+		storeID, ok := pdapi.GetEvictLeaderSchedulerStoreID(scheduler)
+		if !ok {
+			continue
+		}
+		id := strconv.FormatUint(storeID, 10)
+		store, exist := tc.Status.TiKV.Stores[id]
+		if !exist {
+			store, exist = tc.Status.TiKV.TombstoneStores[id]
+		}
+		if !exist {
+			continue
+		}
+		if err := pdCli.EndEvictLeader(storeID); err != nil {
+			return err
+		}
+		klog.Infof("tikv cluster %s/%s: removed the leftover scheduler %s of store %s (pod %s)", tc.GetNamespace(), tc.GetName(), scheduler, id, store.PodName)
+	}
+	return nil
+}
+
+// syncOfflineStores sets the stores listed in .tikv.offlineStores offline in PD and records
+// their progress in the status, the scaler removes their pods once they become tombstone.
+// The stores removed from the list before becoming tombstone are set up again.
+func (tkmm *tikvMemberManager) syncOfflineStores(tc *v1alpha1.TikvCluster, set *apps.StatefulSet) error {
+	ns := tc.GetNamespace()
+	tcName := tc.GetName()
+	pdCli := controller.GetPDClient(tkmm.pdControl, tc)
+
+	listed := sets.NewString(tc.Spec.TiKV.OfflineStores...)
+	for entry, store := range tc.Status.TiKV.OfflineStores {
+		if listed.Has(entry) {
+			continue
+		}
+		if store.Phase == v1alpha1.OfflineStorePhaseOffline {
+			id, err := strconv.ParseUint(store.ID, 10, 64)
+			if err != nil {
+				return err
+			}
+			if err := pdCli.SetStoreState(id, v1alpha1.TiKVStateUp); err != nil {
+				return err
+			}
+			klog.Infof("tikv cluster %s/%s: offline of store %s (pod %s) is cancelled", ns, tcName, store.ID, store.PodName)
+		}
+		delete(tc.Status.TiKV.OfflineStores, entry)
+	}
+
+	if len(tc.Spec.TiKV.OfflineStores) == 0 {
+		return nil
+	}
+	if tc.Status.TiKV.OfflineStores == nil {
+		tc.Status.TiKV.OfflineStores = map[string]v1alpha1.TiKVOfflineStore{}
+	}
+	podOrdinals := helper.GetPodOrdinals(*set.Spec.Replicas, set)
+	for _, entry := range tc.Spec.TiKV.OfflineStores {
+		store, ok := tc.Status.TiKV.OfflineStores[entry]
+		if !ok {
+			store, ok = resolveOfflineStore(tc, entry)
+			if !ok {
+				klog.Warningf("tikv cluster %s/%s: store %s in offlineStores is not found", ns, tcName, entry)
+				continue
+			}
+		}
+		ordinal, err := util.GetOrdinalFromPodName(store.PodName)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := tc.Status.TiKV.TombstoneStores[store.ID]; ok {
+			store.Phase = v1alpha1.OfflineStorePhaseTombstone
+		} else if store.Phase != v1alpha1.OfflineStorePhaseTombstone && store.Phase != v1alpha1.OfflineStorePhaseRemoved {
+			if s, ok := tc.Status.TiKV.Stores[store.ID]; ok && s.State != v1alpha1.TiKVStateOffline {
+				id, err := strconv.ParseUint(store.ID, 10, 64)
+				if err != nil {
+					return err
+				}
+				if err := pdCli.DeleteStore(id); err != nil {
+					return err
+				}
+				klog.Infof("tikv cluster %s/%s: set store %s (pod %s) offline", ns, tcName, store.ID, store.PodName)
+			}
+			store.Phase = v1alpha1.OfflineStorePhaseOffline
+		}
+		if store.Phase == v1alpha1.OfflineStorePhaseTombstone && !podOrdinals.Has(ordinal) {
+			store.Phase = v1alpha1.OfflineStorePhaseRemoved
+		}
+		tc.Status.TiKV.OfflineStores[entry] = store
+	}
+	return nil
+}
+
+// resolveOfflineStore finds the store of an entry of .tikv.offlineStores, which is either a store ID or a pod name
+func resolveOfflineStore(tc *v1alpha1.TikvCluster, entry string) (v1alpha1.TiKVOfflineStore, bool) {
+	for _, stores := range []map[string]v1alpha1.TiKVStore{tc.Status.TiKV.Stores, tc.Status.TiKV.TombstoneStores} {
+		for id, store := range stores {
+			if id == entry || store.PodName == entry {
+				return v1alpha1.TiKVOfflineStore{ID: id, PodName: store.PodName}, true
+			}
+		}
+	}
+	return v1alpha1.TiKVOfflineStore{}, false
 }
 
 func (tkmm *tikvMemberManager) setStoreLabelsForTiKV(tc *v1alpha1.TikvCluster) (int, error) {
@@ -768,8 +1317,7 @@ func tikvStatefulSetIsUpgrading(podLister corelisters.PodLister, pdControl pdapi
 	if statefulSetIsUpgrading(set) {
 		return true, nil
 	}
-	instanceName := tc.GetInstanceName()
-	selector, err := label.New().Instance(instanceName).TiKV().Selector()
+	selector, err := controller.MemberSelector(tc, v1alpha1.TiKVMemberType)
 	if err != nil {
 		return false, err
 	}
@@ -791,7 +1339,8 @@ func tikvStatefulSetIsUpgrading(podLister corelisters.PodLister, pdControl pdapi
 }
 
 type FakeTiKVMemberManager struct {
-	err error
+	err       error
+	statusErr error
 }
 
 func NewFakeTiKVMemberManager() *FakeTiKVMemberManager {
@@ -802,6 +1351,18 @@ func (ftmm *FakeTiKVMemberManager) SetSyncError(err error) {
 	ftmm.err = err
 }
 
+func (ftmm *FakeTiKVMemberManager) SetSyncStatusError(err error) {
+	ftmm.statusErr = err
+}
+
+func (ftmm *FakeTiKVMemberManager) SyncStatus(tc *v1alpha1.TikvCluster) error {
+	if ftmm.statusErr != nil {
+		return ftmm.statusErr
+	}
+	tc.Status.TiKV.Synced = true
+	return nil
+}
+
 func (ftmm *FakeTiKVMemberManager) Sync(tc *v1alpha1.TikvCluster) error {
 	if ftmm.err != nil {
 		return ftmm.err
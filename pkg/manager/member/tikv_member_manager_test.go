@@ -14,6 +14,7 @@
 package member
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"testing"
@@ -23,22 +24,24 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
-	"github.com/tikv/tikv-operator/pkg/client/clientset/versioned/fake"
-	informers "github.com/tikv/tikv-operator/pkg/client/informers/externalversions"
 	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/deps"
 	"github.com/tikv/tikv-operator/pkg/label"
 	"github.com/tikv/tikv-operator/pkg/pdapi"
+	utiltikvcluster "github.com/tikv/tikv-operator/pkg/util/tikvcluster"
 	apps "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	kubeinformers "k8s.io/client-go/informers"
 	kubefake "k8s.io/client-go/kubernetes/fake"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/pointer"
 )
 
@@ -50,6 +53,7 @@ func TestTiKVMemberManagerSyncCreate(t *testing.T) {
 		errWhenCreateStatefulSet     bool
 		errWhenCreateTiKVPeerService bool
 		errWhenGetStores             bool
+		podCacheNotSynced            bool
 		err                          bool
 		tikvPeerSvcCreated           bool
 		setCreated                   bool
@@ -105,6 +109,9 @@ func TestTiKVMemberManagerSyncCreate(t *testing.T) {
 		if test.errWhenCreateTiKVPeerService {
 			fakeSvcControl.SetCreateServiceError(errors.NewInternalError(fmt.Errorf("API server failed")), 0)
 		}
+		if test.podCacheNotSynced {
+			tkmm.syncStatus.Add(controller.PodInformer, func() bool { return false })
+		}
 
 		err := tkmm.Sync(tc)
 		if test.err {
@@ -144,6 +151,18 @@ func TestTiKVMemberManagerSyncCreate(t *testing.T) {
 			pdStores:                     &pdapi.StoresInfo{Count: 0, Stores: []*pdapi.StoreInfo{}},
 			tombstoneStores:              &pdapi.StoresInfo{Count: 0, Stores: []*pdapi.StoreInfo{}},
 		},
+		{
+			name:                         "pod cache is not synced",
+			prepare:                      nil,
+			errWhenCreateStatefulSet:     false,
+			errWhenCreateTiKVPeerService: false,
+			podCacheNotSynced:            true,
+			err:                          false,
+			tikvPeerSvcCreated:           true,
+			setCreated:                   true,
+			pdStores:                     &pdapi.StoresInfo{Count: 0, Stores: []*pdapi.StoreInfo{}},
+			tombstoneStores:              &pdapi.StoresInfo{Count: 0, Stores: []*pdapi.StoreInfo{}},
+		},
 		{
 			name: "pd is not available",
 			prepare: func(tc *v1alpha1.TikvCluster) {
@@ -152,7 +171,7 @@ func TestTiKVMemberManagerSyncCreate(t *testing.T) {
 			errWhenCreateStatefulSet:     false,
 			errWhenCreateTiKVPeerService: false,
 			err:                          true,
-			tikvPeerSvcCreated:           false,
+			tikvPeerSvcCreated:           true,
 			setCreated:                   false,
 			pdStores:                     &pdapi.StoresInfo{Count: 0, Stores: []*pdapi.StoreInfo{}},
 			tombstoneStores:              &pdapi.StoresInfo{Count: 0, Stores: []*pdapi.StoreInfo{}},
@@ -186,6 +205,368 @@ func TestTiKVMemberManagerSyncCreate(t *testing.T) {
 	}
 }
 
+func TestTiKVMemberManagerSyncRecreateDeletedObjects(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name          string
+		pdUnavailable bool
+		delete        func(*v1alpha1.TikvCluster, *controller.FakeServiceControl, *controller.FakeGenericControl)
+	}{
+		{
+			name: "tikv peer service",
+			delete: func(tc *v1alpha1.TikvCluster, svcControl *controller.FakeServiceControl, _ *controller.FakeGenericControl) {
+				svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: controller.TiKVPeerMemberName(tc.Name), Namespace: tc.Namespace}}
+				g.Expect(svcControl.SvcIndexer.Delete(svc)).To(Succeed())
+			},
+		},
+		{
+			name:          "tikv peer service when pd is not available",
+			pdUnavailable: true,
+			delete: func(tc *v1alpha1.TikvCluster, svcControl *controller.FakeServiceControl, _ *controller.FakeGenericControl) {
+				svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: controller.TiKVPeerMemberName(tc.Name), Namespace: tc.Namespace}}
+				g.Expect(svcControl.SvcIndexer.Delete(svc)).To(Succeed())
+			},
+		},
+		{
+			name: "tikv configmap",
+			delete: func(_ *v1alpha1.TikvCluster, _ *controller.FakeServiceControl, genericControl *controller.FakeGenericControl) {
+				cmList := &corev1.ConfigMapList{}
+				g.Expect(genericControl.FakeCli.List(context.TODO(), cmList)).To(Succeed())
+				g.Expect(cmList.Items).To(HaveLen(1))
+				g.Expect(genericControl.FakeCli.Delete(context.TODO(), &cmList.Items[0])).To(Succeed())
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvClusterForPD()
+			tc.Spec.TiKV.Config = &v1alpha1.TiKVConfig{}
+			tc.Status.PD.Members = map[string]v1alpha1.PDMember{
+				"pd-0": {Name: "pd-0", Health: true},
+				"pd-1": {Name: "pd-1", Health: true},
+				"pd-2": {Name: "pd-2", Health: true},
+			}
+			tc.Status.PD.StatefulSet = &apps.StatefulSetStatus{ReadyReplicas: 3}
+			ns := tc.Namespace
+			tcName := tc.Name
+
+			tkmm, _, fakeSvcControl, pdClient, _, _ := newFakeTiKVMemberManager(tc)
+			pdClient.AddReaction(pdapi.GetConfigActionType, func(action *pdapi.Action) (interface{}, error) {
+				return &v1alpha1.PDConfig{}, nil
+			})
+			pdClient.AddReaction(pdapi.GetStoresActionType, func(action *pdapi.Action) (interface{}, error) {
+				return &pdapi.StoresInfo{Count: 0, Stores: []*pdapi.StoreInfo{}}, nil
+			})
+			pdClient.AddReaction(pdapi.GetTombStoneStoresActionType, func(action *pdapi.Action) (interface{}, error) {
+				return &pdapi.StoresInfo{Count: 0, Stores: []*pdapi.StoreInfo{}}, nil
+			})
+			genericControl := controller.NewFakeGenericControl()
+			tkmm.typedControl = controller.NewTypedControl(genericControl)
+
+			g.Expect(tkmm.Sync(tc)).To(Succeed())
+
+			tt.delete(tc, fakeSvcControl, genericControl)
+			if tt.pdUnavailable {
+				tc.Status.PD.Members = map[string]v1alpha1.PDMember{}
+			}
+
+			// the result does not matter, the deleted object must be recreated by a single sync
+			_ = tkmm.Sync(tc)
+
+			_, err := tkmm.svcLister.Services(ns).Get(controller.TiKVPeerMemberName(tcName))
+			g.Expect(err).NotTo(HaveOccurred())
+			cmList := &corev1.ConfigMapList{}
+			g.Expect(genericControl.FakeCli.List(context.TODO(), cmList)).To(Succeed())
+			g.Expect(cmList.Items).To(HaveLen(1))
+		})
+	}
+}
+
+func TestTiKVMemberManagerSyncConfigRef(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForPD()
+	tc.Spec.ConfigUpdateStrategy = v1alpha1.ConfigUpdateStrategyRollingUpdate
+	tc.Spec.TiKV.ConfigRef = &v1alpha1.ConfigMapKeyRef{ConfigMapName: "tikv-config", Key: "config.toml"}
+	tc.Status.PD.Members = map[string]v1alpha1.PDMember{
+		"pd-0": {Name: "pd-0", Health: true},
+		"pd-1": {Name: "pd-1", Health: true},
+		"pd-2": {Name: "pd-2", Health: true},
+	}
+	tc.Status.PD.StatefulSet = &apps.StatefulSetStatus{ReadyReplicas: 3}
+	ns := tc.Namespace
+	tcName := tc.Name
+
+	tkmm, _, _, pdClient, _, _ := newFakeTiKVMemberManager(tc)
+	pdClient.AddReaction(pdapi.GetStoresActionType, func(action *pdapi.Action) (interface{}, error) {
+		return &pdapi.StoresInfo{Count: 0, Stores: []*pdapi.StoreInfo{}}, nil
+	})
+	pdClient.AddReaction(pdapi.GetTombStoneStoresActionType, func(action *pdapi.Action) (interface{}, error) {
+		return &pdapi.StoresInfo{Count: 0, Stores: []*pdapi.StoreInfo{}}, nil
+	})
+	genericControl := controller.NewFakeGenericControl()
+	tkmm.typedControl = controller.NewTypedControl(genericControl)
+	cmInformer := kubeinformers.NewSharedInformerFactory(kubefake.NewSimpleClientset(), 0).Core().V1().ConfigMaps()
+	tkmm.cmLister = cmInformer.Lister()
+	cmIndexer := cmInformer.Informer().GetIndexer()
+
+	expectCondition := func(status corev1.ConditionStatus, reason string) {
+		cond := utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.ConfigRefNotFound)
+		g.Expect(cond).NotTo(BeNil())
+		g.Expect(cond.Status).To(Equal(status))
+		g.Expect(cond.Reason).To(Equal(reason))
+	}
+	listConfigMaps := func() []corev1.ConfigMap {
+		cmList := &corev1.ConfigMapList{}
+		g.Expect(genericControl.FakeCli.List(context.TODO(), cmList)).To(Succeed())
+		return cmList.Items
+	}
+
+	// the configmap does not exist
+	g.Expect(tkmm.Sync(tc)).To(Succeed())
+	expectCondition(corev1.ConditionTrue, utiltikvcluster.ConfigMapNotFound)
+	_, err := tkmm.setLister.StatefulSets(ns).Get(controller.TiKVMemberName(tcName))
+	g.Expect(errors.IsNotFound(err)).To(BeTrue())
+
+	// the key does not exist
+	refCm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "tikv-config", Namespace: ns},
+		Data:       map[string]string{"other.toml": ""},
+	}
+	g.Expect(cmIndexer.Add(refCm)).To(Succeed())
+	g.Expect(tkmm.Sync(tc)).To(Succeed())
+	expectCondition(corev1.ConditionTrue, utiltikvcluster.ConfigMapKeyNotFound)
+
+	// the referenced config is rendered as is
+	refCm = refCm.DeepCopy()
+	refCm.Data["config.toml"] = "[raftstore]\n  sync-log = false\n"
+	g.Expect(cmIndexer.Update(refCm)).To(Succeed())
+	g.Expect(tkmm.Sync(tc)).To(Succeed())
+	expectCondition(corev1.ConditionFalse, utiltikvcluster.ConfigRefFound)
+	_, err = tkmm.setLister.StatefulSets(ns).Get(controller.TiKVMemberName(tcName))
+	g.Expect(err).NotTo(HaveOccurred())
+	cms := listConfigMaps()
+	g.Expect(cms).To(HaveLen(1))
+	g.Expect(cms[0].Data["config-file"]).To(Equal(refCm.Data["config.toml"]))
+
+	// editing the referenced config changes the content hash
+	refCm = refCm.DeepCopy()
+	refCm.Data["config.toml"] = "[raftstore]\n  sync-log = true\n"
+	g.Expect(cmIndexer.Update(refCm)).To(Succeed())
+	_ = tkmm.Sync(tc)
+	cms = listConfigMaps()
+	g.Expect(cms).To(HaveLen(2))
+	g.Expect(cms[0].Name).NotTo(Equal(cms[1].Name))
+}
+
+func TestTiKVMemberManagerSyncOfflineStores(t *testing.T) {
+	g := NewGomegaWithT(t)
+	type testcase struct {
+		name             string
+		offlineStores    []string
+		stores           map[string]v1alpha1.TiKVStore
+		tombstoneStores  map[string]v1alpha1.TiKVStore
+		status           map[string]v1alpha1.TiKVOfflineStore
+		expectDeleted    []uint64
+		expectSetUp      []uint64
+		expectStatus     map[string]v1alpha1.TiKVOfflineStore
+		expectDeleteSlot []int32
+	}
+
+	tc := newTikvClusterForPD()
+	podName := func(ordinal int32) string {
+		return TikvPodName(tc.GetName(), ordinal)
+	}
+
+	tests := []testcase{
+		{
+			name:          "offline store by pod name",
+			offlineStores: []string{podName(1)},
+			stores: map[string]v1alpha1.TiKVStore{
+				"1": {ID: "1", PodName: podName(0), State: v1alpha1.TiKVStateUp},
+				"2": {ID: "2", PodName: podName(1), State: v1alpha1.TiKVStateUp},
+			},
+			expectDeleted: []uint64{2},
+			expectStatus: map[string]v1alpha1.TiKVOfflineStore{
+				podName(1): {ID: "2", PodName: podName(1), Phase: v1alpha1.OfflineStorePhaseOffline},
+			},
+			expectDeleteSlot: []int32{1},
+		},
+		{
+			name:          "offline store by store id which is already offline",
+			offlineStores: []string{"2"},
+			stores: map[string]v1alpha1.TiKVStore{
+				"2": {ID: "2", PodName: podName(1), State: v1alpha1.TiKVStateOffline},
+			},
+			expectStatus: map[string]v1alpha1.TiKVOfflineStore{
+				"2": {ID: "2", PodName: podName(1), Phase: v1alpha1.OfflineStorePhaseOffline},
+			},
+			expectDeleteSlot: []int32{1},
+		},
+		{
+			name:          "store becomes tombstone",
+			offlineStores: []string{"2"},
+			tombstoneStores: map[string]v1alpha1.TiKVStore{
+				"2": {ID: "2", PodName: podName(1), State: v1alpha1.TiKVStateTombstone},
+			},
+			status: map[string]v1alpha1.TiKVOfflineStore{
+				"2": {ID: "2", PodName: podName(1), Phase: v1alpha1.OfflineStorePhaseOffline},
+			},
+			expectStatus: map[string]v1alpha1.TiKVOfflineStore{
+				"2": {ID: "2", PodName: podName(1), Phase: v1alpha1.OfflineStorePhaseTombstone},
+			},
+			expectDeleteSlot: []int32{1},
+		},
+		{
+			name:          "pod of the tombstone store is removed",
+			offlineStores: []string{"6"},
+			tombstoneStores: map[string]v1alpha1.TiKVStore{
+				"6": {ID: "6", PodName: podName(5), State: v1alpha1.TiKVStateTombstone},
+			},
+			status: map[string]v1alpha1.TiKVOfflineStore{
+				"6": {ID: "6", PodName: podName(5), Phase: v1alpha1.OfflineStorePhaseTombstone},
+			},
+			expectStatus: map[string]v1alpha1.TiKVOfflineStore{
+				"6": {ID: "6", PodName: podName(5), Phase: v1alpha1.OfflineStorePhaseRemoved},
+			},
+			expectDeleteSlot: []int32{5},
+		},
+		{
+			name: "cancel offline before tombstone",
+			stores: map[string]v1alpha1.TiKVStore{
+				"2": {ID: "2", PodName: podName(1), State: v1alpha1.TiKVStateOffline},
+			},
+			status: map[string]v1alpha1.TiKVOfflineStore{
+				"2": {ID: "2", PodName: podName(1), Phase: v1alpha1.OfflineStorePhaseOffline},
+			},
+			expectSetUp:      []uint64{2},
+			expectStatus:     map[string]v1alpha1.TiKVOfflineStore{},
+			expectDeleteSlot: []int32{},
+		},
+		{
+			name: "entry removed after tombstone",
+			tombstoneStores: map[string]v1alpha1.TiKVStore{
+				"2": {ID: "2", PodName: podName(1), State: v1alpha1.TiKVStateTombstone},
+			},
+			status: map[string]v1alpha1.TiKVOfflineStore{
+				"2": {ID: "2", PodName: podName(1), Phase: v1alpha1.OfflineStorePhaseTombstone},
+			},
+			expectStatus:     map[string]v1alpha1.TiKVOfflineStore{},
+			expectDeleteSlot: []int32{},
+		},
+		{
+			name:          "store not found",
+			offlineStores: []string{"100"},
+			stores: map[string]v1alpha1.TiKVStore{
+				"2": {ID: "2", PodName: podName(1), State: v1alpha1.TiKVStateUp},
+			},
+			expectStatus:     map[string]v1alpha1.TiKVOfflineStore{},
+			expectDeleteSlot: []int32{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := tc.DeepCopy()
+			tc.Spec.TiKV.OfflineStores = tt.offlineStores
+			tc.Status.TiKV.Stores = tt.stores
+			tc.Status.TiKV.TombstoneStores = tt.tombstoneStores
+			tc.Status.TiKV.OfflineStores = tt.status
+
+			tkmm, _, _, pdClient, _, _ := newFakeTiKVMemberManager(tc)
+			var deleted, setUp []uint64
+			pdClient.AddReaction(pdapi.DeleteStoreActionType, func(action *pdapi.Action) (interface{}, error) {
+				deleted = append(deleted, action.ID)
+				return nil, nil
+			})
+			pdClient.AddReaction(pdapi.SetStoreStateActionType, func(action *pdapi.Action) (interface{}, error) {
+				setUp = append(setUp, action.ID)
+				return nil, nil
+			})
+
+			g.Expect(tkmm.syncOfflineStores(tc, newStatefulSetForPDScale())).To(Succeed())
+			g.Expect(deleted).To(Equal(tt.expectDeleted))
+			g.Expect(setUp).To(Equal(tt.expectSetUp))
+			if len(tt.expectStatus) == 0 {
+				g.Expect(tc.Status.TiKV.OfflineStores).To(BeEmpty())
+			} else {
+				g.Expect(tc.Status.TiKV.OfflineStores).To(Equal(tt.expectStatus))
+			}
+			g.Expect(tc.TiKVOfflineStoreOrdinals().List()).To(ConsistOf(tt.expectDeleteSlot))
+		})
+	}
+}
+
+func TestTiKVMemberManagerCleanupEvictLeaderSchedulers(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name          string
+		tikvPhase     v1alpha1.MemberPhase
+		pdPhase       v1alpha1.MemberPhase
+		synced        bool
+		schedulers    []string
+		expectRemoved []uint64
+	}{
+		{
+			name:          "leftover schedulers of the stores of the cluster",
+			synced:        true,
+			schedulers:    []string{"evict-leader-scheduler-1", "evict-leader-scheduler-3"},
+			expectRemoved: []uint64{1, 3},
+		},
+		{
+			name:       "schedulers of other stores are kept",
+			synced:     true,
+			schedulers: []string{"evict-leader-scheduler-100", "evict-leader-scheduler"},
+		},
+		{
+			name:       "tikv is upgrading",
+			tikvPhase:  v1alpha1.UpgradePhase,
+			synced:     true,
+			schedulers: []string{"evict-leader-scheduler-1"},
+		},
+		{
+			name:       "pd is upgrading",
+			pdPhase:    v1alpha1.UpgradePhase,
+			synced:     true,
+			schedulers: []string{"evict-leader-scheduler-1"},
+		},
+		{
+			name:       "tikv status is not synced",
+			schedulers: []string{"evict-leader-scheduler-1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvClusterForPD()
+			tc.Status.TiKV.Phase = tt.tikvPhase
+			tc.Status.PD.Phase = tt.pdPhase
+			tc.Status.TiKV.Synced = tt.synced
+			tc.Status.TiKV.Stores = map[string]v1alpha1.TiKVStore{
+				"1": {ID: "1", PodName: TikvPodName(tc.GetName(), 0), State: v1alpha1.TiKVStateUp},
+				"2": {ID: "2", PodName: TikvPodName(tc.GetName(), 1), State: v1alpha1.TiKVStateUp},
+			}
+			tc.Status.TiKV.TombstoneStores = map[string]v1alpha1.TiKVStore{
+				"3": {ID: "3", PodName: TikvPodName(tc.GetName(), 2), State: v1alpha1.TiKVStateTombstone},
+			}
+
+			tkmm, _, _, pdClient, _, _ := newFakeTiKVMemberManager(tc)
+			var removed []uint64
+			pdClient.AddReaction(pdapi.GetEvictLeaderSchedulersActionType, func(action *pdapi.Action) (interface{}, error) {
+				return tt.schedulers, nil
+			})
+			pdClient.AddReaction(pdapi.EndEvictLeaderActionType, func(action *pdapi.Action) (interface{}, error) {
+				removed = append(removed, action.ID)
+				return nil, nil
+			})
+
+			g.Expect(tkmm.cleanupEvictLeaderSchedulers(tc)).To(Succeed())
+			g.Expect(removed).To(Equal(tt.expectRemoved))
+		})
+	}
+}
+
 func TestTiKVMemberManagerSyncUpdate(t *testing.T) {
 	g := NewGomegaWithT(t)
 	type testcase struct {
@@ -1437,36 +1818,15 @@ func TestTiKVMemberManagerSyncTikvClusterStatus(t *testing.T) {
 func newFakeTiKVMemberManager(tc *v1alpha1.TikvCluster) (
 	*tikvMemberManager, *controller.FakeStatefulSetControl,
 	*controller.FakeServiceControl, *pdapi.FakePDClient, cache.Indexer, cache.Indexer) {
-	cli := fake.NewSimpleClientset()
-	kubeCli := kubefake.NewSimpleClientset()
-	pdControl := pdapi.NewFakePDControl(kubeCli)
-	pdClient := controller.NewFakePDClient(pdControl, tc)
-	setInformer := kubeinformers.NewSharedInformerFactory(kubeCli, 0).Apps().V1().StatefulSets()
-	svcInformer := kubeinformers.NewSharedInformerFactory(kubeCli, 0).Core().V1().Services()
-	epsInformer := kubeinformers.NewSharedInformerFactory(kubeCli, 0).Core().V1().Endpoints()
-	tcInformer := informers.NewSharedInformerFactory(cli, 0).Tikv().V1alpha1().TikvClusters()
-	setControl := controller.NewFakeStatefulSetControl(setInformer, tcInformer)
-	svcControl := controller.NewFakeServiceControl(svcInformer, epsInformer, tcInformer)
-	podInformer := kubeinformers.NewSharedInformerFactory(kubeCli, 0).Core().V1().Pods()
-	nodeInformer := kubeinformers.NewSharedInformerFactory(kubeCli, 0).Core().V1().Nodes()
-	tikvScaler := NewFakeTiKVScaler()
-	tikvUpgrader := NewFakeTiKVUpgrader()
-	genericControl := controller.NewFakeGenericControl()
+	d := deps.NewFakeDependencies()
+	pdClient := controller.NewFakePDClient(d.PDControl.(*pdapi.FakePDControl), tc)
+	podInformer := d.KubeInformerFactory.Core().V1().Pods()
+	nodeInformer := d.KubeInformerFactory.Core().V1().Nodes()
+	autoFailover := false
+	tmm := NewTiKVMemberManager(d, autoFailover, nil, NewFakeTiKVScaler(), NewFakeTiKVUpgrader(), controller.NewInformerSyncStatus())
 
-	tmm := &tikvMemberManager{
-		pdControl:    pdControl,
-		podLister:    podInformer.Lister(),
-		nodeLister:   nodeInformer.Lister(),
-		setControl:   setControl,
-		svcControl:   svcControl,
-		typedControl: controller.NewTypedControl(genericControl),
-		setLister:    setInformer.Lister(),
-		svcLister:    svcInformer.Lister(),
-		tikvScaler:   tikvScaler,
-		tikvUpgrader: tikvUpgrader,
-	}
-	tmm.tikvStatefulSetIsUpgradingFn = tikvStatefulSetIsUpgrading
-	return tmm, setControl, svcControl, pdClient, podInformer.Informer().GetIndexer(), nodeInformer.Informer().GetIndexer()
+	return tmm.(*tikvMemberManager), d.StatefulSetControl.(*controller.FakeStatefulSetControl), d.ServiceControl.(*controller.FakeServiceControl),
+		pdClient, podInformer.Informer().GetIndexer(), nodeInformer.Informer().GetIndexer()
 }
 
 func TestGetNewServiceForTikvCluster(t *testing.T) {
@@ -1676,6 +2036,26 @@ func TestGetNewTiKVSetForTikvCluster(t *testing.T) {
 				}), "Expected the CAPACITY of tikv is properly set")
 			},
 		},
+		{
+			name: "tikv statefulset update strategy is OnDelete",
+			tc: v1alpha1.TikvCluster{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "tc",
+					Namespace: "ns",
+				},
+				Spec: v1alpha1.TikvClusterSpec{
+					TiKV: v1alpha1.TiKVSpec{
+						ComponentSpec: v1alpha1.ComponentSpec{
+							StatefulSetUpdateStrategy: apps.OnDeleteStatefulSetStrategyType,
+						},
+					},
+				},
+			},
+			testSts: func(sts *apps.StatefulSet) {
+				g := NewGomegaWithT(t)
+				g.Expect(sts.Spec.UpdateStrategy).To(Equal(apps.StatefulSetUpdateStrategy{Type: apps.OnDeleteStatefulSetStrategyType}))
+			},
+		},
 		// TODO add more tests
 	}
 
@@ -1961,9 +2341,10 @@ func TestGetTiKVConfigMap(t *testing.T) {
 	g := NewGomegaWithT(t)
 	updateStrategy := v1alpha1.ConfigUpdateStrategyInPlace
 	testCases := []struct {
-		name     string
-		tc       v1alpha1.TikvCluster
-		expected *corev1.ConfigMap
+		name      string
+		tc        v1alpha1.TikvCluster
+		refConfig string
+		expected  *corev1.ConfigMap
 	}{
 		{
 			name: "TiKV config is nil",
@@ -2009,6 +2390,9 @@ func TestGetTiKVConfigMap(t *testing.T) {
 						"app.kubernetes.io/instance":   "foo",
 						"app.kubernetes.io/component":  "tikv",
 					},
+					Annotations: map[string]string{
+						label.AnnConfigManagedKeys: `["config-file","startup-script"]`,
+					},
 					OwnerReferences: []metav1.OwnerReference{
 						{
 							APIVersion: "tikv.org/v1alpha1",
@@ -2036,11 +2420,65 @@ func TestGetTiKVConfigMap(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "TiKV config ref",
+			tc: v1alpha1.TikvCluster{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "foo",
+					Namespace: "ns",
+				},
+				Spec: v1alpha1.TikvClusterSpec{
+					TiKV: v1alpha1.TiKVSpec{
+						ComponentSpec: v1alpha1.ComponentSpec{
+							ConfigUpdateStrategy: &updateStrategy,
+						},
+						ConfigRef: &v1alpha1.ConfigMapKeyRef{
+							ConfigMapName: "tikv-config",
+							Key:           "config.toml",
+						},
+					},
+				},
+			},
+			refConfig: "[raftstore]\n  sync-log = false\n",
+			expected: &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "foo-tikv",
+					Namespace: "ns",
+					Labels: map[string]string{
+						"app.kubernetes.io/name":       "tikv-cluster",
+						"app.kubernetes.io/managed-by": "tikv-operator",
+						"app.kubernetes.io/instance":   "foo",
+						"app.kubernetes.io/component":  "tikv",
+					},
+					Annotations: map[string]string{
+						label.AnnConfigManagedKeys: `["config-file","startup-script"]`,
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							APIVersion: "tikv.org/v1alpha1",
+							Kind:       "TikvCluster",
+							Name:       "foo",
+							UID:        "",
+							Controller: func(b bool) *bool {
+								return &b
+							}(true),
+							BlockOwnerDeletion: func(b bool) *bool {
+								return &b
+							}(true),
+						},
+					},
+				},
+				Data: map[string]string{
+					"startup-script": "",
+					"config-file":    "[raftstore]\n  sync-log = false\n",
+				},
+			},
+		},
 	}
 
 	for _, tt := range testCases {
 		t.Run(tt.name, func(t *testing.T) {
-			cm, err := getTikVConfigMap(&tt.tc)
+			cm, err := getTikVConfigMap(&tt.tc, tt.refConfig, nil, nil)
 			g.Expect(err).To(Succeed())
 			if tt.expected == nil {
 				g.Expect(cm).To(BeNil())
@@ -2054,3 +2492,342 @@ func TestGetTiKVConfigMap(t *testing.T) {
 		})
 	}
 }
+
+func TestGetTiKVConfigMapTLSCluster(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForPD()
+	tc.Spec.TLSCluster = &v1alpha1.TLSCluster{Enabled: true}
+	tc.Spec.TiKV.ConfigRef = &v1alpha1.ConfigMapKeyRef{ConfigMapName: "tikv-config", Key: "config.toml"}
+	cm, err := getTikVConfigMap(tc, "[security]\n  cert-allowed-cn = [\"tikv\"]\n", nil, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(cm).NotTo(BeNil())
+
+	config := &v1alpha1.TiKVConfig{}
+	g.Expect(UnmarshalTOML([]byte(cm.Data["config-file"]), config)).To(Succeed())
+	g.Expect(config.Security).To(Equal(&v1alpha1.TiKVSecurityConfig{
+		CAPath:        pointer.StringPtr("/var/lib/tikv-tls/ca.crt"),
+		CertPath:      pointer.StringPtr("/var/lib/tikv-tls/tls.crt"),
+		KeyPath:       pointer.StringPtr("/var/lib/tikv-tls/tls.key"),
+		CertAllowedCN: []string{"tikv"},
+	}))
+	g.Expect(cm.Annotations[label.AnnConfigProvenance]).To(ContainSubstring(`"security.ca-path":"spec.tlsCluster"`))
+	g.Expect(cm.Data["startup-script"]).To(ContainSubstring("--pd=https://${CLUSTER_NAME}-pd:2379"))
+}
+
+func TestGetNewTiKVSetForTikvClusterTLS(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForPD()
+	tc.Spec.TLSCluster = &v1alpha1.TLSCluster{Enabled: true}
+	sts, err := getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	podSpec := sts.Spec.Template.Spec
+	g.Expect(podSpec.Volumes).To(ContainElement(corev1.Volume{
+		Name: "tikv-tls",
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{{
+					Secret: &corev1.SecretProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "test-tikv-cluster-secret"}},
+				}},
+			},
+		},
+	}))
+	g.Expect(podSpec.Containers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "tikv-tls", ReadOnly: true, MountPath: "/var/lib/tikv-tls"}))
+	g.Expect(podSpec.Containers[0].ReadinessProbe.TCPSocket.Port).To(Equal(intstr.FromInt(20160)))
+	g.Expect(sts.Spec.Template.Annotations).NotTo(HaveKey(label.AnnTLSCertHash))
+
+	// the members not reloading the rotated certificates online are restarted to load them
+	tc.Status.TiKV.TLSCertRolloutHash = "b5a1"
+	sts, err = getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(sts.Spec.Template.Annotations).To(HaveKeyWithValue(label.AnnTLSCertHash, "b5a1"))
+}
+
+func TestGetNewTiKVSetForTikvClusterAdditionalContainers(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForPD()
+	oldSet, err := getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(oldSet.Spec.Template.Spec.Containers).To(HaveLen(1))
+	g.Expect(SetStatefulSetLastAppliedConfigAnnotation(oldSet)).To(Succeed())
+
+	shipper := corev1.Container{
+		Name:         "log-shipper",
+		Image:        "fluent/fluent-bit",
+		VolumeMounts: []corev1.VolumeMount{{Name: v1alpha1.TiKVMemberType.String(), MountPath: "/var/lib/tikv", ReadOnly: true}},
+	}
+	tc.Spec.TiKV.AdditionalContainers = []corev1.Container{shipper}
+	sts, err := getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	containers := sts.Spec.Template.Spec.Containers
+	g.Expect(containers).To(HaveLen(2))
+	g.Expect(containers[0].Name).To(Equal(v1alpha1.TiKVMemberType.String()))
+	g.Expect(containers[1]).To(Equal(shipper))
+
+	// the pods are rolled to add the containers
+	g.Expect(statefulSetEqual(*sts, *oldSet)).To(BeFalse())
+	// the spec is not shared with the statefulset
+	containers[1].VolumeMounts[0].ReadOnly = false
+	g.Expect(tc.Spec.TiKV.AdditionalContainers[0].VolumeMounts[0].ReadOnly).To(BeTrue())
+}
+
+func TestGetNewTiKVSetForTikvClusterPVCReclaimPolicy(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForPD()
+	sts, err := getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(sts.Spec.VolumeClaimTemplates[0].Annotations).NotTo(HaveKey(label.AnnPVCReclaimKey))
+
+	tc.Spec.TiKV.PVCReclaimPolicy = corev1.PersistentVolumeReclaimRetain
+	sts, err = getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(sts.Spec.VolumeClaimTemplates[0].Annotations).To(HaveKeyWithValue(label.AnnPVCReclaimKey, label.AnnPVCReclaimRetainVal))
+}
+
+func TestGetNewTiKVSetForTikvClusterConnectionDrain(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForPD()
+	tc.Spec.TLSCluster = &v1alpha1.TLSCluster{Enabled: true}
+	tc.Spec.TiKV.ScalePolicy = &v1alpha1.TiKVScalePolicy{ConnectionDrainSeconds: 30}
+	sts, err := getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	probe := sts.Spec.Template.Spec.Containers[0].ReadinessProbe
+	g.Expect(probe.TCPSocket).To(BeNil())
+	g.Expect(probe.Exec.Command).To(Equal([]string{"/bin/sh", "-c", "! grep -q '^tikv.org/connection-drain-begin-time=' /etc/podinfo/annotations"}))
+}
+
+func TestTiKVMemberManagerResolveTiKVEncryption(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForPD()
+	tkmm, _, _, _, _, _ := newFakeTiKVMemberManager(tc)
+	secretInformer := kubeinformers.NewSharedInformerFactory(kubefake.NewSimpleClientset(), 0).Core().V1().Secrets()
+	tkmm.secretLister = secretInformer.Lister()
+	secretIndexer := secretInformer.Informer().GetIndexer()
+
+	// not enabled
+	keys, ok, err := tkmm.resolveTiKVEncryption(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+	g.Expect(keys).To(BeNil())
+
+	// the secret does not exist
+	tc.Spec.TiKV.Encryption = &v1alpha1.TiKVEncryption{SecretRef: &corev1.LocalObjectReference{Name: "master-key"}}
+	_, _, err = tkmm.resolveTiKVEncryption(tc, nil)
+	g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+
+	// the master key does not exist
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "master-key", Namespace: tc.Namespace},
+		Data:       map[string][]byte{"other": []byte("c0ffee")},
+	}
+	g.Expect(secretIndexer.Add(secret)).To(Succeed())
+	_, _, err = tkmm.resolveTiKVEncryption(tc, nil)
+	g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+
+	secret.Data = map[string][]byte{"master-key": []byte("c0ffee")}
+	g.Expect(secretIndexer.Update(secret)).To(Succeed())
+	keys, ok, err = tkmm.resolveTiKVEncryption(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+	g.Expect(keys.previous).To(BeFalse())
+
+	// rotating the master key changes the hash
+	secret.Data = map[string][]byte{"master-key": []byte("decaf"), "previous-master-key": []byte("c0ffee")}
+	g.Expect(secretIndexer.Update(secret)).To(Succeed())
+	rotated, ok, err := tkmm.resolveTiKVEncryption(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+	g.Expect(rotated.previous).To(BeTrue())
+	g.Expect(rotated.hash).NotTo(Equal(keys.hash))
+
+	// removing the encryption enabled is refused without the annotation
+	set, err := getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	tc.Spec.TiKV.Encryption = nil
+	_, ok, err = tkmm.resolveTiKVEncryption(tc, set)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ok).To(BeFalse())
+
+	tc.Annotations = map[string]string{label.AnnDisableEncryptionKey: label.AnnDisableEncryptionVal}
+	_, ok, err = tkmm.resolveTiKVEncryption(tc, set)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+}
+
+func TestGetTiKVConfigMapEncryption(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForPD()
+	tc.Spec.ConfigUpdateStrategy = v1alpha1.ConfigUpdateStrategyRollingUpdate
+	tc.Spec.TiKV.Encryption = &v1alpha1.TiKVEncryption{SecretRef: &corev1.LocalObjectReference{Name: "master-key"}}
+	cm, err := getTikVConfigMap(tc, "", nil, &tikvEncryptionKeys{hash: "1"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(cm).NotTo(BeNil())
+	g.Expect(cm.Data["config-file"]).To(ContainSubstring(`data-encryption-method = "aes256-ctr"`))
+	g.Expect(cm.Data["config-file"]).To(ContainSubstring(`path = "/var/lib/tikv-encryption/master-key"`))
+	g.Expect(cm.Data["config-file"]).NotTo(ContainSubstring("previous-master-key"))
+	g.Expect(cm.Annotations[label.AnnConfigProvenance]).To(ContainSubstring(`"security.encryption.master-key.path":"spec.tikv.encryption"`))
+
+	// rotating the master key renames the configmap to roll tikv
+	rotated, err := getTikVConfigMap(tc, "", nil, &tikvEncryptionKeys{hash: "2", previous: true})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(rotated.Data["config-file"]).To(ContainSubstring(`path = "/var/lib/tikv-encryption/previous-master-key"`))
+	g.Expect(rotated.Name).NotTo(Equal(cm.Name))
+
+	sts, err := getNewTiKVSetForTikvCluster(tc, cm)
+	g.Expect(err).NotTo(HaveOccurred())
+	podSpec := sts.Spec.Template.Spec
+	g.Expect(podSpec.Volumes).To(ContainElement(corev1.Volume{
+		Name:         "tikv-encryption",
+		VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "master-key"}},
+	}))
+	g.Expect(podSpec.Containers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "tikv-encryption", ReadOnly: true, MountPath: "/var/lib/tikv-encryption"}))
+}
+
+func TestTiKVEncryptionKMS(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	keyID := "0987dcba-09fe-87dc-65ba-ab0987654321"
+	tc := newTikvClusterForPD()
+	tc.Spec.ConfigUpdateStrategy = v1alpha1.ConfigUpdateStrategyRollingUpdate
+	tc.Spec.TiKV.Encryption = &v1alpha1.TiKVEncryption{KMS: &v1alpha1.TiKVEncryptionKMS{
+		KeyID:                keyID,
+		Region:               "us-west-2",
+		Endpoint:             "https://kms.example.com",
+		CredentialsSecretRef: &corev1.LocalObjectReference{Name: "kms-credentials"},
+	}}
+	tkmm, _, _, _, _, _ := newFakeTiKVMemberManager(tc)
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubefake.NewSimpleClientset(), 0)
+	secretInformer := kubeInformerFactory.Core().V1().Secrets()
+	cmInformer := kubeInformerFactory.Core().V1().ConfigMaps()
+	tkmm.secretLister = secretInformer.Lister()
+	tkmm.cmLister = cmInformer.Lister()
+	recorder := record.NewFakeRecorder(10)
+	tkmm.recorder = recorder
+
+	// the secret of the credentials does not exist
+	_, _, err := tkmm.resolveTiKVEncryption(tc, nil)
+	g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+	g.Expect(<-recorder.Events).NotTo(ContainSubstring(keyID))
+
+	g.Expect(secretInformer.Informer().GetIndexer().Add(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "kms-credentials", Namespace: tc.Namespace},
+	})).To(Succeed())
+	keys, ok, err := tkmm.resolveTiKVEncryption(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+
+	cm, err := getTikVConfigMap(tc, "", nil, keys)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(cm.Data["config-file"]).To(ContainSubstring(`type = "kms"`))
+	g.Expect(cm.Data["config-file"]).To(ContainSubstring(fmt.Sprintf("key-id = %q", keyID)))
+	g.Expect(cm.Data["config-file"]).To(ContainSubstring(`region = "us-west-2"`))
+	g.Expect(cm.Data["config-file"]).To(ContainSubstring(`endpoint = "https://kms.example.com"`))
+	g.Expect(cm.Data["encryption-key-hash"]).NotTo(ContainSubstring(keyID))
+	g.Expect(cm.Annotations[label.AnnConfigProvenance]).NotTo(ContainSubstring(keyID))
+
+	// the credentials are injected from the secret, nothing is mounted
+	set, err := getNewTiKVSetForTikvCluster(tc, cm)
+	g.Expect(err).NotTo(HaveOccurred())
+	podSpec := set.Spec.Template.Spec
+	g.Expect(hasVolume(&podSpec, "tikv-encryption")).To(BeFalse())
+	g.Expect(podSpec.Containers[0].Env).To(ContainElement(corev1.EnvVar{
+		Name: "AWS_ACCESS_KEY_ID",
+		ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "kms-credentials"}, Key: "access-key-id",
+		}},
+	}))
+	g.Expect(set.Spec.Template.Annotations).NotTo(ContainElement(ContainSubstring(keyID)))
+
+	// IRSA, the service account provides the credentials
+	tc.Spec.TiKV.ServiceAccount = "tikv-kms"
+	tc.Spec.TiKV.Encryption.KMS.CredentialsSecretRef = nil
+	set, err = getNewTiKVSetForTikvCluster(tc, cm)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(set.Spec.Template.Spec.ServiceAccountName).To(Equal("tikv-kms"))
+	for _, env := range set.Spec.Template.Spec.Containers[0].Env {
+		g.Expect(env.Name).NotTo(HavePrefix("AWS_"))
+	}
+
+	// removing the encryption enabled is refused without the annotation
+	g.Expect(cmInformer.Informer().GetIndexer().Add(cm)).To(Succeed())
+	tc.Spec.TiKV.Encryption = nil
+	_, ok, err = tkmm.resolveTiKVEncryption(tc, set)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ok).To(BeFalse())
+	g.Expect(<-recorder.Events).To(ContainSubstring("EncryptionRemovalRefused"))
+}
+
+func TestSyncTiKVConfigMapAdditionalConfigFiles(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForPD()
+	tc.Spec.ConfigUpdateStrategy = v1alpha1.ConfigUpdateStrategyRollingUpdate
+	tc.Spec.TiKV.AdditionalConfigFiles = map[string]string{"blacklist.txt": "a"}
+	tkmm, _, _, _, _, _ := newFakeTiKVMemberManager(tc)
+	genericControl := controller.NewFakeGenericControl()
+	tkmm.typedControl = controller.NewTypedControl(genericControl)
+	cmInformer := kubeinformers.NewSharedInformerFactory(kubefake.NewSimpleClientset(), 0).Core().V1().ConfigMaps()
+	tkmm.cmLister = cmInformer.Lister()
+	cmIndexer := cmInformer.Informer().GetIndexer()
+	getConfigMap := func(name string) *corev1.ConfigMap {
+		cm := &corev1.ConfigMap{}
+		key := types.NamespacedName{Namespace: tc.Namespace, Name: name}
+		g.Expect(genericControl.FakeCli.Get(context.TODO(), key, cm)).To(Succeed())
+		g.Expect(cmIndexer.Update(cm)).To(Succeed())
+		return cm
+	}
+
+	// the additional files are stored and mounted along with the config file
+	cm, err := tkmm.syncTiKVConfigMap(tc, nil, "", nil, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(cm.Data["blacklist.txt"]).To(Equal("a"))
+	g.Expect(cm.Annotations[label.AnnConfigManagedKeys]).To(Equal(`["blacklist.txt","config-file","startup-script"]`))
+	set, err := getNewTiKVSetForTikvCluster(tc, cm)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(set.Spec.Template.Spec.Volumes).To(ContainElement(corev1.Volume{
+		Name: "config", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: cm.Name},
+			Items: []corev1.KeyToPath{
+				{Key: "config-file", Path: "tikv.toml"},
+				{Key: "blacklist.txt", Path: "blacklist.txt"},
+			},
+		}},
+	}))
+
+	// the keys added by users are kept and are not part of the digest
+	userCm := getConfigMap(cm.Name)
+	userCm.Data["plugin.txt"] = "b"
+	userCm.Data["blacklist.txt"] = "c"
+	g.Expect(genericControl.FakeCli.Update(context.TODO(), userCm)).To(Succeed())
+	g.Expect(cmIndexer.Update(userCm)).To(Succeed())
+	synced, err := tkmm.syncTiKVConfigMap(tc, set, "", nil, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(synced.Name).To(Equal(cm.Name))
+	g.Expect(synced.Data["plugin.txt"]).To(Equal("b"))
+	g.Expect(synced.Data["blacklist.txt"]).To(Equal("a"))
+
+	// updating an additional file rolls tikv, the configmap of the new digest carries the keys of users over
+	tc.Spec.TiKV.AdditionalConfigFiles["blacklist.txt"] = "d"
+	rolled, err := tkmm.syncTiKVConfigMap(tc, set, "", nil, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(rolled.Name).NotTo(Equal(cm.Name))
+	g.Expect(rolled.Data["plugin.txt"]).To(Equal("b"))
+	g.Expect(rolled.Data["blacklist.txt"]).To(Equal("d"))
+
+	// removing an additional file in place deletes it, never the keys of users
+	tc.Spec.ConfigUpdateStrategy = v1alpha1.ConfigUpdateStrategyInPlace
+	tc.Spec.TiKV.AdditionalConfigFiles = nil
+	// the configmap is still synced for the config
+	tc.Spec.TiKV.Config = &v1alpha1.TiKVConfig{}
+	inPlace, err := tkmm.syncTiKVConfigMap(tc, set, "", nil, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(inPlace.Name).To(Equal(cm.Name))
+	g.Expect(inPlace.Data).NotTo(HaveKey("blacklist.txt"))
+	g.Expect(inPlace.Data["plugin.txt"]).To(Equal("b"))
+}
@@ -15,39 +15,58 @@ package member
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
 	"time"
 
+	"github.com/pingcap/advanced-statefulset/client/apis/apps/v1/helper"
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
 	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/deps"
 	"github.com/tikv/tikv-operator/pkg/label"
 	"github.com/tikv/tikv-operator/pkg/pdapi"
+	utiltikvcluster "github.com/tikv/tikv-operator/pkg/util/tikvcluster"
 	apps "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
 	podutil "k8s.io/kubernetes/pkg/api/v1/pod"
 )
 
 type tikvScaler struct {
 	generalScaler
-	podLister corelisters.PodLister
+	podControl controller.PodControlInterface
+	podLister  corelisters.PodLister
+	recorder   record.EventRecorder
 }
 
 // NewTiKVScaler returns a tikv Scaler
-func NewTiKVScaler(pdControl pdapi.PDControlInterface,
-	pvcLister corelisters.PersistentVolumeClaimLister,
-	pvcControl controller.PVCControlInterface,
-	podLister corelisters.PodLister) Scaler {
-	return &tikvScaler{generalScaler{pdControl, pvcLister, pvcControl}, podLister}
+func NewTiKVScaler(deps *deps.Dependencies) Scaler {
+	return &tikvScaler{
+		generalScaler{deps.PDControl, deps.PVCLister, deps.PVCControl, deps.Clock},
+		deps.PodControl,
+		deps.PodLister,
+		deps.Recorder,
+	}
 }
 
 func (tsd *tikvScaler) Scale(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulSet, newSet *apps.StatefulSet) error {
 	scaling, _, _, _ := scaleOne(oldSet, newSet)
 	if scaling > 0 {
+		setScaleInBlockedCondition(tc, false, "")
+		tc.Status.TiKV.ScaleIn = nil
 		return tsd.ScaleOut(tc, oldSet, newSet)
 	} else if scaling < 0 {
+		// scaling in abandons the ongoing staged scale out
+		tc.Status.TiKV.CurrentScaleOutOrdinals = nil
 		return tsd.ScaleIn(tc, oldSet, newSet)
 	}
+	setScaleInBlockedCondition(tc, false, "")
+	tc.Status.TiKV.ScaleIn = nil
+	tsd.finishScaleOutStep(tc)
 	return nil
 }
 
@@ -57,6 +76,9 @@ func (tsd *tikvScaler) ScaleOut(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulS
 	if tc.TiKVUpgrading() {
 		return nil
 	}
+	if err := tsd.waitForScaleOutStep(tc); err != nil {
+		return err
+	}
 
 	klog.Infof("scaling out tikv statefulset %s/%s, ordinal: %d (replicas: %d, delete slots: %v)", oldSet.Namespace, oldSet.Name, ordinal, replicas, deleteSlots.List())
 	_, err := tsd.deleteDeferDeletingPVC(tc, oldSet.GetName(), v1alpha1.TiKVMemberType, ordinal)
@@ -65,17 +87,134 @@ func (tsd *tikvScaler) ScaleOut(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulS
 	}
 
 	setReplicasAndDeleteSlots(newSet, replicas, deleteSlots)
+	if tc.Spec.TiKV.ScaleOut != nil {
+		tc.Status.TiKV.CurrentScaleOutOrdinals = append(tc.Status.TiKV.CurrentScaleOutOrdinals, ordinal)
+	}
 	return nil
 }
 
+// waitForScaleOutStep returns a requeue error if the ongoing step of a staged scale out
+// has added all its stores but they are not ready for the next step yet
+func (tsd *tikvScaler) waitForScaleOutStep(tc *v1alpha1.TikvCluster) error {
+	strategy := tc.Spec.TiKV.ScaleOut
+	if strategy == nil {
+		tc.Status.TiKV.CurrentScaleOutOrdinals = nil
+		return nil
+	}
+	stepSize := 1
+	if strategy.StepSize != nil && *strategy.StepSize > 0 {
+		stepSize = int(*strategy.StepSize)
+	}
+	ordinals := tc.Status.TiKV.CurrentScaleOutOrdinals
+	if len(ordinals) < stepSize {
+		return nil
+	}
+
+	done, waitingFor, err := tsd.scaleOutStepDone(tc)
+	if err != nil {
+		return err
+	}
+	if !done {
+		return controller.RequeueErrorf("TikvCluster: [%s/%s]'s tikv scale out step %v is not done, %s",
+			tc.GetNamespace(), tc.GetName(), ordinals, waitingFor)
+	}
+	klog.Infof("TikvCluster: [%s/%s]'s tikv scale out step %v is done", tc.GetNamespace(), tc.GetName(), ordinals)
+	tc.Status.TiKV.CurrentScaleOutOrdinals = nil
+	return nil
+}
+
+// finishScaleOutStep clears the last step of a staged scale out once it is done
+func (tsd *tikvScaler) finishScaleOutStep(tc *v1alpha1.TikvCluster) {
+	if len(tc.Status.TiKV.CurrentScaleOutOrdinals) == 0 {
+		return
+	}
+	if tc.Spec.TiKV.ScaleOut == nil {
+		tc.Status.TiKV.CurrentScaleOutOrdinals = nil
+		return
+	}
+	done, waitingFor, err := tsd.scaleOutStepDone(tc)
+	if err != nil {
+		klog.Errorf("failed to check the tikv scale out step of TikvCluster: [%s/%s], %v", tc.GetNamespace(), tc.GetName(), err)
+		return
+	}
+	if !done {
+		klog.V(4).Infof("TikvCluster: [%s/%s]'s last tikv scale out step is not done, %s", tc.GetNamespace(), tc.GetName(), waitingFor)
+		return
+	}
+	tc.Status.TiKV.CurrentScaleOutOrdinals = nil
+}
+
+// scaleOutStepDone tells whether the stores added by the ongoing step of a staged scale out are Up
+// and have received spec.tikv.scaleOut.minRegionCount regions or pd has no pending operators,
+// otherwise it returns what is being waited for.
+func (tsd *tikvScaler) scaleOutStepDone(tc *v1alpha1.TikvCluster) (bool, string, error) {
+	stores := map[string]v1alpha1.TiKVStore{}
+	for _, store := range tc.Status.TiKV.Stores {
+		stores[store.PodName] = store
+	}
+	var storeIDs []string
+	for _, ordinal := range tc.Status.TiKV.CurrentScaleOutOrdinals {
+		podName := ordinalPodName(v1alpha1.TiKVMemberType, tc.GetName(), ordinal)
+		store, ok := stores[podName]
+		if !ok {
+			return false, fmt.Sprintf("waiting for the store of pod %s", podName), nil
+		}
+		if store.State != v1alpha1.TiKVStateUp {
+			return false, fmt.Sprintf("store %s of pod %s is %s", store.ID, podName, store.State), nil
+		}
+		storeIDs = append(storeIDs, store.ID)
+	}
+
+	pdCli := controller.GetPDClient(tsd.pdControl, tc)
+	if minRegionCount := tc.Spec.TiKV.ScaleOut.MinRegionCount; minRegionCount != nil {
+		storesInfo, err := pdCli.GetStores(pdapi.NoCache)
+		if err != nil {
+			return false, "", err
+		}
+		regionCounts := map[string]int{}
+		for _, store := range storesInfo.Stores {
+			if store.Store == nil || store.Status == nil {
+				continue
+			}
+			regionCounts[strconv.FormatUint(store.Store.GetId(), 10)] = store.Status.RegionCount
+		}
+		received := true
+		for _, id := range storeIDs {
+			if regionCounts[id] < int(*minRegionCount) {
+				received = false
+				break
+			}
+		}
+		if received {
+			return true, "", nil
+		}
+	}
+
+	count, err := pdCli.GetOperatorCount()
+	if err != nil {
+		return false, "", err
+	}
+	if count > 0 {
+		return false, fmt.Sprintf("%d operators are pending in pd", count), nil
+	}
+	return true, "", nil
+}
+
 func (tsd *tikvScaler) ScaleIn(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulSet, newSet *apps.StatefulSet) error {
 	ns := tc.GetNamespace()
 	tcName := tc.GetName()
 	// we can only remove one member at a time when scaling in
 	_, ordinal, replicas, deleteSlots := scaleOne(oldSet, newSet)
+	targetReplicas := *newSet.Spec.Replicas
+	bulk := tikvBulkScaleIn(tc) && helper.GetDeleteSlots(oldSet).Len() == 0 && helper.GetDeleteSlots(newSet).Len() == 0
 	resetReplicas(newSet, oldSet)
 	setName := oldSet.GetName()
 
+	// the store would never become tombstone once pd is deleted
+	if err := checkClusterDeleting(tc, "scaling in tikv"); err != nil {
+		return err
+	}
+
 	// tikv can not scale in when it is upgrading
 	if tc.TiKVUpgrading() {
 		klog.Infof("the TikvCluster: [%s/%s]'s tikv is upgrading,can not scale in until upgrade have completed",
@@ -83,6 +222,16 @@ func (tsd *tikvScaler) ScaleIn(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulSe
 		return nil
 	}
 
+	if err := tsd.checkMaxReplicas(tc, targetReplicas); err != nil {
+		return err
+	}
+
+	if bulk {
+		if scaled, err := tsd.scaleInBulk(tc, oldSet, newSet, targetReplicas); scaled || err != nil {
+			return err
+		}
+	}
+
 	klog.Infof("scaling in tikv statefulset %s/%s, ordinal: %d (replicas: %d, delete slots: %v)", oldSet.Namespace, oldSet.Name, ordinal, replicas, deleteSlots.List())
 	// We need remove member from cluster before reducing statefulset replicas
 	podName := ordinalPodName(v1alpha1.TiKVMemberType, tcName, ordinal)
@@ -98,6 +247,7 @@ func (tsd *tikvScaler) ScaleIn(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulSe
 			if err != nil {
 				return err
 			}
+			setTiKVScaleInStatus(tc, []string{podName}, v1alpha1.TiKVScaleInPhaseDecommissionStore, nil)
 			if state != v1alpha1.TiKVStateOffline {
 				if err := controller.GetPDClient(tsd.pdControl, tc).DeleteStore(id); err != nil {
 					klog.Errorf("tikv scale in: failed to delete store %d, %v", id, err)
@@ -117,6 +267,9 @@ func (tsd *tikvScaler) ScaleIn(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulSe
 
 			// TODO: double check if store is really not in Up/Offline/Down state
 			klog.Infof("TiKV %s/%s store %d becomes tombstone", ns, podName, id)
+			if err := tsd.drainConnections(tc, []*corev1.Pod{pod}); err != nil {
+				return err
+			}
 
 			pvcName := ordinalPVCName(v1alpha1.TiKVMemberType, setName, ordinal)
 			pvc, err := tsd.pvcLister.PersistentVolumeClaims(ns).Get(pvcName)
@@ -126,7 +279,7 @@ func (tsd *tikvScaler) ScaleIn(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulSe
 			if pvc.Annotations == nil {
 				pvc.Annotations = map[string]string{}
 			}
-			now := time.Now().Format(time.RFC3339)
+			now := tsd.clock.Now().Format(time.RFC3339)
 			pvc.Annotations[label.AnnPVCDeferDeleting] = now
 			_, err = tsd.pvcControl.UpdatePVC(tc, pvc)
 			if err != nil {
@@ -137,6 +290,7 @@ func (tsd *tikvScaler) ScaleIn(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulSe
 			klog.Infof("tikv scale in: set pvc %s/%s annotation: %s to %s",
 				ns, pvcName, label.AnnPVCDeferDeleting, now)
 
+			tc.Status.TiKV.ScaleIn = nil
 			setReplicasAndDeleteSlots(newSet, replicas, deleteSlots)
 			return nil
 		}
@@ -155,7 +309,7 @@ func (tsd *tikvScaler) ScaleIn(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulSe
 			return err
 		}
 		safeTimeDeadline := pod.CreationTimestamp.Add(5 * controller.ResyncDuration)
-		if time.Now().Before(safeTimeDeadline) {
+		if tsd.clock.Now().Before(safeTimeDeadline) {
 			// Wait for 5 resync periods to ensure that the following situation does not occur:
 			//
 			// The tikv pod starts for a while, but has not synced its status, and then the pod becomes not ready.
@@ -169,7 +323,7 @@ func (tsd *tikvScaler) ScaleIn(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulSe
 		if pvc.Annotations == nil {
 			pvc.Annotations = map[string]string{}
 		}
-		now := time.Now().Format(time.RFC3339)
+		now := tsd.clock.Now().Format(time.RFC3339)
 		pvc.Annotations[label.AnnPVCDeferDeleting] = now
 		_, err = tsd.pvcControl.UpdatePVC(tc, pvc)
 		if err != nil {
@@ -185,6 +339,261 @@ func (tsd *tikvScaler) ScaleIn(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulSe
 	return fmt.Errorf("TiKV %s/%s not found in cluster", ns, podName)
 }
 
+// scaleInBulk decommissions the stores of all the pods removed by the scale in at once, and removes
+// the pods whose stores are tombstone from the highest ordinal down, a pod is never removed before
+// the pods of higher ordinals. It returns false, leaving the scale in to be done one store at a
+// time, if the store of a pod removed is not found.
+func (tsd *tikvScaler) scaleInBulk(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulSet, newSet *apps.StatefulSet, targetReplicas int32) (bool, error) {
+	ns := tc.GetNamespace()
+	tcName := tc.GetName()
+	stores := map[int32]v1alpha1.TiKVStore{}
+	pods := map[int32]*corev1.Pod{}
+	for ordinal := *oldSet.Spec.Replicas - 1; ordinal >= targetReplicas; ordinal-- {
+		podName := ordinalPodName(v1alpha1.TiKVMemberType, tcName, ordinal)
+		pod, err := tsd.podLister.Pods(ns).Get(podName)
+		if err != nil {
+			return true, err
+		}
+		store, ok := tikvStoreOfPod(tc, pod)
+		if !ok {
+			return false, nil
+		}
+		stores[ordinal] = store
+		pods[ordinal] = pod
+	}
+
+	var drainingPods []string
+	tombstoneCount := 0
+	for ordinal := *oldSet.Spec.Replicas - 1; ordinal >= targetReplicas; ordinal-- {
+		store := stores[ordinal]
+		if store.State == v1alpha1.TiKVStateTombstone {
+			if len(drainingPods) == 0 {
+				tombstoneCount++
+			}
+			continue
+		}
+		drainingPods = append(drainingPods, store.PodName)
+		if store.State == v1alpha1.TiKVStateOffline {
+			continue
+		}
+		id, err := strconv.ParseUint(store.ID, 10, 64)
+		if err != nil {
+			return true, err
+		}
+		if err := controller.GetPDClient(tsd.pdControl, tc).DeleteStore(id); err != nil {
+			klog.Errorf("tikv scale in: failed to delete store %d, %v", id, err)
+			return true, err
+		}
+		klog.Infof("tikv scale in: delete store %d for tikv %s/%s successfully", id, ns, store.PodName)
+	}
+
+	if len(drainingPods) > 0 {
+		setTiKVScaleInStatus(tc, drainingPods, v1alpha1.TiKVScaleInPhaseDecommissionStore, nil)
+	}
+	if tombstoneCount > 0 {
+		replicas := DesiredTiKVReplicas(tc, tombstoneCount)
+		// the status of the statefulset may lag behind, never remove a pod whose store is not tombstone
+		if minReplicas := *oldSet.Spec.Replicas - int32(tombstoneCount); replicas < minReplicas {
+			replicas = minReplicas
+		}
+		var removedPods []*corev1.Pod
+		for ordinal := *oldSet.Spec.Replicas - 1; ordinal >= replicas; ordinal-- {
+			removedPods = append(removedPods, pods[ordinal])
+		}
+		if err := tsd.drainConnections(tc, removedPods); err != nil {
+			return true, err
+		}
+		if len(drainingPods) == 0 {
+			tc.Status.TiKV.ScaleIn = nil
+		}
+		for ordinal := *oldSet.Spec.Replicas - 1; ordinal >= replicas; ordinal-- {
+			if err := tsd.updateDeferDeletingPVC(tc, v1alpha1.TiKVMemberType, ordinal); err != nil {
+				return true, err
+			}
+		}
+		klog.Infof("tikv scale in: %d stores of tikv %s/%s are tombstone, scale in statefulset to %d replicas", tombstoneCount, ns, tcName, replicas)
+		setReplicasAndDeleteSlots(newSet, replicas, sets.NewInt32())
+	}
+	if len(drainingPods) > 0 {
+		return true, controller.RequeueErrorf("TiKV %s/%s %d stores still in cluster", ns, tcName, len(drainingPods))
+	}
+	return true, nil
+}
+
+// drainConnections keeps the pods of the tombstone stores running but not ready for
+// spec.tikv.scalePolicy.connectionDrainSeconds before they are removed, so that the clients
+// connected to the stores re-resolve them. The begin time of the draining is recorded in an
+// annotation of each pod, which fails its readiness probe. It returns a requeue error until
+// the pods are drained.
+func (tsd *tikvScaler) drainConnections(tc *v1alpha1.TikvCluster, pods []*corev1.Pod) error {
+	ns := tc.GetNamespace()
+	tcName := tc.GetName()
+	seconds := tikvConnectionDrainSeconds(tc)
+	if seconds <= 0 || len(pods) == 0 {
+		return nil
+	}
+	if tc.Annotations[label.AnnSkipConnectionDrainKey] == label.AnnSkipConnectionDrainVal {
+		klog.Warningf("the TikvCluster: [%s/%s] has annotation %s, skip draining the connections of the tikv pods scaled in",
+			ns, tcName, label.AnnSkipConnectionDrainKey)
+		return nil
+	}
+
+	now := tsd.clock.Now()
+	var deadline time.Time
+	var podNames []string
+	for _, pod := range pods {
+		podNames = append(podNames, pod.GetName())
+		beginTime := now
+		if beginTimeStr, draining := pod.Annotations[label.AnnConnectionDrainBeginTime]; draining {
+			var err error
+			beginTime, err = time.Parse(time.RFC3339, beginTimeStr)
+			if err != nil {
+				klog.Errorf("parse annotation:[%s] of pod %s/%s to time failed.", label.AnnConnectionDrainBeginTime, ns, pod.GetName())
+				return err
+			}
+		} else {
+			pod = pod.DeepCopy()
+			if pod.Annotations == nil {
+				pod.Annotations = map[string]string{}
+			}
+			pod.Annotations[label.AnnConnectionDrainBeginTime] = now.Format(time.RFC3339)
+			if _, err := tsd.podControl.UpdatePod(tc, pod); err != nil {
+				klog.Errorf("tikv scale in: failed to set pod %s/%s annotation %s, %v", ns, pod.GetName(), label.AnnConnectionDrainBeginTime, err)
+				return err
+			}
+			klog.Infof("tikv scale in: begin draining the connections of pod %s/%s", ns, pod.GetName())
+		}
+		if podDeadline := beginTime.Add(time.Duration(seconds) * time.Second); podDeadline.After(deadline) {
+			deadline = podDeadline
+		}
+	}
+
+	if now.Before(deadline) {
+		setTiKVScaleInStatus(tc, podNames, v1alpha1.TiKVScaleInPhaseDrainConnections, &metav1.Time{Time: deadline})
+		return controller.RequeueErrorf("TiKV %s/%s pods %v are draining the connections until %s", ns, tcName, podNames, deadline.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func setTiKVScaleInStatus(tc *v1alpha1.TikvCluster, pods []string, phase v1alpha1.TiKVScaleInPhase, deadline *metav1.Time) {
+	tc.Status.TiKV.ScaleIn = &v1alpha1.TiKVScaleInStatus{
+		Pods:                    pods,
+		Phase:                   phase,
+		ConnectionDrainDeadline: deadline,
+	}
+}
+
+// tikvStoreOfPod returns the store of the pod, a tombstone store is only returned if the pod
+// carries its store id, as the store of a pod scaled in and out again may be a new one
+func tikvStoreOfPod(tc *v1alpha1.TikvCluster, pod *corev1.Pod) (v1alpha1.TiKVStore, bool) {
+	for _, store := range tc.Status.TiKV.Stores {
+		if store.PodName == pod.Name {
+			return store, true
+		}
+	}
+	for id, store := range tc.Status.TiKV.TombstoneStores {
+		if store.PodName == pod.Name && pod.Labels[label.StoreIDLabelKey] == id {
+			store.State = v1alpha1.TiKVStateTombstone
+			return store, true
+		}
+	}
+	return v1alpha1.TiKVStore{}, false
+}
+
+// DesiredTiKVReplicas returns the replicas the tikv statefulset can be set to at this sync given the
+// number of tombstone stores of the pods being scaled in, counted from the highest ordinal down
+// to the first store still draining. Scaling out returns the desired replicas at once. Scaling in
+// removes the pod of one tombstone store at a time, or of all of them with
+// spec.tikv.scaleIn.bulk, and never goes below the desired replicas.
+func DesiredTiKVReplicas(tc *v1alpha1.TikvCluster, tombstoneCount int) int32 {
+	desired := tc.TiKVStsDesiredReplicas()
+	actual := tc.TiKVStsActualReplicas()
+	if desired >= actual {
+		return desired
+	}
+	step := int32(tombstoneCount)
+	if step > 1 && !tikvBulkScaleIn(tc) {
+		step = 1
+	}
+	replicas := actual - step
+	if replicas < desired {
+		replicas = desired
+	}
+	return replicas
+}
+
+// tikvBulkScaleIn returns whether the stores scaled in are decommissioned at once
+func tikvBulkScaleIn(tc *v1alpha1.TikvCluster) bool {
+	return tc.Spec.TiKV.ScaleIn != nil && tc.Spec.TiKV.ScaleIn.Bulk
+}
+
+// checkMaxReplicas refuses to scale in tikv to fewer stores than the max-replicas of pd,
+// which would leave regions without enough replicas. Up stores that are not part of the
+// tikv statefulset also hold replicas, so they are counted as well.
+func (tsd *tikvScaler) checkMaxReplicas(tc *v1alpha1.TikvCluster, targetReplicas int32) error {
+	ns := tc.GetNamespace()
+	tcName := tc.GetName()
+	if tc.Annotations[label.AnnForceScaleInKey] == label.AnnForceScaleInVal {
+		klog.Warningf("the TikvCluster: [%s/%s] has annotation %s, skip checking pd max-replicas before scaling in tikv",
+			ns, tcName, label.AnnForceScaleInKey)
+		setScaleInBlockedCondition(tc, false, "")
+		return nil
+	}
+
+	pdCli := controller.GetPDClient(tsd.pdControl, tc)
+	config, err := pdCli.GetConfig(pdapi.NoCache)
+	if err != nil {
+		return err
+	}
+	if config.Replication == nil || config.Replication.MaxReplicas == nil {
+		return nil
+	}
+	maxReplicas := *config.Replication.MaxReplicas
+
+	storesInfo, err := pdCli.GetStores(pdapi.NoCache)
+	if err != nil {
+		return err
+	}
+	pattern, err := regexp.Compile(fmt.Sprintf(tikvStoreLimitPattern, tcName, tcName, ns))
+	if err != nil {
+		return err
+	}
+	var externalStores int32
+	for _, store := range storesInfo.Stores {
+		if store.Store == nil || pattern.Match([]byte(store.Store.Address)) {
+			continue
+		}
+		if store.Store.StateName == v1alpha1.TiKVStateUp {
+			externalStores++
+		}
+	}
+
+	if uint64(targetReplicas+externalStores) >= maxReplicas {
+		setScaleInBlockedCondition(tc, false, "")
+		return nil
+	}
+	msg := fmt.Sprintf("can not scale in tikv to %d replicas with %d up stores outside of the statefulset, pd max-replicas is %d, set annotation %s: %q to force it",
+		targetReplicas, externalStores, maxReplicas, label.AnnForceScaleInKey, label.AnnForceScaleInVal)
+	tsd.recorder.Event(tc, corev1.EventTypeWarning, utiltikvcluster.TiKVBelowMaxReplicas, msg)
+	setScaleInBlockedCondition(tc, true, msg)
+	return fmt.Errorf("TikvCluster: [%s/%s], %s", ns, tcName, msg)
+}
+
+// setScaleInBlockedCondition records whether tikv scale in is blocked, the condition is
+// only added once a scale in has been blocked.
+func setScaleInBlockedCondition(tc *v1alpha1.TikvCluster, blocked bool, message string) {
+	if blocked {
+		cond := utiltikvcluster.NewTikvClusterCondition(v1alpha1.TiKVScaleInBlocked, corev1.ConditionTrue, utiltikvcluster.TiKVBelowMaxReplicas, message)
+		utiltikvcluster.SetTikvClusterCondition(&tc.Status, *cond)
+		return
+	}
+	if utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.TiKVScaleInBlocked) == nil {
+		return
+	}
+	cond := utiltikvcluster.NewTikvClusterCondition(v1alpha1.TiKVScaleInBlocked, corev1.ConditionFalse, utiltikvcluster.TiKVScaleInAllowed, "")
+	utiltikvcluster.SetTikvClusterCondition(&tc.Status, *cond)
+}
+
 type fakeTiKVScaler struct{}
 
 // NewFakeTiKVScaler returns a fake tikv Scaler
@@ -19,16 +19,20 @@ import (
 	"time"
 
 	. "github.com/onsi/gomega"
+	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
 	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/deps"
 	"github.com/tikv/tikv-operator/pkg/label"
 	"github.com/tikv/tikv-operator/pkg/pdapi"
+	utiltikvcluster "github.com/tikv/tikv-operator/pkg/util/tikvcluster"
+	apps "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	kubeinformers "k8s.io/client-go/informers"
-	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 func TestTiKVScalerScaleOut(t *testing.T) {
@@ -142,20 +146,163 @@ func TestTiKVScalerScaleOut(t *testing.T) {
 	}
 }
 
+func TestTiKVScalerStagedScaleOut(t *testing.T) {
+	g := NewGomegaWithT(t)
+	type testcase struct {
+		name             string
+		strategy         *v1alpha1.TiKVScaleOutStrategy
+		scaleOutOrdinals []int32
+		storeFn          func(*v1alpha1.TikvCluster)
+		regionCount      int
+		operatorCount    int
+		errExpectFn      func(*GomegaWithT, error)
+		changed          bool
+		expectOrdinals   []int32
+	}
+
+	testFn := func(test *testcase, t *testing.T) {
+		t.Log(test.name)
+		tc := newTikvClusterForPD()
+		tc.Spec.TiKV.ScaleOut = test.strategy
+		tc.Status.TiKV.CurrentScaleOutOrdinals = test.scaleOutOrdinals
+		if test.storeFn != nil {
+			test.storeFn(tc)
+		}
+
+		oldSet := newStatefulSetForPDScale()
+		newSet := oldSet.DeepCopy()
+		newSet.Spec.Replicas = controller.Int32Ptr(7)
+
+		scaler, pdControl, _, _, _ := newFakeTiKVScaler()
+		pdClient := controller.NewFakePDClient(pdControl, tc)
+		pdClient.AddReaction(pdapi.GetStoresActionType, func(action *pdapi.Action) (interface{}, error) {
+			return &pdapi.StoresInfo{
+				Stores: []*pdapi.StoreInfo{
+					{
+						Store:  &pdapi.MetaStore{Store: &metapb.Store{Id: 1}, StateName: v1alpha1.TiKVStateUp},
+						Status: &pdapi.StoreStatus{RegionCount: test.regionCount},
+					},
+				},
+			}, nil
+		})
+		pdClient.AddReaction(pdapi.GetOperatorCountActionType, func(action *pdapi.Action) (interface{}, error) {
+			return test.operatorCount, nil
+		})
+
+		err := scaler.Scale(tc, oldSet, newSet)
+		test.errExpectFn(g, err)
+		if test.changed {
+			g.Expect(int(*newSet.Spec.Replicas)).To(Equal(6))
+		} else {
+			g.Expect(int(*newSet.Spec.Replicas)).To(Equal(5))
+		}
+		g.Expect(tc.Status.TiKV.CurrentScaleOutOrdinals).To(Equal(test.expectOrdinals))
+	}
+
+	tests := []testcase{
+		{
+			name:           "first step",
+			strategy:       &v1alpha1.TiKVScaleOutStrategy{},
+			errExpectFn:    errExpectNil,
+			changed:        true,
+			expectOrdinals: []int32{5},
+		},
+		{
+			name:             "store of the step is not created",
+			strategy:         &v1alpha1.TiKVScaleOutStrategy{},
+			scaleOutOrdinals: []int32{4},
+			errExpectFn:      errExpectRequeue,
+			changed:          false,
+			expectOrdinals:   []int32{4},
+		},
+		{
+			name:             "store of the step is not up",
+			strategy:         &v1alpha1.TiKVScaleOutStrategy{},
+			scaleOutOrdinals: []int32{4},
+			storeFn: func(tc *v1alpha1.TikvCluster) {
+				normalStoreFun(tc)
+				store := tc.Status.TiKV.Stores["1"]
+				store.State = v1alpha1.TiKVStateDown
+				tc.Status.TiKV.Stores["1"] = store
+			},
+			errExpectFn:    errExpectRequeue,
+			changed:        false,
+			expectOrdinals: []int32{4},
+		},
+		{
+			name:             "store of the step has received enough regions",
+			strategy:         &v1alpha1.TiKVScaleOutStrategy{MinRegionCount: controller.Int32Ptr(10)},
+			scaleOutOrdinals: []int32{4},
+			storeFn:          normalStoreFun,
+			regionCount:      10,
+			operatorCount:    3,
+			errExpectFn:      errExpectNil,
+			changed:          true,
+			expectOrdinals:   []int32{5},
+		},
+		{
+			name:             "regions are being balanced",
+			strategy:         &v1alpha1.TiKVScaleOutStrategy{MinRegionCount: controller.Int32Ptr(10)},
+			scaleOutOrdinals: []int32{4},
+			storeFn:          normalStoreFun,
+			regionCount:      5,
+			operatorCount:    3,
+			errExpectFn:      errExpectRequeue,
+			changed:          false,
+			expectOrdinals:   []int32{4},
+		},
+		{
+			name:             "regions are balanced",
+			strategy:         &v1alpha1.TiKVScaleOutStrategy{MinRegionCount: controller.Int32Ptr(10)},
+			scaleOutOrdinals: []int32{4},
+			storeFn:          normalStoreFun,
+			regionCount:      5,
+			operatorCount:    0,
+			errExpectFn:      errExpectNil,
+			changed:          true,
+			expectOrdinals:   []int32{5},
+		},
+		{
+			name:             "step is not full",
+			strategy:         &v1alpha1.TiKVScaleOutStrategy{StepSize: controller.Int32Ptr(2)},
+			scaleOutOrdinals: []int32{4},
+			operatorCount:    3,
+			errExpectFn:      errExpectNil,
+			changed:          true,
+			expectOrdinals:   []int32{4, 5},
+		},
+		{
+			name:             "staged scale out is disabled",
+			scaleOutOrdinals: []int32{4},
+			operatorCount:    3,
+			errExpectFn:      errExpectNil,
+			changed:          true,
+		},
+	}
+
+	for i := range tests {
+		testFn(&tests[i], t)
+	}
+}
+
 func TestTiKVScalerScaleIn(t *testing.T) {
 	g := NewGomegaWithT(t)
 	type testcase struct {
-		name          string
-		tikvUpgrading bool
-		storeFun      func(tc *v1alpha1.TikvCluster)
-		delStoreErr   bool
-		hasPVC        bool
-		storeIDSynced bool
-		isPodReady    bool
-		hasSynced     bool
-		pvcUpdateErr  bool
-		errExpectFn   func(*GomegaWithT, error)
-		changed       bool
+		name           string
+		tikvUpgrading  bool
+		storeFun       func(tc *v1alpha1.TikvCluster)
+		delStoreErr    bool
+		hasPVC         bool
+		storeIDSynced  bool
+		isPodReady     bool
+		hasSynced      bool
+		pvcUpdateErr   bool
+		maxReplicas    uint64
+		externalStores int
+		forceScaleIn   bool
+		errExpectFn    func(*GomegaWithT, error)
+		changed        bool
+		blocked        bool
 	}
 
 	controller.ResyncDuration = 0
@@ -168,6 +315,9 @@ func TestTiKVScalerScaleIn(t *testing.T) {
 		if test.tikvUpgrading {
 			tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
 		}
+		if test.forceScaleIn {
+			tc.Annotations = map[string]string{label.AnnForceScaleInKey: label.AnnForceScaleInVal}
+		}
 
 		oldSet := newStatefulSetForPDScale()
 		newSet := oldSet.DeepCopy()
@@ -206,6 +356,42 @@ func TestTiKVScalerScaleIn(t *testing.T) {
 
 		pdClient := controller.NewFakePDClient(pdControl, tc)
 
+		maxReplicas := uint64(3)
+		if test.maxReplicas > 0 {
+			maxReplicas = test.maxReplicas
+		}
+		pdClient.AddReaction(pdapi.GetConfigActionType, func(action *pdapi.Action) (interface{}, error) {
+			return &pdapi.PDConfigFromAPI{
+				Replication: &pdapi.PDReplicationConfig{MaxReplicas: &maxReplicas},
+			}, nil
+		})
+		pdClient.AddReaction(pdapi.GetStoresActionType, func(action *pdapi.Action) (interface{}, error) {
+			storesInfo := &pdapi.StoresInfo{
+				Stores: []*pdapi.StoreInfo{
+					{
+						Store: &pdapi.MetaStore{
+							Store: &metapb.Store{
+								Id:      1,
+								Address: fmt.Sprintf("%s-tikv-4.%s-tikv-peer.%s.svc:20160", tc.GetName(), tc.GetName(), tc.GetNamespace()),
+							},
+							StateName: v1alpha1.TiKVStateUp,
+						},
+					},
+				},
+			}
+			for i := 0; i < test.externalStores; i++ {
+				storesInfo.Stores = append(storesInfo.Stores, &pdapi.StoreInfo{
+					Store: &pdapi.MetaStore{
+						Store: &metapb.Store{
+							Id:      uint64(100 + i),
+							Address: fmt.Sprintf("10.0.0.%d:20160", i+1),
+						},
+						StateName: v1alpha1.TiKVStateUp,
+					},
+				})
+			}
+			return storesInfo, nil
+		})
 		if test.delStoreErr {
 			pdClient.AddReaction(pdapi.DeleteStoreActionType, func(action *pdapi.Action) (interface{}, error) {
 				return nil, fmt.Errorf("delete store error")
@@ -222,6 +408,18 @@ func TestTiKVScalerScaleIn(t *testing.T) {
 		} else {
 			g.Expect(int(*newSet.Spec.Replicas)).To(Equal(5))
 		}
+		cond := utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.TiKVScaleInBlocked)
+		recorder := scaler.recorder.(*record.FakeRecorder)
+		if test.blocked {
+			g.Expect(cond).NotTo(BeNil())
+			g.Expect(cond.Status).To(Equal(corev1.ConditionTrue))
+			g.Expect(cond.Reason).To(Equal(utiltikvcluster.TiKVBelowMaxReplicas))
+			g.Expect(recorder.Events).To(HaveLen(1))
+			g.Expect(<-recorder.Events).To(ContainSubstring(utiltikvcluster.TiKVBelowMaxReplicas))
+		} else {
+			g.Expect(cond).To(BeNil())
+			g.Expect(recorder.Events).To(HaveLen(0))
+		}
 	}
 
 	tests := []testcase{
@@ -251,6 +449,21 @@ func TestTiKVScalerScaleIn(t *testing.T) {
 			errExpectFn:   errExpectRequeue,
 			changed:       false,
 		},
+		{
+			name: "cluster is deleted while the store is being deleted",
+			storeFun: func(tc *v1alpha1.TikvCluster) {
+				normalStoreFun(tc)
+				tc.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+			},
+			hasPVC:        true,
+			storeIDSynced: true,
+			isPodReady:    true,
+			hasSynced:     true,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(controller.IsIgnoreError(err)).To(BeTrue())
+			},
+			changed: false,
+		},
 		{
 			name:          "tikv is upgrading",
 			tikvUpgrading: true,
@@ -407,6 +620,51 @@ func TestTiKVScalerScaleIn(t *testing.T) {
 			errExpectFn:   errExpectNotNil,
 			changed:       false,
 		},
+		{
+			name:          "scale in below pd max-replicas",
+			tikvUpgrading: false,
+			storeFun:      normalStoreFun,
+			delStoreErr:   false,
+			hasPVC:        true,
+			storeIDSynced: true,
+			isPodReady:    true,
+			hasSynced:     true,
+			pvcUpdateErr:  false,
+			maxReplicas:   5,
+			errExpectFn:   errExpectNotNil,
+			changed:       false,
+			blocked:       true,
+		},
+		{
+			name:          "scale in below pd max-replicas with force scale in annotation",
+			tikvUpgrading: false,
+			storeFun:      tombstoneStoreFun,
+			delStoreErr:   false,
+			hasPVC:        true,
+			storeIDSynced: true,
+			isPodReady:    true,
+			hasSynced:     true,
+			pvcUpdateErr:  false,
+			maxReplicas:   5,
+			forceScaleIn:  true,
+			errExpectFn:   errExpectNil,
+			changed:       true,
+		},
+		{
+			name:           "scale in below pd max-replicas with up stores outside of the statefulset",
+			tikvUpgrading:  false,
+			storeFun:       tombstoneStoreFun,
+			delStoreErr:    false,
+			hasPVC:         true,
+			storeIDSynced:  true,
+			isPodReady:     true,
+			hasSynced:      true,
+			pvcUpdateErr:   false,
+			maxReplicas:    5,
+			externalStores: 2,
+			errExpectFn:    errExpectNil,
+			changed:        true,
+		},
 		{
 			name:          "store state is tombstone, don't have pvc",
 			tikvUpgrading: false,
@@ -427,17 +685,329 @@ func TestTiKVScalerScaleIn(t *testing.T) {
 	}
 }
 
-func newFakeTiKVScaler() (*tikvScaler, *pdapi.FakePDControl, cache.Indexer, cache.Indexer, *controller.FakePVCControl) {
-	kubeCli := kubefake.NewSimpleClientset()
+func TestDesiredTiKVReplicas(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name           string
+		replicas       int32
+		actual         int32
+		tombstoneCount int
+		bulk           bool
+		expected       int32
+	}{
+		{
+			name:     "scale out",
+			replicas: 5,
+			actual:   3,
+			expected: 5,
+		},
+		{
+			name:     "no scaling",
+			replicas: 3,
+			actual:   3,
+			expected: 3,
+		},
+		{
+			name:     "scale in with stores draining",
+			replicas: 3,
+			actual:   5,
+			expected: 5,
+		},
+		{
+			name:           "scale in one store at a time",
+			replicas:       3,
+			actual:         5,
+			tombstoneCount: 2,
+			expected:       4,
+		},
+		{
+			name:           "scale in the last store drained",
+			replicas:       3,
+			actual:         4,
+			tombstoneCount: 1,
+			expected:       3,
+		},
+		{
+			name:           "bulk scale in",
+			replicas:       2,
+			actual:         5,
+			tombstoneCount: 2,
+			bulk:           true,
+			expected:       3,
+		},
+		{
+			name:           "bulk scale in does not go below the desired replicas",
+			replicas:       3,
+			actual:         5,
+			tombstoneCount: 3,
+			bulk:           true,
+			expected:       3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvClusterForPD()
+			tc.Spec.TiKV.Replicas = tt.replicas
+			tc.Spec.TiKV.ScaleIn = &v1alpha1.TiKVScaleInStrategy{Bulk: tt.bulk}
+			tc.Status.TiKV.StatefulSet = &apps.StatefulSetStatus{Replicas: tt.actual}
+			g.Expect(DesiredTiKVReplicas(tc, tt.tombstoneCount)).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestTiKVScalerBulkScaleIn(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name             string
+		states           map[int32]string
+		errExpectFn      func(*GomegaWithT, error)
+		expectedReplicas int32
+		deletedStores    []uint64
+	}{
+		{
+			name:             "all the stores are decommissioned at once",
+			states:           map[int32]string{3: v1alpha1.TiKVStateUp, 4: v1alpha1.TiKVStateUp},
+			errExpectFn:      errExpectRequeue,
+			expectedReplicas: 5,
+			deletedStores:    []uint64{4, 5},
+		},
+		{
+			name:             "the pod of the highest ordinal is removed once its store is tombstone",
+			states:           map[int32]string{3: v1alpha1.TiKVStateOffline, 4: v1alpha1.TiKVStateTombstone},
+			errExpectFn:      errExpectRequeue,
+			expectedReplicas: 4,
+		},
+		{
+			name:             "a pod is not removed before the pods of higher ordinals",
+			states:           map[int32]string{3: v1alpha1.TiKVStateTombstone, 4: v1alpha1.TiKVStateOffline},
+			errExpectFn:      errExpectRequeue,
+			expectedReplicas: 5,
+		},
+		{
+			name:             "all the stores are tombstone",
+			states:           map[int32]string{3: v1alpha1.TiKVStateTombstone, 4: v1alpha1.TiKVStateTombstone},
+			errExpectFn:      errExpectNil,
+			expectedReplicas: 3,
+		},
+		{
+			name:             "falls back to one store at a time without the store of a pod",
+			states:           map[int32]string{3: v1alpha1.TiKVStateUp},
+			errExpectFn:      errExpectNotNil,
+			expectedReplicas: 5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvClusterForPD()
+			tc.Annotations = map[string]string{label.AnnForceScaleInKey: label.AnnForceScaleInVal}
+			tc.Spec.TiKV.ScaleIn = &v1alpha1.TiKVScaleInStrategy{Bulk: true}
+			tc.Status.TiKV.StatefulSet = &apps.StatefulSetStatus{Replicas: 5}
+			tc.Status.TiKV.Stores = map[string]v1alpha1.TiKVStore{}
+			tc.Status.TiKV.TombstoneStores = map[string]v1alpha1.TiKVStore{}
+
+			oldSet := newStatefulSetForPDScale()
+			newSet := oldSet.DeepCopy()
+			newSet.Spec.Replicas = controller.Int32Ptr(3)
+
+			scaler, pdControl, pvcIndexer, podIndexer, _ := newFakeTiKVScaler()
+			for ordinal := int32(3); ordinal < 5; ordinal++ {
+				podName := ordinalPodName(v1alpha1.TiKVMemberType, tc.GetName(), ordinal)
+				id := fmt.Sprintf("%d", ordinal+1)
+				pod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      podName,
+						Namespace: corev1.NamespaceDefault,
+						Labels:    map[string]string{label.StoreIDLabelKey: id},
+					},
+				}
+				readyPodFunc(pod)
+				podIndexer.Add(pod)
+				l := label.New().Instance(tc.GetName())
+				l[label.AnnPodNameKey] = podName
+				pvcIndexer.Add(&corev1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      ordinalPVCName(v1alpha1.TiKVMemberType, oldSet.GetName(), ordinal),
+						Namespace: corev1.NamespaceDefault,
+						Labels:    l,
+					},
+				})
+				state, ok := tt.states[ordinal]
+				if !ok {
+					continue
+				}
+				store := v1alpha1.TiKVStore{ID: id, PodName: podName, State: state}
+				if state == v1alpha1.TiKVStateTombstone {
+					tc.Status.TiKV.TombstoneStores[id] = store
+				} else {
+					tc.Status.TiKV.Stores[id] = store
+				}
+			}
+
+			var deletedStores []uint64
+			pdClient := controller.NewFakePDClient(pdControl, tc)
+			pdClient.AddReaction(pdapi.DeleteStoreActionType, func(action *pdapi.Action) (interface{}, error) {
+				deletedStores = append(deletedStores, action.ID)
+				return nil, nil
+			})
+
+			err := scaler.ScaleIn(tc, oldSet, newSet)
+			tt.errExpectFn(g, err)
+			g.Expect(*newSet.Spec.Replicas).To(Equal(tt.expectedReplicas))
+			g.Expect(deletedStores).To(ConsistOf(tt.deletedStores))
+		})
+	}
+}
+
+func TestTiKVScalerScaleInConnectionDrain(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name             string
+		drainedFor       *time.Duration
+		skip             bool
+		errExpectFn      func(*GomegaWithT, error)
+		expectedReplicas int32
+		expectedPhase    v1alpha1.TiKVScaleInPhase
+	}{
+		{
+			name:             "the draining begins once the store is tombstone",
+			errExpectFn:      errExpectRequeue,
+			expectedReplicas: 5,
+			expectedPhase:    v1alpha1.TiKVScaleInPhaseDrainConnections,
+		},
+		{
+			name:             "the pod is kept until the deadline",
+			drainedFor:       durationPtr(10 * time.Second),
+			errExpectFn:      errExpectRequeue,
+			expectedReplicas: 5,
+			expectedPhase:    v1alpha1.TiKVScaleInPhaseDrainConnections,
+		},
+		{
+			name:             "the pod is removed after the deadline",
+			drainedFor:       durationPtr(time.Minute),
+			errExpectFn:      errExpectNil,
+			expectedReplicas: 4,
+		},
+		{
+			name:             "the draining is skipped",
+			skip:             true,
+			errExpectFn:      errExpectNil,
+			expectedReplicas: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvClusterForPD()
+			tc.Annotations = map[string]string{label.AnnForceScaleInKey: label.AnnForceScaleInVal}
+			if tt.skip {
+				tc.Annotations[label.AnnSkipConnectionDrainKey] = label.AnnSkipConnectionDrainVal
+			}
+			tc.Spec.TiKV.ScalePolicy = &v1alpha1.TiKVScalePolicy{ConnectionDrainSeconds: 30}
+			tombstoneStoreFun(tc)
 
-	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeCli, 0)
-	pvcInformer := kubeInformerFactory.Core().V1().PersistentVolumeClaims()
-	podInformer := kubeInformerFactory.Core().V1().Pods()
-	pdControl := pdapi.NewFakePDControl(kubeCli)
-	pvcControl := controller.NewFakePVCControl(pvcInformer)
+			oldSet := newStatefulSetForPDScale()
+			newSet := oldSet.DeepCopy()
+			newSet.Spec.Replicas = controller.Int32Ptr(4)
+
+			scaler, _, pvcIndexer, podIndexer, _ := newFakeTiKVScaler()
+			fakeClock := scaler.clock.(*clock.FakeClock)
+			podName := ordinalPodName(v1alpha1.TiKVMemberType, tc.GetName(), 4)
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      podName,
+					Namespace: corev1.NamespaceDefault,
+					Labels:    map[string]string{label.StoreIDLabelKey: "1"},
+				},
+			}
+			if tt.drainedFor != nil {
+				beginTime := fakeClock.Now().Add(-*tt.drainedFor)
+				pod.Annotations = map[string]string{label.AnnConnectionDrainBeginTime: beginTime.Format(time.RFC3339)}
+			}
+			podIndexer.Add(pod)
+			pvcIndexer.Add(&corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      ordinalPVCName(v1alpha1.TiKVMemberType, oldSet.GetName(), 4),
+					Namespace: corev1.NamespaceDefault,
+				},
+			})
+
+			err := scaler.ScaleIn(tc, oldSet, newSet)
+			tt.errExpectFn(g, err)
+			g.Expect(*newSet.Spec.Replicas).To(Equal(tt.expectedReplicas))
+			if tt.expectedPhase == "" {
+				g.Expect(tc.Status.TiKV.ScaleIn).To(BeNil())
+				return
+			}
+			g.Expect(tc.Status.TiKV.ScaleIn.Phase).To(Equal(tt.expectedPhase))
+			g.Expect(tc.Status.TiKV.ScaleIn.Pods).To(Equal([]string{podName}))
+			g.Expect(tc.Status.TiKV.ScaleIn.ConnectionDrainDeadline.Time).To(BeTemporally(">", fakeClock.Now()))
+			obj, _, err := podIndexer.GetByKey(corev1.NamespaceDefault + "/" + podName)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(obj.(*corev1.Pod).Annotations).To(HaveKey(label.AnnConnectionDrainBeginTime))
+		})
+	}
+}
+
+func TestTiKVScalerScaleInConnectionDrainDeadline(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tc := newTikvClusterForPD()
+	tc.Annotations = map[string]string{label.AnnForceScaleInKey: label.AnnForceScaleInVal}
+	tc.Spec.TiKV.ScalePolicy = &v1alpha1.TiKVScalePolicy{ConnectionDrainSeconds: 30}
+	tombstoneStoreFun(tc)
+
+	scaler, _, pvcIndexer, podIndexer, _ := newFakeTiKVScaler()
+	fakeClock := scaler.clock.(*clock.FakeClock)
+	begin := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fakeClock.SetTime(begin)
+
+	oldSet := newStatefulSetForPDScale()
+	podName := ordinalPodName(v1alpha1.TiKVMemberType, tc.GetName(), 4)
+	podIndexer.Add(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      podName,
+			Namespace: corev1.NamespaceDefault,
+			Labels:    map[string]string{label.StoreIDLabelKey: "1"},
+		},
+	})
+	pvcIndexer.Add(&corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ordinalPVCName(v1alpha1.TiKVMemberType, oldSet.GetName(), 4),
+			Namespace: corev1.NamespaceDefault,
+		},
+	})
+	scaleIn := func() (*apps.StatefulSet, error) {
+		newSet := oldSet.DeepCopy()
+		newSet.Spec.Replicas = controller.Int32Ptr(4)
+		return newSet, scaler.ScaleIn(tc, oldSet, newSet)
+	}
+
+	newSet, err := scaleIn()
+	g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+	g.Expect(*newSet.Spec.Replicas).To(Equal(int32(5)))
+	g.Expect(tc.Status.TiKV.ScaleIn.ConnectionDrainDeadline.Time).To(BeTemporally("==", begin.Add(30*time.Second)))
+
+	fakeClock.Step(29 * time.Second)
+	newSet, err = scaleIn()
+	g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+	g.Expect(*newSet.Spec.Replicas).To(Equal(int32(5)))
+	g.Expect(tc.Status.TiKV.ScaleIn.ConnectionDrainDeadline.Time).To(BeTemporally("==", begin.Add(30*time.Second)))
+
+	fakeClock.Step(time.Second)
+	newSet, err = scaleIn()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(*newSet.Spec.Replicas).To(Equal(int32(4)))
+}
+
+func durationPtr(d time.Duration) *time.Duration {
+	return &d
+}
+
+func newFakeTiKVScaler() (*tikvScaler, *pdapi.FakePDControl, cache.Indexer, cache.Indexer, *controller.FakePVCControl) {
+	d := deps.NewFakeDependencies()
+	pvcInformer := d.KubeInformerFactory.Core().V1().PersistentVolumeClaims()
+	podInformer := d.KubeInformerFactory.Core().V1().Pods()
 
-	return &tikvScaler{generalScaler{pdControl, pvcInformer.Lister(), pvcControl}, podInformer.Lister()},
-		pdControl, pvcInformer.Informer().GetIndexer(), podInformer.Informer().GetIndexer(), pvcControl
+	return NewTiKVScaler(d).(*tikvScaler),
+		d.PDControl.(*pdapi.FakePDControl), pvcInformer.Informer().GetIndexer(), podInformer.Informer().GetIndexer(), d.PVCControl.(*controller.FakePVCControl)
 }
 
 func normalStoreFun(tc *v1alpha1.TikvCluster) {
@@ -0,0 +1,312 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"fmt"
+
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/features"
+	"github.com/tikv/tikv-operator/pkg/pdapi"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+// TiKVStorageMigrator implements the online migration of the tikv stores to the storage class
+// of spec.tikv.storageMigration
+//
+// The volume claim templates of a StatefulSet are immutable, the PVCs of the pods to be created
+// are created on the target storage class ahead of the StatefulSet instead. A step of the
+// migration lists the pod of a store on another storage class in spec.tikv.offlineStores, the
+// ordinal of the pod becomes a delete slot so that the scaler adds a pod of a new ordinal before
+// the store is decommissioned and its pod removed. The next step waits for the store added to be
+// Up and for the regions to be balanced.
+type TiKVStorageMigrator interface {
+	Migrate(tc *v1alpha1.TikvCluster) error
+}
+
+type tikvStorageMigrator struct {
+	pdControl  pdapi.PDControlInterface
+	setLister  v1.StatefulSetLister
+	pvcLister  corelisters.PersistentVolumeClaimLister
+	pvcControl controller.GeneralPVCControlInterface
+	recorder   record.EventRecorder
+}
+
+// NewTiKVStorageMigrator returns a TiKVStorageMigrator
+func NewTiKVStorageMigrator(pdControl pdapi.PDControlInterface,
+	setLister v1.StatefulSetLister,
+	pvcLister corelisters.PersistentVolumeClaimLister,
+	pvcControl controller.GeneralPVCControlInterface,
+	recorder record.EventRecorder) TiKVStorageMigrator {
+	return &tikvStorageMigrator{pdControl, setLister, pvcLister, pvcControl, recorder}
+}
+
+func (m *tikvStorageMigrator) Migrate(tc *v1alpha1.TikvCluster) error {
+	ns := tc.GetNamespace()
+	tcName := tc.GetName()
+	migration := tc.Spec.TiKV.StorageMigration
+	if migration == nil {
+		if tc.Status.TiKV.StorageMigration != nil {
+			m.abort(tc)
+		}
+		// the pods created after a migration have their PVCs on the storage class migrated to
+		if tc.Spec.TiKV.StorageClassName == nil {
+			return nil
+		}
+		return m.createPVCs(tc, *tc.Spec.TiKV.StorageClassName)
+	}
+	target := migration.StorageClassName
+	if !features.DefaultFeatureGate.Enabled(features.AdvancedStatefulSet) {
+		msg := fmt.Sprintf("can not migrate the tikv stores to storage class %s, the AdvancedStatefulSet feature is disabled", target)
+		klog.Warningf("tikv cluster %s/%s: %s", ns, tcName, msg)
+		m.recorder.Event(tc, corev1.EventTypeWarning, "StorageMigrationUnsupported", msg)
+		return nil
+	}
+
+	status := tc.Status.TiKV.StorageMigration
+	if status == nil {
+		status = &v1alpha1.TiKVStorageMigrationStatus{StartTime: metav1.Now()}
+		tc.Status.TiKV.StorageMigration = status
+	}
+	if status.StorageClassName != target {
+		// the store being replaced goes on to the new target
+		status.StorageClassName = target
+		m.recorder.Event(tc, corev1.EventTypeNormal, "StorageMigrationStarted", fmt.Sprintf("migrating the tikv stores to storage class %s", target))
+	}
+	if err := m.createPVCs(tc, target); err != nil {
+		return err
+	}
+
+	if status.CurrentStore != "" {
+		m.listOfflineStore(tc, status.CurrentStore)
+		done, waitingFor, err := m.stepDone(tc)
+		if err != nil {
+			return err
+		}
+		if !done {
+			return controller.RequeueErrorf("tikv cluster %s/%s: migrating store of pod %s to storage class %s, %s", ns, tcName, status.CurrentStore, target, waitingFor)
+		}
+		klog.Infof("tikv cluster %s/%s: store of pod %s replaced by pod %s on storage class %s", ns, tcName, status.CurrentStore, status.NewStore, target)
+		status.MigratedStores++
+		status.CurrentStore = ""
+		status.NewStore = ""
+	}
+
+	podNames, err := m.podsToMigrate(tc, target)
+	if err != nil {
+		return err
+	}
+	status.RemainingStores = int32(len(podNames))
+	if len(podNames) == 0 {
+		tc.Spec.TiKV.StorageClassName = &target
+		tc.Spec.TiKV.StorageMigration = nil
+		tc.Status.TiKV.StorageMigration = nil
+		msg := fmt.Sprintf("migrated %d tikv stores to storage class %s", status.MigratedStores, target)
+		klog.Infof("tikv cluster %s/%s: %s", ns, tcName, msg)
+		m.recorder.Event(tc, corev1.EventTypeNormal, "StorageMigrated", msg)
+		return nil
+	}
+
+	if tc.TiKVUpgrading() || len(tc.Status.TiKV.FailureStores) > 0 {
+		return controller.RequeueErrorf("tikv cluster %s/%s: tikv is upgrading or failing over, the storage migration waits", ns, tcName)
+	}
+	podName := podNames[0]
+	var store *v1alpha1.TiKVOfflineStore
+	for id, s := range tc.Status.TiKV.Stores {
+		if s.PodName == podName && s.State == v1alpha1.TiKVStateUp {
+			store = &v1alpha1.TiKVOfflineStore{ID: id, PodName: podName}
+			break
+		}
+	}
+	if store == nil {
+		return controller.RequeueErrorf("tikv cluster %s/%s: the storage migration waits for the store of pod %s to be Up", ns, tcName, podName)
+	}
+
+	// the pod of the store becomes a delete slot, replaced by a pod of a new ordinal
+	before := tc.TiKVStsDesiredOrdinals(false)
+	m.listOfflineStore(tc, podName)
+	if tc.Status.TiKV.OfflineStores == nil {
+		tc.Status.TiKV.OfflineStores = map[string]v1alpha1.TiKVOfflineStore{}
+	}
+	tc.Status.TiKV.OfflineStores[podName] = *store
+	added := tc.TiKVStsDesiredOrdinals(false).Difference(before).List()
+	if len(added) > 0 {
+		status.NewStore = ordinalPodName(v1alpha1.TiKVMemberType, tcName, added[0])
+	}
+	status.CurrentStore = podName
+	if err := m.createPVCs(tc, target); err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("replacing the store %s of pod %s by pod %s on storage class %s, %d stores remaining", store.ID, podName, status.NewStore, target, status.RemainingStores)
+	klog.Infof("tikv cluster %s/%s: %s", ns, tcName, msg)
+	m.recorder.Event(tc, corev1.EventTypeNormal, "StorageMigrationStep", msg)
+	return nil
+}
+
+// abort stops the migration, the store being replaced is kept unless it has become tombstone,
+// cancelling its decommission
+func (m *tikvStorageMigrator) abort(tc *v1alpha1.TikvCluster) {
+	status := tc.Status.TiKV.StorageMigration
+	if status.CurrentStore != "" {
+		phase := tc.Status.TiKV.OfflineStores[status.CurrentStore].Phase
+		if phase != v1alpha1.OfflineStorePhaseTombstone && phase != v1alpha1.OfflineStorePhaseRemoved {
+			var offlineStores []string
+			for _, entry := range tc.Spec.TiKV.OfflineStores {
+				if entry != status.CurrentStore {
+					offlineStores = append(offlineStores, entry)
+				}
+			}
+			tc.Spec.TiKV.OfflineStores = offlineStores
+		}
+	}
+	tc.Status.TiKV.StorageMigration = nil
+	msg := fmt.Sprintf("aborted the migration of the tikv stores to storage class %s after %d stores", status.StorageClassName, status.MigratedStores)
+	klog.Infof("tikv cluster %s/%s: %s", tc.GetNamespace(), tc.GetName(), msg)
+	m.recorder.Event(tc, corev1.EventTypeNormal, "StorageMigrationAborted", msg)
+}
+
+// listOfflineStore lists the pod in spec.tikv.offlineStores unless it is listed already
+func (m *tikvStorageMigrator) listOfflineStore(tc *v1alpha1.TikvCluster, podName string) {
+	for _, entry := range tc.Spec.TiKV.OfflineStores {
+		if entry == podName {
+			return
+		}
+	}
+	tc.Spec.TiKV.OfflineStores = append(tc.Spec.TiKV.OfflineStores, podName)
+}
+
+// stepDone tells whether the store being replaced is removed, the store added for it is Up
+// and pd has no pending operators, otherwise it returns what is being waited for
+func (m *tikvStorageMigrator) stepDone(tc *v1alpha1.TikvCluster) (bool, string, error) {
+	status := tc.Status.TiKV.StorageMigration
+	offlineStore, ok := tc.Status.TiKV.OfflineStores[status.CurrentStore]
+	if !ok || offlineStore.Phase != v1alpha1.OfflineStorePhaseRemoved {
+		return false, fmt.Sprintf("waiting for the store of pod %s to be removed", status.CurrentStore), nil
+	}
+	if status.NewStore != "" {
+		up := false
+		for _, store := range tc.Status.TiKV.Stores {
+			if store.PodName == status.NewStore && store.State == v1alpha1.TiKVStateUp {
+				up = true
+				break
+			}
+		}
+		if !up {
+			return false, fmt.Sprintf("waiting for the store of pod %s to be Up", status.NewStore), nil
+		}
+	}
+	count, err := controller.GetPDClient(m.pdControl, tc).GetOperatorCount()
+	if err != nil {
+		return false, "", err
+	}
+	if count > 0 {
+		return false, fmt.Sprintf("%d operators are pending in pd", count), nil
+	}
+	return true, "", nil
+}
+
+// podsToMigrate returns the pods of the stores whose PVCs are on another storage class than
+// target, the stores decommissioned are left out
+func (m *tikvStorageMigrator) podsToMigrate(tc *v1alpha1.TikvCluster, target string) ([]string, error) {
+	ns := tc.GetNamespace()
+	setName := controller.TiKVMemberName(tc.GetName())
+	var podNames []string
+	for _, ordinal := range tc.TiKVStsDesiredOrdinals(false).List() {
+		pvc, err := m.pvcLister.PersistentVolumeClaims(ns).Get(ordinalPVCName(v1alpha1.TiKVMemberType, setName, ordinal))
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if pvc.Spec.StorageClassName != nil && *pvc.Spec.StorageClassName == target {
+			continue
+		}
+		podNames = append(podNames, ordinalPodName(v1alpha1.TiKVMemberType, tc.GetName(), ordinal))
+	}
+	return podNames, nil
+}
+
+// createPVCs creates the PVCs of the desired pods not created yet on the storage class when the
+// volume claim template of the statefulset is on another storage class
+func (m *tikvStorageMigrator) createPVCs(tc *v1alpha1.TikvCluster, storageClassName string) error {
+	ns := tc.GetNamespace()
+	setName := controller.TiKVMemberName(tc.GetName())
+	set, err := m.setLister.StatefulSets(ns).Get(setName)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, template := range set.Spec.VolumeClaimTemplates {
+		if template.Name != v1alpha1.TiKVMemberType.String() {
+			continue
+		}
+		if template.Spec.StorageClassName != nil && *template.Spec.StorageClassName == storageClassName {
+			return nil
+		}
+	}
+
+	storageRequest, err := controller.ParseStorageRequest(tc.Spec.TiKV.Requests)
+	if err != nil {
+		return err
+	}
+	for _, ordinal := range tc.TiKVStsDesiredOrdinals(false).List() {
+		pvcName := ordinalPVCName(v1alpha1.TiKVMemberType, setName, ordinal)
+		_, err := m.pvcLister.PersistentVolumeClaims(ns).Get(pvcName)
+		if err == nil {
+			continue
+		}
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		pvc := tikvVolumeClaimTemplate(tc, storageRequest, pvcName, &storageClassName)
+		pvc.Namespace = ns
+		pvc.Labels = controller.MemberLabels(tc, v1alpha1.TiKVMemberType)
+		if err := m.pvcControl.CreatePVC(tc, &pvc); err != nil {
+			return err
+		}
+		klog.Infof("tikv cluster %s/%s: created pvc %s on storage class %s", ns, tc.GetName(), pvcName, storageClassName)
+	}
+	return nil
+}
+
+var _ TiKVStorageMigrator = &tikvStorageMigrator{}
+
+type FakeTiKVStorageMigrator struct {
+	err error
+}
+
+// NewFakeTiKVStorageMigrator returns a fake tikv storage migrator
+func NewFakeTiKVStorageMigrator() *FakeTiKVStorageMigrator {
+	return &FakeTiKVStorageMigrator{}
+}
+
+func (fm *FakeTiKVStorageMigrator) SetMigrateError(err error) {
+	fm.err = err
+}
+
+func (fm *FakeTiKVStorageMigrator) Migrate(_ *v1alpha1.TikvCluster) error {
+	return fm.err
+}
+
+var _ TiKVStorageMigrator = &FakeTiKVStorageMigrator{}
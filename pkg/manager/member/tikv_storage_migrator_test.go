@@ -0,0 +1,196 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/features"
+	"github.com/tikv/tikv-operator/pkg/pdapi"
+	apps "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+)
+
+func TestTiKVStorageMigratorMigrate(t *testing.T) {
+	g := NewGomegaWithT(t)
+	features.DefaultFeatureGate.Set("AdvancedStatefulSet=true")
+	defer features.DefaultFeatureGate.Set("AdvancedStatefulSet=false")
+
+	type testcase struct {
+		name        string
+		prepare     func(*v1alpha1.TikvCluster)
+		migrated    []int32
+		operators   int
+		errExpectFn func(*GomegaWithT, error)
+		expectFn    func(*GomegaWithT, *v1alpha1.TikvCluster, *controller.FakeGeneralPVCControl)
+	}
+	testFn := func(test *testcase) {
+		t.Log(test.name)
+
+		tc := newTikvClusterForPDDiscovery()
+		tc.Spec.TiKV.Replicas = 2
+		tc.Spec.TiKV.StorageClassName = pointer.StringPtr("old")
+		tc.Spec.TiKV.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+		tc.Spec.TiKV.StorageMigration = &v1alpha1.TiKVStorageMigration{StorageClassName: "new"}
+		tc.Status.TiKV.Stores = map[string]v1alpha1.TiKVStore{
+			"1": {ID: "1", PodName: "test-tikv-0", State: v1alpha1.TiKVStateUp},
+			"2": {ID: "2", PodName: "test-tikv-1", State: v1alpha1.TiKVStateUp},
+		}
+		if test.prepare != nil {
+			test.prepare(tc)
+		}
+
+		kubeCli := kubefake.NewSimpleClientset()
+		kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeCli, 0)
+		setInformer := kubeInformerFactory.Apps().V1().StatefulSets()
+		pvcInformer := kubeInformerFactory.Core().V1().PersistentVolumeClaims()
+		pdControl := pdapi.NewFakePDControl(kubeCli)
+		pdClient := controller.NewFakePDClient(pdControl, tc)
+		pdClient.AddReaction(pdapi.GetOperatorCountActionType, func(action *pdapi.Action) (interface{}, error) {
+			return test.operators, nil
+		})
+		pvcControl := controller.NewFakeGeneralPVCControl(pvcInformer)
+		migrator := NewTiKVStorageMigrator(pdControl, setInformer.Lister(), pvcInformer.Lister(), pvcControl, record.NewFakeRecorder(10))
+
+		setName := controller.TiKVMemberName(tc.GetName())
+		set := &apps.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: tc.GetNamespace(), Name: setName},
+			Spec: apps.StatefulSetSpec{
+				VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+					volumeClaimTemplate(corev1.ResourceRequirements{}, v1alpha1.TiKVMemberType.String(), pointer.StringPtr("old")),
+				},
+			},
+		}
+		g.Expect(setInformer.Informer().GetIndexer().Add(set)).To(Succeed())
+		for ordinal := int32(0); ordinal < 2; ordinal++ {
+			class := "old"
+			for _, migrated := range test.migrated {
+				if migrated == ordinal {
+					class = "new"
+				}
+			}
+			pvc := volumeClaimTemplate(corev1.ResourceRequirements{}, ordinalPVCName(v1alpha1.TiKVMemberType, setName, ordinal), &class)
+			pvc.Namespace = tc.GetNamespace()
+			g.Expect(pvcInformer.Informer().GetIndexer().Add(&pvc)).To(Succeed())
+		}
+
+		err := migrator.Migrate(tc)
+		test.errExpectFn(g, err)
+		test.expectFn(g, tc, pvcControl)
+	}
+
+	tests := []testcase{
+		{
+			name: "starts the first step",
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, pvcControl *controller.FakeGeneralPVCControl) {
+				g.Expect(tc.Spec.TiKV.OfflineStores).To(Equal([]string{"test-tikv-0"}))
+				g.Expect(tc.Status.TiKV.OfflineStores).To(HaveKey("test-tikv-0"))
+				status := tc.Status.TiKV.StorageMigration
+				g.Expect(status).NotTo(BeNil())
+				g.Expect(status.StorageClassName).To(Equal("new"))
+				g.Expect(status.CurrentStore).To(Equal("test-tikv-0"))
+				g.Expect(status.NewStore).To(Equal("test-tikv-2"))
+				g.Expect(status.RemainingStores).To(Equal(int32(2)))
+				pvc, err := pvcControl.PVCLister.PersistentVolumeClaims(tc.GetNamespace()).Get("tikv-test-tikv-2")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(*pvc.Spec.StorageClassName).To(Equal("new"))
+			},
+		},
+		{
+			name: "waits for the store replaced to be removed",
+			prepare: func(tc *v1alpha1.TikvCluster) {
+				tc.Spec.TiKV.OfflineStores = []string{"test-tikv-0"}
+				tc.Status.TiKV.OfflineStores = map[string]v1alpha1.TiKVOfflineStore{
+					"test-tikv-0": {ID: "1", PodName: "test-tikv-0", Phase: v1alpha1.OfflineStorePhaseOffline},
+				}
+				tc.Status.TiKV.StorageMigration = &v1alpha1.TiKVStorageMigrationStatus{StorageClassName: "new", CurrentStore: "test-tikv-0", NewStore: "test-tikv-2"}
+			},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, _ *controller.FakeGeneralPVCControl) {
+				g.Expect(tc.Status.TiKV.StorageMigration.CurrentStore).To(Equal("test-tikv-0"))
+				g.Expect(tc.Status.TiKV.StorageMigration.MigratedStores).To(Equal(int32(0)))
+			},
+		},
+		{
+			name: "waits for the regions to be balanced",
+			prepare: func(tc *v1alpha1.TikvCluster) {
+				tc.Spec.TiKV.OfflineStores = []string{"test-tikv-0"}
+				tc.Status.TiKV.OfflineStores = map[string]v1alpha1.TiKVOfflineStore{
+					"test-tikv-0": {ID: "1", PodName: "test-tikv-0", Phase: v1alpha1.OfflineStorePhaseRemoved},
+				}
+				tc.Status.TiKV.Stores["3"] = v1alpha1.TiKVStore{ID: "3", PodName: "test-tikv-2", State: v1alpha1.TiKVStateUp}
+				tc.Status.TiKV.StorageMigration = &v1alpha1.TiKVStorageMigrationStatus{StorageClassName: "new", CurrentStore: "test-tikv-0", NewStore: "test-tikv-2"}
+			},
+			operators: 3,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, _ *controller.FakeGeneralPVCControl) {
+				g.Expect(tc.Status.TiKV.StorageMigration.CurrentStore).To(Equal("test-tikv-0"))
+			},
+		},
+		{
+			name:     "completes the migration",
+			migrated: []int32{0, 1},
+			prepare: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.TiKV.StorageMigration = &v1alpha1.TiKVStorageMigrationStatus{StorageClassName: "new", MigratedStores: 2}
+			},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, _ *controller.FakeGeneralPVCControl) {
+				g.Expect(*tc.Spec.TiKV.StorageClassName).To(Equal("new"))
+				g.Expect(tc.Spec.TiKV.StorageMigration).To(BeNil())
+				g.Expect(tc.Status.TiKV.StorageMigration).To(BeNil())
+			},
+		},
+		{
+			name: "aborts with the store replaced kept",
+			prepare: func(tc *v1alpha1.TikvCluster) {
+				tc.Spec.TiKV.StorageMigration = nil
+				tc.Spec.TiKV.OfflineStores = []string{"test-tikv-0"}
+				tc.Status.TiKV.OfflineStores = map[string]v1alpha1.TiKVOfflineStore{
+					"test-tikv-0": {ID: "1", PodName: "test-tikv-0", Phase: v1alpha1.OfflineStorePhaseOffline},
+				}
+				tc.Status.TiKV.StorageMigration = &v1alpha1.TiKVStorageMigrationStatus{StorageClassName: "new", CurrentStore: "test-tikv-0", NewStore: "test-tikv-2"}
+			},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, _ *controller.FakeGeneralPVCControl) {
+				g.Expect(tc.Spec.TiKV.OfflineStores).To(BeEmpty())
+				g.Expect(tc.Status.TiKV.StorageMigration).To(BeNil())
+				g.Expect(*tc.Spec.TiKV.StorageClassName).To(Equal("old"))
+			},
+		},
+	}
+
+	for i := range tests {
+		testFn(&tests[i])
+	}
+}
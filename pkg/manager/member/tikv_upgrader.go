@@ -15,46 +15,76 @@ package member
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pingcap/advanced-statefulset/client/apis/apps/v1/helper"
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
 	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/deps"
 	"github.com/tikv/tikv-operator/pkg/pdapi"
+	utiltikvcluster "github.com/tikv/tikv-operator/pkg/util/tikvcluster"
 	apps "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/sets"
 	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
+	podutil "k8s.io/kubernetes/pkg/api/v1/pod"
 )
 
 const (
 	// EvictLeaderBeginTime is the key of evict Leader begin time
 	EvictLeaderBeginTime = "evictLeaderBeginTime"
-	// EvictLeaderTimeout is the timeout limit of evict leader
+	// EvictLeaderTimeout is the default timeout limit of evict leader
 	EvictLeaderTimeout = 3 * time.Minute
+	// UpgradeStallTimeout is the default time an upgraded pod may be not ready before the upgrade is reported as stalled
+	UpgradeStallTimeout = 10 * time.Minute
+
+	// defaultMaxReplicas is the max-replicas of pd if it is not set
+	defaultMaxReplicas = 3
 )
 
 type tikvUpgrader struct {
-	pdControl  pdapi.PDControlInterface
-	podControl controller.PodControlInterface
-	podLister  corelisters.PodLister
+	pdControl   pdapi.PDControlInterface
+	podControl  controller.PodControlInterface
+	podLister   corelisters.PodLister
+	recorder    record.EventRecorder
+	clock       clock.Clock
+	dnsVerifier *podDNSVerifier
 }
 
 // NewTiKVUpgrader returns a tikv Upgrader
-func NewTiKVUpgrader(pdControl pdapi.PDControlInterface,
-	podControl controller.PodControlInterface,
-	podLister corelisters.PodLister) Upgrader {
+func NewTiKVUpgrader(deps *deps.Dependencies) Upgrader {
 	return &tikvUpgrader{
-		pdControl:  pdControl,
-		podControl: podControl,
-		podLister:  podLister,
+		pdControl:   deps.PDControl,
+		podControl:  deps.PodControl,
+		podLister:   deps.PodLister,
+		recorder:    deps.Recorder,
+		clock:       deps.Clock,
+		dnsVerifier: newPodDNSVerifier(deps.Recorder),
 	}
 }
 
 func (tku *tikvUpgrader) Upgrade(tc *v1alpha1.TikvCluster, oldSet *apps.StatefulSet, newSet *apps.StatefulSet) error {
 	ns := tc.GetNamespace()
 	tcName := tc.GetName()
+	// the condition is reset unless the pod upgraded last is still not ready, e.g. the spec changed
+	stalled := false
+	defer func() {
+		if !stalled {
+			resetUpgradeStalledCondition(tc)
+		}
+	}()
+	// leaders are not evicted from the stores of a cluster being deleted
+	if err := checkClusterDeleting(tc, "upgrading tikv"); err != nil {
+		return err
+	}
 	if tc.Status.PD.Phase == v1alpha1.UpgradePhase {
 		_, podSpec, err := GetLastAppliedConfig(oldSet)
 		if err != nil {
@@ -64,7 +94,9 @@ func (tku *tikvUpgrader) Upgrade(tc *v1alpha1.TikvCluster, oldSet *apps.Stateful
 		return nil
 	}
 
-	if !tc.Status.TiKV.Synced {
+	// the forced upgrade goes on even if pd is down and the status of the stores can not be synced
+	force := forceUpgradeAllowed(tc)
+	if !tc.Status.TiKV.Synced && !force {
 		return fmt.Errorf("Tidbcluster: [%s/%s]'s tikv status sync failed, can not to be upgraded", ns, tcName)
 	}
 
@@ -73,11 +105,47 @@ func (tku *tikvUpgrader) Upgrade(tc *v1alpha1.TikvCluster, oldSet *apps.Stateful
 		return nil
 	}
 
-	if tc.Status.TiKV.StatefulSet.UpdateRevision == tc.Status.TiKV.StatefulSet.CurrentRevision {
+	if newSet.Spec.UpdateStrategy.Type == apps.OnDeleteStatefulSetStrategyType {
+		// the pods are upgraded once they are deleted by the users, the status sync still
+		// reports the upgrade until all of them run the update revision
+		tc.Status.TiKV.RollingBackFrom = ""
 		return nil
 	}
+	if onDeleteStrategyApplied(oldSet) {
+		// switching back to RollingUpdate, the partition keeps the pods running until it is
+		// applied and the upgrade goes on from the next sync
+		return nil
+	}
+
+	// the revisions are stale until the statefulset controller observes the reverted spec of a
+	// rollback, acting on them would upgrade more pods to the revision being rolled back from
+	if oldSet.Status.ObservedGeneration < oldSet.Generation {
+		return controller.RequeueErrorf("tidbcluster: [%s/%s]'s tikv statefulset %s is not observed yet", ns, tcName, oldSet.GetName())
+	}
+
+	if tc.Status.TiKV.StatefulSet.UpdateRevision == tc.Status.TiKV.StatefulSet.CurrentRevision {
+		podOrdinals := helper.GetPodOrdinals(*oldSet.Spec.Replicas, oldSet).List()
+		rollingBackFrom, err := tku.getRollingBackFrom(tc, podOrdinals)
+		if err != nil || rollingBackFrom == "" {
+			return err
+		}
+		if !controller.IsManagedUpgrade(oldSet) {
+			// the update strategy is modified manually, let the native statefulset controller roll the pods back
+			newSet.Spec.UpdateStrategy = oldSet.Spec.UpdateStrategy
+			return nil
+		}
+		if tc.Status.TiKV.RollingBackFrom != rollingBackFrom {
+			tku.recorder.Event(tc, corev1.EventTypeNormal, "RollingBack", fmt.Sprintf("roll back tikv from revision %s to %s", rollingBackFrom, tc.Status.TiKV.StatefulSet.UpdateRevision))
+		}
+		tc.Status.TiKV.RollingBackFrom = rollingBackFrom
+		if err := tku.syncUpdatedOrdinals(tc, podOrdinals); err != nil {
+			return err
+		}
+		return tku.rollback(tc, podOrdinals)
+	}
+	tc.Status.TiKV.RollingBackFrom = ""
 
-	if oldSet.Spec.UpdateStrategy.Type == apps.OnDeleteStatefulSetStrategyType || oldSet.Spec.UpdateStrategy.RollingUpdate == nil {
+	if !controller.IsManagedUpgrade(oldSet) {
 		// Manually bypass tikv-operator to modify statefulset directly, such as modify tikv statefulset's RollingUpdate strategy to OnDelete strategy,
 		// or set RollingUpdate to nil, skip tikv-operator's rolling update logic in order to speed up the upgrade in the test environment occasionally.
 		// If we encounter this situation, we will let the native statefulset controller do the upgrade completely, which may be unsafe for upgrading tikv.
@@ -87,16 +155,32 @@ func (tku *tikvUpgrader) Upgrade(tc *v1alpha1.TikvCluster, oldSet *apps.Stateful
 		return nil
 	}
 
-	setUpgradePartition(newSet, *oldSet.Spec.UpdateStrategy.RollingUpdate.Partition)
+	partition := *oldSet.Spec.UpdateStrategy.RollingUpdate.Partition
+	setUpgradePartition(newSet, partition)
 	podOrdinals := helper.GetPodOrdinals(*oldSet.Spec.Replicas, oldSet).List()
+	if err := tku.syncUpdatedOrdinals(tc, podOrdinals); err != nil {
+		return err
+	}
+	if err := tku.restartUpgradingPods(tc, partition); err != nil {
+		return err
+	}
+	upgradeRecorded, err := tku.upgradeRecorded(tc, partition)
+	if err != nil {
+		return err
+	}
 	for _i := len(podOrdinals) - 1; _i >= 0; _i-- {
 		i := podOrdinals[_i]
 		store := tku.getStoreByOrdinal(tc, i)
-		if store == nil {
+		if store == nil && !force {
 			continue
 		}
+		// the pods are processed in descending ordinal, the one returned at is being upgraded
+		tc.Status.TiKV.UpgradingOrdinal = controller.Int32Ptr(i)
 		podName := TikvPodName(tcName, i)
 		pod, err := tku.podLister.Pods(ns).Get(podName)
+		if errors.IsNotFound(err) && upgradingTogether(tc, i) {
+			return controller.RequeueErrorf("tidbcluster: [%s/%s]'s tikv pod: [%s] is being recreated", ns, tcName, podName)
+		}
 		if err != nil {
 			return err
 		}
@@ -106,20 +190,192 @@ func (tku *tikvUpgrader) Upgrade(tc *v1alpha1.TikvCluster, oldSet *apps.Stateful
 		}
 
 		if revision == tc.Status.TiKV.StatefulSet.UpdateRevision {
+			if force {
+				// the stores are not checked by the forced upgrade, just wait for the pod to run
+				if pod.Status.Phase != corev1.PodRunning {
+					return controller.RequeueErrorf("tidbcluster: [%s/%s]'s upgraded tikv pod: [%s] is not running", ns, tcName, podName)
+				}
+				continue
+			}
 
-			if pod.Status.Phase != corev1.PodRunning {
+			if pod.Status.Phase != corev1.PodRunning || !podutil.IsPodReady(pod) {
+				stalled = tku.checkUpgradeStalled(tc, pod)
 				return controller.RequeueErrorf("tidbcluster: [%s/%s]'s upgraded tikv pod: [%s] is not running", ns, tcName, podName)
 			}
 			if store.State != v1alpha1.TiKVStateUp {
 				return controller.RequeueErrorf("tidbcluster: [%s/%s]'s upgraded tikv pod: [%s] is not all ready", ns, tcName, podName)
 			}
+			recordPodStartup(tc, v1alpha1.TiKVMemberType, revision, pod, store.LastTransitionTime.Time)
+			// the pods upgraded last are ready and their stores are up again, stop evicting
+			// leaders from them before moving to the next ordinal, which is done once until
+			// the upgrade of the pod at the partition is recorded
+			if (i == partition || upgradingTogether(tc, i)) && !upgradeRecorded {
+				if err := tku.endEvictLeader(tc, i); err != nil {
+					return err
+				}
+			}
+			// the pods upgraded together above the partition are ready as well once it is reached
+			if i == partition && !upgradeRecorded {
+				tc.Status.TiKV.LastUpgradedPod = &v1alpha1.UpgradedPod{Name: podName, ReadyTime: metav1.NewTime(tku.clock.Now())}
+			}
 
 			continue
 		}
 
-		return tku.upgradeTiKVPod(tc, i, newSet)
+		if tc.Spec.TiKV.UpgradePartition != nil && i < *tc.Spec.TiKV.UpgradePartition {
+			// the upgrade halts at the partition until it is lowered, the leaders may have been
+			// evicted from the pod before the partition was raised
+			klog.Infof("tidbcluster: [%s/%s]'s tikv upgrade halts at partition %d", ns, tcName, *tc.Spec.TiKV.UpgradePartition)
+			tc.Status.TiKV.UpgradingOrdinal = nil
+			return tku.cancelEvictLeader(tc, i, pod)
+		}
+
+		if force {
+			tku.recorder.Event(tc, corev1.EventTypeWarning, "ForceUpgrade", fmt.Sprintf("force upgrade tikv pod %s without checking the stores or evicting the leaders", podName))
+			setUpgradePartition(newSet, i)
+			return nil
+		}
+		if i >= partition && upgradingTogether(tc, i) {
+			return controller.RequeueErrorf("tidbcluster: [%s/%s]'s tikv pod: [%s] is being upgraded", ns, tcName, podName)
+		}
+		if err := tku.verifyLastUpgradedPodDNS(tc); err != nil {
+			return err
+		}
+		if err := tku.waitBetweenUpgrades(tc, pod); err != nil {
+			return err
+		}
+		if err := tku.canUpgrade(tc, podName); err != nil {
+			return err
+		}
+		ordinals, err := tku.getUpgradeOrdinals(tc, podOrdinals[:_i+1])
+		if err != nil {
+			return err
+		}
+		return tku.upgradeTiKVPods(tc, ordinals, newSet)
 	}
 
+	tc.Status.TiKV.UpgradingOrdinal = nil
+	return nil
+}
+
+// upgradeRecorded returns whether the upgrade of the pod at the partition is recorded in
+// LastUpgradedPod, for the pod running now rather than the one it replaced
+func (tku *tikvUpgrader) upgradeRecorded(tc *v1alpha1.TikvCluster, partition int32) (bool, error) {
+	last := tc.Status.TiKV.LastUpgradedPod
+	podName := TikvPodName(tc.GetName(), partition)
+	if last == nil || last.Name != podName {
+		return false, nil
+	}
+	pod, err := tku.podLister.Pods(tc.GetNamespace()).Get(podName)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !last.ReadyTime.Before(&pod.CreationTimestamp), nil
+}
+
+// getRollingBackFrom returns the revision the pods are rolled back from. Reverting the spec in
+// the middle of an upgrade makes the statefulset controller take the current revision as the
+// update revision again, while the pods upgraded already still run the revision upgraded to.
+func (tku *tikvUpgrader) getRollingBackFrom(tc *v1alpha1.TikvCluster, podOrdinals []int32) (string, error) {
+	for _, i := range podOrdinals {
+		pod, err := tku.podLister.Pods(tc.GetNamespace()).Get(TikvPodName(tc.GetName(), i))
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		revision, exist := pod.Labels[apps.ControllerRevisionHashLabelKey]
+		if exist && revision != tc.Status.TiKV.StatefulSet.UpdateRevision {
+			return revision, nil
+		}
+	}
+	return "", nil
+}
+
+// rollback rolls the pods back to the update revision in ascending ordinal, the reverse of the
+// upgrade. The statefulset controller can not do this with the partition, which is left at the
+// replicas so that it does not roll the pods itself, the pods are deleted instead and recreated
+// from the update revision.
+func (tku *tikvUpgrader) rollback(tc *v1alpha1.TikvCluster, podOrdinals []int32) error {
+	ns := tc.GetNamespace()
+	tcName := tc.GetName()
+	for _, i := range podOrdinals {
+		tc.Status.TiKV.UpgradingOrdinal = controller.Int32Ptr(i)
+		podName := TikvPodName(tcName, i)
+		pod, err := tku.podLister.Pods(ns).Get(podName)
+		if errors.IsNotFound(err) {
+			return controller.RequeueErrorf("tidbcluster: [%s/%s]'s tikv pod: [%s] is being recreated", ns, tcName, podName)
+		}
+		if err != nil {
+			return err
+		}
+
+		if pod.Labels[apps.ControllerRevisionHashLabelKey] == tc.Status.TiKV.StatefulSet.UpdateRevision {
+			if pod.Status.Phase != corev1.PodRunning || !podutil.IsPodReady(pod) {
+				return controller.RequeueErrorf("tidbcluster: [%s/%s]'s rolled back tikv pod: [%s] is not running", ns, tcName, podName)
+			}
+			if store := tku.getStoreByOrdinal(tc, i); store != nil && store.State != v1alpha1.TiKVStateUp {
+				return controller.RequeueErrorf("tidbcluster: [%s/%s]'s rolled back tikv pod: [%s] is not all ready", ns, tcName, podName)
+			}
+			continue
+		}
+
+		return tku.rollbackTiKVPod(tc, i, pod)
+	}
+	tc.Status.TiKV.UpgradingOrdinal = nil
+	return nil
+}
+
+// rollbackTiKVPod evicts the leaders from the store of the pod and deletes the pod once they are evicted
+func (tku *tikvUpgrader) rollbackTiKVPod(tc *v1alpha1.TikvCluster, ordinal int32, pod *corev1.Pod) error {
+	ns := tc.GetNamespace()
+	podName := pod.GetName()
+	store := tku.getStoreByOrdinal(tc, ordinal)
+	if store != nil && len(tc.Status.TiKV.Stores) > 1 {
+		storeID, err := strconv.ParseUint(store.ID, 10, 64)
+		if err != nil {
+			return err
+		}
+		if _, evicting := pod.Annotations[EvictLeaderBeginTime]; !evicting {
+			return tku.beginEvictLeader(tc, storeID, pod)
+		}
+		if !tku.readyToUpgrade(tc, pod, *store) {
+			return controller.RequeueErrorf("tidbcluster: [%s/%s]'s tikv pod: [%s] is evicting leader", ns, tc.GetName(), podName)
+		}
+	}
+
+	if err := tku.podControl.DeletePod(tc, pod); err != nil {
+		return err
+	}
+	klog.Infof("tikv upgrader: deleted pod %s/%s to roll it back to revision %s", ns, podName, tc.Status.TiKV.StatefulSet.UpdateRevision)
+	if store == nil {
+		return nil
+	}
+	// the store is down until the pod is recreated, pd does not transfer the leaders back to
+	// it before it is up again, the annotation is gone with the pod
+	return tku.endEvictLeader(tc, ordinal)
+}
+
+// syncUpdatedOrdinals records the ordinals of the pods running the update revision
+func (tku *tikvUpgrader) syncUpdatedOrdinals(tc *v1alpha1.TikvCluster, podOrdinals []int32) error {
+	var updated []int32
+	for _, i := range podOrdinals {
+		pod, err := tku.podLister.Pods(tc.GetNamespace()).Get(TikvPodName(tc.GetName(), i))
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if pod.Labels[apps.ControllerRevisionHashLabelKey] == tc.Status.TiKV.StatefulSet.UpdateRevision {
+			updated = append(updated, i)
+		}
+	}
+	tc.Status.TiKV.UpdatedOrdinals = updated
 	return nil
 }
 
@@ -132,6 +388,12 @@ func (tku *tikvUpgrader) upgradeTiKVPod(tc *v1alpha1.TikvCluster, ordinal int32,
 		return err
 	}
 
+	// there is nowhere to transfer the leaders to if there is only one store
+	if len(tc.Status.TiKV.Stores) <= 1 {
+		setUpgradePartition(newSet, ordinal)
+		return nil
+	}
+
 	for _, store := range tc.Status.TiKV.Stores {
 		if store.PodName == upgradePodName {
 			storeID, err := strconv.ParseUint(store.ID, 10, 64)
@@ -143,11 +405,8 @@ func (tku *tikvUpgrader) upgradeTiKVPod(tc *v1alpha1.TikvCluster, ordinal int32,
 				return tku.beginEvictLeader(tc, storeID, upgradePod)
 			}
 
-			if tku.readyToUpgrade(upgradePod, store) {
-				err := tku.endEvictLeader(tc, ordinal)
-				if err != nil {
-					return err
-				}
+			if tku.readyToUpgrade(tc, upgradePod, store) {
+				recordLeaderEviction(tc, tc.Status.TiKV.StatefulSet.UpdateRevision, upgradePod)
 				setUpgradePartition(newSet, ordinal)
 				return nil
 			}
@@ -159,7 +418,194 @@ func (tku *tikvUpgrader) upgradeTiKVPod(tc *v1alpha1.TikvCluster, ordinal int32,
 	return controller.RequeueErrorf("tidbcluster: [%s/%s] no store status found for tikv pod: [%s]", ns, tcName, upgradePodName)
 }
 
-func (tku *tikvUpgrader) readyToUpgrade(upgradePod *corev1.Pod, store v1alpha1.TiKVStore) bool {
+// getUpgradeOrdinals returns the ordinals of the pods to upgrade together in descending order,
+// starting from the pod to upgrade next, the last of the candidates. Up to spec.tikv.maxUpgradingPods
+// consecutive pods are upgraded together if their stores are in the same value of the first
+// location label, so that they hold at most one replica of each region while pd places the
+// replicas in at least max-replicas values of the label, and at least max-replicas stores are
+// not being upgraded.
+func (tku *tikvUpgrader) getUpgradeOrdinals(tc *v1alpha1.TikvCluster, candidates []int32) ([]int32, error) {
+	ns := tc.GetNamespace()
+	tcName := tc.GetName()
+	ordinals := []int32{candidates[len(candidates)-1]}
+	if tc.Spec.TiKV.MaxUpgradingPods == nil || *tc.Spec.TiKV.MaxUpgradingPods <= 1 {
+		return ordinals, nil
+	}
+
+	pdClient := controller.GetPDClient(tku.pdControl, tc)
+	config, err := pdClient.GetConfig(pdapi.NoCache)
+	if err != nil {
+		return nil, err
+	}
+	maxReplicas := defaultMaxReplicas
+	var locationLabels []string
+	if config.Replication != nil {
+		if config.Replication.MaxReplicas != nil {
+			maxReplicas = int(*config.Replication.MaxReplicas)
+		}
+		locationLabels = config.Replication.LocationLabels
+	}
+	maxUpgradingPods := int(*tc.Spec.TiKV.MaxUpgradingPods)
+	if limit := len(tc.Status.TiKV.Stores) - maxReplicas; limit < maxUpgradingPods {
+		maxUpgradingPods = limit
+	}
+	if maxUpgradingPods <= 1 || len(locationLabels) == 0 {
+		return ordinals, nil
+	}
+
+	storesInfo, err := pdClient.GetStores(pdapi.NoCache)
+	if err != nil {
+		return nil, err
+	}
+	// the value of the first location label of the stores of the cluster by pod name
+	locations := map[string]string{}
+	values := sets.NewString()
+	for _, info := range storesInfo.Stores {
+		if info.Store == nil {
+			continue
+		}
+		store, ok := tc.Status.TiKV.Stores[strconv.FormatUint(info.Store.GetId(), 10)]
+		if !ok {
+			continue
+		}
+		for _, l := range info.Store.GetLabels() {
+			if l.GetKey() == locationLabels[0] {
+				locations[store.PodName] = l.GetValue()
+				values.Insert(l.GetValue())
+			}
+		}
+	}
+	if values.Len() < maxReplicas {
+		klog.Infof("tidbcluster: [%s/%s]'s tikv stores are in %d values of location label %s, less than max-replicas %d, upgrade the pods one at a time",
+			ns, tcName, values.Len(), locationLabels[0], maxReplicas)
+		return ordinals, nil
+	}
+	location, ok := locations[TikvPodName(tcName, ordinals[0])]
+	if !ok {
+		return ordinals, nil
+	}
+	for j := len(candidates) - 2; j >= 0 && len(ordinals) < maxUpgradingPods; j-- {
+		ordinal := candidates[j]
+		if tc.Spec.TiKV.UpgradePartition != nil && ordinal < *tc.Spec.TiKV.UpgradePartition {
+			break
+		}
+		podName := TikvPodName(tcName, ordinal)
+		if l, ok := locations[podName]; !ok || l != location {
+			break
+		}
+		pod, err := tku.podLister.Pods(ns).Get(podName)
+		if err != nil {
+			return nil, err
+		}
+		if pod.Labels[apps.ControllerRevisionHashLabelKey] == tc.Status.TiKV.StatefulSet.UpdateRevision {
+			break
+		}
+		ordinals = append(ordinals, ordinal)
+	}
+	return ordinals, nil
+}
+
+// upgradeTiKVPods evicts the leaders from the pods to upgrade together and lowers the partition
+// to the lowest of them once the leaders are evicted from all of them
+func (tku *tikvUpgrader) upgradeTiKVPods(tc *v1alpha1.TikvCluster, ordinals []int32, newSet *apps.StatefulSet) error {
+	if len(ordinals) == 1 {
+		if err := tku.upgradeTiKVPod(tc, ordinals[0], newSet); err != nil {
+			return err
+		}
+		if *newSet.Spec.UpdateStrategy.RollingUpdate.Partition == ordinals[0] {
+			tc.Status.TiKV.UpgradingOrdinals = ordinals
+		}
+		return nil
+	}
+
+	ns := tc.GetNamespace()
+	tcName := tc.GetName()
+	var pods []*corev1.Pod
+	var evicting []string
+	for _, ordinal := range ordinals {
+		podName := TikvPodName(tcName, ordinal)
+		pod, err := tku.podLister.Pods(ns).Get(podName)
+		if err != nil {
+			return err
+		}
+		store := tku.getStoreByOrdinal(tc, ordinal)
+		if store == nil {
+			return controller.RequeueErrorf("tidbcluster: [%s/%s] no store status found for tikv pod: [%s]", ns, tcName, podName)
+		}
+		if _, ok := pod.Annotations[EvictLeaderBeginTime]; !ok {
+			storeID, err := strconv.ParseUint(store.ID, 10, 64)
+			if err != nil {
+				return err
+			}
+			if err := tku.beginEvictLeader(tc, storeID, pod); err != nil {
+				return err
+			}
+			evicting = append(evicting, podName)
+			continue
+		}
+		if !tku.readyToUpgrade(tc, pod, *store) {
+			evicting = append(evicting, podName)
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	if len(evicting) > 0 {
+		return controller.RequeueErrorf("tidbcluster: [%s/%s]'s tikv pods: %v are evicting leader", ns, tcName, evicting)
+	}
+
+	for _, pod := range pods {
+		recordLeaderEviction(tc, tc.Status.TiKV.StatefulSet.UpdateRevision, pod)
+	}
+	tc.Status.TiKV.UpgradingOrdinals = ordinals
+	setUpgradePartition(newSet, ordinals[len(ordinals)-1])
+	return nil
+}
+
+// restartUpgradingPods deletes the pods upgraded together once the partition includes them. The
+// statefulset controller would restart them one at a time waiting for each to be ready, while the
+// pods deleted are recreated from the update revision at once. Only the pods the leaders are
+// evicted from are deleted, i.e. not the pods recreated already.
+func (tku *tikvUpgrader) restartUpgradingPods(tc *v1alpha1.TikvCluster, partition int32) error {
+	if len(tc.Status.TiKV.UpgradingOrdinals) <= 1 {
+		return nil
+	}
+	for _, ordinal := range tc.Status.TiKV.UpgradingOrdinals {
+		if ordinal < partition {
+			continue
+		}
+		pod, err := tku.podLister.Pods(tc.GetNamespace()).Get(TikvPodName(tc.GetName(), ordinal))
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, evicting := pod.Annotations[EvictLeaderBeginTime]; !evicting || pod.DeletionTimestamp != nil ||
+			pod.Labels[apps.ControllerRevisionHashLabelKey] == tc.Status.TiKV.StatefulSet.UpdateRevision {
+			continue
+		}
+		if err := tku.podControl.DeletePod(tc, pod); err != nil {
+			return err
+		}
+		klog.Infof("tikv upgrader: deleted pod %s/%s to upgrade it to revision %s", pod.GetNamespace(), pod.GetName(), tc.Status.TiKV.StatefulSet.UpdateRevision)
+	}
+	return nil
+}
+
+// upgradingTogether returns whether the pod of the ordinal is one of the pods upgraded together
+func upgradingTogether(tc *v1alpha1.TikvCluster, ordinal int32) bool {
+	if len(tc.Status.TiKV.UpgradingOrdinals) <= 1 {
+		return false
+	}
+	for _, o := range tc.Status.TiKV.UpgradingOrdinals {
+		if o == ordinal {
+			return true
+		}
+	}
+	return false
+}
+
+func (tku *tikvUpgrader) readyToUpgrade(tc *v1alpha1.TikvCluster, upgradePod *corev1.Pod, store v1alpha1.TiKVStore) bool {
 	if store.LeaderCount == 0 {
 		return true
 	}
@@ -169,13 +615,192 @@ func (tku *tikvUpgrader) readyToUpgrade(upgradePod *corev1.Pod, store v1alpha1.T
 			klog.Errorf("parse annotation:[%s] to time failed.", EvictLeaderBeginTime)
 			return false
 		}
-		if time.Now().After(evictLeaderBeginTime.Add(EvictLeaderTimeout)) {
+		if tku.clock.Now().After(evictLeaderBeginTime.Add(getEvictLeaderTimeout(tc))) {
 			return true
 		}
 	}
 	return false
 }
 
+// waitBetweenUpgrades returns a RequeueError until spec.tikv.waitDurationBetweenUpgrades has
+// passed since the pod upgraded last became ready. The wait is skipped when the force upgrade
+// annotation is allowed to take effect, and once the leaders of the pod to upgrade are being evicted.
+func (tku *tikvUpgrader) waitBetweenUpgrades(tc *v1alpha1.TikvCluster, pod *corev1.Pod) error {
+	wait := tc.Spec.TiKV.WaitDurationBetweenUpgrades
+	last := tc.Status.TiKV.LastUpgradedPod
+	if wait == nil || last == nil || forceUpgradeAllowed(tc) {
+		return nil
+	}
+	if _, evicting := pod.Annotations[EvictLeaderBeginTime]; evicting {
+		return nil
+	}
+	remaining := last.ReadyTime.Add(wait.Duration).Sub(tku.clock.Now())
+	if remaining <= 0 {
+		return nil
+	}
+	return controller.RequeueErrorAfter(remaining, "tidbcluster: [%s/%s]'s tikv pod: [%s] is upgraded %s after tikv pod: [%s] became ready, %s remaining",
+		tc.GetNamespace(), tc.GetName(), pod.GetName(), wait.Duration, last.Name, remaining.Round(time.Second))
+}
+
+// verifyLastUpgradedPodDNS holds the upgrade of the next pod until the FQDN of the pod upgraded
+// last resolves to its new IP
+func (tku *tikvUpgrader) verifyLastUpgradedPodDNS(tc *v1alpha1.TikvCluster) error {
+	last := tc.Status.TiKV.LastUpgradedPod
+	if last == nil {
+		return nil
+	}
+	pod, err := tku.podLister.Pods(tc.GetNamespace()).Get(last.Name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return tku.dnsVerifier.verify(tc, v1alpha1.TiKVMemberType, pod, last.ReadyTime.Time)
+}
+
+// canUpgrade returns a RequeueError and emits an event telling why if the cluster is degraded,
+// i.e. a store is not Up or more regions than spec.tikv.upgradeSafetyCheck tolerates have down
+// or pending peers, so that the upgrade does not take down another store. The check is skipped
+// when the force upgrade annotation is allowed to take effect.
+func (tku *tikvUpgrader) canUpgrade(tc *v1alpha1.TikvCluster, podName string) error {
+	if forceUpgradeAllowed(tc) {
+		return nil
+	}
+	reasons, err := tku.upgradeBlockedReasons(tc)
+	if err != nil {
+		return err
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+	msg := fmt.Sprintf("tikv pod %s is not upgraded: %s", podName, strings.Join(reasons, ", "))
+	tku.recorder.Event(tc, corev1.EventTypeWarning, "UpgradeBlocked", msg)
+	return controller.RequeueErrorf("tidbcluster: [%s/%s]'s %s", tc.GetNamespace(), tc.GetName(), msg)
+}
+
+// upgradeBlockedReasons returns the checks the cluster fails before upgrading a tikv pod
+func (tku *tikvUpgrader) upgradeBlockedReasons(tc *v1alpha1.TikvCluster) ([]string, error) {
+	var reasons []string
+	var ids []string
+	for id := range tc.Status.TiKV.Stores {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if store := tc.Status.TiKV.Stores[id]; store.State != v1alpha1.TiKVStateUp {
+			reasons = append(reasons, fmt.Sprintf("store %s is %s", store.ID, store.State))
+		}
+	}
+
+	var maxDownPeerRegions, maxPendingPeerRegions int32
+	if check := tc.Spec.TiKV.UpgradeSafetyCheck; check != nil {
+		if check.MaxDownPeerRegions != nil {
+			maxDownPeerRegions = *check.MaxDownPeerRegions
+		}
+		if check.MaxPendingPeerRegions != nil {
+			maxPendingPeerRegions = *check.MaxPendingPeerRegions
+		}
+	}
+	pdClient := controller.GetPDClient(tku.pdControl, tc)
+	for _, c := range []struct {
+		check pdapi.RegionsCheck
+		max   int32
+		desc  string
+	}{
+		{check: pdapi.RegionsCheckDownPeer, max: maxDownPeerRegions, desc: "down peers"},
+		{check: pdapi.RegionsCheckPendingPeer, max: maxPendingPeerRegions, desc: "pending peers"},
+	} {
+		count, err := pdClient.GetRegionCount(c.check)
+		if err != nil {
+			return nil, fmt.Errorf("tidbcluster: [%s/%s] failed to get the regions with %s, %v", tc.GetNamespace(), tc.GetName(), c.desc, err)
+		}
+		if count > int(c.max) {
+			reasons = append(reasons, fmt.Sprintf("%d regions have %s", count, c.desc))
+		}
+	}
+	return reasons, nil
+}
+
+// checkUpgradeStalled reports the upgrade as stalled if the upgraded pod has not been ready
+// for longer than the upgrade stall timeout
+func (tku *tikvUpgrader) checkUpgradeStalled(tc *v1alpha1.TikvCluster, pod *corev1.Pod) bool {
+	since := pod.GetCreationTimestamp().Time
+	if cond := podutil.GetPodReadyCondition(pod.Status); cond != nil && !cond.LastTransitionTime.IsZero() {
+		since = cond.LastTransitionTime.Time
+	}
+	timeout := getUpgradeStallTimeout(tc)
+	if since.IsZero() || tku.clock.Since(since) < timeout {
+		return false
+	}
+
+	msg := fmt.Sprintf("tikv pod %s has not been ready for more than %s after upgrade: %s", pod.GetName(), timeout, podNotReadyReason(pod))
+	if cond := utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.UpgradeStalled); cond == nil || cond.Status != corev1.ConditionTrue {
+		tku.recorder.Event(tc, corev1.EventTypeWarning, "UpgradeStalled", msg)
+	}
+	cond := utiltikvcluster.NewTikvClusterCondition(v1alpha1.UpgradeStalled, corev1.ConditionTrue, utiltikvcluster.TiKVPodNotReady, msg)
+	utiltikvcluster.SetTikvClusterCondition(&tc.Status, *cond)
+	return true
+}
+
+// resetUpgradeStalledCondition resets the UpgradeStalled condition if it is set
+func resetUpgradeStalledCondition(tc *v1alpha1.TikvCluster) {
+	cond := utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.UpgradeStalled)
+	if cond == nil || cond.Status != corev1.ConditionTrue {
+		return
+	}
+	cond = utiltikvcluster.NewTikvClusterCondition(v1alpha1.UpgradeStalled, corev1.ConditionFalse, utiltikvcluster.UpgradeNotStalled, "")
+	utiltikvcluster.SetTikvClusterCondition(&tc.Status, *cond)
+}
+
+// podNotReadyReason returns why the pod is not ready from the statuses of its containers
+func podNotReadyReason(pod *corev1.Pod) string {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Ready {
+			continue
+		}
+		if waiting := status.State.Waiting; waiting != nil && waiting.Reason != "" {
+			if terminated := status.LastTerminationState.Terminated; terminated != nil {
+				return fmt.Sprintf("container %s is %s, last terminated with %s (exit code %d)", status.Name, waiting.Reason, terminated.Reason, terminated.ExitCode)
+			}
+			return fmt.Sprintf("container %s is %s: %s", status.Name, waiting.Reason, waiting.Message)
+		}
+		if terminated := status.State.Terminated; terminated != nil {
+			return fmt.Sprintf("container %s is terminated with %s (exit code %d)", status.Name, terminated.Reason, terminated.ExitCode)
+		}
+		return fmt.Sprintf("container %s is not ready", status.Name)
+	}
+	return fmt.Sprintf("pod is %s", pod.Status.Phase)
+}
+
+// getUpgradeStallTimeout returns the upgrade stall timeout configured in the TikvCluster
+func getUpgradeStallTimeout(tc *v1alpha1.TikvCluster) time.Duration {
+	if tc.Spec.TiKV.UpgradeStallTimeout == nil {
+		return UpgradeStallTimeout
+	}
+	d, err := time.ParseDuration(*tc.Spec.TiKV.UpgradeStallTimeout)
+	if err != nil {
+		klog.Errorf("tidbcluster: [%s/%s] invalid upgradeStallTimeout %q, use the default %s",
+			tc.GetNamespace(), tc.GetName(), *tc.Spec.TiKV.UpgradeStallTimeout, UpgradeStallTimeout)
+		return UpgradeStallTimeout
+	}
+	return d
+}
+
+// getEvictLeaderTimeout returns the evict leader timeout configured in the TikvCluster
+func getEvictLeaderTimeout(tc *v1alpha1.TikvCluster) time.Duration {
+	if tc.Spec.TiKV.EvictLeaderTimeout == nil {
+		return EvictLeaderTimeout
+	}
+	d, err := time.ParseDuration(*tc.Spec.TiKV.EvictLeaderTimeout)
+	if err != nil {
+		klog.Errorf("tidbcluster: [%s/%s] invalid evictLeaderTimeout %q, use the default %s",
+			tc.GetNamespace(), tc.GetName(), *tc.Spec.TiKV.EvictLeaderTimeout, EvictLeaderTimeout)
+		return EvictLeaderTimeout
+	}
+	return d
+}
+
 func (tku *tikvUpgrader) beginEvictLeader(tc *v1alpha1.TikvCluster, storeID uint64, pod *corev1.Pod) error {
 	ns := tc.GetNamespace()
 	podName := pod.GetName()
@@ -189,7 +814,7 @@ func (tku *tikvUpgrader) beginEvictLeader(tc *v1alpha1.TikvCluster, storeID uint
 	if pod.Annotations == nil {
 		pod.Annotations = map[string]string{}
 	}
-	now := time.Now().Format(time.RFC3339)
+	now := tku.clock.Now().Format(time.RFC3339)
 	pod.Annotations[EvictLeaderBeginTime] = now
 	_, err = tku.podControl.UpdatePod(tc, pod)
 	if err != nil {
@@ -208,12 +833,15 @@ func (tku *tikvUpgrader) endEvictLeader(tc *v1alpha1.TikvCluster, ordinal int32)
 		time.Sleep(5 * time.Second)
 	}
 	store := tku.getStoreByOrdinal(tc, ordinal)
+	if store == nil {
+		return nil
+	}
 	storeID, err := strconv.ParseUint(store.ID, 10, 64)
 	if err != nil {
 		return err
 	}
 
-	err = tku.pdControl.GetPDClient(pdapi.Namespace(tc.GetNamespace()), tc.GetName(), tc.IsTLSClusterEnabled()).EndEvictLeader(storeID)
+	err = controller.GetPDClient(tku.pdControl, tc).EndEvictLeader(storeID)
 	if err != nil {
 		klog.Errorf("tikv upgrader: failed to end evict leader storeID: %d ordinal: %d, %v", storeID, ordinal, err)
 		return err
@@ -222,6 +850,24 @@ func (tku *tikvUpgrader) endEvictLeader(tc *v1alpha1.TikvCluster, ordinal int32)
 	return nil
 }
 
+// cancelEvictLeader ends evicting the leaders from a pod which is not to be upgraded any more
+func (tku *tikvUpgrader) cancelEvictLeader(tc *v1alpha1.TikvCluster, ordinal int32, pod *corev1.Pod) error {
+	if _, evicting := pod.Annotations[EvictLeaderBeginTime]; !evicting {
+		return nil
+	}
+	if err := tku.endEvictLeader(tc, ordinal); err != nil {
+		return err
+	}
+	pod = pod.DeepCopy()
+	delete(pod.Annotations, EvictLeaderBeginTime)
+	if _, err := tku.podControl.UpdatePod(tc, pod); err != nil {
+		klog.Errorf("tikv upgrader: failed to remove pod %s/%s annotation %s, %v",
+			tc.GetNamespace(), pod.GetName(), EvictLeaderBeginTime, err)
+		return err
+	}
+	return nil
+}
+
 func (tku *tikvUpgrader) getStoreByOrdinal(tc *v1alpha1.TikvCluster, ordinal int32) *v1alpha1.TiKVStore {
 	podName := TikvPodName(tc.GetName(), ordinal)
 	for _, store := range tc.Status.TiKV.Stores {
@@ -20,17 +20,21 @@ import (
 	"time"
 
 	. "github.com/onsi/gomega"
+	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
 	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/deps"
 	"github.com/tikv/tikv-operator/pkg/label"
 	"github.com/tikv/tikv-operator/pkg/pdapi"
+	utiltikvcluster "github.com/tikv/tikv-operator/pkg/util/tikvcluster"
 	apps "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
-	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/apimachinery/pkg/util/clock"
 	podinformers "k8s.io/client-go/informers/core/v1"
-	kubefake "k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/utils/pointer"
 )
 
@@ -41,22 +45,28 @@ const (
 
 func TestTiKVUpgraderUpgrade(t *testing.T) {
 	g := NewGomegaWithT(t)
+	// the time of the fake clock of the upgrader, the deadlines of the cases are relative to it
+	now := time.Now()
 
 	type testcase struct {
 		name                string
 		changeFn            func(*v1alpha1.TikvCluster)
 		changeOldSet        func(set *apps.StatefulSet)
+		changeNewSet        func(set *apps.StatefulSet)
 		changePods          func([]*corev1.Pod)
+		regionCounts        map[pdapi.RegionsCheck]int
 		beginEvictLeaderErr bool
 		endEvictLeaderErr   bool
 		updatePodErr        bool
 		errExpectFn         func(*GomegaWithT, error)
 		expectFn            func(*GomegaWithT, *v1alpha1.TikvCluster, *apps.StatefulSet, map[string]*corev1.Pod)
+		endEvictLeaderFn    func(*GomegaWithT, []uint64)
 	}
 
 	testFn := func(test *testcase, t *testing.T) {
 		t.Log(test.name)
 		upgrader, pdControl, podControl, podInformer := newTiKVUpgrader()
+		upgrader.clock.(*clock.FakeClock).SetTime(now)
 
 		tc := newTikvClusterForTiKVUpgrader()
 		if test.changeFn != nil {
@@ -68,6 +78,9 @@ func TestTiKVUpgraderUpgrade(t *testing.T) {
 			test.changeOldSet(oldSet)
 		}
 		newSet := newStatefulSetForTiKVUpgrader()
+		if test.changeNewSet != nil {
+			test.changeNewSet(newSet)
+		}
 
 		pdClient := controller.NewFakePDClient(pdControl, tc)
 		if test.beginEvictLeaderErr {
@@ -79,12 +92,17 @@ func TestTiKVUpgraderUpgrade(t *testing.T) {
 				return nil, nil
 			})
 		}
+		pdClient.AddReaction(pdapi.GetRegionCountActionType, func(action *pdapi.Action) (interface{}, error) {
+			return test.regionCounts[pdapi.RegionsCheck(action.Name)], nil
+		})
+		var endEvictLeaderStores []uint64
 		if test.endEvictLeaderErr {
 			pdClient.AddReaction(pdapi.EndEvictLeaderActionType, func(action *pdapi.Action) (interface{}, error) {
 				return nil, fmt.Errorf("failed to end evict leader")
 			})
 		} else {
 			pdClient.AddReaction(pdapi.EndEvictLeaderActionType, func(action *pdapi.Action) (interface{}, error) {
+				endEvictLeaderStores = append(endEvictLeaderStores, action.ID)
 				return nil, nil
 			})
 		}
@@ -112,6 +130,20 @@ func TestTiKVUpgraderUpgrade(t *testing.T) {
 			pods[pod.GetName()] = pod
 		}
 		test.expectFn(g, tc, newSet, pods)
+		if test.endEvictLeaderFn != nil {
+			test.endEvictLeaderFn(g, endEvictLeaderStores)
+		}
+	}
+
+	// the force upgrade annotation is ignored once pd is healthy and the stores are all up
+	setHealthyPD := func(tc *v1alpha1.TikvCluster) {
+		tc.Status.PD.Phase = v1alpha1.NormalPhase
+		tc.Status.PD.Synced = true
+		tc.Status.PD.Members = map[string]v1alpha1.PDMember{
+			"pd-0": {Name: "pd-0", Health: true},
+			"pd-1": {Name: "pd-1", Health: true},
+			"pd-2": {Name: "pd-2", Health: true},
+		}
 	}
 
 	tests := []*testcase{
@@ -157,6 +189,43 @@ func TestTiKVUpgraderUpgrade(t *testing.T) {
 				g.Expect(newSet.Spec.UpdateStrategy).To(Equal(apps.StatefulSetUpdateStrategy{Type: apps.RollingUpdateStatefulSetStrategyType}))
 			},
 		},
+		{
+			name: "OnDelete strategy leaves the pods to be deleted by the users",
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				oldSet.Spec.UpdateStrategy = apps.StatefulSetUpdateStrategy{Type: apps.OnDeleteStatefulSetStrategyType}
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+			},
+			changeNewSet: func(newSet *apps.StatefulSet) {
+				newSet.Spec.UpdateStrategy = apps.StatefulSetUpdateStrategy{Type: apps.OnDeleteStatefulSetStrategyType}
+			},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(tc.Status.TiKV.Phase).To(Equal(v1alpha1.UpgradePhase))
+				g.Expect(newSet.Spec.UpdateStrategy).To(Equal(apps.StatefulSetUpdateStrategy{Type: apps.OnDeleteStatefulSetStrategyType}))
+				for _, pod := range pods {
+					g.Expect(pod.Annotations).NotTo(HaveKey(EvictLeaderBeginTime))
+				}
+			},
+		},
+		{
+			name: "switching from OnDelete to RollingUpdate applies the partition before upgrading the pods",
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				oldSet.Spec.UpdateStrategy = apps.StatefulSetUpdateStrategy{Type: apps.OnDeleteStatefulSetStrategyType}
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+			},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(tc.Status.TiKV.Phase).To(Equal(v1alpha1.UpgradePhase))
+				g.Expect(newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(controller.Int32Ptr(3)))
+				for _, pod := range pods {
+					g.Expect(pod.Annotations).NotTo(HaveKey(EvictLeaderBeginTime))
+				}
+			},
+		},
 		{
 			name: "to upgrade the pod which ordinal is 2",
 			changeFn: func(tc *v1alpha1.TikvCluster) {
@@ -174,33 +243,773 @@ func TestTiKVUpgraderUpgrade(t *testing.T) {
 			changePods: func(pods []*corev1.Pod) {
 				for _, pod := range pods {
 					if pod.GetName() == TikvPodName(upgradeTcName, 2) {
-						pod.Annotations = map[string]string{EvictLeaderBeginTime: time.Now().Add(-1 * time.Minute).Format(time.RFC3339)}
+						pod.Annotations = map[string]string{EvictLeaderBeginTime: now.Add(-1 * time.Minute).Format(time.RFC3339)}
+					}
+				}
+			},
+			beginEvictLeaderErr: false,
+			endEvictLeaderErr:   false,
+			updatePodErr:        false,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(tc.Status.TiKV.Phase).To(Equal(v1alpha1.UpgradePhase))
+				g.Expect(tc.Status.TiKV.UpgradingOrdinal).To(Equal(controller.Int32Ptr(2)))
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(2)))
+			},
+		},
+		{
+			name: "upgrade is blocked by a store not Up",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Synced = true
+				store := tc.Status.TiKV.Stores["3"]
+				store.LeaderCount = 0
+				tc.Status.TiKV.Stores["3"] = store
+				store = tc.Status.TiKV.Stores["1"]
+				store.State = v1alpha1.TiKVStateDown
+				tc.Status.TiKV.Stores["1"] = store
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+			},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+				g.Expect(err.Error()).To(ContainSubstring("store 1 is Down"))
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(3)))
+				g.Expect(pods[TikvPodName(upgradeTcName, 2)].Annotations).NotTo(HaveKey(EvictLeaderBeginTime))
+			},
+		},
+		{
+			name: "upgrade is blocked by regions with pending peers",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Synced = true
+				tc.Spec.TiKV.UpgradeSafetyCheck = &v1alpha1.TiKVUpgradeSafetyCheck{MaxPendingPeerRegions: pointer.Int32Ptr(100)}
+				store := tc.Status.TiKV.Stores["3"]
+				store.LeaderCount = 0
+				tc.Status.TiKV.Stores["3"] = store
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+			},
+			changePods: func(pods []*corev1.Pod) {
+				for _, pod := range pods {
+					if pod.GetName() == TikvPodName(upgradeTcName, 2) {
+						pod.Annotations = map[string]string{EvictLeaderBeginTime: now.Add(-1 * time.Minute).Format(time.RFC3339)}
+					}
+				}
+			},
+			regionCounts: map[pdapi.RegionsCheck]int{pdapi.RegionsCheckPendingPeer: 137},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+				g.Expect(err.Error()).To(ContainSubstring("137 regions have pending peers"))
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(3)))
+			},
+		},
+		{
+			name: "upgrade goes on with regions with down peers under the threshold",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Synced = true
+				tc.Spec.TiKV.UpgradeSafetyCheck = &v1alpha1.TiKVUpgradeSafetyCheck{MaxDownPeerRegions: pointer.Int32Ptr(5)}
+				store := tc.Status.TiKV.Stores["3"]
+				store.LeaderCount = 0
+				tc.Status.TiKV.Stores["3"] = store
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+			},
+			changePods: func(pods []*corev1.Pod) {
+				for _, pod := range pods {
+					if pod.GetName() == TikvPodName(upgradeTcName, 2) {
+						pod.Annotations = map[string]string{EvictLeaderBeginTime: now.Add(-1 * time.Minute).Format(time.RFC3339)}
+					}
+				}
+			},
+			regionCounts: map[pdapi.RegionsCheck]int{pdapi.RegionsCheckDownPeer: 5},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(2)))
+			},
+		},
+		{
+			name: "force upgrade annotation skips the upgrade safety check",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Annotations = map[string]string{label.AnnForceUpgradeKey: label.AnnForceUpgradeVal}
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Synced = true
+				store := tc.Status.TiKV.Stores["3"]
+				store.LeaderCount = 0
+				tc.Status.TiKV.Stores["3"] = store
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+			},
+			changePods: func(pods []*corev1.Pod) {
+				for _, pod := range pods {
+					if pod.GetName() == TikvPodName(upgradeTcName, 2) {
+						pod.Annotations = map[string]string{EvictLeaderBeginTime: now.Add(-1 * time.Minute).Format(time.RFC3339)}
+					}
+				}
+			},
+			regionCounts: map[pdapi.RegionsCheck]int{pdapi.RegionsCheckDownPeer: 10, pdapi.RegionsCheckPendingPeer: 10},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(2)))
+			},
+		},
+		{
+			name: "force upgrade annotation does not skip the upgrade safety check of a healthy cluster",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				setHealthyPD(tc)
+				tc.Annotations = map[string]string{label.AnnForceUpgradeKey: label.AnnForceUpgradeVal}
+				tc.Status.TiKV.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Synced = true
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+			},
+			regionCounts: map[pdapi.RegionsCheck]int{pdapi.RegionsCheckPendingPeer: 10},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+				g.Expect(err.Error()).To(ContainSubstring("10 regions have pending peers"))
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(3)))
+				g.Expect(pods[TikvPodName(upgradeTcName, 2)].Annotations).NotTo(HaveKey(EvictLeaderBeginTime))
+			},
+		},
+		{
+			name: "to upgrade the pod which ordinal is 1",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
+				tc.Status.TiKV.Synced = true
+				tc.Status.TiKV.StatefulSet.CurrentReplicas = 2
+				tc.Status.TiKV.StatefulSet.UpdatedReplicas = 1
+				// set leader to 0
+				store := tc.Status.TiKV.Stores["2"]
+				store.LeaderCount = 0
+				tc.Status.TiKV.Stores["2"] = store
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+				oldSet.Status.CurrentReplicas = 2
+				oldSet.Status.UpdatedReplicas = 1
+				oldSet.Spec.UpdateStrategy.RollingUpdate.Partition = controller.Int32Ptr(2)
+			},
+			changePods: func(pods []*corev1.Pod) {
+				for _, pod := range pods {
+					if pod.GetName() == TikvPodName(upgradeTcName, 1) {
+						pod.Annotations = map[string]string{EvictLeaderBeginTime: now.Add(-1 * time.Minute).Format(time.RFC3339)}
+					}
+				}
+			},
+			beginEvictLeaderErr: false,
+			endEvictLeaderErr:   false,
+			updatePodErr:        false,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(tc.Status.TiKV.UpgradingOrdinal).To(Equal(controller.Int32Ptr(1)))
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(1)))
+			},
+			endEvictLeaderFn: func(g *GomegaWithT, stores []uint64) {
+				g.Expect(stores).To(Equal([]uint64{3}))
+			},
+		},
+		{
+			name: "upgrade halts at the upgrade partition",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Spec.TiKV.UpgradePartition = controller.Int32Ptr(2)
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
+				tc.Status.TiKV.Synced = true
+				tc.Status.TiKV.StatefulSet.CurrentReplicas = 2
+				tc.Status.TiKV.StatefulSet.UpdatedReplicas = 1
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+				oldSet.Status.CurrentReplicas = 2
+				oldSet.Status.UpdatedReplicas = 1
+				oldSet.Spec.UpdateStrategy.RollingUpdate.Partition = controller.Int32Ptr(2)
+			},
+			changePods: func(pods []*corev1.Pod) {
+				for _, pod := range pods {
+					// the partition is raised while evicting the leaders of the next pod
+					if pod.GetName() == TikvPodName(upgradeTcName, 1) {
+						pod.Annotations = map[string]string{EvictLeaderBeginTime: now.Add(-1 * time.Minute).Format(time.RFC3339)}
+					}
+				}
+			},
+			beginEvictLeaderErr: false,
+			endEvictLeaderErr:   false,
+			updatePodErr:        false,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(tc.Status.TiKV.Phase).To(Equal(v1alpha1.UpgradePhase))
+				g.Expect(tc.Status.TiKV.UpdatedOrdinals).To(Equal([]int32{2}))
+				g.Expect(tc.Status.TiKV.UpgradingOrdinal).To(BeNil())
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(2)))
+				g.Expect(pods[TikvPodName(upgradeTcName, 1)].Annotations).NotTo(HaveKey(EvictLeaderBeginTime))
+			},
+			endEvictLeaderFn: func(g *GomegaWithT, stores []uint64) {
+				g.Expect(stores).To(Equal([]uint64{3, 2}))
+			},
+		},
+		{
+			name: "upgrade resumes when the upgrade partition is lowered",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Spec.TiKV.UpgradePartition = controller.Int32Ptr(1)
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
+				tc.Status.TiKV.Synced = true
+				tc.Status.TiKV.StatefulSet.CurrentReplicas = 2
+				tc.Status.TiKV.StatefulSet.UpdatedReplicas = 1
+				store := tc.Status.TiKV.Stores["2"]
+				store.LeaderCount = 0
+				tc.Status.TiKV.Stores["2"] = store
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+				oldSet.Status.CurrentReplicas = 2
+				oldSet.Status.UpdatedReplicas = 1
+				oldSet.Spec.UpdateStrategy.RollingUpdate.Partition = controller.Int32Ptr(2)
+			},
+			changePods: func(pods []*corev1.Pod) {
+				for _, pod := range pods {
+					if pod.GetName() == TikvPodName(upgradeTcName, 1) {
+						pod.Annotations = map[string]string{EvictLeaderBeginTime: now.Add(-1 * time.Minute).Format(time.RFC3339)}
+					}
+				}
+			},
+			beginEvictLeaderErr: false,
+			endEvictLeaderErr:   false,
+			updatePodErr:        false,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(tc.Status.TiKV.UpdatedOrdinals).To(Equal([]int32{2}))
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(1)))
+			},
+		},
+		{
+			name: "force upgrade when tikv sync failed",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Synced = false
+				tc.Annotations = map[string]string{label.AnnForceUpgradeKey: label.AnnForceUpgradeVal}
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+			},
+			changePods:          nil,
+			beginEvictLeaderErr: true,
+			endEvictLeaderErr:   false,
+			updatePodErr:        false,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(tc.Status.TiKV.Phase).To(Equal(v1alpha1.UpgradePhase))
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(2)))
+				g.Expect(pods[TikvPodName(upgradeTcName, 2)].Annotations).NotTo(HaveKey(EvictLeaderBeginTime))
+			},
+		},
+		{
+			name: "force upgrade is ignored for healthy clusters",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.PD.Synced = true
+				tc.Status.PD.Members = map[string]v1alpha1.PDMember{
+					"pd-0": {Name: "pd-0", Health: true},
+					"pd-1": {Name: "pd-1", Health: true},
+					"pd-2": {Name: "pd-2", Health: true},
+				}
+				tc.Status.TiKV.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Synced = true
+				tc.Annotations = map[string]string{label.AnnForceUpgradeKey: label.AnnForceUpgradeVal}
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+			},
+			changePods:          nil,
+			beginEvictLeaderErr: false,
+			endEvictLeaderErr:   false,
+			updatePodErr:        false,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(3)))
+				g.Expect(pods[TikvPodName(upgradeTcName, 2)].Annotations).To(HaveKey(EvictLeaderBeginTime))
+			},
+		},
+		{
+			name: "upgraded pod is not ready",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
+				tc.Status.TiKV.Synced = true
+				tc.Status.TiKV.StatefulSet.CurrentReplicas = 2
+				tc.Status.TiKV.StatefulSet.UpdatedReplicas = 1
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+				oldSet.Status.CurrentReplicas = 2
+				oldSet.Status.UpdatedReplicas = 1
+				oldSet.Spec.UpdateStrategy.RollingUpdate.Partition = controller.Int32Ptr(2)
+			},
+			changePods: func(pods []*corev1.Pod) {
+				for _, pod := range pods {
+					if pod.GetName() == TikvPodName(upgradeTcName, 2) {
+						pod.Status.Conditions = nil
+					}
+				}
+			},
+			beginEvictLeaderErr: false,
+			endEvictLeaderErr:   false,
+			updatePodErr:        false,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(2)))
+				_, exist := pods[TikvPodName(upgradeTcName, 1)].Annotations[EvictLeaderBeginTime]
+				g.Expect(exist).To(BeFalse())
+			},
+			endEvictLeaderFn: func(g *GomegaWithT, stores []uint64) {
+				g.Expect(stores).To(BeEmpty())
+			},
+		},
+		{
+			name: "upgraded pod is not ready for longer than the upgrade stall timeout",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Spec.TiKV.UpgradeStallTimeout = pointer.StringPtr("5m")
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
+				tc.Status.TiKV.Synced = true
+				tc.Status.TiKV.StatefulSet.CurrentReplicas = 2
+				tc.Status.TiKV.StatefulSet.UpdatedReplicas = 1
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+				oldSet.Status.CurrentReplicas = 2
+				oldSet.Status.UpdatedReplicas = 1
+				oldSet.Spec.UpdateStrategy.RollingUpdate.Partition = controller.Int32Ptr(2)
+			},
+			changePods: func(pods []*corev1.Pod) {
+				for _, pod := range pods {
+					if pod.GetName() == TikvPodName(upgradeTcName, 2) {
+						pod.Status.Conditions = []corev1.PodCondition{
+							{Type: corev1.PodReady, Status: corev1.ConditionFalse, LastTransitionTime: metav1.NewTime(now.Add(-6 * time.Minute))},
+						}
+						pod.Status.ContainerStatuses = []corev1.ContainerStatus{
+							{
+								Name:                 "tikv",
+								State:                corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+								LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Error", ExitCode: 1}},
+							},
+						}
+					}
+				}
+			},
+			beginEvictLeaderErr: false,
+			endEvictLeaderErr:   false,
+			updatePodErr:        false,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(2)))
+				cond := utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.UpgradeStalled)
+				g.Expect(cond).NotTo(BeNil())
+				g.Expect(cond.Status).To(Equal(corev1.ConditionTrue))
+				g.Expect(cond.Reason).To(Equal(utiltikvcluster.TiKVPodNotReady))
+				g.Expect(cond.Message).To(ContainSubstring(TikvPodName(upgradeTcName, 2)))
+				g.Expect(cond.Message).To(ContainSubstring("CrashLoopBackOff"))
+			},
+		},
+		{
+			name: "upgrade stalled condition is reset once the upgraded pod is ready",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
+				tc.Status.TiKV.Synced = true
+				tc.Status.TiKV.StatefulSet.CurrentReplicas = 2
+				tc.Status.TiKV.StatefulSet.UpdatedReplicas = 1
+				cond := utiltikvcluster.NewTikvClusterCondition(v1alpha1.UpgradeStalled, corev1.ConditionTrue, utiltikvcluster.TiKVPodNotReady, "")
+				utiltikvcluster.SetTikvClusterCondition(&tc.Status, *cond)
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+				oldSet.Status.CurrentReplicas = 2
+				oldSet.Status.UpdatedReplicas = 1
+				oldSet.Spec.UpdateStrategy.RollingUpdate.Partition = controller.Int32Ptr(2)
+			},
+			changePods:          nil,
+			beginEvictLeaderErr: false,
+			endEvictLeaderErr:   false,
+			updatePodErr:        false,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				cond := utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.UpgradeStalled)
+				g.Expect(cond).NotTo(BeNil())
+				g.Expect(cond.Status).To(Equal(corev1.ConditionFalse))
+				g.Expect(cond.Reason).To(Equal(utiltikvcluster.UpgradeNotStalled))
+			},
+		},
+		{
+			name: "skip evicting leaders when there is only one store",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Synced = true
+				delete(tc.Status.TiKV.Stores, "2")
+				delete(tc.Status.TiKV.Stores, "3")
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+			},
+			changePods:          nil,
+			beginEvictLeaderErr: true,
+			endEvictLeaderErr:   false,
+			updatePodErr:        false,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(0)))
+				_, exist := pods[TikvPodName(upgradeTcName, 0)].Annotations[EvictLeaderBeginTime]
+				g.Expect(exist).To(BeFalse())
+			},
+		},
+		{
+			name: "newSet template changed",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Synced = true
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				oldSet.Spec.Template.Spec.Containers[0].Image = "old-image"
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+			},
+			changePods:          nil,
+			beginEvictLeaderErr: false,
+			endEvictLeaderErr:   false,
+			updatePodErr:        false,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(tc.Status.TiKV.Phase).To(Equal(v1alpha1.UpgradePhase))
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(3)))
+			},
+		},
+		{
+			name: "update revision equals current revision",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Synced = true
+				tc.Status.TiKV.StatefulSet.UpdateRevision = tc.Status.TiKV.StatefulSet.CurrentRevision
+			},
+			changePods:          nil,
+			beginEvictLeaderErr: false,
+			endEvictLeaderErr:   false,
+			updatePodErr:        false,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(tc.Status.TiKV.Phase).To(Equal(v1alpha1.UpgradePhase))
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(3)))
+			},
+		},
+		{
+			name: "tikv can not upgrade when pd is upgrading",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Phase = v1alpha1.UpgradePhase
+				tc.Status.TiKV.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Synced = true
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+			},
+			changePods:          nil,
+			beginEvictLeaderErr: false,
+			endEvictLeaderErr:   false,
+			updatePodErr:        false,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(tc.Status.TiKV.Phase).To(Equal(v1alpha1.NormalPhase))
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(3)))
+			},
+		},
+		{
+			name: "get last apply config error",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Phase = v1alpha1.UpgradePhase
+				tc.Status.TiKV.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Synced = true
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				oldSet.SetAnnotations(map[string]string{LastAppliedConfigAnnotation: "fake apply config"})
+			},
+			changePods:          nil,
+			beginEvictLeaderErr: false,
+			endEvictLeaderErr:   false,
+			updatePodErr:        false,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).To(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(tc.Status.TiKV.Phase).To(Equal(v1alpha1.NormalPhase))
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(3)))
+			},
+		},
+		{
+			name: "begin evict leaders on store[2]",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
+				tc.Status.TiKV.Synced = true
+				tc.Status.TiKV.StatefulSet.CurrentReplicas = 2
+				tc.Status.TiKV.StatefulSet.UpdatedReplicas = 1
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+				oldSet.Status.CurrentReplicas = 2
+				oldSet.Status.UpdatedReplicas = 1
+				oldSet.Spec.UpdateStrategy.RollingUpdate.Partition = controller.Int32Ptr(2)
+			},
+			changePods:          nil,
+			beginEvictLeaderErr: false,
+			endEvictLeaderErr:   false,
+			updatePodErr:        false,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(2)))
+				_, exist := pods[TikvPodName(upgradeTcName, 1)].Annotations[EvictLeaderBeginTime]
+				g.Expect(exist).To(BeTrue())
+			},
+		},
+		{
+			name: "wait between upgrades after the upgraded pod is ready",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Spec.TiKV.WaitDurationBetweenUpgrades = &metav1.Duration{Duration: time.Minute}
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
+				tc.Status.TiKV.Synced = true
+				tc.Status.TiKV.StatefulSet.CurrentReplicas = 2
+				tc.Status.TiKV.StatefulSet.UpdatedReplicas = 1
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+				oldSet.Status.CurrentReplicas = 2
+				oldSet.Status.UpdatedReplicas = 1
+				oldSet.Spec.UpdateStrategy.RollingUpdate.Partition = controller.Int32Ptr(2)
+			},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+				after, ok := controller.RequeueAfter(err)
+				g.Expect(ok).To(BeTrue())
+				g.Expect(after).To(Equal(time.Minute))
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(2)))
+				g.Expect(tc.Status.TiKV.LastUpgradedPod.Name).To(Equal(TikvPodName(upgradeTcName, 2)))
+				g.Expect(pods[TikvPodName(upgradeTcName, 1)].Annotations).NotTo(HaveKey(EvictLeaderBeginTime))
+			},
+		},
+		{
+			name: "force upgrade annotation skips the wait between upgrades",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Annotations = map[string]string{label.AnnForceUpgradeKey: label.AnnForceUpgradeVal}
+				tc.Spec.TiKV.WaitDurationBetweenUpgrades = &metav1.Duration{Duration: time.Minute}
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
+				tc.Status.TiKV.Synced = true
+				tc.Status.TiKV.StatefulSet.CurrentReplicas = 2
+				tc.Status.TiKV.StatefulSet.UpdatedReplicas = 1
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+				oldSet.Status.CurrentReplicas = 2
+				oldSet.Status.UpdatedReplicas = 1
+				oldSet.Spec.UpdateStrategy.RollingUpdate.Partition = controller.Int32Ptr(2)
+			},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(1)))
+			},
+		},
+		{
+			name: "force upgrade annotation does not skip the wait between upgrades of a healthy cluster",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				setHealthyPD(tc)
+				tc.Annotations = map[string]string{label.AnnForceUpgradeKey: label.AnnForceUpgradeVal}
+				tc.Spec.TiKV.WaitDurationBetweenUpgrades = &metav1.Duration{Duration: time.Minute}
+				tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
+				tc.Status.TiKV.Synced = true
+				tc.Status.TiKV.StatefulSet.CurrentReplicas = 2
+				tc.Status.TiKV.StatefulSet.UpdatedReplicas = 1
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+				oldSet.Status.CurrentReplicas = 2
+				oldSet.Status.UpdatedReplicas = 1
+				oldSet.Spec.UpdateStrategy.RollingUpdate.Partition = controller.Int32Ptr(2)
+			},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				after, ok := controller.RequeueAfter(err)
+				g.Expect(ok).To(BeTrue())
+				g.Expect(after).To(Equal(time.Minute))
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(2)))
+				g.Expect(pods[TikvPodName(upgradeTcName, 1)].Annotations).NotTo(HaveKey(EvictLeaderBeginTime))
+			},
+		},
+		{
+			name: "upgrade goes on once the wait between upgrades is over",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Spec.TiKV.WaitDurationBetweenUpgrades = &metav1.Duration{Duration: time.Minute}
+				tc.Status.TiKV.LastUpgradedPod = &v1alpha1.UpgradedPod{
+					Name:      TikvPodName(upgradeTcName, 2),
+					ReadyTime: metav1.NewTime(now.Add(-2 * time.Minute)),
+				}
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
+				tc.Status.TiKV.Synced = true
+				tc.Status.TiKV.StatefulSet.CurrentReplicas = 2
+				tc.Status.TiKV.StatefulSet.UpdatedReplicas = 1
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+				oldSet.Status.CurrentReplicas = 2
+				oldSet.Status.UpdatedReplicas = 1
+				oldSet.Spec.UpdateStrategy.RollingUpdate.Partition = controller.Int32Ptr(2)
+			},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(pods[TikvPodName(upgradeTcName, 1)].Annotations).To(HaveKey(EvictLeaderBeginTime))
+			},
+		},
+		{
+			name: "leader eviction is not ended again while waiting between upgrades",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Spec.TiKV.WaitDurationBetweenUpgrades = &metav1.Duration{Duration: time.Minute}
+				tc.Status.TiKV.LastUpgradedPod = &v1alpha1.UpgradedPod{
+					Name:      TikvPodName(upgradeTcName, 2),
+					ReadyTime: metav1.NewTime(now.Add(-10 * time.Second)),
+				}
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
+				tc.Status.TiKV.Synced = true
+				tc.Status.TiKV.StatefulSet.CurrentReplicas = 2
+				tc.Status.TiKV.StatefulSet.UpdatedReplicas = 1
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+				oldSet.Status.CurrentReplicas = 2
+				oldSet.Status.UpdatedReplicas = 1
+				oldSet.Spec.UpdateStrategy.RollingUpdate.Partition = controller.Int32Ptr(2)
+			},
+			changePods: func(pods []*corev1.Pod) {
+				for _, pod := range pods {
+					if pod.GetName() == TikvPodName(upgradeTcName, 2) {
+						pod.CreationTimestamp = metav1.NewTime(now.Add(-time.Minute))
+					}
+				}
+			},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(2)))
+			},
+			endEvictLeaderFn: func(g *GomegaWithT, stores []uint64) {
+				g.Expect(stores).To(BeEmpty())
+			},
+		},
+		{
+			name: "leader eviction is ended for the pod recreated after the upgrade recorded",
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Spec.TiKV.WaitDurationBetweenUpgrades = &metav1.Duration{Duration: time.Minute}
+				tc.Status.TiKV.LastUpgradedPod = &v1alpha1.UpgradedPod{
+					Name:      TikvPodName(upgradeTcName, 2),
+					ReadyTime: metav1.NewTime(now.Add(-time.Hour)),
+				}
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
+				tc.Status.TiKV.Synced = true
+				tc.Status.TiKV.StatefulSet.CurrentReplicas = 2
+				tc.Status.TiKV.StatefulSet.UpdatedReplicas = 1
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+				oldSet.Status.CurrentReplicas = 2
+				oldSet.Status.UpdatedReplicas = 1
+				oldSet.Spec.UpdateStrategy.RollingUpdate.Partition = controller.Int32Ptr(2)
+			},
+			changePods: func(pods []*corev1.Pod) {
+				for _, pod := range pods {
+					if pod.GetName() == TikvPodName(upgradeTcName, 2) {
+						pod.CreationTimestamp = metav1.NewTime(now.Add(-time.Minute))
 					}
 				}
 			},
-			beginEvictLeaderErr: false,
-			endEvictLeaderErr:   false,
-			updatePodErr:        false,
 			errExpectFn: func(g *GomegaWithT, err error) {
-				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
 			},
 			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
-				g.Expect(tc.Status.TiKV.Phase).To(Equal(v1alpha1.UpgradePhase))
-				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(2)))
+				g.Expect(tc.Status.TiKV.LastUpgradedPod.ReadyTime.Time).To(BeTemporally("==", now))
+			},
+			endEvictLeaderFn: func(g *GomegaWithT, stores []uint64) {
+				g.Expect(stores).To(Equal([]uint64{3}))
 			},
 		},
 		{
-			name: "to upgrade the pod which ordinal is 1",
+			name: "waiting leader count equals to 0",
 			changeFn: func(tc *v1alpha1.TikvCluster) {
 				tc.Status.PD.Phase = v1alpha1.NormalPhase
 				tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
 				tc.Status.TiKV.Synced = true
 				tc.Status.TiKV.StatefulSet.CurrentReplicas = 2
 				tc.Status.TiKV.StatefulSet.UpdatedReplicas = 1
-				// set leader to 0
-				store := tc.Status.TiKV.Stores["2"]
-				store.LeaderCount = 0
-				tc.Status.TiKV.Stores["2"] = store
 			},
 			changeOldSet: func(oldSet *apps.StatefulSet) {
 				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
@@ -211,7 +1020,7 @@ func TestTiKVUpgraderUpgrade(t *testing.T) {
 			changePods: func(pods []*corev1.Pod) {
 				for _, pod := range pods {
 					if pod.GetName() == TikvPodName(upgradeTcName, 1) {
-						pod.Annotations = map[string]string{EvictLeaderBeginTime: time.Now().Add(-1 * time.Minute).Format(time.RFC3339)}
+						pod.Annotations = map[string]string{EvictLeaderBeginTime: now.Format(time.RFC3339)}
 					}
 				}
 			},
@@ -219,101 +1028,130 @@ func TestTiKVUpgraderUpgrade(t *testing.T) {
 			endEvictLeaderErr:   false,
 			updatePodErr:        false,
 			errExpectFn: func(g *GomegaWithT, err error) {
-				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(Equal("tidbcluster: [default/upgrader]'s tikv pod: [upgrader-tikv-1] is evicting leader"))
 			},
 			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
-				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(1)))
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(2)))
 			},
 		},
 		{
-			name: "newSet template changed",
+			name: "cluster is deleted while evicting leaders",
 			changeFn: func(tc *v1alpha1.TikvCluster) {
 				tc.Status.PD.Phase = v1alpha1.NormalPhase
-				tc.Status.TiKV.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
 				tc.Status.TiKV.Synced = true
+				tc.Status.TiKV.StatefulSet.CurrentReplicas = 2
+				tc.Status.TiKV.StatefulSet.UpdatedReplicas = 1
+				tc.DeletionTimestamp = &metav1.Time{Time: now}
 			},
 			changeOldSet: func(oldSet *apps.StatefulSet) {
-				oldSet.Spec.Template.Spec.Containers[0].Image = "old-image"
 				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+				oldSet.Status.CurrentReplicas = 2
+				oldSet.Status.UpdatedReplicas = 1
+				oldSet.Spec.UpdateStrategy.RollingUpdate.Partition = controller.Int32Ptr(2)
+			},
+			changePods: func(pods []*corev1.Pod) {
+				for _, pod := range pods {
+					if pod.GetName() == TikvPodName(upgradeTcName, 1) {
+						pod.Annotations = map[string]string{EvictLeaderBeginTime: now.Format(time.RFC3339)}
+					}
+				}
 			},
-			changePods:          nil,
 			beginEvictLeaderErr: false,
 			endEvictLeaderErr:   false,
 			updatePodErr:        false,
 			errExpectFn: func(g *GomegaWithT, err error) {
-				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(controller.IsIgnoreError(err)).To(BeTrue())
 			},
 			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
-				g.Expect(tc.Status.TiKV.Phase).To(Equal(v1alpha1.UpgradePhase))
 				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(3)))
 			},
+			endEvictLeaderFn: func(g *GomegaWithT, stores []uint64) {
+				g.Expect(stores).To(BeEmpty())
+			},
 		},
+
 		{
-			name: "update revision equals current revision",
+			name: "roll back begins evicting leaders from the lowest ordinal rolled back",
 			changeFn: func(tc *v1alpha1.TikvCluster) {
 				tc.Status.PD.Phase = v1alpha1.NormalPhase
-				tc.Status.TiKV.Phase = v1alpha1.NormalPhase
-				tc.Status.TiKV.Synced = true
-				tc.Status.TiKV.StatefulSet.UpdateRevision = tc.Status.TiKV.StatefulSet.CurrentRevision
+				tc.Status.TiKV.StatefulSet.UpdateRevision = "1"
+			},
+			changeOldSet: func(oldSet *apps.StatefulSet) {
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+				oldSet.Status.CurrentReplicas = 1
 			},
-			changePods:          nil,
-			beginEvictLeaderErr: false,
-			endEvictLeaderErr:   false,
-			updatePodErr:        false,
 			errExpectFn: func(g *GomegaWithT, err error) {
 				g.Expect(err).NotTo(HaveOccurred())
 			},
 			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
-				g.Expect(tc.Status.TiKV.Phase).To(Equal(v1alpha1.UpgradePhase))
+				g.Expect(tc.Status.TiKV.RollingBackFrom).To(Equal("2"))
+				g.Expect(tc.Status.TiKV.UpdatedOrdinals).To(Equal([]int32{0}))
 				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(3)))
+				g.Expect(pods[TikvPodName(upgradeTcName, 1)].Annotations).To(HaveKey(EvictLeaderBeginTime))
+				g.Expect(pods[TikvPodName(upgradeTcName, 2)].Annotations).NotTo(HaveKey(EvictLeaderBeginTime))
 			},
 		},
 		{
-			name: "tikv can not upgrade when pd is upgrading",
+			name: "roll back deletes the pod once the leaders are evicted",
 			changeFn: func(tc *v1alpha1.TikvCluster) {
-				tc.Status.PD.Phase = v1alpha1.UpgradePhase
-				tc.Status.TiKV.Phase = v1alpha1.NormalPhase
-				tc.Status.TiKV.Synced = true
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.StatefulSet.UpdateRevision = "1"
+				store := tc.Status.TiKV.Stores["2"]
+				store.LeaderCount = 0
+				tc.Status.TiKV.Stores["2"] = store
 			},
 			changeOldSet: func(oldSet *apps.StatefulSet) {
 				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+				oldSet.Status.CurrentReplicas = 1
+			},
+			changePods: func(pods []*corev1.Pod) {
+				for _, pod := range pods {
+					if pod.GetName() == TikvPodName(upgradeTcName, 1) {
+						pod.Annotations = map[string]string{EvictLeaderBeginTime: now.Format(time.RFC3339)}
+					}
+				}
 			},
-			changePods:          nil,
-			beginEvictLeaderErr: false,
-			endEvictLeaderErr:   false,
-			updatePodErr:        false,
 			errExpectFn: func(g *GomegaWithT, err error) {
 				g.Expect(err).NotTo(HaveOccurred())
 			},
 			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
-				g.Expect(tc.Status.TiKV.Phase).To(Equal(v1alpha1.NormalPhase))
 				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(3)))
+				g.Expect(pods).NotTo(HaveKey(TikvPodName(upgradeTcName, 1)))
+				g.Expect(pods).To(HaveKey(TikvPodName(upgradeTcName, 2)))
+			},
+			endEvictLeaderFn: func(g *GomegaWithT, stores []uint64) {
+				g.Expect(stores).To(Equal([]uint64{2}))
 			},
 		},
 		{
-			name: "get last apply config error",
+			name: "roll back waits for the rolled back pod to be ready",
 			changeFn: func(tc *v1alpha1.TikvCluster) {
-				tc.Status.PD.Phase = v1alpha1.UpgradePhase
-				tc.Status.TiKV.Phase = v1alpha1.NormalPhase
-				tc.Status.TiKV.Synced = true
+				tc.Status.PD.Phase = v1alpha1.NormalPhase
+				tc.Status.TiKV.StatefulSet.UpdateRevision = "1"
 			},
 			changeOldSet: func(oldSet *apps.StatefulSet) {
-				oldSet.SetAnnotations(map[string]string{LastAppliedConfigAnnotation: "fake apply config"})
+				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+				oldSet.Status.CurrentReplicas = 2
+			},
+			changePods: func(pods []*corev1.Pod) {
+				for _, pod := range pods {
+					if pod.GetName() == TikvPodName(upgradeTcName, 1) {
+						pod.Status.Conditions = nil
+					}
+				}
 			},
-			changePods:          nil,
-			beginEvictLeaderErr: false,
-			endEvictLeaderErr:   false,
-			updatePodErr:        false,
 			errExpectFn: func(g *GomegaWithT, err error) {
-				g.Expect(err).To(HaveOccurred())
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
 			},
 			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
-				g.Expect(tc.Status.TiKV.Phase).To(Equal(v1alpha1.NormalPhase))
-				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(3)))
+				g.Expect(tc.Status.TiKV.RollingBackFrom).To(Equal("2"))
+				g.Expect(pods[TikvPodName(upgradeTcName, 2)].Annotations).NotTo(HaveKey(EvictLeaderBeginTime))
 			},
 		},
 		{
-			name: "begin evict leaders on store[2]",
+			name: "failed to begin evict leaders on store[2]",
 			changeFn: func(tc *v1alpha1.TikvCluster) {
 				tc.Status.PD.Phase = v1alpha1.NormalPhase
 				tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
@@ -328,20 +1166,20 @@ func TestTiKVUpgraderUpgrade(t *testing.T) {
 				oldSet.Spec.UpdateStrategy.RollingUpdate.Partition = controller.Int32Ptr(2)
 			},
 			changePods:          nil,
-			beginEvictLeaderErr: false,
+			beginEvictLeaderErr: true,
 			endEvictLeaderErr:   false,
 			updatePodErr:        false,
 			errExpectFn: func(g *GomegaWithT, err error) {
-				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(err).To(HaveOccurred())
 			},
 			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
 				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(2)))
 				_, exist := pods[TikvPodName(upgradeTcName, 1)].Annotations[EvictLeaderBeginTime]
-				g.Expect(exist).To(BeTrue())
+				g.Expect(exist).To(BeFalse())
 			},
 		},
 		{
-			name: "waiting leader count equals to 0",
+			name: "evict leaders time out",
 			changeFn: func(tc *v1alpha1.TikvCluster) {
 				tc.Status.PD.Phase = v1alpha1.NormalPhase
 				tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
@@ -358,7 +1196,7 @@ func TestTiKVUpgraderUpgrade(t *testing.T) {
 			changePods: func(pods []*corev1.Pod) {
 				for _, pod := range pods {
 					if pod.GetName() == TikvPodName(upgradeTcName, 1) {
-						pod.Annotations = map[string]string{EvictLeaderBeginTime: time.Now().Format(time.RFC3339)}
+						pod.Annotations = map[string]string{EvictLeaderBeginTime: now.Add(-5 * time.Minute).Format(time.RFC3339)}
 					}
 				}
 			},
@@ -366,45 +1204,16 @@ func TestTiKVUpgraderUpgrade(t *testing.T) {
 			endEvictLeaderErr:   false,
 			updatePodErr:        false,
 			errExpectFn: func(g *GomegaWithT, err error) {
-				g.Expect(err).To(HaveOccurred())
-				g.Expect(err.Error()).To(Equal("tidbcluster: [default/upgrader]'s tikv pod: [upgrader-tikv-1] is evicting leader"))
-			},
-			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
-				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(2)))
-			},
-		},
-
-		{
-			name: "failed to begin evict leaders on store[2]",
-			changeFn: func(tc *v1alpha1.TikvCluster) {
-				tc.Status.PD.Phase = v1alpha1.NormalPhase
-				tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
-				tc.Status.TiKV.Synced = true
-				tc.Status.TiKV.StatefulSet.CurrentReplicas = 2
-				tc.Status.TiKV.StatefulSet.UpdatedReplicas = 1
-			},
-			changeOldSet: func(oldSet *apps.StatefulSet) {
-				SetStatefulSetLastAppliedConfigAnnotation(oldSet)
-				oldSet.Status.CurrentReplicas = 2
-				oldSet.Status.UpdatedReplicas = 1
-				oldSet.Spec.UpdateStrategy.RollingUpdate.Partition = controller.Int32Ptr(2)
-			},
-			changePods:          nil,
-			beginEvictLeaderErr: true,
-			endEvictLeaderErr:   false,
-			updatePodErr:        false,
-			errExpectFn: func(g *GomegaWithT, err error) {
-				g.Expect(err).To(HaveOccurred())
+				g.Expect(err).NotTo(HaveOccurred())
 			},
 			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
-				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(2)))
-				_, exist := pods[TikvPodName(upgradeTcName, 1)].Annotations[EvictLeaderBeginTime]
-				g.Expect(exist).To(BeFalse())
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(1)))
 			},
 		},
 		{
-			name: "evict leaders time out",
+			name: "evict leaders not time out with configured timeout",
 			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Spec.TiKV.EvictLeaderTimeout = pointer.StringPtr("10m")
 				tc.Status.PD.Phase = v1alpha1.NormalPhase
 				tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
 				tc.Status.TiKV.Synced = true
@@ -420,7 +1229,7 @@ func TestTiKVUpgraderUpgrade(t *testing.T) {
 			changePods: func(pods []*corev1.Pod) {
 				for _, pod := range pods {
 					if pod.GetName() == TikvPodName(upgradeTcName, 1) {
-						pod.Annotations = map[string]string{EvictLeaderBeginTime: time.Now().Add(-5 * time.Minute).Format(time.RFC3339)}
+						pod.Annotations = map[string]string{EvictLeaderBeginTime: now.Add(-5 * time.Minute).Format(time.RFC3339)}
 					}
 				}
 			},
@@ -428,10 +1237,11 @@ func TestTiKVUpgraderUpgrade(t *testing.T) {
 			endEvictLeaderErr:   false,
 			updatePodErr:        false,
 			errExpectFn: func(g *GomegaWithT, err error) {
-				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(Equal("tidbcluster: [default/upgrader]'s tikv pod: [upgrader-tikv-1] is evicting leader"))
 			},
 			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
-				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(1)))
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(2)))
 			},
 		},
 		{
@@ -452,7 +1262,7 @@ func TestTiKVUpgraderUpgrade(t *testing.T) {
 			changePods: func(pods []*corev1.Pod) {
 				for _, pod := range pods {
 					if pod.GetName() == TikvPodName(upgradeTcName, 1) {
-						pod.Annotations = map[string]string{EvictLeaderBeginTime: time.Now().Format(time.RFC3339)}
+						pod.Annotations = map[string]string{EvictLeaderBeginTime: now.Format(time.RFC3339)}
 					}
 				}
 			},
@@ -510,7 +1320,7 @@ func TestTiKVUpgraderUpgrade(t *testing.T) {
 			changePods: func(pods []*corev1.Pod) {
 				for _, pod := range pods {
 					if pod.GetName() == TikvPodName(upgradeTcName, 2) {
-						pod.Annotations = map[string]string{EvictLeaderBeginTime: time.Now().Format(time.RFC3339)}
+						pod.Annotations = map[string]string{EvictLeaderBeginTime: now.Format(time.RFC3339)}
 					}
 				}
 			},
@@ -531,16 +1341,384 @@ func TestTiKVUpgraderUpgrade(t *testing.T) {
 	}
 }
 
-func newTiKVUpgrader() (Upgrader, *pdapi.FakePDControl, *controller.FakePodControl, podinformers.PodInformer) {
-	kubeCli := kubefake.NewSimpleClientset()
-	podInformer := kubeinformers.NewSharedInformerFactory(kubeCli, 0).Core().V1().Pods()
-	podControl := controller.NewFakePodControl(podInformer)
-	pdControl := pdapi.NewFakePDControl(kubeCli)
-	return &tikvUpgrader{
-		pdControl:  pdControl,
-		podControl: podControl,
-		podLister:  podInformer.Lister(),
-	}, pdControl, podControl, podInformer
+func TestTiKVUpgraderUpgradeTogether(t *testing.T) {
+	g := NewGomegaWithT(t)
+	now := time.Now()
+	evicted := now.Add(-5 * time.Minute).Format(time.RFC3339)
+	// the stores of the pods of ordinals 0 and 1 are in zone a, 2 and 3 in zone b, 4 and 5 in zone c
+	zones := []string{"a", "a", "b", "b", "c", "c"}
+
+	tests := []struct {
+		name             string
+		maxUpgradingPods int32
+		maxReplicas      uint64
+		zones            []string
+		changeFn         func(*v1alpha1.TikvCluster)
+		partition        int32
+		changePods       func(map[int32]*corev1.Pod)
+		errExpectFn      func(*GomegaWithT, error)
+		expectFn         func(*GomegaWithT, *v1alpha1.TikvCluster, *apps.StatefulSet, map[string]*corev1.Pod)
+		expectEndEvict   []uint64
+	}{
+		{
+			name:             "evict leaders from the pods upgraded together",
+			maxUpgradingPods: 3,
+			partition:        6,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(6)))
+				g.Expect(pods[TikvPodName(upgradeTcName, 5)].Annotations).To(HaveKey(EvictLeaderBeginTime))
+				g.Expect(pods[TikvPodName(upgradeTcName, 4)].Annotations).To(HaveKey(EvictLeaderBeginTime))
+				g.Expect(pods[TikvPodName(upgradeTcName, 3)].Annotations).NotTo(HaveKey(EvictLeaderBeginTime))
+			},
+		},
+		{
+			name:             "upgrade the pods together once the leaders are evicted",
+			maxUpgradingPods: 3,
+			partition:        6,
+			changePods: func(pods map[int32]*corev1.Pod) {
+				pods[5].Annotations = map[string]string{EvictLeaderBeginTime: evicted}
+				pods[4].Annotations = map[string]string{EvictLeaderBeginTime: evicted}
+			},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(4)))
+				g.Expect(tc.Status.TiKV.UpgradingOrdinals).To(Equal([]int32{5, 4}))
+			},
+		},
+		{
+			name:             "clamped to keep max-replicas stores not upgraded",
+			maxUpgradingPods: 3,
+			maxReplicas:      5,
+			partition:        6,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(pods[TikvPodName(upgradeTcName, 5)].Annotations).To(HaveKey(EvictLeaderBeginTime))
+				g.Expect(pods[TikvPodName(upgradeTcName, 4)].Annotations).NotTo(HaveKey(EvictLeaderBeginTime))
+			},
+		},
+		{
+			name:             "stores in fewer zones than max-replicas",
+			maxUpgradingPods: 3,
+			zones:            []string{"a", "a", "a", "b", "b", "b"},
+			partition:        6,
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(pods[TikvPodName(upgradeTcName, 5)].Annotations).To(HaveKey(EvictLeaderBeginTime))
+				g.Expect(pods[TikvPodName(upgradeTcName, 4)].Annotations).NotTo(HaveKey(EvictLeaderBeginTime))
+			},
+		},
+		{
+			name:             "upgrade partition",
+			maxUpgradingPods: 3,
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Spec.TiKV.UpgradePartition = pointer.Int32Ptr(5)
+			},
+			partition: 6,
+			changePods: func(pods map[int32]*corev1.Pod) {
+				pods[5].Annotations = map[string]string{EvictLeaderBeginTime: evicted}
+			},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(5)))
+				g.Expect(tc.Status.TiKV.UpgradingOrdinals).To(Equal([]int32{5}))
+			},
+		},
+		{
+			name:             "restart the pods upgraded together",
+			maxUpgradingPods: 3,
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.TiKV.UpgradingOrdinals = []int32{5, 4}
+			},
+			partition: 4,
+			changePods: func(pods map[int32]*corev1.Pod) {
+				pods[5].Annotations = map[string]string{EvictLeaderBeginTime: evicted}
+				pods[4].Annotations = map[string]string{EvictLeaderBeginTime: evicted}
+			},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(4)))
+				g.Expect(pods).NotTo(HaveKey(TikvPodName(upgradeTcName, 5)))
+				g.Expect(pods).NotTo(HaveKey(TikvPodName(upgradeTcName, 4)))
+				g.Expect(pods).To(HaveKey(TikvPodName(upgradeTcName, 3)))
+			},
+		},
+		{
+			name:             "pods recreated are not restarted again",
+			maxUpgradingPods: 3,
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.TiKV.UpgradingOrdinals = []int32{5, 4}
+			},
+			partition: 4,
+			changePods: func(pods map[int32]*corev1.Pod) {
+				pods[5].Labels[apps.ControllerRevisionHashLabelKey] = "2"
+				pods[5].Status.Conditions = nil
+			},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(pods).To(HaveKey(TikvPodName(upgradeTcName, 5)))
+				g.Expect(pods).To(HaveKey(TikvPodName(upgradeTcName, 4)))
+			},
+		},
+		{
+			name:             "next pods upgraded together once the pods upgraded are ready",
+			maxUpgradingPods: 3,
+			changeFn: func(tc *v1alpha1.TikvCluster) {
+				tc.Status.TiKV.UpgradingOrdinals = []int32{5, 4}
+			},
+			partition: 4,
+			changePods: func(pods map[int32]*corev1.Pod) {
+				pods[5].Labels[apps.ControllerRevisionHashLabelKey] = "2"
+				pods[4].Labels[apps.ControllerRevisionHashLabelKey] = "2"
+			},
+			errExpectFn: func(g *GomegaWithT, err error) {
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+			},
+			expectFn: func(g *GomegaWithT, tc *v1alpha1.TikvCluster, newSet *apps.StatefulSet, pods map[string]*corev1.Pod) {
+				g.Expect(tc.Status.TiKV.LastUpgradedPod.Name).To(Equal(TikvPodName(upgradeTcName, 4)))
+				g.Expect(pods[TikvPodName(upgradeTcName, 3)].Annotations).To(HaveKey(EvictLeaderBeginTime))
+				g.Expect(pods[TikvPodName(upgradeTcName, 2)].Annotations).To(HaveKey(EvictLeaderBeginTime))
+				g.Expect(pods[TikvPodName(upgradeTcName, 1)].Annotations).NotTo(HaveKey(EvictLeaderBeginTime))
+			},
+			expectEndEvict: []uint64{6, 5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upgrader, pdControl, _, podInformer := newTiKVUpgrader()
+			upgrader.clock.(*clock.FakeClock).SetTime(now)
+			tikvZones := zones
+			if tt.zones != nil {
+				tikvZones = tt.zones
+			}
+			maxReplicas := uint64(3)
+			if tt.maxReplicas != 0 {
+				maxReplicas = tt.maxReplicas
+			}
+
+			tc := newTikvClusterForTiKVUpgrader()
+			tc.Spec.TiKV.Replicas = 6
+			tc.Spec.TiKV.MaxUpgradingPods = pointer.Int32Ptr(tt.maxUpgradingPods)
+			tc.Status.PD.Phase = v1alpha1.NormalPhase
+			tc.Status.TiKV.StatefulSet.Replicas = 6
+			tc.Status.TiKV.Stores = map[string]v1alpha1.TiKVStore{}
+			var stores []*pdapi.StoreInfo
+			for i := int32(0); i < 6; i++ {
+				id := uint64(i + 1)
+				tc.Status.TiKV.Stores[strconv.FormatUint(id, 10)] = v1alpha1.TiKVStore{
+					ID:      strconv.FormatUint(id, 10),
+					PodName: TikvPodName(upgradeTcName, i),
+					State:   v1alpha1.TiKVStateUp,
+				}
+				stores = append(stores, &pdapi.StoreInfo{Store: &pdapi.MetaStore{
+					Store: &metapb.Store{Id: id, Labels: []*metapb.StoreLabel{{Key: "zone", Value: tikvZones[i]}}},
+				}})
+			}
+			if tt.changeFn != nil {
+				tt.changeFn(tc)
+			}
+
+			oldSet := oldStatefulSetForTiKVUpgrader()
+			oldSet.Spec.Replicas = controller.Int32Ptr(6)
+			oldSet.Spec.UpdateStrategy.RollingUpdate.Partition = controller.Int32Ptr(tt.partition)
+			oldSet.Status.Replicas = 6
+			oldSet.Status.CurrentReplicas = 6
+			SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+			newSet := newStatefulSetForTiKVUpgrader()
+			newSet.Spec.Replicas = controller.Int32Ptr(6)
+
+			pdClient := controller.NewFakePDClient(pdControl, tc)
+			pdClient.AddReaction(pdapi.GetConfigActionType, func(action *pdapi.Action) (interface{}, error) {
+				return &pdapi.PDConfigFromAPI{Replication: &pdapi.PDReplicationConfig{
+					MaxReplicas:    &maxReplicas,
+					LocationLabels: pdapi.StringSlice{"zone"},
+				}}, nil
+			})
+			pdClient.AddReaction(pdapi.GetStoresActionType, func(action *pdapi.Action) (interface{}, error) {
+				return &pdapi.StoresInfo{Stores: stores}, nil
+			})
+			pdClient.AddReaction(pdapi.GetRegionCountActionType, func(action *pdapi.Action) (interface{}, error) {
+				return 0, nil
+			})
+			var endEvict []uint64
+			pdClient.AddReaction(pdapi.EndEvictLeaderActionType, func(action *pdapi.Action) (interface{}, error) {
+				endEvict = append(endEvict, action.ID)
+				return nil, nil
+			})
+
+			pods := map[int32]*corev1.Pod{}
+			for i, pod := range getTiKVPods(oldSet) {
+				pods[int32(i)] = pod
+			}
+			if tt.changePods != nil {
+				tt.changePods(pods)
+			}
+			for _, pod := range pods {
+				podInformer.Informer().GetIndexer().Add(pod)
+			}
+
+			tt.errExpectFn(g, upgrader.Upgrade(tc, oldSet, newSet))
+			tikvPods, err := podInformer.Lister().Pods(tc.Namespace).List(labels.Everything())
+			g.Expect(err).NotTo(HaveOccurred())
+			podsByName := map[string]*corev1.Pod{}
+			for _, pod := range tikvPods {
+				podsByName[pod.GetName()] = pod
+			}
+			tt.expectFn(g, tc, newSet, podsByName)
+			g.Expect(endEvict).To(Equal(tt.expectEndEvict))
+		})
+	}
+}
+
+func TestTiKVUpgraderWaitBetweenUpgrades(t *testing.T) {
+	g := NewGomegaWithT(t)
+	upgrader, pdControl, _, podInformer := newTiKVUpgrader()
+	fakeClock := upgrader.clock.(*clock.FakeClock)
+	tc := newTikvClusterForTiKVUpgrader()
+	tc.Spec.TiKV.WaitDurationBetweenUpgrades = &metav1.Duration{Duration: time.Minute}
+	tc.Status.TiKV.LastUpgradedPod = &v1alpha1.UpgradedPod{
+		Name:      TikvPodName(upgradeTcName, 2),
+		ReadyTime: metav1.NewTime(fakeClock.Now().Add(-10 * time.Second)),
+	}
+	tc.Status.PD.Phase = v1alpha1.NormalPhase
+	tc.Status.TiKV.Phase = v1alpha1.UpgradePhase
+	tc.Status.TiKV.Synced = true
+	tc.Status.TiKV.StatefulSet.CurrentReplicas = 2
+	tc.Status.TiKV.StatefulSet.UpdatedReplicas = 1
+	oldSet := oldStatefulSetForTiKVUpgrader()
+	SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+	oldSet.Status.CurrentReplicas = 2
+	oldSet.Status.UpdatedReplicas = 1
+	oldSet.Spec.UpdateStrategy.RollingUpdate.Partition = controller.Int32Ptr(2)
+	for _, pod := range getTiKVPods(oldSet) {
+		podInformer.Informer().GetIndexer().Add(pod)
+	}
+	pdClient := controller.NewFakePDClient(pdControl, tc)
+	pdClient.AddReaction(pdapi.BeginEvictLeaderActionType, func(action *pdapi.Action) (interface{}, error) {
+		return nil, nil
+	})
+	pdClient.AddReaction(pdapi.GetRegionCountActionType, func(action *pdapi.Action) (interface{}, error) {
+		return 0, nil
+	})
+	pdClient.AddReaction(pdapi.EndEvictLeaderActionType, func(action *pdapi.Action) (interface{}, error) {
+		return nil, nil
+	})
+	evicting := func() bool {
+		pod, err := podInformer.Lister().Pods(corev1.NamespaceDefault).Get(TikvPodName(upgradeTcName, 1))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, exist := pod.Annotations[EvictLeaderBeginTime]
+		return exist
+	}
+
+	err := upgrader.Upgrade(tc, oldSet, newStatefulSetForTiKVUpgrader())
+	after, ok := controller.RequeueAfter(err)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(after).To(Equal(50 * time.Second))
+	g.Expect(evicting()).To(BeFalse())
+
+	fakeClock.Step(49 * time.Second)
+	err = upgrader.Upgrade(tc, oldSet, newStatefulSetForTiKVUpgrader())
+	after, ok = controller.RequeueAfter(err)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(after).To(Equal(time.Second))
+	g.Expect(evicting()).To(BeFalse())
+
+	fakeClock.Step(time.Second)
+	g.Expect(upgrader.Upgrade(tc, oldSet, newStatefulSetForTiKVUpgrader())).To(Succeed())
+	g.Expect(evicting()).To(BeTrue())
+}
+
+func TestTiKVUpgraderStaleStoresCache(t *testing.T) {
+	g := NewGomegaWithT(t)
+	upgrader, pdControl, _, podInformer := newTiKVUpgrader()
+	tc := newTikvClusterForTiKVUpgrader()
+	tc.Status.PD.Phase = v1alpha1.NormalPhase
+	oldSet := oldStatefulSetForTiKVUpgrader()
+	SetStatefulSetLastAppliedConfigAnnotation(oldSet)
+	oldSet.Status.CurrentReplicas = 2
+	oldSet.Status.UpdatedReplicas = 1
+	oldSet.Spec.UpdateStrategy.RollingUpdate.Partition = controller.Int32Ptr(2)
+	newSet := newStatefulSetForTiKVUpgrader()
+	for _, pod := range getTiKVPods(oldSet) {
+		if pod.GetName() == TikvPodName(upgradeTcName, 1) {
+			pod.Annotations = map[string]string{EvictLeaderBeginTime: upgrader.clock.Now().Add(-1 * time.Minute).Format(time.RFC3339)}
+		}
+		podInformer.Informer().GetIndexer().Add(pod)
+	}
+
+	// the cached stores were read before the upgraded pod 2 restarted, pd knows its store is down
+	stores := func(fresh bool) *pdapi.StoresInfo {
+		info := &pdapi.StoresInfo{}
+		for i := 0; i < 3; i++ {
+			state, leaderCount := v1alpha1.TiKVStateUp, 10
+			if i == 1 {
+				leaderCount = 0
+			}
+			if i == 2 && fresh {
+				state = v1alpha1.TiKVStateDown
+			}
+			info.Stores = append(info.Stores, &pdapi.StoreInfo{
+				Store: &pdapi.MetaStore{
+					Store: &metapb.Store{
+						Id:      uint64(i + 1),
+						Address: fmt.Sprintf("%s.%s-tikv-peer.%s.svc:20160", TikvPodName(upgradeTcName, int32(i)), upgradeTcName, corev1.NamespaceDefault),
+					},
+					StateName: state,
+				},
+				Status: &pdapi.StoreStatus{LeaderCount: leaderCount, LastHeartbeatTS: upgrader.clock.Now()},
+			})
+		}
+		return info
+	}
+	pdClient := controller.NewFakePDClient(pdControl, tc)
+	pdClient.AddReaction(pdapi.GetStoresActionType, func(action *pdapi.Action) (interface{}, error) {
+		return stores(action.NoCache), nil
+	})
+	pdClient.AddReaction(pdapi.GetTombStoneStoresActionType, func(action *pdapi.Action) (interface{}, error) {
+		return &pdapi.StoresInfo{}, nil
+	})
+	pdClient.AddReaction(pdapi.GetRegionCountActionType, func(action *pdapi.Action) (interface{}, error) {
+		return 0, nil
+	})
+
+	tkmm := &tikvMemberManager{
+		pdControl: pdControl,
+		podLister: podInformer.Lister(),
+		clock:     upgrader.clock,
+		tikvStatefulSetIsUpgradingFn: func(corelisters.PodLister, pdapi.PDControlInterface, *apps.StatefulSet, *v1alpha1.TikvCluster) (bool, error) {
+			return true, nil
+		},
+	}
+	g.Expect(tkmm.syncTikvClusterStatus(tc, oldSet)).To(Succeed())
+	g.Expect(tc.Status.TiKV.Stores["3"].State).To(Equal(v1alpha1.TiKVStateDown))
+
+	err := upgrader.Upgrade(tc, oldSet, newSet)
+	g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+	g.Expect(*newSet.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(2)))
+}
+
+func newTiKVUpgrader() (*tikvUpgrader, *pdapi.FakePDControl, *controller.FakePodControl, podinformers.PodInformer) {
+	d := deps.NewFakeDependencies()
+	upgrader := NewTiKVUpgrader(d).(*tikvUpgrader)
+	// the pods of the tests do not resolve
+	upgrader.dnsVerifier = nil
+	return upgrader, d.PDControl.(*pdapi.FakePDControl), d.PodControl.(*controller.FakePodControl),
+		d.KubeInformerFactory.Core().V1().Pods()
 }
 
 func newStatefulSetForTiKVUpgrader() *apps.StatefulSet {
@@ -664,7 +1842,12 @@ func getTiKVPods(set *apps.StatefulSet) []*corev1.Pod {
 				Namespace: corev1.NamespaceDefault,
 				Labels:    l,
 			},
-			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+				Conditions: []corev1.PodCondition{
+					{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+				},
+			},
 		})
 	}
 	return pods
@@ -0,0 +1,175 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"fmt"
+
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/label"
+	"github.com/tikv/tikv-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	certManagerGroup       = "cert-manager.io"
+	certManagerAPIVersion  = certManagerGroup + "/v1"
+	certManagerIssuerKind  = "Issuer"
+	certManagerCertificate = "Certificate"
+)
+
+// TLSCertManager has the certificates of the cluster TLS issued by cert-manager
+type TLSCertManager interface {
+	// Sync creates or updates the cert-manager certificates of pd, tikv and the clients of pd,
+	// i.e. the operator and the discovery service, and waits for their secrets to be issued
+	Sync(tc *v1alpha1.TikvCluster) error
+}
+
+type realTLSCertManager struct {
+	ctrl     controller.TypedControlInterface
+	recorder record.EventRecorder
+}
+
+// NewTLSCertManager returns a TLSCertManager
+func NewTLSCertManager(typedControl controller.TypedControlInterface, recorder record.EventRecorder) TLSCertManager {
+	return &realTLSCertManager{typedControl, recorder}
+}
+
+func (m *realTLSCertManager) Sync(tc *v1alpha1.TikvCluster) error {
+	if !tc.IsCertManagerEnabled() {
+		return nil
+	}
+	ns := tc.GetNamespace()
+	tcName := tc.GetName()
+
+	var waiting []string
+	for _, cert := range getTLSCertificates(tc) {
+		if _, err := m.ctrl.CreateOrUpdateCertificate(tc, cert); err != nil {
+			if apimeta.IsNoMatchError(err) {
+				msg := fmt.Sprintf("cert-manager is not installed, can not issue the certificate %s: %v", cert.GetName(), err)
+				m.recorder.Event(tc, corev1.EventTypeWarning, "CertManagerNotInstalled", msg)
+				return controller.RequeueErrorf("tikvcluster: [%s/%s], %s", ns, tcName, msg)
+			}
+			return controller.RequeueErrorf("error creating or updating certificate %s: %v", cert.GetName(), err)
+		}
+		secretName, _, _ := unstructured.NestedString(cert.Object, "spec", "secretName")
+		exist, err := m.ctrl.Exist(types.NamespacedName{Namespace: ns, Name: secretName}, &corev1.Secret{})
+		if err != nil {
+			return err
+		}
+		if !exist {
+			waiting = append(waiting, secretName)
+		}
+	}
+	// the members mount the secrets, their statefulsets are not created until the secrets are issued
+	if len(waiting) > 0 {
+		return controller.RequeueErrorf("tikvcluster: [%s/%s] is waiting for the secrets %v to be issued by cert-manager", ns, tcName, waiting)
+	}
+	return nil
+}
+
+// getTLSCertificates returns the cert-manager certificates of the cluster TLS, they are named
+// after their secrets
+func getTLSCertificates(tc *v1alpha1.TikvCluster) []*unstructured.Unstructured {
+	ns := tc.GetNamespace()
+	tcName := tc.GetName()
+	instanceName := tc.GetInstanceName()
+
+	// the members are reached through their services and by the FQDNs of their pods under the
+	// peer services, the status servers are probed on the loopback address
+	memberDNSNames := func(memberType v1alpha1.MemberType) []string {
+		svcName := controller.MemberName(tcName, memberType)
+		peerSvcName := controller.PeerMemberName(tcName, memberType)
+		return []string{
+			svcName,
+			fmt.Sprintf("%s.%s", svcName, ns),
+			fmt.Sprintf("%s.%s.svc", svcName, ns),
+			peerSvcName,
+			fmt.Sprintf("%s.%s", peerSvcName, ns),
+			fmt.Sprintf("%s.%s.svc", peerSvcName, ns),
+			fmt.Sprintf("*.%s", peerSvcName),
+			fmt.Sprintf("*.%s.%s", peerSvcName, ns),
+			fmt.Sprintf("*.%s.%s.svc", peerSvcName, ns),
+			"localhost",
+		}
+	}
+	discoveryName := controller.DiscoveryServiceName(tcName)
+
+	return []*unstructured.Unstructured{
+		newTLSCertificate(tc, util.ClusterTLSSecretName(tcName, label.PDLabelVal), label.New().Instance(instanceName).PD(), memberDNSNames(v1alpha1.PDMemberType)),
+		newTLSCertificate(tc, util.ClusterTLSSecretName(tcName, label.TiKVLabelVal), label.New().Instance(instanceName).TiKV(), memberDNSNames(v1alpha1.TiKVMemberType)),
+		newTLSCertificate(tc, util.ClusterClientTLSSecretName(tcName), label.New().Instance(instanceName).Discovery(), []string{
+			discoveryName,
+			fmt.Sprintf("%s.%s", discoveryName, ns),
+			fmt.Sprintf("%s.%s.svc", discoveryName, ns),
+		}),
+	}
+}
+
+func newTLSCertificate(tc *v1alpha1.TikvCluster, secretName string, l label.Label, dnsNames []string) *unstructured.Unstructured {
+	issuerRef := tc.Spec.TLSCluster.CertManager.IssuerRef
+	issuer := map[string]interface{}{
+		"name":  issuerRef.Name,
+		"kind":  issuerRef.Kind,
+		"group": issuerRef.Group,
+	}
+	if issuerRef.Kind == "" {
+		issuer["kind"] = certManagerIssuerKind
+	}
+	if issuerRef.Group == "" {
+		issuer["group"] = certManagerGroup
+	}
+	names := make([]interface{}, 0, len(dnsNames))
+	for _, name := range dnsNames {
+		names = append(names, name)
+	}
+
+	cert := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"secretName":  secretName,
+			"commonName":  dnsNames[0],
+			"dnsNames":    names,
+			"ipAddresses": []interface{}{"127.0.0.1"},
+			"usages":      []interface{}{"server auth", "client auth"},
+			"issuerRef":   issuer,
+		},
+	}}
+	cert.SetAPIVersion(certManagerAPIVersion)
+	cert.SetKind(certManagerCertificate)
+	cert.SetName(secretName)
+	cert.SetNamespace(tc.GetNamespace())
+	cert.SetLabels(l.Labels())
+	return cert
+}
+
+type FakeTLSCertManager struct {
+	err error
+}
+
+func NewFakeTLSCertManager() *FakeTLSCertManager {
+	return &FakeTLSCertManager{}
+}
+
+func (m *FakeTLSCertManager) SetSyncError(err error) {
+	m.err = err
+}
+
+func (m *FakeTLSCertManager) Sync(_ *v1alpha1.TikvCluster) error {
+	return m.err
+}
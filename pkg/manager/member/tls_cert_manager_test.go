@@ -0,0 +1,112 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestTLSCertManagerSync(t *testing.T) {
+	g := NewGomegaWithT(t)
+	type testcase struct {
+		name        string
+		certManager bool
+		secrets     []string
+		errOnCreate error
+		expectFn    func(*controller.FakeGenericControl, *record.FakeRecorder, error)
+	}
+	testFn := func(tt *testcase) {
+		t.Log(tt.name)
+
+		tc := newTikvClusterForPDDiscovery()
+		tc.Spec.TLSCluster = &v1alpha1.TLSCluster{Enabled: true}
+		if tt.certManager {
+			tc.Spec.TLSCluster.CertManager = &v1alpha1.CertManagerTLS{IssuerRef: v1alpha1.CertManagerIssuerRef{Name: "ca", Kind: "ClusterIssuer"}}
+		}
+		ctrl := controller.NewFakeGenericControl()
+		for _, name := range tt.secrets {
+			g.Expect(ctrl.AddObject(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: tc.Namespace, Name: name}})).To(Succeed())
+		}
+		if tt.errOnCreate != nil {
+			ctrl.SetCreateOrUpdateError(tt.errOnCreate, 0)
+		}
+		recorder := record.NewFakeRecorder(10)
+		err := NewTLSCertManager(controller.NewTypedControl(ctrl), recorder).Sync(tc)
+		tt.expectFn(ctrl, recorder, err)
+	}
+
+	tests := []*testcase{
+		{
+			name:        "certificates not issued by cert-manager",
+			certManager: false,
+			expectFn: func(ctrl *controller.FakeGenericControl, _ *record.FakeRecorder, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+		},
+		{
+			name:        "waiting for the secrets",
+			certManager: true,
+			secrets:     []string{"test-pd-cluster-secret"},
+			expectFn: func(ctrl *controller.FakeGenericControl, _ *record.FakeRecorder, err error) {
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+				g.Expect(err.Error()).To(ContainSubstring("[test-tikv-cluster-secret test-cluster-client-secret]"))
+
+				cert := &unstructured.Unstructured{}
+				cert.SetAPIVersion(certManagerAPIVersion)
+				cert.SetKind(certManagerCertificate)
+				exist, err := ctrl.Exist(client.ObjectKey{Namespace: corev1.NamespaceDefault, Name: "test-tikv-cluster-secret"}, cert)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(exist).To(BeTrue())
+				dnsNames, _, _ := unstructured.NestedStringSlice(cert.Object, "spec", "dnsNames")
+				g.Expect(dnsNames).To(ContainElement("*.test-tikv-peer.default.svc"))
+				issuer, _, _ := unstructured.NestedStringMap(cert.Object, "spec", "issuerRef")
+				g.Expect(issuer).To(Equal(map[string]string{"name": "ca", "kind": "ClusterIssuer", "group": "cert-manager.io"}))
+			},
+		},
+		{
+			name:        "secrets issued",
+			certManager: true,
+			secrets:     []string{"test-pd-cluster-secret", "test-tikv-cluster-secret", "test-cluster-client-secret"},
+			expectFn: func(ctrl *controller.FakeGenericControl, _ *record.FakeRecorder, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+		},
+		{
+			name:        "cert-manager not installed",
+			certManager: true,
+			errOnCreate: &apimeta.NoKindMatchError{GroupKind: schema.GroupKind{Group: certManagerGroup, Kind: certManagerCertificate}},
+			expectFn: func(ctrl *controller.FakeGenericControl, recorder *record.FakeRecorder, err error) {
+				g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+				g.Expect(err.Error()).To(ContainSubstring("cert-manager is not installed"))
+				g.Expect(recorder.Events).To(HaveLen(1))
+				g.Expect(<-recorder.Events).To(ContainSubstring("CertManagerNotInstalled"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		testFn(tt)
+	}
+}
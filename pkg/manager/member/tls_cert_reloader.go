@@ -0,0 +1,125 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"fmt"
+
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/label"
+	"github.com/tikv/tikv-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+// TLSCertReloader has the members load the cluster TLS certificates rotated, e.g. by cert-manager
+type TLSCertReloader interface {
+	// Sync compares the certificates of pd and tikv with the ones last observed, the members of
+	// the versions reloading certificates online pick up the rotated ones from their projected
+	// volumes, the other members are rolled with their leaders evicted by setting
+	// status.<member>.tlsCertRolloutHash
+	Sync(tc *v1alpha1.TikvCluster) error
+}
+
+type realTLSCertReloader struct {
+	secretLister corelisters.SecretLister
+	recorder     record.EventRecorder
+}
+
+// NewTLSCertReloader returns a TLSCertReloader
+func NewTLSCertReloader(secretLister corelisters.SecretLister, recorder record.EventRecorder) TLSCertReloader {
+	return &realTLSCertReloader{secretLister, recorder}
+}
+
+func (r *realTLSCertReloader) Sync(tc *v1alpha1.TikvCluster) error {
+	if !tc.IsTLSClusterEnabled() {
+		return nil
+	}
+	if err := r.sync(tc, v1alpha1.PDMemberType, label.PDLabelVal, tc.PDVersion(), &tc.Status.PD.TLSCertHash, &tc.Status.PD.TLSCertRolloutHash); err != nil {
+		return err
+	}
+	return r.sync(tc, v1alpha1.TiKVMemberType, label.TiKVLabelVal, tc.TiKVVersion(), &tc.Status.TiKV.TLSCertHash, &tc.Status.TiKV.TLSCertRolloutHash)
+}
+
+func (r *realTLSCertReloader) sync(tc *v1alpha1.TikvCluster, memberType v1alpha1.MemberType, component, version string, certHash, rolloutHash *string) error {
+	ns := tc.GetNamespace()
+	secretName := util.ClusterTLSSecretName(tc.GetName(), component)
+	secret, err := r.secretLister.Secrets(ns).Get(secretName)
+	if errors.IsNotFound(err) {
+		// the members are not created until the secret is issued
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get the tls secret %s/%s of %s: %v", ns, secretName, memberType, err)
+	}
+	hash, err := tlsCertHash(secret)
+	if err != nil {
+		return err
+	}
+	if *certHash == hash {
+		return nil
+	}
+	// the members started with the certificate observed first
+	if *certHash == "" {
+		*certHash = hash
+		return nil
+	}
+
+	*certHash = hash
+	if tlsCertReloadSupported(version) {
+		klog.Infof("tikv cluster %s/%s: the certificate of %s in secret %s rotated, reloaded online", ns, tc.GetName(), memberType, secretName)
+		r.recorder.Event(tc, corev1.EventTypeNormal, "TLSCertReloaded", fmt.Sprintf("the certificate of %s in secret %s rotated, %s %s reloads it online", memberType, secretName, memberType, version))
+		return nil
+	}
+	*rolloutHash = hash
+	klog.Infof("tikv cluster %s/%s: the certificate of %s in secret %s rotated, rolling the pods to load it", ns, tc.GetName(), memberType, secretName)
+	r.recorder.Event(tc, corev1.EventTypeNormal, "TLSCertRollout", fmt.Sprintf("the certificate of %s in secret %s rotated, %s %s is restarted pod by pod to load it", memberType, secretName, memberType, version))
+	return nil
+}
+
+// tlsCertHash returns the hash of the certificates and the key in the tls secret
+func tlsCertHash(secret *corev1.Secret) (string, error) {
+	return Sha256Sum([][]byte{
+		secret.Data[corev1.TLSCertKey],
+		secret.Data[corev1.TLSPrivateKeyKey],
+		secret.Data[corev1.ServiceAccountRootCAKey],
+	})
+}
+
+// tlsCertReloadSupported returns whether the members of the version reload the certificates
+// rotated in their files online, i.e. v4.0.0 and later. The versions which are not semantic
+// versions, e.g. nightly, are built from the latest code and reload them too.
+func tlsCertReloadSupported(version string) bool {
+	supported, _ := clusterVersionGreaterThanOrEqualTo4(version)
+	return supported
+}
+
+type FakeTLSCertReloader struct {
+	err error
+}
+
+func NewFakeTLSCertReloader() *FakeTLSCertReloader {
+	return &FakeTLSCertReloader{}
+}
+
+func (r *FakeTLSCertReloader) SetSyncError(err error) {
+	r.err = err
+}
+
+func (r *FakeTLSCertReloader) Sync(_ *v1alpha1.TikvCluster) error {
+	return r.err
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestTLSCertReloaderSync(t *testing.T) {
+	g := NewGomegaWithT(t)
+	type testcase struct {
+		name     string
+		version  string
+		expectFn func(*v1alpha1.TikvCluster, *record.FakeRecorder)
+	}
+	testFn := func(tt *testcase) {
+		t.Log(tt.name)
+
+		tc := newTikvClusterForPDDiscovery()
+		tc.Spec.TiKV.Image = "pingcap/tikv:" + tt.version
+		tc.Spec.TLSCluster = &v1alpha1.TLSCluster{Enabled: true}
+		secretInformer := kubeinformers.NewSharedInformerFactory(kubefake.NewSimpleClientset(), 0).Core().V1().Secrets()
+		recorder := record.NewFakeRecorder(10)
+		reloader := NewTLSCertReloader(secretInformer.Lister(), recorder)
+
+		// the secrets are not issued yet
+		g.Expect(reloader.Sync(tc)).To(Succeed())
+		g.Expect(tc.Status.PD.TLSCertHash).To(BeEmpty())
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: tc.Namespace, Name: "test-tikv-cluster-secret"},
+			Data:       map[string][]byte{corev1.TLSCertKey: []byte("cert"), corev1.TLSPrivateKeyKey: []byte("key")},
+		}
+		g.Expect(secretInformer.Informer().GetIndexer().Add(secret)).To(Succeed())
+		g.Expect(reloader.Sync(tc)).To(Succeed())
+		g.Expect(tc.Status.PD.TLSCertHash).To(BeEmpty())
+		g.Expect(tc.Status.TiKV.TLSCertHash).NotTo(BeEmpty())
+		g.Expect(tc.Status.TiKV.TLSCertRolloutHash).To(BeEmpty())
+		g.Expect(recorder.Events).To(BeEmpty())
+
+		// the certificate rotated
+		secret = secret.DeepCopy()
+		secret.Data[corev1.TLSCertKey] = []byte("rotated")
+		g.Expect(secretInformer.Informer().GetIndexer().Update(secret)).To(Succeed())
+		observed := tc.Status.TiKV.TLSCertHash
+		g.Expect(reloader.Sync(tc)).To(Succeed())
+		g.Expect(tc.Status.TiKV.TLSCertHash).NotTo(Equal(observed))
+		tt.expectFn(tc, recorder)
+	}
+
+	tests := []*testcase{
+		{
+			name:    "reloaded online",
+			version: "v4.0.0",
+			expectFn: func(tc *v1alpha1.TikvCluster, recorder *record.FakeRecorder) {
+				g.Expect(tc.Status.TiKV.TLSCertRolloutHash).To(BeEmpty())
+				g.Expect(recorder.Events).To(HaveLen(1))
+				g.Expect(<-recorder.Events).To(ContainSubstring("TLSCertReloaded"))
+			},
+		},
+		{
+			name:    "reloaded online by nightly",
+			version: "nightly",
+			expectFn: func(tc *v1alpha1.TikvCluster, recorder *record.FakeRecorder) {
+				g.Expect(tc.Status.TiKV.TLSCertRolloutHash).To(BeEmpty())
+				g.Expect(<-recorder.Events).To(ContainSubstring("TLSCertReloaded"))
+			},
+		},
+		{
+			name:    "rolling restart",
+			version: "v3.0.8",
+			expectFn: func(tc *v1alpha1.TikvCluster, recorder *record.FakeRecorder) {
+				g.Expect(tc.Status.TiKV.TLSCertRolloutHash).To(Equal(tc.Status.TiKV.TLSCertHash))
+				g.Expect(tc.Status.PD.TLSCertRolloutHash).To(BeEmpty())
+				g.Expect(recorder.Events).To(HaveLen(1))
+				g.Expect(<-recorder.Events).To(ContainSubstring("TLSCertRollout"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		testFn(tt)
+	}
+}
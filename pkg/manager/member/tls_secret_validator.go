@@ -0,0 +1,274 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/label"
+	"github.com/tikv/tikv-operator/pkg/util"
+	utiltikvcluster "github.com/tikv/tikv-operator/pkg/util/tikvcluster"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+// TLSSecretValidator checks the cluster TLS secrets of pd and tikv before the members mounting
+// them are rolled out, so that a malformed or expired certificate does not crashloop the pods
+type TLSSecretValidator interface {
+	// Validate parses ca.crt, tls.crt and tls.key of the secrets and verifies that the key matches
+	// the certificate, the certificate chains to the CA, is valid now and covers the DNS names
+	// the members advertise. A problem is reported by the TLSSecretInvalid condition and event,
+	// and a RequeueError is returned to hold the rollout.
+	Validate(tc *v1alpha1.TikvCluster) error
+}
+
+type realTLSSecretValidator struct {
+	secretLister corelisters.SecretLister
+	recorder     record.EventRecorder
+}
+
+// NewTLSSecretValidator returns a TLSSecretValidator
+func NewTLSSecretValidator(secretLister corelisters.SecretLister, recorder record.EventRecorder) TLSSecretValidator {
+	return &realTLSSecretValidator{secretLister, recorder}
+}
+
+func (v *realTLSSecretValidator) Validate(tc *v1alpha1.TikvCluster) error {
+	if !tc.IsTLSClusterEnabled() {
+		setTLSSecretInvalidCondition(tc, "", "")
+		return nil
+	}
+	ns := tc.GetNamespace()
+	tcName := tc.GetName()
+	now := time.Now()
+
+	for _, memberType := range []v1alpha1.MemberType{v1alpha1.PDMemberType, v1alpha1.TiKVMemberType} {
+		component := label.PDLabelVal
+		if memberType == v1alpha1.TiKVMemberType {
+			component = label.TiKVLabelVal
+		}
+		secretName := util.ClusterTLSSecretName(tcName, component)
+		var reason, msg string
+		secret, err := v.secretLister.Secrets(ns).Get(secretName)
+		if errors.IsNotFound(err) {
+			reason, msg = utiltikvcluster.TLSSecretNotFound, "the secret does not exist"
+		} else if err != nil {
+			return fmt.Errorf("failed to get the tls secret %s/%s of %s: %v", ns, secretName, memberType, err)
+		} else {
+			reason, msg = validateTLSSecret(secret, tlsDNSNames(tc, memberType), now)
+		}
+		if reason == "" {
+			continue
+		}
+
+		msg = fmt.Sprintf("tls secret %s/%s of %s: %s", ns, secretName, memberType, msg)
+		if cond := utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.TLSSecretInvalid); cond == nil || cond.Status != corev1.ConditionTrue || cond.Message != msg {
+			klog.Warningf("tikv cluster %s/%s: %s", ns, tcName, msg)
+			v.recorder.Event(tc, corev1.EventTypeWarning, string(v1alpha1.TLSSecretInvalid), msg)
+		}
+		setTLSSecretInvalidCondition(tc, reason, msg)
+		return controller.RequeueErrorf("tikvcluster: [%s/%s] is not rolled out, %s", ns, tcName, msg)
+	}
+	setTLSSecretInvalidCondition(tc, "", "")
+	return nil
+}
+
+// tlsDNSNames returns the DNS names the certificate of the member type has to cover, i.e. the
+// FQDNs the pods advertise under the peer service and the pd service the clients connect to
+func tlsDNSNames(tc *v1alpha1.TikvCluster, memberType v1alpha1.MemberType) []string {
+	ns := tc.GetNamespace()
+	tcName := tc.GetName()
+	ordinals := tc.TiKVStsDesiredOrdinals(false)
+	var names []string
+	if memberType == v1alpha1.PDMemberType {
+		ordinals = tc.PDStsDesiredOrdinals(false)
+		names = append(names, fmt.Sprintf("%s.%s", controller.PDMemberName(tcName), ns))
+	}
+	peerSvcName := controller.PeerMemberName(tcName, memberType)
+	for _, ordinal := range ordinals.List() {
+		names = append(names, fmt.Sprintf("%s.%s.%s.svc", ordinalPodName(memberType, tcName, ordinal), peerSvcName, ns))
+	}
+	return names
+}
+
+// validateTLSSecret returns the reason and the message of the first problem found in the tls
+// secret, the reason is empty when the secret is valid
+func validateTLSSecret(secret *corev1.Secret, dnsNames []string, now time.Time) (string, string) {
+	caCerts, err := parseCertificates(secret.Data[corev1.ServiceAccountRootCAKey])
+	if err != nil {
+		return utiltikvcluster.TLSSecretMalformed, fmt.Sprintf("%s: %v", corev1.ServiceAccountRootCAKey, err)
+	}
+	certs, err := parseCertificates(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		return utiltikvcluster.TLSSecretMalformed, fmt.Sprintf("%s: %v", corev1.TLSCertKey, err)
+	}
+	if err := parsePrivateKey(secret.Data[corev1.TLSPrivateKeyKey]); err != nil {
+		return utiltikvcluster.TLSSecretMalformed, fmt.Sprintf("%s: %v", corev1.TLSPrivateKeyKey, err)
+	}
+	// the key is parsed, the key pair is only refused when they do not match
+	if _, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]); err != nil {
+		return utiltikvcluster.TLSKeyMismatch, fmt.Sprintf("%s does not match the certificate in %s: %v", corev1.TLSPrivateKeyKey, corev1.TLSCertKey, err)
+	}
+
+	cert := certs[0]
+	if now.Before(cert.NotBefore) {
+		return utiltikvcluster.TLSCertNotValidYet, fmt.Sprintf("the certificate %q is not valid before %s", cert.Subject.CommonName, cert.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if now.After(cert.NotAfter) {
+		return utiltikvcluster.TLSCertExpired, fmt.Sprintf("the certificate %q expired at %s", cert.Subject.CommonName, cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	roots := x509.NewCertPool()
+	for _, caCert := range caCerts {
+		roots.AddCert(caCert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	if _, err := cert.Verify(opts); err != nil {
+		return utiltikvcluster.TLSCertUntrusted, fmt.Sprintf("the certificate %q does not chain to the CA in %s: %v", cert.Subject.CommonName, corev1.ServiceAccountRootCAKey, err)
+	}
+
+	var uncovered []string
+	for _, name := range dnsNames {
+		if err := cert.VerifyHostname(name); err != nil {
+			uncovered = append(uncovered, name)
+		}
+	}
+	if len(uncovered) > 0 {
+		return utiltikvcluster.TLSCertNameMismatch, fmt.Sprintf("the certificate %q does not cover the DNS names %s", cert.Subject.CommonName, strings.Join(uncovered, ", "))
+	}
+	return "", ""
+}
+
+// parseCertificates parses the PEM encoded certificates, the first one is the leaf of a chain
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("missing")
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM encoded certificate found")
+	}
+	return certs, nil
+}
+
+// parsePrivateKey parses the PEM encoded private key in the formats crypto/tls accepts
+func parsePrivateKey(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("missing")
+	}
+	var block *pem.Block
+	for {
+		block, data = pem.Decode(data)
+		if block == nil {
+			return fmt.Errorf("no PEM encoded private key found")
+		}
+		if block.Type == "PRIVATE KEY" || strings.HasSuffix(block.Type, " PRIVATE KEY") {
+			break
+		}
+	}
+	if _, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return nil
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		switch key.(type) {
+		case *rsa.PrivateKey, *ecdsa.PrivateKey:
+			return nil
+		}
+	}
+	if _, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return nil
+	}
+	return fmt.Errorf("failed to parse the private key")
+}
+
+// setTLSSecretInvalidCondition sets the TLSSecretInvalid condition when reason is not empty,
+// otherwise it resolves the condition if it has been added.
+func setTLSSecretInvalidCondition(tc *v1alpha1.TikvCluster, reason, message string) {
+	if reason != "" {
+		cond := utiltikvcluster.NewTikvClusterCondition(v1alpha1.TLSSecretInvalid, corev1.ConditionTrue, reason, message)
+		if current := utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.TLSSecretInvalid); current != nil && current.Status == corev1.ConditionTrue && current.Message != message {
+			// another problem of the same kind, e.g. in the other secret
+			cond.LastTransitionTime = current.LastTransitionTime
+			tc.Status.Conditions = filterOutTLSSecretInvalidCondition(tc.Status.Conditions)
+		}
+		utiltikvcluster.SetTikvClusterCondition(&tc.Status, *cond)
+		return
+	}
+	if cond := utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.TLSSecretInvalid); cond == nil || cond.Status == corev1.ConditionFalse {
+		return
+	}
+	cond := utiltikvcluster.NewTikvClusterCondition(v1alpha1.TLSSecretInvalid, corev1.ConditionFalse, utiltikvcluster.TLSSecretValid, "")
+	utiltikvcluster.SetTikvClusterCondition(&tc.Status, *cond)
+}
+
+func filterOutTLSSecretInvalidCondition(conditions []v1alpha1.TikvClusterCondition) []v1alpha1.TikvClusterCondition {
+	var newConditions []v1alpha1.TikvClusterCondition
+	for _, c := range conditions {
+		if c.Type != v1alpha1.TLSSecretInvalid {
+			newConditions = append(newConditions, c)
+		}
+	}
+	return newConditions
+}
+
+type FakeTLSSecretValidator struct {
+	err error
+}
+
+func NewFakeTLSSecretValidator() *FakeTLSSecretValidator {
+	return &FakeTLSSecretValidator{}
+}
+
+func (v *FakeTLSSecretValidator) SetValidateError(err error) {
+	v.err = err
+}
+
+func (v *FakeTLSSecretValidator) Validate(_ *v1alpha1.TikvCluster) error {
+	return v.err
+}
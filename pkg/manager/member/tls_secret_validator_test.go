@@ -0,0 +1,214 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	utiltikvcluster "github.com/tikv/tikv-operator/pkg/util/tikvcluster"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(g *GomegaWithT, parent *testCert, dnsNames []string, notBefore, notAfter time.Time) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "test"},
+		DNSNames:     dnsNames,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.Subject.CommonName = "ca"
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	g.Expect(err).NotTo(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	g.Expect(err).NotTo(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	g.Expect(err).NotTo(HaveOccurred())
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func newTestTLSSecret(name string, ca, cert *testCert) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: corev1.NamespaceDefault, Name: name},
+		Data: map[string][]byte{
+			corev1.ServiceAccountRootCAKey: ca.certPEM,
+			corev1.TLSCertKey:              cert.certPEM,
+			corev1.TLSPrivateKeyKey:        cert.keyPEM,
+		},
+	}
+}
+
+func TestValidateTLSSecret(t *testing.T) {
+	g := NewGomegaWithT(t)
+	now := time.Now()
+	dnsNames := []string{"test-pd.default", "test-pd-0.test-pd-peer.default.svc"}
+	sans := []string{"test-pd.default", "*.test-pd-peer.default.svc"}
+	ca := newTestCert(g, nil, nil, now.Add(-time.Hour), now.Add(time.Hour))
+
+	type testcase struct {
+		name   string
+		secret func() *corev1.Secret
+		reason string
+	}
+	tests := []testcase{
+		{
+			name: "valid",
+			secret: func() *corev1.Secret {
+				return newTestTLSSecret("s", ca, newTestCert(g, ca, sans, now.Add(-time.Hour), now.Add(time.Hour)))
+			},
+		},
+		{
+			name: "ca missing",
+			secret: func() *corev1.Secret {
+				s := newTestTLSSecret("s", ca, newTestCert(g, ca, sans, now.Add(-time.Hour), now.Add(time.Hour)))
+				delete(s.Data, corev1.ServiceAccountRootCAKey)
+				return s
+			},
+			reason: utiltikvcluster.TLSSecretMalformed,
+		},
+		{
+			name: "certificate malformed",
+			secret: func() *corev1.Secret {
+				s := newTestTLSSecret("s", ca, newTestCert(g, ca, sans, now.Add(-time.Hour), now.Add(time.Hour)))
+				s.Data[corev1.TLSCertKey] = []byte("not a certificate")
+				return s
+			},
+			reason: utiltikvcluster.TLSSecretMalformed,
+		},
+		{
+			name: "key of another certificate",
+			secret: func() *corev1.Secret {
+				s := newTestTLSSecret("s", ca, newTestCert(g, ca, sans, now.Add(-time.Hour), now.Add(time.Hour)))
+				s.Data[corev1.TLSPrivateKeyKey] = newTestCert(g, ca, sans, now.Add(-time.Hour), now.Add(time.Hour)).keyPEM
+				return s
+			},
+			reason: utiltikvcluster.TLSKeyMismatch,
+		},
+		{
+			name: "signed by another ca",
+			secret: func() *corev1.Secret {
+				other := newTestCert(g, nil, nil, now.Add(-time.Hour), now.Add(time.Hour))
+				return newTestTLSSecret("s", ca, newTestCert(g, other, sans, now.Add(-time.Hour), now.Add(time.Hour)))
+			},
+			reason: utiltikvcluster.TLSCertUntrusted,
+		},
+		{
+			name: "expired",
+			secret: func() *corev1.Secret {
+				return newTestTLSSecret("s", ca, newTestCert(g, ca, sans, now.Add(-2*time.Hour), now.Add(-time.Hour)))
+			},
+			reason: utiltikvcluster.TLSCertExpired,
+		},
+		{
+			name: "not valid yet",
+			secret: func() *corev1.Secret {
+				return newTestTLSSecret("s", ca, newTestCert(g, ca, sans, now.Add(time.Hour), now.Add(2*time.Hour)))
+			},
+			reason: utiltikvcluster.TLSCertNotValidYet,
+		},
+		{
+			name: "peer names not covered",
+			secret: func() *corev1.Secret {
+				return newTestTLSSecret("s", ca, newTestCert(g, ca, []string{"test-pd.default"}, now.Add(-time.Hour), now.Add(time.Hour)))
+			},
+			reason: utiltikvcluster.TLSCertNameMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Log(tt.name)
+		reason, msg := validateTLSSecret(tt.secret(), dnsNames, now)
+		g.Expect(reason).To(Equal(tt.reason), msg)
+	}
+}
+
+func TestTLSSecretValidatorValidate(t *testing.T) {
+	g := NewGomegaWithT(t)
+	now := time.Now()
+
+	tc := newTikvClusterForPDDiscovery()
+	tc.Spec.PD.Replicas = 1
+	tc.Spec.TiKV.Replicas = 1
+	tc.Spec.TLSCluster = &v1alpha1.TLSCluster{Enabled: true}
+	secretInformer := kubeinformers.NewSharedInformerFactory(kubefake.NewSimpleClientset(), 0).Core().V1().Secrets()
+	recorder := record.NewFakeRecorder(10)
+	validator := NewTLSSecretValidator(secretInformer.Lister(), recorder)
+
+	ca := newTestCert(g, nil, nil, now.Add(-time.Hour), now.Add(time.Hour))
+	pdCert := newTestCert(g, ca, []string{"test-pd.default", "*.test-pd-peer.default.svc"}, now.Add(-time.Hour), now.Add(time.Hour))
+	g.Expect(secretInformer.Informer().GetIndexer().Add(newTestTLSSecret("test-pd-cluster-secret", ca, pdCert))).To(Succeed())
+	tikvSecret := newTestTLSSecret("test-tikv-cluster-secret", ca, newTestCert(g, ca, []string{"*.test-tikv-peer.default.svc"}, now.Add(-2*time.Hour), now.Add(-time.Hour)))
+	g.Expect(secretInformer.Informer().GetIndexer().Add(tikvSecret)).To(Succeed())
+
+	// the expired certificate of tikv holds the rollout
+	err := validator.Validate(tc)
+	g.Expect(controller.IsRequeueError(err)).To(BeTrue())
+	cond := utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.TLSSecretInvalid)
+	g.Expect(cond).NotTo(BeNil())
+	g.Expect(cond.Status).To(Equal(corev1.ConditionTrue))
+	g.Expect(cond.Reason).To(Equal(utiltikvcluster.TLSCertExpired))
+	g.Expect(cond.Message).To(ContainSubstring("test-tikv-cluster-secret"))
+	g.Expect(<-recorder.Events).To(ContainSubstring("TLSSecretInvalid"))
+
+	// the event is not repeated for the same problem
+	g.Expect(controller.IsRequeueError(validator.Validate(tc))).To(BeTrue())
+	g.Expect(recorder.Events).To(BeEmpty())
+
+	// the certificate renewed
+	tikvSecret = newTestTLSSecret("test-tikv-cluster-secret", ca, newTestCert(g, ca, []string{"*.test-tikv-peer.default.svc"}, now.Add(-time.Hour), now.Add(time.Hour)))
+	g.Expect(secretInformer.Informer().GetIndexer().Update(tikvSecret)).To(Succeed())
+	g.Expect(validator.Validate(tc)).To(Succeed())
+	cond = utiltikvcluster.GetTikvClusterCondition(tc.Status, v1alpha1.TLSSecretInvalid)
+	g.Expect(cond.Status).To(Equal(corev1.ConditionFalse))
+	g.Expect(cond.Reason).To(Equal(utiltikvcluster.TLSSecretValid))
+}
@@ -0,0 +1,182 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+const (
+	upgradeStageImagePull      = "image_pull"
+	upgradeStageStartup        = "startup"
+	upgradeStageLeaderEviction = "leader_eviction"
+)
+
+var upgradeStageDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: "tikv_operator",
+		Subsystem: "upgrade",
+		Name:      "stage_duration_seconds",
+		Help:      "Duration of the stages of upgrading a pod, by component and stage (image_pull, startup or leader_eviction).",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 13),
+	}, []string{"component", "stage"})
+
+func init() {
+	prometheus.MustRegister(upgradeStageDuration)
+}
+
+// getComponentUpgradeStatus returns the upgrade status of the component, which is reset when
+// the component is upgraded to another revision
+func getComponentUpgradeStatus(tc *v1alpha1.TikvCluster, memberType v1alpha1.MemberType, revision string) *v1alpha1.ComponentUpgradeStatus {
+	if tc.Status.UpgradeStatus == nil {
+		tc.Status.UpgradeStatus = &v1alpha1.UpgradeStatus{}
+	}
+	status := &tc.Status.UpgradeStatus.PD
+	if memberType == v1alpha1.TiKVMemberType {
+		status = &tc.Status.UpgradeStatus.TiKV
+	}
+	if *status == nil || (*status).Revision != revision {
+		*status = &v1alpha1.ComponentUpgradeStatus{
+			Revision: revision,
+			Pods:     map[string]v1alpha1.PodUpgradeDurations{},
+		}
+	}
+	if (*status).Pods == nil {
+		(*status).Pods = map[string]v1alpha1.PodUpgradeDurations{}
+	}
+	return *status
+}
+
+// recordPodStartup records how long the upgraded pod took to pull the image and to start up,
+// readyTime is when the pd member became healthy or the tikv store became Up. It is recorded
+// once per pod and revision.
+func recordPodStartup(tc *v1alpha1.TikvCluster, memberType v1alpha1.MemberType, revision string, pod *corev1.Pod, readyTime time.Time) {
+	status := getComponentUpgradeStatus(tc, memberType, revision)
+	durations := status.Pods[pod.GetName()]
+	if durations.Startup != nil {
+		return
+	}
+	startedAt := containerStartedAt(pod, memberType.String())
+	if startedAt.IsZero() {
+		return
+	}
+
+	if scheduledAt := podScheduledAt(pod); !scheduledAt.IsZero() && !startedAt.Before(scheduledAt) {
+		d := startedAt.Sub(scheduledAt)
+		durations.ImagePull = &metav1.Duration{Duration: d}
+		upgradeStageDuration.WithLabelValues(memberType.String(), upgradeStageImagePull).Observe(d.Seconds())
+	}
+	// the transition time may be of an earlier state change if pd or the store came back
+	// before the pod was observed
+	if readyTime.IsZero() || readyTime.Before(startedAt) {
+		readyTime = time.Now()
+	}
+	d := readyTime.Sub(startedAt)
+	durations.Startup = &metav1.Duration{Duration: d}
+	upgradeStageDuration.WithLabelValues(memberType.String(), upgradeStageStartup).Observe(d.Seconds())
+
+	status.Pods[pod.GetName()] = durations
+	summarizeUpgradeDurations(status)
+}
+
+// recordLeaderEviction records how long the leaders of the tikv store took to be evicted
+// before the pod is upgraded
+func recordLeaderEviction(tc *v1alpha1.TikvCluster, revision string, pod *corev1.Pod) {
+	beginTimeStr, evicting := pod.Annotations[EvictLeaderBeginTime]
+	if !evicting {
+		return
+	}
+	beginTime, err := time.Parse(time.RFC3339, beginTimeStr)
+	if err != nil {
+		klog.Errorf("parse annotation:[%s] to time failed.", EvictLeaderBeginTime)
+		return
+	}
+	status := getComponentUpgradeStatus(tc, v1alpha1.TiKVMemberType, revision)
+	durations := status.Pods[pod.GetName()]
+	if durations.LeaderEviction != nil {
+		return
+	}
+	d := time.Since(beginTime)
+	durations.LeaderEviction = &metav1.Duration{Duration: d}
+	upgradeStageDuration.WithLabelValues(v1alpha1.TiKVMemberType.String(), upgradeStageLeaderEviction).Observe(d.Seconds())
+
+	status.Pods[pod.GetName()] = durations
+	summarizeUpgradeDurations(status)
+}
+
+// summarizeUpgradeDurations computes the percentiles of the durations recorded for the pods
+func summarizeUpgradeDurations(status *v1alpha1.ComponentUpgradeStatus) {
+	var imagePull, startup, leaderEviction []time.Duration
+	for _, durations := range status.Pods {
+		if durations.ImagePull != nil {
+			imagePull = append(imagePull, durations.ImagePull.Duration)
+		}
+		if durations.Startup != nil {
+			startup = append(startup, durations.Startup.Duration)
+		}
+		if durations.LeaderEviction != nil {
+			leaderEviction = append(leaderEviction, durations.LeaderEviction.Duration)
+		}
+	}
+	status.ImagePull = newUpgradeDurationSummary(imagePull)
+	status.Startup = newUpgradeDurationSummary(startup)
+	status.LeaderEviction = newUpgradeDurationSummary(leaderEviction)
+}
+
+func newUpgradeDurationSummary(durations []time.Duration) *v1alpha1.UpgradeDurationSummary {
+	if len(durations) == 0 {
+		return nil
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return &v1alpha1.UpgradeDurationSummary{
+		P50: metav1.Duration{Duration: percentile(durations, 50)},
+		P95: metav1.Duration{Duration: percentile(durations, 95)},
+	}
+}
+
+// percentile returns the nearest-rank percentile of the sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// containerStartedAt returns when the running container of the pod was started
+func containerStartedAt(pod *corev1.Pod, containerName string) time.Time {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == containerName && status.State.Running != nil {
+			return status.State.Running.StartedAt.Time
+		}
+	}
+	return time.Time{}
+}
+
+// podScheduledAt returns when the pod was scheduled to a node
+func podScheduledAt(pod *corev1.Pod) time.Time {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionTrue {
+			return cond.LastTransitionTime.Time
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package member
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRecordPodStartup(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	now := time.Now()
+	newPod := func(name string, scheduledAt, startedAt time.Time) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: corev1.PodStatus{
+				Conditions: []corev1.PodCondition{
+					{Type: corev1.PodScheduled, Status: corev1.ConditionTrue, LastTransitionTime: metav1.NewTime(scheduledAt)},
+				},
+				ContainerStatuses: []corev1.ContainerStatus{
+					{
+						Name:  v1alpha1.TiKVMemberType.String(),
+						State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: metav1.NewTime(startedAt)}},
+					},
+				},
+			},
+		}
+	}
+
+	tc := newTikvClusterForTiKVUpgrader()
+	for i, pull := range []time.Duration{10 * time.Second, 20 * time.Second, 90 * time.Second} {
+		startedAt := now.Add(-time.Hour)
+		pod := newPod(TikvPodName(upgradeTcName, int32(i)), startedAt.Add(-pull), startedAt)
+		recordPodStartup(tc, v1alpha1.TiKVMemberType, "rev-2", pod, startedAt.Add(time.Duration(i+1)*time.Minute))
+	}
+	status := tc.Status.UpgradeStatus.TiKV
+	g.Expect(status.Revision).To(Equal("rev-2"))
+	g.Expect(status.Pods).To(HaveLen(3))
+	g.Expect(status.ImagePull.P50.Duration).To(Equal(20 * time.Second))
+	g.Expect(status.ImagePull.P95.Duration).To(Equal(90 * time.Second))
+	g.Expect(status.Startup.P50.Duration).To(Equal(2 * time.Minute))
+	g.Expect(status.Startup.P95.Duration).To(Equal(3 * time.Minute))
+	g.Expect(status.LeaderEviction).To(BeNil())
+
+	// a pod is recorded once per revision
+	pod := newPod(TikvPodName(upgradeTcName, 0), now.Add(-time.Minute), now)
+	recordPodStartup(tc, v1alpha1.TiKVMemberType, "rev-2", pod, now)
+	g.Expect(tc.Status.UpgradeStatus.TiKV.Pods[pod.GetName()].ImagePull.Duration).To(Equal(10 * time.Second))
+
+	// the durations of the previous upgrade are dropped when upgrading to another revision
+	recordPodStartup(tc, v1alpha1.TiKVMemberType, "rev-3", pod, now.Add(time.Minute))
+	status = tc.Status.UpgradeStatus.TiKV
+	g.Expect(status.Revision).To(Equal("rev-3"))
+	g.Expect(status.Pods).To(HaveLen(1))
+	g.Expect(status.ImagePull.P95.Duration).To(Equal(time.Minute))
+	g.Expect(status.Startup.P95.Duration).To(Equal(time.Minute))
+	g.Expect(tc.Status.UpgradeStatus.PD).To(BeNil())
+}
+
+func TestRecordLeaderEviction(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForTiKVUpgrader()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: TikvPodName(upgradeTcName, 1),
+		},
+	}
+	recordLeaderEviction(tc, "rev-2", pod)
+	g.Expect(tc.Status.UpgradeStatus).To(BeNil())
+
+	pod.Annotations = map[string]string{EvictLeaderBeginTime: time.Now().Add(-time.Minute).Format(time.RFC3339)}
+	recordLeaderEviction(tc, "rev-2", pod)
+	status := tc.Status.UpgradeStatus.TiKV
+	g.Expect(status.Pods[pod.GetName()].LeaderEviction.Duration).To(BeNumerically(">=", time.Minute))
+	g.Expect(status.LeaderEviction.P50).To(Equal(status.LeaderEviction.P95))
+	g.Expect(status.Startup).To(BeNil())
+}
+
+func TestPercentile(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	type testcase struct {
+		name      string
+		durations []time.Duration
+		p         int
+		expect    time.Duration
+	}
+	tests := []testcase{
+		{name: "one duration", durations: []time.Duration{5}, p: 95, expect: 5},
+		{name: "p50 of four", durations: []time.Duration{1, 2, 3, 4}, p: 50, expect: 2},
+		{name: "p95 of four", durations: []time.Duration{1, 2, 3, 4}, p: 95, expect: 4},
+		{name: "p50 of five", durations: []time.Duration{1, 2, 3, 4, 5}, p: 50, expect: 3},
+	}
+	for _, test := range tests {
+		t.Log(test.name)
+		g.Expect(percentile(test.durations, test.p)).To(Equal(test.expect))
+	}
+}
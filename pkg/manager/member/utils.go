@@ -18,6 +18,7 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 	"github.com/pingcap/advanced-statefulset/client/apis/apps/v1/helper"
@@ -29,9 +30,20 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/klog"
 )
 
+// checkClusterDeleting returns an IgnoreError if the tikv cluster is being deleted, the
+// members are garbage collected and waiting for them to become healthy again never ends
+func checkClusterDeleting(tc *v1alpha1.TikvCluster, operation string) error {
+	if !tc.IsDeleting() {
+		return nil
+	}
+	return controller.IgnoreErrorf("tidbcluster: [%s/%s] is being deleted, skip %s", tc.GetNamespace(), tc.GetName(), operation)
+}
+
 const (
 	// LastAppliedConfigAnnotation is annotation key of last applied configuration
 	LastAppliedConfigAnnotation = "tikv.org/last-applied-configuration"
@@ -39,8 +51,34 @@ const (
 	ImagePullBackOff = "ImagePullBackOff"
 	// ErrImagePull is the pod state of image pull failed
 	ErrImagePull = "ErrImagePull"
+	// CrashLoopBackOff is the pod state of container restarting repeatedly
+	CrashLoopBackOff = "CrashLoopBackOff"
+)
+
+// The states of a TiKV store recorded in the store state annotation of its pod
+const (
+	StoreStateUp        = v1alpha1.TiKVStateUp
+	StoreStateDown      = v1alpha1.TiKVStateDown
+	StoreStateOffline   = v1alpha1.TiKVStateOffline
+	StoreStateTombstone = v1alpha1.TiKVStateTombstone
 )
 
+// GetStoreState returns the state of the store of the tikv pod recorded in its annotation, ok is
+// false if it is not recorded. Compare it with the StoreState constants instead of literals.
+func GetStoreState(pod *corev1.Pod) (state string, ok bool) {
+	state, ok = pod.Annotations[label.AnnStoreState]
+	return state, ok
+}
+
+// SetStoreState records the state of the store of the tikv pod in its annotation, the pod has to
+// be updated by the caller
+func SetStoreState(pod *corev1.Pod, state string) {
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[label.AnnStoreState] = state
+}
+
 func annotationsMountVolume() (corev1.VolumeMount, corev1.Volume) {
 	m := corev1.VolumeMount{Name: "annotations", ReadOnly: true, MountPath: "/etc/podinfo"}
 	v := corev1.Volume{
@@ -59,6 +97,215 @@ func annotationsMountVolume() (corev1.VolumeMount, corev1.Volume) {
 	return m, v
 }
 
+// tlsClusterReadinessProbe returns the readiness probe of the members listening on port with
+// the cluster TLS enabled, nil otherwise. The https endpoints require a client certificate
+// the kubelet can not present, so the probe checks the TLS listener accepts connections.
+func tlsClusterReadinessProbe(tc *v1alpha1.TikvCluster, port int32) *corev1.Probe {
+	if !tc.IsTLSClusterEnabled() {
+		return nil
+	}
+	return &corev1.Probe{
+		Handler: corev1.Handler{
+			TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(int(port))},
+		},
+		InitialDelaySeconds: 10,
+		PeriodSeconds:       10,
+	}
+}
+
+// tikvReadinessProbe returns the readiness probe of tikv, with
+// spec.tikv.scalePolicy.connectionDrainSeconds set it fails once the pod is annotated with
+// the begin time of the connection draining, read from the annotations of the downward API
+// which the kubelet refreshes within a minute or so. It replaces the probe of the cluster TLS.
+func tikvReadinessProbe(tc *v1alpha1.TikvCluster) *corev1.Probe {
+	if tikvConnectionDrainSeconds(tc) == 0 {
+		return tlsClusterReadinessProbe(tc, tc.Ports().TiKV)
+	}
+	return &corev1.Probe{
+		Handler: corev1.Handler{
+			Exec: &corev1.ExecAction{
+				Command: []string{"/bin/sh", "-c", fmt.Sprintf("! grep -q '^%s=' /etc/podinfo/annotations", label.AnnConnectionDrainBeginTime)},
+			},
+		},
+		InitialDelaySeconds: 10,
+		PeriodSeconds:       5,
+	}
+}
+
+// tikvConnectionDrainSeconds returns spec.tikv.scalePolicy.connectionDrainSeconds
+func tikvConnectionDrainSeconds(tc *v1alpha1.TikvCluster) int32 {
+	if tc.Spec.TiKV.ScalePolicy == nil {
+		return 0
+	}
+	return tc.Spec.TiKV.ScalePolicy.ConnectionDrainSeconds
+}
+
+// tlsSecretVolumeSource returns the projected volume of the cluster TLS secret, the kubelet
+// updates the files when the secret is rotated so that the members reloading the certificates
+// online pick them up without a restart
+func tlsSecretVolumeSource(secretName string) corev1.VolumeSource {
+	return corev1.VolumeSource{
+		Projected: &corev1.ProjectedVolumeSource{
+			Sources: []corev1.VolumeProjection{{
+				Secret: &corev1.SecretProjection{
+					LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+				},
+			}},
+		},
+	}
+}
+
+// dataDirInitContainer returns the init container chowning the data directory to the user and
+// the group the main container runs as when it runs as non-root or with an fsGroup, as the
+// volumes of some CSI drivers do not honor fsGroup and the data written as root can not be read
+// after switching an existing cluster to non-root. The security context of the main container
+// overrides the one of the pod, like it does for the kubelet. It returns nil if neither the user
+// nor the group is known.
+func dataDirInitContainer(psc *corev1.PodSecurityContext, sc *corev1.SecurityContext, volumeName, dataDir string) *corev1.Container {
+	if controller.DataDirInitImage == "" {
+		return nil
+	}
+	if psc == nil {
+		psc = &corev1.PodSecurityContext{}
+	}
+	if sc == nil {
+		sc = &corev1.SecurityContext{}
+	}
+	runAsNonRoot, uid, gid := psc.RunAsNonRoot, psc.RunAsUser, psc.RunAsGroup
+	if sc.RunAsNonRoot != nil {
+		runAsNonRoot = sc.RunAsNonRoot
+	}
+	if sc.RunAsUser != nil {
+		uid = sc.RunAsUser
+	}
+	if sc.RunAsGroup != nil {
+		gid = sc.RunAsGroup
+	}
+	if psc.FSGroup != nil {
+		gid = psc.FSGroup
+	}
+	if (runAsNonRoot == nil || !*runAsNonRoot) && psc.FSGroup == nil {
+		return nil
+	}
+	var cmd string
+	switch {
+	case uid != nil && gid != nil:
+		cmd = fmt.Sprintf("chown -R %d:%d %s", *uid, *gid, dataDir)
+	case uid != nil:
+		cmd = fmt.Sprintf("chown -R %d %s", *uid, dataDir)
+	case gid != nil:
+		cmd = fmt.Sprintf("chgrp -R %d %s && chmod -R g+rwX %s", *gid, dataDir, dataDir)
+	default:
+		return nil
+	}
+	root := int64(0)
+	nonRoot := false
+	return &corev1.Container{
+		Name:    "init-data-dir",
+		Image:   controller.DataDirInitImage,
+		Command: []string{"sh", "-c", cmd},
+		SecurityContext: initContainerSecurityContext(&corev1.SecurityContext{
+			RunAsUser:    &root,
+			RunAsNonRoot: &nonRoot,
+		}, sc, "CHOWN", "FOWNER", "DAC_OVERRIDE"),
+		VolumeMounts: []corev1.VolumeMount{{Name: volumeName, MountPath: dataDir}},
+	}
+}
+
+// initContainerSecurityContext adds the restrictions of sc, the security context of the main
+// container, that do not get in the way of an init container to the security context of the init
+// container: the read-only root filesystem, the privilege escalation and the capabilities
+// dropped, of which the ones the init container needs are added back. The privileged init
+// containers are left alone.
+func initContainerSecurityContext(initSC, sc *corev1.SecurityContext, needed ...corev1.Capability) *corev1.SecurityContext {
+	if sc == nil || (initSC.Privileged != nil && *initSC.Privileged) {
+		return initSC
+	}
+	initSC.ReadOnlyRootFilesystem = sc.ReadOnlyRootFilesystem
+	initSC.AllowPrivilegeEscalation = sc.AllowPrivilegeEscalation
+	if sc.Capabilities != nil && len(sc.Capabilities.Drop) > 0 {
+		initSC.Capabilities = &corev1.Capabilities{
+			Drop: append([]corev1.Capability(nil), sc.Capabilities.Drop...),
+			Add:  needed,
+		}
+	}
+	return initSC
+}
+
+// containerSecurityContext returns the security context of the main container of a component, a
+// copy of sc of the component with privileged defaulted to the one of the component
+func containerSecurityContext(sc *corev1.SecurityContext, privileged *bool) *corev1.SecurityContext {
+	if sc == nil && privileged == nil {
+		return nil
+	}
+	sc = sc.DeepCopy()
+	if sc == nil {
+		sc = &corev1.SecurityContext{}
+	}
+	if sc.Privileged == nil {
+		sc.Privileged = privileged
+	}
+	return sc
+}
+
+// tmpMountVolume returns the emptyDir mounted at /tmp of the main container of a component with
+// a read-only root filesystem, where pd and tikv write their temporary files
+func tmpMountVolume() (corev1.VolumeMount, corev1.Volume) {
+	m := corev1.VolumeMount{Name: "tmp", MountPath: "/tmp"}
+	v := corev1.Volume{
+		Name:         "tmp",
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	}
+	return m, v
+}
+
+// readOnlyRootFilesystem returns whether the container runs with a read-only root filesystem
+func readOnlyRootFilesystem(sc *corev1.SecurityContext) bool {
+	return sc != nil && sc.ReadOnlyRootFilesystem != nil && *sc.ReadOnlyRootFilesystem
+}
+
+// topologySpreadConstraints returns a copy of the constraints whose empty labelSelector is filled
+// with the labels selecting the pods of the component, so that users do not have to repeat them
+func topologySpreadConstraints(constraints []corev1.TopologySpreadConstraint, l label.Label) []corev1.TopologySpreadConstraint {
+	if len(constraints) == 0 {
+		return nil
+	}
+	filled := make([]corev1.TopologySpreadConstraint, 0, len(constraints))
+	for _, c := range constraints {
+		c := *c.DeepCopy()
+		if c.LabelSelector == nil || (len(c.LabelSelector.MatchLabels) == 0 && len(c.LabelSelector.MatchExpressions) == 0) {
+			c.LabelSelector = l.LabelSelector().DeepCopy()
+		}
+		filled = append(filled, c)
+	}
+	return filled
+}
+
+// hostNetworkAntiAffinity returns a copy of the affinity with a required pod anti-affinity on
+// the hostname between the pods of the component, the pods in the host network listen on the
+// same ports of the node and two of them could not run on one node
+func hostNetworkAntiAffinity(affinity *corev1.Affinity, l label.Label) *corev1.Affinity {
+	term := corev1.PodAffinityTerm{
+		LabelSelector: l.LabelSelector().DeepCopy(),
+		TopologyKey:   corev1.LabelHostname,
+	}
+	if affinity == nil {
+		affinity = &corev1.Affinity{}
+	} else {
+		affinity = affinity.DeepCopy()
+	}
+	if affinity.PodAntiAffinity == nil {
+		affinity.PodAntiAffinity = &corev1.PodAntiAffinity{}
+	}
+	for _, t := range affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+		if apiequality.Semantic.DeepEqual(t, term) {
+			return affinity
+		}
+	}
+	affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution = append(affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution, term)
+	return affinity
+}
+
 // statefulSetIsUpgrading confirms whether the statefulSet is upgrading phase
 func statefulSetIsUpgrading(set *apps.StatefulSet) bool {
 	if set.Status.CurrentRevision != set.Status.UpdateRevision {
@@ -143,12 +390,112 @@ func templateEqual(new *apps.StatefulSet, old *apps.StatefulSet) bool {
 	return false
 }
 
+// isPromAnnotation returns true if the key is one of the additional metrics endpoints annotations
+func isPromAnnotation(key string) bool {
+	return key == label.AnnPromAdditionalEndpoints || controller.IsLegacyPromAnnotation(key)
+}
+
+// keepLegacyPromAnnotations keeps the prometheus annotations of the pod template of the old
+// statefulset if migrating the legacy ones is the only change of the pod template, as updating
+// the template would restart all the pods. The running pods are migrated in place instead,
+// and the template is migrated at the next rolling update. It returns whether they are kept.
+func keepLegacyPromAnnotations(newSet, oldSet *apps.StatefulSet) bool {
+	oldAnns := oldSet.Spec.Template.Annotations
+	hasLegacy := false
+	for key := range oldAnns {
+		if controller.IsLegacyPromAnnotation(key) {
+			hasLegacy = true
+			break
+		}
+	}
+	if !hasLegacy || !templateEqual(newSet, oldSet) {
+		return false
+	}
+
+	newAnns := withoutPromAnnotations(newSet.Spec.Template.Annotations)
+	oldOthers := withoutPromAnnotations(oldAnns)
+	delete(oldOthers, LastAppliedConfigAnnotation)
+	if !apiequality.Semantic.DeepEqual(newAnns, oldOthers) {
+		return false
+	}
+	for key, val := range oldAnns {
+		if isPromAnnotation(key) {
+			newAnns[key] = val
+		}
+	}
+	newSet.Spec.Template.Annotations = newAnns
+	return true
+}
+
+func withoutPromAnnotations(anns map[string]string) map[string]string {
+	others := map[string]string{}
+	for key, val := range anns {
+		if !isPromAnnotation(key) {
+			others[key] = val
+		}
+	}
+	return others
+}
+
+// migrateLegacyPromAnnotationsOfPods migrates the legacy prometheus annotations of the running
+// pods selected by the label in place, updating the annotations of a pod does not restart it
+func migrateLegacyPromAnnotationsOfPods(podLister corelisters.PodLister, podControl controller.PodControlInterface, tc *v1alpha1.TikvCluster, l label.Label) error {
+	selector, err := l.Selector()
+	if err != nil {
+		return err
+	}
+	pods, err := podLister.Pods(tc.GetNamespace()).List(selector)
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		anns := controller.MigrateLegacyPromAnnotations(pod.Annotations)
+		if apiequality.Semantic.DeepEqual(anns, pod.Annotations) {
+			continue
+		}
+		pod = pod.DeepCopy()
+		pod.Annotations = anns
+		if _, err := podControl.UpdatePod(tc, pod); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // setUpgradePartition set statefulSet's rolling update partition
 func setUpgradePartition(set *apps.StatefulSet, upgradeOrdinal int32) {
 	set.Spec.UpdateStrategy.RollingUpdate = &apps.RollingUpdateStatefulSetStrategy{Partition: &upgradeOrdinal}
 	klog.Infof("set %s/%s partition to %d", set.GetNamespace(), set.GetName(), upgradeOrdinal)
 }
 
+// newStatefulSetUpdateStrategy returns the update strategy of a component's statefulset, the
+// partition only applies to RollingUpdate
+func newStatefulSetUpdateStrategy(strategyType apps.StatefulSetUpdateStrategyType, partition int32) apps.StatefulSetUpdateStrategy {
+	if strategyType == apps.OnDeleteStatefulSetStrategyType {
+		return apps.StatefulSetUpdateStrategy{Type: apps.OnDeleteStatefulSetStrategyType}
+	}
+	return apps.StatefulSetUpdateStrategy{
+		Type: apps.RollingUpdateStatefulSetStrategyType,
+		RollingUpdate: &apps.RollingUpdateStatefulSetStrategy{
+			Partition: controller.Int32Ptr(partition),
+		},
+	}
+}
+
+// onDeleteStrategyApplied returns whether the OnDelete update strategy of the statefulset was
+// applied by tikv-operator from .spec.<component>.statefulSetUpdateStrategy, rather than
+// modified manually bypassing tikv-operator
+func onDeleteStrategyApplied(set *apps.StatefulSet) bool {
+	if set.Spec.UpdateStrategy.Type != apps.OnDeleteStatefulSetStrategyType {
+		return false
+	}
+	spec, _, err := GetLastAppliedConfig(set)
+	if err != nil {
+		return false
+	}
+	return spec.UpdateStrategy.Type == apps.OnDeleteStatefulSetStrategyType
+}
+
 func imagePullFailed(pod *corev1.Pod) bool {
 	for _, container := range pod.Status.ContainerStatuses {
 		if container.State.Waiting != nil && container.State.Waiting.Reason != "" &&
@@ -159,6 +506,15 @@ func imagePullFailed(pod *corev1.Pod) bool {
 	return false
 }
 
+func podCrashLooping(pod *corev1.Pod) bool {
+	for _, container := range pod.Status.ContainerStatuses {
+		if container.State.Waiting != nil && container.State.Waiting.Reason == CrashLoopBackOff {
+			return true
+		}
+	}
+	return false
+}
+
 func MemberPodName(tcName string, ordinal int32, memberType v1alpha1.MemberType) string {
 	return fmt.Sprintf("%s-%s-%d", tcName, memberType.String(), ordinal)
 }
@@ -171,15 +527,34 @@ func PdPodName(tcName string, ordinal int32) string {
 	return fmt.Sprintf("%s-%d", controller.PDMemberName(tcName), ordinal)
 }
 
-// CombineAnnotations merges two annotations maps
-func CombineAnnotations(a, b map[string]string) map[string]string {
-	if a == nil {
-		a = make(map[string]string)
+// operatorAnnotationPrefixes are the prefixes of the annotation keys managed by the operator
+var operatorAnnotationPrefixes = []string{"prometheus.io/", "tikv.org/"}
+
+// MergeAnnotations returns a new map holding the annotations of the operator and of the user,
+// neither of which is mutated. On a key set by both, the operator wins for the keys with one of
+// the reserved prometheus.io/ and tikv.org/ prefixes and the user wins for any other key. The
+// user keys with a reserved prefix the operator does not set are kept.
+func MergeAnnotations(operatorAnns, userAnns map[string]string) map[string]string {
+	merged := make(map[string]string, len(operatorAnns)+len(userAnns))
+	for k, v := range userAnns {
+		merged[k] = v
 	}
-	for k, v := range b {
-		a[k] = v
+	for k, v := range operatorAnns {
+		if _, ok := userAnns[k]; ok && !isOperatorAnnotation(k) {
+			continue
+		}
+		merged[k] = v
+	}
+	return merged
+}
+
+func isOperatorAnnotation(key string) bool {
+	for _, prefix := range operatorAnnotationPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
 	}
-	return a
+	return false
 }
 
 // NeedForceUpgrade check if force upgrade is necessary
@@ -194,6 +569,15 @@ func NeedForceUpgrade(tc *v1alpha1.TikvCluster) bool {
 	return false
 }
 
+// forceUpgradeAllowed returns true if force upgrade is requested and the cluster is unhealthy,
+// the annotation is ignored for healthy clusters so that it takes no effect if left on by accident
+func forceUpgradeAllowed(tc *v1alpha1.TikvCluster) bool {
+	if !NeedForceUpgrade(tc) {
+		return false
+	}
+	return !tc.Status.PD.Synced || !tc.PDAllMembersReady() || !tc.Status.TiKV.Synced || !tc.TiKVAllStoresReady()
+}
+
 // FindConfigMapVolume returns the configmap which's name matches the predicate in a PodSpec, empty indicates not found
 func FindConfigMapVolume(podSpec *corev1.PodSpec, pred func(string) bool) string {
 	for _, vol := range podSpec.Volumes {
@@ -242,6 +626,13 @@ func AddConfigMapDigestSuffix(cm *corev1.ConfigMap) error {
 // getStsAnnotations gets annotations for statefulset of given component.
 func getStsAnnotations(tc *v1alpha1.TikvCluster, component string) map[string]string {
 	anns := map[string]string{}
+	// the stores decommissioned by spec.tikv.offlineStores are deleted as delete slots as well
+	if component == label.TiKVLabelVal && tc.TiKVOfflineStoreOrdinals().Len() > 0 {
+		// marshaling a slice of integers never fails
+		b, _ := json.Marshal(tc.TiKVStsDeleteSlots().List())
+		anns[helper.DeleteSlotsAnn] = string(b)
+		return anns
+	}
 	tcAnns := tc.Annotations
 	if tcAnns == nil {
 		return anns
@@ -280,35 +671,42 @@ func updateStatefulSet(setCtl controller.StatefulSetControlInterface, tc *v1alph
 		oldSet.Annotations = map[string]string{}
 	}
 	if !statefulSetEqual(*newSet, *oldSet) || isOrphan {
-		set := *oldSet
-		// Retain the deprecated last applied pod template annotation for backward compatibility
-		var podConfig string
-		var hasPodConfig bool
-		if oldSet.Spec.Template.Annotations != nil {
-			podConfig, hasPodConfig = oldSet.Spec.Template.Annotations[LastAppliedConfigAnnotation]
-		}
-		set.Spec.Template = newSet.Spec.Template
-		if hasPodConfig {
-			set.Spec.Template.Annotations[LastAppliedConfigAnnotation] = podConfig
-		}
-		set.Annotations = newSet.Annotations
-		*set.Spec.Replicas = *newSet.Spec.Replicas
-		set.Spec.UpdateStrategy = newSet.Spec.UpdateStrategy
-		if isOrphan {
-			set.OwnerReferences = newSet.OwnerReferences
-			set.Labels = newSet.Labels
-		}
-		err := SetStatefulSetLastAppliedConfigAnnotation(&set)
-		if err != nil {
-			return err
-		}
-		_, err = setCtl.UpdateStatefulSet(tc, &set)
-		return err
+		return applyStatefulSet(setCtl, tc, newSet, oldSet)
 	}
 
 	return nil
 }
 
+// applyStatefulSet updates the operator-owned fields of the statefulset of a component to the
+// ones of newSet and records them as the last applied config
+func applyStatefulSet(setCtl controller.StatefulSetControlInterface, tc *v1alpha1.TikvCluster, newSet, oldSet *apps.StatefulSet) error {
+	isOrphan := metav1.GetControllerOf(oldSet) == nil
+	set := *oldSet
+	// Retain the deprecated last applied pod template annotation for backward compatibility
+	var podConfig string
+	var hasPodConfig bool
+	if oldSet.Spec.Template.Annotations != nil {
+		podConfig, hasPodConfig = oldSet.Spec.Template.Annotations[LastAppliedConfigAnnotation]
+	}
+	set.Spec.Template = newSet.Spec.Template
+	if hasPodConfig {
+		set.Spec.Template.Annotations[LastAppliedConfigAnnotation] = podConfig
+	}
+	set.Annotations = newSet.Annotations
+	*set.Spec.Replicas = *newSet.Spec.Replicas
+	set.Spec.UpdateStrategy = newSet.Spec.UpdateStrategy
+	if isOrphan {
+		set.OwnerReferences = newSet.OwnerReferences
+		set.Labels = newSet.Labels
+	}
+	err := SetStatefulSetLastAppliedConfigAnnotation(&set)
+	if err != nil {
+		return err
+	}
+	_, err = setCtl.UpdateStatefulSet(tc, &set)
+	return err
+}
+
 func clusterSecretName(tc *v1alpha1.TikvCluster, component string) string {
 	return fmt.Sprintf("%s-%s-cluster-secret", tc.Name, component)
 }
@@ -20,9 +20,12 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/pingcap/advanced-statefulset/client/apis/apps/v1/helper"
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
 	"github.com/tikv/tikv-operator/pkg/label"
 	apps "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
 )
 
 func TestStatefulSetIsUpgrading(t *testing.T) {
@@ -99,6 +102,192 @@ func TestStatefulSetIsUpgrading(t *testing.T) {
 	}
 }
 
+func TestStoreState(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pod := &corev1.Pod{}
+	_, ok := GetStoreState(pod)
+	g.Expect(ok).To(BeFalse())
+
+	pod.Annotations = map[string]string{"other": "value"}
+	_, ok = GetStoreState(pod)
+	g.Expect(ok).To(BeFalse())
+
+	for _, state := range []string{StoreStateUp, StoreStateDown, StoreStateOffline, StoreStateTombstone} {
+		SetStoreState(pod, state)
+		got, ok := GetStoreState(pod)
+		g.Expect(ok).To(BeTrue())
+		g.Expect(got).To(Equal(state))
+	}
+	g.Expect(pod.Annotations).To(HaveKeyWithValue("other", "value"))
+
+	// the annotations are created if the pod has none
+	pod = &corev1.Pod{}
+	SetStoreState(pod, StoreStateDown)
+	g.Expect(pod.Annotations).To(Equal(map[string]string{label.AnnStoreState: "Down"}))
+}
+
+func TestDataDirInitContainer(t *testing.T) {
+	g := NewGomegaWithT(t)
+	defer func(image string) { controller.DataDirInitImage = image }(controller.DataDirInitImage)
+	controller.DataDirInitImage = "busybox:1.26.2"
+
+	nonRoot := true
+	uid, gid, pdUID := int64(1000), int64(2000), int64(1001)
+	tests := []struct {
+		name    string
+		psc     *corev1.PodSecurityContext
+		sc      *corev1.SecurityContext
+		command string
+	}{
+		{
+			name: "no security context",
+		},
+		{
+			name:    "user of the container",
+			psc:     &corev1.PodSecurityContext{RunAsUser: &uid, FSGroup: &gid},
+			sc:      &corev1.SecurityContext{RunAsNonRoot: &nonRoot, RunAsUser: &pdUID},
+			command: "chown -R 1001:2000 /var/lib/tikv",
+		},
+		{
+			name: "root",
+			psc:  &corev1.PodSecurityContext{RunAsUser: &uid},
+		},
+		{
+			name:    "non-root with fsGroup",
+			psc:     &corev1.PodSecurityContext{RunAsNonRoot: &nonRoot, RunAsUser: &uid, FSGroup: &gid},
+			command: "chown -R 1000:2000 /var/lib/tikv",
+		},
+		{
+			name:    "non-root with runAsGroup",
+			psc:     &corev1.PodSecurityContext{RunAsNonRoot: &nonRoot, RunAsUser: &uid, RunAsGroup: &gid},
+			command: "chown -R 1000:2000 /var/lib/tikv",
+		},
+		{
+			name:    "non-root",
+			psc:     &corev1.PodSecurityContext{RunAsNonRoot: &nonRoot, RunAsUser: &uid},
+			command: "chown -R 1000 /var/lib/tikv",
+		},
+		{
+			name:    "fsGroup only",
+			psc:     &corev1.PodSecurityContext{FSGroup: &gid},
+			command: "chgrp -R 2000 /var/lib/tikv && chmod -R g+rwX /var/lib/tikv",
+		},
+		{
+			name: "user of the image",
+			psc:  &corev1.PodSecurityContext{RunAsNonRoot: &nonRoot},
+		},
+	}
+	for _, tt := range tests {
+		t.Log(tt.name)
+		init := dataDirInitContainer(tt.psc, tt.sc, "tikv", "/var/lib/tikv")
+		if tt.command == "" {
+			g.Expect(init).To(BeNil())
+			continue
+		}
+		g.Expect(init).NotTo(BeNil())
+		g.Expect(init.Command).To(Equal([]string{"sh", "-c", tt.command}))
+		g.Expect(*init.SecurityContext.RunAsUser).To(Equal(int64(0)))
+		g.Expect(*init.SecurityContext.RunAsNonRoot).To(BeFalse())
+		g.Expect(init.VolumeMounts).To(Equal([]corev1.VolumeMount{{Name: "tikv", MountPath: "/var/lib/tikv"}}))
+	}
+
+	// disabled by the operator
+	controller.DataDirInitImage = ""
+	g.Expect(dataDirInitContainer(&corev1.PodSecurityContext{RunAsNonRoot: &nonRoot, RunAsUser: &uid}, nil, "tikv", "/var/lib/tikv")).To(BeNil())
+}
+
+func TestRestrictedContainerSecurityContext(t *testing.T) {
+	g := NewGomegaWithT(t)
+	defer func(image string) { controller.DataDirInitImage = image }(controller.DataDirInitImage)
+	controller.DataDirInitImage = "busybox:1.26.2"
+
+	tc := newTikvClusterForPD()
+	oldTiKVSet, err := getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(oldTiKVSet.Spec.Template.Spec.Containers[0].SecurityContext).To(Equal(&corev1.SecurityContext{Privileged: pointer.BoolPtr(false)}))
+	g.Expect(SetStatefulSetLastAppliedConfigAnnotation(oldTiKVSet)).To(Succeed())
+
+	// the same spec rendered again round-trips through the applied config
+	tikvSet, err := getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(templateEqual(tikvSet, oldTiKVSet)).To(BeTrue())
+
+	restricted := &corev1.SecurityContext{
+		Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+		ReadOnlyRootFilesystem:   pointer.BoolPtr(true),
+		AllowPrivilegeEscalation: pointer.BoolPtr(false),
+		RunAsNonRoot:             pointer.BoolPtr(true),
+		RunAsUser:                pointer.Int64Ptr(1000),
+	}
+	tc.Spec.TiKV.SecurityContext = restricted
+	tc.Spec.PD.SecurityContext = restricted
+	tikvSet, err = getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(templateEqual(tikvSet, oldTiKVSet)).To(BeFalse())
+	podSpec := tikvSet.Spec.Template.Spec
+	g.Expect(*podSpec.Containers[0].SecurityContext.Privileged).To(BeFalse())
+	g.Expect(podSpec.Containers[0].SecurityContext.Capabilities).To(Equal(restricted.Capabilities))
+	g.Expect(podSpec.Containers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "tmp", MountPath: "/tmp"}))
+	g.Expect(podSpec.Volumes).To(ContainElement(corev1.Volume{Name: "tmp", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}))
+	// the data dir is chowned with the capabilities needed only
+	g.Expect(podSpec.InitContainers).To(HaveLen(1))
+	initSC := podSpec.InitContainers[0].SecurityContext
+	g.Expect(*initSC.RunAsUser).To(Equal(int64(0)))
+	g.Expect(*initSC.ReadOnlyRootFilesystem).To(BeTrue())
+	g.Expect(*initSC.AllowPrivilegeEscalation).To(BeFalse())
+	g.Expect(initSC.Capabilities.Drop).To(Equal([]corev1.Capability{"ALL"}))
+	g.Expect(initSC.Capabilities.Add).To(ConsistOf(corev1.Capability("CHOWN"), corev1.Capability("FOWNER"), corev1.Capability("DAC_OVERRIDE")))
+	// the spec is not modified by the rendering
+	g.Expect(tc.Spec.TiKV.SecurityContext.Privileged).To(BeNil())
+
+	// the restricted statefulset round-trips through the applied config, it is not rolled again
+	g.Expect(SetStatefulSetLastAppliedConfigAnnotation(tikvSet)).To(Succeed())
+	newTiKVSet, err := getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(templateEqual(newTiKVSet, tikvSet)).To(BeTrue())
+
+	pdSet, err := getNewPDSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(pdSet.Spec.Template.Spec.Containers[0].SecurityContext).To(Equal(restricted))
+	g.Expect(pdSet.Spec.Template.Spec.Containers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "tmp", MountPath: "/tmp"}))
+	g.Expect(SetStatefulSetLastAppliedConfigAnnotation(pdSet)).To(Succeed())
+	newPDSet, err := getNewPDSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(templateEqual(newPDSet, pdSet)).To(BeTrue())
+}
+
+func TestNonRootPodTemplate(t *testing.T) {
+	g := NewGomegaWithT(t)
+	defer func(image string) { controller.DataDirInitImage = image }(controller.DataDirInitImage)
+	controller.DataDirInitImage = "busybox:1.26.2"
+
+	tc := newTikvClusterForPD()
+	oldTiKVSet, err := getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(SetStatefulSetLastAppliedConfigAnnotation(oldTiKVSet)).To(Succeed())
+
+	nonRoot := true
+	uid, gid, pdUID := int64(1000), int64(2000), int64(1001)
+	tc.Spec.PodSecurityContext = &corev1.PodSecurityContext{RunAsNonRoot: &nonRoot, RunAsUser: &uid, FSGroup: &gid}
+	tc.Spec.PD.PodSecurityContext = &corev1.PodSecurityContext{RunAsNonRoot: &nonRoot, RunAsUser: &pdUID}
+
+	tikvSet, err := getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(tikvSet.Spec.Template.Spec.SecurityContext).To(Equal(tc.Spec.PodSecurityContext))
+	g.Expect(tikvSet.Spec.Template.Spec.InitContainers).To(HaveLen(1))
+	g.Expect(tikvSet.Spec.Template.Spec.InitContainers[0].Command).To(Equal([]string{"sh", "-c", "chown -R 1000:2000 /var/lib/tikv"}))
+	// switching an existing cluster to non-root rolls the pods
+	g.Expect(templateEqual(tikvSet, oldTiKVSet)).To(BeFalse())
+
+	// the component overrides the cluster
+	pdSet, err := getNewPDSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(pdSet.Spec.Template.Spec.SecurityContext).To(Equal(tc.Spec.PD.PodSecurityContext))
+	g.Expect(pdSet.Spec.Template.Spec.InitContainers).To(HaveLen(1))
+	g.Expect(pdSet.Spec.Template.Spec.InitContainers[0].Command).To(Equal([]string{"sh", "-c", "chown -R 1001 /var/lib/pd"}))
+}
+
 func TestGetStsAnnotations(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -140,6 +329,42 @@ func TestGetStsAnnotations(t *testing.T) {
 				helper.DeleteSlotsAnn: "[1,2]",
 			},
 		},
+		{
+			name: "tikv offline stores",
+			tc: &v1alpha1.TikvCluster{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						label.AnnTiKVDeleteSlots: "[1,2]",
+					},
+				},
+				Status: v1alpha1.TikvClusterStatus{
+					TiKV: v1alpha1.TiKVStatus{
+						OfflineStores: map[string]v1alpha1.TiKVOfflineStore{
+							"demo-tikv-4": {ID: "4", PodName: "demo-tikv-4", Phase: v1alpha1.OfflineStorePhaseOffline},
+							"5":           {ID: "5", PodName: "demo-tikv-0", Phase: v1alpha1.OfflineStorePhaseRemoved},
+						},
+					},
+				},
+			},
+			component: label.TiKVLabelVal,
+			expected: map[string]string{
+				helper.DeleteSlotsAnn: "[0,1,2,4]",
+			},
+		},
+		{
+			name: "pd ignores tikv offline stores",
+			tc: &v1alpha1.TikvCluster{
+				Status: v1alpha1.TikvClusterStatus{
+					TiKV: v1alpha1.TiKVStatus{
+						OfflineStores: map[string]v1alpha1.TiKVOfflineStore{
+							"demo-tikv-4": {ID: "4", PodName: "demo-tikv-4", Phase: v1alpha1.OfflineStorePhaseOffline},
+						},
+					},
+				},
+			},
+			component: label.PDLabelVal,
+			expected:  map[string]string{},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -150,3 +375,285 @@ func TestGetStsAnnotations(t *testing.T) {
 		})
 	}
 }
+
+func TestKeepLegacyPromAnnotations(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name       string
+		oldAnns    map[string]string
+		newAnns    map[string]string
+		image      string
+		expectKept bool
+		expectAnns map[string]string
+	}{
+		{
+			name:       "no legacy annotation",
+			oldAnns:    map[string]string{"prometheus.io/port": "20180"},
+			newAnns:    map[string]string{"prometheus.io/port": "20180"},
+			expectKept: false,
+			expectAnns: map[string]string{"prometheus.io/port": "20180"},
+		},
+		{
+			name:    "legacy annotations are the only change",
+			oldAnns: map[string]string{"prometheus.io/port": "20180", "proxy.prometheus.io/port": "20292"},
+			newAnns: map[string]string{
+				"prometheus.io/port":                       "20180",
+				"prometheus.tikv.org/additional-endpoints": `{"proxy":"20292"}`,
+			},
+			expectKept: true,
+			expectAnns: map[string]string{"prometheus.io/port": "20180", "proxy.prometheus.io/port": "20292"},
+		},
+		{
+			name:    "other annotations changed",
+			oldAnns: map[string]string{"prometheus.io/port": "20180", "proxy.prometheus.io/port": "20292"},
+			newAnns: map[string]string{
+				"prometheus.io/port":                       "20181",
+				"prometheus.tikv.org/additional-endpoints": `{"proxy":"20292"}`,
+			},
+			expectKept: false,
+			expectAnns: map[string]string{
+				"prometheus.io/port":                       "20181",
+				"prometheus.tikv.org/additional-endpoints": `{"proxy":"20292"}`,
+			},
+		},
+		{
+			name:    "pod spec changed",
+			oldAnns: map[string]string{"prometheus.io/port": "20180", "proxy.prometheus.io/port": "20292"},
+			newAnns: map[string]string{
+				"prometheus.io/port":                       "20180",
+				"prometheus.tikv.org/additional-endpoints": `{"proxy":"20292"}`,
+			},
+			image:      "tikv:v4.0.1",
+			expectKept: false,
+			expectAnns: map[string]string{
+				"prometheus.io/port":                       "20180",
+				"prometheus.tikv.org/additional-endpoints": `{"proxy":"20292"}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldSet := &apps.StatefulSet{}
+			oldSet.Spec.Template.Annotations = tt.oldAnns
+			oldSet.Spec.Template.Spec.Containers = []corev1.Container{{Name: "tikv", Image: "tikv:v4.0.0"}}
+			g.Expect(SetStatefulSetLastAppliedConfigAnnotation(oldSet)).To(Succeed())
+			newSet := &apps.StatefulSet{}
+			newSet.Spec.Template.Annotations = tt.newAnns
+			newSet.Spec.Template.Spec.Containers = []corev1.Container{{Name: "tikv", Image: "tikv:v4.0.0"}}
+			if tt.image != "" {
+				newSet.Spec.Template.Spec.Containers[0].Image = tt.image
+			}
+
+			g.Expect(keepLegacyPromAnnotations(newSet, oldSet)).To(Equal(tt.expectKept))
+			g.Expect(newSet.Spec.Template.Annotations).To(Equal(tt.expectAnns))
+		})
+	}
+}
+
+func TestMergeAnnotations(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name         string
+		operatorAnns map[string]string
+		userAnns     map[string]string
+		expectAnns   map[string]string
+	}{
+		{
+			name:         "no annotations",
+			operatorAnns: nil,
+			userAnns:     nil,
+			expectAnns:   map[string]string{},
+		},
+		{
+			name:         "operator wins on a prometheus.io key",
+			operatorAnns: map[string]string{"prometheus.io/port": "20180", "prometheus.io/scrape": "true"},
+			userAnns:     map[string]string{"prometheus.io/port": "9090"},
+			expectAnns:   map[string]string{"prometheus.io/port": "20180", "prometheus.io/scrape": "true"},
+		},
+		{
+			name:         "operator wins on a tikv.org key",
+			operatorAnns: map[string]string{label.AnnTLSCertHash: "operator"},
+			userAnns:     map[string]string{label.AnnTLSCertHash: "user"},
+			expectAnns:   map[string]string{label.AnnTLSCertHash: "operator"},
+		},
+		{
+			name:         "user keys with a reserved prefix the operator does not set are kept",
+			operatorAnns: map[string]string{"prometheus.io/port": "20180"},
+			userAnns:     map[string]string{label.AnnSysctlInit: "true", "prometheus.io/path": "/custom"},
+			expectAnns: map[string]string{
+				"prometheus.io/port": "20180",
+				"prometheus.io/path": "/custom",
+				label.AnnSysctlInit:  "true",
+			},
+		},
+		{
+			name:         "user wins on a plain key",
+			operatorAnns: map[string]string{"example.com/owner": "operator", "prometheus.io/port": "20180"},
+			userAnns:     map[string]string{"example.com/owner": "user", "team": "storage"},
+			expectAnns: map[string]string{
+				"example.com/owner":  "user",
+				"team":               "storage",
+				"prometheus.io/port": "20180",
+			},
+		},
+		{
+			name:         "keys only containing a reserved prefix are plain keys",
+			operatorAnns: map[string]string{"proxy.prometheus.io/port": "20292", "prometheus.tikv.org/additional-endpoints": `{"proxy":"20292"}`},
+			userAnns:     map[string]string{"proxy.prometheus.io/port": "20293", "prometheus.tikv.org/additional-endpoints": `{"proxy":"20293"}`},
+			expectAnns:   map[string]string{"proxy.prometheus.io/port": "20293", "prometheus.tikv.org/additional-endpoints": `{"proxy":"20293"}`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			operatorAnns := copyAnnotations(tt.operatorAnns)
+			userAnns := copyAnnotations(tt.userAnns)
+
+			merged := MergeAnnotations(operatorAnns, userAnns)
+			g.Expect(merged).To(Equal(tt.expectAnns))
+			g.Expect(operatorAnns).To(Equal(tt.operatorAnns))
+			g.Expect(userAnns).To(Equal(tt.userAnns))
+
+			// the merged map is a new one
+			merged["new"] = "value"
+			g.Expect(operatorAnns).NotTo(HaveKey("new"))
+			g.Expect(userAnns).NotTo(HaveKey("new"))
+		})
+	}
+}
+
+func TestSchedulingPodTemplate(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForPD()
+	oldTiKVSet, err := getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(SetStatefulSetLastAppliedConfigAnnotation(oldTiKVSet)).To(Succeed())
+
+	dedicated := corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "pd", Effect: corev1.TaintEffectNoSchedule}
+	storage := corev1.Toleration{Key: "storage", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}
+	pdAffinity := &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+				MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "dedicated", Operator: corev1.NodeSelectorOpIn, Values: []string{"pd"}}},
+			}},
+		},
+	}}
+	tc.Spec.NodeSelector = map[string]string{"zone": "a"}
+	tc.Spec.Tolerations = []corev1.Toleration{dedicated}
+	tc.Spec.PD.Affinity = pdAffinity
+	tc.Spec.PD.NodeSelector = map[string]string{"dedicated": "pd"}
+	tc.Spec.TiKV.Tolerations = []corev1.Toleration{storage, dedicated}
+
+	pdSet, err := getNewPDSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(pdSet.Spec.Template.Spec.Affinity).To(Equal(pdAffinity))
+	g.Expect(pdSet.Spec.Template.Spec.NodeSelector).To(Equal(map[string]string{"zone": "a", "dedicated": "pd"}))
+	g.Expect(pdSet.Spec.Template.Spec.Tolerations).To(Equal([]corev1.Toleration{dedicated}))
+
+	tikvSet, err := getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(tikvSet.Spec.Template.Spec.Affinity).To(BeNil())
+	g.Expect(tikvSet.Spec.Template.Spec.NodeSelector).To(Equal(map[string]string{"zone": "a"}))
+	g.Expect(tikvSet.Spec.Template.Spec.Tolerations).To(Equal([]corev1.Toleration{dedicated, storage}))
+	// the pods are rolled to be scheduled again
+	g.Expect(templateEqual(tikvSet, oldTiKVSet)).To(BeFalse())
+	g.Expect(SetStatefulSetLastAppliedConfigAnnotation(tikvSet)).To(Succeed())
+	newTiKVSet, err := getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(templateEqual(newTiKVSet, tikvSet)).To(BeTrue())
+}
+
+func TestHostNetworkAntiAffinity(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	l := label.New().Instance("test").TiKV()
+	term := corev1.PodAffinityTerm{LabelSelector: l.LabelSelector(), TopologyKey: corev1.LabelHostname}
+	g.Expect(hostNetworkAntiAffinity(nil, l)).To(Equal(&corev1.Affinity{
+		PodAntiAffinity: &corev1.PodAntiAffinity{RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{term}},
+	}))
+
+	// the affinity set is kept and not mutated
+	zoneTerm := corev1.PodAffinityTerm{LabelSelector: l.LabelSelector(), TopologyKey: "topology.kubernetes.io/zone"}
+	affinity := &corev1.Affinity{
+		NodeAffinity:    &corev1.NodeAffinity{},
+		PodAntiAffinity: &corev1.PodAntiAffinity{RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{zoneTerm}},
+	}
+	got := hostNetworkAntiAffinity(affinity, l)
+	g.Expect(got.NodeAffinity).To(Equal(&corev1.NodeAffinity{}))
+	g.Expect(got.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution).To(Equal([]corev1.PodAffinityTerm{zoneTerm, term}))
+	g.Expect(affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution).To(HaveLen(1))
+
+	// the term is not added twice
+	g.Expect(hostNetworkAntiAffinity(got, l)).To(Equal(got))
+}
+
+func TestTopologySpreadConstraints(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForPD()
+	oldTiKVSet, err := getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(oldTiKVSet.Spec.Template.Spec.TopologySpreadConstraints).To(BeNil())
+	g.Expect(SetStatefulSetLastAppliedConfigAnnotation(oldTiKVSet)).To(Succeed())
+
+	zoneSpread := corev1.TopologySpreadConstraint{
+		MaxSkew:           1,
+		TopologyKey:       "topology.kubernetes.io/zone",
+		WhenUnsatisfiable: corev1.DoNotSchedule,
+	}
+	hostSpread := corev1.TopologySpreadConstraint{
+		MaxSkew:           1,
+		TopologyKey:       "kubernetes.io/hostname",
+		WhenUnsatisfiable: corev1.ScheduleAnyway,
+		LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "tikv"}},
+	}
+	tc.Spec.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{zoneSpread}
+	tc.Spec.TiKV.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{zoneSpread, hostSpread}
+
+	// the cluster-level constraints are inherited with the selector of the component filled in
+	pdSet, err := getNewPDSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	pdZoneSpread := zoneSpread
+	pdZoneSpread.LabelSelector = label.New().Instance(tc.GetInstanceName()).PD().LabelSelector()
+	g.Expect(pdSet.Spec.Template.Spec.TopologySpreadConstraints).To(Equal([]corev1.TopologySpreadConstraint{pdZoneSpread}))
+
+	// the constraints of the component override the cluster-level ones, a selector set is kept
+	tikvSet, err := getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	tikvZoneSpread := zoneSpread
+	tikvZoneSpread.LabelSelector = label.New().Instance(tc.GetInstanceName()).TiKV().LabelSelector()
+	g.Expect(tikvSet.Spec.Template.Spec.TopologySpreadConstraints).To(Equal([]corev1.TopologySpreadConstraint{tikvZoneSpread, hostSpread}))
+	// the spec is not mutated
+	g.Expect(tc.Spec.TiKV.TopologySpreadConstraints[0].LabelSelector).To(BeNil())
+
+	// the pods are rolled to be scheduled again, but not again once the constraints are applied
+	g.Expect(templateEqual(tikvSet, oldTiKVSet)).To(BeFalse())
+	g.Expect(SetStatefulSetLastAppliedConfigAnnotation(tikvSet)).To(Succeed())
+	newTiKVSet, err := getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(templateEqual(newTiKVSet, tikvSet)).To(BeTrue())
+	g.Expect(statefulSetEqual(*newTiKVSet, *tikvSet)).To(BeTrue())
+}
+
+func TestPriorityClassNamePodTemplate(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := newTikvClusterForPD()
+	oldTiKVSet, err := getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(oldTiKVSet.Spec.Template.Spec.PriorityClassName).To(BeEmpty())
+	g.Expect(SetStatefulSetLastAppliedConfigAnnotation(oldTiKVSet)).To(Succeed())
+
+	tc.Spec.PriorityClassName = pointer.StringPtr("tikv-cluster")
+	tc.Spec.TiKV.PriorityClassName = pointer.StringPtr("tikv-store")
+
+	pdSet, err := getNewPDSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(pdSet.Spec.Template.Spec.PriorityClassName).To(Equal("tikv-cluster"))
+
+	tikvSet, err := getNewTiKVSetForTikvCluster(tc, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(tikvSet.Spec.Template.Spec.PriorityClassName).To(Equal("tikv-store"))
+	// the pods are rolled by the upgrader
+	g.Expect(templateEqual(tikvSet, oldTiKVSet)).To(BeFalse())
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/tikv/tikv-operator/pkg/controller"
 	"github.com/tikv/tikv-operator/pkg/label"
 	"github.com/tikv/tikv-operator/pkg/manager"
+	"github.com/tikv/tikv-operator/pkg/util"
 	corev1 "k8s.io/api/core/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/klog"
@@ -74,7 +75,8 @@ func (pmm *metaManager) Sync(tc *v1alpha1.TikvCluster) error {
 			return err
 		}
 
-		if component := pod.Labels[label.ComponentLabelKey]; component != label.PDLabelVal && component != label.TiKVLabelVal {
+		component := pod.Labels[label.ComponentLabelKey]
+		if component != label.PDLabelVal && component != label.TiKVLabelVal {
 			// Skip syncing meta info for pod that doesn't use PV
 			// Currently only PD/TiKV uses PV
 			continue
@@ -85,6 +87,11 @@ func (pmm *metaManager) Sync(tc *v1alpha1.TikvCluster) error {
 			return err
 		}
 		for _, pvc := range pvcs {
+			if component == label.TiKVLabelVal {
+				if pvc, err = pmm.syncPVCReclaimAnnotation(tc, pvc); err != nil {
+					return err
+				}
+			}
 			_, err = pmm.pvcControl.UpdateMetaInfo(tc, pvc, pod)
 			if err != nil {
 				return err
@@ -108,6 +115,23 @@ func (pmm *metaManager) Sync(tc *v1alpha1.TikvCluster) error {
 	return nil
 }
 
+// syncPVCReclaimAnnotation marks the pvc of tikv with spec.tikv.pvcReclaimPolicy if it is not
+// marked so yet
+func (pmm *metaManager) syncPVCReclaimAnnotation(tc *v1alpha1.TikvCluster, pvc *corev1.PersistentVolumeClaim) (*corev1.PersistentVolumeClaim, error) {
+	policy := tc.Spec.TiKV.PVCReclaimPolicy
+	if policy == "" {
+		return pvc, nil
+	}
+	retain := policy == corev1.PersistentVolumeReclaimRetain
+	if marked, ok := util.GetPVCReclaimPolicy(pvc); ok && marked == retain {
+		return pvc, nil
+	}
+	pvc = pvc.DeepCopy()
+	util.SetPVCReclaimAnnotation(pvc, retain)
+	klog.Infof("tikv cluster %s/%s: set pvc %s annotation %s to %s", tc.GetNamespace(), tc.GetName(), pvc.GetName(), label.AnnPVCReclaimKey, pvc.Annotations[label.AnnPVCReclaimKey])
+	return pmm.pvcControl.UpdatePVC(tc, pvc)
+}
+
 func (pmm *metaManager) resolvePVCFromPod(pod *corev1.Pod) ([]*corev1.PersistentVolumeClaim, error) {
 	var pvcs []*corev1.PersistentVolumeClaim
 	var pvcName string
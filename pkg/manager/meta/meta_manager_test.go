@@ -685,6 +685,61 @@ func TestMetaManagerSyncMultiPVC(t *testing.T) {
 	}
 }
 
+func TestMetaManagerSyncPVCReclaimAnnotation(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		name       string
+		policy     corev1.PersistentVolumeReclaimPolicy
+		annotation string
+		expected   string
+	}{
+		{
+			name: "not set",
+		},
+		{
+			name:     "retain",
+			policy:   corev1.PersistentVolumeReclaimRetain,
+			expected: label.AnnPVCReclaimRetainVal,
+		},
+		{
+			name:       "changed to delete",
+			policy:     corev1.PersistentVolumeReclaimDelete,
+			annotation: label.AnnPVCReclaimRetainVal,
+			expected:   label.AnnPVCReclaimDeleteVal,
+		},
+		{
+			name:       "unset keeps the annotation",
+			annotation: label.AnnPVCReclaimRetainVal,
+			expected:   label.AnnPVCReclaimRetainVal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTikvClusterForMeta()
+			tc.Spec.TiKV.PVCReclaimPolicy = tt.policy
+			pvc1 := newPVC(tc, "1")
+			if tt.annotation != "" {
+				pvc1.Annotations = map[string]string{label.AnnPVCReclaimKey: tt.annotation}
+			}
+
+			nmm, _, _, _, podIndexer, pvcIndexer, pvIndexer := newFakeMetaManager()
+			g.Expect(podIndexer.Add(newPod(tc))).To(Succeed())
+			g.Expect(pvcIndexer.Add(pvc1)).To(Succeed())
+			g.Expect(pvIndexer.Add(newPV("1"))).To(Succeed())
+
+			g.Expect(nmm.Sync(tc)).To(Succeed())
+			pvc, err := nmm.pvcLister.PersistentVolumeClaims(tc.GetNamespace()).Get(pvc1.Name)
+			g.Expect(err).NotTo(HaveOccurred())
+			if tt.expected == "" {
+				g.Expect(pvc.Annotations).NotTo(HaveKey(label.AnnPVCReclaimKey))
+				return
+			}
+			g.Expect(pvc.Annotations).To(HaveKeyWithValue(label.AnnPVCReclaimKey, tt.expected))
+			g.Expect(pvcMetaInfoMatchDesire(pvc)).To(BeTrue())
+		})
+	}
+}
+
 func newFakeMetaManager() (
 	*metaManager,
 	*controller.FakePodControl,
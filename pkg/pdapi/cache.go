@@ -0,0 +1,170 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pdapi
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog"
+)
+
+// ResponseCacheTTL is how long the responses of GetStores, GetMembers and GetConfig are
+// cached by the pd clients of a cluster, 0 disables the cache. It is read on every call,
+// so changing it takes effect at once.
+var ResponseCacheTTL = 10 * time.Second
+
+// extendedTTL is a longer TTL of the response cache in effect for a while, e.g. when all the
+// clusters are synced at once after a relist of the informers
+var extendedTTL struct {
+	sync.Mutex
+	ttl   time.Duration
+	until time.Time
+}
+
+// ExtendResponseCacheTTL raises the TTL of the response cache to ttl until the given time for
+// the StatusOnly reads, it never enables a disabled cache nor lowers the TTL
+func ExtendResponseCacheTTL(ttl time.Duration, until time.Time) {
+	extendedTTL.Lock()
+	defer extendedTTL.Unlock()
+	// the TTL of an extension that is over is dropped
+	if ttl > extendedTTL.ttl || time.Now().After(extendedTTL.until) {
+		extendedTTL.ttl = ttl
+	}
+	if until.After(extendedTTL.until) {
+		extendedTTL.until = until
+	}
+	klog.Infof("pd response cache TTL is raised to %s until %s", ttl, until.Format(time.RFC3339))
+}
+
+// responseCacheTTL returns the TTL of the response cache in effect, the extended TTL is only
+// in effect for the StatusOnly reads
+func responseCacheTTL(statusOnly bool) time.Duration {
+	ttl := ResponseCacheTTL
+	if ttl <= 0 || !statusOnly {
+		return ttl
+	}
+	extendedTTL.Lock()
+	defer extendedTTL.Unlock()
+	if time.Now().Before(extendedTTL.until) && extendedTTL.ttl > ttl {
+		return extendedTTL.ttl
+	}
+	return ttl
+}
+
+var responseCacheRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "tikv_operator",
+		Subsystem: "pdapi",
+		Name:      "response_cache_requests_total",
+		Help:      "Number of pd api reads looked up in the response cache, by api and result (hit or miss).",
+	}, []string{"api", "result"})
+
+func init() {
+	prometheus.MustRegister(responseCacheRequests)
+}
+
+// GetOption configures a read of PDClient
+type GetOption func(*getOptions)
+
+type getOptions struct {
+	noCache    bool
+	statusOnly bool
+}
+
+// NoCache makes a read bypass the response cache, it should be used by the checks made
+// right before a destructive action. The fresh response still refreshes the cache.
+func NoCache(o *getOptions) {
+	o.noCache = true
+}
+
+// StatusOnly marks a read which only reports the status of the cluster, it may be served with
+// the TTL raised by ExtendResponseCacheTTL. The reads deciding to upgrade, scale or fail over
+// must not use it.
+func StatusOnly(o *getOptions) {
+	o.statusOnly = true
+}
+
+func newGetOptions(opts []GetOption) getOptions {
+	o := getOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// responseCache caches the response bodies of the read apis of a pd cluster, bodies are
+// cached instead of the decoded responses so that callers never share objects.
+// Any write to the pd cluster invalidates the whole cache.
+type responseCache struct {
+	lock    sync.Mutex
+	entries map[string]cacheEntry
+	// generation is bumped by every invalidation, so that a response fetched
+	// before a write is not cached after the write
+	generation uint64
+}
+
+type cacheEntry struct {
+	body []byte
+	// the TTL depends on the read, so the entry expires relative to when it is fetched
+	fetchedAt time.Time
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{entries: map[string]cacheEntry{}}
+}
+
+// getBody returns the cached response body of the api if it has not expired,
+// otherwise it fetches the body and caches it
+func (c *responseCache) getBody(api string, fetch func() ([]byte, error), opts ...GetOption) ([]byte, error) {
+	o := newGetOptions(opts)
+	ttl := responseCacheTTL(o.statusOnly)
+	if c == nil || ttl <= 0 {
+		return fetch()
+	}
+
+	c.lock.Lock()
+	entry, ok := c.entries[api]
+	generation := c.generation
+	c.lock.Unlock()
+	if ok && !o.noCache && time.Since(entry.fetchedAt) < ttl {
+		responseCacheRequests.WithLabelValues(api, "hit").Inc()
+		klog.V(4).Infof("pd api %s: served from the response cache", api)
+		return entry.body, nil
+	}
+	responseCacheRequests.WithLabelValues(api, "miss").Inc()
+
+	body, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.generation == generation {
+		c.entries[api] = cacheEntry{body: body, fetchedAt: time.Now()}
+	}
+	return body, nil
+}
+
+// invalidate drops all the cached responses
+func (c *responseCache) invalidate() {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.generation++
+	c.entries = map[string]cacheEntry{}
+}
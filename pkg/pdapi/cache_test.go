@@ -0,0 +1,192 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pdapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestResponseCache(t *testing.T) {
+	g := NewGomegaWithT(t)
+	defer func(ttl time.Duration) { ResponseCacheTTL = ttl }(ResponseCacheTTL)
+	ResponseCacheTTL = time.Minute
+
+	stores := &StoresInfo{
+		Count:  1,
+		Stores: []*StoreInfo{{Store: &MetaStore{Store: &metapb.Store{Id: 1}}}},
+	}
+	storesBytes, err := json.Marshal(stores)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	var gets int32
+	svc := getClientServer(func(w http.ResponseWriter, request *http.Request) {
+		switch {
+		case request.Method == "GET" && request.URL.Path == fmt.Sprintf("/%s", storesPrefix):
+			atomic.AddInt32(&gets, 1)
+			w.Header().Set("Content-Type", ContentTypeJSON)
+			w.Write(storesBytes)
+		case request.Method == "POST":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer svc.Close()
+
+	counter := func(result string) float64 {
+		m := &dto.Metric{}
+		g.Expect(responseCacheRequests.WithLabelValues("stores", result).Write(m)).To(Succeed())
+		return m.GetCounter().GetValue()
+	}
+	hits, misses := counter("hit"), counter("miss")
+
+	pdClient := NewPDClient(svc.URL, DefaultTimeout, nil)
+	first, err := pdClient.GetStores()
+	g.Expect(err).NotTo(HaveOccurred())
+	second, err := pdClient.GetStores()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(atomic.LoadInt32(&gets)).To(Equal(int32(1)))
+	g.Expect(second).To(Equal(first))
+	// callers never share the decoded responses
+	g.Expect(second).NotTo(BeIdenticalTo(first))
+	g.Expect(counter("hit") - hits).To(Equal(float64(1)))
+	g.Expect(counter("miss") - misses).To(Equal(float64(1)))
+
+	// reads before destructive actions bypass the cache
+	_, err = pdClient.GetStores(NoCache)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(atomic.LoadInt32(&gets)).To(Equal(int32(2)))
+
+	// writes invalidate the cache
+	g.Expect(pdClient.SetStoreState(1, "Up")).To(Succeed())
+	_, err = pdClient.GetStores()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(atomic.LoadInt32(&gets)).To(Equal(int32(3)))
+
+	// a zero ttl disables the cache
+	ResponseCacheTTL = 0
+	_, err = pdClient.GetStores()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(atomic.LoadInt32(&gets)).To(Equal(int32(4)))
+}
+
+func TestResponseCacheInvalidatedDuringFetch(t *testing.T) {
+	g := NewGomegaWithT(t)
+	defer func(ttl time.Duration) { ResponseCacheTTL = ttl }(ResponseCacheTTL)
+	ResponseCacheTTL = time.Minute
+
+	c := newResponseCache()
+	body, err := c.getBody("stores", func() ([]byte, error) {
+		// a write finishing while the response is in flight
+		c.invalidate()
+		return []byte("stale"), nil
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(body)).To(Equal("stale"))
+	body, err = c.getBody("stores", func() ([]byte, error) {
+		return []byte("fresh"), nil
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(body)).To(Equal("fresh"))
+
+	// concurrent reads and writes are safe
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			c.getBody("stores", func() ([]byte, error) { return []byte("fresh"), nil })
+		}()
+		go func() {
+			defer wg.Done()
+			c.invalidate()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestExtendResponseCacheTTL(t *testing.T) {
+	g := NewGomegaWithT(t)
+	defer func(ttl time.Duration) { ResponseCacheTTL = ttl }(ResponseCacheTTL)
+	ResponseCacheTTL = 10 * time.Second
+	defer func() {
+		extendedTTL.Lock()
+		defer extendedTTL.Unlock()
+		extendedTTL.ttl, extendedTTL.until = 0, time.Time{}
+	}()
+
+	ExtendResponseCacheTTL(time.Minute, time.Now().Add(time.Hour))
+	g.Expect(responseCacheTTL(true)).To(Equal(time.Minute))
+	// the reads which are not StatusOnly keep the ttl
+	g.Expect(responseCacheTTL(false)).To(Equal(10 * time.Second))
+
+	// a shorter extension does not lower the ttl in effect
+	ExtendResponseCacheTTL(30*time.Second, time.Now().Add(time.Minute))
+	g.Expect(responseCacheTTL(true)).To(Equal(time.Minute))
+
+	// the extension never enables a disabled cache
+	ResponseCacheTTL = 0
+	g.Expect(responseCacheTTL(true)).To(Equal(time.Duration(0)))
+	ResponseCacheTTL = 10 * time.Second
+
+	// the ttl falls back once the extension is over
+	extendedTTL.Lock()
+	extendedTTL.until = time.Now().Add(-time.Second)
+	extendedTTL.Unlock()
+	g.Expect(responseCacheTTL(true)).To(Equal(10 * time.Second))
+}
+
+func TestResponseCacheExtendedTTLStatusOnly(t *testing.T) {
+	g := NewGomegaWithT(t)
+	defer func(ttl time.Duration) { ResponseCacheTTL = ttl }(ResponseCacheTTL)
+	ResponseCacheTTL = 10 * time.Second
+	defer func() {
+		extendedTTL.Lock()
+		defer extendedTTL.Unlock()
+		extendedTTL.ttl, extendedTTL.until = 0, time.Time{}
+	}()
+	ExtendResponseCacheTTL(time.Minute, time.Now().Add(time.Hour))
+
+	c := newResponseCache()
+	var fetches int
+	fetch := func() ([]byte, error) {
+		fetches++
+		return []byte(fmt.Sprintf("%d", fetches)), nil
+	}
+	_, err := c.getBody("stores", fetch, StatusOnly)
+	g.Expect(err).NotTo(HaveOccurred())
+	// the response is older than the ttl but not the extended ttl
+	c.lock.Lock()
+	entry := c.entries["stores"]
+	entry.fetchedAt = time.Now().Add(-30 * time.Second)
+	c.entries["stores"] = entry
+	c.lock.Unlock()
+
+	body, err := c.getBody("stores", fetch, StatusOnly)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(body)).To(Equal("1"))
+	body, err = c.getBody("stores", fetch)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(body)).To(Equal("2"))
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -30,8 +31,10 @@ import (
 	"github.com/tikv/tikv-operator/pkg/httputil"
 	"github.com/tikv/tikv-operator/pkg/util"
 	"github.com/tikv/tikv-operator/pkg/util/crypto"
+	corev1 "k8s.io/api/core/v1"
 	types "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/klog"
 )
 
@@ -44,10 +47,12 @@ type Namespace string
 
 // PDControlInterface is an interface that knows how to manage and get tidb cluster's PD client
 type PDControlInterface interface {
-	// GetPDClient provides PDClient of the tidb cluster.
-	GetPDClient(Namespace, string, bool) PDClient
+	// GetPDClient provides PDClient of the tidb cluster reached at the url, see controller.PDClientURL.
+	GetPDClient(namespace Namespace, tcName string, url string, tlsEnabled bool) PDClient
 	// GetPDEtcdClient provides PD etcd Client of the tidb cluster.
 	GetPDEtcdClient(namespace Namespace, tcName string, tlsEnabled bool) (PDEtcdClient, error)
+	// InvalidateResponseCache drops the responses cached by the PD clients of the tidb cluster.
+	InvalidateResponseCache(namespace Namespace, tcName string)
 }
 
 // defaultPDControl is the default implementation of PDControlInterface.
@@ -57,11 +62,41 @@ type defaultPDControl struct {
 	kubeCli       kubernetes.Interface
 	pdClients     map[string]PDClient
 	pdEtcdClients map[string]PDEtcdClient
+	// caches are shared by the pd clients of a cluster, including the ones of tls
+	// clusters which are rebuilt along with their tls configs
+	caches map[string]*responseCache
+
+	tlsMutex sync.Mutex
+	// secretLister lists the client secrets of the tls clusters, they are read from the
+	// apiserver if it is nil
+	secretLister corelisters.SecretLister
+	// tlsConfigs are the tls configs of the clusters, which are rebuilt when the resource
+	// versions of their client secrets change, e.g. the certificates are rotated
+	tlsConfigs map[string]*cachedTLSConfig
+}
+
+// cachedTLSConfig is the tls config loaded from the client secret of a cluster
+type cachedTLSConfig struct {
+	resourceVersion string
+	config          *tls.Config
 }
 
 // NewDefaultPDControl returns a defaultPDControl instance
 func NewDefaultPDControl(kubeCli kubernetes.Interface) PDControlInterface {
-	return &defaultPDControl{kubeCli: kubeCli, pdClients: map[string]PDClient{}, pdEtcdClients: map[string]PDEtcdClient{}}
+	return NewDefaultPDControlWithSecretLister(kubeCli, nil)
+}
+
+// NewDefaultPDControlWithSecretLister returns a defaultPDControl instance which loads the tls
+// configs of the clusters from the client secrets in the lister
+func NewDefaultPDControlWithSecretLister(kubeCli kubernetes.Interface, secretLister corelisters.SecretLister) PDControlInterface {
+	return &defaultPDControl{
+		kubeCli:       kubeCli,
+		pdClients:     map[string]PDClient{},
+		pdEtcdClients: map[string]PDEtcdClient{},
+		caches:        map[string]*responseCache{},
+		secretLister:  secretLister,
+		tlsConfigs:    map[string]*cachedTLSConfig{},
+	}
 }
 
 // GetTLSConfig returns *tls.Config for given TiDB cluster.
@@ -76,6 +111,36 @@ func GetTLSConfig(kubeCli kubernetes.Interface, namespace Namespace, tcName stri
 	return crypto.LoadTlsConfigFromSecret(secret, caCert)
 }
 
+// getTLSConfig returns the tls config of the cluster loaded from its client secret, the config
+// is cached until the resource version of the secret changes
+func (pdc *defaultPDControl) getTLSConfig(namespace Namespace, tcName string) (*tls.Config, error) {
+	pdc.tlsMutex.Lock()
+	defer pdc.tlsMutex.Unlock()
+
+	secretName := util.ClusterClientTLSSecretName(tcName)
+	var secret *corev1.Secret
+	var err error
+	if pdc.secretLister != nil {
+		secret, err = pdc.secretLister.Secrets(string(namespace)).Get(secretName)
+	} else {
+		secret, err = pdc.kubeCli.CoreV1().Secrets(string(namespace)).Get(secretName, types.GetOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to load certificates from secret %s/%s: %v", namespace, secretName, err)
+	}
+
+	key := pdEtcdClientKey(namespace, tcName)
+	if cached, ok := pdc.tlsConfigs[key]; ok && cached.resourceVersion == secret.ResourceVersion {
+		return cached.config, nil
+	}
+	config, err := crypto.LoadTlsConfigFromSecret(secret, nil)
+	if err != nil {
+		return nil, err
+	}
+	pdc.tlsConfigs[key] = &cachedTLSConfig{resourceVersion: secret.ResourceVersion, config: config}
+	return config, nil
+}
+
 func (pdc *defaultPDControl) GetPDEtcdClient(namespace Namespace, tcName string, tlsEnabled bool) (PDEtcdClient, error) {
 	pdc.etcdmutex.Lock()
 	defer pdc.etcdmutex.Unlock()
@@ -84,7 +149,7 @@ func (pdc *defaultPDControl) GetPDEtcdClient(namespace Namespace, tcName string,
 	var err error
 
 	if tlsEnabled {
-		tlsConfig, err = GetTLSConfig(pdc.kubeCli, namespace, tcName, nil)
+		tlsConfig, err = pdc.getTLSConfig(namespace, tcName)
 		if err != nil {
 			klog.Errorf("Unable to get tls config for tidb cluster %q, pd etcd client may not work: %v", tcName, err)
 			return nil, err
@@ -103,32 +168,52 @@ func (pdc *defaultPDControl) GetPDEtcdClient(namespace Namespace, tcName string,
 }
 
 // GetPDClient provides a PDClient of real pd cluster,if the PDClient not existing, it will create new one.
-func (pdc *defaultPDControl) GetPDClient(namespace Namespace, tcName string, tlsEnabled bool) PDClient {
+func (pdc *defaultPDControl) GetPDClient(namespace Namespace, tcName string, url string, tlsEnabled bool) PDClient {
 	pdc.mutex.Lock()
 	defer pdc.mutex.Unlock()
 
 	var tlsConfig *tls.Config
 	var err error
 	var scheme = "http"
-
 	if tlsEnabled {
 		scheme = "https"
-		tlsConfig, err = GetTLSConfig(pdc.kubeCli, namespace, tcName, nil)
+	}
+
+	key := pdClientKey(scheme, namespace, tcName)
+	cache, ok := pdc.caches[key]
+	if !ok {
+		cache = newResponseCache()
+		pdc.caches[key] = cache
+	}
+
+	if tlsEnabled {
+		tlsConfig, err = pdc.getTLSConfig(namespace, tcName)
 		if err != nil {
 			klog.Errorf("Unable to get tls config for tidb cluster %q, pd client may not work: %v", tcName, err)
-			return &pdClient{url: PdClientURL(namespace, tcName, scheme), httpClient: &http.Client{Timeout: DefaultTimeout}}
+			return &pdClient{url: url, httpClient: &http.Client{Timeout: DefaultTimeout}, cache: cache}
 		}
-
-		return NewPDClient(PdClientURL(namespace, tcName, scheme), DefaultTimeout, tlsConfig)
 	}
 
-	key := pdClientKey(scheme, namespace, tcName)
-	if _, ok := pdc.pdClients[key]; !ok {
-		pdc.pdClients[key] = NewPDClient(PdClientURL(namespace, tcName, scheme), DefaultTimeout, nil)
+	// the client of a tls cluster is rebuilt along with its tls config, so that the connections
+	// kept alive with the rotated certificates are dropped, and so is the client of a cluster
+	// whose pd service port is changed
+	client, ok := pdc.pdClients[key]
+	if cached, isPDClient := client.(*pdClient); !ok || (isPDClient && (cached.tlsConfig != tlsConfig || cached.url != url)) {
+		pdc.pdClients[key] = newPDClient(url, DefaultTimeout, tlsConfig, cache)
 	}
 	return pdc.pdClients[key]
 }
 
+// InvalidateResponseCache drops the responses cached by the PD clients of the tidb cluster
+func (pdc *defaultPDControl) InvalidateResponseCache(namespace Namespace, tcName string) {
+	pdc.mutex.Lock()
+	defer pdc.mutex.Unlock()
+
+	for _, scheme := range []string{"http", "https"} {
+		pdc.caches[pdClientKey(scheme, namespace, tcName)].invalidate()
+	}
+}
+
 // pdClientKey returns the pd client key
 func pdClientKey(scheme string, namespace Namespace, clusterName string) string {
 	return fmt.Sprintf("%s.%s.%s", scheme, clusterName, string(namespace))
@@ -138,11 +223,6 @@ func pdEtcdClientKey(namespace Namespace, clusterName string) string {
 	return fmt.Sprintf("%s.%s", clusterName, string(namespace))
 }
 
-// pdClientUrl builds the url of pd client
-func PdClientURL(namespace Namespace, clusterName string, scheme string) string {
-	return fmt.Sprintf("%s://%s-pd.%s:2379", scheme, clusterName, string(namespace))
-}
-
 func PDEtcdClientURL(namespace Namespace, clusterName string) string {
 	return fmt.Sprintf("%s-pd.%s:2379", clusterName, string(namespace))
 }
@@ -152,13 +232,13 @@ type PDClient interface {
 	// GetHealth returns the PD's health info
 	GetHealth() (*HealthInfo, error)
 	// GetConfig returns PD's config
-	GetConfig() (*PDConfigFromAPI, error)
+	GetConfig(opts ...GetOption) (*PDConfigFromAPI, error)
 	// GetCluster returns used when syncing pod labels.
 	GetCluster() (*metapb.Cluster, error)
 	// GetMembers returns all PD members from cluster
-	GetMembers() (*MembersInfo, error)
+	GetMembers(opts ...GetOption) (*MembersInfo, error)
 	// GetStores lists all TiKV stores from cluster
-	GetStores() (*StoresInfo, error)
+	GetStores(opts ...GetOption) (*StoresInfo, error)
 	// GetTombStoneStores lists all tombstone stores from cluster
 	GetTombStoneStores() (*StoresInfo, error)
 	// GetStore gets a TiKV store for a specific store id from cluster
@@ -183,6 +263,10 @@ type PDClient interface {
 	EndEvictLeader(storeID uint64) error
 	// GetEvictLeaderSchedulers gets schedulers of evict leader
 	GetEvictLeaderSchedulers() ([]string, error)
+	// GetOperatorCount returns the number of pending operators, e.g. the ones balancing regions
+	GetOperatorCount() (int, error)
+	// GetRegionCount returns the number of regions failing the check, e.g. the ones with down peers
+	GetRegionCount(check RegionsCheck) (int, error)
 	// GetPDLeader returns pd leader
 	GetPDLeader() (*pdpb.Member, error)
 	// TransferPDLeader transfers pd leader to specified member
@@ -200,22 +284,33 @@ var (
 	pdLeaderPrefix         = "pd/api/v1/leader"
 	pdLeaderTransferPrefix = "pd/api/v1/leader/transfer"
 	pdReplicationPrefix    = "pd/api/v1/config/replicate"
+	operatorsPrefix        = "pd/api/v1/operators"
+	regionsCheckPrefix     = "pd/api/v1/regions/check"
 )
 
 // pdClient is default implementation of PDClient
 type pdClient struct {
 	url        string
 	httpClient *http.Client
+	cache      *responseCache
+	// tlsConfig is the tls config the client is built with, nil if tls is not enabled
+	tlsConfig *tls.Config
 }
 
 // NewPDClient returns a new PDClient
 func NewPDClient(url string, timeout time.Duration, tlsConfig *tls.Config) PDClient {
+	return newPDClient(url, timeout, tlsConfig, newResponseCache())
+}
+
+func newPDClient(url string, timeout time.Duration, tlsConfig *tls.Config, cache *responseCache) *pdClient {
 	return &pdClient{
 		url: url,
 		httpClient: &http.Client{
 			Timeout:   timeout,
 			Transport: &http.Transport{TLSClientConfig: tlsConfig},
 		},
+		cache:     cache,
+		tlsConfig: tlsConfig,
 	}
 }
 
@@ -278,6 +373,21 @@ type MembersInfo struct {
 	EtcdLeader *pdpb.Member         `json:"etcd_leader,omitempty"`
 }
 
+// RegionsCheck is a check of the regions PD reports the regions failing it of
+type RegionsCheck string
+
+const (
+	// RegionsCheckDownPeer finds the regions with peers on stores which are down
+	RegionsCheckDownPeer RegionsCheck = "down-peer"
+	// RegionsCheckPendingPeer finds the regions with peers whose raft logs fall behind
+	RegionsCheckPendingPeer RegionsCheck = "pending-peer"
+)
+
+// RegionsInfo is the regions info returned from PD RESTful interface
+type RegionsInfo struct {
+	Count int `json:"count"`
+}
+
 type schedulerInfo struct {
 	Name    string `json:"name"`
 	StoreID uint64 `json:"store_id"`
@@ -299,9 +409,11 @@ func (pc *pdClient) GetHealth() (*HealthInfo, error) {
 	}, nil
 }
 
-func (pc *pdClient) GetConfig() (*PDConfigFromAPI, error) {
+func (pc *pdClient) GetConfig(opts ...GetOption) (*PDConfigFromAPI, error) {
 	apiURL := fmt.Sprintf("%s/%s", pc.url, configPrefix)
-	body, err := httputil.GetBodyOK(pc.httpClient, apiURL)
+	body, err := pc.cache.getBody("config", func() ([]byte, error) {
+		return httputil.GetBodyOK(pc.httpClient, apiURL)
+	}, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -327,9 +439,11 @@ func (pc *pdClient) GetCluster() (*metapb.Cluster, error) {
 	return cluster, nil
 }
 
-func (pc *pdClient) GetMembers() (*MembersInfo, error) {
+func (pc *pdClient) GetMembers(opts ...GetOption) (*MembersInfo, error) {
 	apiURL := fmt.Sprintf("%s/%s", pc.url, membersPrefix)
-	body, err := httputil.GetBodyOK(pc.httpClient, apiURL)
+	body, err := pc.cache.getBody("members", func() ([]byte, error) {
+		return httputil.GetBodyOK(pc.httpClient, apiURL)
+	}, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -341,9 +455,11 @@ func (pc *pdClient) GetMembers() (*MembersInfo, error) {
 	return members, nil
 }
 
-func (pc *pdClient) GetStores() (*StoresInfo, error) {
+func (pc *pdClient) GetStores(opts ...GetOption) (*StoresInfo, error) {
 	apiURL := fmt.Sprintf("%s/%s", pc.url, storesPrefix)
-	body, err := httputil.GetBodyOK(pc.httpClient, apiURL)
+	body, err := pc.cache.getBody("stores", func() ([]byte, error) {
+		return httputil.GetBodyOK(pc.httpClient, apiURL)
+	}, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -384,8 +500,9 @@ func (pc *pdClient) GetStore(storeID uint64) (*StoreInfo, error) {
 }
 
 func (pc *pdClient) DeleteStore(storeID uint64) error {
+	defer pc.cache.invalidate()
 	var exist bool
-	stores, err := pc.GetStores()
+	stores, err := pc.GetStores(NoCache)
 	if err != nil {
 		return err
 	}
@@ -423,6 +540,7 @@ func (pc *pdClient) DeleteStore(storeID uint64) error {
 
 // SetStoreState sets store to specified state.
 func (pc *pdClient) SetStoreState(storeID uint64, state string) error {
+	defer pc.cache.invalidate()
 	apiURL := fmt.Sprintf("%s/%s/%d/state?state=%s", pc.url, storePrefix, storeID, state)
 	req, err := http.NewRequest("POST", apiURL, nil)
 	if err != nil {
@@ -446,8 +564,9 @@ func (pc *pdClient) SetStoreState(storeID uint64, state string) error {
 }
 
 func (pc *pdClient) DeleteMemberByID(memberID uint64) error {
+	defer pc.cache.invalidate()
 	var exist bool
-	members, err := pc.GetMembers()
+	members, err := pc.GetMembers(NoCache)
 	if err != nil {
 		return err
 	}
@@ -478,8 +597,9 @@ func (pc *pdClient) DeleteMemberByID(memberID uint64) error {
 }
 
 func (pc *pdClient) DeleteMember(name string) error {
+	defer pc.cache.invalidate()
 	var exist bool
-	members, err := pc.GetMembers()
+	members, err := pc.GetMembers(NoCache)
 	if err != nil {
 		return err
 	}
@@ -510,6 +630,7 @@ func (pc *pdClient) DeleteMember(name string) error {
 }
 
 func (pc *pdClient) SetStoreLabels(storeID uint64, labels map[string]string) (bool, error) {
+	defer pc.cache.invalidate()
 	apiURL := fmt.Sprintf("%s/%s/%d/label", pc.url, storePrefix, storeID)
 	data, err := json.Marshal(labels)
 	if err != nil {
@@ -528,6 +649,7 @@ func (pc *pdClient) SetStoreLabels(storeID uint64, labels map[string]string) (bo
 }
 
 func (pc *pdClient) UpdateReplicationConfig(config PDReplicationConfig) error {
+	defer pc.cache.invalidate()
 	apiURL := fmt.Sprintf("%s/%s", pc.url, pdReplicationPrefix)
 	data, err := json.Marshal(config)
 	if err != nil {
@@ -546,6 +668,7 @@ func (pc *pdClient) UpdateReplicationConfig(config PDReplicationConfig) error {
 }
 
 func (pc *pdClient) BeginEvictLeader(storeID uint64) error {
+	defer pc.cache.invalidate()
 	leaderEvictInfo := getLeaderEvictSchedulerInfo(storeID)
 	apiURL := fmt.Sprintf("%s/%s", pc.url, schedulersPrefix)
 	data, err := json.Marshal(leaderEvictInfo)
@@ -583,6 +706,7 @@ func (pc *pdClient) BeginEvictLeader(storeID uint64) error {
 }
 
 func (pc *pdClient) EndEvictLeader(storeID uint64) error {
+	defer pc.cache.invalidate()
 	sName := getLeaderEvictSchedulerStr(storeID)
 	apiURL := fmt.Sprintf("%s/%s/%s", pc.url, schedulersPrefix, sName)
 	req, err := http.NewRequest("DELETE", apiURL, nil)
@@ -644,6 +768,34 @@ func (pc *pdClient) GetEvictLeaderSchedulers() ([]string, error) {
 	return evicts, nil
 }
 
+func (pc *pdClient) GetOperatorCount() (int, error) {
+	apiURL := fmt.Sprintf("%s/%s", pc.url, operatorsPrefix)
+	body, err := httputil.GetBodyOK(pc.httpClient, apiURL)
+	if err != nil {
+		return 0, err
+	}
+	operators := []json.RawMessage{}
+	err = json.Unmarshal(body, &operators)
+	if err != nil {
+		return 0, err
+	}
+	return len(operators), nil
+}
+
+func (pc *pdClient) GetRegionCount(check RegionsCheck) (int, error) {
+	apiURL := fmt.Sprintf("%s/%s/%s", pc.url, regionsCheckPrefix, check)
+	body, err := httputil.GetBodyOK(pc.httpClient, apiURL)
+	if err != nil {
+		return 0, err
+	}
+	regions := &RegionsInfo{}
+	err = json.Unmarshal(body, regions)
+	if err != nil {
+		return 0, err
+	}
+	return regions.Count, nil
+}
+
 func (pc *pdClient) GetPDLeader() (*pdpb.Member, error) {
 	apiURL := fmt.Sprintf("%s/%s", pc.url, pdLeaderPrefix)
 	body, err := httputil.GetBodyOK(pc.httpClient, apiURL)
@@ -659,6 +811,7 @@ func (pc *pdClient) GetPDLeader() (*pdpb.Member, error) {
 }
 
 func (pc *pdClient) TransferPDLeader(memberName string) error {
+	defer pc.cache.invalidate()
 	apiURL := fmt.Sprintf("%s/%s/%s", pc.url, pdLeaderTransferPrefix, memberName)
 	req, err := http.NewRequest("POST", apiURL, nil)
 	if err != nil {
@@ -701,17 +854,33 @@ func getLeaderEvictSchedulerStr(storeID uint64) string {
 	return fmt.Sprintf("%s-%d", "evict-leader-scheduler", storeID)
 }
 
+// GetEvictLeaderSchedulerStoreID returns the ID of the store whose leaders are evicted by the
+// scheduler, ok is false if the name is not one of an evict leader scheduler of a single store
+func GetEvictLeaderSchedulerStoreID(scheduler string) (storeID uint64, ok bool) {
+	if !strings.HasPrefix(scheduler, "evict-leader-scheduler-") {
+		return 0, false
+	}
+	storeID, err := strconv.ParseUint(strings.TrimPrefix(scheduler, "evict-leader-scheduler-"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return storeID, true
+}
+
 type FakePDControl struct {
 	defaultPDControl
 }
 
 func NewFakePDControl(kubeCli kubernetes.Interface) *FakePDControl {
 	return &FakePDControl{
-		defaultPDControl{kubeCli: kubeCli, pdClients: map[string]PDClient{}},
+		defaultPDControl{kubeCli: kubeCli, pdClients: map[string]PDClient{}, caches: map[string]*responseCache{}, tlsConfigs: map[string]*cachedTLSConfig{}},
 	}
 }
 
 func (fpc *FakePDControl) SetPDClient(namespace Namespace, tcName string, pdclient PDClient) {
+	// the clients may be set while a controller running against the fake gets them
+	fpc.defaultPDControl.mutex.Lock()
+	defer fpc.defaultPDControl.mutex.Unlock()
 	fpc.defaultPDControl.pdClients[pdClientKey("http", namespace, tcName)] = pdclient
 }
 
@@ -734,6 +903,8 @@ const (
 	BeginEvictLeaderActionType         ActionType = "BeginEvictLeader"
 	EndEvictLeaderActionType           ActionType = "EndEvictLeader"
 	GetEvictLeaderSchedulersActionType ActionType = "GetEvictLeaderSchedulers"
+	GetOperatorCountActionType         ActionType = "GetOperatorCount"
+	GetRegionCountActionType           ActionType = "GetRegionCount"
 	GetPDLeaderActionType              ActionType = "GetPDLeader"
 	TransferPDLeaderActionType         ActionType = "TransferPDLeader"
 )
@@ -751,6 +922,8 @@ type Action struct {
 	Name        string
 	Labels      map[string]string
 	Replication PDReplicationConfig
+	// NoCache is whether the read bypasses the response cache
+	NoCache bool
 }
 
 type Reaction func(action *Action) (interface{}, error)
@@ -788,8 +961,8 @@ func (pc *FakePDClient) GetHealth() (*HealthInfo, error) {
 	return result.(*HealthInfo), nil
 }
 
-func (pc *FakePDClient) GetConfig() (*PDConfigFromAPI, error) {
-	action := &Action{}
+func (pc *FakePDClient) GetConfig(opts ...GetOption) (*PDConfigFromAPI, error) {
+	action := &Action{NoCache: newGetOptions(opts).noCache}
 	result, err := pc.fakeAPI(GetConfigActionType, action)
 	if err != nil {
 		return nil, err
@@ -806,8 +979,8 @@ func (pc *FakePDClient) GetCluster() (*metapb.Cluster, error) {
 	return result.(*metapb.Cluster), nil
 }
 
-func (pc *FakePDClient) GetMembers() (*MembersInfo, error) {
-	action := &Action{}
+func (pc *FakePDClient) GetMembers(opts ...GetOption) (*MembersInfo, error) {
+	action := &Action{NoCache: newGetOptions(opts).noCache}
 	result, err := pc.fakeAPI(GetMembersActionType, action)
 	if err != nil {
 		return nil, err
@@ -815,8 +988,8 @@ func (pc *FakePDClient) GetMembers() (*MembersInfo, error) {
 	return result.(*MembersInfo), nil
 }
 
-func (pc *FakePDClient) GetStores() (*StoresInfo, error) {
-	action := &Action{}
+func (pc *FakePDClient) GetStores(opts ...GetOption) (*StoresInfo, error) {
+	action := &Action{NoCache: newGetOptions(opts).noCache}
 	result, err := pc.fakeAPI(GetStoresActionType, action)
 	if err != nil {
 		return nil, err
@@ -885,7 +1058,10 @@ func (pc *FakePDClient) SetStoreLabels(storeID uint64, labels map[string]string)
 	if reaction, ok := pc.reactions[SetStoreLabelsActionType]; ok {
 		action := &Action{ID: storeID, Labels: labels}
 		result, err := reaction(action)
-		return result.(bool), err
+		if err != nil {
+			return false, err
+		}
+		return result.(bool), nil
 	}
 	return true, nil
 }
@@ -922,16 +1098,46 @@ func (pc *FakePDClient) GetEvictLeaderSchedulers() ([]string, error) {
 	if reaction, ok := pc.reactions[GetEvictLeaderSchedulersActionType]; ok {
 		action := &Action{}
 		result, err := reaction(action)
-		return result.([]string), err
+		if err != nil {
+			return nil, err
+		}
+		return result.([]string), nil
 	}
 	return nil, nil
 }
 
+func (pc *FakePDClient) GetOperatorCount() (int, error) {
+	if reaction, ok := pc.reactions[GetOperatorCountActionType]; ok {
+		action := &Action{}
+		result, err := reaction(action)
+		if err != nil {
+			return 0, err
+		}
+		return result.(int), nil
+	}
+	return 0, nil
+}
+
+func (pc *FakePDClient) GetRegionCount(check RegionsCheck) (int, error) {
+	if reaction, ok := pc.reactions[GetRegionCountActionType]; ok {
+		action := &Action{Name: string(check)}
+		result, err := reaction(action)
+		if err != nil {
+			return 0, err
+		}
+		return result.(int), nil
+	}
+	return 0, nil
+}
+
 func (pc *FakePDClient) GetPDLeader() (*pdpb.Member, error) {
 	if reaction, ok := pc.reactions[GetPDLeaderActionType]; ok {
 		action := &Action{}
 		result, err := reaction(action)
-		return result.(*pdpb.Member), err
+		if err != nil {
+			return nil, err
+		}
+		return result.(*pdpb.Member), nil
 	}
 	return nil, nil
 }
@@ -14,18 +14,31 @@
 package pdapi
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	. "github.com/onsi/gomega"
 	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/kvproto/pkg/pdpb"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 )
 
 const (
@@ -306,6 +319,46 @@ func TestGetStore(t *testing.T) {
 	}
 }
 
+func TestGetRegionCount(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tcs := []struct {
+		caseName string
+		check    RegionsCheck
+		path     string
+		resp     []byte
+		want     int
+	}{{
+		caseName: "down peer",
+		check:    RegionsCheckDownPeer,
+		path:     fmt.Sprintf("/%s/down-peer", regionsCheckPrefix),
+		resp:     []byte(`{"count":2,"regions":[{"id":2},{"id":4}]}`),
+		want:     2,
+	}, {
+		caseName: "pending peer",
+		check:    RegionsCheckPendingPeer,
+		path:     fmt.Sprintf("/%s/pending-peer", regionsCheckPrefix),
+		resp:     []byte(`{"count":0,"regions":null}`),
+		want:     0,
+	}}
+
+	for _, tc := range tcs {
+		svc := getClientServer(func(w http.ResponseWriter, request *http.Request) {
+			g.Expect(request.Method).To(Equal("GET"), "test method")
+			g.Expect(request.URL.Path).To(Equal(tc.path), "test url")
+
+			w.Header().Set("Content-Type", ContentTypeJSON)
+			w.Write(tc.resp)
+		})
+		defer svc.Close()
+
+		pdClient := NewPDClient(svc.URL, DefaultTimeout, &tls.Config{})
+		result, err := pdClient.GetRegionCount(tc.check)
+		g.Expect(err).NotTo(HaveOccurred(), tc.caseName)
+		g.Expect(result).To(Equal(tc.want), tc.caseName)
+	}
+}
+
 func TestSetStoreLabels(t *testing.T) {
 	g := NewGomegaWithT(t)
 	id := uint64(1)
@@ -634,6 +687,122 @@ func TestDeleteStore(t *testing.T) {
 	}
 }
 
+func TestGetEvictLeaderSchedulerStoreID(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tests := []struct {
+		scheduler string
+		storeID   uint64
+		ok        bool
+	}{
+		{scheduler: "evict-leader-scheduler-1", storeID: 1, ok: true},
+		{scheduler: getLeaderEvictSchedulerStr(42), storeID: 42, ok: true},
+		{scheduler: "evict-leader-scheduler"},
+		{scheduler: "evict-leader-scheduler-foo"},
+		{scheduler: "balance-leader-scheduler"},
+	}
+	for _, tt := range tests {
+		storeID, ok := GetEvictLeaderSchedulerStoreID(tt.scheduler)
+		g.Expect(ok).To(Equal(tt.ok), tt.scheduler)
+		g.Expect(storeID).To(Equal(tt.storeID), tt.scheduler)
+	}
+}
+
+func TestFakePDClientReactionError(t *testing.T) {
+	g := NewGomegaWithT(t)
+	pdClient := NewFakePDClient()
+	failed := func(action *Action) (interface{}, error) {
+		return nil, fmt.Errorf("pd is unavailable")
+	}
+	for _, actionType := range []ActionType{SetStoreLabelsActionType, GetEvictLeaderSchedulersActionType, GetOperatorCountActionType, GetPDLeaderActionType} {
+		pdClient.AddReaction(actionType, failed)
+	}
+
+	_, err := pdClient.SetStoreLabels(1, map[string]string{"zone": "a"})
+	g.Expect(err).To(HaveOccurred())
+	_, err = pdClient.GetEvictLeaderSchedulers()
+	g.Expect(err).To(HaveOccurred())
+	_, err = pdClient.GetOperatorCount()
+	g.Expect(err).To(HaveOccurred())
+	_, err = pdClient.GetPDLeader()
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestGetPDClientTLSConfigRotation(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	pdControl := NewDefaultPDControlWithSecretLister(kubefake.NewSimpleClientset(), corelisters.NewSecretLister(indexer))
+	httpsURL, httpURL := "https://demo-pd.default.svc:2379", "http://demo-pd.default.svc:2379"
+
+	// the secret is not issued yet
+	httpsClient := pdControl.GetPDClient(Namespace(corev1.NamespaceDefault), "demo", httpsURL, true).(*pdClient)
+	g.Expect(httpsClient.tlsConfig).To(BeNil())
+
+	g.Expect(indexer.Add(newClientTLSSecret(t, "1"))).To(Succeed())
+	httpsClient = pdControl.GetPDClient(Namespace(corev1.NamespaceDefault), "demo", httpsURL, true).(*pdClient)
+	g.Expect(httpsClient.url).To(Equal(httpsURL))
+	g.Expect(httpsClient.tlsConfig).NotTo(BeNil())
+	g.Expect(httpsClient.tlsConfig.Certificates).To(HaveLen(1))
+	// cached until the secret changes
+	g.Expect(pdControl.GetPDClient(Namespace(corev1.NamespaceDefault), "demo", httpsURL, true)).To(BeIdenticalTo(httpsClient))
+
+	// the certificates are rotated
+	rotated := newClientTLSSecret(t, "2")
+	g.Expect(indexer.Update(rotated)).To(Succeed())
+	rotatedClient := pdControl.GetPDClient(Namespace(corev1.NamespaceDefault), "demo", httpsURL, true).(*pdClient)
+	g.Expect(rotatedClient).NotTo(BeIdenticalTo(httpsClient))
+	g.Expect(rotatedClient.tlsConfig.Certificates[0].Certificate).To(Equal([][]byte{mustDecodePEM(rotated.Data[corev1.TLSCertKey])}))
+	// the responses cached are shared by the clients rebuilt
+	g.Expect(rotatedClient.cache).To(BeIdenticalTo(httpsClient.cache))
+
+	// the clusters without tls speak plain http
+	httpClient := pdControl.GetPDClient(Namespace(corev1.NamespaceDefault), "demo", httpURL, false).(*pdClient)
+	g.Expect(httpClient.url).To(Equal(httpURL))
+	g.Expect(httpClient.tlsConfig).To(BeNil())
+
+	// the client is rebuilt once the port of the pd service is changed
+	g.Expect(pdControl.GetPDClient(Namespace(corev1.NamespaceDefault), "demo", "http://demo-pd.default.svc:12379", false).(*pdClient).url).To(Equal("http://demo-pd.default.svc:12379"))
+}
+
+// newClientTLSSecret returns the client secret of the cluster demo with a self-signed certificate
+func newClientTLSSecret(t *testing.T, resourceVersion string) *corev1.Secret {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "demo-discovery"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "demo-cluster-client-secret",
+			Namespace:       corev1.NamespaceDefault,
+			ResourceVersion: resourceVersion,
+		},
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		},
+	}
+}
+
+func mustDecodePEM(data []byte) []byte {
+	block, _ := pem.Decode(data)
+	return block.Bytes
+}
+
 func readJSON(r io.ReadCloser, data interface{}) error {
 	defer r.Close()
 
@@ -33,6 +33,7 @@ func (TikvClusterStrategy) NewObject() runtime.Object {
 
 func (TikvClusterStrategy) PrepareForCreate(ctx context.Context, obj runtime.Object) {
 	if tc, ok := castTikvCluster(obj); ok {
+		// the warnings are reported by the controller as events on the cluster
 		defaulting.SetTikvClusterDefault(tc)
 	}
 }
@@ -14,9 +14,19 @@
 package tikvcluster
 
 import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/label"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const (
@@ -30,8 +40,68 @@ const (
 	PDUnhealthy = "PDUnhealthy"
 	// TiKVStoreNotUp is added when one of tikv stores is not up.
 	TiKVStoreNotUp = "TiKVStoreNotUp"
+	// TiKVBelowMaxReplicas is added when scaling in tikv would leave fewer stores than the max-replicas of pd.
+	TiKVBelowMaxReplicas = "TiKVBelowMaxReplicas"
+	// TiKVScaleInAllowed is added when a previously blocked tikv scale in is no longer blocked.
+	TiKVScaleInAllowed = "TiKVScaleInAllowed"
+	// ConfigMapNotFound is added when the ConfigMap referenced by spec.tikv.configRef does not exist.
+	ConfigMapNotFound = "ConfigMapNotFound"
+	// ConfigMapKeyNotFound is added when the key referenced by spec.tikv.configRef does not exist in the ConfigMap.
+	ConfigMapKeyNotFound = "ConfigMapKeyNotFound"
+	// ConfigRefFound is added when a previously missing spec.tikv.configRef exists.
+	ConfigRefFound = "ConfigRefFound"
+	// PDFailoverLimitReached is added when a pd member is not failed over because spec.pd.maxFailoverCount is reached.
+	PDFailoverLimitReached = "PDFailoverLimitReached"
+	// TiKVFailoverLimitReached is added when a tikv store is not failed over because spec.tikv.maxFailoverCount is reached.
+	TiKVFailoverLimitReached = "TiKVFailoverLimitReached"
+	// FailoverLimitNotReached is added when the failure members of pd and tikv are back under their maxFailoverCount.
+	FailoverLimitNotReached = "FailoverLimitNotReached"
+	// ZoneOutage is added when the tikv stores of a zone down exceed spec.tikv.failover.zoneOutageThreshold.
+	ZoneOutage = "ZoneOutage"
+	// NoZoneOutage is added when no zone is suspected to be out any more.
+	NoZoneOutage = "NoZoneOutage"
+	// TiKVPodNotReady is added when the tikv pod upgraded last is not ready within spec.tikv.upgradeStallTimeout.
+	TiKVPodNotReady = "TiKVPodNotReady"
+	// UpgradeNotStalled is added when a stalled upgrade goes on or is not needed any more.
+	UpgradeNotStalled = "UpgradeNotStalled"
+	// PVCTerminating is added when the PVC of a pod to be created is terminating longer than the operator waits for it.
+	PVCTerminating = "PVCTerminating"
+	// PVCNotTerminating is added when no PVC of the pods to be created is stuck terminating any more.
+	PVCNotTerminating = "PVCNotTerminating"
+	// StatefulSetModified is added when the statefulset of a component is modified bypassing the operator.
+	StatefulSetModified = "StatefulSetModified"
+	// StatefulSetNotModified is added when no statefulset is modified bypassing the operator any more.
+	StatefulSetNotModified = "StatefulSetNotModified"
+	// TLSSecretNotFound is added when the cluster TLS secret of pd or tikv does not exist.
+	TLSSecretNotFound = "TLSSecretNotFound"
+	// TLSSecretMalformed is added when the certificates or the key in a cluster TLS secret can not be parsed.
+	TLSSecretMalformed = "TLSSecretMalformed"
+	// TLSKeyMismatch is added when the key in a cluster TLS secret does not match its certificate.
+	TLSKeyMismatch = "TLSKeyMismatch"
+	// TLSCertUntrusted is added when the certificate in a cluster TLS secret does not chain to its CA.
+	TLSCertUntrusted = "TLSCertUntrusted"
+	// TLSCertNotValidYet is added when the certificate in a cluster TLS secret is not valid yet.
+	TLSCertNotValidYet = "TLSCertNotValidYet"
+	// TLSCertExpired is added when the certificate in a cluster TLS secret has expired.
+	TLSCertExpired = "TLSCertExpired"
+	// TLSCertNameMismatch is added when the certificate in a cluster TLS secret does not cover a DNS name of the members.
+	TLSCertNameMismatch = "TLSCertNameMismatch"
+	// TLSSecretValid is added when the cluster TLS secrets of pd and tikv are valid again.
+	TLSSecretValid = "TLSSecretValid"
+	// ManagerSyncFailed is added when a manager syncing the cluster failed.
+	ManagerSyncFailed = "SyncFailed"
+	// ManagerDependencyFailed is added when a manager is not run because a manager it depends on failed.
+	ManagerDependencyFailed = "DependencyFailed"
+	// ManagerSynced is added when a previously failed manager synced the cluster.
+	ManagerSynced = "Synced"
 )
 
+// ManagerSyncedConditionType returns the type of the condition reporting the failures of the
+// manager syncing the cluster, e.g. PDSynced.
+func ManagerSyncedConditionType(manager string) v1alpha1.TikvClusterConditionType {
+	return v1alpha1.TikvClusterConditionType(manager + "Synced")
+}
+
 // NewTikvClusterCondition creates a new tikvcluster condition.
 func NewTikvClusterCondition(condType v1alpha1.TikvClusterConditionType, status v1.ConditionStatus, reason, message string) *v1alpha1.TikvClusterCondition {
 	return &v1alpha1.TikvClusterCondition{
@@ -87,3 +157,66 @@ func filterOutCondition(conditions []v1alpha1.TikvClusterCondition, condType v1a
 func GetTikvClusterReadyCondition(status v1alpha1.TikvClusterStatus) *v1alpha1.TikvClusterCondition {
 	return GetTikvClusterCondition(status, v1alpha1.TikvClusterReady)
 }
+
+// WaitForCondition polls the tikv cluster of key every interval until its condition of condType
+// has the given status. It returns nil once the condition matches and the error of ctx once ctx
+// is done, the errors getting the tikv cluster, e.g. the cluster not created yet, are retried.
+func WaitForCondition(ctx context.Context, cli client.Client, key client.ObjectKey, condType string, status v1.ConditionStatus, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		tc := &v1alpha1.TikvCluster{}
+		if err := cli.Get(ctx, key, tc); err != nil {
+			klog.V(4).Infof("failed to get tikv cluster %s while waiting for condition %s: %v", key, condType, err)
+		} else if cond := GetTikvClusterCondition(tc.Status, v1alpha1.TikvClusterConditionType(condType)); cond != nil && cond.Status == status {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// OrphanedPVCs returns the PVCs of the members of type t of the tikv cluster whose ordinal is not
+// below desiredReplicas, i.e. the PVCs kept after the members are scaled in, ordered by ordinal.
+// The ordinal is parsed from the suffix of the PVC name, the PVCs without one are skipped.
+func OrphanedPVCs(ctx context.Context, cli client.Client, tc *v1alpha1.TikvCluster, t v1alpha1.MemberType, desiredReplicas int32) ([]v1.PersistentVolumeClaim, error) {
+	l := label.New().Instance(tc.GetInstanceName()).Component(t.String())
+	pvcList := &v1.PersistentVolumeClaimList{}
+	if err := cli.List(ctx, pvcList, client.InNamespace(tc.GetNamespace()), client.MatchingLabels(l)); err != nil {
+		return nil, fmt.Errorf("failed to list the pvcs of %s of tikv cluster %s/%s: %v", t, tc.GetNamespace(), tc.GetName(), err)
+	}
+	var orphaned []v1.PersistentVolumeClaim
+	ordinals := map[string]int32{}
+	for _, pvc := range pvcList.Items {
+		ordinal, ok := pvcOrdinal(pvc.GetName())
+		if !ok {
+			klog.V(4).Infof("pvc %s/%s of tikv cluster %s has no ordinal, skip it", pvc.GetNamespace(), pvc.GetName(), tc.GetName())
+			continue
+		}
+		if ordinal >= desiredReplicas {
+			orphaned = append(orphaned, pvc)
+			ordinals[pvc.GetName()] = ordinal
+		}
+	}
+	sort.Slice(orphaned, func(i, j int) bool {
+		return ordinals[orphaned[i].GetName()] < ordinals[orphaned[j].GetName()]
+	})
+	return orphaned, nil
+}
+
+// pvcOrdinal returns the ordinal suffix of the name of a PVC of a statefulset,
+// i.e. <volume>-<statefulset>-<ordinal>
+func pvcOrdinal(name string) (int32, bool) {
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return 0, false
+	}
+	ordinal, err := strconv.ParseInt(name[i+1:], 10, 32)
+	if err != nil || ordinal < 0 {
+		return 0, false
+	}
+	return int32(ordinal), true
+}
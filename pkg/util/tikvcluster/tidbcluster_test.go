@@ -0,0 +1,119 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikvcluster
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/label"
+	"github.com/tikv/tikv-operator/pkg/scheme"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// flippingClient sets the Ready condition of the tikv cluster to True on the get of the given count
+type flippingClient struct {
+	client.Client
+	gets   int
+	flipAt int
+}
+
+func (c *flippingClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	c.gets++
+	if c.gets == c.flipAt {
+		tc := &v1alpha1.TikvCluster{}
+		if err := c.Client.Get(ctx, key, tc); err != nil {
+			return err
+		}
+		SetTikvClusterCondition(&tc.Status, *NewTikvClusterCondition(v1alpha1.TikvClusterReady, v1.ConditionTrue, Ready, ""))
+		if err := c.Client.Update(ctx, tc); err != nil {
+			return err
+		}
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func TestWaitForCondition(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := &v1alpha1.TikvCluster{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}}
+	SetTikvClusterCondition(&tc.Status, *NewTikvClusterCondition(v1alpha1.TikvClusterReady, v1.ConditionFalse, PDUnhealthy, ""))
+	key := client.ObjectKey{Namespace: "default", Name: "test"}
+
+	// the condition flips on the third poll
+	cli := &flippingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme, tc.DeepCopy()), flipAt: 3}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	g.Expect(WaitForCondition(ctx, cli, key, string(v1alpha1.TikvClusterReady), v1.ConditionTrue, time.Millisecond)).To(Succeed())
+	g.Expect(cli.gets).To(Equal(3))
+
+	// the condition never matches
+	cli = &flippingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme, tc.DeepCopy())}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := WaitForCondition(ctx, cli, key, string(v1alpha1.TikvClusterReady), v1.ConditionTrue, time.Millisecond)
+	g.Expect(err).To(Equal(context.DeadlineExceeded))
+	g.Expect(cli.gets).To(BeNumerically(">", 1))
+
+	// the cluster does not exist
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = WaitForCondition(ctx, fake.NewFakeClientWithScheme(scheme.Scheme), key, string(v1alpha1.TikvClusterReady), v1.ConditionTrue, time.Millisecond)
+	g.Expect(err).To(Equal(context.DeadlineExceeded))
+}
+
+func TestOrphanedPVCs(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tc := &v1alpha1.TikvCluster{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}}
+	newPVC := func(name string, l label.Label) runtime.Object {
+		return &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: l}}
+	}
+	var objs []runtime.Object
+	// listed in reverse order to check the ordering by ordinal
+	for i := 5; i >= 0; i-- {
+		objs = append(objs, newPVC(fmt.Sprintf("tikv-test-tikv-%d", i), label.New().Instance("test").TiKV()))
+	}
+	objs = append(objs,
+		newPVC("pd-test-pd-4", label.New().Instance("test").PD()),
+		newPVC("tikv-other-tikv-4", label.New().Instance("other").TiKV()),
+		newPVC("tikv-test-tikv-backup", label.New().Instance("test").TiKV()),
+	)
+	cli := fake.NewFakeClientWithScheme(scheme.Scheme, objs...)
+
+	pvcs, err := OrphanedPVCs(context.TODO(), cli, tc, v1alpha1.TiKVMemberType, 3)
+	g.Expect(err).NotTo(HaveOccurred())
+	var names []string
+	for _, pvc := range pvcs {
+		names = append(names, pvc.Name)
+	}
+	g.Expect(names).To(Equal([]string{"tikv-test-tikv-3", "tikv-test-tikv-4", "tikv-test-tikv-5"}))
+
+	pvcs, err = OrphanedPVCs(context.TODO(), cli, tc, v1alpha1.PDMemberType, 3)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(pvcs).To(HaveLen(1))
+	g.Expect(pvcs[0].Name).To(Equal("pd-test-pd-4"))
+
+	pvcs, err = OrphanedPVCs(context.TODO(), cli, tc, v1alpha1.TiKVMemberType, 6)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(pvcs).To(BeEmpty())
+}
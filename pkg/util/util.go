@@ -83,6 +83,9 @@ func GetPodOrdinals(tc *v1alpha1.TikvCluster, memberType v1alpha1.MemberType) (s
 		return nil, fmt.Errorf("unknown member type %v", memberType)
 	}
 	deleteSlots := getDeleteSlots(tc, ann)
+	if memberType == v1alpha1.TiKVMemberType {
+		deleteSlots.Insert(tc.TiKVOfflineStoreOrdinals().List()...)
+	}
 	maxReplicaCount, deleteSlots := helper.GetMaxReplicaCountAndDeleteSlots(replicas, deleteSlots)
 	podOrdinals := sets.NewInt32()
 	for i := int32(0); i < maxReplicaCount; i++ {
@@ -97,6 +100,32 @@ func OrdinalPVCName(memberType v1alpha1.MemberType, setName string, ordinal int3
 	return fmt.Sprintf("%s-%s-%d", memberType, setName, ordinal)
 }
 
+// SetPVCReclaimAnnotation marks the pvc for the local volume provisioner to retain or delete the
+// disk backing it once it is deleted
+func SetPVCReclaimAnnotation(pvc *corev1.PersistentVolumeClaim, retain bool) {
+	if pvc.Annotations == nil {
+		pvc.Annotations = map[string]string{}
+	}
+	if retain {
+		pvc.Annotations[label.AnnPVCReclaimKey] = label.AnnPVCReclaimRetainVal
+	} else {
+		pvc.Annotations[label.AnnPVCReclaimKey] = label.AnnPVCReclaimDeleteVal
+	}
+}
+
+// GetPVCReclaimPolicy returns whether the disk backing the pvc is retained once it is deleted, the
+// second value is false if the pvc is not marked or marked with an unknown value
+func GetPVCReclaimPolicy(pvc *corev1.PersistentVolumeClaim) (bool, bool) {
+	switch pvc.Annotations[label.AnnPVCReclaimKey] {
+	case label.AnnPVCReclaimRetainVal:
+		return true, true
+	case label.AnnPVCReclaimDeleteVal:
+		return false, true
+	default:
+		return false, false
+	}
+}
+
 // IsSubMapOf returns whether the first map is a sub map of the second map
 func IsSubMapOf(first map[string]string, second map[string]string) bool {
 	for k, v := range first {
@@ -138,6 +167,13 @@ func ClusterClientTLSSecretName(tcName string) string {
 	return fmt.Sprintf("%s-cluster-client-secret", tcName)
 }
 
+// ScrapeTLSSecretName returns the name of the client certificate copied to the namespace of
+// Prometheus, the namespace of the cluster is part of it as the clusters of several namespaces
+// may export their certificates to the same namespace
+func ScrapeTLSSecretName(ns, tcName string) string {
+	return fmt.Sprintf("%s-%s-scrape-tls", ns, tcName)
+}
+
 func ClusterTLSSecretName(tcName, component string) string {
 	return fmt.Sprintf("%s-%s-cluster-secret", tcName, component)
 }
@@ -37,6 +37,32 @@ func TestGetOrdinalFromPodName(t *testing.T) {
 	g.Expect(i).To(Equal(int32(0)))
 }
 
+func TestPVCReclaimAnnotation(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	retain, ok := GetPVCReclaimPolicy(pvc)
+	g.Expect(ok).To(BeFalse())
+	g.Expect(retain).To(BeFalse())
+
+	SetPVCReclaimAnnotation(pvc, true)
+	g.Expect(pvc.Annotations).To(HaveKeyWithValue(label.AnnPVCReclaimKey, "retain"))
+	retain, ok = GetPVCReclaimPolicy(pvc)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(retain).To(BeTrue())
+
+	SetPVCReclaimAnnotation(pvc, false)
+	g.Expect(pvc.Annotations).To(HaveKeyWithValue(label.AnnPVCReclaimKey, "delete"))
+	retain, ok = GetPVCReclaimPolicy(pvc)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(retain).To(BeFalse())
+
+	// an unknown value is not taken as a policy
+	pvc.Annotations[label.AnnPVCReclaimKey] = "recycle"
+	_, ok = GetPVCReclaimPolicy(pvc)
+	g.Expect(ok).To(BeFalse())
+}
+
 func TestIsSubMapOf(t *testing.T) {
 	g := NewGomegaWithT(t)
 
@@ -0,0 +1,395 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build envtest
+
+// Package envtest runs the tikv cluster controller against a real
+// kube-apiserver and etcd started by controller-runtime's envtest, with the
+// CRDs in manifests installed. PD is faked, and there is neither a kubelet nor
+// the built-in controllers, so pods are never created and nothing is garbage
+// collected unless CollectGarbage is called.
+package envtest
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/client/clientset/versioned"
+	informers "github.com/tikv/tikv-operator/pkg/client/informers/externalversions"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/controller/tikvcluster"
+	"github.com/tikv/tikv-operator/pkg/pdapi"
+	"github.com/tikv/tikv-operator/pkg/registry"
+	"github.com/tikv/tikv-operator/pkg/scheme"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlenvtest "sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+const (
+	pollInterval = 100 * time.Millisecond
+	pollTimeout  = 30 * time.Second
+
+	// WebhookNamespaceLabel is the label of the namespaces in which the tikv clusters are
+	// validated by the webhook enabled by EnableValidatingWebhook
+	WebhookNamespaceLabel = "tikv.org/validating-webhook"
+)
+
+// Framework holds a test control plane and a tikv cluster controller running
+// against it
+type Framework struct {
+	KubeCli    kubernetes.Interface
+	Cli        versioned.Interface
+	GenericCli client.Client
+	PDControl  *pdapi.FakePDControl
+
+	env           *ctrlenvtest.Environment
+	stopCh        chan struct{}
+	webhookServer *httptest.Server
+}
+
+// Start starts the control plane, installs the CRDs and runs the controller
+func (f *Framework) Start() error {
+	f.env = &ctrlenvtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "manifests")},
+		ErrorIfCRDPathMissing: true,
+	}
+	cfg, err := f.env.Start()
+	if err != nil {
+		return fmt.Errorf("failed to start the test control plane: %v", err)
+	}
+
+	if f.KubeCli, err = kubernetes.NewForConfig(cfg); err != nil {
+		return err
+	}
+	if f.Cli, err = versioned.NewForConfig(cfg); err != nil {
+		return err
+	}
+	if f.GenericCli, err = client.New(cfg, client.Options{Scheme: scheme.Scheme}); err != nil {
+		return err
+	}
+	f.PDControl = pdapi.NewFakePDControl(f.KubeCli)
+
+	informerFactory := informers.NewSharedInformerFactory(f.Cli, controller.ResyncDuration)
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(f.KubeCli, controller.ResyncDuration)
+	syncStatus := controller.NewInformerSyncStatus()
+	tcc := tikvcluster.NewController(f.KubeCli, f.Cli, f.GenericCli, f.PDControl, informerFactory, kubeInformerFactory,
+		true, 5*time.Minute, 5*time.Minute, syncStatus)
+
+	f.stopCh = make(chan struct{})
+	informerFactory.Start(f.stopCh)
+	kubeInformerFactory.Start(f.stopCh)
+	if !syncStatus.WaitForCacheSync(f.stopCh, 0, controller.RequiredInformers...) {
+		return fmt.Errorf("error syncing informers %v", syncStatus.NotSynced())
+	}
+	go tcc.Run(2, 1, f.stopCh)
+	return nil
+}
+
+// Stop stops the controller and the control plane
+func (f *Framework) Stop() error {
+	if f.stopCh != nil {
+		close(f.stopCh)
+	}
+	if f.webhookServer != nil {
+		f.webhookServer.Close()
+	}
+	if f.env == nil {
+		return nil
+	}
+	return f.env.Stop()
+}
+
+// CreateNamespace creates a namespace of the given name
+func (f *Framework) CreateNamespace(name string) error {
+	_, err := f.KubeCli.CoreV1().Namespaces().Create(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+	})
+	return err
+}
+
+// CreateNamespaceWithWebhook creates a namespace of the given name in which the tikv clusters
+// are validated by the webhook, which is enabled first if it is not yet
+func (f *Framework) CreateNamespaceWithWebhook(name string) error {
+	if err := f.EnableValidatingWebhook(); err != nil {
+		return err
+	}
+	_, err := f.KubeCli.CoreV1().Namespaces().Create(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{WebhookNamespaceLabel: "true"},
+		},
+	})
+	return err
+}
+
+// EnableValidatingWebhook serves the validation of the registry strategies as a validating
+// admission webhook of the tikv clusters in the namespaces labeled with WebhookNamespaceLabel,
+// as the webhook of a deployment would
+func (f *Framework) EnableValidatingWebhook() error {
+	if f.webhookServer != nil {
+		return nil
+	}
+	f.webhookServer = httptest.NewTLSServer(http.HandlerFunc(serveTikvClusterValidation))
+	url := f.webhookServer.URL + "/tikvclusters"
+	failurePolicy := admissionregistrationv1beta1.Fail
+	sideEffects := admissionregistrationv1beta1.SideEffectClassNone
+	_, err := f.KubeCli.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().Create(&admissionregistrationv1beta1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "tikv-operator-envtest"},
+		Webhooks: []admissionregistrationv1beta1.ValidatingWebhook{
+			{
+				Name: "tikvclusters.tikv.org",
+				ClientConfig: admissionregistrationv1beta1.WebhookClientConfig{
+					URL:      &url,
+					CABundle: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.webhookServer.Certificate().Raw}),
+				},
+				Rules: []admissionregistrationv1beta1.RuleWithOperations{
+					{
+						Operations: []admissionregistrationv1beta1.OperationType{
+							admissionregistrationv1beta1.Create,
+							admissionregistrationv1beta1.Update,
+						},
+						Rule: admissionregistrationv1beta1.Rule{
+							APIGroups:   []string{v1alpha1.SchemeGroupVersion.Group},
+							APIVersions: []string{v1alpha1.SchemeGroupVersion.Version},
+							Resources:   []string{"tikvclusters"},
+						},
+					},
+				},
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{WebhookNamespaceLabel: "true"},
+				},
+				FailurePolicy: &failurePolicy,
+				SideEffects:   &sideEffects,
+			},
+		},
+	})
+	return err
+}
+
+// serveTikvClusterValidation reviews the admission of a tikv cluster with TikvClusterStrategy
+func serveTikvClusterValidation(w http.ResponseWriter, r *http.Request) {
+	review := &admissionv1beta1.AdmissionReview{}
+	if err := json.NewDecoder(r.Body).Decode(review); err != nil || review.Request == nil {
+		http.Error(w, fmt.Sprintf("invalid admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+	review.Response = validateTikvCluster(review.Request)
+	review.Response.UID = review.Request.UID
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+}
+
+func validateTikvCluster(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	strategy := registry.TikvClusterStrategy{}
+	obj := strategy.NewObject()
+	if err := json.Unmarshal(req.Object.Raw, obj); err != nil {
+		return deniedResponse(err)
+	}
+	var errs field.ErrorList
+	if req.Operation == admissionv1beta1.Update {
+		old := strategy.NewObject()
+		if err := json.Unmarshal(req.OldObject.Raw, old); err != nil {
+			return deniedResponse(err)
+		}
+		errs = strategy.ValidateUpdate(context.TODO(), obj, old)
+	} else {
+		errs = strategy.Validate(context.TODO(), obj)
+	}
+	if len(errs) > 0 {
+		return deniedResponse(errs.ToAggregate())
+	}
+	return &admissionv1beta1.AdmissionResponse{Allowed: true}
+}
+
+func deniedResponse(err error) *admissionv1beta1.AdmissionResponse {
+	return &admissionv1beta1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+			Message: err.Error(),
+		},
+	}
+}
+
+// CollectGarbage does for the foreground deletion of the tikv cluster what the garbage
+// collector, which is not running, does: the objects blocking the deletion of the cluster
+// are deleted first, then the foregroundDeletion finalizer of the cluster is removed
+func (f *Framework) CollectGarbage(tc *v1alpha1.TikvCluster) error {
+	ns := tc.GetNamespace()
+	propagation := metav1.DeletePropagationBackground
+	deleteOptions := &metav1.DeleteOptions{PropagationPolicy: &propagation}
+	blocking := func(obj metav1.Object) bool {
+		ref := metav1.GetControllerOf(obj)
+		return ref != nil && ref.UID == tc.GetUID() && ref.BlockOwnerDeletion != nil && *ref.BlockOwnerDeletion
+	}
+	ignoreNotFound := func(err error) error {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	sets, err := f.KubeCli.AppsV1().StatefulSets(ns).List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range sets.Items {
+		if blocking(&sets.Items[i]) {
+			if err := f.KubeCli.AppsV1().StatefulSets(ns).Delete(sets.Items[i].Name, deleteOptions); ignoreNotFound(err) != nil {
+				return err
+			}
+		}
+	}
+	deploys, err := f.KubeCli.AppsV1().Deployments(ns).List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range deploys.Items {
+		if blocking(&deploys.Items[i]) {
+			if err := f.KubeCli.AppsV1().Deployments(ns).Delete(deploys.Items[i].Name, deleteOptions); ignoreNotFound(err) != nil {
+				return err
+			}
+		}
+	}
+	svcs, err := f.KubeCli.CoreV1().Services(ns).List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range svcs.Items {
+		if blocking(&svcs.Items[i]) {
+			if err := f.KubeCli.CoreV1().Services(ns).Delete(svcs.Items[i].Name, deleteOptions); ignoreNotFound(err) != nil {
+				return err
+			}
+		}
+	}
+	cms, err := f.KubeCli.CoreV1().ConfigMaps(ns).List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range cms.Items {
+		if blocking(&cms.Items[i]) {
+			if err := f.KubeCli.CoreV1().ConfigMaps(ns).Delete(cms.Items[i].Name, deleteOptions); ignoreNotFound(err) != nil {
+				return err
+			}
+		}
+	}
+
+	return Poll(func() (bool, error) {
+		cur, err := f.Cli.TikvV1alpha1().TikvClusters(ns).Get(tc.GetName(), metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		var finalizers []string
+		for _, finalizer := range cur.Finalizers {
+			if finalizer != metav1.FinalizerDeleteDependents {
+				finalizers = append(finalizers, finalizer)
+			}
+		}
+		cur.Finalizers = finalizers
+		_, err = f.Cli.TikvV1alpha1().TikvClusters(ns).Update(cur)
+		if errors.IsConflict(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+}
+
+// FakeHealthyPD makes the pd of the tikv cluster report a healthy member and no stores, it must be
+// called before the cluster is created
+func (f *Framework) FakeHealthyPD(tc *v1alpha1.TikvCluster) *pdapi.FakePDClient {
+	pdClient := pdapi.NewFakePDClient()
+	member := &pdpb.Member{Name: fmt.Sprintf("%s-pd-0", tc.GetName()), MemberId: 1}
+	pdClient.AddReaction(pdapi.GetHealthActionType, func(action *pdapi.Action) (interface{}, error) {
+		return &pdapi.HealthInfo{Healths: []pdapi.MemberHealth{
+			{Name: member.Name, MemberID: member.MemberId, Health: true},
+		}}, nil
+	})
+	pdClient.AddReaction(pdapi.GetMembersActionType, func(action *pdapi.Action) (interface{}, error) {
+		return &pdapi.MembersInfo{Members: []*pdpb.Member{member}, Leader: member, EtcdLeader: member}, nil
+	})
+	pdClient.AddReaction(pdapi.GetPDLeaderActionType, func(action *pdapi.Action) (interface{}, error) {
+		return member, nil
+	})
+	pdClient.AddReaction(pdapi.GetClusterActionType, func(action *pdapi.Action) (interface{}, error) {
+		return &metapb.Cluster{Id: 1}, nil
+	})
+	pdClient.AddReaction(pdapi.GetStoresActionType, func(action *pdapi.Action) (interface{}, error) {
+		return &pdapi.StoresInfo{}, nil
+	})
+	pdClient.AddReaction(pdapi.GetTombStoneStoresActionType, func(action *pdapi.Action) (interface{}, error) {
+		return &pdapi.StoresInfo{}, nil
+	})
+	pdClient.AddReaction(pdapi.GetConfigActionType, func(action *pdapi.Action) (interface{}, error) {
+		return &pdapi.PDConfigFromAPI{}, nil
+	})
+	f.PDControl.SetPDClient(pdapi.Namespace(tc.GetNamespace()), tc.GetName(), pdClient)
+	return pdClient
+}
+
+// Poll polls the condition until it is satisfied or the timeout is reached
+func Poll(condition wait.ConditionFunc) error {
+	return wait.PollImmediate(pollInterval, pollTimeout, condition)
+}
+
+// NewTikvCluster returns a minimal valid tikv cluster
+func NewTikvCluster(ns, name string) *v1alpha1.TikvCluster {
+	requests := corev1.ResourceList{
+		corev1.ResourceStorage: resource.MustParse("1Gi"),
+	}
+	return &v1alpha1.TikvCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: ns,
+			Name:      name,
+		},
+		Spec: v1alpha1.TikvClusterSpec{
+			PD: v1alpha1.PDSpec{
+				ComponentSpec: v1alpha1.ComponentSpec{
+					Image: "pingcap/pd:v4.0.0",
+				},
+				ResourceRequirements: corev1.ResourceRequirements{Requests: requests},
+				Replicas:             1,
+			},
+			TiKV: v1alpha1.TiKVSpec{
+				ComponentSpec: v1alpha1.ComponentSpec{
+					Image: "pingcap/tikv:v4.0.0",
+				},
+				ResourceRequirements: corev1.ResourceRequirements{Requests: requests},
+				Replicas:             1,
+			},
+		},
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2020 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build envtest
+
+package envtest
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/tikv/tikv-operator/pkg/apis/tikv/v1alpha1"
+	"github.com/tikv/tikv-operator/pkg/controller"
+	"github.com/tikv/tikv-operator/pkg/label"
+	apps "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+var framework = &Framework{}
+
+func TestMain(m *testing.M) {
+	if err := framework.Start(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		framework.Stop()
+		os.Exit(1)
+	}
+	code := m.Run()
+	if err := framework.Stop(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(code)
+}
+
+// createTikvCluster creates the tikv cluster in a namespace of its own, with a healthy pd
+func createTikvCluster(g *GomegaWithT, ns string, tc *v1alpha1.TikvCluster) *v1alpha1.TikvCluster {
+	g.Expect(framework.CreateNamespace(ns)).To(Succeed())
+	framework.FakeHealthyPD(tc)
+	tc, err := framework.Cli.TikvV1alpha1().TikvClusters(ns).Create(tc)
+	g.Expect(err).NotTo(HaveOccurred())
+	return tc
+}
+
+func waitForStatefulSet(g *GomegaWithT, ns, name string, condition func(*apps.StatefulSet) bool) *apps.StatefulSet {
+	var set *apps.StatefulSet
+	err := Poll(func() (bool, error) {
+		var err error
+		set, err = framework.KubeCli.AppsV1().StatefulSets(ns).Get(name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return condition(set), nil
+	})
+	g.Expect(err).NotTo(HaveOccurred(), "statefulset %s/%s", ns, name)
+	return set
+}
+
+func TestTikvClusterCreation(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ns := "create"
+	tc := createTikvCluster(g, ns, NewTikvCluster(ns, "basic"))
+
+	set := waitForStatefulSet(g, ns, controller.PDMemberName(tc.Name), func(*apps.StatefulSet) bool { return true })
+	g.Expect(metav1.IsControlledBy(set, tc)).To(BeTrue())
+	g.Expect(*set.Spec.Replicas).To(Equal(int32(1)))
+	g.Expect(set.Spec.Template.Spec.Containers[0].Image).To(Equal("pingcap/pd:v4.0.0"))
+
+	// everything rendered for the cluster is owned by it, which is what the garbage collector
+	// relies on to delete them along with the cluster
+	for _, name := range []string{controller.PDMemberName(tc.Name), controller.PDPeerMemberName(tc.Name)} {
+		svc, err := framework.KubeCli.CoreV1().Services(ns).Get(name, metav1.GetOptions{})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(metav1.IsControlledBy(svc, tc)).To(BeTrue(), "service %s", name)
+	}
+	selector := label.New().Instance(tc.GetInstanceName()).PD().Labels()
+	cms, err := framework.KubeCli.CoreV1().ConfigMaps(ns).List(metav1.ListOptions{
+		LabelSelector: metav1.FormatLabelSelector(&metav1.LabelSelector{MatchLabels: selector}),
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(cms.Items).NotTo(BeEmpty())
+	for i := range cms.Items {
+		g.Expect(metav1.IsControlledBy(&cms.Items[i], tc)).To(BeTrue(), "configmap %s", cms.Items[i].Name)
+	}
+}
+
+func TestTikvClusterSpecChange(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ns := "spec-change"
+	tc := createTikvCluster(g, ns, NewTikvCluster(ns, "basic"))
+	waitForStatefulSet(g, ns, controller.PDMemberName(tc.Name), func(*apps.StatefulSet) bool { return true })
+
+	err := Poll(func() (bool, error) {
+		tc, err := framework.Cli.TikvV1alpha1().TikvClusters(ns).Get(tc.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		tc.Spec.PD.StatefulSetUpdateStrategy = apps.OnDeleteStatefulSetStrategyType
+		_, err = framework.Cli.TikvV1alpha1().TikvClusters(ns).Update(tc)
+		if errors.IsConflict(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	waitForStatefulSet(g, ns, controller.PDMemberName(tc.Name), func(set *apps.StatefulSet) bool {
+		return set.Spec.UpdateStrategy.Type == apps.OnDeleteStatefulSetStrategyType
+	})
+}
+
+// the CRD has no status subresource, so the status is persisted through regular updates
+func TestTikvClusterStatus(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ns := "status"
+	tc := createTikvCluster(g, ns, NewTikvCluster(ns, "basic"))
+
+	err := Poll(func() (bool, error) {
+		tc, err := framework.Cli.TikvV1alpha1().TikvClusters(ns).Get(tc.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return tc.Status.PD.StatefulSet != nil && tc.Status.ClusterID == "1", nil
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+}
+
+func TestTikvClusterInvalidSpec(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ns := "invalid"
+	tc := NewTikvCluster(ns, "basic")
+	tc.Spec.PD.ResourceRequirements.Requests = corev1.ResourceList{}
+	tc = createTikvCluster(g, ns, tc)
+
+	err := Poll(func() (bool, error) {
+		events, err := framework.KubeCli.CoreV1().Events(ns).List(metav1.ListOptions{
+			FieldSelector: fields.Set{
+				"involvedObject.name": tc.Name,
+				"reason":              "FailedValidation",
+			}.AsSelector().String(),
+		})
+		if err != nil {
+			return false, err
+		}
+		return len(events.Items) > 0, nil
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = framework.KubeCli.AppsV1().StatefulSets(ns).Get(controller.PDMemberName(tc.Name), metav1.GetOptions{})
+	g.Expect(errors.IsNotFound(err)).To(BeTrue())
+}
+
+func TestTikvClusterForegroundDeletion(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ns := "deletion"
+	tc := createTikvCluster(g, ns, NewTikvCluster(ns, "basic"))
+	set := waitForStatefulSet(g, ns, controller.PDMemberName(tc.Name), func(*apps.StatefulSet) bool { return true })
+	// the dependents block the deletion of the cluster until the garbage collector deletes them
+	ref := metav1.GetControllerOf(set)
+	g.Expect(ref).NotTo(BeNil())
+	g.Expect(*ref.BlockOwnerDeletion).To(BeTrue())
+
+	propagation := metav1.DeletePropagationForeground
+	err := framework.Cli.TikvV1alpha1().TikvClusters(ns).Delete(tc.Name, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+	g.Expect(err).NotTo(HaveOccurred())
+	tc, err = framework.Cli.TikvV1alpha1().TikvClusters(ns).Get(tc.Name, metav1.GetOptions{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(tc.DeletionTimestamp).NotTo(BeNil())
+	g.Expect(tc.Finalizers).To(ContainElement(metav1.FinalizerDeleteDependents))
+
+	// the members of the cluster being deleted are not synced anymore, so a dependent deleted
+	// before the cluster is not recreated
+	err = framework.KubeCli.AppsV1().StatefulSets(ns).Delete(set.Name, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Consistently(func() bool {
+		_, err := framework.KubeCli.AppsV1().StatefulSets(ns).Get(set.Name, metav1.GetOptions{})
+		return errors.IsNotFound(err)
+	}, 3*time.Second, pollInterval).Should(BeTrue())
+
+	g.Expect(framework.CollectGarbage(tc)).To(Succeed())
+	err = Poll(func() (bool, error) {
+		_, err := framework.Cli.TikvV1alpha1().TikvClusters(ns).Get(tc.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	svcs, err := framework.KubeCli.CoreV1().Services(ns).List(metav1.ListOptions{})
+	g.Expect(err).NotTo(HaveOccurred())
+	for i := range svcs.Items {
+		g.Expect(metav1.IsControlledBy(&svcs.Items[i], tc)).To(BeFalse(), "service %s", svcs.Items[i].Name)
+	}
+}
+
+func TestTikvClusterWebhookValidation(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ns := "webhook"
+	g.Expect(framework.CreateNamespaceWithWebhook(ns)).To(Succeed())
+
+	// the apiserver picks the webhook configuration up asynchronously
+	invalid := NewTikvCluster(ns, "invalid")
+	invalid.Spec.PD.ResourceRequirements.Requests = corev1.ResourceList{}
+	err := Poll(func() (bool, error) {
+		_, err := framework.Cli.TikvV1alpha1().TikvClusters(ns).Create(invalid)
+		if err == nil {
+			return false, framework.Cli.TikvV1alpha1().TikvClusters(ns).Delete(invalid.Name, nil)
+		}
+		return strings.Contains(err.Error(), "denied the request"), nil
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	tc := NewTikvCluster(ns, "basic")
+	framework.FakeHealthyPD(tc)
+	tc, err = framework.Cli.TikvV1alpha1().TikvClusters(ns).Create(tc)
+	g.Expect(err).NotTo(HaveOccurred())
+	// tls between the components can not be toggled on an existing cluster
+	err = Poll(func() (bool, error) {
+		tc, err := framework.Cli.TikvV1alpha1().TikvClusters(ns).Get(tc.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		tc.Spec.TLSCluster = &v1alpha1.TLSCluster{Enabled: true}
+		_, err = framework.Cli.TikvV1alpha1().TikvClusters(ns).Update(tc)
+		if errors.IsConflict(err) {
+			return false, nil
+		}
+		return true, err
+	})
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("spec.tlsCluster.enabled"))
+}